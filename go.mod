@@ -4,6 +4,7 @@ go 1.21
 
 require (
 	github.com/gorilla/websocket v1.5.1
+	github.com/mattn/go-sqlite3 v1.14.22
 	gopkg.in/yaml.v2 v2.4.0
 )
 
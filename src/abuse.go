@@ -0,0 +1,199 @@
+// abuse.go
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type violationKind string
+
+const (
+	violationRateLimited      violationKind = "rate_limited"
+	violationMalformedMessage violationKind = "malformed_message"
+	violationTeamSpoofing     violationKind = "team_spoofing"
+)
+
+// abuseSubject keys the tracker: users once they have authenticated, IPs for
+// violations that happen before a user is known.
+func userSubject(userID string) string { return "user:" + userID }
+func ipSubject(ip string) string       { return "ip:" + ip }
+
+type abuseRecord struct {
+	score       float64
+	updated     time.Time
+	bannedUntil time.Time
+	violations  map[violationKind]int
+}
+
+// abuseTracker keeps a decaying violation score per subject and temp-bans
+// subjects whose score reaches the threshold. Bans are kept in memory, so
+// they survive reconnects but not a restart.
+type abuseTracker struct {
+	mu          sync.Mutex
+	records     map[string]*abuseRecord
+	weights     map[violationKind]float64
+	threshold   float64
+	halfLife    time.Duration
+	banDuration time.Duration
+	nextCleanup time.Time
+	now         func() time.Time
+
+	// onBan runs after a subject is banned, outside the tracker lock.
+	onBan func(subject string, until time.Time, score float64, violations map[violationKind]int)
+}
+
+// abuseGuard is nil unless abuse.enabled is set, and all methods are nil-safe.
+var abuseGuard *abuseTracker
+
+func newAbuseTracker(threshold float64, halfLife, banDuration time.Duration, weights map[violationKind]float64) *abuseTracker {
+	return &abuseTracker{
+		records:     make(map[string]*abuseRecord),
+		weights:     weights,
+		threshold:   threshold,
+		halfLife:    halfLife,
+		banDuration: banDuration,
+		now:         time.Now,
+	}
+}
+
+// record adds a violation to subject's score and reports whether it caused a ban.
+func (t *abuseTracker) record(subject string, kind violationKind) bool {
+	if t == nil || subject == "" {
+		return false
+	}
+
+	now := t.now()
+	appMetrics.Count("abuse.violations", 1, metricTag("kind", string(kind)))
+
+	t.mu.Lock()
+	if !now.Before(t.nextCleanup) {
+		t.cleanupLocked(now)
+		t.nextCleanup = now.Add(t.halfLife)
+	}
+
+	record, ok := t.records[subject]
+	if !ok {
+		record = &abuseRecord{updated: now, violations: make(map[violationKind]int)}
+		t.records[subject] = record
+	}
+	if now.Before(record.bannedUntil) {
+		// Already banned; violations while banned do not extend the ban.
+		t.mu.Unlock()
+		return false
+	}
+
+	record.score = t.decayed(record, now) + t.weights[kind]
+	record.updated = now
+	record.violations[kind]++
+
+	if record.score < t.threshold {
+		t.mu.Unlock()
+		return false
+	}
+
+	until := now.Add(t.banDuration)
+	score := record.score
+	violations := make(map[violationKind]int, len(record.violations))
+	for k, v := range record.violations {
+		violations[k] = v
+	}
+	record.bannedUntil = until
+	record.score = 0
+	record.violations = make(map[violationKind]int)
+	onBan := t.onBan
+	t.mu.Unlock()
+
+	slog.Warn("Temporarily banned", "subject", subject, "until", until, "score", score)
+	appMetrics.Count("abuse.bans", 1)
+	if onBan != nil {
+		onBan(subject, until, score, violations)
+	}
+	return true
+}
+
+// bannedUntil returns when subject's ban ends, or false if it is not banned.
+func (t *abuseTracker) bannedUntil(subject string) (time.Time, bool) {
+	if t == nil {
+		return time.Time{}, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	record, ok := t.records[subject]
+	if !ok || !t.now().Before(record.bannedUntil) {
+		return time.Time{}, false
+	}
+	return record.bannedUntil, true
+}
+
+func (t *abuseTracker) decayed(record *abuseRecord, now time.Time) float64 {
+	elapsed := now.Sub(record.updated)
+	if elapsed <= 0 || t.halfLife <= 0 {
+		return record.score
+	}
+	return record.score * math.Pow(0.5, float64(elapsed)/float64(t.halfLife))
+}
+
+func (t *abuseTracker) cleanupLocked(now time.Time) {
+	for subject, record := range t.records {
+		if now.Before(record.bannedUntil) {
+			continue
+		}
+		if t.decayed(record, now) < 0.01 {
+			delete(t.records, subject)
+		}
+	}
+}
+
+type abuseWebhookPayload struct {
+	Event       string                `json:"event"`
+	Subject     string                `json:"subject"`
+	Score       float64               `json:"score"`
+	BannedUntil time.Time             `json:"bannedUntil"`
+	Violations  map[violationKind]int `json:"violations"`
+}
+
+// banHandler builds the onBan hook: it disconnects a banned user's sessions,
+// writes an audit event and, when url is set, notifies the backend.
+func banHandler(hub *Hub, url string, webhooks *webhookDispatcher) func(string, time.Time, float64, map[violationKind]int) {
+	return func(subject string, until time.Time, score float64, violations map[violationKind]int) {
+		if userID, ok := strings.CutPrefix(subject, "user:"); ok && hub != nil {
+			hub.disconnectUser(userID, "temporarily banned")
+		}
+
+		details := map[string]string{
+			"banned_until": until.Format(time.RFC3339),
+			"score":        strconv.FormatFloat(score, 'f', 1, 64),
+		}
+		for kind, count := range violations {
+			details[string(kind)] = strconv.Itoa(count)
+		}
+		recordAudit(auditEvent{Action: "abuse.temp_ban", Subject: subject, Details: details})
+
+		if url == "" {
+			return
+		}
+		payload, err := json.Marshal(abuseWebhookPayload{
+			Event:       "abuse.temp_ban",
+			Subject:     subject,
+			Score:       score,
+			BannedUntil: until,
+			Violations:  violations,
+		})
+		if err != nil {
+			slog.Error("Failed to encode the abuse webhook", "error", err)
+			return
+		}
+		if !webhooks.enqueue(webhookJob{Kind: "abuse_ban", URL: url, Payload: payload}) {
+			slog.Error("Abuse webhook dropped", "subject", subject)
+			appMetrics.Count("abuse.webhook_failures", 1)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// abuse_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAbuseTracker(now *time.Time) *abuseTracker {
+	tracker := newAbuseTracker(10, time.Minute, 15*time.Minute, map[violationKind]float64{
+		violationRateLimited:      1,
+		violationMalformedMessage: 2,
+		violationTeamSpoofing:     5,
+	})
+	tracker.now = func() time.Time { return *now }
+	return tracker
+}
+
+func TestAbuseTracker_BansAtThreshold(t *testing.T) {
+	setupTestAppConfig()
+	now := time.Now()
+	tracker := newTestAbuseTracker(&now)
+
+	var banned []string
+	tracker.onBan = func(subject string, until time.Time, score float64, violations map[violationKind]int) {
+		banned = append(banned, subject)
+		if violations[violationTeamSpoofing] != 2 {
+			t.Errorf("expected 2 spoofing violations, got %v", violations)
+		}
+	}
+
+	if tracker.record(userSubject("user1"), violationTeamSpoofing) {
+		t.Fatal("did not expect a ban below the threshold")
+	}
+	if !tracker.record(userSubject("user1"), violationTeamSpoofing) {
+		t.Fatal("expected a ban at the threshold")
+	}
+	if len(banned) != 1 || banned[0] != "user:user1" {
+		t.Fatalf("expected onBan for user1, got %v", banned)
+	}
+
+	until, ok := tracker.bannedUntil(userSubject("user1"))
+	if !ok || !until.Equal(now.Add(15*time.Minute)) {
+		t.Fatalf("expected ban until %v, got %v (%v)", now.Add(15*time.Minute), until, ok)
+	}
+	if _, ok := tracker.bannedUntil(userSubject("user2")); ok {
+		t.Fatal("did not expect other users to be banned")
+	}
+
+	now = now.Add(16 * time.Minute)
+	if _, ok := tracker.bannedUntil(userSubject("user1")); ok {
+		t.Fatal("expected the ban to expire")
+	}
+}
+
+func TestAbuseTracker_ScoresDecay(t *testing.T) {
+	setupTestAppConfig()
+	now := time.Now()
+	tracker := newTestAbuseTracker(&now)
+
+	for i := 0; i < 9; i++ {
+		tracker.record(ipSubject("10.0.0.1"), violationRateLimited)
+	}
+	// Two half-lives later the score of 9 has decayed to 2.25.
+	now = now.Add(2 * time.Minute)
+	if tracker.record(ipSubject("10.0.0.1"), violationRateLimited) {
+		t.Fatal("expected decayed score to stay below the threshold")
+	}
+}
+
+func TestAbuseTracker_NilSafe(t *testing.T) {
+	var tracker *abuseTracker
+	if tracker.record("user:x", violationRateLimited) {
+		t.Fatal("nil tracker must not ban")
+	}
+	if _, ok := tracker.bannedUntil("user:x"); ok {
+		t.Fatal("nil tracker must not report bans")
+	}
+}
+
+func TestBanHandler_PostsWebhookAndDisconnects(t *testing.T) {
+	setupTestAppConfig()
+
+	received := make(chan abuseWebhookPayload, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "test-api-key" {
+			t.Errorf("expected API key header, got %q", r.Header.Get("X-API-Key"))
+		}
+		var payload abuseWebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer backend.Close()
+
+	hub := newHub()
+	conn := newMockConn()
+	client := &Client{hub: hub, conn: conn, teamID: "team1", userID: "user1", send: make(chan outboundMessage, 1)}
+	hub.clients["team1"] = map[string]map[*Client]struct{}{"user1": {client: {}}}
+
+	logs := captureJSONLogs(t)
+	webhooks := newWebhookDispatcher(backend.Client(), 4, 1, time.Second, time.Second)
+	stop := make(chan struct{})
+	defer close(stop)
+	go webhooks.run(1, stop)
+	onBan := banHandler(hub, backend.URL, webhooks)
+	onBan("user:user1", time.Now().Add(time.Minute), 10, map[violationKind]int{violationTeamSpoofing: 2})
+
+	select {
+	case payload := <-received:
+		if payload.Event != "abuse.temp_ban" || payload.Subject != "user:user1" || payload.Violations[violationTeamSpoofing] != 2 {
+			t.Fatalf("unexpected webhook payload: %+v", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the ban webhook to be posted")
+	}
+
+	conn.mu.Lock()
+	closed := conn.isClosed
+	conn.mu.Unlock()
+	if !closed {
+		t.Fatal("expected the banned user's connection to be closed")
+	}
+	if !strings.Contains(logs.String(), `"action":"abuse.temp_ban"`) {
+		t.Fatalf("expected an audit log line, got %s", logs.String())
+	}
+}
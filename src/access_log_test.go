@@ -0,0 +1,93 @@
+// access_log_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Logging.AccessLog = true
+	AppConfig().Logging.AccessLogSampleRate = 1
+	authFailures = nil
+
+	handler := accessLogMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		requestID  string
+		wantStatus int
+		wantCaller string
+		wantBytes  int64
+	}{
+		{"operator key", "test-api-key", "req-123", http.StatusCreated, "operator", 5},
+		{"bad key", "wrong", "", http.StatusUnauthorized, "", int64(len("Invalid API key\n"))},
+		{"unsafe request id", "test-api-key", "has space", http.StatusCreated, "operator", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureJSONLogs(t)
+			req := httptest.NewRequest(http.MethodGet, "/admin/stats?token=secret", nil)
+			req.Header.Set("X-API-Key", tt.apiKey)
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-ID", tt.requestID)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			requestID := rr.Header().Get("X-Request-ID")
+			if tt.requestID == "req-123" && requestID != "req-123" {
+				t.Fatalf("expected the caller's request ID to be kept, got %q", requestID)
+			}
+			if !validRequestID(requestID) || requestID == "has space" {
+				t.Fatalf("expected a safe request ID, got %q", requestID)
+			}
+
+			if strings.Contains(logs.String(), "secret") {
+				t.Fatalf("access log leaked the query string: %s", logs.String())
+			}
+			lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+			line := lines[len(lines)-1]
+			var entry accessLogEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("expected a JSON access log line, got %q: %v", line, err)
+			}
+			if entry.Status != tt.wantStatus || entry.Caller != tt.wantCaller || entry.Bytes != tt.wantBytes ||
+				entry.RequestID != requestID || entry.Path != "/admin/stats" || entry.Method != http.MethodGet {
+				t.Fatalf("unexpected access log entry %+v", entry)
+			}
+		})
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Logging.AccessLog = true
+	AppConfig().Logging.AccessLogSampleRate = 0.0000001
+
+	status := http.StatusOK
+	handler := accessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+
+	logs := captureLogs(t)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if logs.Len() != 0 {
+		t.Fatalf("expected successful requests to be sampled out, got %s", logs.String())
+	}
+
+	status = http.StatusInternalServerError
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if !strings.Contains(logs.String(), "status=500") {
+		t.Fatalf("expected errors to be logged regardless of sampling, got %s", logs.String())
+	}
+}
@@ -0,0 +1,146 @@
+// admin.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type adminStatsResponse struct {
+	TotalTeams      int           `json:"total_teams"`
+	TotalClients    int           `json:"total_clients"`
+	Teams           []TeamStats   `json:"teams"`
+	DeliveryLatency latencyReport `json:"delivery_latency"`
+
+	// AwaitingReconnect counts users from a restored snapshot that have not
+	// reconnected yet.
+	AwaitingReconnect int `json:"awaiting_reconnect,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to encode the response", "error", err)
+	}
+}
+
+// writeJSONWithETag writes v as a 200 response tagged with a hash of its
+// encoding, and answers 304 Not Modified when If-None-Match already names
+// that tag, so pollers only download reads that changed.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		slog.Error("Failed to encode the response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		slog.Error("Failed to write the response", "error", err)
+	}
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for: W/
+// prefixes are ignored and "*" matches any current representation.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeJSONBody strictly decodes a single JSON object from an admin request
+// body, bounded by websocket.max_message_size.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, AppConfig().WebSocket.MaxMessageSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("request body is required")
+		}
+		return err
+	}
+	var extra struct{}
+	if err := decoder.Decode(&extra); !errors.Is(err, io.EOF) {
+		return errors.New("request body must contain a single JSON object")
+	}
+	return nil
+}
+
+// readLimitedBody reads an admin request body, bounded by websocket.max_message_size.
+func readLimitedBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, AppConfig().WebSocket.MaxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("request body is required")
+	}
+	return data, nil
+}
+
+// handleAdminStats reports hub counts and end-to-end delivery latency
+// percentiles for SLO validation.
+func handleAdminStats(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, buildAdminStats(hub))
+}
+
+func buildAdminStats(hub *Hub) adminStatsResponse {
+	health := hub.healthCheck()
+	return adminStatsResponse{
+		TotalTeams:      health.TotalTeams,
+		TotalClients:    health.TotalClients,
+		Teams:           hub.teamStats(),
+		DeliveryLatency: deliveryLatency.Report(),
+
+		AwaitingReconnect: hub.awaitingReconnect(time.Now()),
+	}
+}
+
+// handleAdminAudit returns the most recent audit events, newest first.
+func handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := maxRecentAuditEvents
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	writeJSONWithETag(w, r, map[string]interface{}{"events": recentAudit.list(limit)})
+}
@@ -0,0 +1,171 @@
+// admin_ui.go
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// adminFeedInterval is how often the dashboard's live feed pushes hub stats.
+const adminFeedInterval = 2 * time.Second
+
+//go:embed admin_ui/index.html
+var adminUIPage []byte
+
+// adminFeedAuth is the first frame a dashboard sends on /admin/ui/feed.
+// Browsers cannot set headers on websocket requests, so the API key is sent
+// in-band instead of as X-API-Key.
+type adminFeedAuth struct {
+	Type   string `json:"type"`
+	APIKey string `json:"apiKey"`
+}
+
+// handleAdminUI serves the embedded dashboard. The page itself holds no data;
+// it asks for the API key and uses it for every admin call.
+func handleAdminUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path != "/admin/ui" && r.URL.Path != "/admin/ui/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self' ws: wss:")
+	_, _ = w.Write(adminUIPage)
+}
+
+// sameOriginOrAllowed accepts websocket upgrades from the page this server
+// served itself, or from a configured allowed origin.
+func sameOriginOrAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if parsed, err := url.Parse(origin); err == nil && parsed.Host == r.Host {
+		return true
+	}
+	return IsOriginAllowed(origin)
+}
+
+// acceptAdminSocket upgrades an admin websocket and checks the API key in its
+// first frame, which is returned for endpoint-specific fields. On failure the
+// socket is already closed.
+func acceptAdminSocket(w http.ResponseWriter, r *http.Request, scope string) (*websocket.Conn, []byte, bool) {
+	clientKey := ipFailureKey(clientIPFromRequest(r))
+	if until, locked := authFailures.lockedUntil(clientKey); locked {
+		w.Header().Set("Retry-After", retryAfterSeconds(until))
+		http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+		return nil, nil, false
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  AppConfig().WebSocket.BufferSize.Read,
+		WriteBufferSize: AppConfig().WebSocket.BufferSize.Write,
+		CheckOrigin:     sameOriginOrAllowed,
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("Failed to upgrade the connection", "scope", scope, "error", err)
+		return nil, nil, false
+	}
+
+	conn.SetReadLimit(AppConfig().WebSocket.AuthMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(AppConfig().WebSocket.ReadDeadline))
+
+	var auth adminFeedAuth
+	_, frame, err := conn.ReadMessage()
+	if err == nil {
+		err = json.Unmarshal(frame, &auth)
+	}
+	if err != nil {
+		slog.Error("Failed to read the auth frame", "scope", scope, "error", err)
+		conn.Close()
+		return nil, nil, false
+	}
+	if auth.Type != "auth" || subtle.ConstantTimeCompare([]byte(auth.APIKey), []byte(AppConfig().Security.APIKey)) != 1 {
+		slog.Warn("Invalid API key", "scope", scope, "remote_addr", r.RemoteAddr)
+		appMetrics.Count("auth.api_key_failures", 1)
+		authFailures.recordFailure(clientKey, scope)
+		writeWebSocketAuthError(conn, protocolJSONv1, "Invalid API key")
+		conn.Close()
+		return nil, nil, false
+	}
+	authFailures.recordSuccess(clientKey)
+
+	// As for a client's read pump, a connection that answers no ping within
+	// PongWait times out, so a half-open browser tab is noticed. The caller
+	// pings every PingPeriod.
+	_ = conn.SetReadDeadline(time.Now().Add(AppConfig().WebSocket.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(AppConfig().WebSocket.PongWait))
+	})
+	return conn, frame, true
+}
+
+// handleAdminUIFeed streams buildAdminStats snapshots to the dashboard every
+// adminFeedInterval after the client authenticates with the API key.
+func handleAdminUIFeed(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	conn, _, ok := acceptAdminSocket(w, r, "admin_feed")
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	// The dashboard never sends anything after auth; reading only handles
+	// pongs and notices when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	writeStats := func() error {
+		_ = conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+		return conn.WriteJSON(map[string]interface{}{
+			"type":  "stats",
+			"time":  time.Now().UTC(),
+			"stats": buildAdminStats(hub),
+		})
+	}
+	if err := writeStats(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(adminFeedInterval)
+	defer ticker.Stop()
+	pings := time.NewTicker(AppConfig().WebSocket.PingPeriod)
+	defer pings.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := writeStats(); err != nil {
+				return
+			}
+		case <-pings.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// admin_ui_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandleAdminUI(t *testing.T) {
+	setupTestAppConfig()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"page", http.MethodGet, "/admin/ui", http.StatusOK},
+		{"trailing slash", http.MethodGet, "/admin/ui/", http.StatusOK},
+		{"unknown asset", http.MethodGet, "/admin/ui/app.js", http.StatusNotFound},
+		{"wrong method", http.MethodPost, "/admin/ui", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handleAdminUI(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			if rr.Code != tt.want {
+				t.Fatalf("expected status %d, got %d", tt.want, rr.Code)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(rr.Body.String(), "/admin/ui/feed") {
+				t.Fatalf("expected the dashboard page, got %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleAdminUIFeed(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Security.APIKey = "admin-secret"
+	deliveryLatency = newLatencyRecorder()
+
+	hub := newHub()
+	client := &Client{hub: hub, teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 4)}
+	client.send <- outboundMessage{payload: []byte(`{}`)}
+	hub.clients["team-a"] = map[string]map[*Client]struct{}{"user-1": {client: {}}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminUIFeed(hub, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	tests := []struct {
+		name     string
+		apiKey   string
+		wantType string
+	}{
+		{"valid key", "admin-secret", "stats"},
+		{"invalid key", "wrong", "auth_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authFailures = nil
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+			if err != nil {
+				t.Fatalf("dial failed: %v", err)
+			}
+			defer conn.Close()
+
+			if err := conn.WriteJSON(adminFeedAuth{Type: "auth", APIKey: tt.apiKey}); err != nil {
+				t.Fatalf("failed to send auth: %v", err)
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+			var frame struct {
+				Type  string             `json:"type"`
+				Stats adminStatsResponse `json:"stats"`
+			}
+			if err := conn.ReadJSON(&frame); err != nil {
+				t.Fatalf("failed to read frame: %v", err)
+			}
+			if frame.Type != tt.wantType {
+				t.Fatalf("expected %s frame, got %s", tt.wantType, frame.Type)
+			}
+			if tt.wantType != "stats" {
+				return
+			}
+			if frame.Stats.TotalClients != 1 || len(frame.Stats.Teams) != 1 {
+				t.Fatalf("unexpected stats: %+v", frame.Stats)
+			}
+			if team := frame.Stats.Teams[0]; team.TeamID != "team-a" || team.QueueDepth != 1 {
+				t.Fatalf("unexpected team stats: %+v", team)
+			}
+		})
+	}
+}
+
+func TestHandleAdminUIFeed_DropsUnresponsiveClient(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Security.APIKey = "admin-secret"
+	AppConfig().WebSocket.PongWait = 200 * time.Millisecond
+	AppConfig().WebSocket.PingPeriod = 50 * time.Millisecond
+	authFailures = nil
+	deliveryLatency = newLatencyRecorder()
+
+	hub := newHub()
+	finished := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminUIFeed(hub, w, r)
+		finished <- struct{}{}
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	connect := func() *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		if err := conn.WriteJSON(adminFeedAuth{Type: "auth", APIKey: "admin-secret"}); err != nil {
+			t.Fatalf("failed to send auth: %v", err)
+		}
+		return conn
+	}
+
+	// A tab that keeps reading answers the pings and stays subscribed.
+	live := connect()
+	defer live.Close()
+	go func() {
+		for {
+			if _, _, err := live.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// A half-open tab never reads, so no pong comes back.
+	stalled := connect()
+	defer stalled.Close()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the unresponsive feed to be dropped after PongWait")
+	}
+	select {
+	case <-finished:
+		t.Fatal("expected the responsive feed to stay connected")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	live.Close()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the feed to end when the tab closes")
+	}
+}
+
+func TestAuditRing(t *testing.T) {
+	ring := newAuditRing(3)
+	for _, action := range []string{"a", "b", "c", "d"} {
+		ring.add(auditEvent{Action: action})
+	}
+
+	tests := []struct {
+		limit int
+		want  string
+	}{
+		{1, "d"},
+		{3, "d,c,b"},
+		{10, "d,c,b"},
+	}
+	for _, tt := range tests {
+		var actions []string
+		for _, event := range ring.list(tt.limit) {
+			actions = append(actions, event.Action)
+		}
+		if got := strings.Join(actions, ","); got != tt.want {
+			t.Fatalf("list(%d) = %s, want %s", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestHandleAdminAudit(t *testing.T) {
+	setupTestAppConfig()
+	recentAudit = newAuditRing(maxRecentAuditEvents)
+	recordAudit(auditEvent{Action: "test.first", Subject: "user:1"})
+	recordAudit(auditEvent{Action: "test.second", Subject: "user:2"})
+
+	rr := httptest.NewRecorder()
+	handleAdminAudit(rr, httptest.NewRequest(http.MethodGet, "/admin/audit?limit=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var response struct {
+		Events []auditEvent `json:"events"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(response.Events) != 1 || response.Events[0].Action != "test.second" {
+		t.Fatalf("expected only the newest event, got %+v", response.Events)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminAudit(rr, httptest.NewRequest(http.MethodGet, "/admin/audit?limit=0", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid limit, got %d", rr.Code)
+	}
+}
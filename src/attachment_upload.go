@@ -0,0 +1,218 @@
+// attachment_upload.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxUploadNameLength bounds the file name kept in an upload's storage key.
+const maxUploadNameLength = 200
+
+// attachmentUploads is nil unless attachments.max_upload_size is set.
+var attachmentUploads *attachmentUploader
+
+// attachmentUploader stores small files that chat clients upload in the
+// attachments bucket, under a key that /send accepts as an attachment.
+type attachmentUploader struct {
+	presigner *storagePresigner
+	client    *http.Client
+	maxSize   int64
+	prefix    string
+}
+
+func newAttachmentUploader(presigner *storagePresigner, client *http.Client, maxSize int64, prefix string) *attachmentUploader {
+	return &attachmentUploader{presigner: presigner, client: client, maxSize: maxSize, prefix: prefix}
+}
+
+// uploadedAttachment is the response to an upload. Its name, mime, size and
+// storage_key can be passed as an attachment to /send unchanged; url is a
+// pre-signed link for previewing the file straight away.
+type uploadedAttachment struct {
+	AttachmentRequest
+	URL          string    `json:"url"`
+	URLExpiresAt time.Time `json:"urlExpiresAt"`
+}
+
+// uploadName reduces a client-supplied file name to its last path element
+// without control characters, so it is safe as the end of a storage key.
+func uploadName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "." || name == ".." {
+		return ""
+	}
+	for len(name) > maxUploadNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// authenticateUpload signs in the uploader the way a WebSocket handshake
+// would: with the bearer token for ?teamId= and, for a tenant's team,
+// ?tenantId=. Failures count towards the same lockouts.
+func authenticateUpload(r *http.Request) (*Client, *authRejection) {
+	query := r.URL.Query()
+	authMsg := AuthMessage{
+		Type:     "auth",
+		TenantID: query.Get("tenantId"),
+		TeamID:   query.Get("teamId"),
+		UserID:   query.Get("userId"),
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		authMsg.Token = token
+	}
+	authMsg.Normalize()
+
+	tenant, err := findTenant(authMsg.TenantID)
+	if err != nil {
+		return nil, &authRejection{http.StatusNotFound, err.Error()}
+	}
+	if authMsg.TeamID == statsTeamID {
+		return nil, &authRejection{http.StatusBadRequest, "teamId " + statsTeamID + " is reserved"}
+	}
+
+	tokenKey := tokenFailureKey(authMsg.Token)
+	if _, locked := authFailures.lockedUntil(tokenKey); locked {
+		return nil, &authRejection{http.StatusTooManyRequests, "Too many failed authentication attempts"}
+	}
+
+	client := &Client{}
+	if err := client.authenticate(authMsg); err != nil {
+		appMetrics.Count("auth.failures", 1)
+		if !isCredentialFailure(err) {
+			return nil, &authRejection{http.StatusServiceUnavailable, err.Error()}
+		}
+		authFailures.recordFailure(ipFailureKey(clientIPFromRequest(r)), "attachments")
+		authFailures.recordFailure(tokenKey, "attachments")
+		var mismatch *teamMismatchError
+		if errors.As(err, &mismatch) {
+			abuseGuard.record(userSubject(mismatch.userID), violationTeamSpoofing)
+			return nil, &authRejection{http.StatusForbidden, err.Error()}
+		}
+		return nil, &authRejection{http.StatusUnauthorized, err.Error()}
+	}
+	authFailures.recordSuccess(tokenKey)
+
+	if _, banned := abuseGuard.bannedUntil(userSubject(client.userID)); banned {
+		return nil, &authRejection{http.StatusForbidden, "Temporarily banned"}
+	}
+	scopedTeam, err := scopeTeam(tenantIDOf(tenant), client.teamID)
+	if err != nil {
+		return nil, &authRejection{http.StatusBadRequest, err.Error()}
+	}
+	client.tenantID = tenantIDOf(tenant)
+	client.teamID = scopedTeam
+	return client, nil
+}
+
+// handleAttachmentUpload handles POST /attachments: a multipart/form-data
+// body whose "file" part is stored under the uploader's team.
+func handleAttachmentUpload(uploader *attachmentUploader, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if uploader == nil {
+		http.Error(w, "Attachment uploads are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	client, rejection := authenticateUpload(r)
+	if rejection != nil {
+		slog.Warn("Rejecting attachment upload", "remote_ip", clientIPFromRequest(r), "reason", rejection.message)
+		http.Error(w, rejection.message, rejection.status)
+		return
+	}
+
+	// Allow for the multipart framing around the file.
+	r.Body = http.MaxBytesReader(w, r.Body, uploader.maxSize+64<<10)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+	tooLarge := fmt.Sprintf("Files must be at most %d bytes", uploader.maxSize)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			var maxBytes *http.MaxBytesError
+			if errors.As(err, &maxBytes) {
+				http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, `The body has no "file" part`, http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		name := uploadName(part.FileName())
+		if name == "" {
+			http.Error(w, "The file needs a name", http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(part, uploader.maxSize+1))
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) || int64(len(data)) > uploader.maxSize {
+			http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to read the file", http.StatusBadRequest)
+			return
+		}
+		uploader.store(w, r, client, name, part.Header.Get("Content-Type"), data)
+		return
+	}
+}
+
+func (u *attachmentUploader) store(w http.ResponseWriter, r *http.Request, client *Client, name, mime string, data []byte) {
+	if mime == "" || mime == "application/octet-stream" {
+		mime = http.DetectContentType(data)
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, "Failed to store the file", http.StatusInternalServerError)
+		return
+	}
+	key := u.prefix + client.teamID + "/" + hex.EncodeToString(id) + "/" + name
+	if len(key) > maxStorageKeyLength {
+		http.Error(w, "The file name is too long", http.StatusBadRequest)
+		return
+	}
+
+	if err := u.presigner.put(r.Context(), u.client, key, mime, data); err != nil {
+		appMetrics.Count("attachments.upload_failed", 1, tenantTags(client.tenantID)...)
+		client.logger().Error("Failed to store an uploaded attachment", "key", key, "error", err)
+		http.Error(w, "Failed to store the file", http.StatusBadGateway)
+		return
+	}
+	appMetrics.Count("attachments.uploaded", 1, tenantTags(client.tenantID)...)
+	appMetrics.Count("attachments.uploaded_bytes", int64(len(data)), tenantTags(client.tenantID)...)
+	client.logger().Info("Stored an uploaded attachment", "key", key, "size", len(data))
+
+	now := time.Now()
+	writeJSON(w, http.StatusCreated, uploadedAttachment{
+		AttachmentRequest: AttachmentRequest{Name: name, Mime: mime, Size: int64(len(data)), StorageKey: key},
+		URL:               u.presigner.presign(key, now),
+		URLExpiresAt:      now.Add(u.presigner.ttl).UTC(),
+	})
+}
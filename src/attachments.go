@@ -0,0 +1,219 @@
+// attachments.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStorageKeyLength is the longest object key S3 and GCS accept.
+const maxStorageKeyLength = 1024
+
+// attachmentPresigner is nil unless attachments.provider is set, in which
+// case attachment storage keys are exchanged for pre-signed URLs.
+var attachmentPresigner *storagePresigner
+
+// storagePresigner signs GET URLs for objects in one bucket. GCS is reached
+// through its S3-compatible XML API with HMAC keys, which accepts the same
+// Signature Version 4 URLs.
+type storagePresigner struct {
+	bucketURL *url.URL // the bucket root; object keys are appended to its path
+	region    string
+	creds     awsCredentials
+	ttl       time.Duration
+}
+
+func newStoragePresigner(config *Config) (*storagePresigner, error) {
+	settings := config.Attachments
+	creds := awsCredentials{
+		AccessKeyID:     firstNonEmpty(settings.AccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretAccessKey: firstNonEmpty(settings.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+	}
+	if settings.AccessKeyID == "" {
+		creds.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("attachments.access_key_id and attachments.secret_access_key (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY) are required")
+	}
+
+	region := firstNonEmpty(settings.Region, os.Getenv("AWS_REGION"))
+	endpoint := strings.TrimRight(settings.Endpoint, "/")
+	var root string
+	switch settings.Provider {
+	case "gcs":
+		region = "auto"
+		root = firstNonEmpty(endpoint, "https://storage.googleapis.com") + "/" + settings.Bucket
+	case "s3":
+		if region == "" {
+			return nil, errors.New("attachments.region or AWS_REGION is required for s3")
+		}
+		if endpoint != "" {
+			// Custom endpoints, such as MinIO, are addressed path-style.
+			root = endpoint + "/" + settings.Bucket
+		} else {
+			root = "https://" + settings.Bucket + ".s3." + region + ".amazonaws.com"
+		}
+	default:
+		return nil, fmt.Errorf("unknown attachments.provider %q", settings.Provider)
+	}
+
+	bucketURL, err := url.Parse(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attachments endpoint: %w", err)
+	}
+	return &storagePresigner{bucketURL: bucketURL, region: region, creds: creds, ttl: settings.URLTTL}, nil
+}
+
+// objectURL returns the URL of the object stored at key.
+func (p *storagePresigner) objectURL(key string) *url.URL {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+
+	object := *p.bucketURL
+	object.RawPath = strings.TrimRight(p.bucketURL.EscapedPath(), "/") + "/" + strings.Join(segments, "/")
+	object.Path = strings.TrimRight(p.bucketURL.Path, "/") + "/" + key
+	return &object
+}
+
+// presign returns a URL that fetches key until ttl has passed.
+func (p *storagePresigner) presign(key string, now time.Time) string {
+	return presignAWSURL(p.objectURL(key), p.creds, p.region, "s3", p.ttl, now)
+}
+
+// put stores body at key with a signed PUT.
+func (p *storagePresigner) put(ctx context.Context, client *http.Client, key, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.objectURL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	signAWSRequest(req, body, p.creds, p.region, "s3", time.Now())
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("object storage answered %s", res.Status)
+	}
+	return nil
+}
+
+// attachmentLinks renders a notification with freshly signed attachment URLs
+// when it is written to a socket, so a client gets a working link even if the
+// notification waited in a queue or a blackout. It is shared by every
+// recipient of the notification and re-signs once half of the URL lifetime
+// has passed, so no link is delivered with less than half its lifetime left.
+type attachmentLinks struct {
+	presigner *storagePresigner
+	message   Message
+
+	mu      sync.Mutex
+	payload []byte
+	renewAt time.Time
+}
+
+func newAttachmentLinks(presigner *storagePresigner, message Message) *attachmentLinks {
+	if presigner == nil || len(message.Attachments) == 0 {
+		return nil
+	}
+	return &attachmentLinks{presigner: presigner, message: message}
+}
+
+// render returns the notification payload with URLs valid at now, or fallback
+// if it cannot be encoded.
+func (l *attachmentLinks) render(fallback []byte, now time.Time) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.payload != nil && now.Before(l.renewAt) {
+		return l.payload
+	}
+
+	message := l.message
+	message.Attachments = make([]Attachment, len(l.message.Attachments))
+	expires := now.Add(l.presigner.ttl).UTC()
+	for i, attachment := range l.message.Attachments {
+		attachment.URL = l.presigner.presign(attachment.StorageKey, now)
+		attachment.URLExpiresAt = &expires
+		message.Attachments[i] = attachment
+	}
+	payload, err := message.ToJSON()
+	if err != nil {
+		slog.Error("Failed to encode attachment links", "notification", message.NotificationID, "error", err)
+		return fallback
+	}
+
+	l.payload = payload
+	l.renewAt = now.Add(l.presigner.ttl / 2)
+	appMetrics.Count("attachments.presigned", int64(len(message.Attachments)))
+	return payload
+}
+
+// deliveryPayload returns the frame to write for message at this moment.
+func (m outboundMessage) deliveryPayload() []byte {
+	if m.links == nil {
+		return m.payload
+	}
+	return m.links.render(m.payload, time.Now())
+}
+
+// attachmentsFromRequest converts /send attachments to their websocket form.
+func attachmentsFromRequest(requested []AttachmentRequest) []Attachment {
+	if len(requested) == 0 {
+		return nil
+	}
+	attachments := make([]Attachment, len(requested))
+	for i, attachment := range requested {
+		attachments[i] = Attachment{
+			Name:       attachment.Name,
+			Mime:       attachment.Mime,
+			Size:       attachment.Size,
+			StorageKey: attachment.StorageKey,
+		}
+	}
+	return attachments
+}
+
+func validateAttachments(attachments []AttachmentRequest) error {
+	limit := 0
+	if AppConfig() != nil {
+		limit = AppConfig().Attachments.MaxPerMessage
+	}
+	if len(attachments) > limit {
+		return fmt.Errorf("at most %d attachments are allowed", limit)
+	}
+	for i, attachment := range attachments {
+		if attachment.Name == "" {
+			return fmt.Errorf("attachments[%d].name is required", i)
+		}
+		if attachment.StorageKey == "" {
+			return fmt.Errorf("attachments[%d].storage_key is required", i)
+		}
+		if len(attachment.StorageKey) > maxStorageKeyLength {
+			return fmt.Errorf("attachments[%d].storage_key must be at most %d bytes", i, maxStorageKeyLength)
+		}
+		if attachment.Size < 0 {
+			return fmt.Errorf("attachments[%d].size must not be negative", i)
+		}
+	}
+	return nil
+}
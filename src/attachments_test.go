@@ -0,0 +1,187 @@
+// attachments_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPresigner(t *testing.T, provider, endpoint string) *storagePresigner {
+	t.Helper()
+	config := &Config{}
+	setDefaults(config)
+	config.Attachments.Provider = provider
+	config.Attachments.Bucket = "files"
+	config.Attachments.Region = "eu-west-1"
+	config.Attachments.Endpoint = endpoint
+	config.Attachments.AccessKeyID = "AKIDEXAMPLE"
+	config.Attachments.SecretAccessKey = "secret"
+	presigner, err := newStoragePresigner(config)
+	if err != nil {
+		t.Fatalf("newStoragePresigner failed: %v", err)
+	}
+	return presigner
+}
+
+func TestStoragePresigner(t *testing.T) {
+	now := time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		provider string
+		endpoint string
+		wantURL  string
+		wantCred string
+	}{
+		{name: "s3", provider: "s3", wantURL: "https://files.s3.eu-west-1.amazonaws.com/reports/Q1%20%28final%29.pdf", wantCred: "AKIDEXAMPLE/20250110/eu-west-1/s3/aws4_request"},
+		{name: "s3 endpoint", provider: "s3", endpoint: "http://minio:9000/", wantURL: "http://minio:9000/files/reports/Q1%20%28final%29.pdf", wantCred: "AKIDEXAMPLE/20250110/eu-west-1/s3/aws4_request"},
+		{name: "gcs", provider: "gcs", wantURL: "https://storage.googleapis.com/files/reports/Q1%20%28final%29.pdf", wantCred: "AKIDEXAMPLE/20250110/auto/s3/aws4_request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed := testPresigner(t, tt.provider, tt.endpoint).presign("reports/Q1 (final).pdf", now)
+			base, query, _ := strings.Cut(signed, "?")
+			if base != tt.wantURL {
+				t.Fatalf("expected object URL %s, got %s", tt.wantURL, base)
+			}
+			values, err := url.ParseQuery(query)
+			if err != nil {
+				t.Fatalf("invalid query: %v", err)
+			}
+			if values.Get("X-Amz-Credential") != tt.wantCred || values.Get("X-Amz-Expires") != "900" || len(values.Get("X-Amz-Signature")) != 64 {
+				t.Fatalf("unexpected signing parameters: %v", values)
+			}
+		})
+	}
+}
+
+func TestNewStoragePresigner_RequiresCredentialsAndRegion(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_REGION", "")
+
+	config := &Config{}
+	setDefaults(config)
+	config.Attachments.Provider = "s3"
+	config.Attachments.Bucket = "files"
+	if _, err := newStoragePresigner(config); err == nil || !strings.Contains(err.Error(), "access_key_id") {
+		t.Fatalf("expected a missing credentials error, got %v", err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	if _, err := newStoragePresigner(config); err == nil || !strings.Contains(err.Error(), "region") {
+		t.Fatalf("expected a missing region error, got %v", err)
+	}
+}
+
+func TestAttachmentLinks_RenewAfterHalfTheLifetime(t *testing.T) {
+	setupTestAppConfig()
+	message := Message{NotificationID: "n1", MessageType: "file", Body: "report", Attachments: []Attachment{{Name: "q1.pdf", StorageKey: "reports/q1.pdf"}}}
+	links := newAttachmentLinks(testPresigner(t, "s3", ""), message)
+
+	decode := func(payload []byte) Attachment {
+		var delivered Message
+		if err := json.Unmarshal(payload, &delivered); err != nil || len(delivered.Attachments) != 1 {
+			t.Fatalf("unexpected payload %s: %v", payload, err)
+		}
+		return delivered.Attachments[0]
+	}
+
+	start := time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC)
+	first := decode(links.render(nil, start))
+	if first.URL == "" || first.URLExpiresAt == nil || !first.URLExpiresAt.Equal(start.Add(15*time.Minute)) {
+		t.Fatalf("expected a signed URL expiring in 15m, got %+v", first)
+	}
+	if again := decode(links.render(nil, start.Add(7*time.Minute))); again.URL != first.URL {
+		t.Fatal("expected the URL to be reused within half its lifetime")
+	}
+	if renewed := decode(links.render(nil, start.Add(8*time.Minute))); renewed.URL == first.URL {
+		t.Fatal("expected the URL to be re-signed after half its lifetime")
+	}
+	if message.Attachments[0].URL != "" {
+		t.Fatal("rendering must not modify the original message")
+	}
+
+	if newAttachmentLinks(nil, message) != nil || newAttachmentLinks(links.presigner, Message{}) != nil {
+		t.Fatal("expected no links without a presigner or attachments")
+	}
+}
+
+func TestHandleSendMessage_Attachments(t *testing.T) {
+	tests := []struct {
+		name       string
+		presign    bool
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "plain reference",
+			body:       `{"target_user_id":"user-1","message_type":"file","body":"report","attachments":[{"name":"q1.pdf","mime":"application/pdf","size":1024,"storage_key":"reports/q1.pdf"}]}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "pre-signed",
+			presign:    true,
+			body:       `{"target_user_id":"user-1","message_type":"file","body":"report","attachments":[{"name":"q1.pdf","storage_key":"reports/q1.pdf"}]}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing storage key",
+			body:       `{"target_user_id":"user-1","message_type":"file","body":"report","attachments":[{"name":"q1.pdf"}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "attachments[0].storage_key is required",
+		},
+		{
+			name:       "too many",
+			body:       `{"target_user_id":"user-1","message_type":"file","body":"report","attachments":[` + strings.Repeat(`{"name":"a","storage_key":"a"},`, 10) + `{"name":"a","storage_key":"a"}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "at most 10 attachments",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestAppConfig()
+			attachmentPresigner = nil
+			if tt.presign {
+				attachmentPresigner = testPresigner(t, "s3", "")
+				t.Cleanup(func() { attachmentPresigner = nil })
+			}
+			hub := newHub()
+			client := &Client{teamID: "team-1", userID: "user-1", send: make(chan outboundMessage, 1)}
+			hub.clients["team-1"] = map[string]map[*Client]struct{}{"user-1": {client: {}}}
+
+			rr := httptest.NewRecorder()
+			handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", bytes.NewBufferString(tt.body)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantError != "" {
+				if !strings.Contains(rr.Body.String(), tt.wantError) {
+					t.Fatalf("expected error %q, got %s", tt.wantError, rr.Body.String())
+				}
+				return
+			}
+
+			var delivered Message
+			if err := json.Unmarshal((<-client.send).deliveryPayload(), &delivered); err != nil {
+				t.Fatalf("failed to decode delivery: %v", err)
+			}
+			if len(delivered.Attachments) != 1 || delivered.Attachments[0].StorageKey != "reports/q1.pdf" {
+				t.Fatalf("unexpected attachments: %+v", delivered.Attachments)
+			}
+			signed := strings.HasPrefix(delivered.Attachments[0].URL, "https://files.s3.eu-west-1.amazonaws.com/reports/q1.pdf?")
+			if signed != tt.presign {
+				t.Fatalf("expected signed=%v, got URL %q", tt.presign, delivered.Attachments[0].URL)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// audit.go
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maxRecentAuditEvents is how many audit events are kept in memory for
+// /admin/audit and the dashboard.
+const maxRecentAuditEvents = 200
+
+var recentAudit = newAuditRing(maxRecentAuditEvents)
+
+// auditEvent records a security-relevant action taken by the server.
+type auditEvent struct {
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Subject string            `json:"subject"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// recordAudit writes an audit event as a log record.
+func recordAudit(event auditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if writeAuditLine(event) {
+		recentAudit.add(event)
+	}
+	details := map[string]string{"action": event.Action, "subject": event.Subject}
+	for key, value := range event.Details {
+		details[key] = value
+	}
+	liveEvents.publish(controlEvent{Time: event.Time, Type: "audit", Details: details})
+}
+
+// auditLogMessage is the message of audit records, which carry the event as
+// JSON in their event attribute.
+const auditLogMessage = "AUDIT"
+
+// writeAuditLine logs an audit event and reports whether it could be encoded.
+func writeAuditLine(event auditEvent) bool {
+	line, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode an audit event", "action", event.Action, "error", err)
+		return false
+	}
+	slog.Info(auditLogMessage, "event", json.RawMessage(line))
+	appMetrics.Count("audit.events", 1, metricTag("action", event.Action))
+	return true
+}
+
+// auditRing keeps the latest audit events in a fixed-size ring buffer.
+type auditRing struct {
+	mu     sync.Mutex
+	events []auditEvent
+	next   int
+	full   bool
+}
+
+func newAuditRing(size int) *auditRing {
+	return &auditRing{events: make([]auditEvent, size)}
+}
+
+func (r *auditRing) add(event auditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events[r.next] = event
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns up to limit events, newest first.
+func (r *auditRing) list(limit int) []auditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.events)
+	}
+	if limit > count {
+		limit = count
+	}
+	events := make([]auditEvent, 0, limit)
+	for i := 1; i <= limit; i++ {
+		events = append(events, r.events[(r.next-i+len(r.events))%len(r.events)])
+	}
+	return events
+}
+
+// chronological returns every event, oldest first.
+func (r *auditRing) chronological() []auditEvent {
+	events := r.list(len(r.events))
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events
+}
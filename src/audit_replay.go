@@ -0,0 +1,223 @@
+// audit_replay.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// sendAuditAction is the audit action of an accepted /send.
+	sendAuditAction = "send"
+	// maxReplayBodyBytes bounds an uploaded audit log.
+	maxReplayBodyBytes = 32 << 20
+	// maxReplayEvents bounds the sends one replay evaluates.
+	maxReplayEvents = 10000
+)
+
+// recentSends is nil unless audit.sends is set. It keeps the latest audited
+// sends apart from recentAudit, so they do not push out security events.
+var recentSends *auditRing
+
+// auditSend writes an AUDIT line for an accepted /send, with the request as
+// it was decoded and the number of clients it was delivered to.
+func auditSend(tenantID string, req *MessageRequest, delivered int, outcome string) {
+	if recentSends == nil {
+		return
+	}
+	request, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+	details := map[string]string{"request": string(request), "delivered": strconv.Itoa(delivered)}
+	if tenantID != "" {
+		details["tenant"] = tenantID
+	}
+	switch outcome {
+	case firehoseDeferred:
+		details["deferred"] = "true"
+	case firehoseSuppressed:
+		details["suppressed"] = "true"
+	}
+	event := auditEvent{Time: time.Now(), Action: sendAuditAction, Subject: req.NotificationID, Details: details}
+	if writeAuditLine(event) {
+		recentSends.add(event)
+	}
+}
+
+// replayResult compares one audited send with what it would reach now.
+type replayResult struct {
+	Time           time.Time `json:"time"`
+	NotificationID string    `json:"notification_id,omitempty"`
+	TenantID       string    `json:"tenant_id,omitempty"`
+	TargetTeamID   string    `json:"target_team_id,omitempty"`
+	TargetUserID   string    `json:"target_user_id,omitempty"`
+	Broadcast      bool      `json:"broadcast"`
+	Delivered      int       `json:"delivered"` // when it was sent
+	Total          int       `json:"total"`     // connections it would reach now
+	Users          int       `json:"users"`
+	Deferred       bool      `json:"deferred,omitempty"`
+	Error          string    `json:"error,omitempty"` // why it would now be rejected
+}
+
+type replayResponse struct {
+	From       time.Time      `json:"from,omitempty"`
+	To         time.Time      `json:"to,omitempty"`
+	Replayed   int            `json:"replayed"`
+	Rejected   int            `json:"rejected"`
+	Delivered  int            `json:"delivered"`
+	Recipients int            `json:"recipients"`
+	Truncated  bool           `json:"truncated,omitempty"`
+	Results    []replayResult `json:"results"`
+}
+
+// parseAuditLog reads audit events from log output: one per line, either as
+// the JSON event itself, as an AUDIT record of the text or JSON log format,
+// or as an older log line containing "AUDIT <json>". Other lines are skipped.
+func parseAuditLog(r io.Reader) ([]auditEvent, error) {
+	var events []auditEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxReplayBodyBytes)
+	for scanner.Scan() {
+		var event auditEvent
+		if json.Unmarshal(auditEventJSON(scanner.Bytes()), &event) != nil || event.Action == "" {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// auditEventJSON returns the audit event JSON of one log line.
+func auditEventJSON(line []byte) []byte {
+	line = bytes.TrimSpace(line)
+	if bytes.HasPrefix(line, []byte("{")) {
+		var record struct {
+			Msg   string          `json:"msg"`
+			Event json.RawMessage `json:"event"`
+		}
+		if json.Unmarshal(line, &record) == nil && record.Msg == auditLogMessage {
+			return record.Event
+		}
+		return line
+	}
+	// The text format writes msg=AUDIT, and the standard logger just AUDIT.
+	if i := bytes.Index(line, []byte(auditLogMessage+" event=")); i >= 0 {
+		quoted, err := strconv.QuotedPrefix(string(line[i+len(auditLogMessage+" event="):]))
+		if err != nil {
+			return nil
+		}
+		event, _ := strconv.Unquote(quoted)
+		return []byte(event)
+	}
+	if i := bytes.Index(line, []byte("AUDIT ")); i >= 0 {
+		return line[i+len("AUDIT "):]
+	}
+	return line
+}
+
+// replayAuditedSend evaluates one audited send as a dry run against the
+// current configuration and connections.
+func replayAuditedSend(hub *Hub, event auditEvent, now time.Time) replayResult {
+	result := replayResult{Time: event.Time, NotificationID: event.Subject, TenantID: event.Details["tenant"]}
+	result.Delivered, _ = strconv.Atoi(event.Details["delivered"])
+
+	req, err := decodeMessageRequest([]byte(event.Details["request"]))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.TargetTeamID, result.TargetUserID, result.Broadcast = req.TargetTeamID, req.TargetUserID, req.Broadcast
+
+	preview, err := previewRequest(hub, result.TenantID, req, now)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Total, result.Users, result.Deferred = preview.Total, preview.Users, preview.Deferred
+	return result
+}
+
+// handleAdminAuditReplay serves POST /admin/audit/replay. It replays the
+// audited sends in ?from= to ?to= as dry runs. The sends come from the
+// uploaded audit log, or from the ones this instance still remembers when the
+// body is empty.
+func handleAdminAuditReplay(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var response replayResponse
+	for name, bound := range map[string]*time.Time{"from": &response.From, "to": &response.To} {
+		if raw := r.URL.Query().Get(name); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				http.Error(w, name+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			*bound = parsed
+		}
+	}
+	if !response.From.IsZero() && !response.To.IsZero() && !response.To.After(response.From) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxReplayBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("audit log exceeds %d bytes", maxReplayBodyBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var events []auditEvent
+	if len(bytes.TrimSpace(body)) > 0 {
+		if events, err = parseAuditLog(bytes.NewReader(body)); err != nil {
+			http.Error(w, "Error reading audit log: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if recentSends != nil {
+		events = recentSends.chronological()
+	} else {
+		http.Error(w, "Send auditing is not enabled; upload an audit log instead", http.StatusServiceUnavailable)
+		return
+	}
+
+	now := time.Now()
+	response.Results = []replayResult{}
+	for _, event := range events {
+		if event.Action != sendAuditAction {
+			continue
+		}
+		if (!response.From.IsZero() && event.Time.Before(response.From)) || (!response.To.IsZero() && !event.Time.Before(response.To)) {
+			continue
+		}
+		if response.Replayed == maxReplayEvents {
+			response.Truncated = true
+			break
+		}
+		result := replayAuditedSend(hub, event, now)
+		response.Replayed++
+		if result.Error != "" {
+			response.Rejected++
+		}
+		response.Delivered += result.Delivered
+		response.Recipients += result.Total
+		response.Results = append(response.Results, result)
+	}
+
+	appMetrics.Count("audit.replays", 1)
+	writeJSON(w, http.StatusOK, response)
+}
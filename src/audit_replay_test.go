@@ -0,0 +1,103 @@
+// audit_replay_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAuditLog(t *testing.T) {
+	log := strings.Join([]string{
+		`2026/01/02 10:00:00 📝 AUDIT {"time":"2026-01-02T10:00:00Z","action":"send","subject":"n1","details":{"delivered":"2"}}`,
+		`2026/01/02 10:00:01 ✅ Client connected`,
+		`{"time":"2026-01-02T10:00:02Z","action":"auth.failure","subject":"1.2.3.4"}`,
+		`not json`,
+		`time=2026-01-02T10:00:03Z level=INFO msg=AUDIT event="{\"time\":\"2026-01-02T10:00:03Z\",\"action\":\"ban\",\"subject\":\"ip:1.2.3.4\"}"`,
+		`{"time":"2026-01-02T10:00:04Z","level":"INFO","msg":"AUDIT","event":{"time":"2026-01-02T10:00:04Z","action":"lockout","subject":"1.2.3.4"}}`,
+		`{"time":"2026-01-02T10:00:05Z","level":"INFO","msg":"Client connected"}`,
+		`2026/01/02 10:00:06 INFO AUDIT event="{\"time\":\"2026-01-02T10:00:06Z\",\"action\":\"unban\",\"subject\":\"ip:1.2.3.4\"}"`,
+	}, "\n")
+	events, err := parseAuditLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("parseAuditLog returned error: %v", err)
+	}
+	if len(events) != 5 || events[0].Action != "send" || events[0].Details["delivered"] != "2" || events[1].Action != "auth.failure" || events[2].Action != "ban" || events[3].Action != "lockout" || events[4].Action != "unban" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestHandleAdminAuditReplay(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	alice := &Client{hub: hub, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 4)}
+	bob := &Client{hub: hub, teamID: "team-1", userID: "bob", send: make(chan outboundMessage, 4)}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {alice: {}}}
+
+	replay := func(target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleAdminAuditReplay(hub, rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := replay("/admin/audit/replay", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without send auditing or a log, got %d", rec.Code)
+	}
+
+	recentSends = newAuditRing(10)
+	defer func() { recentSends = nil }()
+
+	rec := httptest.NewRecorder()
+	handleSendMessage(hub, rec, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(
+		`{"notification_id":"n1","target_team_id":"team-1","message_type":"alert","body":"hi","broadcast":true}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	auditSend("missing", &MessageRequest{TargetTeamID: "team-1", MessageType: "alert", Body: "x", Broadcast: true}, 0, firehoseUnrouted)
+
+	// bob connects after the send; the replay reaches both users.
+	hub.clients["team-1"]["bob"] = map[*Client]struct{}{bob: {}}
+
+	rec = replay("/admin/audit/replay", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response replayResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Replayed != 2 || response.Rejected != 1 || response.Delivered != 1 || response.Recipients != 2 {
+		t.Fatalf("unexpected totals: %+v", response)
+	}
+	if first := response.Results[0]; first.NotificationID != "n1" || first.Total != 2 || first.Users != 2 || first.Error != "" {
+		t.Fatalf("unexpected replay of the send: %+v", first)
+	}
+	if second := response.Results[1]; second.TenantID != "missing" || second.Error == "" {
+		t.Fatalf("expected the unknown tenant's send to be rejected, got %+v", second)
+	}
+	if len(alice.send) != 1 || len(bob.send) != 0 {
+		t.Fatal("expected the replay not to deliver anything")
+	}
+
+	uploaded := `📝 AUDIT {"time":"2026-01-02T10:00:00Z","action":"send","subject":"old","details":{"request":"{\"target_team_id\":\"team-1\",\"target_user_id\":\"bob\",\"message_type\":\"alert\",\"body\":\"x\"}","delivered":"0"}}` + "\n" +
+		`📝 AUDIT {"time":"2026-01-03T10:00:00Z","action":"send","subject":"later","details":{"request":"{}"}}`
+	rec = replay("/admin/audit/replay?from=2026-01-02T00:00:00Z&to=2026-01-03T00:00:00Z", uploaded)
+	response = replayResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Replayed != 1 || response.Results[0].NotificationID != "old" || response.Results[0].Total != 1 {
+		t.Fatalf("expected only the send inside the range to be replayed, got %+v", response)
+	}
+
+	if rec := replay("/admin/audit/replay?from=yesterday", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a malformed from, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handleAdminAuditReplay(hub, rec, httptest.NewRequest(http.MethodGet, "/admin/audit/replay", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,96 @@
+// auth_cache.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxAuthCacheEntries bounds the auth cache; expired entries are dropped when
+// it is full, and everything is dropped if that is not enough.
+const maxAuthCacheEntries = 10000
+
+// backendAuthCache is nil unless backend.auth_cache_ttl is set, and all
+// methods are nil-safe.
+var backendAuthCache *authCache
+
+// authCache remembers recent successful backend authentications so clients
+// can still connect while the backend is unreachable. It is only consulted
+// when the backend cannot be asked, never in place of a working backend.
+type authCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]authCacheEntry
+}
+
+type authCacheEntry struct {
+	userID    string
+	email     string
+	teamID    string
+	teamAdmin bool
+	roles     []string
+	groups    []string
+	expires   time.Time
+}
+
+func newAuthCache(ttl time.Duration) *authCache {
+	return &authCache{ttl: ttl, now: time.Now, entries: make(map[string]authCacheEntry)}
+}
+
+// authCacheKey hashes the whole token; unlike lockout keys it is never
+// truncated, since a collision here would authenticate the wrong user.
+func authCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *authCache) remember(token, teamID string, user *verifiedUser) {
+	if c == nil {
+		return
+	}
+
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxAuthCacheEntries {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maxAuthCacheEntries {
+			c.entries = make(map[string]authCacheEntry)
+		}
+	}
+	c.entries[authCacheKey(token)] = authCacheEntry{
+		userID:    user.ID,
+		email:     user.Email,
+		teamID:    teamID,
+		teamAdmin: user.TeamAdmin,
+		roles:     user.Roles,
+		groups:    user.Groups,
+		expires:   now.Add(c.ttl),
+	}
+}
+
+// lookup returns what token last authenticated as, if that was for teamID
+// and has not expired.
+func (c *authCache) lookup(token, teamID string) (authCacheEntry, bool) {
+	if c == nil {
+		return authCacheEntry{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[authCacheKey(token)]
+	if !ok || entry.teamID != teamID || !c.now().Before(entry.expires) {
+		return authCacheEntry{}, false
+	}
+	return entry, true
+}
@@ -0,0 +1,214 @@
+// backend_health.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backendStatus is the backend's health as last probed.
+type backendStatus struct {
+	Degraded  bool      `json:"degraded"`
+	Since     time.Time `json:"since"` // when Degraded last changed
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// degradedModeFrame tells __stats__ subscribers that the server entered or
+// left degraded mode.
+type degradedModeFrame struct {
+	Type      string    `json:"type"` // always "degradedMode"
+	Component string    `json:"component"`
+	Degraded  bool      `json:"degraded"`
+	Since     time.Time `json:"since"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// backendMonitor probes the backend's health endpoint on a fixed interval.
+// After threshold consecutive failed probes it marks the backend degraded and
+// holds breaker open, so auth and team checks fail fast (and fall back to the
+// auth cache) instead of each waiting on a dead backend. The first successful
+// probe closes the breaker again.
+type backendMonitor struct {
+	url       string
+	interval  time.Duration
+	threshold int
+	client    *http.Client
+	breaker   *CircuitBreaker
+
+	// onChange runs after the backend becomes degraded or recovers,
+	// outside the monitor lock.
+	onChange func(backendStatus)
+
+	mu       sync.Mutex
+	failures int
+	status   backendStatus
+}
+
+func newBackendMonitor(baseURL, path string, interval time.Duration, threshold int, client *http.Client, breaker *CircuitBreaker) *backendMonitor {
+	return &backendMonitor{
+		url:       strings.TrimRight(baseURL, "/") + path,
+		interval:  interval,
+		threshold: threshold,
+		client:    client,
+		breaker:   breaker,
+		status:    backendStatus{Since: time.Now()},
+	}
+}
+
+// run probes immediately and then every interval until stop is closed.
+func (m *backendMonitor) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.record(m.probe())
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (m *backendMonitor) probe() error {
+	res, err := m.client.Get(m.url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("health check returned status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// record applies the outcome of one probe.
+func (m *backendMonitor) record(err error) {
+	m.mu.Lock()
+	changed := false
+	if err != nil {
+		m.failures++
+		m.status.LastError = err.Error()
+		if m.failures >= m.threshold {
+			// Re-trip on every failed probe so the breaker never half-opens
+			// while the backend is known to be down.
+			m.breaker.trip()
+			if !m.status.Degraded {
+				m.status.Degraded = true
+				m.status.Since = time.Now()
+				changed = true
+			}
+		}
+	} else {
+		m.failures = 0
+		m.status.LastError = ""
+		if m.status.Degraded {
+			m.breaker.reset()
+			m.status.Degraded = false
+			m.status.Since = time.Now()
+			changed = true
+		}
+	}
+	status := m.status
+	onChange := m.onChange
+	m.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange(status)
+	}
+}
+
+// current returns the last probed status.
+func (m *backendMonitor) current() backendStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// announceBackendStatus logs a change in the health of the backends as a
+// whole and pushes a degradedMode frame to __stats__ subscribers.
+func announceBackendStatus(hub *Hub, status backendStatus) {
+	if status.Degraded {
+		slog.Warn("All backends are down, serving auth from cache only", "error", status.LastError)
+		appMetrics.Gauge("backend.degraded", 1)
+	} else {
+		slog.Info("A backend recovered, resuming normal auth")
+		appMetrics.Gauge("backend.degraded", 0)
+	}
+
+	publishAdminFrame(hub, degradedModeFrame{
+		Type:      "degradedMode",
+		Component: "backend",
+		Degraded:  status.Degraded,
+		Since:     status.Since.UTC(),
+		Reason:    status.LastError,
+	})
+}
+
+type readinessResponse struct {
+	Status          string                 `json:"status"` // "ready", "degraded", "draining" or "starting"
+	Degraded        bool                   `json:"degraded"`
+	ConfigLoaded    bool                   `json:"configLoaded"`
+	Backend         *backendStatus         `json:"backend,omitempty"`
+	Backends        []backendTargetStatus  `json:"backends,omitempty"`
+	CircuitBreakers []circuitBreakerStatus `json:"circuitBreakers,omitempty"`
+	Objectives      []string               `json:"breachedObjectives,omitempty"` // service-level objectives missed over slo.window
+}
+
+// circuitBreakerStatus is the state of one backend's circuit breaker.
+type circuitBreakerStatus struct {
+	URL      string `json:"url"`
+	State    string `json:"state"` // "closed", "open" or "half-open"
+	Failures int    `json:"failures"`
+}
+
+// handleReadyz reports whether the instance is serving normally. It is
+// degraded when every backend is down or has its circuit breaker open, or a
+// service-level objective is missed. A degraded instance still answers 200:
+// it keeps delivering notifications and authenticating cached clients, so it
+// should stay in rotation. A draining instance, or one whose configuration
+// is not loaded yet, answers 503 so it is taken out.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if AppConfig() == nil {
+		writeJSON(w, http.StatusServiceUnavailable, readinessResponse{Status: "starting"})
+		return
+	}
+
+	now := time.Now()
+	response := readinessResponse{Status: "ready", ConfigLoaded: true}
+	pool := currentBackends()
+	if pool.monitored() {
+		status := pool.current()
+		response.Backend = &status
+		response.Backends = pool.statuses()
+		response.Degraded = status.Degraded
+	}
+	response.CircuitBreakers = pool.breakerStates(now)
+	open := 0
+	for _, breaker := range response.CircuitBreakers {
+		if breaker.State == "open" {
+			open++
+		}
+	}
+	if open > 0 && open == len(response.CircuitBreakers) {
+		response.Degraded = true
+	}
+	response.Objectives = serviceLevels.breached(now)
+	if len(response.Objectives) > 0 {
+		response.Degraded = true
+	}
+	if response.Degraded {
+		response.Status = "degraded"
+	}
+	if drainMode.Load() {
+		response.Status = "draining"
+		writeJSON(w, http.StatusServiceUnavailable, response)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
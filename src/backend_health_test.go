@@ -0,0 +1,190 @@
+// backend_health_test.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackendMonitorTransitions(t *testing.T) {
+	setupTestAppConfig()
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health/" {
+			t.Errorf("unexpected probe path %s", r.URL.Path)
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer backend.Close()
+
+	monitor := newBackendMonitor(backend.URL+"/", "/health/", time.Hour, 2, backend.Client(), backendCircuitBreaker)
+	var changes []backendStatus
+	monitor.onChange = func(s backendStatus) { changes = append(changes, s) }
+
+	steps := []struct {
+		status       int
+		wantDegraded bool
+		wantChanges  int
+	}{
+		{http.StatusOK, false, 0},
+		{http.StatusServiceUnavailable, false, 0}, // below the threshold
+		{http.StatusServiceUnavailable, true, 1},
+		{http.StatusServiceUnavailable, true, 1},
+		{http.StatusOK, false, 2},
+	}
+	for i, step := range steps {
+		status.Store(int32(step.status))
+		monitor.record(monitor.probe())
+
+		current := monitor.current()
+		if current.Degraded != step.wantDegraded || len(changes) != step.wantChanges {
+			t.Fatalf("step %d: expected degraded=%v after %d changes, got %+v after %d", i, step.wantDegraded, step.wantChanges, current, len(changes))
+		}
+		err := backendCircuitBreaker.Call(func() error { return nil })
+		if open := errors.Is(err, errCircuitOpen); open != step.wantDegraded {
+			t.Fatalf("step %d: expected breaker open=%v, got %v", i, step.wantDegraded, err)
+		}
+	}
+	if changes[0].LastError != "health check returned status 503" || changes[1].LastError != "" {
+		t.Fatalf("unexpected change notifications %+v", changes)
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	setupTestAppConfig()
+	backendCircuitBreaker.reset()
+	t.Cleanup(func() { authBackends = nil })
+
+	monitoredPool := func(urls ...string) *backendPool {
+		pool := newBackendPool(urls, false)
+		pool.monitor("/health/", time.Hour, 1, nil)
+		return pool
+	}
+
+	tests := []struct {
+		name         string
+		pool         *backendPool
+		down         int // how many backends fail a probe
+		wantStatus   string
+		wantBackends int
+	}{
+		{"no pool", nil, 0, "ready", 0},
+		{"unmonitored pool", newBackendPool([]string{"http://a"}, false), 0, "ready", 0},
+		{"backend up", monitoredPool("http://a"), 0, "ready", 1},
+		{"one of two backends down", monitoredPool("http://a", "http://b"), 1, "ready", 2},
+		{"every backend down", monitoredPool("http://a", "http://b"), 2, "degraded", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authBackends = tt.pool
+			for i := 0; i < tt.down; i++ {
+				tt.pool.targets[i].monitor.record(errors.New("connection refused"))
+			}
+
+			rr := httptest.NewRecorder()
+			handleReadyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			var response readinessResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if rr.Code != http.StatusOK || response.Status != tt.wantStatus || len(response.Backends) != tt.wantBackends {
+				t.Fatalf("expected 200 %q with %d backends, got %d %+v", tt.wantStatus, tt.wantBackends, rr.Code, response)
+			}
+			if (response.Backend != nil) != (tt.wantBackends > 0) {
+				t.Fatalf("expected an aggregate status only for monitored backends, got %+v", response.Backend)
+			}
+			if !response.ConfigLoaded || len(response.CircuitBreakers) == 0 {
+				t.Fatalf("expected the config and circuit breakers to be reported, got %+v", response)
+			}
+		})
+	}
+
+	// Without health probes, open breakers on every backend degrade the
+	// instance.
+	pool := newBackendPool([]string{"http://a", "http://b"}, false)
+	authBackends = pool
+	pool.targets[0].breaker.trip()
+	rr := httptest.NewRecorder()
+	handleReadyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if !strings.Contains(rr.Body.String(), `"status":"ready"`) || !strings.Contains(rr.Body.String(), `{"url":"http://a","state":"open","failures":5}`) {
+		t.Fatalf("expected one open breaker on a ready instance, got %s", rr.Body.String())
+	}
+	pool.targets[1].breaker.trip()
+	rr = httptest.NewRecorder()
+	handleReadyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if !strings.Contains(rr.Body.String(), `"status":"degraded"`) {
+		t.Fatalf("expected every open breaker to degrade the instance, got %s", rr.Body.String())
+	}
+}
+
+func TestCircuitBreakerState(t *testing.T) {
+	setupTestAppConfig()
+	breaker := &CircuitBreaker{}
+	now := time.Now()
+	if state, _ := breaker.state(now); state != "closed" {
+		t.Fatalf("expected a new breaker to be closed, got %s", state)
+	}
+	breaker.trip()
+	if state, failures := breaker.state(now); state != "open" || failures != AppConfig().CircuitBreaker.Threshold {
+		t.Fatalf("expected a tripped breaker to be open, got %s with %d failures", state, failures)
+	}
+	if state, _ := breaker.state(now.Add(AppConfig().CircuitBreaker.Timeout + time.Second)); state != "half-open" {
+		t.Fatalf("expected the breaker to be half-open after its timeout, got %s", state)
+	}
+}
+
+func TestAuthenticate_FallsBackToAuthCache(t *testing.T) {
+	setupTestAppConfig()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 123, "settings": {"selectedTeam": "team-prod"}}`))
+	}))
+	defer backend.Close()
+	AppConfig().Backend.URL = backend.URL
+	httpClient = backend.Client()
+
+	now := time.Now()
+	backendAuthCache = newAuthCache(time.Minute)
+	backendAuthCache.now = func() time.Time { return now }
+	t.Cleanup(func() { backendAuthCache = nil })
+
+	if err := (&Client{}).authenticate(AuthMessage{Token: "valid-token", TeamID: "team-prod"}); err != nil {
+		t.Fatalf("expected the backend to authenticate the token, got %v", err)
+	}
+	backendCircuitBreaker.trip()
+
+	tests := []struct {
+		name      string
+		token     string
+		teamID    string
+		advance   time.Duration
+		wantError bool
+	}{
+		{"cached token", "valid-token", "team-prod", 0, false},
+		{"other team", "valid-token", "team-other", 0, true},
+		{"unknown token", "other-token", "team-prod", 0, true},
+		{"expired entry", "valid-token", "team-prod", 2 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			client := &Client{}
+			err := client.authenticate(AuthMessage{Token: tt.token, TeamID: tt.teamID})
+			if tt.wantError {
+				if !errors.Is(err, errCircuitOpen) {
+					t.Fatalf("expected the backend error, got %v", err)
+				}
+				return
+			}
+			if err != nil || client.userID != "123" || !client.isAuthenticated {
+				t.Fatalf("expected a cached authentication, got %v (%+v)", err, client)
+			}
+		})
+	}
+}
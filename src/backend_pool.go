@@ -0,0 +1,192 @@
+// backend_pool.go
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// authBackends is the set of backends that auth calls and team checks go to.
+// main() builds it from backend.urls (or backend.url); while it is nil, calls
+// go to backend.url through backendCircuitBreaker.
+var authBackends *backendPool
+
+// backendTarget is one backend with its own circuit breaker and, when
+// backend.health_path is set, its own health monitor.
+type backendTarget struct {
+	url     string
+	breaker *CircuitBreaker
+	monitor *backendMonitor
+}
+
+func (t *backendTarget) degraded() bool {
+	return t.monitor != nil && t.monitor.current().Degraded
+}
+
+// backendPool spreads backend calls over several backends. A call goes to
+// the first healthy backend, in list order or round-robin, and moves on to
+// the next one when a backend is unavailable; a credential failure is
+// returned at once. The pool counts as degraded only when every monitored
+// backend is down.
+type backendPool struct {
+	targets    []*backendTarget
+	roundRobin bool
+	next       atomic.Uint64
+
+	// onChange runs after the pool as a whole becomes degraded or
+	// recovers, outside the pool lock.
+	onChange func(backendStatus)
+
+	mu     sync.Mutex
+	status backendStatus
+}
+
+func newBackendPool(urls []string, roundRobin bool) *backendPool {
+	pool := &backendPool{roundRobin: roundRobin, status: backendStatus{Since: time.Now()}}
+	for _, url := range urls {
+		pool.targets = append(pool.targets, &backendTarget{url: strings.TrimRight(url, "/"), breaker: &CircuitBreaker{}})
+	}
+	return pool
+}
+
+// currentBackends returns authBackends, or a pool of backend.url alone.
+func currentBackends() *backendPool {
+	if authBackends != nil {
+		return authBackends
+	}
+	return &backendPool{targets: []*backendTarget{{url: strings.TrimRight(AppConfig().Backend.URL, "/"), breaker: backendCircuitBreaker}}}
+}
+
+// monitor gives every backend a health monitor on path. Call run to start
+// probing.
+func (p *backendPool) monitor(path string, interval time.Duration, threshold int, client *http.Client) {
+	for _, target := range p.targets {
+		target := target
+		target.monitor = newBackendMonitor(target.url, path, interval, threshold, client, target.breaker)
+		target.monitor.onChange = func(status backendStatus) { p.targetChanged(target, status) }
+	}
+}
+
+// run starts the health monitors until stop is closed.
+func (p *backendPool) run(stop <-chan struct{}) {
+	for _, target := range p.targets {
+		if target.monitor != nil {
+			go target.monitor.run(stop)
+		}
+	}
+}
+
+func (p *backendPool) targetChanged(target *backendTarget, status backendStatus) {
+	if status.Degraded {
+		slog.Warn("Backend is down", "url", redactURL(target.url), "error", status.LastError)
+	} else {
+		slog.Info("Backend is back up", "url", redactURL(target.url))
+	}
+
+	degraded := true
+	for _, t := range p.targets {
+		if !t.degraded() {
+			degraded = false
+			break
+		}
+	}
+
+	p.mu.Lock()
+	if degraded == p.status.Degraded {
+		p.mu.Unlock()
+		return
+	}
+	p.status = backendStatus{Degraded: degraded, Since: time.Now(), LastError: status.LastError}
+	aggregate := p.status
+	onChange := p.onChange
+	p.mu.Unlock()
+
+	if onChange != nil {
+		onChange(aggregate)
+	}
+}
+
+// current returns the pool's aggregate status.
+func (p *backendPool) current() backendStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// monitored reports whether the pool's backends are health checked.
+func (p *backendPool) monitored() bool {
+	return len(p.targets) > 0 && p.targets[0].monitor != nil
+}
+
+// order returns the backends in the order a call should try them: healthy
+// ones first, starting at the next in turn when round-robin is on.
+func (p *backendPool) order() []*backendTarget {
+	start := 0
+	if p.roundRobin && len(p.targets) > 0 {
+		start = int((p.next.Add(1) - 1) % uint64(len(p.targets)))
+	}
+
+	ordered := make([]*backendTarget, 0, len(p.targets))
+	var down []*backendTarget
+	for i := range p.targets {
+		target := p.targets[(start+i)%len(p.targets)]
+		if target.degraded() {
+			down = append(down, target)
+			continue
+		}
+		ordered = append(ordered, target)
+	}
+	// Degraded backends are still tried last; their open breakers make
+	// that immediate.
+	return append(ordered, down...)
+}
+
+// call runs fn against each backend in turn, through its breaker, until one
+// succeeds or fails with a credential error. It returns the last error.
+func (p *backendPool) call(fn func(baseURL string) error) error {
+	var err error
+	for i, target := range p.order() {
+		if i > 0 {
+			appMetrics.Count("backend.failovers", 1)
+		}
+		err = target.breaker.Call(func() error {
+			return fn(target.url)
+		})
+		if err == nil || isCredentialFailure(err) {
+			return err
+		}
+	}
+	return err
+}
+
+type backendTargetStatus struct {
+	URL string `json:"url"`
+	backendStatus
+}
+
+// breakerStates returns the state of each backend's circuit breaker, with
+// redacted URLs.
+func (p *backendPool) breakerStates(now time.Time) []circuitBreakerStatus {
+	states := make([]circuitBreakerStatus, 0, len(p.targets))
+	for _, target := range p.targets {
+		state, failures := target.breaker.state(now)
+		states = append(states, circuitBreakerStatus{URL: redactURL(target.url), State: state, Failures: failures})
+	}
+	return states
+}
+
+// statuses returns each monitored backend's status, with redacted URLs.
+func (p *backendPool) statuses() []backendTargetStatus {
+	statuses := make([]backendTargetStatus, 0, len(p.targets))
+	for _, target := range p.targets {
+		if target.monitor == nil {
+			continue
+		}
+		statuses = append(statuses, backendTargetStatus{URL: redactURL(target.url), backendStatus: target.monitor.current()})
+	}
+	return statuses
+}
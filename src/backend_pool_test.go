@@ -0,0 +1,106 @@
+// backend_pool_test.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackendPoolCall(t *testing.T) {
+	setupTestAppConfig()
+	unavailable := markCircuitBreakerFailure(errors.New("connection refused"))
+	rejected := errors.New("invalid JWT token provided")
+
+	tests := []struct {
+		name       string
+		roundRobin bool
+		down       []int            // backends marked degraded by their monitor
+		results    map[string]error // per backend; missing means success
+		calls      int
+		wantCalls  []string
+		wantErr    error
+	}{
+		{"first backend answers", false, nil, nil, 2, []string{"a", "a"}, nil},
+		{"fails over when unavailable", false, nil, map[string]error{"a": unavailable}, 1, []string{"a", "b"}, nil},
+		{"credential failure is not retried", false, nil, map[string]error{"a": rejected}, 1, []string{"a"}, rejected},
+		{"every backend unavailable", false, nil, map[string]error{"a": unavailable, "b": unavailable, "c": unavailable}, 1, []string{"a", "b", "c"}, unavailable},
+		{"round robin", true, nil, nil, 3, []string{"a", "b", "c"}, nil},
+		{"degraded backend skipped", false, []int{0}, nil, 1, []string{"b"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newBackendPool([]string{"a", "b", "c"}, tt.roundRobin)
+			pool.monitor("/health/", time.Hour, 1, nil)
+			for _, i := range tt.down {
+				pool.targets[i].monitor.record(errors.New("connection refused"))
+			}
+
+			var calls []string
+			var err error
+			for i := 0; i < tt.calls; i++ {
+				err = pool.call(func(baseURL string) error {
+					calls = append(calls, baseURL)
+					return tt.results[baseURL]
+				})
+			}
+			if len(calls) != len(tt.wantCalls) {
+				t.Fatalf("expected calls %v, got %v", tt.wantCalls, calls)
+			}
+			for i := range calls {
+				if calls[i] != tt.wantCalls[i] {
+					t.Fatalf("expected calls %v, got %v", tt.wantCalls, calls)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestBackendPoolDegradedOnlyWhenEveryBackendIsDown(t *testing.T) {
+	setupTestAppConfig()
+	pool := newBackendPool([]string{"http://a", "http://b"}, false)
+	pool.monitor("/health/", time.Hour, 1, nil)
+	var changes []backendStatus
+	pool.onChange = func(status backendStatus) { changes = append(changes, status) }
+
+	a, b := pool.targets[0].monitor, pool.targets[1].monitor
+	a.record(errors.New("a is down"))
+	if pool.current().Degraded || len(changes) != 0 {
+		t.Fatalf("one healthy backend should keep the pool up, got %+v", changes)
+	}
+	b.record(errors.New("b is down"))
+	if !pool.current().Degraded || len(changes) != 1 || changes[0].LastError != "b is down" {
+		t.Fatalf("expected the pool to be degraded once, got %+v", changes)
+	}
+	a.record(nil)
+	if pool.current().Degraded || len(changes) != 2 {
+		t.Fatalf("expected the pool to recover with one backend, got %+v", changes)
+	}
+}
+
+func TestAuthenticate_FailsOverBetweenBackends(t *testing.T) {
+	setupTestAppConfig()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 123, "settings": {"selectedTeam": "team-prod"}}`))
+	}))
+	defer up.Close()
+	httpClient = up.Client()
+
+	authBackends = newBackendPool([]string{down.URL, up.URL}, false)
+	t.Cleanup(func() { authBackends = nil })
+
+	client := &Client{}
+	if err := client.authenticate(AuthMessage{Token: "valid-token", TeamID: "team-prod"}); err != nil || client.userID != "123" {
+		t.Fatalf("expected the second backend to authenticate the client, got %v", err)
+	}
+}
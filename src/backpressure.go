@@ -0,0 +1,76 @@
+// backpressure.go
+package main
+
+import (
+	"log/slog"
+	"math"
+)
+
+func queueUtilization(depth, capacity int) float64 {
+	if capacity == 0 {
+		return 0
+	}
+	return math.Round(float64(depth)/float64(capacity)*1000) / 1000
+}
+
+func newBackpressureNotice(active bool, depth, capacity int) *BackpressureNotice {
+	notice := &BackpressureNotice{
+		Type:          "backpressure",
+		Active:        active,
+		QueueDepth:    depth,
+		QueueCapacity: capacity,
+		Utilization:   queueUtilization(depth, capacity),
+	}
+	if active {
+		notice.Advice = "reduce_activity"
+	}
+	return notice
+}
+
+// signalBackpressure queues a backpressure notice on the control channel once the client's send queue
+// crosses websocket.backpressure_ratio. It is called after every successful
+// enqueue and only fires again after the queue has drained (see
+// clearBackpressure), so a slow client receives one notice per episode.
+func (h *Hub) signalBackpressure(client *Client) {
+	capacity := cap(client.send)
+	depth := len(client.send)
+	if capacity == 0 || float64(depth) < float64(capacity)*AppConfig().WebSocket.BackpressureRatio {
+		return
+	}
+	if !client.backpressureSignaled.CompareAndSwap(false, true) {
+		return
+	}
+
+	payload, err := newBackpressureNotice(true, depth, capacity).ToJSON()
+	if err != nil {
+		slog.Error("Failed to encode the backpressure notice", "error", err)
+		return
+	}
+
+	client.logger().Warn("Backpressure: send queue filling up", "depth", depth, "capacity", capacity)
+	appMetrics.Count("clients.backpressure", 1)
+
+	h.enqueueControl(client, outboundMessage{payload: payload})
+}
+
+// clearBackpressure tells a previously signaled client that its queue has
+// drained below half the backpressure threshold. It runs on the writePump,
+// which owns the connection, so it writes directly.
+func (c *Client) clearBackpressure() error {
+	if !c.backpressureSignaled.Load() {
+		return nil
+	}
+
+	capacity := cap(c.send)
+	depth := len(c.send)
+	if float64(depth) >= float64(capacity)*AppConfig().WebSocket.BackpressureRatio/2 {
+		return nil
+	}
+	c.backpressureSignaled.Store(false)
+
+	payload, err := newBackpressureNotice(false, depth, capacity).ToJSON()
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(payload, false)
+}
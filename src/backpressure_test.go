@@ -0,0 +1,129 @@
+// backpressure_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHub_SignalsBackpressureOncePerEpisode(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().WebSocket.BackpressureRatio = 0.5
+	hub := newHub()
+
+	client := &Client{
+		hub:     hub,
+		teamID:  "team-a",
+		userID:  "user-1",
+		send:    make(chan outboundMessage, 4),
+		control: make(chan outboundMessage, 4),
+	}
+	for i := 0; i < 3; i++ {
+		if !hub.enqueueMessage(client, outboundMessage{payload: []byte("data")}) {
+			t.Fatalf("enqueue %d unexpectedly failed", i+1)
+		}
+	}
+
+	if len(client.send) != 3 {
+		t.Fatalf("expected backpressure notices to stay out of the data queue, got depth %d", len(client.send))
+	}
+
+	var notices []BackpressureNotice
+	for len(client.control) > 0 {
+		frame := <-client.control
+		var notice BackpressureNotice
+		if err := json.Unmarshal(frame.payload, &notice); err != nil {
+			t.Fatalf("failed to decode backpressure notice: %v", err)
+		}
+		notices = append(notices, notice)
+	}
+
+	if len(notices) != 1 {
+		t.Fatalf("expected exactly one backpressure notice, got %d", len(notices))
+	}
+	if !notices[0].Active || notices[0].QueueCapacity != 4 || notices[0].Utilization != 0.5 {
+		t.Fatalf("unexpected backpressure notice: %+v", notices[0])
+	}
+}
+
+func TestWritePump_ClearsBackpressureAfterDraining(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	go hub.run()
+
+	conn := newMockConn()
+	client := &Client{hub: hub, conn: conn, teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 4)}
+	client.backpressureSignaled.Store(true)
+	go client.writePump(context.Background())
+
+	client.send <- outboundMessage{payload: []byte("data")}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn.mu.Lock()
+		written := len(conn.written)
+		var last string
+		if written > 0 {
+			last = string(conn.written[written-1])
+		}
+		conn.mu.Unlock()
+
+		if strings.Contains(last, `"active":false`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for backpressure clear notice, last frame %q", last)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stopWritePump(t, client)
+
+	if client.backpressureSignaled.Load() {
+		t.Fatal("expected backpressure flag to be reset")
+	}
+}
+
+func TestWritePump_PrioritizesControlFrames(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	go hub.run()
+
+	conn := newMockConn()
+	client := &Client{
+		hub:     hub,
+		conn:    conn,
+		teamID:  "team-a",
+		userID:  "user-1",
+		send:    make(chan outboundMessage, 4),
+		control: make(chan outboundMessage, 1),
+	}
+	client.send <- outboundMessage{payload: []byte("data-1")}
+	client.send <- outboundMessage{payload: []byte("data-2")}
+	client.control <- outboundMessage{payload: []byte("control")}
+
+	go client.writePump(context.Background())
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn.mu.Lock()
+		written := len(conn.written)
+		conn.mu.Unlock()
+		if written >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for frames, got %d", written)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stopWritePump(t, client)
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if string(conn.written[0]) != "control" {
+		t.Fatalf("expected control frame to be written first, got %q", conn.written[0])
+	}
+}
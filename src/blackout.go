@@ -0,0 +1,414 @@
+// blackout.go
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// blackoutWindow is a period during which a team's non-critical broadcasts are
+// held back and delivered once the window closes.
+type blackoutWindow struct {
+	ID     string    `json:"id"`
+	TeamID string    `json:"teamId"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func (w blackoutWindow) activeAt(now time.Time) bool {
+	return !now.Before(w.Start) && now.Before(w.End)
+}
+
+// blackoutSchedule holds each team's blackout windows and the broadcasts
+// deferred by them. Windows live in memory only and are lost on restart.
+type blackoutSchedule struct {
+	mu            sync.Mutex
+	windows       map[string][]blackoutWindow
+	deferred      map[string][]outboundMessage
+	criticalTypes map[string]struct{}
+	maxDeferred   int
+}
+
+// teamBlackouts is nil until main configures it, and all methods are nil-safe.
+var teamBlackouts *blackoutSchedule
+
+func newBlackoutSchedule(criticalTypes []string, maxDeferred int) *blackoutSchedule {
+	schedule := &blackoutSchedule{
+		windows:       make(map[string][]blackoutWindow),
+		deferred:      make(map[string][]outboundMessage),
+		criticalTypes: make(map[string]struct{}, len(criticalTypes)),
+		maxDeferred:   maxDeferred,
+	}
+	for _, messageType := range criticalTypes {
+		schedule.criticalTypes[messageType] = struct{}{}
+	}
+	return schedule
+}
+
+func (s *blackoutSchedule) add(window blackoutWindow) (blackoutWindow, error) {
+	window.TeamID = strings.TrimSpace(window.TeamID)
+	if window.TeamID == "" {
+		return blackoutWindow{}, errors.New("teamId is required")
+	}
+	if window.Start.IsZero() {
+		window.Start = time.Now()
+	}
+	if !window.End.After(window.Start) {
+		return blackoutWindow{}, errors.New("end must be after start")
+	}
+	window.ID = newNotificationID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	windows := append(s.windows[window.TeamID], window)
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	s.windows[window.TeamID] = windows
+	return window, nil
+}
+
+// remove deletes a window by ID. Broadcasts it deferred are released on the
+// next release pass if no other window still covers the team.
+func (s *blackoutSchedule) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for teamID, windows := range s.windows {
+		for i, window := range windows {
+			if window.ID != id {
+				continue
+			}
+			windows = append(windows[:i], windows[i+1:]...)
+			if len(windows) == 0 {
+				delete(s.windows, teamID)
+			} else {
+				s.windows[teamID] = windows
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the scheduled windows for one team, or for every team when
+// teamID is empty.
+func (s *blackoutSchedule) list(teamID string) []blackoutWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	windows := []blackoutWindow{}
+	for team, teamWindows := range s.windows {
+		if teamID == "" || team == teamID {
+			windows = append(windows, teamWindows...)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	return windows
+}
+
+func (s *blackoutSchedule) activeLocked(teamID string, now time.Time) bool {
+	for _, window := range s.windows[teamID] {
+		if window.activeAt(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// defers reports whether a broadcast of message to teamID would be deferred
+// at now, without deferring anything.
+func (s *blackoutSchedule) defers(teamID string, message outboundMessage, now time.Time) bool {
+	if s == nil || !s.applies(message) {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeLocked(teamID, now)
+}
+
+// applies reports whether blackouts hold message back at all: it is not of a
+// critical type, and its urgency is subject to quiet hours.
+func (s *blackoutSchedule) applies(message outboundMessage) bool {
+	if _, critical := s.criticalTypes[message.messageType]; critical {
+		return false
+	}
+	return urgencyPolicyOf(message.urgency).QuietHours
+}
+
+// deferBroadcast holds a team broadcast back if the team is in a blackout and
+// the message is not critical. It reports whether the message was deferred.
+func (s *blackoutSchedule) deferBroadcast(teamID string, message outboundMessage, now time.Time) bool {
+	if s == nil || !s.applies(message) {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.activeLocked(teamID, now) {
+		return false
+	}
+
+	// Keep the newest broadcasts; the oldest are the least likely to matter
+	// once the window closes.
+	queue := s.deferred[teamID]
+	if limit := teamRetention.replayBuffer(teamID, s.maxDeferred); len(queue) >= limit {
+		appMetrics.Count("blackout.dropped", int64(len(queue)-limit+1), metricTag("team", teamID))
+		queue = queue[len(queue)-limit+1:]
+	}
+	// Deferral is intentional, so released messages do not count against
+	// delivery latency.
+	message.receivedAt = time.Time{}
+	message.deferredAt = now
+	s.deferred[teamID] = append(queue, message)
+	appMetrics.Count("blackout.deferred", 1, metricTag("team", teamID))
+	return true
+}
+
+// release drops windows that have ended and returns the deferred broadcasts
+// of every team no longer in a blackout, except those that waited longer than
+// the team's offline_queue_ttl.
+func (s *blackoutSchedule) release(now time.Time) map[string][]outboundMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for teamID, windows := range s.windows {
+		kept := windows[:0]
+		for _, window := range windows {
+			if now.Before(window.End) {
+				kept = append(kept, window)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.windows, teamID)
+		} else {
+			s.windows[teamID] = kept
+		}
+	}
+
+	released := make(map[string][]outboundMessage)
+	for teamID, queue := range s.deferred {
+		if s.activeLocked(teamID, now) {
+			continue
+		}
+		delete(s.deferred, teamID)
+		if ttl := teamRetention.offlineQueueTTL(teamID); ttl > 0 {
+			fresh := queue[:0]
+			for _, message := range queue {
+				if message.deferredAt.IsZero() || now.Sub(message.deferredAt) < ttl {
+					fresh = append(fresh, message)
+				}
+			}
+			if expired := len(queue) - len(fresh); expired > 0 {
+				appMetrics.Count("blackout.expired", int64(expired), metricTag("team", teamID))
+			}
+			queue = fresh
+		}
+		released[teamID] = queue
+	}
+	return released
+}
+
+// run delivers deferred broadcasts as their windows close until stop is closed.
+func (s *blackoutSchedule) run(hub *Hub, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.deliverReleased(hub, time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// discard drops a tenant's deferred copies of a notification and returns how
+// many were dropped.
+func (s *blackoutSchedule) discard(tenantID, notificationID string) int {
+	if s == nil || notificationID == "" {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	discarded := 0
+	for teamID, queue := range s.deferred {
+		kept := queue[:0]
+		for _, message := range queue {
+			if message.tenantID == tenantID && message.notificationID == notificationID {
+				discarded++
+				continue
+			}
+			kept = append(kept, message)
+		}
+		if len(kept) == 0 {
+			delete(s.deferred, teamID)
+		} else {
+			s.deferred[teamID] = kept
+		}
+	}
+	return discarded
+}
+
+// snapshot returns every window and deferred broadcast, ordered by team.
+// Broadcasts keep the order they were deferred in.
+func (s *blackoutSchedule) snapshot() ([]blackoutWindow, []deferredSnapshot) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	windows := make([]blackoutWindow, 0)
+	for _, teamWindows := range s.windows {
+		windows = append(windows, teamWindows...)
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		if windows[i].TeamID != windows[j].TeamID {
+			return windows[i].TeamID < windows[j].TeamID
+		}
+		if !windows[i].Start.Equal(windows[j].Start) {
+			return windows[i].Start.Before(windows[j].Start)
+		}
+		return windows[i].ID < windows[j].ID
+	})
+
+	teams := make([]string, 0, len(s.deferred))
+	for teamID := range s.deferred {
+		teams = append(teams, teamID)
+	}
+	sort.Strings(teams)
+	deferred := make([]deferredSnapshot, 0)
+	for _, teamID := range teams {
+		for _, message := range s.deferred[teamID] {
+			deferred = append(deferred, newDeferredSnapshot(teamID, message))
+		}
+	}
+	return windows, deferred
+}
+
+// restore adds windows and deferred broadcasts from a snapshot. Broadcasts
+// whose windows have ended are released on the next check.
+func (s *blackoutSchedule) restore(windows []blackoutWindow, deferred []deferredSnapshot) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, window := range windows {
+		s.windows[window.TeamID] = append(s.windows[window.TeamID], window)
+	}
+	for _, saved := range deferred {
+		message, err := saved.message()
+		if err != nil {
+			slog.Error("Skipping a deferred broadcast from the snapshot", "notification", saved.NotificationID, "error", err)
+			continue
+		}
+		queue := s.deferred[saved.TeamID]
+		if limit := teamRetention.replayBuffer(saved.TeamID, s.maxDeferred); len(queue) >= limit {
+			queue = queue[len(queue)-limit+1:]
+		}
+		s.deferred[saved.TeamID] = append(queue, message)
+	}
+}
+
+func (s *blackoutSchedule) deliverReleased(hub *Hub, now time.Time) {
+	for teamID, queue := range s.release(now) {
+		delivered := 0
+		for _, message := range queue {
+			if message.channel != "" {
+				delivered += hub.publishToChannel(teamID, message.channel, message)
+				continue
+			}
+			delivered += hub.broadcastToTeam(teamID, message)
+		}
+		slog.Info("Blackout ended, released deferred broadcasts", "team", teamID, "broadcasts", len(queue), "delivered", delivered)
+	}
+}
+
+// broadcastToAllTeamsOutsideBlackouts delivers a global broadcast to every team
+// that is not in a blackout and defers one copy for each team that is.
+func broadcastToAllTeamsOutsideBlackouts(hub *Hub, message outboundMessage, now time.Time) int {
+	if teamBlackouts == nil {
+		return hub.broadcastToAllTeams(message)
+	}
+
+	count := 0
+	deferredTeams := make(map[string]bool)
+	for _, client := range hub.snapshotAllClients() {
+		if !message.reaches(client) {
+			continue
+		}
+		deferred, seen := deferredTeams[client.teamID]
+		if !seen {
+			deferred = teamBlackouts.deferBroadcast(client.teamID, message, now)
+			deferredTeams[client.teamID] = deferred
+		}
+		if !deferred && hub.enqueueMessage(client, message) {
+			count++
+		}
+	}
+	return count
+}
+
+type blackoutRequest struct {
+	TeamID string    `json:"teamId"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"`
+}
+
+// handleAdminBlackouts lists (GET ?teamId=), schedules (POST) and cancels
+// (DELETE ?id=) team blackout windows.
+func handleAdminBlackouts(w http.ResponseWriter, r *http.Request) {
+	if teamBlackouts == nil {
+		http.Error(w, "Blackouts are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSONWithETag(w, r, map[string]interface{}{
+			"windows": teamBlackouts.list(strings.TrimSpace(r.URL.Query().Get("teamId"))),
+		})
+
+	case http.MethodPost:
+		var req blackoutRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		window, err := teamBlackouts.add(blackoutWindow{TeamID: req.TeamID, Start: req.Start, End: req.End, Reason: req.Reason})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("Blackout scheduled", "blackout", window.ID, "team", window.TeamID, "start", window.Start, "end", window.End)
+		writeJSON(w, http.StatusCreated, window)
+
+	case http.MethodDelete:
+		id := strings.TrimSpace(r.URL.Query().Get("id"))
+		if id == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		if !teamBlackouts.remove(id) {
+			http.Error(w, "Blackout not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,151 @@
+// blackout_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlackoutSchedule_DefersNonCriticalBroadcasts(t *testing.T) {
+	setupTestAppConfig()
+	schedule := newBlackoutSchedule([]string{"system_alert"}, 10)
+	now := time.Now()
+
+	if _, err := schedule.add(blackoutWindow{TeamID: "team1", Start: now.Add(-time.Minute), End: now.Add(time.Minute)}); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		teamID   string
+		msgType  string
+		at       time.Time
+		expected bool
+	}{
+		{"non-critical during window", "team1", "user_message", now, true},
+		{"critical during window", "team1", "system_alert", now, false},
+		{"other team", "team2", "user_message", now, false},
+		{"after window", "team1", "user_message", now.Add(2 * time.Minute), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message := outboundMessage{payload: []byte("x"), messageType: tc.msgType}
+			if got := schedule.deferBroadcast(tc.teamID, message, tc.at); got != tc.expected {
+				t.Fatalf("deferBroadcast() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBlackoutSchedule_ReleasesWhenWindowCloses(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	client := &Client{hub: hub, teamID: "team1", userID: "user1", send: make(chan outboundMessage, 4)}
+	hub.clients["team1"] = map[string]map[*Client]struct{}{"user1": {client: {}}}
+
+	schedule := newBlackoutSchedule(nil, 10)
+	now := time.Now()
+	window, _ := schedule.add(blackoutWindow{TeamID: "team1", Start: now, End: now.Add(time.Minute)})
+
+	schedule.deferBroadcast("team1", outboundMessage{payload: []byte("first"), messageType: "user_message"}, now)
+	schedule.deferBroadcast("team1", outboundMessage{payload: []byte("second"), messageType: "user_message"}, now)
+
+	schedule.deliverReleased(hub, now.Add(time.Second))
+	if len(client.send) != 0 {
+		t.Fatal("expected broadcasts to stay deferred while the window is open")
+	}
+
+	if !schedule.remove(window.ID) {
+		t.Fatal("expected window to be removed")
+	}
+	schedule.deliverReleased(hub, now.Add(2*time.Second))
+
+	if len(client.send) != 2 {
+		t.Fatalf("expected 2 released broadcasts, got %d", len(client.send))
+	}
+	if first := <-client.send; string(first.payload) != "first" {
+		t.Fatalf("expected broadcasts in order, got %q", first.payload)
+	}
+}
+
+func TestBlackoutSchedule_DropsOldestWhenFull(t *testing.T) {
+	setupTestAppConfig()
+	schedule := newBlackoutSchedule(nil, 2)
+	now := time.Now()
+	schedule.add(blackoutWindow{TeamID: "team1", Start: now, End: now.Add(time.Minute)})
+
+	for _, payload := range []string{"a", "b", "c"} {
+		schedule.deferBroadcast("team1", outboundMessage{payload: []byte(payload), messageType: "user_message"}, now)
+	}
+
+	released := schedule.release(now.Add(2 * time.Minute))["team1"]
+	if len(released) != 2 || string(released[0].payload) != "b" || string(released[1].payload) != "c" {
+		t.Fatalf("expected the newest two broadcasts, got %d", len(released))
+	}
+}
+
+func TestHandleAdminBlackouts(t *testing.T) {
+	setupTestAppConfig()
+	teamBlackouts = newBlackoutSchedule(nil, 10)
+	defer func() { teamBlackouts = nil }()
+
+	end := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	rr := httptest.NewRecorder()
+	handleAdminBlackouts(rr, httptest.NewRequest(http.MethodPost, "/admin/blackouts",
+		strings.NewReader(`{"teamId":"team1","end":"`+end+`","reason":"demo"}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created blackoutWindow
+	if err := json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&created); err != nil || created.ID == "" {
+		t.Fatalf("expected a created window, got %s (%v)", rr.Body.String(), err)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminBlackouts(rr, httptest.NewRequest(http.MethodGet, "/admin/blackouts?teamId=team1", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), created.ID) {
+		t.Fatalf("expected window in listing, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminBlackouts(rr, httptest.NewRequest(http.MethodPost, "/admin/blackouts", strings.NewReader(`{"end":"`+end+`"}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without teamId, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminBlackouts(rr, httptest.NewRequest(http.MethodDelete, "/admin/blackouts?id="+created.ID, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rr.Code)
+	}
+}
+
+func TestHandleSendMessage_DefersTeamBroadcastDuringBlackout(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	client := &Client{hub: hub, teamID: "team1", userID: "user1", send: make(chan outboundMessage, 4)}
+	hub.clients["team1"] = map[string]map[*Client]struct{}{"user1": {client: {}}}
+
+	teamBlackouts = newBlackoutSchedule([]string{"system_alert"}, 10)
+	defer func() { teamBlackouts = nil }()
+	teamBlackouts.add(blackoutWindow{TeamID: "team1", End: time.Now().Add(time.Minute)})
+
+	rr := httptest.NewRecorder()
+	handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(
+		`{"target_team_id":"team1","sender_user_id":"system","message_type":"user_message","body":"hi","broadcast":true}`)))
+
+	var response map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response["deferred"] != true || response["success"] != true {
+		t.Fatalf("expected a deferred success response, got %v", response)
+	}
+	if len(client.send) != 0 {
+		t.Fatal("expected no immediate delivery during a blackout")
+	}
+}
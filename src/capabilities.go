@@ -0,0 +1,365 @@
+// capabilities.go
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// minMaxFrameSize keeps control frames, which are never split or
+	// replaced, within every client's declared limit.
+	minMaxFrameSize = 1024
+	// maxPendingAcks bounds the notifications tracked per connection while
+	// waiting for acks; later ones are sent untracked.
+	maxPendingAcks = 1000
+	// batchFrameOverhead covers the batch frame's own fields when packing
+	// notifications under maxFrameSize.
+	batchFrameOverhead = 64
+	// chunkFrameOverhead covers a chunk frame's own fields.
+	chunkFrameOverhead = 128
+)
+
+// clientCapabilities is the validated form of ClientCapabilities.
+type clientCapabilities struct {
+	declared     bool // the auth message had a capabilities object
+	batching     bool
+	binary       bool
+	ack          bool
+	chunking     bool
+	maxFrameSize int
+}
+
+// compileCapabilities validates what a client declared under protocol. A nil
+// declaration keeps the defaults: one frame per notification, binary frames
+// only for msgpack.v1, no acks and no frame size limit.
+func compileCapabilities(declared *ClientCapabilities, protocol wireProtocol) (clientCapabilities, error) {
+	caps := clientCapabilities{binary: protocol == protocolMsgpackV1}
+	if declared == nil {
+		return caps, nil
+	}
+
+	if declared.SupportsBinary != nil {
+		if !*declared.SupportsBinary && protocol == protocolMsgpackV1 {
+			return caps, errors.New("capabilities.supportsBinary cannot be false with the msgpack.v1 subprotocol")
+		}
+		caps.binary = *declared.SupportsBinary
+	}
+	if declared.MaxFrameSize < 0 || (declared.MaxFrameSize > 0 && declared.MaxFrameSize < minMaxFrameSize) {
+		return caps, fmt.Errorf("capabilities.maxFrameSize must be 0 or at least %d", minMaxFrameSize)
+	}
+	caps.declared = true
+	caps.batching = declared.SupportsBatching
+	caps.ack = declared.SupportsAck
+	caps.chunking = declared.SupportsChunking
+	caps.maxFrameSize = declared.MaxFrameSize
+	return caps, nil
+}
+
+// capabilitiesView is echoed in authSuccess to clients that declared
+// capabilities, so they know what the server applied.
+type capabilitiesView struct {
+	SupportsBatching  bool `json:"supportsBatching"`
+	SupportsBinary    bool `json:"supportsBinary"`
+	SupportsAck       bool `json:"supportsAck"`
+	SupportsChunking  bool `json:"supportsChunking"`
+	MaxFrameSize      int  `json:"maxFrameSize"`
+	MaxBatchMessages  int  `json:"maxBatchMessages,omitempty"`
+	AckTimeoutSeconds int  `json:"ackTimeoutSeconds,omitempty"`
+}
+
+func (c clientCapabilities) view() capabilitiesView {
+	view := capabilitiesView{
+		SupportsBatching: c.batching,
+		SupportsBinary:   c.binary,
+		SupportsAck:      c.ack,
+		SupportsChunking: c.chunking,
+		MaxFrameSize:     c.maxFrameSize,
+	}
+	if c.batching {
+		view.MaxBatchMessages = AppConfig().Limits.MaxBatchMessages
+	}
+	if c.ack {
+		view.AckTimeoutSeconds = int(AppConfig().WebSocket.AckTimeout / time.Second)
+	}
+	return view
+}
+
+// ackTracker remembers when each notification was sent to a supportsAck
+// client until the client acknowledges it or the ack timeout passes.
+type ackTracker struct {
+	mu      sync.Mutex
+	pending map[string]time.Time
+}
+
+func newAckTracker() *ackTracker {
+	return &ackTracker{pending: make(map[string]time.Time)}
+}
+
+// sent starts waiting for an ack of notificationID. Notifications without an
+// ID cannot be acknowledged and are not tracked.
+func (a *ackTracker) sent(notificationID string, at time.Time) {
+	if a == nil || notificationID == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.pending) >= maxPendingAcks {
+		appMetrics.Count("acks.untracked", 1)
+		return
+	}
+	a.pending[notificationID] = at
+}
+
+// ack records the client's acknowledgement of ids and returns how many were
+// pending. Unknown or repeated IDs are ignored.
+func (a *ackTracker) ack(ids []string, now time.Time) int {
+	if a == nil {
+		return 0
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	acked := 0
+	for _, id := range ids {
+		sentAt, ok := a.pending[id]
+		if !ok {
+			continue
+		}
+		delete(a.pending, id)
+		appMetrics.Timing("acks.latency", now.Sub(sentAt))
+		acked++
+	}
+	appMetrics.Count("acks.received", int64(acked))
+	return acked
+}
+
+// expire gives up on notifications sent before cutoff and returns how many
+// there were.
+func (a *ackTracker) expire(cutoff time.Time) int {
+	if a == nil {
+		return 0
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	missed := 0
+	for id, sentAt := range a.pending {
+		if sentAt.Before(cutoff) {
+			delete(a.pending, id)
+			missed++
+		}
+	}
+	if missed > 0 {
+		appMetrics.Count("acks.missed", int64(missed))
+	}
+	return missed
+}
+
+// collectBatch returns first plus, for supportsBatching clients, whatever
+// else is already queued, up to limits.max_batch_messages. closed reports
+// that the send channel was closed while collecting.
+func (c *Client) collectBatch(first outboundMessage) (batch []outboundMessage, closed bool) {
+	batch = []outboundMessage{first}
+	if !c.caps.batching {
+		return batch, false
+	}
+	for len(batch) < AppConfig().Limits.MaxBatchMessages {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				return batch, true
+			}
+			batch = append(batch, message)
+		default:
+			return batch, false
+		}
+	}
+	return batch, false
+}
+
+// writeNotifications sends queued notifications, packing consecutive ones
+// into batch frames that stay within the client's maxFrameSize.
+func (c *Client) writeNotifications(messages []outboundMessage) error {
+	for len(messages) > 0 {
+		n := c.batchFits(messages)
+		if n < 2 {
+			if err := c.writeNotification(messages[0]); err != nil {
+				return err
+			}
+			messages = messages[1:]
+			continue
+		}
+		if err := c.writeBatch(messages[:n]); err != nil {
+			return err
+		}
+		messages = messages[n:]
+	}
+	return nil
+}
+
+// batchFits returns how many leading messages fit in one batch frame.
+func (c *Client) batchFits(messages []outboundMessage) int {
+	if c.caps.maxFrameSize == 0 {
+		return len(messages)
+	}
+	size := batchFrameOverhead
+	for i, message := range messages {
+		size += len(message.deliveryPayload()) + 1
+		if size > c.caps.maxFrameSize {
+			return i
+		}
+	}
+	return len(messages)
+}
+
+func (c *Client) writeBatch(messages []outboundMessage) error {
+	messageType, data, err := c.encodeFrame(encodeBatchFrame(messages), false)
+	if err != nil {
+		return err
+	}
+	if c.caps.maxFrameSize > 0 && len(data) > c.caps.maxFrameSize {
+		// The estimate was off; fall back to one frame per notification.
+		for _, message := range messages {
+			if err := c.writeNotification(message); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	appMetrics.Count("messages.batched", int64(len(messages)))
+	for _, message := range messages {
+		c.delivered(message)
+	}
+	return nil
+}
+
+// writeNotification sends one notification. One that does not fit in the
+// client's maxFrameSize is sent in chunks to clients that support them and
+// replaced by a frameTooLarge notice for the rest.
+func (c *Client) writeNotification(message outboundMessage) error {
+	messageType, data, err := c.encodeNotification(message)
+	if err != nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+	if c.caps.maxFrameSize > 0 && len(data) > c.caps.maxFrameSize {
+		if c.caps.chunking {
+			if err := c.writeChunks(message, data); err != nil {
+				return err
+			}
+			c.delivered(message)
+			return nil
+		}
+
+		c.logger().Warn("Notification is over the client's maxFrameSize", "notification", message.notificationID, "bytes", len(data), "max_frame_size", c.caps.maxFrameSize)
+		appMetrics.Count("messages.oversized", 1)
+		notice, err := json.Marshal(FrameTooLargeNotice{
+			Type:           "frameTooLarge",
+			NotificationID: message.notificationID,
+			MessageType:    message.messageType,
+			Size:           len(data),
+			MaxFrameSize:   c.caps.maxFrameSize,
+			Message:        "notification exceeds maxFrameSize; declare supportsChunking to receive it in chunks",
+		})
+		if err != nil {
+			return err
+		}
+		return c.writeFrame(notice, false)
+	}
+
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	c.delivered(message)
+	return nil
+}
+
+// writeChunks sends data, an encoded notification frame, as a chunked
+// transfer. The writePump is the only writer, so the transfer's frames are
+// never interleaved with other frames.
+func (c *Client) writeChunks(message outboundMessage, data []byte) error {
+	// base64 turns every 3 bytes into 4 characters.
+	chunkSize := (c.caps.maxFrameSize - chunkFrameOverhead) / 4 * 3
+	chunks := (len(data) + chunkSize - 1) / chunkSize
+	transferID := newNotificationID()
+
+	frames := make([]interface{}, 0, chunks+2)
+	frames = append(frames, ChunkStartFrame{
+		Type:           "chunkStart",
+		TransferID:     transferID,
+		NotificationID: message.notificationID,
+		MessageType:    message.messageType,
+		Size:           len(data),
+		Chunks:         chunks,
+		Encoding:       "base64",
+	})
+	for i := 0; i < chunks; i++ {
+		end := min((i+1)*chunkSize, len(data))
+		frames = append(frames, ChunkFrame{
+			Type:       "chunk",
+			TransferID: transferID,
+			Index:      i,
+			Data:       base64.StdEncoding.EncodeToString(data[i*chunkSize : end]),
+		})
+	}
+	frames = append(frames, ChunkEndFrame{Type: "chunkEnd", TransferID: transferID})
+
+	for _, frame := range frames {
+		payload, err := json.Marshal(frame)
+		if err != nil {
+			return err
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+		if err := c.writeFrame(payload, false); err != nil {
+			return err
+		}
+	}
+	appMetrics.Count("messages.chunked", 1)
+	return nil
+}
+
+// delivered records a notification that reached the socket.
+func (c *Client) delivered(message outboundMessage) {
+	if !message.receivedAt.IsZero() {
+		deliveryLatency.Observe(message.teamID, message.messageType, time.Since(message.receivedAt))
+	}
+	serviceLevels.delivered(message.receivedAt, time.Now())
+	messageFirehose.record(message, c, firehoseWritten)
+	c.acks.sent(message.notificationID, time.Now())
+}
+
+// encodeNotification encodes a notification for the connection, reusing the
+// frame already encoded for other recipients in the same protocol.
+func (c *Client) encodeNotification(message outboundMessage) (int, []byte, error) {
+	if message.fanout == nil || message.links != nil || message.messageType == "" {
+		return c.encodeFrame(message.deliveryPayload(), message.messageType != "")
+	}
+	messageType, data, err := message.fanout.frameFor(c.protocol, message.payload)
+	if err == nil && c.caps.binary {
+		messageType = websocket.BinaryMessage
+	}
+	return messageType, data, err
+}
+
+// encodeFrame applies the connection's protocol and, for clients that
+// declared supportsBinary, sends JSON in binary websocket frames.
+func (c *Client) encodeFrame(payload []byte, notification bool) (int, []byte, error) {
+	messageType, data, err := c.protocol.encodeFrame(payload, notification)
+	if err == nil && c.caps.binary {
+		messageType = websocket.BinaryMessage
+	}
+	return messageType, data, err
+}
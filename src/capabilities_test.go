@@ -0,0 +1,271 @@
+// capabilities_test.go
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCompileCapabilities(t *testing.T) {
+	setupTestAppConfig()
+	yes, no := true, false
+
+	tests := []struct {
+		name     string
+		declared *ClientCapabilities
+		protocol wireProtocol
+		want     clientCapabilities
+		wantErr  string
+	}{
+		{name: "none", protocol: protocolJSONv1, want: clientCapabilities{}},
+		{name: "none with msgpack", protocol: protocolMsgpackV1, want: clientCapabilities{binary: true}},
+		{
+			name:     "all",
+			declared: &ClientCapabilities{SupportsBatching: true, SupportsBinary: &yes, SupportsAck: true, MaxFrameSize: 4096},
+			protocol: protocolJSONv2,
+			want:     clientCapabilities{declared: true, batching: true, binary: true, ack: true, maxFrameSize: 4096},
+		},
+		{name: "text over msgpack", declared: &ClientCapabilities{SupportsBinary: &no}, protocol: protocolMsgpackV1, wantErr: "supportsBinary"},
+		{name: "tiny frames", declared: &ClientCapabilities{MaxFrameSize: 100}, protocol: protocolJSONv1, wantErr: "maxFrameSize"},
+		{name: "negative frames", declared: &ClientCapabilities{MaxFrameSize: -1}, protocol: protocolJSONv1, wantErr: "maxFrameSize"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compileCapabilities(tt.declared, tt.protocol)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error about %s, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func queuedNotification(id, body string) outboundMessage {
+	payload, _ := json.Marshal(Message{NotificationID: id, MessageType: "chat", Body: body})
+	return outboundMessage{payload: payload, messageType: "chat", notificationID: id}
+}
+
+func TestWriteNotifications_Batching(t *testing.T) {
+	tests := []struct {
+		name         string
+		caps         clientCapabilities
+		wantFrames   int
+		wantBatchLen int // messages in the first frame when it is a batch
+	}{
+		{name: "without batching", caps: clientCapabilities{}, wantFrames: 1}, // the rest stay queued
+		{name: "batched", caps: clientCapabilities{batching: true}, wantFrames: 1, wantBatchLen: 3},
+		{name: "split by frame size", caps: clientCapabilities{batching: true, maxFrameSize: 1024}, wantFrames: 2, wantBatchLen: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestAppConfig()
+			conn := newMockConn()
+			client := &Client{conn: conn, teamID: "team-a", userID: "user-1", caps: tt.caps, send: make(chan outboundMessage, 3)}
+
+			body := strings.Repeat("x", 300)
+			client.send <- queuedNotification("n2", body)
+			client.send <- queuedNotification("n3", body)
+			batch, closed := client.collectBatch(queuedNotification("n1", body))
+			if closed {
+				t.Fatal("send channel reported closed")
+			}
+			if err := client.writeNotifications(batch); err != nil {
+				t.Fatalf("writeNotifications failed: %v", err)
+			}
+
+			if len(conn.written) != tt.wantFrames {
+				t.Fatalf("expected %d frames, got %d", tt.wantFrames, len(conn.written))
+			}
+			if tt.wantBatchLen == 0 {
+				return
+			}
+			var frame BatchFrame
+			if err := json.Unmarshal(conn.written[0], &frame); err != nil {
+				t.Fatalf("failed to decode batch: %v", err)
+			}
+			if frame.Type != "batch" || frame.Count != tt.wantBatchLen || len(frame.Messages) != tt.wantBatchLen {
+				t.Fatalf("unexpected batch frame: type=%s count=%d messages=%d", frame.Type, frame.Count, len(frame.Messages))
+			}
+			if tt.caps.maxFrameSize > 0 && len(conn.written[0]) > tt.caps.maxFrameSize {
+				t.Fatalf("batch of %d bytes exceeds maxFrameSize %d", len(conn.written[0]), tt.caps.maxFrameSize)
+			}
+		})
+	}
+}
+
+func TestWriteNotification_ReplacesOversizedFrames(t *testing.T) {
+	setupTestAppConfig()
+	conn := newMockConn()
+	client := &Client{conn: conn, teamID: "team-a", userID: "user-1", caps: clientCapabilities{maxFrameSize: 1024}}
+
+	if err := client.writeNotification(queuedNotification("big", strings.Repeat("x", 2000))); err != nil {
+		t.Fatalf("writeNotification failed: %v", err)
+	}
+
+	var notice FrameTooLargeNotice
+	if err := json.Unmarshal(conn.written[0], &notice); err != nil {
+		t.Fatalf("failed to decode notice: %v", err)
+	}
+	if notice.Type != "frameTooLarge" || notice.NotificationID != "big" || notice.MessageType != "chat" || notice.Size <= 1024 || notice.MaxFrameSize != 1024 || !strings.Contains(notice.Message, "supportsChunking") {
+		t.Fatalf("unexpected notice: %+v", notice)
+	}
+}
+
+func TestWriteNotification_ChunksOversizedFrames(t *testing.T) {
+	setupTestAppConfig()
+	conn := newMockConn()
+	client := &Client{conn: conn, teamID: "team-a", userID: "user-1", protocol: protocolJSONv2, caps: clientCapabilities{chunking: true, maxFrameSize: 1024}}
+
+	message := queuedNotification("big", strings.Repeat("<é>", 1000))
+	if err := client.writeNotification(message); err != nil {
+		t.Fatalf("writeNotification failed: %v", err)
+	}
+
+	var start ChunkStartFrame
+	if err := json.Unmarshal(conn.written[0], &start); err != nil || start.Type != "chunkStart" {
+		t.Fatalf("expected chunkStart, got %s", conn.written[0])
+	}
+	if start.NotificationID != "big" || start.Encoding != "base64" || len(conn.written) != start.Chunks+2 {
+		t.Fatalf("unexpected transfer: %+v with %d frames", start, len(conn.written))
+	}
+
+	var reassembled []byte
+	for i, frame := range conn.written[1 : len(conn.written)-1] {
+		if len(frame) > 1024 {
+			t.Fatalf("chunk %d is %d bytes, over maxFrameSize", i, len(frame))
+		}
+		var chunk ChunkFrame
+		if err := json.Unmarshal(frame, &chunk); err != nil || chunk.Type != "chunk" || chunk.TransferID != start.TransferID || chunk.Index != i {
+			t.Fatalf("unexpected chunk %d: %s", i, frame)
+		}
+		data, err := base64.StdEncoding.DecodeString(chunk.Data)
+		if err != nil {
+			t.Fatalf("chunk %d is not base64: %v", i, err)
+		}
+		reassembled = append(reassembled, data...)
+	}
+
+	var end ChunkEndFrame
+	if err := json.Unmarshal(conn.written[len(conn.written)-1], &end); err != nil || end.Type != "chunkEnd" || end.TransferID != start.TransferID {
+		t.Fatalf("expected chunkEnd, got %s", conn.written[len(conn.written)-1])
+	}
+	_, want, _ := protocolJSONv2.encodeFrame(message.payload, true)
+	if string(reassembled) != string(want) || start.Size != len(want) {
+		t.Fatalf("reassembled frame does not match the notification frame")
+	}
+}
+
+func TestAckTracker(t *testing.T) {
+	acks := newAckTracker()
+	start := time.Now()
+	acks.sent("n1", start)
+	acks.sent("n2", start)
+	acks.sent("", start)
+
+	if acked := acks.ack([]string{"n1", "n1", "unknown"}, start.Add(time.Second)); acked != 1 {
+		t.Fatalf("expected one pending notification acknowledged, got %d", acked)
+	}
+	if missed := acks.expire(start); missed != 0 {
+		t.Fatalf("expected nothing sent before the cutoff, got %d", missed)
+	}
+	if missed := acks.expire(start.Add(time.Minute)); missed != 1 {
+		t.Fatalf("expected n2 to be missed, got %d", missed)
+	}
+
+	var disabled *ackTracker
+	disabled.sent("n1", start)
+	if disabled.ack([]string{"n1"}, start) != 0 || disabled.expire(start) != 0 {
+		t.Fatal("nil tracker must do nothing")
+	}
+}
+
+func TestHandleWebSocket_Capabilities(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Environment.Mode = "development"
+	AppConfig().Environment.EnableFakeAuth = true
+	authFailures = nil
+	hub := newHub()
+	go hub.run()
+	wsURL := newWebSocketTestServer(t, hub)
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer ws.Close()
+
+	auth := `{"type":"auth","teamId":"team-c","userId":"user-c","token":"fake_development_token",` +
+		`"capabilities":{"supportsBinary":true,"supportsAck":true,"maxFrameSize":2048}}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(auth)); err != nil {
+		t.Fatalf("failed to send auth: %v", err)
+	}
+
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read auth reply: %v", err)
+	}
+	var reply struct {
+		Type         string           `json:"type"`
+		Capabilities capabilitiesView `json:"capabilities"`
+	}
+	if err := json.Unmarshal(data, &reply); err != nil || reply.Type != "authSuccess" {
+		t.Fatalf("expected authSuccess, got %s", data)
+	}
+	if !reply.Capabilities.SupportsBinary || !reply.Capabilities.SupportsAck || reply.Capabilities.MaxFrameSize != 2048 || reply.Capabilities.AckTimeoutSeconds != 30 {
+		t.Fatalf("unexpected capabilities echoed: %+v", reply.Capabilities)
+	}
+
+	// authSuccess is written once the client is registered.
+	clients := hub.snapshotTeamClients("team-c")
+	if len(clients) != 1 {
+		t.Fatal("client was not registered")
+	}
+	client := clients[0]
+	hub.broadcastToTeam("team-c", queuedNotification("n1", "hello"))
+
+	messageType, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read notification: %v", err)
+	}
+	if messageType != websocket.BinaryMessage || !strings.Contains(string(data), `"notificationId":"n1"`) {
+		t.Fatalf("expected the notification as a binary JSON frame, got type %d: %s", messageType, data)
+	}
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"ack","notificationIds":["n1"]}`)); err != nil {
+		t.Fatalf("failed to send ack: %v", err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		client.acks.mu.Lock()
+		pending := len(client.acks.pending)
+		client.acks.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the ack to clear the pending notification")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The connection stays open after an ack.
+	hub.broadcastToTeam("team-c", queuedNotification("n2", "again"))
+	if _, data, err := ws.ReadMessage(); err != nil || !strings.Contains(string(data), `"notificationId":"n2"`) {
+		t.Fatalf("expected a second notification after the ack, got %s, %v", data, err)
+	}
+}
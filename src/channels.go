@@ -0,0 +1,170 @@
+// channels.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// maxChannelNameLength bounds the name of a channel.
+const maxChannelNameLength = 64
+
+// channelNamePattern is what a channel may be called, such as "project-42"
+// or "alerts:billing".
+var channelNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:\-]*$`)
+
+// errTooManyChannels refuses a subscribe beyond limits.max_channels_per_client.
+var errTooManyChannels = errors.New("too many channel subscriptions")
+
+// errNotRegistered refuses a subscribe from a connection that has already
+// left the roster.
+var errNotRegistered = errors.New("connection is not registered")
+
+func init() {
+	registerClientFrame("subscribe", func() clientFrame { return &ChannelFrame{} }, handleChannelFrame)
+	registerClientFrame("unsubscribe", func() clientFrame { return &ChannelFrame{} }, handleChannelFrame)
+}
+
+func validateChannelName(name string) error {
+	if len(name) > maxChannelNameLength || !channelNamePattern.MatchString(name) {
+		return fmt.Errorf("channel must be 1-%d letters, digits, '_', '.', ':' or '-', starting with a letter or digit", maxChannelNameLength)
+	}
+	return nil
+}
+
+func (f *ChannelFrame) validate(c *Client) error {
+	if c.teamID == statsTeamID {
+		return errors.New(statsTeamID + " has no channels")
+	}
+	return validateChannelName(f.Channel)
+}
+
+// handleChannelFrame subscribes or unsubscribes the connection and answers
+// with the channels it is subscribed to.
+func handleChannelFrame(c *Client, frame clientFrame) {
+	request := frame.(*ChannelFrame)
+	reply := ChannelSubscriptionsFrame{Channel: request.Channel}
+	var err error
+	if request.Type == "subscribe" {
+		reply.Type = "subscribed"
+		reply.Channels, err = c.hub.Subscribe(c, request.Channel)
+	} else {
+		reply.Type = "unsubscribed"
+		reply.Channels = c.hub.Unsubscribe(c, request.Channel)
+	}
+	if err != nil {
+		c.logger().Warn("Channel subscription refused", "channel", request.Channel, "error", err)
+		reply.Error = err.Error()
+	} else {
+		appMetrics.Count("channels."+reply.Type, 1, tenantTags(c.tenantID)...)
+	}
+	if reply.Channels == nil {
+		reply.Channels = []string{}
+	}
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		c.logger().Error("Failed to encode channel subscriptions", "error", err)
+		return
+	}
+	c.hub.SendControl(c, outboundMessage{payload: payload})
+}
+
+// subscribe adds client to channel in its team, up to limit channels per
+// connection, and returns the channels it is subscribed to.
+func (h *Hub) subscribe(client *Client, channel string, limit int) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[client.teamID][client.userID][client]; !ok {
+		return nil, errNotRegistered
+	}
+	if _, ok := client.channels[channel]; !ok {
+		if len(client.channels) >= limit {
+			return subscribedChannelsLocked(client), errTooManyChannels
+		}
+		if client.channels == nil {
+			client.channels = make(map[string]struct{})
+		}
+		client.channels[channel] = struct{}{}
+		if h.channels == nil {
+			h.channels = make(map[string]map[string]map[*Client]struct{})
+		}
+		if h.channels[client.teamID] == nil {
+			h.channels[client.teamID] = make(map[string]map[*Client]struct{})
+		}
+		if h.channels[client.teamID][channel] == nil {
+			h.channels[client.teamID][channel] = make(map[*Client]struct{})
+		}
+		h.channels[client.teamID][channel][client] = struct{}{}
+	}
+	return subscribedChannelsLocked(client), nil
+}
+
+// unsubscribe removes client from channel and returns the channels it is
+// still subscribed to.
+func (h *Hub) unsubscribe(client *Client, channel string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.unsubscribeLocked(client, channel)
+	return subscribedChannelsLocked(client)
+}
+
+func (h *Hub) unsubscribeLocked(client *Client, channel string) {
+	if _, ok := client.channels[channel]; !ok {
+		return
+	}
+	delete(client.channels, channel)
+	teamChannels := h.channels[client.teamID]
+	delete(teamChannels[channel], client)
+	if len(teamChannels[channel]) == 0 {
+		delete(teamChannels, channel)
+	}
+	if len(teamChannels) == 0 {
+		delete(h.channels, client.teamID)
+	}
+}
+
+// unsubscribeAllLocked drops a departing client's subscriptions.
+func (h *Hub) unsubscribeAllLocked(client *Client) {
+	for channel := range client.channels {
+		h.unsubscribeLocked(client, channel)
+	}
+}
+
+func subscribedChannelsLocked(client *Client) []string {
+	channels := make([]string, 0, len(client.channels))
+	for channel := range client.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func (h *Hub) snapshotChannelClients(teamID, channel string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(h.channels[teamID][channel]))
+	for client := range h.channels[teamID][channel] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// publishToChannel delivers message to the subscribers of channel in
+// teamID. Subscriptions do not survive a handover, so nothing is held for
+// sessions migrating away.
+func (h *Hub) publishToChannel(teamID, channel string, message outboundMessage) int {
+	message.channel = channel
+	count := 0
+	for _, client := range h.snapshotChannelClients(teamID, channel) {
+		if h.enqueueMessage(client, message) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,123 @@
+// channels_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestChannelSubscriptions(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Limits.MaxChannelsPerClient = 2
+	hub := newHub()
+	newMember := func(userID string) *Client {
+		return &Client{hub: hub, teamID: "team-1", userID: userID, send: make(chan outboundMessage, 4), control: make(chan outboundMessage, 4)}
+	}
+	alice, bob := newMember("alice"), newMember("bob")
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {alice: {}}, "bob": {bob: {}}}
+
+	frame := func(c *Client, data string) ChannelSubscriptionsFrame {
+		t.Helper()
+		if err := c.handleFrame(websocket.TextMessage, []byte(data)); err != nil {
+			t.Fatalf("expected %s to be accepted, got %v", data, err)
+		}
+		var reply ChannelSubscriptionsFrame
+		if err := json.Unmarshal((<-c.control).payload, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	if reply := frame(alice, `{"type":"subscribe","channel":"project-42"}`); reply.Type != "subscribed" || !reflect.DeepEqual(reply.Channels, []string{"project-42"}) {
+		t.Fatalf("unexpected reply %+v", reply)
+	}
+	frame(alice, `{"type":"subscribe","channel":"alerts"}`)
+	if reply := frame(alice, `{"type":"subscribe","channel":"random"}`); reply.Error == "" || !reflect.DeepEqual(reply.Channels, []string{"alerts", "project-42"}) {
+		t.Fatalf("expected the third channel to be refused, got %+v", reply)
+	}
+	frame(bob, `{"type":"subscribe","channel":"alerts"}`)
+
+	if delivered := hub.publishToChannel("team-1", "project-42", outboundMessage{payload: []byte(`{}`)}); delivered != 1 || len(alice.send) != 1 || len(bob.send) != 0 {
+		t.Fatalf("expected only alice to receive project-42, delivered %d", delivered)
+	}
+	if delivered := hub.publishToChannel("team-1", "alerts", outboundMessage{payload: []byte(`{}`)}); delivered != 2 {
+		t.Fatalf("expected both subscribers to receive alerts, delivered %d", delivered)
+	}
+	if delivered := hub.publishToChannel("team-2", "alerts", outboundMessage{payload: []byte(`{}`)}); delivered != 0 {
+		t.Fatalf("expected channels to be scoped to their team, delivered %d", delivered)
+	}
+
+	if reply := frame(alice, `{"type":"unsubscribe","channel":"project-42"}`); reply.Type != "unsubscribed" || !reflect.DeepEqual(reply.Channels, []string{"alerts"}) {
+		t.Fatalf("unexpected reply %+v", reply)
+	}
+	if _, ok := hub.channels["team-1"]["project-42"]; ok {
+		t.Fatal("expected the empty channel to be dropped")
+	}
+
+	hub.removeClient(alice)
+	hub.removeClient(bob)
+	if len(hub.channels) != 0 {
+		t.Fatalf("expected departing clients to leave their channels, got %v", hub.channels)
+	}
+	if _, err := hub.subscribe(alice, "alerts", 2); err != errNotRegistered {
+		t.Fatalf("expected a departed client to be refused, got %v", err)
+	}
+
+	for data, want := range map[string]string{
+		`{"type":"subscribe","channel":""}`:                                  "channel must be",
+		`{"type":"subscribe","channel":"-leading"}`:                          "channel must be",
+		`{"type":"subscribe","channel":"with space"}`:                        "channel must be",
+		`{"type":"unsubscribe","channel":"` + strings.Repeat("c", 65) + `"}`: "channel must be",
+	} {
+		if err := bob.handleFrame(websocket.TextMessage, []byte(data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %s to be rejected with %q, got %v", data, want, err)
+		}
+	}
+}
+
+func TestHandleSendMessage_TargetChannel(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	alice := &Client{teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 4), channels: map[string]struct{}{"alerts": {}}}
+	bob := &Client{teamID: "team-1", userID: "bob", send: make(chan outboundMessage, 4)}
+	hub.Register(alice)
+	hub.Register(bob)
+
+	rr := httptest.NewRecorder()
+	handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(`{"target_team_id":"team-1","target_channel":"alerts","message_type":"alert","body":"disk full"}`)))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"delivered":1`) {
+		t.Fatalf("expected one delivery, got %d %s", rr.Code, rr.Body.String())
+	}
+	if len(alice.send) != 1 || len(bob.send) != 0 {
+		t.Fatalf("expected only the subscriber to receive it, got %d and %d", len(alice.send), len(bob.send))
+	}
+	var message Message
+	if err := json.Unmarshal((<-alice.send).payload, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Channel != "alerts" {
+		t.Fatalf("expected the message to name its channel, got %+v", message)
+	}
+	if !reflect.DeepEqual(hub.broadcasts, []string{"team-1#alerts"}) {
+		t.Fatalf("expected a channel publish, got %v", hub.broadcasts)
+	}
+
+	for body, want := range map[string]string{
+		`{"target_channel":"alerts","message_type":"alert","body":"x"}`:                                                  "requires target_team_id",
+		`{"target_team_id":"team-1","target_channel":"alerts","broadcast":true,"message_type":"alert","body":"x"}`:       "cannot specify broadcast",
+		`{"target_team_id":"team-1","target_channel":"alerts","target_user_id":"bob","message_type":"alert","body":"x"}`: "cannot specify broadcast",
+		`{"target_team_id":"team-1","target_channel":"no way","message_type":"alert","body":"x"}`:                        "target_channel: channel must be",
+	} {
+		rr := httptest.NewRecorder()
+		handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected %s to be rejected with %q, got %d %s", body, want, rr.Code, rr.Body.String())
+		}
+	}
+}
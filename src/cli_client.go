@@ -0,0 +1,280 @@
+// cli_client.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const cliClientHelp = `Commands:
+  /ack <id>...           acknowledge notifications
+  /read <conv> [id]      mark a conversation read, up to the notification id
+  /subscribe <channel>   subscribe to a channel of the team
+  /unsubscribe <channel> unsubscribe from a channel
+  /request <method> [json]
+                         send a request frame with optional JSON params
+  /raw <json>            send a raw JSON frame (the server closes the connection on unknown types)
+  /send <user> <body>    deliver a notification to a user through POST /send (needs -api-key)
+  /broadcast <body>      broadcast a notification to the team through POST /send (needs -api-key)
+  /quit                  close the connection
+`
+
+// cliClient is the state behind the "client" subcommand, a small interactive
+// websocket client for manual testing.
+type cliClient struct {
+	wsURL  string
+	teamID string
+	userID string
+	apiKey string
+
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	out     io.Writer
+	outMu   sync.Mutex
+	http    *http.Client
+}
+
+// runClientCommand implements `notification-server client [flags]` and
+// returns the process exit code.
+func runClientCommand(args []string, stdin io.Reader, stdout io.Writer) int {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	wsURL := flags.String("url", "ws://localhost:8081/ws", "websocket endpoint to connect to")
+	teamID := flags.String("team", "", "team to authenticate against (required)")
+	userID := flags.String("user", "", "user ID (required with the fake development token)")
+	token := flags.String("token", "fake_development_token", "JWT, or the fake development token")
+	apiKey := flags.String("api-key", "", "API key for /send and /broadcast commands")
+	origin := flags.String("origin", "", "Origin header to present, for servers that restrict origins")
+	flags.Usage = func() {
+		fmt.Fprintf(stdout, "Usage: notification-server client -team <team> [-user <user>] [flags]\n\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(stdout, "\n%s", cliClientHelp)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *teamID == "" {
+		flags.Usage()
+		return 2
+	}
+
+	client := &cliClient{
+		wsURL:  *wsURL,
+		teamID: *teamID,
+		userID: *userID,
+		apiKey: *apiKey,
+		out:    stdout,
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	header := http.Header{}
+	if *origin != "" {
+		header.Set("Origin", *origin)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(*wsURL, header)
+	if err != nil {
+		fmt.Fprintf(stdout, "❌ Failed to connect to %s: %v\n", *wsURL, err)
+		return 1
+	}
+	client.conn = conn
+	defer conn.Close()
+
+	if err := client.writeJSON(AuthMessage{Type: "auth", TeamID: *teamID, UserID: *userID, Token: *token}); err != nil {
+		fmt.Fprintf(stdout, "❌ Failed to send auth: %v\n", err)
+		return 1
+	}
+	client.printf("🔌 Connected to %s as team=%s user=%s (type /help for commands)\n", *wsURL, *teamID, *userID)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.readLoop()
+	}()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return 0
+		case line, ok := <-lines:
+			if !ok || !client.handleLine(line) {
+				client.writeMu.Lock()
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				client.writeMu.Unlock()
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+				}
+				return 0
+			}
+		}
+	}
+}
+
+func (c *cliClient) printf(format string, args ...interface{}) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	fmt.Fprintf(c.out, format, args...)
+}
+
+func (c *cliClient) writeJSON(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+func (c *cliClient) readLoop() {
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				c.printf("🔌 Connection closed\n")
+			} else {
+				c.printf("🔌 Connection closed: %v\n", err)
+			}
+			return
+		}
+
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, message, "", "  ") == nil {
+			message = pretty.Bytes()
+		}
+		c.printf("← %s %s\n", time.Now().Format("15:04:05.000"), message)
+	}
+}
+
+// handleLine runs one line of input and reports whether to keep going.
+func (c *cliClient) handleLine(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true
+	}
+
+	command, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+
+	var err error
+	switch {
+	case !strings.HasPrefix(line, "/"):
+		c.printf("❌ clients cannot send messages over the websocket; use /send or /broadcast (type /help)\n")
+		return true
+	case command == "/quit" || command == "/exit":
+		return false
+	case command == "/help":
+		c.printf("%s", cliClientHelp)
+	case command == "/ack":
+		ids := strings.Fields(rest)
+		if len(ids) == 0 {
+			c.printf("❌ usage: /ack <id>...\n")
+			return true
+		}
+		err = c.writeJSON(AckFrame{Type: "ack", NotificationIDs: ids})
+	case command == "/read":
+		conversation, id, _ := strings.Cut(rest, " ")
+		if conversation == "" {
+			c.printf("❌ usage: /read <conversation> [notification id]\n")
+			return true
+		}
+		err = c.writeJSON(ReadReceiptFrame{Type: "read", ConversationID: conversation, NotificationID: strings.TrimSpace(id)})
+	case command == "/subscribe" || command == "/unsubscribe":
+		if rest == "" {
+			c.printf("❌ usage: %s <channel>\n", command)
+			return true
+		}
+		err = c.writeJSON(ChannelFrame{Type: strings.TrimPrefix(command, "/"), Channel: rest})
+	case command == "/request":
+		method, params, _ := strings.Cut(rest, " ")
+		params = strings.TrimSpace(params)
+		if method == "" || (params != "" && !json.Valid([]byte(params))) {
+			c.printf("❌ usage: /request <method> [json params]\n")
+			return true
+		}
+		err = c.writeJSON(ClientRequestFrame{Type: "request", RequestID: newNotificationID(), Method: method, Params: json.RawMessage(params)})
+	case command == "/raw":
+		if !json.Valid([]byte(rest)) {
+			c.printf("❌ /raw needs a JSON frame\n")
+			return true
+		}
+		c.writeMu.Lock()
+		err = c.conn.WriteMessage(websocket.TextMessage, []byte(rest))
+		c.writeMu.Unlock()
+	case command == "/send":
+		user, body, _ := strings.Cut(rest, " ")
+		if user == "" || body == "" {
+			c.printf("❌ usage: /send <user> <body>\n")
+			return true
+		}
+		err = c.postNotification(MessageRequest{TargetTeamID: c.teamID, TargetUserID: user, Body: body})
+	case command == "/broadcast":
+		if rest == "" {
+			c.printf("❌ usage: /broadcast <body>\n")
+			return true
+		}
+		err = c.postNotification(MessageRequest{TargetTeamID: c.teamID, Body: rest, Broadcast: true})
+	default:
+		c.printf("❌ unknown command %s (type /help)\n", command)
+		return true
+	}
+
+	if err != nil {
+		c.printf("❌ %v\n", err)
+	}
+	return true
+}
+
+// postNotification sends a notification through the REST API, deriving its
+// address from the websocket URL.
+func (c *cliClient) postNotification(req MessageRequest) error {
+	if c.apiKey == "" {
+		return fmt.Errorf("-api-key is required to send notifications")
+	}
+	req.SenderUserID = firstNonEmpty(c.userID, "cli")
+	req.MessageType = "user_message"
+	req.NotificationID = newNotificationID()
+
+	endpoint, err := url.Parse(c.wsURL)
+	if err != nil {
+		return err
+	}
+	endpoint.Scheme = strings.Replace(endpoint.Scheme, "ws", "http", 1)
+	endpoint.Path = "/send"
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-API-Key", c.apiKey)
+
+	res, err := c.http.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	response, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	c.printf("→ POST %s: %s %s\n", endpoint.Path, res.Status, strings.TrimSpace(string(response)))
+	return nil
+}
@@ -0,0 +1,100 @@
+// cli_client_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRunClientCommand(t *testing.T) {
+	received := make(chan map[string]interface{}, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var frame map[string]interface{}
+			json.Unmarshal(data, &frame)
+			received <- frame
+			if frame["type"] == "auth" {
+				conn.WriteJSON(map[string]string{"type": "authSuccess"})
+			}
+		}
+	}))
+	defer server.Close()
+
+	stdinReader, stdinWriter := io.Pipe()
+	var stdout lockedBuffer
+	exited := make(chan int, 1)
+	go func() {
+		exited <- runClientCommand([]string{"-url", "ws" + strings.TrimPrefix(server.URL, "http"), "-team", "team-a", "-user", "user-1"}, stdinReader, &stdout)
+	}()
+
+	auth := <-received
+	if auth["type"] != "auth" || auth["teamId"] != "team-a" || auth["userId"] != "user-1" || auth["token"] != "fake_development_token" {
+		t.Fatalf("unexpected auth frame: %v", auth)
+	}
+
+	// Plain text is not a frame the server accepts, so nothing is sent for it.
+	io.WriteString(stdinWriter, "hello there\n/ack n1 n2\n/subscribe alerts\n")
+	if ack := <-received; ack["type"] != "ack" || fmt.Sprint(ack["notificationIds"]) != "[n1 n2]" {
+		t.Fatalf("unexpected ack frame: %v", ack)
+	}
+	if subscribe := <-received; subscribe["type"] != "subscribe" || subscribe["channel"] != "alerts" {
+		t.Fatalf("unexpected subscribe frame: %v", subscribe)
+	}
+
+	io.WriteString(stdinWriter, "/quit\n")
+	if code := <-exited; code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	stdinWriter.Close()
+
+	if !strings.Contains(stdout.String(), `"type": "authSuccess"`) || !strings.Contains(stdout.String(), "use /send or /broadcast") {
+		t.Fatalf("expected incoming frames and the plain text hint to be printed, got %s", stdout.String())
+	}
+}
+
+func TestRunClientCommand_RequiresTeam(t *testing.T) {
+	var stdout bytes.Buffer
+	if code := runClientCommand(nil, strings.NewReader(""), &stdout); code != 2 {
+		t.Fatalf("expected usage exit code 2, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "Usage: notification-server client") {
+		t.Fatalf("expected usage output, got %s", stdout.String())
+	}
+}
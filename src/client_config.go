@@ -0,0 +1,161 @@
+// client_config.go
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// preferredProtocol is the subprotocol /client-config tells new frontends
+// to offer first.
+const preferredProtocol = protocolJSONv2
+
+// clientConfigSignatureHeader carries the base64 Ed25519 signature of the
+// exact /client-config response body.
+const clientConfigSignatureHeader = "X-Client-Config-Signature"
+
+// clientConfigSigner signs /client-config responses; nil leaves them
+// unsigned. It is loaded from client_config.signing_key_file in main().
+var clientConfigSigner ed25519.PrivateKey
+
+// clientConfigDocument is the GET /client-config response.
+type clientConfigDocument struct {
+	Version         string          `json:"version"`          // Hash of the other fields; changes whenever they do
+	KeyID           string          `json:"key_id,omitempty"` // Identifies the signing key, for rotations
+	WebSocketURL    string          `json:"websocket_url"`
+	ProtocolVersion wireProtocol    `json:"protocol_version"` // Subprotocol to offer first
+	Protocols       []wireProtocol  `json:"protocols"`        // Every subprotocol the server accepts
+	Heartbeat       clientHeartbeat `json:"heartbeat"`
+	MaxMessageSize  int64           `json:"max_message_size"` // Largest frame a client may send after auth
+	Features        map[string]bool `json:"features"`
+}
+
+// clientHeartbeat tells clients how the server checks that they are alive:
+// a ping every PingIntervalMS, which must be answered within PongTimeoutMS.
+type clientHeartbeat struct {
+	PingIntervalMS int64 `json:"ping_interval_ms"`
+	PongTimeoutMS  int64 `json:"pong_timeout_ms"`
+}
+
+// builtinClientFeatures are the flags the server derives from its own
+// config. client_config.features may not redefine them.
+var builtinClientFeatures = []string{"attachments", "conversations", "handover"}
+
+// loadClientConfigSigner reads a PKCS#8 PEM Ed25519 private key, as written
+// by `openssl genpkey -algorithm ed25519`.
+func loadClientConfigSigner(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key is a %T, not an Ed25519 key", key)
+	}
+	return signer, nil
+}
+
+// clientConfigKeyID is the first 16 hex characters of the SHA-256 of the
+// public key, so clients pinning several keys know which one to verify with.
+func clientConfigKeyID(signer ed25519.PrivateKey) string {
+	sum := sha256.Sum256(signer.Public().(ed25519.PublicKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// buildClientConfig describes the server to a frontend that reached it
+// through r. Without client_config.websocket_url, the URL is derived from the
+// request's host, which is only right when no proxy rewrites it. Signed
+// responses always carry the configured URL, as validateConfig requires it
+// with a signing key.
+func buildClientConfig(r *http.Request) clientConfigDocument {
+	doc := clientConfigDocument{
+		WebSocketURL:    AppConfig().ClientConfig.WebSocketURL,
+		ProtocolVersion: preferredProtocol,
+		Protocols:       supportedProtocols,
+		Heartbeat: clientHeartbeat{
+			PingIntervalMS: AppConfig().WebSocket.PingPeriod.Milliseconds(),
+			PongTimeoutMS:  AppConfig().WebSocket.PongWait.Milliseconds(),
+		},
+		MaxMessageSize: AppConfig().WebSocket.MaxMessageSize,
+		Features: map[string]bool{
+			"attachments":   AppConfig().Attachments.Provider != "none",
+			"conversations": AppConfig().Conversations.Enabled,
+			"handover":      AppConfig().Handover.PeerURL != "",
+		},
+	}
+	if doc.WebSocketURL == "" {
+		scheme := "ws"
+		if r.TLS != nil {
+			scheme = "wss"
+		}
+		doc.WebSocketURL = scheme + "://" + r.Host + "/ws"
+	}
+	for name, enabled := range AppConfig().ClientConfig.Features {
+		doc.Features[name] = enabled
+	}
+	if clientConfigSigner != nil {
+		doc.KeyID = clientConfigKeyID(clientConfigSigner)
+	}
+	return doc
+}
+
+// handleClientConfig serves GET /client-config, which frontends fetch to
+// bootstrap instead of hardcoding connection parameters. It needs no API
+// key. The version doubles as the ETag, and with a signing key the body is
+// signed, so a client can reject config that did not come from the server.
+func handleClientConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	doc := buildClientConfig(r)
+	unversioned, err := json.Marshal(doc)
+	if err != nil {
+		slog.Error("Failed to encode the client config", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(unversioned)
+	doc.Version = hex.EncodeToString(sum[:8])
+	body, err := json.Marshal(doc)
+	if err != nil {
+		slog.Error("Failed to encode the client config", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	etag := `"` + doc.Version + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if clientConfigSigner != nil {
+		w.Header().Set(clientConfigSignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(clientConfigSigner, body)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		slog.Error("Failed to write the response", "error", err)
+	}
+}
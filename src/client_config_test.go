@@ -0,0 +1,121 @@
+// client_config_test.go
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandleClientConfig(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Conversations.Enabled = true
+	AppConfig().ClientConfig.Features = map[string]bool{"darkMode": true}
+
+	req := httptest.NewRequest("GET", "/client-config", nil)
+	req.Host = "notify.example.com"
+	req.TLS = &tls.ConnectionState{}
+	rr := httptest.NewRecorder()
+	handleClientConfig(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get(clientConfigSignatureHeader) != "" {
+		t.Fatal("expected no signature without a signing key")
+	}
+	var doc clientConfigDocument
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if doc.WebSocketURL != "wss://notify.example.com/ws" {
+		t.Errorf("expected the URL derived from the request, got %q", doc.WebSocketURL)
+	}
+	if doc.ProtocolVersion != protocolJSONv2 || len(doc.Protocols) != len(supportedProtocols) {
+		t.Errorf("unexpected protocols: %q %v", doc.ProtocolVersion, doc.Protocols)
+	}
+	if doc.Heartbeat.PingIntervalMS != AppConfig().WebSocket.PingPeriod.Milliseconds() || doc.Heartbeat.PongTimeoutMS != AppConfig().WebSocket.PongWait.Milliseconds() {
+		t.Errorf("unexpected heartbeat: %+v", doc.Heartbeat)
+	}
+	if !doc.Features["conversations"] || doc.Features["attachments"] || !doc.Features["darkMode"] {
+		t.Errorf("unexpected features: %v", doc.Features)
+	}
+	if doc.Version == "" || rr.Header().Get("ETag") != `"`+doc.Version+`"` {
+		t.Fatalf("expected the version as ETag, got %q and %q", doc.Version, rr.Header().Get("ETag"))
+	}
+
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+	rr = httptest.NewRecorder()
+	handleClientConfig(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for an unchanged version, got %d", rr.Code)
+	}
+
+	AppConfig().ClientConfig.Features["darkMode"] = false
+	rr = httptest.NewRecorder()
+	handleClientConfig(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected a new version after a flag changed, got %d", rr.Code)
+	}
+}
+
+func TestHandleClientConfig_Signed(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().ClientConfig.WebSocketURL = "wss://push.example.com/ws"
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "signing.pem")
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	clientConfigSigner, err = loadClientConfigSigner(keyFile)
+	if err != nil {
+		t.Fatalf("failed to load the key: %v", err)
+	}
+	defer func() { clientConfigSigner = nil }()
+
+	rr := httptest.NewRecorder()
+	handleClientConfig(rr, httptest.NewRequest("GET", "/client-config", nil))
+
+	signature, err := base64.StdEncoding.DecodeString(rr.Header().Get(clientConfigSignatureHeader))
+	if err != nil || !ed25519.Verify(public, rr.Body.Bytes(), signature) {
+		t.Fatalf("expected a valid signature of the body, got %q", rr.Header().Get(clientConfigSignatureHeader))
+	}
+	var doc clientConfigDocument
+	json.Unmarshal(rr.Body.Bytes(), &doc)
+	if doc.KeyID != clientConfigKeyID(clientConfigSigner) || doc.WebSocketURL != "wss://push.example.com/ws" {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+}
+
+func TestLoadConfig_ClientConfigSigningNeedsURL(t *testing.T) {
+	configFile, cleanup := createTempConfigFile(t, `
+security:
+  api_key: "test-key"
+backend:
+  url: "http://localhost:8000"
+client_config:
+  signing_key_file: "/etc/notify/client-config.pem"
+`)
+	defer cleanup()
+
+	err := LoadConfig(configFile)
+	if err == nil || !strings.Contains(err.Error(), "client_config.signing_key_file requires client_config.websocket_url") {
+		t.Fatalf("expected signing a Host-derived URL to be refused, got %v", err)
+	}
+}
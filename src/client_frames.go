@@ -0,0 +1,156 @@
+// client_frames.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// clientFrame is a frame an authenticated client may send, decoded into its
+// registered struct. validate rejects a frame that decoded but cannot be
+// used by c; rejected frames count as malformed.
+type clientFrame interface {
+	validate(c *Client) error
+}
+
+// clientFrameHandler acts on a frame that passed validation. frame is the
+// value the type's newFrame returned.
+type clientFrameHandler func(c *Client, frame clientFrame)
+
+// clientFrameType is the struct a frame type decodes into and its handler.
+type clientFrameType struct {
+	newFrame func() clientFrame
+	handle   clientFrameHandler
+}
+
+// clientFrameEnvelope is decoded first to find a frame's registered type.
+type clientFrameEnvelope struct {
+	Type string `json:"type"`
+}
+
+var (
+	clientFrameTypesMu sync.RWMutex
+	clientFrameTypes   = map[string]clientFrameType{
+		"ack":     {newFrame: func() clientFrame { return &AckFrame{} }, handle: handleAckFrame},
+		"read":    {newFrame: func() clientFrame { return &ReadReceiptFrame{} }, handle: handleReadReceiptFrame},
+		"request": {newFrame: func() clientFrame { return &ClientRequestFrame{} }, handle: handleClientRequestFrame},
+	}
+)
+
+// registerClientFrame accepts frames whose type is frameType from clients.
+// Each frame is decoded into a fresh newFrame(), validated and passed to
+// handle on the connection's read pump, so handle must not block. Plugins are
+// compiled in: add a file to this package that registers its frames from
+// init(). Registering a type twice panics.
+func registerClientFrame(frameType string, newFrame func() clientFrame, handle clientFrameHandler) {
+	clientFrameTypesMu.Lock()
+	defer clientFrameTypesMu.Unlock()
+	if _, ok := clientFrameTypes[frameType]; ok {
+		panic("client frame type " + frameType + " is already registered")
+	}
+	clientFrameTypes[frameType] = clientFrameType{newFrame: newFrame, handle: handle}
+}
+
+func lookupClientFrame(frameType string) (clientFrameType, bool) {
+	clientFrameTypesMu.RLock()
+	defer clientFrameTypesMu.RUnlock()
+	registered, ok := clientFrameTypes[frameType]
+	return registered, ok
+}
+
+// handleFrame decodes a frame from the client and runs its type's handler.
+// It returns an error, and counts the frame in ws.frames.rejected, when the
+// frame has no registered type or fails to decode or validate.
+func (c *Client) handleFrame(messageType int, data []byte) error {
+	payload, err := c.protocol.decodeFrame(messageType, data)
+	if err != nil {
+		return c.rejectFrame("", err)
+	}
+	var envelope clientFrameEnvelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return c.rejectFrame("", err)
+	}
+	registered, ok := lookupClientFrame(envelope.Type)
+	if !ok {
+		return c.rejectFrame("", fmt.Errorf("unknown frame type %q", envelope.Type))
+	}
+	frame := registered.newFrame()
+	if err := json.Unmarshal(payload, frame); err != nil {
+		return c.rejectFrame(envelope.Type, err)
+	}
+	if err := frame.validate(c); err != nil {
+		return c.rejectFrame(envelope.Type, err)
+	}
+	appMetrics.Count("ws.frames", 1, tenantTags(c.tenantID, metricTag("type", envelope.Type))...)
+	registered.handle(c, frame)
+	if c.teamID != statsTeamID {
+		teamWebhooks.notify(c.tenantID, c.teamID, teamWebhookEvent{Event: teamEventMessage, Type: envelope.Type, UserID: c.userID, Frame: payload})
+	}
+	return nil
+}
+
+// rejectFrame counts a rejected frame. frameType is empty when the frame has
+// no registered type, which keeps client-chosen values out of metric tags.
+func (c *Client) rejectFrame(frameType string, err error) error {
+	appMetrics.Count("ws.frames.rejected", 1, tenantTags(c.tenantID, metricTag("type", firstNonEmpty(frameType, "unknown")))...)
+	if frameType != "" {
+		return fmt.Errorf("invalid %s frame: %v", frameType, err)
+	}
+	return err
+}
+
+func (f *AckFrame) validate(c *Client) error {
+	if c.acks == nil {
+		return errors.New("client did not declare supportsAck")
+	}
+	if len(f.NotificationIDs) == 0 {
+		return errors.New("notificationIds is required")
+	}
+	return nil
+}
+
+func handleAckFrame(c *Client, frame clientFrame) {
+	ack := frame.(*AckFrame)
+	now := time.Now()
+	c.acks.ack(ack.NotificationIDs, now)
+	notificationEscalations.acknowledge(c, ack.NotificationIDs, now)
+	onCallSchedules.acknowledge(c, ack.NotificationIDs)
+}
+
+func (f *ReadReceiptFrame) validate(c *Client) error {
+	if conversationReads == nil {
+		return errors.New("conversation tracking is not enabled")
+	}
+	if f.ConversationID == "" {
+		return errors.New("conversationId is required")
+	}
+	return nil
+}
+
+// handleReadReceiptFrame clears conversation unread counts, and tells the
+// user's other devices that the conversation was read.
+func handleReadReceiptFrame(c *Client, frame clientFrame) {
+	receipt := frame.(*ReadReceiptFrame)
+	if _, err := conversationReads.markRead(c.teamID, c.userID, receipt.ConversationID, receipt.NotificationID); err != nil {
+		c.logger().Warn("Read receipt ignored", "conversation", receipt.ConversationID, "error", err)
+		return
+	}
+	fanOutSyncEvent(c, SyncEventFrame{Action: syncActionRead, ConversationID: receipt.ConversationID, NotificationID: receipt.NotificationID})
+}
+
+func (f *ClientRequestFrame) validate(c *Client) error {
+	if f.RequestID == "" || len(f.RequestID) > maxClientRequestIDLength {
+		return fmt.Errorf("requestId must be 1-%d bytes", maxClientRequestIDLength)
+	}
+	if f.Method == "" {
+		return errors.New("method is required")
+	}
+	return nil
+}
+
+func handleClientRequestFrame(c *Client, frame clientFrame) {
+	c.answerRequest(*frame.(*ClientRequestFrame))
+}
@@ -0,0 +1,74 @@
+// client_frames_test.go
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// pingFrame is registered by TestClientFrames_Registry as a plugin would.
+type pingFrame struct {
+	Type  string `json:"type"`
+	Nonce string `json:"nonce"`
+}
+
+func (f *pingFrame) validate(c *Client) error {
+	if f.Nonce == "" {
+		return errors.New("nonce is required")
+	}
+	return nil
+}
+
+func TestClientFrames_Registry(t *testing.T) {
+	setupTestAppConfig()
+	var handled []string
+	registerClientFrame("test.ping", func() clientFrame { return &pingFrame{} }, func(c *Client, frame clientFrame) {
+		handled = append(handled, frame.(*pingFrame).Nonce)
+	})
+	defer func() {
+		clientFrameTypesMu.Lock()
+		delete(clientFrameTypes, "test.ping")
+		clientFrameTypesMu.Unlock()
+	}()
+
+	client := &Client{teamID: "team1", userID: "user1", acks: newAckTracker()}
+	if err := client.handleFrame(websocket.TextMessage, []byte(`{"type":"test.ping","nonce":"n1"}`)); err != nil {
+		t.Fatalf("expected the registered frame to be handled, got %v", err)
+	}
+	if len(handled) != 1 || handled[0] != "n1" {
+		t.Fatalf("expected the handler to receive the decoded frame, got %v", handled)
+	}
+
+	tests := []struct {
+		name    string
+		client  *Client
+		frame   string
+		wantErr string
+	}{
+		{"unknown type", client, `{"type":"chat","body":"hi"}`, "unknown frame type"},
+		{"not JSON", client, `hello`, "invalid character"},
+		{"wrong field type", client, `{"type":"ack","notificationIds":"n1"}`, "invalid ack frame"},
+		{"fails validation", client, `{"type":"ack","notificationIds":[]}`, "notificationIds is required"},
+		{"plugin validation", client, `{"type":"test.ping"}`, "invalid test.ping frame"},
+		{"ack without supportsAck", &Client{}, `{"type":"ack","notificationIds":["n1"]}`, "supportsAck"},
+		{"request without requestId", client, `{"type":"request","method":"status.set"}`, "requestId"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.client.handleFrame(websocket.TextMessage, []byte(tt.frame))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected registering a built-in type again to panic")
+		}
+	}()
+	registerClientFrame("ack", func() clientFrame { return &AckFrame{} }, handleAckFrame)
+}
@@ -0,0 +1,80 @@
+// client_requests.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// maxClientRequestIDLength bounds the requestId echoed back to a client.
+const maxClientRequestIDLength = 128
+
+// clientRequestHandler answers one request method for an authenticated
+// client. The result is sent back as the response's result.
+type clientRequestHandler func(c *Client, params json.RawMessage) (interface{}, error)
+
+// clientRequestHandlers maps request methods to their handlers.
+var clientRequestHandlers = map[string]clientRequestHandler{
+	"conversations.list": handleConversationsListRequest,
+	"preferences.get":    handlePreferencesGetRequest,
+	"preferences.set":    handlePreferencesSetRequest,
+	"status.set":         handleStatusSetRequest,
+}
+
+// answerRequest runs request and queues the response on the control queue,
+// so it is written by the writePump ahead of queued notifications.
+func (c *Client) answerRequest(request ClientRequestFrame) {
+	response := ClientResponseFrame{Type: "response", RequestID: request.RequestID}
+	handler, ok := clientRequestHandlers[request.Method]
+	if !ok {
+		response.Error = "unknown method " + request.Method
+	} else if result, err := handler(c, request.Params); err != nil {
+		response.Error = err.Error()
+	} else {
+		response.OK = true
+		response.Result = result
+	}
+	appMetrics.Count("ws.requests", 1, tenantTags(c.tenantID, metricTag("method", request.Method))...)
+
+	payload, err := json.Marshal(response)
+	if err != nil {
+		c.logger().Error("Failed to encode response", "method", request.Method, "error", err)
+		return
+	}
+	c.hub.SendControl(c, outboundMessage{payload: payload})
+}
+
+type conversationsListParams struct {
+	Limit int `json:"limit"` // 0 returns every conversation
+}
+
+type conversationsListResult struct {
+	TotalUnread   int                `json:"totalUnread"`
+	Conversations []conversationView `json:"conversations"`
+}
+
+func handleConversationsListRequest(c *Client, params json.RawMessage) (interface{}, error) {
+	if conversationReads == nil {
+		return nil, errors.New("conversation tracking is not enabled")
+	}
+	var p conversationsListParams
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, errors.New("invalid params: " + err.Error())
+		}
+	}
+	if p.Limit < 0 {
+		return nil, errors.New("limit must not be negative")
+	}
+
+	views := conversationReads.list(c.teamID, c.userID)
+	result := conversationsListResult{}
+	for _, view := range views {
+		result.TotalUnread += view.Unread
+	}
+	if p.Limit > 0 && len(views) > p.Limit {
+		views = views[:p.Limit]
+	}
+	result.Conversations = views
+	return result, nil
+}
@@ -0,0 +1,78 @@
+// client_requests_test.go
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandleWebSocket_ClientRequests(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Environment.Mode = "development"
+	AppConfig().Environment.EnableFakeAuth = true
+	authFailures = nil
+	conversationReads = newConversationIndex(10, 100)
+	defer func() { conversationReads = nil }()
+	hub := newHub()
+	go hub.run()
+	wsURL := newWebSocketTestServer(t, hub)
+
+	conversationReads.recordSend("team-q", NewMessage("d1", "team-q", "user-q", "peer-1", "chat", "older", false), false)
+	time.Sleep(2 * time.Millisecond)
+	conversationReads.recordSend("team-q", NewMessage("d2", "team-q", "user-q", "peer-2", "chat", "newer", false), false)
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"auth","teamId":"team-q","userId":"user-q","token":"fake_development_token"}`)); err != nil {
+		t.Fatalf("failed to send auth: %v", err)
+	}
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatalf("failed to read auth reply: %v", err)
+	}
+
+	request := func(frame string) (response struct {
+		Type      string                  `json:"type"`
+		RequestID string                  `json:"requestId"`
+		OK        bool                    `json:"ok"`
+		Result    conversationsListResult `json:"result"`
+		Error     string                  `json:"error"`
+	}) {
+		t.Helper()
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if err := json.Unmarshal(data, &response); err != nil || response.Type != "response" {
+			t.Fatalf("expected a response frame, got %s", data)
+		}
+		return response
+	}
+
+	response := request(`{"type":"request","requestId":"r1","method":"conversations.list","params":{"limit":1}}`)
+	if response.RequestID != "r1" || !response.OK || response.Result.TotalUnread != 2 || len(response.Result.Conversations) != 1 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if got := response.Result.Conversations[0]; got.PeerUserID != "peer-2" || got.LastMessage.Snippet != "newer" {
+		t.Fatalf("expected the most recent conversation first, got %+v", got)
+	}
+
+	response = request(`{"type":"request","requestId":"r2","method":"nope"}`)
+	if response.RequestID != "r2" || response.OK || response.Error != "unknown method nope" {
+		t.Fatalf("expected an unknown method error, got %+v", response)
+	}
+
+	response = request(`{"type":"request","requestId":"r3","method":"conversations.list","params":{"limit":-1}}`)
+	if response.OK || response.Error == "" {
+		t.Fatalf("expected a negative limit to be rejected, got %+v", response)
+	}
+}
@@ -0,0 +1,210 @@
+// compression.go
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressionMiddleware gzip- or deflate-encodes REST responses for clients
+// that accept it. Responses are buffered until they reach
+// compression.min_size, so small bodies go out unencoded with their
+// original Content-Length. Websocket upgrades pass straight through.
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if AppConfig() == nil || !AppConfig().Compression.Enabled || r.Method == http.MethodHead || isWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			minSize:        AppConfig().Compression.MinSize,
+			level:          AppConfig().Compression.Level,
+			status:         http.StatusOK,
+		}
+		defer func() {
+			if err := cw.Close(); err != nil {
+				appMetrics.Count("http.compression_errors", 1)
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// negotiateEncoding picks gzip, then deflate, from an Accept-Encoding header,
+// honouring q=0 exclusions and the "*" wildcard. It returns "" for identity.
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	wildcard, wildcardSet := false, false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		allowed := true
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q <= 0 {
+					allowed = false
+				}
+			}
+		}
+		if name == "*" {
+			wildcard, wildcardSet = allowed, true
+			continue
+		}
+		if name != "" {
+			accepted[name] = allowed
+		}
+	}
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if allowed, listed := accepted[encoding]; listed {
+			if allowed {
+				return encoding
+			}
+			continue
+		}
+		if wildcardSet && wildcard {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter holds back the status and body until it knows whether the
+// response is worth compressing, then either encodes or replays it as is.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	level    int
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser // nil when the response is sent unencoded
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		return
+	}
+	if status < http.StatusOK {
+		// Informational responses such as 103 Early Hints pass through.
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide commits the headers and flushes the buffered body.
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	header := cw.Header()
+	if len(cw.buf) >= cw.minSize && compressible(cw.status, header) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+		// The tag names the unencoded body, so it is only weakly valid here.
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "gzip" {
+			cw.encoder, _ = gzip.NewWriterLevel(cw.ResponseWriter, cw.level)
+		} else {
+			cw.encoder, _ = zlib.NewWriterLevel(cw.ResponseWriter, cw.level)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buffered := cw.buf
+	cw.buf = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buffered)
+	} else {
+		_, err = cw.ResponseWriter.Write(buffered)
+	}
+	return err
+}
+
+// compressible reports whether a response may be encoded: it must carry a
+// body, not be encoded already, and not be a format that is compressed by
+// nature.
+func compressible(status int, header http.Header) bool {
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	for _, prefix := range []string{"image/", "audio/", "video/", "application/zip", "application/gzip"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
+
+// Flush sends whatever has been written so far, compressing it if the
+// response has already grown past the threshold.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		_ = cw.decide()
+	}
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,111 @@
+// compression_test.go
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip;q=0.5", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"br", ""},
+		{"*", "gzip"},
+		{"gzip;q=0, *", "deflate"},
+		{"*;q=0", ""},
+		{"GZIP", "gzip"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header); got != tt.want {
+			t.Fatalf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Compression.Enabled = true
+	AppConfig().Compression.MinSize = 100
+	AppConfig().Compression.Level = 5
+
+	large := strings.Repeat(`{"teamId":"team-1"},`, 50)
+	small := `{"status":"ok"}`
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		body           string
+		contentType    string
+		upgrade        bool
+		wantEncoding   string
+	}{
+		{"gzip", "gzip", large, "application/json", false, "gzip"},
+		{"deflate", "deflate", large, "application/json", false, "deflate"},
+		{"below threshold", "gzip", small, "application/json", false, ""},
+		{"not accepted", "", large, "application/json", false, ""},
+		{"already compressed type", "gzip", large, "image/png", false, ""},
+		{"websocket upgrade", "gzip", large, "application/json", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := compressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(http.StatusAccepted)
+				// Write in pieces to cross the threshold mid-response.
+				for i := 0; i < len(tt.body); i += 64 {
+					end := min(i+64, len(tt.body))
+					_, _ = io.WriteString(w, tt.body[i:end])
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			if tt.upgrade {
+				req.Header.Set("Upgrade", "websocket")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("expected status %d, got %d", http.StatusAccepted, rr.Code)
+			}
+			if got := rr.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("expected Content-Encoding %q, got %q", tt.wantEncoding, got)
+			}
+
+			var reader io.Reader = rr.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				gz, err := gzip.NewReader(rr.Body)
+				if err != nil {
+					t.Fatalf("invalid gzip body: %v", err)
+				}
+				reader = gz
+			case "deflate":
+				zr, err := zlib.NewReader(rr.Body)
+				if err != nil {
+					t.Fatalf("invalid deflate body: %v", err)
+				}
+				reader = zr
+			}
+			body, err := io.ReadAll(reader)
+			if err != nil || string(body) != tt.body {
+				t.Fatalf("body mismatch (err %v): got %d bytes, want %d", err, len(body), len(tt.body))
+			}
+		})
+	}
+}
@@ -2,15 +2,22 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
+// Config is the server configuration. Fields tagged secret:"true" are masked
+// wherever the effective configuration is displayed.
 type Config struct {
 	Server struct {
 		Port           string        `yaml:"port"`
@@ -20,31 +27,126 @@ type Config struct {
 		AllowedOrigins []string      `yaml:"allowed_origins"`
 	} `yaml:"server"`
 
+	// TLS serves the HTTP API and websockets over HTTPS. ReloadInterval,
+	// MinVersion and CipherSuites also cover the TCP listener.
+	TLS struct {
+		CertFile       string        `yaml:"cert_file"`        // Certificate for https:// and wss://; empty serves plain HTTP
+		KeyFile        string        `yaml:"key_file"`         // Its private key
+		ReloadInterval time.Duration `yaml:"reload_interval"`  // Check certificate files for changes this often; 0 reloads on SIGHUP only
+		MinVersion     string        `yaml:"min_version"`      // Oldest protocol accepted: "1.2" or "1.3"; "1.0" and "1.1" are refused in production
+		CipherSuites   []string      `yaml:"cipher_suites"`    // TLS 1.2 suites by Go name, in no particular order; empty uses Go's secure defaults
+		OCSPStapleFile string        `yaml:"ocsp_staple_file"` // DER OCSP response stapled to handshakes, reloaded with the certificate
+	} `yaml:"tls"`
+
+	// Headers are the CORS and security headers corsMiddleware adds, per
+	// route group: websocket is /ws and api is /send, /users/,
+	// /notifications/, /teams/presence-summary and /client-config.
+	Headers struct {
+		WebSocket routeHeaders `yaml:"websocket"`
+		API       routeHeaders `yaml:"api"`
+	} `yaml:"headers"`
+
+	// ClientConfig is served to frontends at GET /client-config.
+	ClientConfig struct {
+		WebSocketURL   string          `yaml:"websocket_url"`    // Public ws:// or wss:// URL of /ws; empty derives it from the request
+		Features       map[string]bool `yaml:"features"`         // Frontend feature flags, passed through as-is
+		SigningKeyFile string          `yaml:"signing_key_file"` // Ed25519 PKCS#8 PEM key that signs responses; empty leaves them unsigned
+	} `yaml:"client_config"`
+
+	// Compression applies to REST responses only, never to websocket frames.
+	Compression struct {
+		Enabled bool `yaml:"enabled"`  // gzip/deflate responses for clients that accept it
+		MinSize int  `yaml:"min_size"` // Smaller responses are sent unencoded
+		Level   int  `yaml:"level"`    // 1 (fastest) to 9 (smallest)
+	} `yaml:"compression"`
+
 	WebSocket struct {
-		WriteWait          time.Duration `yaml:"write_wait"`
-		PongWait           time.Duration `yaml:"pong_wait"`
-		PingPeriod         time.Duration `yaml:"ping_period"`
-		MaxMessageSize     int64         `yaml:"max_message_size"`
-		AuthMaxMessageSize int64         `yaml:"auth_max_message_size"`
-		ReadDeadline       time.Duration `yaml:"read_deadline"`
-		BufferSize         struct {
+		WriteWait           time.Duration `yaml:"write_wait"`
+		PongWait            time.Duration `yaml:"pong_wait"`
+		PingPeriod          time.Duration `yaml:"ping_period"`
+		MaxMessageSize      int64         `yaml:"max_message_size"`
+		AuthMaxMessageSize  int64         `yaml:"auth_max_message_size"`
+		ReadDeadline        time.Duration `yaml:"read_deadline"`
+		ReaperInterval      time.Duration `yaml:"reaper_interval"`
+		StalePongMultiplier float64       `yaml:"stale_pong_multiplier"`
+		FullBufferRatio     float64       `yaml:"full_buffer_ratio"`
+		FullBufferSweeps    int           `yaml:"full_buffer_sweeps"`
+		BackpressureRatio   float64       `yaml:"backpressure_ratio"`
+		AllowQueryToken     bool          `yaml:"allow_query_token"`     // Accept ?token=&teamId= on the upgrade instead of an auth frame
+		AckTimeout          time.Duration `yaml:"ack_timeout"`           // How long a supportsAck client has to acknowledge a notification
+		RegisterBatchWindow time.Duration `yaml:"register_batch_window"` // During a burst of connects and disconnects, how long the hub gathers them into one batch
+		RegisterBatchSize   int           `yaml:"register_batch_size"`   // Most connects and disconnects applied in one batch
+		BufferSize          struct {
 			Read  int `yaml:"read"`
 			Write int `yaml:"write"`
 		} `yaml:"buffer_size"`
 	} `yaml:"websocket"`
 
+	// TCP configures the newline-delimited JSON listener for server-side
+	// consumers that do not speak WebSocket.
+	TCP struct {
+		Address        string `yaml:"address"`         // e.g. ":9443"; empty disables the listener
+		CertFile       string `yaml:"cert_file"`       // TLS certificate; required unless allow_plaintext is set
+		KeyFile        string `yaml:"key_file"`        // TLS private key
+		AllowPlaintext bool   `yaml:"allow_plaintext"` // Accept unencrypted streams, e.g. behind a TLS-terminating proxy
+	} `yaml:"tcp"`
+
+	// Control configures the operator socket used by notifyctl.
+	Control struct {
+		Socket string `yaml:"socket"` // Unix socket path, e.g. /run/notification-server/control.sock; empty disables
+	} `yaml:"control"`
+
 	Security struct {
-		APIKey string `yaml:"api_key"`
+		APIKey      string   `yaml:"api_key" secret:"true"`
+		APIKeyFile  string   `yaml:"api_key_file"` // Read the API key from a mounted file instead of inline YAML
+		IPAllowlist []string `yaml:"ip_allowlist"` // CIDR ranges allowed to call /send and admin endpoints
+		IPDenylist  []string `yaml:"ip_denylist"`  // CIDR ranges always rejected, even if allowlisted
+		BruteForce  struct {
+			MaxFailures int           `yaml:"max_failures"` // Failed attempts within window before a lockout
+			Window      time.Duration `yaml:"window"`
+			BaseLockout time.Duration `yaml:"base_lockout"` // Doubles with each further lockout
+			MaxLockout  time.Duration `yaml:"max_lockout"`
+		} `yaml:"brute_force"`
 	} `yaml:"security"`
 
 	Backend struct {
-		URL     string        `yaml:"url"`
-		Timeout time.Duration `yaml:"timeout"`
+		URL           string        `yaml:"url"`
+		URLs          []string      `yaml:"urls"`     // Replaces url when set; auth calls fail over between them
+		Strategy      string        `yaml:"strategy"` // failover (in list order) or round_robin
+		Timeout       time.Duration `yaml:"timeout"`
+		TeamCheckPath string        `yaml:"team_check_path"` // e.g. /api/teams/{teamId}/; empty skips the /ws/{teamId} existence check
+		TeamCheckTTL  time.Duration `yaml:"team_check_ttl"`  // How long existence answers are cached
+
+		HealthPath      string        `yaml:"health_path"`      // e.g. /health/; empty disables probing
+		HealthInterval  time.Duration `yaml:"health_interval"`  // How often the health path is probed
+		HealthThreshold int           `yaml:"health_threshold"` // Consecutive failed probes before the backend is degraded
+		AuthCacheTTL    time.Duration `yaml:"auth_cache_ttl"`   // How long a successful auth may be reused while the backend is down; 0 disables
+
+		// JWT verifies WebSocket tokens locally against the identity
+		// provider's published keys instead of asking the backend.
+		JWT struct {
+			JWKSURL         string        `yaml:"jwks_url"`         // Empty asks the backend to authenticate each connection
+			Issuer          string        `yaml:"issuer"`           // Required iss claim; empty accepts any
+			Audience        string        `yaml:"audience"`         // Required aud claim; empty accepts any
+			RefreshInterval time.Duration `yaml:"refresh_interval"` // How long fetched keys are used before fetching them again
+			Leeway          time.Duration `yaml:"leeway"`           // Clock skew allowed on exp and nbf
+			UserClaim       string        `yaml:"user_claim"`
+			TeamClaim       string        `yaml:"team_claim"`
+			EmailClaim      string        `yaml:"email_claim"`
+		} `yaml:"jwt"`
 	} `yaml:"backend"`
 
 	Limits struct {
-		MaxClientsPerTeam int `yaml:"max_clients_per_team"`
-		SendChannelBuffer int `yaml:"send_channel_buffer"`
+		MaxClientsPerTeam    int `yaml:"max_clients_per_team"`
+		SendChannelBuffer    int `yaml:"send_channel_buffer"`
+		ControlChannelBuffer int `yaml:"control_channel_buffer"`
+		MaxDigestMessages    int `yaml:"max_digest_messages"`
+		MaxBatchMessages     int `yaml:"max_batch_messages"`
+		MaxChannelsPerClient int `yaml:"max_channels_per_client"` // Channels one connection may subscribe to
+		// Direct notifications to a user with no connection are stored and
+		// delivered when the user connects.
+		OfflineQueueDepth int           `yaml:"offline_queue_depth"` // Most notifications kept per offline user; 0 disables the offline queue
+		OfflineTTL        time.Duration `yaml:"offline_ttl"`         // How long they are kept; 0 uses storage.notification_ttl
 	} `yaml:"limits"`
 
 	CircuitBreaker struct {
@@ -53,15 +155,271 @@ type Config struct {
 	} `yaml:"circuit_breaker"`
 
 	RateLimit struct {
-		RequestsPerSecond float64       `yaml:"requests_per_second"`
-		Burst             int           `yaml:"burst"`
-		EntryTTL          time.Duration `yaml:"entry_ttl"`
-		CleanupInterval   time.Duration `yaml:"cleanup_interval"`
+		Backend           string            `yaml:"backend"` // "memory" or "redis"; redis shares the limit between instances
+		RequestsPerSecond float64           `yaml:"requests_per_second"`
+		Burst             int               `yaml:"burst"`
+		Key               string            `yaml:"key"` // "ip" (default), "tenant" or "api_key": who requests are counted against
+		EntryTTL          time.Duration     `yaml:"entry_ttl"`
+		CleanupInterval   time.Duration     `yaml:"cleanup_interval"`
+		Policies          []RateLimitPolicy `yaml:"policies"` // Per-route limits; the first matching policy applies
 	} `yaml:"rate_limit"`
 
+	Storage struct {
+		Driver          string        `yaml:"driver"` // "memory", "redis" or "sql"
+		NotificationTTL time.Duration `yaml:"notification_ttl"`
+		PruneInterval   time.Duration `yaml:"prune_interval"`
+		Redis           struct {
+			Address   string        `yaml:"address"`
+			Password  string        `yaml:"password" secret:"true"`
+			DB        int           `yaml:"db"`
+			KeyPrefix string        `yaml:"key_prefix"`
+			Timeout   time.Duration `yaml:"timeout"`
+		} `yaml:"redis"`
+		SQL struct {
+			Driver         string `yaml:"driver"` // database/sql driver name linked into the build
+			DSN            string `yaml:"dsn" secret:"true"`
+			SkipMigrations bool   `yaml:"skip_migrations"` // Leave schema changes to --migrate-only runs
+		} `yaml:"sql"`
+		Encryption struct {
+			Enabled     bool   `yaml:"enabled"`
+			ActiveKeyID string `yaml:"active_key_id"`
+			Keys        []struct {
+				ID  string `yaml:"id"`
+				Key string `yaml:"key" secret:"true"` // base64-encoded 32-byte AES key
+			} `yaml:"keys"`
+		} `yaml:"encryption"`
+	} `yaml:"storage"`
+
+	// Secrets configures how vault:// and awssm:// references elsewhere in
+	// this file are resolved. Values in this section are never resolved.
+	Secrets struct {
+		RefreshInterval time.Duration `yaml:"refresh_interval"` // 0 resolves once at startup
+		Timeout         time.Duration `yaml:"timeout"`
+		Vault           struct {
+			Address   string `yaml:"address"`             // Defaults to VAULT_ADDR
+			Token     string `yaml:"token" secret:"true"` // Defaults to VAULT_TOKEN
+			TokenFile string `yaml:"token_file"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"vault"`
+		AWS struct {
+			Region   string `yaml:"region"`   // Defaults to AWS_REGION
+			Endpoint string `yaml:"endpoint"` // Overrides the regional Secrets Manager endpoint
+		} `yaml:"aws"`
+	} `yaml:"secrets"`
+
+	Abuse struct {
+		Enabled       bool          `yaml:"enabled"`
+		Threshold     float64       `yaml:"threshold"`       // Score at which a subject is temp-banned
+		ScoreHalfLife time.Duration `yaml:"score_half_life"` // Scores halve after this long without violations
+		BanDuration   time.Duration `yaml:"ban_duration"`
+		WebhookURL    string        `yaml:"webhook_url"` // Optional backend endpoint notified of bans
+		Weights       struct {
+			RateLimited      float64 `yaml:"rate_limited"`
+			MalformedMessage float64 `yaml:"malformed_message"`
+			TeamSpoofing     float64 `yaml:"team_spoofing"`
+		} `yaml:"weights"`
+	} `yaml:"abuse"`
+
+	Blackout struct {
+		CriticalMessageTypes []string      `yaml:"critical_message_types"` // Delivered even during a blackout
+		MaxDeferredPerTeam   int           `yaml:"max_deferred_per_team"`
+		CheckInterval        time.Duration `yaml:"check_interval"`
+	} `yaml:"blackout"`
+
+	// Urgency decides which of do-not-disturb, blackouts, filters and
+	// digests apply to each urgency level a /send may give.
+	Urgency struct {
+		Default string                   `yaml:"default"` // Level of a /send without urgency
+		Levels  map[string]UrgencyPolicy `yaml:"levels"`  // low, normal, high and critical; a level listed replaces its defaults
+	} `yaml:"urgency"`
+
+	// Escalation re-sends a critical notification to one user that is not
+	// acknowledged within ack_timeout to the next step of an escalation chain.
+	Escalation struct {
+		Enabled       bool                `yaml:"enabled"`
+		Chain         []string            `yaml:"chain"`          // Steps after the recipient: group, admins, group:<name> or user:<id>
+		Teams         map[string][]string `yaml:"teams"`          // Chains of particular teams, replacing chain; an empty one disables escalation
+		AckTimeout    time.Duration       `yaml:"ack_timeout"`    // How long each step has to acknowledge
+		CheckInterval time.Duration       `yaml:"check_interval"` // How often timed-out steps are looked for
+		MaxTrails     int                 `yaml:"max_trails"`     // Finished escalations kept for /admin/escalations
+	} `yaml:"escalation"`
+
+	// OnCall lets /send page target_user_id oncall:<team>, the users on call
+	// for the team, from shifts managed through /admin/oncall.
+	OnCall struct {
+		Enabled          bool          `yaml:"enabled"`
+		HandoffWindow    time.Duration `yaml:"handoff_window"` // Unacknowledged pages this recent also reach users who come on call
+		CheckInterval    time.Duration `yaml:"check_interval"` // How often handoffs are looked for
+		MaxShiftsPerTeam int           `yaml:"max_shifts_per_team"`
+	} `yaml:"oncall"`
+
+	// Incidents groups bursts of alerts that share a /send group_key: the
+	// first is delivered, duplicates are suppressed and summed up in
+	// rollups, and a resolution is sent once the group goes quiet.
+	Incidents struct {
+		Enabled        bool                           `yaml:"enabled"`
+		MessageTypes   []string                       `yaml:"message_types"`   // Types grouped by group_key
+		RollupInterval time.Duration                  `yaml:"rollup_interval"` // How often an incident's suppressed alerts are summed up
+		ResolveAfter   time.Duration                  `yaml:"resolve_after"`   // An incident with no alert for this long is resolved
+		CheckInterval  time.Duration                  `yaml:"check_interval"`  // How often due rollups are looked for
+		MaxOpen        int                            `yaml:"max_open"`        // Groups tracked at once; alerts of further groups are not grouped
+		Groups         map[string]IncidentGroupPolicy `yaml:"groups"`          // Settings of particular group keys
+	} `yaml:"incidents"`
+
+	Schedules struct {
+		Enabled       bool          `yaml:"enabled"`
+		CheckInterval time.Duration `yaml:"check_interval"` // How often due schedules are looked for
+		MisfireGrace  time.Duration `yaml:"misfire_grace"`  // A run noticed later than this has misfired
+		HistorySize   int           `yaml:"history_size"`   // Executions kept per schedule
+	} `yaml:"schedules"`
+
+	Attachments struct {
+		Provider        string        `yaml:"provider"`                        // none, s3 or gcs
+		Bucket          string        `yaml:"bucket"`                          // Bucket holding attachment storage keys
+		Region          string        `yaml:"region"`                          // s3 only; defaults to AWS_REGION
+		Endpoint        string        `yaml:"endpoint"`                        // Path-style endpoint, e.g. MinIO; gcs defaults to https://storage.googleapis.com
+		AccessKeyID     string        `yaml:"access_key_id"`                   // Defaults to AWS_ACCESS_KEY_ID; an HMAC key ID for gcs
+		SecretAccessKey string        `yaml:"secret_access_key" secret:"true"` // Defaults to AWS_SECRET_ACCESS_KEY
+		URLTTL          time.Duration `yaml:"url_ttl"`                         // Lifetime of pre-signed URLs
+		MaxPerMessage   int           `yaml:"max_per_message"`
+		MaxUploadSize   int64         `yaml:"max_upload_size"` // Largest file POST /attachments accepts, in bytes; 0 disables uploads
+		UploadPrefix    string        `yaml:"upload_prefix"`   // Prepended to the storage keys of uploaded files
+	} `yaml:"attachments"`
+
+	Quota struct {
+		Enabled      bool          `yaml:"enabled"`
+		WarnRatio    float64       `yaml:"warn_ratio"`    // Share of a limit at which the team is warned
+		WarnCooldown time.Duration `yaml:"warn_cooldown"` // Minimum time between warnings for one team and limit
+		WebhookURL   string        `yaml:"webhook_url"`   // Optional endpoint notified of warnings
+	} `yaml:"quota"`
+
+	Conversations struct {
+		Enabled       bool `yaml:"enabled"`
+		MaxPerUser    int  `yaml:"max_per_user"`   // Direct conversations tracked per user; the least recently active is dropped
+		SnippetLength int  `yaml:"snippet_length"` // Characters of the last message kept as its preview
+	} `yaml:"conversations"`
+
+	// Mentions sends a mention notification to team members named with @ in
+	// chat messages.
+	Mentions struct {
+		Enabled       bool     `yaml:"enabled"`
+		MessageTypes  []string `yaml:"message_types"`   // /send message types whose bodies are searched for mentions
+		MaxPerMessage int      `yaml:"max_per_message"` // Most users notified for one message
+	} `yaml:"mentions"`
+
+	// KeywordAlerts sends a keywordAlert notification to users whose
+	// keywordWatches preference names a word in a team message.
+	KeywordAlerts struct {
+		Enabled    bool `yaml:"enabled"`
+		MaxPerUser int  `yaml:"max_per_user"` // Keywords one user may watch
+		MaxLength  int  `yaml:"max_length"`   // Characters in one keyword
+	} `yaml:"keyword_alerts"`
+
+	// Preferences syncs each user's settings between their devices.
+	Preferences struct {
+		Sync         bool `yaml:"sync"`
+		MaxKeys      int  `yaml:"max_keys"`       // Preferences kept per user
+		MaxValueSize int  `yaml:"max_value_size"` // Bytes of JSON per preference value
+	} `yaml:"preferences"`
+
+	Presence struct {
+		SummaryTTL time.Duration `yaml:"summary_ttl"` // How long /teams/presence-summary reuses its counts
+	} `yaml:"presence"`
+
+	// Analytics records usage rollups in the store for /admin/analytics.
+	Analytics struct {
+		Presence      bool          `yaml:"presence"`       // Hourly and daily presence rollups per team
+		Messages      bool          `yaml:"messages"`       // Hourly message counts and bytes per team and message type
+		FlushInterval time.Duration `yaml:"flush_interval"` // How often open rollups are saved
+		Instance      string        `yaml:"instance"`       // Names this instance's rollups; defaults to the hostname
+	} `yaml:"analytics"`
+
+	// Archive keeps a sample of /send messages in the store for troubleshooting.
+	Archive struct {
+		SampleRate float64       `yaml:"sample_rate"` // Fraction of messages archived, e.g. 0.001; 0 disables
+		TTL        time.Duration `yaml:"ttl"`         // How long archived messages are kept
+	} `yaml:"archive"`
+
+	// SLO sets the service-level objectives judged at /admin/slo. Missing one
+	// marks the instance degraded in /readyz.
+	SLO struct {
+		DeliverySuccess float64       `yaml:"delivery_success"` // Minimum ratio of notifications written rather than dropped; 0 disables
+		AuthSuccess     float64       `yaml:"auth_success"`     // Minimum ratio of successful websocket authentications; 0 disables
+		P99Latency      time.Duration `yaml:"p99_latency"`      // Maximum p99 delivery latency; 0 disables
+		Window          time.Duration `yaml:"window"`           // Rolling window the objectives are judged over: 5m, 1h or 24h
+		MinEvents       int           `yaml:"min_events"`       // Objectives with fewer events in the window are not judged
+	} `yaml:"slo"`
+
+	Audit struct {
+		Sends        bool `yaml:"sends"`         // Write an AUDIT line for every /send, for POST /admin/audit/replay
+		ReplayBuffer int  `yaml:"replay_buffer"` // Audited sends kept in memory for replays without an uploaded log
+	} `yaml:"audit"`
+
+	Snapshot struct {
+		Path   string        `yaml:"path"`    // Hub state file written on graceful shutdown and restored on start; empty disables
+		MaxAge time.Duration `yaml:"max_age"` // Older snapshots are discarded; restored users are awaited this long
+	} `yaml:"snapshot"`
+
+	// Handover configures blue/green connection handover between instances.
+	Handover struct {
+		PeerURL string        `yaml:"peer_url"` // Base URL of the instance clients migrate from; enables resumeToken
+		TTL     time.Duration `yaml:"ttl"`      // How long a migrating client's messages are held for the new instance
+		MaxHeld int           `yaml:"max_held"` // Messages held per migrating connection; the oldest are dropped first
+	} `yaml:"handover"`
+
+	// Shutdown sets the notice clients get when the server shuts down. It can
+	// be replaced at runtime with PUT /admin/shutdown.
+	Shutdown struct {
+		Reason            string        `yaml:"reason"`             // Why the server is going away, shown by client UIs
+		EstimatedDowntime time.Duration `yaml:"estimated_downtime"` // How long until it is back; 0 leaves the estimate out
+		NoticeGrace       time.Duration `yaml:"notice_grace"`       // Longest wait for the notice to be written before closing; 0 sends no notice
+	} `yaml:"shutdown"`
+
+	Recall struct {
+		Window     time.Duration `yaml:"window"`      // How long after sending a notification can be recalled
+		MaxTracked int           `yaml:"max_tracked"` // Recallable notifications kept; the oldest are forgotten first
+	} `yaml:"recall"`
+
+	// Webhooks configures the dispatcher shared by all outbound webhooks.
+	Webhooks struct {
+		QueueSize      int           `yaml:"queue_size"` // Jobs waiting beyond this are dropped
+		Workers        int           `yaml:"workers"`
+		MaxAttempts    int           `yaml:"max_attempts"`
+		InitialBackoff time.Duration `yaml:"initial_backoff"` // Doubles after each failed attempt
+		MaxBackoff     time.Duration `yaml:"max_backoff"`
+		Timeout        time.Duration `yaml:"timeout"`      // Per attempt
+		MaxPerTeam     int           `yaml:"max_per_team"` // Webhooks a team may register through /admin/webhooks/teams; 0 disables them
+	} `yaml:"webhooks"`
+
+	Stats struct {
+		Interval time.Duration `yaml:"interval"` // How often __stats__ subscribers receive a stats frame
+	} `yaml:"stats"`
+
+	// Tenants partition one deployment between customer applications.
+	Tenants []TenantConfig `yaml:"tenants"`
+
+	// Retention overrides how much is kept for individual teams, and for how long.
+	Retention []TeamRetention `yaml:"retention"`
+
+	Metrics struct {
+		Backend       string        `yaml:"backend"` // "none", "statsd" or "dogstatsd"
+		Address       string        `yaml:"address"`
+		Prefix        string        `yaml:"prefix"`
+		Tags          []string      `yaml:"tags"`
+		FlushInterval time.Duration `yaml:"flush_interval"`
+	} `yaml:"metrics"`
+
+	Debug struct {
+		LeakWatchdog     bool          `yaml:"leak_watchdog"` // Development mode only
+		WatchdogInterval time.Duration `yaml:"watchdog_interval"`
+		StackSampleBytes int           `yaml:"stack_sample_bytes"`
+	} `yaml:"debug"`
+
 	Logging struct {
-		Level  string `yaml:"level"`
-		Format string `yaml:"format"`
+		Level               string  `yaml:"level"`                  // debug, info, warn or error; production logs at info or above
+		Format              string  `yaml:"format"`                 // text or json
+		AccessLog           bool    `yaml:"access_log"`             // Log every HTTP request with its status and latency
+		AccessLogSampleRate float64 `yaml:"access_log_sample_rate"` // Fraction of successful requests logged; errors always are
 	} `yaml:"logging"`
 
 	// Environment settings
@@ -72,29 +430,112 @@ type Config struct {
 	} `yaml:"environment"`
 }
 
-var AppConfig *Config
+// appConfig is the configuration in use. A reload or secret refresh
+// publishes a new copy rather than changing the one readers may hold, so a
+// *Config taken from AppConfig never changes underneath its reader.
+var appConfig atomic.Pointer[Config]
+
+// AppConfig returns the configuration in use, or nil before it is loaded.
+func AppConfig() *Config {
+	return appConfig.Load()
+}
+
+// setAppConfig publishes config as the configuration in use.
+func setAppConfig(config *Config) {
+	appConfig.Store(config)
+}
+
+// activeConfigPath is the file AppConfig was loaded from.
+var activeConfigPath string
+
+// loadedConfig is a parsed and validated config plus the secret references
+// that were resolved into it.
+type loadedConfig struct {
+	config         *Config
+	secretResolver *secretResolver
+	secretBindings []secretBinding
+}
 
 func LoadConfig(configPath string) error {
+	loaded, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	setAppConfig(loaded.config)
+	activeConfigPath = configPath
+	secretRefs.resolver = loaded.secretResolver
+	secretRefs.bindings = loaded.secretBindings
+	slog.Info("Configuration loaded", "path", configPath)
+	return nil
+}
+
+// readConfigFile loads and validates a config file without installing it.
+func readConfigFile(configPath string) (*loadedConfig, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %v", err)
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	return parseConfig(data, filepath.Dir(configPath))
+}
+
+// parseConfig loads config YAML; relative include paths resolve against baseDir.
+func parseConfig(data []byte, baseDir string) (*loadedConfig, error) {
+	data, err := expandConfigIncludes(data, baseDir)
+	if err != nil {
+		return nil, err
 	}
 
 	config := &Config{}
 	if err := yaml.Unmarshal(data, config); err != nil {
-		return fmt.Errorf("failed to parse config file: %v", err)
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
 
 	// Set defaults for any missing values
 	setDefaults(config)
 
+	resolver, bindings, err := loadSecretRefs(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %v", err)
+	}
+	if err := loadSecretFiles(config); err != nil {
+		return nil, fmt.Errorf("failed to load secrets: %v", err)
+	}
+
 	// Validate required fields
 	if err := validateConfig(config); err != nil {
-		return fmt.Errorf("config validation failed: %v", err)
+		return nil, fmt.Errorf("config validation failed: %v", err)
 	}
 
-	AppConfig = config
-	log.Printf("Configuration loaded successfully from %s", configPath)
+	return &loadedConfig{config: config, secretResolver: resolver, secretBindings: bindings}, nil
+}
+
+// loadSecretFiles resolves secrets configured as file paths, such as a
+// Kubernetes or Docker secret mounted into the container.
+func loadSecretFiles(config *Config) error {
+	path := strings.TrimSpace(config.Security.APIKeyFile)
+	if path == "" {
+		return nil
+	}
+	if strings.TrimSpace(config.Security.APIKey) != "" {
+		return fmt.Errorf("security.api_key and security.api_key_file are mutually exclusive")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("security.api_key_file: %v", err)
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		slog.Warn("security.api_key_file is world-readable", "path", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("security.api_key_file: %v", err)
+	}
+	config.Security.APIKey = strings.TrimSpace(string(data))
+	if config.Security.APIKey == "" {
+		return fmt.Errorf("security.api_key_file %s is empty", path)
+	}
 	return nil
 }
 
@@ -114,6 +555,17 @@ func setDefaults(config *Config) {
 	if len(config.Server.AllowedOrigins) == 0 {
 		config.Server.AllowedOrigins = []string{}
 	}
+	if config.TLS.MinVersion == "" {
+		config.TLS.MinVersion = "1.2"
+	}
+	setRouteHeaderDefaults(&config.Headers.WebSocket)
+	setRouteHeaderDefaults(&config.Headers.API)
+	if config.Compression.MinSize == 0 {
+		config.Compression.MinSize = 1024
+	}
+	if config.Compression.Level == 0 {
+		config.Compression.Level = 5
+	}
 
 	if config.WebSocket.WriteWait == 0 {
 		config.WebSocket.WriteWait = 10 * time.Second
@@ -133,6 +585,30 @@ func setDefaults(config *Config) {
 	if config.WebSocket.ReadDeadline == 0 {
 		config.WebSocket.ReadDeadline = 30 * time.Second
 	}
+	if config.WebSocket.ReaperInterval == 0 {
+		config.WebSocket.ReaperInterval = 30 * time.Second
+	}
+	if config.WebSocket.StalePongMultiplier == 0 {
+		config.WebSocket.StalePongMultiplier = 2
+	}
+	if config.WebSocket.FullBufferRatio == 0 {
+		config.WebSocket.FullBufferRatio = 0.9
+	}
+	if config.WebSocket.FullBufferSweeps == 0 {
+		config.WebSocket.FullBufferSweeps = 3
+	}
+	if config.WebSocket.BackpressureRatio == 0 {
+		config.WebSocket.BackpressureRatio = 0.75
+	}
+	if config.WebSocket.AckTimeout == 0 {
+		config.WebSocket.AckTimeout = 30 * time.Second
+	}
+	if config.WebSocket.RegisterBatchWindow == 0 {
+		config.WebSocket.RegisterBatchWindow = 10 * time.Millisecond
+	}
+	if config.WebSocket.RegisterBatchSize == 0 {
+		config.WebSocket.RegisterBatchSize = 256
+	}
 	if config.WebSocket.BufferSize.Read == 0 {
 		config.WebSocket.BufferSize.Read = 1024
 	}
@@ -146,6 +622,46 @@ func setDefaults(config *Config) {
 	if config.Backend.Timeout == 0 {
 		config.Backend.Timeout = 10 * time.Second
 	}
+	if config.Backend.TeamCheckTTL == 0 {
+		config.Backend.TeamCheckTTL = time.Minute
+	}
+	if config.Backend.Strategy == "" {
+		config.Backend.Strategy = "failover"
+	}
+	if config.Backend.HealthInterval == 0 {
+		config.Backend.HealthInterval = 10 * time.Second
+	}
+	if config.Backend.HealthThreshold == 0 {
+		config.Backend.HealthThreshold = 3
+	}
+	if config.Backend.JWT.RefreshInterval == 0 {
+		config.Backend.JWT.RefreshInterval = time.Hour
+	}
+	if config.Backend.JWT.Leeway == 0 {
+		config.Backend.JWT.Leeway = 30 * time.Second
+	}
+	if config.Backend.JWT.UserClaim == "" {
+		config.Backend.JWT.UserClaim = "sub"
+	}
+	if config.Backend.JWT.TeamClaim == "" {
+		config.Backend.JWT.TeamClaim = "selectedTeam"
+	}
+	if config.Backend.JWT.EmailClaim == "" {
+		config.Backend.JWT.EmailClaim = "email"
+	}
+
+	if config.Security.BruteForce.MaxFailures == 0 {
+		config.Security.BruteForce.MaxFailures = 10
+	}
+	if config.Security.BruteForce.Window == 0 {
+		config.Security.BruteForce.Window = 5 * time.Minute
+	}
+	if config.Security.BruteForce.BaseLockout == 0 {
+		config.Security.BruteForce.BaseLockout = 30 * time.Second
+	}
+	if config.Security.BruteForce.MaxLockout == 0 {
+		config.Security.BruteForce.MaxLockout = 30 * time.Minute
+	}
 
 	if config.Limits.MaxClientsPerTeam == 0 {
 		config.Limits.MaxClientsPerTeam = 1000
@@ -153,6 +669,18 @@ func setDefaults(config *Config) {
 	if config.Limits.SendChannelBuffer == 0 {
 		config.Limits.SendChannelBuffer = 256
 	}
+	if config.Limits.ControlChannelBuffer == 0 {
+		config.Limits.ControlChannelBuffer = 16
+	}
+	if config.Limits.MaxChannelsPerClient == 0 {
+		config.Limits.MaxChannelsPerClient = 50
+	}
+	if config.Limits.MaxDigestMessages == 0 {
+		config.Limits.MaxDigestMessages = 100
+	}
+	if config.Limits.MaxBatchMessages == 0 {
+		config.Limits.MaxBatchMessages = 50
+	}
 
 	if config.CircuitBreaker.Threshold == 0 {
 		config.CircuitBreaker.Threshold = 5
@@ -161,12 +689,18 @@ func setDefaults(config *Config) {
 		config.CircuitBreaker.Timeout = 60 * time.Second
 	}
 
+	if config.RateLimit.Backend == "" {
+		config.RateLimit.Backend = "memory"
+	}
 	if config.RateLimit.RequestsPerSecond == 0 {
 		config.RateLimit.RequestsPerSecond = 20
 	}
 	if config.RateLimit.Burst == 0 {
 		config.RateLimit.Burst = 60
 	}
+	if config.RateLimit.Key == "" {
+		config.RateLimit.Key = "ip"
+	}
 	if config.RateLimit.EntryTTL == 0 {
 		config.RateLimit.EntryTTL = 5 * time.Minute
 	}
@@ -174,12 +708,251 @@ func setDefaults(config *Config) {
 		config.RateLimit.CleanupInterval = time.Minute
 	}
 
+	if config.Storage.Driver == "" {
+		config.Storage.Driver = "memory"
+	}
+	if config.Storage.NotificationTTL == 0 {
+		config.Storage.NotificationTTL = 24 * time.Hour
+	}
+	if config.Storage.PruneInterval == 0 {
+		config.Storage.PruneInterval = 5 * time.Minute
+	}
+	if config.Limits.OfflineTTL == 0 {
+		config.Limits.OfflineTTL = config.Storage.NotificationTTL
+	}
+	if config.Storage.Redis.Address == "" {
+		config.Storage.Redis.Address = "127.0.0.1:6379"
+	}
+	if config.Storage.Redis.KeyPrefix == "" {
+		config.Storage.Redis.KeyPrefix = "notification_server"
+	}
+	if config.Storage.Redis.Timeout == 0 {
+		config.Storage.Redis.Timeout = 5 * time.Second
+	}
+
+	if config.Secrets.Timeout == 0 {
+		config.Secrets.Timeout = 10 * time.Second
+	}
+
+	if config.Abuse.Threshold == 0 {
+		config.Abuse.Threshold = 10
+	}
+	if config.Abuse.ScoreHalfLife == 0 {
+		config.Abuse.ScoreHalfLife = 10 * time.Minute
+	}
+	if config.Abuse.BanDuration == 0 {
+		config.Abuse.BanDuration = 15 * time.Minute
+	}
+	if config.Abuse.Weights.RateLimited == 0 {
+		config.Abuse.Weights.RateLimited = 1
+	}
+	if config.Abuse.Weights.MalformedMessage == 0 {
+		config.Abuse.Weights.MalformedMessage = 2
+	}
+	if config.Abuse.Weights.TeamSpoofing == 0 {
+		config.Abuse.Weights.TeamSpoofing = 5
+	}
+
+	if config.Blackout.CriticalMessageTypes == nil {
+		config.Blackout.CriticalMessageTypes = []string{"system_alert"}
+	}
+	if config.Blackout.MaxDeferredPerTeam == 0 {
+		config.Blackout.MaxDeferredPerTeam = 1000
+	}
+	if config.Blackout.CheckInterval == 0 {
+		config.Blackout.CheckInterval = time.Second
+	}
+	if config.Urgency.Default == "" {
+		config.Urgency.Default = urgencyNormal
+	}
+	if config.Urgency.Levels == nil {
+		config.Urgency.Levels = make(map[string]UrgencyPolicy, len(defaultUrgencyPolicies))
+	}
+	for level, policy := range defaultUrgencyPolicies {
+		if _, ok := config.Urgency.Levels[level]; !ok {
+			config.Urgency.Levels[level] = policy
+		}
+	}
+	if config.Escalation.Chain == nil {
+		config.Escalation.Chain = []string{escalationStepGroup, escalationStepAdmins}
+	}
+	if config.Escalation.AckTimeout == 0 {
+		config.Escalation.AckTimeout = 5 * time.Minute
+	}
+	if config.Escalation.CheckInterval == 0 {
+		config.Escalation.CheckInterval = 5 * time.Second
+	}
+	if config.Escalation.MaxTrails == 0 {
+		config.Escalation.MaxTrails = 100
+	}
+	if config.OnCall.HandoffWindow == 0 {
+		config.OnCall.HandoffWindow = time.Hour
+	}
+	if config.OnCall.CheckInterval == 0 {
+		config.OnCall.CheckInterval = 10 * time.Second
+	}
+	if config.OnCall.MaxShiftsPerTeam == 0 {
+		config.OnCall.MaxShiftsPerTeam = 200
+	}
+	if config.Incidents.MessageTypes == nil {
+		config.Incidents.MessageTypes = []string{"system_alert"}
+	}
+	if config.Incidents.RollupInterval == 0 {
+		config.Incidents.RollupInterval = 5 * time.Minute
+	}
+	if config.Incidents.ResolveAfter == 0 {
+		config.Incidents.ResolveAfter = 10 * time.Minute
+	}
+	if config.Incidents.CheckInterval == 0 {
+		config.Incidents.CheckInterval = 5 * time.Second
+	}
+	if config.Incidents.MaxOpen == 0 {
+		config.Incidents.MaxOpen = 1000
+	}
+	if config.Schedules.CheckInterval == 0 {
+		config.Schedules.CheckInterval = time.Second
+	}
+	if config.Schedules.MisfireGrace == 0 {
+		config.Schedules.MisfireGrace = time.Minute
+	}
+	if config.Schedules.HistorySize == 0 {
+		config.Schedules.HistorySize = 20
+	}
+	if config.Attachments.Provider == "" {
+		config.Attachments.Provider = "none"
+	}
+	if config.Attachments.URLTTL == 0 {
+		config.Attachments.URLTTL = 15 * time.Minute
+	}
+	if config.Attachments.MaxPerMessage == 0 {
+		config.Attachments.MaxPerMessage = 10
+	}
+	if config.Attachments.UploadPrefix == "" {
+		config.Attachments.UploadPrefix = "uploads/"
+	}
+	if config.Quota.WarnRatio == 0 {
+		config.Quota.WarnRatio = 0.9
+	}
+	if config.Quota.WarnCooldown == 0 {
+		config.Quota.WarnCooldown = time.Hour
+	}
+	if len(config.Mentions.MessageTypes) == 0 {
+		config.Mentions.MessageTypes = []string{"userMessage"}
+	}
+	if config.Mentions.MaxPerMessage == 0 {
+		config.Mentions.MaxPerMessage = 20
+	}
+	if config.Conversations.MaxPerUser == 0 {
+		config.Conversations.MaxPerUser = 200
+	}
+	if config.Conversations.SnippetLength == 0 {
+		config.Conversations.SnippetLength = 100
+	}
+	if config.KeywordAlerts.MaxPerUser == 0 {
+		config.KeywordAlerts.MaxPerUser = 20
+	}
+	if config.KeywordAlerts.MaxLength == 0 {
+		config.KeywordAlerts.MaxLength = 64
+	}
+	if config.Preferences.MaxKeys == 0 {
+		config.Preferences.MaxKeys = 100
+	}
+	if config.Preferences.MaxValueSize == 0 {
+		config.Preferences.MaxValueSize = 4096
+	}
+	if config.Audit.ReplayBuffer == 0 {
+		config.Audit.ReplayBuffer = 1000
+	}
+	if config.Snapshot.MaxAge == 0 {
+		config.Snapshot.MaxAge = 10 * time.Minute
+	}
+	if config.Handover.TTL == 0 {
+		config.Handover.TTL = 2 * time.Minute
+	}
+	if config.Handover.MaxHeld == 0 {
+		config.Handover.MaxHeld = 256
+	}
+	if config.Shutdown.Reason == "" {
+		config.Shutdown.Reason = "The server is restarting"
+	}
+	if config.Shutdown.NoticeGrace == 0 {
+		config.Shutdown.NoticeGrace = time.Second
+	}
+	if config.Analytics.FlushInterval == 0 {
+		config.Analytics.FlushInterval = time.Minute
+	}
+	if config.Analytics.Instance == "" {
+		config.Analytics.Instance, _ = os.Hostname()
+	}
+	if config.Archive.TTL == 0 {
+		config.Archive.TTL = 7 * 24 * time.Hour
+	}
+	if config.SLO.Window == 0 {
+		config.SLO.Window = 5 * time.Minute
+	}
+	if config.SLO.MinEvents == 0 {
+		config.SLO.MinEvents = 100
+	}
+	if config.Presence.SummaryTTL == 0 {
+		config.Presence.SummaryTTL = 5 * time.Second
+	}
+	if config.Recall.Window == 0 {
+		config.Recall.Window = 24 * time.Hour
+	}
+	if config.Recall.MaxTracked == 0 {
+		config.Recall.MaxTracked = 100000
+	}
+	if config.Webhooks.QueueSize == 0 {
+		config.Webhooks.QueueSize = 1000
+	}
+	if config.Webhooks.Workers == 0 {
+		config.Webhooks.Workers = 4
+	}
+	if config.Webhooks.MaxAttempts == 0 {
+		config.Webhooks.MaxAttempts = 5
+	}
+	if config.Webhooks.InitialBackoff == 0 {
+		config.Webhooks.InitialBackoff = time.Second
+	}
+	if config.Webhooks.MaxBackoff == 0 {
+		config.Webhooks.MaxBackoff = time.Minute
+	}
+	if config.Webhooks.Timeout == 0 {
+		config.Webhooks.Timeout = 5 * time.Second
+	}
+	if config.Stats.Interval == 0 {
+		config.Stats.Interval = 5 * time.Second
+	}
+
+	if config.Metrics.Backend == "" {
+		config.Metrics.Backend = "none"
+	}
+	if config.Metrics.Address == "" {
+		config.Metrics.Address = "127.0.0.1:8125"
+	}
+	if config.Metrics.Prefix == "" {
+		config.Metrics.Prefix = "notification_server"
+	}
+	if config.Metrics.FlushInterval == 0 {
+		config.Metrics.FlushInterval = 10 * time.Second
+	}
+
+	if config.Debug.WatchdogInterval == 0 {
+		config.Debug.WatchdogInterval = 30 * time.Second
+	}
+	if config.Debug.StackSampleBytes == 0 {
+		config.Debug.StackSampleBytes = 64 * 1024
+	}
+
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
 	if config.Logging.Format == "" {
 		config.Logging.Format = "text"
 	}
+	if config.Logging.AccessLogSampleRate == 0 {
+		config.Logging.AccessLogSampleRate = 1
+	}
 
 	// Environment defaults
 	if config.Environment.Mode == "" {
@@ -187,10 +960,21 @@ func setDefaults(config *Config) {
 	}
 }
 
+// backendURLs returns the backends auth calls go to: backend.urls, or
+// backend.url alone when the list is empty.
+func backendURLs(config *Config) []string {
+	if len(config.Backend.URLs) > 0 {
+		return config.Backend.URLs
+	}
+	return []string{config.Backend.URL}
+}
+
 func validateConfig(config *Config) error {
 	config.Security.APIKey = strings.TrimSpace(config.Security.APIKey)
 	config.Backend.URL = strings.TrimSpace(config.Backend.URL)
 	config.Environment.Mode = strings.ToLower(strings.TrimSpace(config.Environment.Mode))
+	config.Metrics.Backend = strings.ToLower(strings.TrimSpace(config.Metrics.Backend))
+	config.Storage.Driver = strings.ToLower(strings.TrimSpace(config.Storage.Driver))
 
 	if config.Security.APIKey == "" {
 		return fmt.Errorf("security.api_key is required")
@@ -198,6 +982,94 @@ func validateConfig(config *Config) error {
 	if config.Backend.URL == "" {
 		return fmt.Errorf("backend.url is required")
 	}
+	seenBackends := make(map[string]bool, len(config.Backend.URLs))
+	for i, url := range config.Backend.URLs {
+		url = strings.TrimRight(strings.TrimSpace(url), "/")
+		if url == "" {
+			return fmt.Errorf("backend.urls must not contain empty entries")
+		}
+		if seenBackends[url] {
+			return fmt.Errorf("backend.urls lists %s more than once", url)
+		}
+		seenBackends[url] = true
+		config.Backend.URLs[i] = url
+	}
+	config.Backend.Strategy = strings.ToLower(strings.TrimSpace(config.Backend.Strategy))
+	if config.Backend.Strategy != "failover" && config.Backend.Strategy != "round_robin" {
+		return fmt.Errorf("backend.strategy must be failover or round_robin")
+	}
+	if config.Compression.MinSize < 0 {
+		return fmt.Errorf("compression.min_size must not be negative")
+	}
+	if err := validateRouteHeaders("headers.websocket", config.Headers.WebSocket); err != nil {
+		return err
+	}
+	if err := validateRouteHeaders("headers.api", config.Headers.API); err != nil {
+		return err
+	}
+	if config.ClientConfig.WebSocketURL != "" {
+		parsed, err := url.Parse(config.ClientConfig.WebSocketURL)
+		if err != nil || (parsed.Scheme != "ws" && parsed.Scheme != "wss") || parsed.Host == "" {
+			return fmt.Errorf("client_config.websocket_url must be a ws:// or wss:// URL")
+		}
+	}
+	// A URL derived from the request's Host header is chosen by whoever sends
+	// the request, so the server must not sign one.
+	if config.ClientConfig.SigningKeyFile != "" && config.ClientConfig.WebSocketURL == "" {
+		return fmt.Errorf("client_config.signing_key_file requires client_config.websocket_url")
+	}
+	for _, name := range builtinClientFeatures {
+		if _, ok := config.ClientConfig.Features[name]; ok {
+			return fmt.Errorf("client_config.features.%s is set by the server and cannot be configured", name)
+		}
+	}
+	if config.Compression.Level < 1 || config.Compression.Level > 9 {
+		return fmt.Errorf("compression.level must be between 1 and 9")
+	}
+	config.Backend.TeamCheckPath = strings.TrimSpace(config.Backend.TeamCheckPath)
+	if config.Backend.TeamCheckPath != "" && (!strings.HasPrefix(config.Backend.TeamCheckPath, "/") || !strings.Contains(config.Backend.TeamCheckPath, "{teamId}")) {
+		return fmt.Errorf("backend.team_check_path must start with / and contain {teamId}")
+	}
+	if config.Backend.TeamCheckTTL <= 0 {
+		return fmt.Errorf("backend.team_check_ttl must be greater than 0")
+	}
+	config.Backend.HealthPath = strings.TrimSpace(config.Backend.HealthPath)
+	if config.Backend.HealthPath != "" && !strings.HasPrefix(config.Backend.HealthPath, "/") {
+		return fmt.Errorf("backend.health_path must start with /")
+	}
+	if config.Backend.HealthInterval <= 0 {
+		return fmt.Errorf("backend.health_interval must be greater than 0")
+	}
+	if config.Backend.HealthThreshold < 1 {
+		return fmt.Errorf("backend.health_threshold must be at least 1")
+	}
+	if config.Backend.AuthCacheTTL < 0 {
+		return fmt.Errorf("backend.auth_cache_ttl must not be negative")
+	}
+	if config.Backend.JWT.JWKSURL != "" {
+		parsed, err := url.Parse(config.Backend.JWT.JWKSURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("backend.jwt.jwks_url must be an http:// or https:// URL")
+		}
+	}
+	if config.Backend.JWT.RefreshInterval <= 0 {
+		return fmt.Errorf("backend.jwt.refresh_interval must be greater than 0")
+	}
+	if config.Backend.JWT.Leeway < 0 {
+		return fmt.Errorf("backend.jwt.leeway must not be negative")
+	}
+	if _, err := newIPPolicy(config.Security.IPAllowlist, config.Security.IPDenylist); err != nil {
+		return err
+	}
+	if config.Security.BruteForce.MaxFailures < 1 {
+		return fmt.Errorf("security.brute_force.max_failures must be greater than 0")
+	}
+	if config.Security.BruteForce.Window <= 0 || config.Security.BruteForce.BaseLockout <= 0 {
+		return fmt.Errorf("security.brute_force.window and security.brute_force.base_lockout must be greater than 0")
+	}
+	if config.Security.BruteForce.MaxLockout < config.Security.BruteForce.BaseLockout {
+		return fmt.Errorf("security.brute_force.max_lockout must not be less than security.brute_force.base_lockout")
+	}
 	if config.Environment.Mode != "development" && config.Environment.Mode != "production" {
 		return fmt.Errorf("environment.mode must be either development or production")
 	}
@@ -213,12 +1085,69 @@ func validateConfig(config *Config) error {
 	if config.WebSocket.AuthMaxMessageSize > config.WebSocket.MaxMessageSize {
 		return fmt.Errorf("websocket.auth_max_message_size must not exceed websocket.max_message_size")
 	}
+	if config.WebSocket.ReaperInterval <= 0 {
+		return fmt.Errorf("websocket.reaper_interval must be greater than 0")
+	}
+	if config.WebSocket.StalePongMultiplier < 1 {
+		return fmt.Errorf("websocket.stale_pong_multiplier must be at least 1")
+	}
+	if config.WebSocket.FullBufferRatio <= 0 || config.WebSocket.FullBufferRatio > 1 {
+		return fmt.Errorf("websocket.full_buffer_ratio must be greater than 0 and at most 1")
+	}
+	if config.WebSocket.FullBufferSweeps < 1 {
+		return fmt.Errorf("websocket.full_buffer_sweeps must be greater than 0")
+	}
+	if config.WebSocket.BackpressureRatio <= 0 || config.WebSocket.BackpressureRatio > 1 {
+		return fmt.Errorf("websocket.backpressure_ratio must be greater than 0 and at most 1")
+	}
+	if config.WebSocket.AckTimeout <= 0 {
+		return fmt.Errorf("websocket.ack_timeout must be greater than 0")
+	}
+	if config.WebSocket.RegisterBatchWindow < 0 || config.WebSocket.RegisterBatchWindow > time.Second {
+		return fmt.Errorf("websocket.register_batch_window must be between 0 and 1s")
+	}
+	if config.WebSocket.RegisterBatchSize < 1 {
+		return fmt.Errorf("websocket.register_batch_size must be greater than 0")
+	}
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		return fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+	}
+	if config.TLS.ReloadInterval < 0 {
+		return fmt.Errorf("tls.reload_interval must not be negative")
+	}
+	if err := validateTLSPolicy(config); err != nil {
+		return err
+	}
+	if (config.TCP.CertFile == "") != (config.TCP.KeyFile == "") {
+		return fmt.Errorf("tcp.cert_file and tcp.key_file must be set together")
+	}
+	if config.TCP.Address != "" && config.TCP.CertFile == "" && !config.TCP.AllowPlaintext {
+		return fmt.Errorf("tcp.cert_file and tcp.key_file are required unless tcp.allow_plaintext is set")
+	}
 	if config.Limits.MaxClientsPerTeam < 1 {
 		return fmt.Errorf("limits.max_clients_per_team must be greater than 0")
 	}
 	if config.Limits.SendChannelBuffer < 1 {
 		return fmt.Errorf("limits.send_channel_buffer must be greater than 0")
 	}
+	if config.Limits.ControlChannelBuffer < 1 {
+		return fmt.Errorf("limits.control_channel_buffer must be greater than 0")
+	}
+	if config.Limits.MaxChannelsPerClient < 1 {
+		return fmt.Errorf("limits.max_channels_per_client must be greater than 0")
+	}
+	if config.Limits.MaxDigestMessages < 1 {
+		return fmt.Errorf("limits.max_digest_messages must be greater than 0")
+	}
+	if config.Limits.MaxBatchMessages < 1 {
+		return fmt.Errorf("limits.max_batch_messages must be greater than 0")
+	}
+	if config.Limits.OfflineQueueDepth < 0 {
+		return fmt.Errorf("limits.offline_queue_depth must not be negative")
+	}
+	if config.Limits.OfflineTTL < 0 {
+		return fmt.Errorf("limits.offline_ttl must not be negative")
+	}
 	if config.RateLimit.RequestsPerSecond <= 0 {
 		return fmt.Errorf("rate_limit.requests_per_second must be greater than 0")
 	}
@@ -231,56 +1160,340 @@ func validateConfig(config *Config) error {
 	if config.RateLimit.CleanupInterval <= 0 {
 		return fmt.Errorf("rate_limit.cleanup_interval must be greater than 0")
 	}
+	switch config.RateLimit.Backend {
+	case "memory", "redis":
+	default:
+		return fmt.Errorf("rate_limit.backend must be memory or redis")
+	}
+	switch config.RateLimit.Key {
+	case "ip", "tenant", "api_key":
+	default:
+		return fmt.Errorf("rate_limit.key must be ip, tenant or api_key")
+	}
+	if err := validateRateLimitPolicies(config); err != nil {
+		return err
+	}
+	switch config.Storage.Driver {
+	case "memory", "redis":
+	case "sql":
+		if config.Storage.SQL.Driver == "" || config.Storage.SQL.DSN == "" {
+			return fmt.Errorf("storage.sql.driver and storage.sql.dsn are required for the sql storage driver")
+		}
+		if !slices.Contains(sql.Drivers(), config.Storage.SQL.Driver) {
+			return fmt.Errorf("storage.sql.driver %q is not linked into this build", config.Storage.SQL.Driver)
+		}
+	default:
+		return fmt.Errorf("storage.driver must be one of memory, redis or sql")
+	}
+	if config.Storage.Encryption.Enabled {
+		keys := make(map[string]string, len(config.Storage.Encryption.Keys))
+		for _, key := range config.Storage.Encryption.Keys {
+			if key.ID == "" {
+				return fmt.Errorf("storage.encryption.keys entries require an id")
+			}
+			if _, ok := keys[key.ID]; ok {
+				return fmt.Errorf("storage.encryption.keys has duplicate id %q", key.ID)
+			}
+			keys[key.ID] = key.Key
+		}
+		if _, err := newKeyring(config.Storage.Encryption.ActiveKeyID, keys); err != nil {
+			return fmt.Errorf("storage.encryption: %v", err)
+		}
+	}
+	if config.Storage.NotificationTTL <= 0 {
+		return fmt.Errorf("storage.notification_ttl must be greater than 0")
+	}
+	if config.Storage.PruneInterval <= 0 {
+		return fmt.Errorf("storage.prune_interval must be greater than 0")
+	}
+	if config.Secrets.RefreshInterval < 0 {
+		return fmt.Errorf("secrets.refresh_interval must not be negative")
+	}
+	config.Abuse.WebhookURL = strings.TrimSpace(config.Abuse.WebhookURL)
+	if config.Abuse.Threshold <= 0 {
+		return fmt.Errorf("abuse.threshold must be greater than 0")
+	}
+	if config.Abuse.ScoreHalfLife <= 0 || config.Abuse.BanDuration <= 0 {
+		return fmt.Errorf("abuse.score_half_life and abuse.ban_duration must be greater than 0")
+	}
+	if config.Abuse.Weights.RateLimited < 0 || config.Abuse.Weights.MalformedMessage < 0 || config.Abuse.Weights.TeamSpoofing < 0 {
+		return fmt.Errorf("abuse.weights must not be negative")
+	}
+	if config.Blackout.MaxDeferredPerTeam < 1 {
+		return fmt.Errorf("blackout.max_deferred_per_team must be greater than 0")
+	}
+	if config.Blackout.CheckInterval <= 0 {
+		return fmt.Errorf("blackout.check_interval must be greater than 0")
+	}
+	if !validUrgency(config.Urgency.Default) {
+		return fmt.Errorf("urgency.default must be low, normal, high or critical")
+	}
+	for level := range config.Urgency.Levels {
+		if !validUrgency(level) {
+			return fmt.Errorf("urgency.levels may only list low, normal, high and critical, not %q", level)
+		}
+	}
+	if err := validateEscalationChain(config.Escalation.Chain); err != nil {
+		return fmt.Errorf("escalation.chain: %w", err)
+	}
+	for teamID, chain := range config.Escalation.Teams {
+		if strings.TrimSpace(teamID) == "" {
+			return fmt.Errorf("escalation.teams keys must not be empty")
+		}
+		if err := validateEscalationChain(chain); err != nil {
+			return fmt.Errorf("escalation.teams.%s: %w", teamID, err)
+		}
+	}
+	if config.Escalation.AckTimeout <= 0 || config.Escalation.CheckInterval <= 0 {
+		return fmt.Errorf("escalation.ack_timeout and escalation.check_interval must be greater than 0")
+	}
+	if config.Escalation.MaxTrails < 1 {
+		return fmt.Errorf("escalation.max_trails must be at least 1")
+	}
+	if config.OnCall.HandoffWindow <= 0 || config.OnCall.CheckInterval <= 0 {
+		return fmt.Errorf("oncall.handoff_window and oncall.check_interval must be greater than 0")
+	}
+	if config.OnCall.MaxShiftsPerTeam < 1 {
+		return fmt.Errorf("oncall.max_shifts_per_team must be at least 1")
+	}
+	for _, messageType := range config.Incidents.MessageTypes {
+		if strings.TrimSpace(messageType) == "" {
+			return fmt.Errorf("incidents.message_types entries must not be empty")
+		}
+	}
+	if config.Incidents.RollupInterval <= 0 || config.Incidents.ResolveAfter <= 0 || config.Incidents.CheckInterval <= 0 {
+		return fmt.Errorf("incidents.rollup_interval, incidents.resolve_after and incidents.check_interval must be greater than 0")
+	}
+	if config.Incidents.MaxOpen < 1 {
+		return fmt.Errorf("incidents.max_open must be at least 1")
+	}
+	for groupKey, policy := range config.Incidents.Groups {
+		if strings.TrimSpace(groupKey) == "" {
+			return fmt.Errorf("incidents.groups keys must not be empty")
+		}
+		if policy.RollupInterval < 0 || policy.ResolveAfter < 0 {
+			return fmt.Errorf("incidents.groups.%s rollup_interval and resolve_after must not be negative", groupKey)
+		}
+	}
+	if config.Schedules.CheckInterval <= 0 || config.Schedules.CheckInterval > time.Minute {
+		return fmt.Errorf("schedules.check_interval must be greater than 0 and at most 1m")
+	}
+	if config.Schedules.MisfireGrace < config.Schedules.CheckInterval {
+		return fmt.Errorf("schedules.misfire_grace must be at least schedules.check_interval")
+	}
+	if config.Schedules.HistorySize < 1 {
+		return fmt.Errorf("schedules.history_size must be at least 1")
+	}
+	switch config.Attachments.Provider {
+	case "none":
+	case "s3", "gcs":
+		if config.Attachments.Bucket == "" {
+			return fmt.Errorf("attachments.bucket is required when attachments.provider is %s", config.Attachments.Provider)
+		}
+	default:
+		return fmt.Errorf("attachments.provider must be none, s3 or gcs")
+	}
+	// Signature Version 4 URLs are valid for at most seven days.
+	if config.Attachments.URLTTL < time.Second || config.Attachments.URLTTL > 7*24*time.Hour {
+		return fmt.Errorf("attachments.url_ttl must be between 1s and 168h")
+	}
+	if config.Attachments.MaxPerMessage < 0 {
+		return fmt.Errorf("attachments.max_per_message must not be negative")
+	}
+	if config.Attachments.MaxUploadSize < 0 {
+		return fmt.Errorf("attachments.max_upload_size must not be negative")
+	}
+	if config.Attachments.MaxUploadSize > 0 && config.Attachments.Provider == "none" {
+		return fmt.Errorf("attachments.max_upload_size requires attachments.provider")
+	}
+	if config.Quota.WarnRatio <= 0 || config.Quota.WarnRatio > 1 {
+		return fmt.Errorf("quota.warn_ratio must be greater than 0 and at most 1")
+	}
+	if config.Quota.WarnCooldown <= 0 {
+		return fmt.Errorf("quota.warn_cooldown must be greater than 0")
+	}
+	if config.Analytics.FlushInterval < time.Second {
+		return fmt.Errorf("analytics.flush_interval must be at least 1s")
+	}
+	if config.Archive.SampleRate < 0 || config.Archive.SampleRate > 1 {
+		return fmt.Errorf("archive.sample_rate must be between 0 and 1")
+	}
+	if config.Archive.TTL < 0 {
+		return fmt.Errorf("archive.ttl must not be negative")
+	}
+	if config.SLO.DeliverySuccess < 0 || config.SLO.DeliverySuccess > 1 {
+		return fmt.Errorf("slo.delivery_success must be between 0 and 1")
+	}
+	if config.SLO.AuthSuccess < 0 || config.SLO.AuthSuccess > 1 {
+		return fmt.Errorf("slo.auth_success must be between 0 and 1")
+	}
+	if config.SLO.P99Latency < 0 {
+		return fmt.Errorf("slo.p99_latency must not be negative")
+	}
+	if !sloWindowAllowed(config.SLO.Window) {
+		return fmt.Errorf("slo.window must be 5m, 1h or 24h")
+	}
+	if config.SLO.MinEvents < 0 {
+		return fmt.Errorf("slo.min_events must not be negative")
+	}
+	if config.Presence.SummaryTTL < 0 {
+		return fmt.Errorf("presence.summary_ttl must not be negative")
+	}
+	if config.Conversations.MaxPerUser < 1 {
+		return fmt.Errorf("conversations.max_per_user must be at least 1")
+	}
+	if config.Conversations.SnippetLength < 1 {
+		return fmt.Errorf("conversations.snippet_length must be at least 1")
+	}
+	if config.Mentions.MaxPerMessage < 1 {
+		return fmt.Errorf("mentions.max_per_message must be at least 1")
+	}
+	for _, messageType := range config.Mentions.MessageTypes {
+		if strings.TrimSpace(messageType) == "" {
+			return fmt.Errorf("mentions.message_types entries must not be empty")
+		}
+	}
+	if config.KeywordAlerts.MaxPerUser < 1 {
+		return fmt.Errorf("keyword_alerts.max_per_user must be at least 1")
+	}
+	if config.KeywordAlerts.MaxLength < 1 {
+		return fmt.Errorf("keyword_alerts.max_length must be at least 1")
+	}
+	if config.KeywordAlerts.Enabled && !config.Preferences.Sync {
+		return fmt.Errorf("keyword_alerts.enabled requires preferences.sync")
+	}
+	if config.Preferences.MaxKeys < 1 {
+		return fmt.Errorf("preferences.max_keys must be at least 1")
+	}
+	if config.Preferences.MaxValueSize < 1 {
+		return fmt.Errorf("preferences.max_value_size must be at least 1")
+	}
+	if config.Audit.ReplayBuffer < 1 {
+		return fmt.Errorf("audit.replay_buffer must be at least 1")
+	}
+	if config.Snapshot.MaxAge <= 0 {
+		return fmt.Errorf("snapshot.max_age must be greater than 0")
+	}
+	if config.Handover.TTL <= 0 {
+		return fmt.Errorf("handover.ttl must be greater than 0")
+	}
+	if config.Handover.MaxHeld < 1 {
+		return fmt.Errorf("handover.max_held must be at least 1")
+	}
+	if config.Shutdown.EstimatedDowntime < 0 {
+		return fmt.Errorf("shutdown.estimated_downtime must not be negative")
+	}
+	if config.Shutdown.NoticeGrace < 0 || config.Shutdown.NoticeGrace >= shutdownTimeout {
+		return fmt.Errorf("shutdown.notice_grace must be between 0 and %s", shutdownTimeout)
+	}
+	if config.Recall.Window <= 0 {
+		return fmt.Errorf("recall.window must be greater than 0")
+	}
+	if config.Recall.MaxTracked < 1 {
+		return fmt.Errorf("recall.max_tracked must be at least 1")
+	}
+	if config.Webhooks.QueueSize < 1 || config.Webhooks.Workers < 1 || config.Webhooks.MaxAttempts < 1 {
+		return fmt.Errorf("webhooks.queue_size, webhooks.workers and webhooks.max_attempts must be greater than 0")
+	}
+	if config.Webhooks.InitialBackoff <= 0 || config.Webhooks.MaxBackoff < config.Webhooks.InitialBackoff {
+		return fmt.Errorf("webhooks.initial_backoff must be greater than 0 and at most webhooks.max_backoff")
+	}
+	if config.Webhooks.Timeout <= 0 {
+		return fmt.Errorf("webhooks.timeout must be greater than 0")
+	}
+	if config.Webhooks.MaxPerTeam < 0 {
+		return fmt.Errorf("webhooks.max_per_team must not be negative")
+	}
+	if _, err := parseLogLevel(config.Logging.Level); err != nil {
+		return err
+	}
+	if config.Logging.Format != "text" && config.Logging.Format != "json" {
+		return fmt.Errorf("logging.format must be text or json")
+	}
+	if config.Logging.AccessLogSampleRate <= 0 || config.Logging.AccessLogSampleRate > 1 {
+		return fmt.Errorf("logging.access_log_sample_rate must be greater than 0 and at most 1")
+	}
+	if config.Stats.Interval < time.Second {
+		return fmt.Errorf("stats.interval must be at least 1s")
+	}
+	if err := validateTenants(config); err != nil {
+		return err
+	}
+	if err := validateRetention(config); err != nil {
+		return err
+	}
+	switch config.Metrics.Backend {
+	case "none", "statsd", "dogstatsd":
+	default:
+		return fmt.Errorf("metrics.backend must be one of none, statsd or dogstatsd")
+	}
+	if config.Debug.WatchdogInterval <= 0 {
+		return fmt.Errorf("debug.watchdog_interval must be greater than 0")
+	}
+	if config.Debug.StackSampleBytes < 1 {
+		return fmt.Errorf("debug.stack_sample_bytes must be greater than 0")
+	}
+	if config.Metrics.FlushInterval <= 0 {
+		return fmt.Errorf("metrics.flush_interval must be greater than 0")
+	}
 	return nil
 }
 
 // Environment helper functions
 func IsDevelopment() bool {
-	if AppConfig == nil {
+	if AppConfig() == nil {
 		return false
 	}
-	return AppConfig.Environment.Mode == "development"
+	return AppConfig().Environment.Mode == "development"
 }
 
 func IsProduction() bool {
-	if AppConfig == nil {
+	if AppConfig() == nil {
 		return true // Default to production for safety
 	}
-	return AppConfig.Environment.Mode == "production"
+	return AppConfig().Environment.Mode == "production"
 }
 
 func ShouldAllowAllOrigins() bool {
-	if AppConfig == nil {
+	if AppConfig() == nil {
 		return false
 	}
 	// Allow all origins if explicitly set OR if in development mode
-	return AppConfig.Environment.AllowAllOrigins || IsDevelopment()
+	return AppConfig().Environment.AllowAllOrigins || IsDevelopment()
 }
 
 func IsFakeAuthEnabled() bool {
-	if AppConfig == nil {
+	if AppConfig() == nil {
 		return false
 	}
 	// Only allow fake auth in development
-	return AppConfig.Environment.EnableFakeAuth && IsDevelopment()
+	return AppConfig().Environment.EnableFakeAuth && IsDevelopment()
+}
+
+func IsLeakWatchdogEnabled() bool {
+	if AppConfig() == nil {
+		return false
+	}
+	// The watchdog samples full goroutine stacks, so it is development only
+	return AppConfig().Debug.LeakWatchdog && IsDevelopment()
 }
 
 // Enhanced IsOriginAllowed function
 func IsOriginAllowed(origin string) bool {
-	if AppConfig == nil {
+	if AppConfig() == nil {
 		return false
 	}
 
 	// In development, allow all origins if configured
 	if ShouldAllowAllOrigins() {
-		log.Printf("🧪 DEV: Allowing origin %s (development mode)", origin)
+		slog.Debug("Allowing origin in development mode", "origin", origin)
 		return true
 	}
 
 	// In production, check against allowed origins list
-	for _, allowed := range AppConfig.Server.AllowedOrigins {
+	for _, allowed := range AppConfig().Server.AllowedOrigins {
 		if allowed == "*" {
-			log.Printf("⚠️  WARNING: Wildcard origin allowed in production!")
+			slog.Warn("Wildcard origin allowed in production")
 			return true
 		}
 		if allowed == origin {
@@ -288,6 +1501,6 @@ func IsOriginAllowed(origin string) bool {
 		}
 	}
 
-	log.Printf("❌ Origin rejected: %s", origin)
+	slog.Warn("Origin rejected", "origin", origin)
 	return false
 }
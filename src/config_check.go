@@ -0,0 +1,202 @@
+// config_check.go
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// namedAddress is a listener or peer address together with the setting it
+// came from.
+type namedAddress struct {
+	setting string
+	address string
+}
+
+// listenAddresses returns every address the server listens on. New listeners
+// must be added here so preflight can detect port collisions.
+func listenAddresses(config *Config) []namedAddress {
+	addresses := []namedAddress{
+		{setting: "server.port", address: ":" + config.Server.Port},
+	}
+	if config.TCP.Address != "" {
+		addresses = append(addresses, namedAddress{setting: "tcp.address", address: config.TCP.Address})
+	}
+	return addresses
+}
+
+// preflightConfig runs the checks that go beyond validateConfig: they look at
+// the environment (files, ports, URLs) rather than single values, and report
+// every problem instead of stopping at the first.
+func preflightConfig(config *Config) []error {
+	var problems []error
+
+	seen := make(map[string]string)
+	for _, listener := range listenAddresses(config) {
+		_, port, err := net.SplitHostPort(listener.address)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: invalid address %q", listener.setting, listener.address))
+			continue
+		}
+		number, err := strconv.Atoi(port)
+		if err != nil || number < 1 || number > 65535 {
+			problems = append(problems, fmt.Errorf("%s: port must be between 1 and 65535, got %q", listener.setting, port))
+			continue
+		}
+		if other, ok := seen[port]; ok {
+			problems = append(problems, fmt.Errorf("%s: port %s is already used by %s", listener.setting, port, other))
+			continue
+		}
+		seen[port] = listener.setting
+	}
+
+	for _, file := range []namedAddress{
+		{setting: "security.api_key_file", address: config.Security.APIKeyFile},
+		{setting: "secrets.vault.token_file", address: config.Secrets.Vault.TokenFile},
+		{setting: "tls.ocsp_staple_file", address: config.TLS.OCSPStapleFile},
+	} {
+		if file.address == "" {
+			continue
+		}
+		if _, err := os.Stat(file.address); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", file.setting, err))
+		}
+	}
+
+	if config.TLS.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(config.TLS.CertFile, config.TLS.KeyFile); err != nil {
+			problems = append(problems, fmt.Errorf("tls.cert_file: %v", err))
+		}
+	}
+	if config.ClientConfig.SigningKeyFile != "" {
+		if _, err := loadClientConfigSigner(config.ClientConfig.SigningKeyFile); err != nil {
+			problems = append(problems, fmt.Errorf("client_config.signing_key_file: %v", err))
+		}
+	}
+	if config.TCP.Address != "" && config.TCP.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(config.TCP.CertFile, config.TCP.KeyFile); err != nil {
+			problems = append(problems, fmt.Errorf("tcp.cert_file: %v", err))
+		}
+	}
+
+	for _, file := range []namedAddress{
+		{setting: "snapshot.path", address: config.Snapshot.Path},
+		{setting: "control.socket", address: config.Control.Socket},
+	} {
+		if file.address == "" {
+			continue
+		}
+		if info, err := os.Stat(filepath.Dir(file.address)); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", file.setting, err))
+		} else if !info.IsDir() {
+			problems = append(problems, fmt.Errorf("%s: %s is not a directory", file.setting, filepath.Dir(file.address)))
+		}
+	}
+
+	endpoints := []namedAddress{
+		{setting: "backend.url", address: config.Backend.URL},
+		{setting: "abuse.webhook_url", address: config.Abuse.WebhookURL},
+		{setting: "quota.webhook_url", address: config.Quota.WebhookURL},
+		{setting: "attachments.endpoint", address: config.Attachments.Endpoint},
+		{setting: "handover.peer_url", address: config.Handover.PeerURL},
+	}
+	for i, backend := range config.Backend.URLs {
+		endpoints = append(endpoints, namedAddress{setting: fmt.Sprintf("backend.urls[%d]", i), address: backend})
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.address == "" {
+			continue
+		}
+		parsed, err := url.Parse(endpoint.address)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problems = append(problems, fmt.Errorf("%s: must be an absolute http or https URL", endpoint.setting))
+		}
+	}
+
+	if config.Metrics.Backend != "none" {
+		if _, err := net.ResolveUDPAddr("udp", config.Metrics.Address); err != nil {
+			problems = append(problems, fmt.Errorf("metrics.address: %v", err))
+		}
+	}
+
+	return problems
+}
+
+// checkConfig loads, validates and preflights a config without installing it.
+// It returns every problem found.
+func checkConfig(load func() (*loadedConfig, error)) []string {
+	loaded, err := load()
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	for _, problem := range preflightConfig(loaded.config) {
+		problems = append(problems, problem.Error())
+	}
+	return problems
+}
+
+// runValidateConfig backs the -validate-config flag and returns the process exit code.
+func runValidateConfig(configPath string) int {
+	problems := checkConfig(func() (*loadedConfig, error) { return readConfigFile(configPath) })
+	if len(problems) == 0 {
+		fmt.Printf("✅ %s is valid\n", configPath)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "❌ %s has %d problem(s):\n", configPath, len(problems))
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+	}
+	return 1
+}
+
+type configValidationResponse struct {
+	Valid  bool     `json:"valid"`
+	Source string   `json:"source"`
+	Errors []string `json:"errors"`
+}
+
+// handleAdminConfigValidate validates a candidate config without applying it:
+// the YAML request body for POST, or the file the server was started with for
+// GET (for example before a reload).
+func handleAdminConfigValidate(w http.ResponseWriter, r *http.Request) {
+	var (
+		source string
+		load   func() (*loadedConfig, error)
+	)
+
+	switch r.Method {
+	case http.MethodGet:
+		source = activeConfigPath
+		load = func() (*loadedConfig, error) { return readConfigFile(activeConfigPath) }
+	case http.MethodPost:
+		data, err := readLimitedBody(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		source = "request body"
+		load = func() (*loadedConfig, error) { return parseConfig(data, filepath.Dir(activeConfigPath)) }
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	problems := checkConfig(load)
+	if problems == nil {
+		problems = []string{}
+	}
+	writeJSON(w, http.StatusOK, configValidationResponse{
+		Valid:  len(problems) == 0,
+		Source: source,
+		Errors: problems,
+	})
+}
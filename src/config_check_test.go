@@ -0,0 +1,96 @@
+// config_check_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreflightConfig(t *testing.T) {
+	config := &Config{}
+	setDefaults(config)
+	config.Security.APIKey = "key"
+	config.Backend.URL = "http://backend:8000"
+
+	if problems := preflightConfig(config); len(problems) != 0 {
+		t.Fatalf("expected a default config to pass preflight, got %v", problems)
+	}
+
+	config.Server.Port = "99999"
+	config.Backend.URL = "backend:8000"
+	config.Security.APIKeyFile = filepath.Join(t.TempDir(), "missing")
+
+	problems := preflightConfig(config)
+	if len(problems) != 3 {
+		t.Fatalf("expected 3 problems, got %d: %v", len(problems), problems)
+	}
+	for i, setting := range []string{"server.port", "security.api_key_file", "backend.url"} {
+		if !strings.HasPrefix(problems[i].Error(), setting) {
+			t.Errorf("problem %d should name %s, got %q", i, setting, problems[i])
+		}
+	}
+}
+
+func TestHandleAdminConfigValidate(t *testing.T) {
+	setupTestAppConfig()
+
+	testCases := []struct {
+		name   string
+		body   string
+		valid  bool
+		substr string
+	}{
+		{"valid config", "security:\n  api_key: k\nbackend:\n  url: http://backend\n", true, ""},
+		{"validation error", "backend:\n  url: http://backend\n", false, "security.api_key is required"},
+		{"ping period not below pong wait", "security:\n  api_key: k\nbackend:\n  url: http://backend\nwebsocket:\n  ping_period: 60s\n  pong_wait: 30s\n", false, "ping_period"},
+		{"preflight error", "security:\n  api_key: k\nbackend:\n  url: backend\n", false, "backend.url"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handleAdminConfigValidate(rr, httptest.NewRequest(http.MethodPost, "/admin/config/validate", strings.NewReader(tc.body)))
+
+			var response configValidationResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if response.Valid != tc.valid {
+				t.Fatalf("valid = %v, want %v (errors %v)", response.Valid, tc.valid, response.Errors)
+			}
+			if tc.substr != "" && !strings.Contains(strings.Join(response.Errors, "\n"), tc.substr) {
+				t.Fatalf("expected an error mentioning %q, got %v", tc.substr, response.Errors)
+			}
+		})
+	}
+}
+
+func TestHandleAdminConfigValidate_ActiveFile(t *testing.T) {
+	setupTestAppConfig()
+	configFile, cleanup := createTempConfigFile(t, "security:\n  api_key: k\nbackend:\n  url: http://backend\n")
+	defer cleanup()
+	activeConfigPath = configFile
+	defer func() { activeConfigPath = "" }()
+
+	rr := httptest.NewRecorder()
+	handleAdminConfigValidate(rr, httptest.NewRequest(http.MethodGet, "/admin/config/validate", nil))
+
+	var response configValidationResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if !response.Valid || response.Source != configFile {
+		t.Fatalf("expected the active config file to validate, got %+v", response)
+	}
+}
+
+func TestRunValidateConfig(t *testing.T) {
+	configFile, cleanup := createTempConfigFile(t, "backend:\n  url: http://backend\n")
+	defer cleanup()
+
+	if code := runValidateConfig(configFile); code != 1 {
+		t.Fatalf("expected exit code 1 for an invalid config, got %d", code)
+	}
+}
@@ -0,0 +1,122 @@
+// config_include.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+const maxConfigIncludeDepth = 8
+
+type configDocument = map[interface{}]interface{}
+
+// expandConfigIncludes resolves a document's top-level `include:` entry (a
+// path or list of paths, relative to baseDir). Included files are merged in
+// order and the including document is overlaid on top, so a production file
+// only needs the settings that differ from its base. It returns data
+// unchanged when there is nothing to include.
+func expandConfigIncludes(data []byte, baseDir string) ([]byte, error) {
+	var doc configDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if _, ok := doc["include"]; !ok {
+		return data, nil
+	}
+
+	merged, err := resolveConfigIncludes(doc, baseDir, map[string]bool{}, 0)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(merged)
+}
+
+func resolveConfigIncludes(doc configDocument, baseDir string, visiting map[string]bool, depth int) (configDocument, error) {
+	includes, err := configIncludePaths(doc["include"])
+	if err != nil {
+		return nil, err
+	}
+	delete(doc, "include")
+	if len(includes) == 0 {
+		return doc, nil
+	}
+	if depth >= maxConfigIncludeDepth {
+		return nil, fmt.Errorf("config includes nested more than %d levels deep", maxConfigIncludeDepth)
+	}
+
+	merged := configDocument{}
+	for _, include := range includes {
+		path := include
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if visiting[path] {
+			return nil, fmt.Errorf("config include cycle at %s", path)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read included config: %v", err)
+		}
+		var included configDocument
+		if err := yaml.Unmarshal(data, &included); err != nil {
+			return nil, fmt.Errorf("failed to parse included config %s: %v", path, err)
+		}
+
+		visiting[path] = true
+		included, err = resolveConfigIncludes(included, filepath.Dir(path), visiting, depth+1)
+		delete(visiting, path)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeConfigDocuments(merged, included)
+	}
+	return mergeConfigDocuments(merged, doc), nil
+}
+
+func configIncludePaths(value interface{}) ([]string, error) {
+	switch typed := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{typed}, nil
+	case []interface{}:
+		paths := make([]string, 0, len(typed))
+		for _, item := range typed {
+			path, ok := item.(string)
+			if !ok || path == "" {
+				return nil, fmt.Errorf("include entries must be file paths")
+			}
+			paths = append(paths, path)
+		}
+		return paths, nil
+	default:
+		return nil, fmt.Errorf("include must be a file path or a list of file paths")
+	}
+}
+
+// mergeConfigDocuments deep-merges overlay into base. Nested mappings are
+// merged key by key; scalars and lists in overlay replace those in base.
+func mergeConfigDocuments(base, overlay configDocument) configDocument {
+	merged := make(configDocument, len(base)+len(overlay))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overlay {
+		overlayMap, overlayIsMap := value.(configDocument)
+		baseMap, baseIsMap := merged[key].(configDocument)
+		if overlayIsMap && baseIsMap {
+			merged[key] = mergeConfigDocuments(baseMap, overlayMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,102 @@
+// config_include_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfig_Includes(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base/base.yaml": `
+server:
+  port: "9000"
+  allowed_origins: ["http://localhost:3000", "http://localhost:5173"]
+security:
+  api_key: "base-key"
+backend:
+  url: "http://backend:8000"
+  timeout: 5s
+`,
+		"base/limits.yaml": `
+limits:
+  max_clients_per_team: 50
+`,
+		"production.yaml": `
+include:
+  - base/base.yaml
+  - base/limits.yaml
+server:
+  allowed_origins: ["https://app.example.com"]
+backend:
+  timeout: 2s
+environment:
+  mode: production
+`,
+	})
+	defer func() { setAppConfig(nil) }()
+
+	if err := LoadConfig(filepath.Join(dir, "production.yaml")); err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+
+	if AppConfig().Server.Port != "9000" || AppConfig().Security.APIKey != "base-key" {
+		t.Errorf("expected base values to be inherited, got port=%q key=%q", AppConfig().Server.Port, AppConfig().Security.APIKey)
+	}
+	if AppConfig().Backend.URL != "http://backend:8000" || AppConfig().Backend.Timeout != 2*time.Second {
+		t.Errorf("expected nested values to merge, got url=%q timeout=%v", AppConfig().Backend.URL, AppConfig().Backend.Timeout)
+	}
+	if !reflect.DeepEqual(AppConfig().Server.AllowedOrigins, []string{"https://app.example.com"}) {
+		t.Errorf("expected lists to be replaced, got %v", AppConfig().Server.AllowedOrigins)
+	}
+	if AppConfig().Limits.MaxClientsPerTeam != 50 {
+		t.Errorf("expected value from second include, got %d", AppConfig().Limits.MaxClientsPerTeam)
+	}
+}
+
+func TestLoadConfig_IncludeErrors(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml":       "include: b.yaml\n",
+		"b.yaml":       "include: a.yaml\n",
+		"missing.yaml": "include: nowhere.yaml\n",
+		"invalid.yaml": "include: {path: a.yaml}\n",
+	})
+	defer func() { setAppConfig(nil) }()
+
+	testCases := []struct {
+		file   string
+		substr string
+	}{
+		{"a.yaml", "cycle"},
+		{"missing.yaml", "failed to read included config"},
+		{"invalid.yaml", "include must be"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			err := LoadConfig(filepath.Join(dir, tc.file))
+			if err == nil || !strings.Contains(err.Error(), tc.substr) {
+				t.Fatalf("expected error containing %q, got %v", tc.substr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// config_reload.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// reloadableSettings are the settings a reload applies to the running
+// instance. They are read from AppConfig where they are used, or their users
+// are rebuilt by applyReloadedSettings. Changes to any other setting, such as
+// server.port or the tls section, need a restart and are not applied.
+var reloadableSettings = map[string]bool{
+	"server.allowed_origins":         true,
+	"limits.max_clients_per_team":    true,
+	"rate_limit.requests_per_second": true,
+	"rate_limit.burst":               true,
+	"rate_limit.key":                 true,
+	"rate_limit.entry_ttl":           true,
+	"rate_limit.cleanup_interval":    true,
+	"rate_limit.policies":            true,
+	"logging.level":                  true,
+	"logging.access_log":             true,
+	"logging.access_log_sample_rate": true,
+}
+
+// configMu serializes publishing a new AppConfig, so a reload and a secret
+// refresh do not each start from the same copy and drop the other's changes.
+var configMu sync.Mutex
+
+type configReloadResponse struct {
+	Source   string   `json:"source"`
+	Applied  []string `json:"applied"`  // settings now in use
+	Rejected []string `json:"rejected"` // changed settings that need a restart
+}
+
+// reloadConfig re-reads the config file at path and applies the reloadable
+// settings that changed, publishing a copy of AppConfig carrying them. A
+// file that fails validation changes nothing. Connections are kept; new
+// limits apply to what happens from then on.
+func reloadConfig(path string) (configReloadResponse, error) {
+	loaded, err := readConfigFile(path)
+	if err != nil {
+		appMetrics.Count("config.reloads", 1, metricTag("result", "failed"))
+		return configReloadResponse{}, err
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	current := AppConfig()
+	next := *current
+	response := configReloadResponse{Source: path, Applied: []string{}, Rejected: []string{}}
+	diffConfig(reflect.ValueOf(&next).Elem(), reflect.ValueOf(loaded.config).Elem(), "", func(name string, running, reloaded reflect.Value) {
+		if !reloadableSettings[name] {
+			response.Rejected = append(response.Rejected, name)
+			return
+		}
+		running.Set(reloaded)
+		response.Applied = append(response.Applied, name)
+	})
+
+	if len(response.Rejected) > 0 {
+		slog.Warn("Settings changed but need a restart; keeping the running values", "path", path, "changed", response.Rejected)
+	}
+	appMetrics.Count("config.reloads", 1, metricTag("result", "ok"))
+	if len(response.Applied) == 0 {
+		slog.Info("Configuration reloaded with nothing to apply", "path", path)
+		return response, nil
+	}
+
+	setAppConfig(&next)
+	applyReloadedSettings(current, &next)
+	slog.Info("Configuration reloaded", "path", path, "changed", response.Applied)
+	recordAudit(auditEvent{Action: "config.reloaded", Subject: "config", Details: map[string]string{"fields": strings.Join(response.Applied, ",")}})
+	return response, nil
+}
+
+// diffConfig calls changed with the name of each setting that differs
+// between running and reloaded, and both values. Settings are compared one
+// level into each section, so that rate_limit.burst is told apart from
+// rate_limit.backend.
+func diffConfig(running, reloaded reflect.Value, prefix string, changed func(name string, running, reloaded reflect.Value)) {
+	for i := 0; i < running.NumField(); i++ {
+		field := running.Type().Field(i)
+		name := joinConfigName(prefix, field)
+		if prefix == "" && field.Type.Kind() == reflect.Struct {
+			diffConfig(running.Field(i), reloaded.Field(i), name, changed)
+			continue
+		}
+		if !reflect.DeepEqual(running.Field(i).Interface(), reloaded.Field(i).Interface()) {
+			changed(name, running.Field(i), reloaded.Field(i))
+		}
+	}
+}
+
+// applyReloadedSettings rebuilds what was built from settings at startup.
+func applyReloadedSettings(previous, next *Config) {
+	if !reflect.DeepEqual(previous.RateLimit, next.RateLimit) {
+		configureRateLimits(next)
+	}
+	if previous.Logging.Level != next.Logging.Level {
+		if setLogLevel(next) {
+			slog.Warn("debug logging is not available in production; logging at info")
+		}
+	}
+}
+
+// handleAdminConfigReload applies the reloadable settings of the config file
+// the server was started with, as SIGHUP does.
+func handleAdminConfigReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	response, err := reloadConfig(activeConfigPath)
+	if err != nil {
+		slog.Error("Configuration reload failed, keeping the running configuration", "path", activeConfigPath, "error", err)
+		http.Error(w, fmt.Sprintf("Reload failed, the running configuration is unchanged: %v", err), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
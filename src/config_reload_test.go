@@ -0,0 +1,136 @@
+// config_reload_test.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const reloadTestConfig = `
+server:
+  port: 9090
+  allowed_origins: ["https://app.example"]
+security:
+  api_key: "reload-test-key"
+backend:
+  url: "http://backend:8000"
+limits:
+  max_clients_per_team: 100
+rate_limit:
+  requests_per_second: 10
+  burst: 20
+logging:
+  level: info
+`
+
+func TestReloadConfig(t *testing.T) {
+	configFile, cleanup := createTempConfigFile(t, reloadTestConfig)
+	defer cleanup()
+	defer setRateLimits(nil, nil)
+	defer logLevel.Set(slog.LevelInfo)
+	if err := LoadConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	running := AppConfig()
+
+	edited := strings.NewReplacer(
+		"port: 9090", "port: 9091",
+		`["https://app.example"]`, `["https://app.example", "https://new.example"]`,
+		"max_clients_per_team: 100", "max_clients_per_team: 250",
+		"burst: 20", "burst: 5",
+		"level: info", "level: warn",
+	).Replace(reloadTestConfig)
+	if err := os.WriteFile(configFile, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := reloadConfig(configFile)
+	if err != nil {
+		t.Fatalf("reloadConfig() failed: %v", err)
+	}
+	wantApplied := []string{"server.allowed_origins", "limits.max_clients_per_team", "rate_limit.burst", "logging.level"}
+	if !reflect.DeepEqual(response.Applied, wantApplied) || !reflect.DeepEqual(response.Rejected, []string{"server.port"}) {
+		t.Fatalf("expected %v applied and server.port rejected, got %+v", wantApplied, response)
+	}
+	if AppConfig() == running || running.Limits.MaxClientsPerTeam != 100 {
+		t.Fatal("expected a new config to be published without changing the running one")
+	}
+	if AppConfig().Server.Port != "9090" || AppConfig().Limits.MaxClientsPerTeam != 250 || !IsOriginAllowed("https://new.example") {
+		t.Fatalf("expected the reloadable settings only to change, got port %s and max clients %d", AppConfig().Server.Port, AppConfig().Limits.MaxClientsPerTeam)
+	}
+	if limiter, ok := activeRateLimits.Load().fallback.(*ipRateLimiter); !ok || limiter.burst != 5 {
+		t.Fatalf("expected the rate limiter to be rebuilt, got %#v", activeRateLimits.Load().fallback)
+	}
+	if logLevel.Level() != slog.LevelWarn {
+		t.Fatalf("expected the log level to follow the reload, got %v", logLevel.Level())
+	}
+
+	// A file that fails validation changes nothing.
+	reloaded := AppConfig()
+	if err := os.WriteFile(configFile, []byte(strings.Replace(edited, "burst: 5", "burst: -1", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloadConfig(configFile); err == nil || AppConfig() != reloaded {
+		t.Fatalf("expected an invalid file to be refused, got %v", err)
+	}
+}
+
+func TestReloadConfig_ConcurrentReaders(t *testing.T) {
+	configFile, cleanup := createTempConfigFile(t, reloadTestConfig)
+	defer cleanup()
+	defer setRateLimits(nil, nil)
+	if err := LoadConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	configureRateLimits(AppConfig())
+
+	// Requests keep reading the config and the limiters while reloads
+	// publish new ones; go test -race reports any unsynchronised access.
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = AppConfig().Limits.MaxClientsPerTeam
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/send", nil)
+			req.RemoteAddr = fmt.Sprintf("203.0.113.%d:1234", i%250)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}()
+	for i, burst := range []string{"burst: 5", "burst: 6", "burst: 7"} {
+		if err := os.WriteFile(configFile, []byte(strings.Replace(reloadTestConfig, "burst: 20", burst, 1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reloadConfig(configFile); err != nil {
+			t.Fatalf("reload %d failed: %v", i+1, err)
+		}
+	}
+	<-done
+}
+
+func TestHandleAdminConfigReload(t *testing.T) {
+	configFile, cleanup := createTempConfigFile(t, reloadTestConfig)
+	defer cleanup()
+	if err := LoadConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handleAdminConfigReload(rr, httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"applied":[]`) {
+		t.Fatalf("expected an unchanged file to apply nothing, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminConfigReload(rr, httptest.NewRequest(http.MethodGet, "/admin/config/reload", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rr.Code)
+	}
+}
@@ -26,7 +26,7 @@ func createTempConfigFile(t *testing.T, content string) (string, func()) {
 	// Return the path and a cleanup function
 	return configFile, func() {
 		os.RemoveAll(dir)
-		AppConfig = nil // Reset global AppConfig after each test
+		setAppConfig(nil) // Reset global AppConfig after each test
 	}
 }
 
@@ -77,23 +77,23 @@ environment:
 		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
 	}
 
-	if AppConfig == nil {
-		t.Fatal("AppConfig should not be nil after successful loading")
+	if AppConfig() == nil {
+		t.Fatal("AppConfig() should not be nil after successful loading")
 	}
 
 	// Assert a few key values to ensure parsing was correct
-	if AppConfig.Server.Port != "9090" {
-		t.Errorf("Expected Server.Port to be '9090', got '%s'", AppConfig.Server.Port)
+	if AppConfig().Server.Port != "9090" {
+		t.Errorf("Expected Server.Port to be '9090', got '%s'", AppConfig().Server.Port)
 	}
-	if AppConfig.Security.APIKey != "my-secret-api-key" {
-		t.Errorf("Expected Security.APIKey to be 'my-secret-api-key', got '%s'", AppConfig.Security.APIKey)
+	if AppConfig().Security.APIKey != "my-secret-api-key" {
+		t.Errorf("Expected Security.APIKey to be 'my-secret-api-key', got '%s'", AppConfig().Security.APIKey)
 	}
-	if AppConfig.Environment.Mode != "development" {
-		t.Errorf("Expected Environment.Mode to be 'development', got '%s'", AppConfig.Environment.Mode)
+	if AppConfig().Environment.Mode != "development" {
+		t.Errorf("Expected Environment.Mode to be 'development', got '%s'", AppConfig().Environment.Mode)
 	}
 	expectedOrigins := []string{"http://localhost:3000", "https://myapp.com"}
-	if !reflect.DeepEqual(AppConfig.Server.AllowedOrigins, expectedOrigins) {
-		t.Errorf("Expected AllowedOrigins to be %v, got %v", expectedOrigins, AppConfig.Server.AllowedOrigins)
+	if !reflect.DeepEqual(AppConfig().Server.AllowedOrigins, expectedOrigins) {
+		t.Errorf("Expected AllowedOrigins to be %v, got %v", expectedOrigins, AppConfig().Server.AllowedOrigins)
 	}
 }
 
@@ -177,6 +177,79 @@ environment:
 	}
 }
 
+func TestLoadConfig_ValidationFailure_SQLDriverNotLinked(t *testing.T) {
+	yamlContent := `
+security:
+  api_key: "a-required-key"
+backend:
+  url: "http://localhost:8000"
+storage:
+  driver: sql
+  sql:
+    driver: "not-linked"
+    dsn: "db"
+`
+	configFile, cleanup := createTempConfigFile(t, yamlContent)
+	defer cleanup()
+
+	err := LoadConfig(configFile)
+	expectedError := `config validation failed: storage.sql.driver "not-linked" is not linked into this build`
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', got '%v'", expectedError, err)
+	}
+}
+
+func TestLoadConfig_APIKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "api_key")
+	if err := os.WriteFile(keyFile, []byte("mounted-secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	configFile, cleanup := createTempConfigFile(t, `
+security:
+  api_key_file: "`+keyFile+`"
+backend:
+  url: "http://localhost:8000"
+`)
+	defer cleanup()
+
+	if err := LoadConfig(configFile); err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if AppConfig().Security.APIKey != "mounted-secret" {
+		t.Errorf("Expected API key from file, got %q", AppConfig().Security.APIKey)
+	}
+}
+
+func TestLoadConfig_APIKeyFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte("  \n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		security string
+	}{
+		{"missing file", `api_key_file: "` + filepath.Join(dir, "missing") + `"`},
+		{"empty file", `api_key_file: "` + emptyFile + `"`},
+		{"both inline and file", "api_key: \"inline\"\n  api_key_file: \"" + emptyFile + "\""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFile, cleanup := createTempConfigFile(t, "security:\n  "+tc.security+"\nbackend:\n  url: \"http://localhost:8000\"\n")
+			defer cleanup()
+
+			if err := LoadConfig(configFile); err == nil {
+				t.Fatal("LoadConfig() should have failed")
+			}
+		})
+	}
+}
+
 // TestSetDefaults checks if default values are correctly applied for an empty config.
 func TestSetDefaults(t *testing.T) {
 	// We need some required fields for validation to pass
@@ -194,47 +267,47 @@ backend:
 		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
 	}
 
-	if AppConfig == nil {
-		t.Fatal("AppConfig should not be nil")
+	if AppConfig() == nil {
+		t.Fatal("AppConfig() should not be nil")
 	}
 
 	// Check a representative sample of default values
-	if AppConfig.Server.Port != "8081" {
-		t.Errorf("Expected default Server.Port to be '8081', got '%s'", AppConfig.Server.Port)
+	if AppConfig().Server.Port != "8081" {
+		t.Errorf("Expected default Server.Port to be '8081', got '%s'", AppConfig().Server.Port)
 	}
-	if AppConfig.Server.ReadTimeout != 10*time.Second {
-		t.Errorf("Expected default Server.ReadTimeout to be 10s, got %v", AppConfig.Server.ReadTimeout)
+	if AppConfig().Server.ReadTimeout != 10*time.Second {
+		t.Errorf("Expected default Server.ReadTimeout to be 10s, got %v", AppConfig().Server.ReadTimeout)
 	}
-	if AppConfig.WebSocket.PongWait != 60*time.Second {
-		t.Errorf("Expected default WebSocket.PongWait to be 60s, got %v", AppConfig.WebSocket.PongWait)
+	if AppConfig().WebSocket.PongWait != 60*time.Second {
+		t.Errorf("Expected default WebSocket.PongWait to be 60s, got %v", AppConfig().WebSocket.PongWait)
 	}
-	if AppConfig.WebSocket.AuthMaxMessageSize != 16*1024 {
-		t.Errorf("Expected default WebSocket.AuthMaxMessageSize to be 16384, got %d", AppConfig.WebSocket.AuthMaxMessageSize)
+	if AppConfig().WebSocket.AuthMaxMessageSize != 16*1024 {
+		t.Errorf("Expected default WebSocket.AuthMaxMessageSize to be 16384, got %d", AppConfig().WebSocket.AuthMaxMessageSize)
 	}
 	// PingPeriod is derived from PongWait
 	expectedPingPeriod := (60 * time.Second * 9) / 10
-	if AppConfig.WebSocket.PingPeriod != expectedPingPeriod {
-		t.Errorf("Expected default WebSocket.PingPeriod to be %v, got %v", expectedPingPeriod, AppConfig.WebSocket.PingPeriod)
+	if AppConfig().WebSocket.PingPeriod != expectedPingPeriod {
+		t.Errorf("Expected default WebSocket.PingPeriod to be %v, got %v", expectedPingPeriod, AppConfig().WebSocket.PingPeriod)
 	}
-	if AppConfig.Limits.MaxClientsPerTeam != 1000 {
-		t.Errorf("Expected default Limits.MaxClientsPerTeam to be 1000, got %d", AppConfig.Limits.MaxClientsPerTeam)
+	if AppConfig().Limits.MaxClientsPerTeam != 1000 {
+		t.Errorf("Expected default Limits.MaxClientsPerTeam to be 1000, got %d", AppConfig().Limits.MaxClientsPerTeam)
 	}
-	if AppConfig.Environment.Mode != "production" {
-		t.Errorf("Expected default Environment.Mode to be 'production', got '%s'", AppConfig.Environment.Mode)
+	if AppConfig().Environment.Mode != "production" {
+		t.Errorf("Expected default Environment.Mode to be 'production', got '%s'", AppConfig().Environment.Mode)
 	}
-	if AppConfig.RateLimit.RequestsPerSecond != 20 {
-		t.Errorf("Expected default RateLimit.RequestsPerSecond to be 20, got %v", AppConfig.RateLimit.RequestsPerSecond)
+	if AppConfig().RateLimit.RequestsPerSecond != 20 {
+		t.Errorf("Expected default RateLimit.RequestsPerSecond to be 20, got %v", AppConfig().RateLimit.RequestsPerSecond)
 	}
 	expectedOrigins := []string{}
-	if !reflect.DeepEqual(AppConfig.Server.AllowedOrigins, expectedOrigins) {
-		t.Errorf("Expected default AllowedOrigins to be %v, got %v", expectedOrigins, AppConfig.Server.AllowedOrigins)
+	if !reflect.DeepEqual(AppConfig().Server.AllowedOrigins, expectedOrigins) {
+		t.Errorf("Expected default AllowedOrigins to be %v, got %v", expectedOrigins, AppConfig().Server.AllowedOrigins)
 	}
 }
 
 // TestEnvironmentHelpers tests the various boolean helper functions.
 func TestEnvironmentHelpers(t *testing.T) {
 	// Defer cleanup to reset AppConfig after the test
-	defer func() { AppConfig = nil }()
+	defer func() { setAppConfig(nil) }()
 
 	testCases := []struct {
 		name                    string
@@ -245,7 +318,7 @@ func TestEnvironmentHelpers(t *testing.T) {
 		expectedFakeAuthEnabled bool
 	}{
 		{
-			name:                    "AppConfig is nil",
+			name:                    "AppConfig() is nil",
 			config:                  nil,
 			expectedIsDevelopment:   false,
 			expectedIsProduction:    true, // Safety default
@@ -326,7 +399,7 @@ func TestEnvironmentHelpers(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			AppConfig = tc.config
+			setAppConfig(tc.config)
 
 			if got := IsDevelopment(); got != tc.expectedIsDevelopment {
 				t.Errorf("IsDevelopment() = %v, want %v", got, tc.expectedIsDevelopment)
@@ -346,7 +419,7 @@ func TestEnvironmentHelpers(t *testing.T) {
 
 // TestIsOriginAllowed tests the detailed logic for origin validation.
 func TestIsOriginAllowed(t *testing.T) {
-	defer func() { AppConfig = nil }()
+	defer func() { setAppConfig(nil) }()
 
 	testCases := []struct {
 		name          string
@@ -355,7 +428,7 @@ func TestIsOriginAllowed(t *testing.T) {
 		expected      bool
 	}{
 		{
-			name:          "AppConfig is nil",
+			name:          "AppConfig() is nil",
 			config:        nil,
 			originToCheck: "http://anywhere.com",
 			expected:      false,
@@ -459,7 +532,7 @@ func TestIsOriginAllowed(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			AppConfig = tc.config
+			setAppConfig(tc.config)
 			if got := IsOriginAllowed(tc.originToCheck); got != tc.expected {
 				t.Errorf("IsOriginAllowed('%s') = %v, want %v", tc.originToCheck, got, tc.expected)
 			}
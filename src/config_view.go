@@ -0,0 +1,77 @@
+// config_view.go
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const maskedSecret = "********"
+
+// effectiveConfig renders a config the way it is written in YAML, with
+// defaults applied and secret fields masked. Empty secrets stay empty so
+// operators can tell unset from set.
+func effectiveConfig(config *Config) map[string]interface{} {
+	return configValue(reflect.ValueOf(config).Elem(), false).(map[string]interface{})
+}
+
+func configValue(value reflect.Value, secret bool) interface{} {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(value.Int()).String()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		fields := make(map[string]interface{}, value.NumField())
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			fields[name] = configValue(value.Field(i), field.Tag.Get("secret") == "true")
+		}
+		return fields
+	case reflect.Slice:
+		items := make([]interface{}, value.Len())
+		for i := range items {
+			items[i] = configValue(value.Index(i), secret)
+		}
+		return items
+	case reflect.String:
+		if secret && value.String() != "" {
+			return maskedSecret
+		}
+		return value.String()
+	default:
+		return value.Interface()
+	}
+}
+
+type adminConfigResponse struct {
+	Source string                 `json:"source"`
+	Config map[string]interface{} `json:"config"`
+	// SecretSources lists settings resolved from vault:// or awssm://
+	// references, keyed by setting name.
+	SecretSources map[string]string `json:"secret_sources"`
+}
+
+// handleAdminConfig returns the configuration the running instance is using.
+func handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sources := make(map[string]string, len(secretRefs.bindings))
+	for _, binding := range secretRefs.bindings {
+		sources[binding.name] = binding.ref.String()
+	}
+	writeJSONWithETag(w, r, adminConfigResponse{
+		Source:        activeConfigPath,
+		Config:        effectiveConfig(AppConfig()),
+		SecretSources: sources,
+	})
+}
@@ -0,0 +1,115 @@
+// config_view_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleAdminConfig_MasksSecrets(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Storage.Redis.Password = "redis-password"
+	AppConfig().Storage.Encryption.Keys = append(AppConfig().Storage.Encryption.Keys, struct {
+		ID  string `yaml:"id"`
+		Key string `yaml:"key" secret:"true"`
+	}{ID: "k1", Key: testKey(7)})
+	secretRefs.bindings = []secretBinding{{name: "security.api_key", ref: secretRef{scheme: "vault", path: "secret/data/app", field: "api_key"}}}
+	defer func() { secretRefs.bindings = nil }()
+
+	rr := httptest.NewRecorder()
+	handleAdminConfig(rr, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, secret := range []string{"test-api-key", "redis-password", testKey(7)} {
+		if strings.Contains(body, secret) {
+			t.Fatalf("response leaks secret %q: %s", secret, body)
+		}
+	}
+
+	var response struct {
+		Config struct {
+			Security struct {
+				APIKey string `json:"api_key"`
+			} `json:"security"`
+			Storage struct {
+				SQL struct {
+					DSN string `json:"dsn"`
+				} `json:"sql"`
+				Encryption struct {
+					Keys []struct {
+						ID  string `json:"id"`
+						Key string `json:"key"`
+					} `json:"keys"`
+				} `json:"encryption"`
+			} `json:"storage"`
+			WebSocket struct {
+				PongWait string `json:"pong_wait"`
+			} `json:"websocket"`
+		} `json:"config"`
+		SecretSources map[string]string `json:"secret_sources"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if response.Config.Security.APIKey != maskedSecret {
+		t.Errorf("expected masked api_key, got %q", response.Config.Security.APIKey)
+	}
+	if response.Config.Storage.SQL.DSN != "" {
+		t.Errorf("expected unset secrets to stay empty, got %q", response.Config.Storage.SQL.DSN)
+	}
+	if keys := response.Config.Storage.Encryption.Keys; len(keys) != 1 || keys[0].ID != "k1" || keys[0].Key != maskedSecret {
+		t.Errorf("expected masked encryption key with visible id, got %+v", keys)
+	}
+	if response.Config.WebSocket.PongWait != AppConfig().WebSocket.PongWait.String() {
+		t.Errorf("expected durations rendered as strings, got %q", response.Config.WebSocket.PongWait)
+	}
+	if response.SecretSources["security.api_key"] != "vault://secret/data/app#api_key" {
+		t.Errorf("expected secret source for security.api_key, got %v", response.SecretSources)
+	}
+}
+
+func TestHandleAdminConfig_ConditionalRequests(t *testing.T) {
+	setupTestAppConfig()
+
+	rr := httptest.NewRecorder()
+	handleAdminConfig(rr, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", rr.Code, etag)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		change      func()
+		wantStatus  int
+	}{
+		{"matching tag", etag, nil, http.StatusNotModified},
+		{"weak form in a list", `"other", W/` + etag, nil, http.StatusNotModified},
+		{"wildcard", "*", nil, http.StatusNotModified},
+		{"stale tag", `"stale"`, nil, http.StatusOK},
+		{"config changed", etag, func() { AppConfig().Limits.MaxClientsPerTeam++ }, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if tt.change != nil {
+			tt.change()
+		}
+		req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+		req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		rr := httptest.NewRecorder()
+		handleAdminConfig(rr, req)
+		if rr.Code != tt.wantStatus {
+			t.Fatalf("%s: expected status %d, got %d", tt.name, tt.wantStatus, rr.Code)
+		}
+		if tt.wantStatus == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Fatalf("%s: 304 response must not carry a body", tt.name)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// control.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// controlEventBuffer is how many events a slow `notifyctl events` may fall
+// behind before further events are dropped for it.
+const controlEventBuffer = 256
+
+// drainMode refuses new connections while existing ones stay, so a load
+// balancer can move traffic away before a restart. It is toggled with
+// `notifyctl drain`.
+var drainMode atomic.Bool
+
+// controlEvent is one line of `notifyctl events`.
+type controlEvent struct {
+	Time    time.Time         `json:"time"`
+	Type    string            `json:"type"` // connect, disconnect, userJoined, userLeft, send or audit
+	TeamID  string            `json:"teamId,omitempty"`
+	UserID  string            `json:"userId,omitempty"`
+	ConnID  string            `json:"connectionId,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// eventBus fans live events out to control socket subscribers. Publishing
+// never blocks: a subscriber that falls behind misses events.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan controlEvent]struct{}
+}
+
+// liveEvents carries the events streamed by `notifyctl events`. Publishing
+// with no subscribers only takes a lock.
+var liveEvents = newEventBus()
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[chan controlEvent]struct{})}
+}
+
+func (b *eventBus) publish(event controlEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			appMetrics.Count("control.events_dropped", 1)
+		}
+	}
+}
+
+func (b *eventBus) subscribe() chan controlEvent {
+	subscriber := make(chan controlEvent, controlEventBuffer)
+	b.mu.Lock()
+	b.subscribers[subscriber] = struct{}{}
+	b.mu.Unlock()
+	return subscriber
+}
+
+func (b *eventBus) unsubscribe(subscriber chan controlEvent) {
+	b.mu.Lock()
+	delete(b.subscribers, subscriber)
+	b.mu.Unlock()
+}
+
+// controlClient describes one connection for `notifyctl clients`.
+type controlClient struct {
+	TenantID   string `json:"tenantId,omitempty"`
+	TeamID     string `json:"teamId"`
+	UserID     string `json:"userId"`
+	Email      string `json:"email,omitempty"`
+	ConnID     string `json:"connectionId"`
+	QueueDepth int    `json:"queueDepth"`
+}
+
+// listenControlSocket listens on the Unix socket at path, readable and
+// writable only by the server's user. The socket is created under a umask
+// that already excludes everyone else, so there is no window in which another
+// local user could connect. A socket left behind by an earlier run is
+// replaced; any other file at path is an error.
+func listenControlSocket(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	oldMask := syscall.Umask(0o177)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, err
+	}
+	return listener, nil
+}
+
+// newControlMux serves the commands behind notifyctl. Access is controlled by
+// the socket's file permissions, so no API key is checked.
+func newControlMux(hub *Hub) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/teams", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, hub.teamStats())
+	})
+	mux.HandleFunc("/clients", func(w http.ResponseWriter, r *http.Request) {
+		handleControlClients(hub, w, r)
+	})
+	mux.HandleFunc("/kick", func(w http.ResponseWriter, r *http.Request) {
+		handleControlKick(hub, w, r)
+	})
+	mux.HandleFunc("/drain", handleControlDrain)
+	mux.HandleFunc("/events", handleControlEvents)
+	mux.HandleFunc("/send", func(w http.ResponseWriter, r *http.Request) {
+		handleSendMessage(hub, w, r)
+	})
+	return mux
+}
+
+func handleControlClients(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	teamID := strings.TrimSpace(r.URL.Query().Get("team"))
+	var clients []*Client
+	if teamID != "" {
+		clients = hub.snapshotTeamClients(teamID)
+	} else {
+		clients = hub.snapshotAllClients()
+	}
+
+	listed := make([]controlClient, 0, len(clients))
+	for _, client := range clients {
+		listed = append(listed, controlClient{
+			TenantID:   client.tenantID,
+			TeamID:     client.teamID,
+			UserID:     client.userID,
+			Email:      client.email,
+			ConnID:     client.connID,
+			QueueDepth: len(client.send),
+		})
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		if listed[i].TeamID != listed[j].TeamID {
+			return listed[i].TeamID < listed[j].TeamID
+		}
+		if listed[i].UserID != listed[j].UserID {
+			return listed[i].UserID < listed[j].UserID
+		}
+		return listed[i].ConnID < listed[j].ConnID
+	})
+	writeJSON(w, http.StatusOK, listed)
+}
+
+func handleControlKick(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := strings.TrimSpace(r.URL.Query().Get("user"))
+	teamID := strings.TrimSpace(r.URL.Query().Get("team"))
+	if userID == "" {
+		http.Error(w, "user is required", http.StatusBadRequest)
+		return
+	}
+
+	disconnected := 0
+	if teamID == "" {
+		disconnected = hub.disconnectUser(userID, "kicked by operator")
+	} else {
+		for _, client := range hub.snapshotTeamClients(teamID) {
+			if client.userID == userID {
+				hub.disconnectClient(client, "kicked by operator")
+				disconnected++
+			}
+		}
+	}
+	recordAudit(auditEvent{Action: "control.kick", Subject: userID, Details: map[string]string{"team": teamID, "disconnected": strconv.Itoa(disconnected)}})
+	writeJSON(w, http.StatusOK, map[string]int{"disconnected": disconnected})
+}
+
+func handleControlDrain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+		if err != nil {
+			http.Error(w, "on must be true or false", http.StatusBadRequest)
+			return
+		}
+		if drainMode.Swap(on) != on {
+			slog.Info("Drain mode set", "drain", on)
+			recordAudit(auditEvent{Action: "control.drain", Subject: strconv.FormatBool(on)})
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"draining": drainMode.Load()})
+}
+
+// handleControlEvents streams live events as newline-delimited JSON until the
+// caller disconnects.
+func handleControlEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events := liveEvents.subscribe()
+	defer liveEvents.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// serveControlSocket serves the control commands on listener until it is
+// closed.
+func serveControlSocket(hub *Hub, listener net.Listener) {
+	server := &http.Server{Handler: newControlMux(hub), ReadHeaderTimeout: 5 * time.Second}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Error("Control socket stopped", "error", err)
+	}
+}
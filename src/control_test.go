@@ -0,0 +1,158 @@
+// control_test.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startControlSocket serves the control commands for hub on a socket in a
+// temporary directory and returns its path.
+func startControlSocket(t *testing.T, hub *Hub) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := listenControlSocket(path)
+	if err != nil {
+		t.Fatalf("listenControlSocket failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go serveControlSocket(hub, listener)
+	return path
+}
+
+func TestNotifyctl_Commands(t *testing.T) {
+	setupTestAppConfig()
+	defer drainMode.Store(false)
+
+	hub := newHub()
+	alice := &Client{hub: hub, teamID: "team-1", userID: "alice", connID: "c1", send: make(chan outboundMessage, 10)}
+	bob := &Client{hub: hub, teamID: "team-2", userID: "bob", connID: "c2", send: make(chan outboundMessage, 10)}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {alice: {}}}
+	hub.clients["team-2"] = map[string]map[*Client]struct{}{"bob": {bob: {}}}
+	socket := startControlSocket(t, hub)
+
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected an owner-only socket, got %v, %v", info, err)
+	}
+
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		code := runNotifyctlCommand(append([]string{"-socket", socket}, args...), &out)
+		return code, out.String()
+	}
+
+	if code, out := run("teams"); code != 0 || !strings.Contains(out, "team-1") || !strings.Contains(out, "team-2") {
+		t.Fatalf("teams returned %d:\n%s", code, out)
+	}
+	if code, out := run("clients", "team-2"); code != 0 || strings.Contains(out, "alice") || !strings.Contains(out, "c2") {
+		t.Fatalf("clients team-2 returned %d:\n%s", code, out)
+	}
+
+	if code, out := run("send", "team-1", "alice", "test", "hello", "there"); code != 0 || !strings.Contains(out, "Delivered to 1 connections") {
+		t.Fatalf("send returned %d:\n%s", code, out)
+	}
+	if message := <-alice.send; !strings.Contains(string(message.payload), `"body":"hello there"`) {
+		t.Fatalf("unexpected test message: %s", message.payload)
+	}
+
+	if code, out := run("drain", "on"); code != 0 || !strings.Contains(out, "Draining") {
+		t.Fatalf("drain on returned %d:\n%s", code, out)
+	}
+	rec := httptest.NewRecorder()
+	handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "draining") {
+		t.Fatalf("expected /readyz to report draining, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	handleWebSocket(hub, rec, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected new connections to be refused while draining, got %d", rec.Code)
+	}
+	if code, out := run("-json", "drain", "off"); code != 0 || !strings.Contains(out, `"draining": false`) {
+		t.Fatalf("drain off returned %d:\n%s", code, out)
+	}
+
+	if code, out := run("kick", "bob", "team-1"); code != 0 || !strings.Contains(out, "Disconnected 0") {
+		t.Fatalf("kick in another team returned %d:\n%s", code, out)
+	}
+	if code, out := run("kick", "bob"); code != 0 || !strings.Contains(out, "Disconnected 1") {
+		t.Fatalf("kick returned %d:\n%s", code, out)
+	}
+
+	if code, _ := run("reboot"); code != 2 {
+		t.Fatalf("expected an unknown command to be a usage error, got %d", code)
+	}
+}
+
+func TestListenControlSocket_OwnerOnlyFromCreation(t *testing.T) {
+	// Under a permissive umask the socket would be world-connectable unless
+	// listenControlSocket narrows the mode before the socket exists.
+	oldMask := syscall.Umask(0)
+	defer syscall.Umask(oldMask)
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := listenControlSocket(path)
+	if err != nil {
+		t.Fatalf("listenControlSocket failed: %v", err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the socket to be created 0600, got %v", info.Mode().Perm())
+	}
+	if mask := syscall.Umask(0); mask != 0 {
+		t.Fatalf("expected the process umask to be restored, got %o", mask)
+	}
+}
+
+func TestControlEvents_Stream(t *testing.T) {
+	setupTestAppConfig()
+
+	server := httptest.NewServer(newControlMux(newHub()))
+	defer server.Close()
+	res, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("failed to open the event stream: %v", err)
+	}
+	defer res.Body.Close()
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		liveEvents.mu.Lock()
+		subscribed := len(liveEvents.subscribers) > 0
+		liveEvents.mu.Unlock()
+		if subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the subscription")
+		}
+	}
+	recordAudit(auditEvent{Action: "control.test", Subject: "subject-1"})
+
+	// Events from other tests' hubs may arrive first.
+	reader := bufio.NewReader(res.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read an event: %v", err)
+		}
+		if strings.Contains(line, `"type":"audit"`) && strings.Contains(line, `"subject":"subject-1"`) {
+			break
+		}
+	}
+	if got := formatControlEvent(controlEvent{Time: time.Now(), Type: "connect", TeamID: "t", Details: map[string]string{"b": "2", "a": "1"}}); !strings.HasSuffix(got, "team=t a=1 b=2") {
+		t.Fatalf("unexpected formatted event: %q", got)
+	}
+}
@@ -0,0 +1,413 @@
+// conversations.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// teamConversationID names a team's broadcasts as a conversation.
+	teamConversationID = "team"
+	// directConversationPrefix prefixes the peer's user ID in the ID of a
+	// direct conversation.
+	directConversationPrefix = "user:"
+	// maxTrackedUnread bounds the notification IDs remembered per
+	// conversation for read receipts that name a notification.
+	maxTrackedUnread = 100
+	// maxConversationMembers bounds the users tracked; the least recently
+	// active is forgotten when it is full.
+	maxConversationMembers = 100000
+)
+
+var errUnknownConversation = errors.New("unknown conversation")
+
+// conversationReads is nil unless conversations.enabled is set, and all
+// methods are nil-safe.
+var conversationReads *conversationIndex
+
+// conversationIndex is an in-memory read model of unread counts per
+// conversation, so a chat UI can restore its badges after reconnecting.
+// Notifications accepted by /send are sorted into conversations: a team
+// broadcast belongs to the team's conversation and a message to one user
+// belongs to the direct conversation with its sender. Each conversation
+// keeps a preview of its last message. Read receipts from the recipient's
+// sockets clear the counts, and sending into a conversation marks it read
+// for the sender.
+type conversationIndex struct {
+	maxPerUser    int
+	snippetLength int
+	now           func() time.Time
+
+	mu      sync.Mutex
+	teams   map[string]*teamThread         // scoped team -> broadcasts
+	members map[string]*conversationMember // "<scoped team>\n<user>" -> read state
+}
+
+// teamThread numbers a team's broadcasts so each member only needs to
+// remember how far they have read.
+type teamThread struct {
+	seq    int64
+	recent []threadMessage // the last maxTrackedUnread broadcasts, oldest first
+	lastAt time.Time
+	last   conversationPreview
+}
+
+type threadMessage struct {
+	id  string
+	seq int64
+}
+
+type conversationMember struct {
+	teamRead   int64 // the team broadcast read up to
+	direct     map[string]*directConversation
+	lastActive time.Time
+}
+
+// directConversation is one member's side of a conversation with peer.
+type directConversation struct {
+	unread    int
+	unreadIDs []string // the newest unread notification IDs, oldest first
+	lastAt    time.Time
+	last      conversationPreview
+}
+
+// conversationPreview is a conversation's last message, enough for a
+// conversation list to render without fetching it.
+type conversationPreview struct {
+	NotificationID string `json:"notificationId,omitempty"`
+	SenderUserID   string `json:"senderUserId,omitempty"`
+	MessageType    string `json:"messageType"`
+	Snippet        string `json:"snippet"`
+	Timestamp      int64  `json:"timestamp"` // Unix milliseconds, as in notifications
+}
+
+func newConversationIndex(maxPerUser, snippetLength int) *conversationIndex {
+	return &conversationIndex{
+		maxPerUser:    maxPerUser,
+		snippetLength: snippetLength,
+		now:           time.Now,
+		teams:         make(map[string]*teamThread),
+		members:       make(map[string]*conversationMember),
+	}
+}
+
+// preview returns message as a conversation's last message, its body folded
+// onto one line and cut to snippetLength characters.
+func (c *conversationIndex) preview(message *Message) conversationPreview {
+	snippet := strings.Join(strings.Fields(message.Body), " ")
+	if runes := []rune(snippet); len(runes) > c.snippetLength {
+		snippet = string(runes[:c.snippetLength]) + "…"
+	}
+	return conversationPreview{
+		NotificationID: message.NotificationID,
+		SenderUserID:   message.SenderUserID,
+		MessageType:    message.MessageType,
+		Snippet:        snippet,
+		Timestamp:      message.Timestamp,
+	}
+}
+
+func conversationMemberKey(teamID, userID string) string {
+	return teamID + "\n" + userID
+}
+
+// recordSend files a notification accepted by /send under its conversation.
+// Global broadcasts, and messages without a team or sender, belong to no
+// conversation and are ignored. A notification that replaces one still
+// tracked as unread takes its place rather than adding to the count.
+func (c *conversationIndex) recordSend(teamID string, message *Message, broadcast bool) {
+	if c == nil || teamID == "" {
+		return
+	}
+
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if broadcast {
+		thread := c.teams[teamID]
+		if thread == nil {
+			thread = &teamThread{}
+			c.teams[teamID] = thread
+		}
+		if message.NotificationID != "" && thread.find(message.NotificationID) >= 0 {
+			return // a retried /send
+		}
+		thread.lastAt = now
+		thread.last = c.preview(message)
+		if i := thread.find(message.ReplacesID); message.ReplacesID != "" && i >= 0 {
+			// An update takes the place of the broadcast it replaces.
+			thread.recent[i].id = message.NotificationID
+		} else {
+			thread.seq++
+			thread.recent = append(thread.recent, threadMessage{id: message.NotificationID, seq: thread.seq})
+			if len(thread.recent) > maxTrackedUnread {
+				thread.recent = thread.recent[len(thread.recent)-maxTrackedUnread:]
+			}
+		}
+		if message.SenderUserID != "" {
+			c.member(teamID, message.SenderUserID, now).teamRead = thread.seq
+		}
+		return
+	}
+
+	if message.SenderUserID == "" || message.SenderUserID == message.TargetUserID {
+		return
+	}
+	recipient := c.conversation(teamID, message.TargetUserID, message.SenderUserID, now)
+	replaced := false
+	if message.NotificationID != "" {
+		for i, id := range recipient.unreadIDs {
+			if id == message.NotificationID {
+				return // a retried /send
+			}
+			if message.ReplacesID != "" && id == message.ReplacesID {
+				// An unread update takes the place of the message it replaces.
+				recipient.unreadIDs[i] = message.NotificationID
+				replaced = true
+			}
+		}
+		if !replaced {
+			recipient.unreadIDs = append(recipient.unreadIDs, message.NotificationID)
+			if len(recipient.unreadIDs) > maxTrackedUnread {
+				recipient.unreadIDs = recipient.unreadIDs[len(recipient.unreadIDs)-maxTrackedUnread:]
+			}
+		}
+	}
+	if !replaced {
+		recipient.unread++
+	}
+	recipient.lastAt = now
+	recipient.last = c.preview(message)
+
+	sender := c.conversation(teamID, message.SenderUserID, message.TargetUserID, now)
+	sender.unread = 0
+	sender.unreadIDs = nil
+	sender.lastAt = now
+	sender.last = recipient.last
+}
+
+// markRead applies a read receipt from userID for conversationID. With a
+// notificationID the conversation is read up to and including it; without
+// one it is read entirely. A notification that is no longer tracked, such as
+// one already read, changes nothing. It returns the conversation's unread
+// count afterwards.
+func (c *conversationIndex) markRead(teamID, userID, conversationID, notificationID string) (int, error) {
+	if c == nil {
+		return 0, nil
+	}
+
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conversationID == teamConversationID {
+		thread := c.teams[teamID]
+		if thread == nil {
+			return 0, errUnknownConversation
+		}
+		member := c.member(teamID, userID, now)
+		upTo := thread.seq
+		if notificationID != "" {
+			i := thread.find(notificationID)
+			if i < 0 {
+				return thread.unread(member), nil
+			}
+			upTo = thread.recent[i].seq
+		}
+		if upTo > member.teamRead {
+			member.teamRead = upTo
+		}
+		appMetrics.Count("conversations.read_receipts", 1)
+		return thread.unread(member), nil
+	}
+
+	peer, ok := strings.CutPrefix(conversationID, directConversationPrefix)
+	if !ok || peer == "" {
+		return 0, errUnknownConversation
+	}
+	member := c.members[conversationMemberKey(teamID, userID)]
+	if member == nil || member.direct[peer] == nil {
+		return 0, errUnknownConversation
+	}
+	member.lastActive = now
+	conversation := member.direct[peer]
+	if notificationID == "" {
+		conversation.unread = 0
+		conversation.unreadIDs = nil
+	} else {
+		for i, id := range conversation.unreadIDs {
+			if id == notificationID {
+				conversation.unreadIDs = conversation.unreadIDs[i+1:]
+				conversation.unread = len(conversation.unreadIDs)
+				break
+			}
+		}
+	}
+	appMetrics.Count("conversations.read_receipts", 1)
+	return conversation.unread, nil
+}
+
+// member returns userID's read state in teamID, creating it if needed. The
+// caller holds c.mu.
+func (c *conversationIndex) member(teamID, userID string, now time.Time) *conversationMember {
+	key := conversationMemberKey(teamID, userID)
+	member := c.members[key]
+	if member == nil {
+		if len(c.members) >= maxConversationMembers {
+			c.evictIdleMember()
+		}
+		member = &conversationMember{direct: make(map[string]*directConversation)}
+		c.members[key] = member
+	}
+	member.lastActive = now
+	return member
+}
+
+func (c *conversationIndex) evictIdleMember() {
+	var idleKey string
+	var idleSince time.Time
+	for key, member := range c.members {
+		if idleKey == "" || member.lastActive.Before(idleSince) {
+			idleKey, idleSince = key, member.lastActive
+		}
+	}
+	delete(c.members, idleKey)
+}
+
+// conversation returns userID's direct conversation with peer, creating it
+// and making room under maxPerUser if needed. The caller holds c.mu.
+func (c *conversationIndex) conversation(teamID, userID, peer string, now time.Time) *directConversation {
+	member := c.member(teamID, userID, now)
+	conversation := member.direct[peer]
+	if conversation != nil {
+		return conversation
+	}
+	if len(member.direct) >= c.maxPerUser {
+		var oldest string
+		for other, existing := range member.direct {
+			if oldest == "" || existing.lastAt.Before(member.direct[oldest].lastAt) {
+				oldest = other
+			}
+		}
+		delete(member.direct, oldest)
+	}
+	conversation = &directConversation{}
+	member.direct[peer] = conversation
+	return conversation
+}
+
+// find returns the index in recent of notificationID, or -1.
+func (t *teamThread) find(notificationID string) int {
+	for i, message := range t.recent {
+		if message.id == notificationID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *teamThread) unread(member *conversationMember) int {
+	if member == nil {
+		return int(t.seq)
+	}
+	return int(t.seq - member.teamRead)
+}
+
+// conversationView is one conversation in GET /users/{team}/{user}/conversations.
+type conversationView struct {
+	ID            string              `json:"id"`   // "team" or "user:<peer>"
+	Kind          string              `json:"kind"` // "team" or "direct"
+	PeerUserID    string              `json:"peerUserId,omitempty"`
+	Unread        int                 `json:"unread"`
+	LastMessageAt time.Time           `json:"lastMessageAt"`
+	LastMessage   conversationPreview `json:"lastMessage"`
+}
+
+type conversationsResponse struct {
+	TeamID        string             `json:"teamId"`
+	UserID        string             `json:"userId"`
+	TotalUnread   int                `json:"totalUnread"`
+	Conversations []conversationView `json:"conversations"`
+}
+
+// list returns userID's conversations in teamID, most recently active first.
+func (c *conversationIndex) list(teamID, userID string) []conversationView {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	member := c.members[conversationMemberKey(teamID, userID)]
+	views := make([]conversationView, 0)
+	if thread := c.teams[teamID]; thread != nil {
+		views = append(views, conversationView{
+			ID:            teamConversationID,
+			Kind:          "team",
+			Unread:        thread.unread(member),
+			LastMessageAt: thread.lastAt.UTC(),
+			LastMessage:   thread.last,
+		})
+	}
+	if member != nil {
+		for peer, conversation := range member.direct {
+			views = append(views, conversationView{
+				ID:            directConversationPrefix + peer,
+				Kind:          "direct",
+				PeerUserID:    peer,
+				Unread:        conversation.unread,
+				LastMessageAt: conversation.lastAt.UTC(),
+				LastMessage:   conversation.last,
+			})
+		}
+	}
+	sort.Slice(views, func(i, j int) bool {
+		if !views[i].LastMessageAt.Equal(views[j].LastMessageAt) {
+			return views[i].LastMessageAt.After(views[j].LastMessageAt)
+		}
+		return views[i].ID < views[j].ID
+	})
+	return views
+}
+
+// handleUserConversations serves GET /users/{team}/{user}/conversations. A
+// tenant key reads its own tenant's teams; the operator key names a tenant
+// with ?tenant_id=.
+func handleUserConversations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if conversationReads == nil {
+		http.Error(w, "Conversation tracking is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] != "conversations" {
+		http.NotFound(w, r)
+		return
+	}
+	team, user := parts[0], parts[1]
+
+	tenantID, err := sendTenantID(r, &MessageRequest{TenantID: strings.TrimSpace(r.URL.Query().Get("tenant_id"))})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	teamID, err := scopeTeam(tenantID, team)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	views := conversationReads.list(teamID, user)
+	response := conversationsResponse{TeamID: team, UserID: user, Conversations: views}
+	for _, view := range views {
+		response.TotalUnread += view.Unread
+	}
+	writeJSONWithETag(w, r, response)
+}
@@ -0,0 +1,220 @@
+// conversations_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func conversationUnread(t *testing.T, index *conversationIndex, teamID, userID string) map[string]int {
+	t.Helper()
+	unread := make(map[string]int)
+	for _, view := range index.list(teamID, userID) {
+		unread[view.ID] = view.Unread
+	}
+	return unread
+}
+
+func TestConversationIndex_UnreadCounts(t *testing.T) {
+	setupTestAppConfig()
+	index := newConversationIndex(10, 100)
+
+	index.recordSend("team1", NewMessage("d1", "team1", "alice", "bob", "chat", "hi", false), false)
+	index.recordSend("team1", NewMessage("d2", "team1", "alice", "bob", "chat", "there", false), false)
+	index.recordSend("team1", NewMessage("d2", "team1", "alice", "bob", "chat", "there", false), false)
+	index.recordSend("team1", NewMessage("b1", "team1", "", "carol", "news", "all hands", true), true)
+	index.recordSend("team1", NewMessage("x1", "team1", "alice", "", "alert", "no sender", false), false)
+	index.recordSend("", NewMessage("g1", "", "", "carol", "news", "global", true), true)
+
+	got := conversationUnread(t, index, "team1", "alice")
+	if len(got) != 2 || got["user:bob"] != 2 || got["team"] != 1 {
+		t.Fatalf("unexpected unread counts for alice: %v", got)
+	}
+	if got := conversationUnread(t, index, "team1", "bob"); got["user:alice"] != 0 || got["team"] != 1 {
+		t.Fatalf("expected the sender's side to be read, got %v", got)
+	}
+	if got := conversationUnread(t, index, "team1", "carol"); got["team"] != 0 {
+		t.Fatalf("expected the broadcast to be read by its sender, got %v", got)
+	}
+
+	if unread, err := index.markRead("team1", "alice", "user:bob", "d1"); err != nil || unread != 1 {
+		t.Fatalf("expected 1 unread after reading d1, got %d, %v", unread, err)
+	}
+	if unread, err := index.markRead("team1", "alice", "user:bob", "unknown"); err != nil || unread != 1 {
+		t.Fatalf("expected an unknown notification to change nothing, got %d, %v", unread, err)
+	}
+	if unread, err := index.markRead("team1", "alice", "team", ""); err != nil || unread != 0 {
+		t.Fatalf("expected the team conversation to be read, got %d, %v", unread, err)
+	}
+	if _, err := index.markRead("team1", "alice", "user:dave", ""); err != errUnknownConversation {
+		t.Fatalf("expected an unknown conversation error, got %v", err)
+	}
+	if _, err := index.markRead("team2", "alice", "team", ""); err != errUnknownConversation {
+		t.Fatalf("expected an unknown team conversation error, got %v", err)
+	}
+
+	// Replying marks the conversation read for the replying user.
+	index.recordSend("team1", NewMessage("d3", "team1", "bob", "alice", "chat", "reply", false), false)
+	if got := conversationUnread(t, index, "team1", "alice"); got["user:bob"] != 0 {
+		t.Fatalf("expected the reply to mark the conversation read, got %v", got)
+	}
+	if got := conversationUnread(t, index, "team1", "bob"); got["user:alice"] != 1 {
+		t.Fatalf("expected bob to have the reply unread, got %v", got)
+	}
+}
+
+func TestConversationIndex_MaxPerUser(t *testing.T) {
+	index := newConversationIndex(2, 100)
+	now := time.Now()
+	index.now = func() time.Time { return now }
+
+	for _, peer := range []string{"p1", "p2", "p3"} {
+		now = now.Add(time.Second)
+		index.recordSend("team1", NewMessage("", "team1", "alice", peer, "chat", "hi", false), false)
+	}
+
+	views := index.list("team1", "alice")
+	if len(views) != 2 || views[0].PeerUserID != "p3" || views[1].PeerUserID != "p2" {
+		t.Fatalf("expected the least recently active conversation to be dropped, got %+v", views)
+	}
+}
+
+func TestConversationIndex_Previews(t *testing.T) {
+	index := newConversationIndex(10, 10)
+
+	index.recordSend("team1", NewMessage("d1", "team1", "alice", "bob", "chat", "first", false), false)
+	index.recordSend("team1", NewMessage("d2", "team1", "alice", "bob", "chat", "see  you\nat the standup", false), false)
+	index.recordSend("team1", NewMessage("b1", "team1", "", "carol", "news", "ship it", true), true)
+
+	views := index.list("team1", "alice")
+	if len(views) != 2 {
+		t.Fatalf("expected two conversations, got %+v", views)
+	}
+	previews := map[string]conversationPreview{}
+	for _, view := range views {
+		previews[view.ID] = view.LastMessage
+	}
+	if got := previews["user:bob"]; got.NotificationID != "d2" || got.SenderUserID != "bob" || got.Snippet != "see you at…" || got.Timestamp == 0 {
+		t.Fatalf("unexpected direct preview: %+v", got)
+	}
+	if got := previews["team"]; got.NotificationID != "b1" || got.SenderUserID != "carol" || got.MessageType != "news" || got.Snippet != "ship it" {
+		t.Fatalf("unexpected team preview: %+v", got)
+	}
+	if got := index.list("team1", "bob"); len(got) != 2 || got[0].ID != "team" || got[1].LastMessage.NotificationID != "d2" {
+		t.Fatalf("expected the sender to see the same preview, got %+v", got)
+	}
+}
+
+func TestHandleUserConversations(t *testing.T) {
+	setupTestAppConfig()
+	defer func() { conversationReads = nil }()
+
+	rec := httptest.NewRecorder()
+	handleUserConversations(rec, httptest.NewRequest(http.MethodGet, "/users/team1/alice/conversations", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while disabled, got %d", rec.Code)
+	}
+
+	conversationReads = newConversationIndex(10, 100)
+	conversationReads.recordSend("team1", NewMessage("d1", "team1", "alice", "bob", "chat", "hi", false), false)
+	conversationReads.recordSend("team1", NewMessage("b1", "team1", "", "", "news", "all hands", true), true)
+
+	rec = httptest.NewRecorder()
+	handleUserConversations(rec, httptest.NewRequest(http.MethodGet, "/users/team1/alice/conversations", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response conversationsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.TeamID != "team1" || response.UserID != "alice" || response.TotalUnread != 2 || len(response.Conversations) != 2 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	for _, path := range []string{"/users/team1/alice", "/users/team1//conversations", "/users/team1/alice/conversations/extra"} {
+		rec = httptest.NewRecorder()
+		handleUserConversations(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for %s, got %d", path, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handleUserConversations(rec, httptest.NewRequest(http.MethodGet, "/users/team1/alice/conversations?tenant_id=missing", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for an unknown tenant, got %d", rec.Code)
+	}
+}
+
+func TestHandleWebSocket_ReadReceipts(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Environment.Mode = "development"
+	AppConfig().Environment.EnableFakeAuth = true
+	authFailures = nil
+	conversationReads = newConversationIndex(10, 100)
+	defer func() { conversationReads = nil }()
+	hub := newHub()
+	go hub.run()
+	wsURL := newWebSocketTestServer(t, hub)
+
+	conversationReads.recordSend("team-r", NewMessage("d1", "team-r", "user-r", "peer", "chat", "hi", false), false)
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"auth","teamId":"team-r","userId":"user-r","token":"fake_development_token"}`)); err != nil {
+		t.Fatalf("failed to send auth: %v", err)
+	}
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatalf("failed to read auth reply: %v", err)
+	}
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"read","conversationId":"user:peer","notificationId":"d1"}`)); err != nil {
+		t.Fatalf("failed to send read receipt: %v", err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if got := conversationUnread(t, conversationReads, "team-r", "user-r"); got["user:peer"] == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the read receipt to clear the unread count")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(hub.snapshotTeamClients("team-r")) != 1 {
+		t.Fatal("expected the read receipt not to close the connection")
+	}
+}
+
+func TestConversationIndex_Replacements(t *testing.T) {
+	index := newConversationIndex(10, 100)
+
+	index.recordSend("team1", NewMessage("d1", "team1", "alice", "bob", "build", "running", false), false)
+	update := NewMessage("d2", "team1", "alice", "bob", "build", "passed", false)
+	update.ReplacesID = "d1"
+	index.recordSend("team1", update, false)
+
+	index.recordSend("team1", NewMessage("b1", "team1", "", "", "deploy", "deploying", true), true)
+	broadcastUpdate := NewMessage("b2", "team1", "", "", "deploy", "deployed", true)
+	broadcastUpdate.ReplacesID = "b1"
+	index.recordSend("team1", broadcastUpdate, true)
+
+	if got := conversationUnread(t, index, "team1", "alice"); got["user:bob"] != 1 || got["team"] != 1 {
+		t.Fatalf("expected updates to take the place of what they replace, got %v", got)
+	}
+	if unread, err := index.markRead("team1", "alice", "user:bob", "d2"); err != nil || unread != 0 {
+		t.Fatalf("expected reading the update to clear the conversation, got %d, %v", unread, err)
+	}
+	if views := index.list("team1", "alice"); views[0].LastMessage.Snippet != "deployed" {
+		t.Fatalf("expected the update as the preview, got %+v", views[0].LastMessage)
+	}
+}
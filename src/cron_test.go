@@ -0,0 +1,81 @@
+// cron_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron_Rejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * foo *",
+	} {
+		if _, err := parseCron(expr, time.UTC); err == nil {
+			t.Errorf("expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	at := func(location *time.Location, value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+		if err != nil {
+			t.Fatalf("bad time %q: %v", value, err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		location *time.Location
+		after    string
+		want     string
+	}{
+		{"every 15 minutes", "*/15 * * * *", time.UTC, "2024-05-01 10:07", "2024-05-01 10:15"},
+		{"next minute, not the same one", "* * * * *", time.UTC, "2024-05-01 10:07", "2024-05-01 10:08"},
+		{"weekday mornings skip the weekend", "0 9 * * mon-fri", time.UTC, "2024-05-03 09:00", "2024-05-06 09:00"},
+		{"daily descriptor", "@daily", time.UTC, "2024-12-31 23:59", "2025-01-01 00:00"},
+		{"7 is Sunday", "30 8 * * 7", time.UTC, "2024-05-01 00:00", "2024-05-05 08:30"},
+		{"restricted day fields match either", "0 0 13 * fri", time.UTC, "2024-05-01 00:00", "2024-05-03 00:00"},
+		{"lists and named months", "0 12 1 jan,jul *", time.UTC, "2024-02-01 00:00", "2024-07-01 12:00"},
+		{"leap day", "0 0 29 2 *", time.UTC, "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"local time", "0 9 * * *", newYork, "2024-05-01 09:00", "2024-05-02 09:00"},
+		{"across a DST gap", "30 2 * * *", newYork, "2024-03-09 03:00", "2024-03-11 02:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseCron(tt.expr, tt.location)
+			if err != nil {
+				t.Fatalf("parseCron(%q) failed: %v", tt.expr, err)
+			}
+			got := schedule.next(at(tt.location, tt.after))
+			if want := at(tt.location, tt.want); !got.Equal(want) {
+				t.Fatalf("next after %s = %s, want %s", tt.after, got, want)
+			}
+		})
+	}
+
+	never, err := parseCron("0 0 30 2 *", time.UTC)
+	if err != nil {
+		t.Fatalf("parseCron failed: %v", err)
+	}
+	if got := never.next(time.Now()); !got.IsZero() {
+		t.Fatalf("expected February 30 never to match, got %s", got)
+	}
+}
@@ -0,0 +1,136 @@
+// digest.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minDigestInterval = time.Second
+	maxDigestInterval = time.Hour
+)
+
+// clientDigest batches a connection's matching notifications and hands them to
+// the writePump, which sends them as one digest frame per interval.
+type clientDigest struct {
+	interval     time.Duration
+	messageTypes map[string]struct{}
+	maxMessages  int
+
+	mu      sync.Mutex
+	pending []digestEntry
+	flush   chan struct{} // signaled when the batch reaches maxMessages
+}
+
+// compileDigest validates a client's digest settings. A nil settings value
+// disables digest mode and compiles to nil.
+func compileDigest(settings *DigestSettings, maxMessages int) (*clientDigest, error) {
+	if settings == nil {
+		return nil, nil
+	}
+
+	interval := time.Duration(settings.IntervalSeconds) * time.Second
+	if interval < minDigestInterval || interval > maxDigestInterval {
+		return nil, errors.New("digest.intervalSeconds must be between 1 and 3600")
+	}
+
+	digest := &clientDigest{
+		interval:    interval,
+		maxMessages: maxMessages,
+		flush:       make(chan struct{}, 1),
+	}
+	if len(settings.MessageTypes) > 0 {
+		digest.messageTypes = make(map[string]struct{}, len(settings.MessageTypes))
+		for _, messageType := range settings.MessageTypes {
+			messageType = strings.TrimSpace(messageType)
+			if messageType == "" {
+				return nil, errors.New("digest.messageTypes entries must not be empty")
+			}
+			digest.messageTypes[messageType] = struct{}{}
+		}
+	}
+	return digest, nil
+}
+
+// wants reports whether a message should be batched rather than sent immediately.
+func (d *clientDigest) wants(message outboundMessage) bool {
+	if d == nil || message.messageType == "" {
+		return false
+	}
+	if d.messageTypes == nil {
+		return true
+	}
+	_, ok := d.messageTypes[message.messageType]
+	return ok
+}
+
+// digestEntry is one batched notification. The ID lets a recall remove it.
+type digestEntry struct {
+	notificationID string
+	payload        json.RawMessage
+}
+
+func (d *clientDigest) add(notificationID string, payload []byte) {
+	d.mu.Lock()
+	d.pending = append(d.pending, digestEntry{notificationID: notificationID, payload: payload})
+	full := len(d.pending) >= d.maxMessages
+	d.mu.Unlock()
+
+	if full {
+		select {
+		case d.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (d *clientDigest) drain() []json.RawMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending := make([]json.RawMessage, len(d.pending))
+	for i, entry := range d.pending {
+		pending[i] = entry.payload
+	}
+	d.pending = nil
+	return pending
+}
+
+// discard removes a notification from the batch, reporting whether it was
+// pending.
+func (d *clientDigest) discard(notificationID string) bool {
+	if d == nil || notificationID == "" {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	kept := d.pending[:0]
+	for _, entry := range d.pending {
+		if entry.notificationID != notificationID {
+			kept = append(kept, entry)
+		}
+	}
+	found := len(kept) < len(d.pending)
+	d.pending = kept
+	return found
+}
+
+// nextFrame drains the batch into a digest frame, returning nil when nothing
+// is pending.
+func (d *clientDigest) nextFrame() ([]byte, error) {
+	messages := d.drain()
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(DigestFrame{
+		Type:            "digest",
+		IntervalSeconds: int(d.interval / time.Second),
+		Count:           len(messages),
+		Messages:        messages,
+	})
+}
@@ -0,0 +1,109 @@
+// digest_test.go
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCompileDigest_Validation(t *testing.T) {
+	if digest, err := compileDigest(nil, 10); err != nil || digest != nil {
+		t.Fatalf("expected nil settings to disable digests, got %v, %v", digest, err)
+	}
+
+	testCases := []struct {
+		name     string
+		settings DigestSettings
+	}{
+		{"zero interval", DigestSettings{IntervalSeconds: 0}},
+		{"interval too long", DigestSettings{IntervalSeconds: 7200}},
+		{"empty message type", DigestSettings{IntervalSeconds: 5, MessageTypes: []string{" "}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := compileDigest(&tc.settings, 10); err == nil {
+				t.Fatal("expected a validation error")
+			}
+		})
+	}
+}
+
+func TestClientDigest_Wants(t *testing.T) {
+	digest, err := compileDigest(&DigestSettings{IntervalSeconds: 5, MessageTypes: []string{"build_status"}}, 10)
+	if err != nil {
+		t.Fatalf("compileDigest failed: %v", err)
+	}
+
+	if !digest.wants(outboundMessage{messageType: "build_status"}) {
+		t.Fatal("expected selected message type to be digested")
+	}
+	if digest.wants(outboundMessage{messageType: "system_alert"}) {
+		t.Fatal("expected other message types to be delivered immediately")
+	}
+	if digest.wants(outboundMessage{payload: []byte(`{"type":"backpressure"}`)}) {
+		t.Fatal("control frames must never be digested")
+	}
+}
+
+func TestClientDigest_NextFrame(t *testing.T) {
+	digest, _ := compileDigest(&DigestSettings{IntervalSeconds: 30}, 2)
+
+	if frame, err := digest.nextFrame(); err != nil || frame != nil {
+		t.Fatalf("expected no frame for an empty batch, got %s, %v", frame, err)
+	}
+
+	digest.add("", []byte(`{"body":"one"}`))
+	select {
+	case <-digest.flush:
+		t.Fatal("flush signaled before the batch was full")
+	default:
+	}
+	digest.add("", []byte(`{"body":"two"}`))
+	select {
+	case <-digest.flush:
+	default:
+		t.Fatal("expected a flush signal once the batch was full")
+	}
+
+	frame, err := digest.nextFrame()
+	if err != nil {
+		t.Fatalf("nextFrame failed: %v", err)
+	}
+	var decoded DigestFrame
+	if err := json.Unmarshal(frame, &decoded); err != nil {
+		t.Fatalf("failed to decode digest frame: %v", err)
+	}
+	if decoded.Type != "digest" || decoded.IntervalSeconds != 30 || decoded.Count != 2 || len(decoded.Messages) != 2 {
+		t.Fatalf("unexpected digest frame: %s", frame)
+	}
+	if string(decoded.Messages[0]) != `{"body":"one"}` {
+		t.Fatalf("expected messages in arrival order, got %s", decoded.Messages[0])
+	}
+	if frame, _ := digest.nextFrame(); frame != nil {
+		t.Fatal("expected the batch to be drained")
+	}
+}
+
+func TestHub_EnqueueMessageDigestsSelectedTypes(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+
+	digest, _ := compileDigest(&DigestSettings{IntervalSeconds: 60, MessageTypes: []string{"build_status"}}, 10)
+	client := &Client{hub: hub, send: make(chan outboundMessage, 4), teamID: "team1", userID: "user1", digest: digest}
+
+	if !hub.enqueueMessage(client, outboundMessage{payload: []byte(`{"n":1}`), messageType: "build_status", receivedAt: time.Now()}) {
+		t.Fatal("expected digested message to count as delivered")
+	}
+	if !hub.enqueueMessage(client, outboundMessage{payload: []byte(`{"n":2}`), messageType: "system_alert"}) {
+		t.Fatal("expected immediate message to be delivered")
+	}
+
+	if len(client.send) != 1 {
+		t.Fatalf("expected only the non-digested message on the send queue, got %d", len(client.send))
+	}
+	if pending := digest.drain(); len(pending) != 1 || string(pending[0]) != `{"n":1}` {
+		t.Fatalf("unexpected digest batch: %s", pending)
+	}
+}
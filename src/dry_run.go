@@ -0,0 +1,175 @@
+// dry_run.go
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// maxDryRunRecipients bounds the recipients listed in a dry-run response;
+// the counts always cover every recipient.
+const maxDryRunRecipients = 1000
+
+// dryRunRecipient is one connection a /send would deliver to.
+type dryRunRecipient struct {
+	TeamID       string `json:"team_id"`
+	UserID       string `json:"user_id"`
+	ConnectionID string `json:"connection_id"`
+	Deferred     bool   `json:"deferred,omitempty"` // held back by a blackout window
+	Digest       bool   `json:"digest,omitempty"`   // batched into the client's digest
+}
+
+type dryRunResponse struct {
+	Success    bool              `json:"success"`
+	DryRun     bool              `json:"dry_run"`
+	Delivered  int               `json:"delivered"`          // always 0; nothing is sent
+	Deferred   bool              `json:"deferred,omitempty"` // a team broadcast would be held back by a blackout
+	Queued     bool              `json:"queued,omitempty"`   // a user with no session would get it from the offline queue
+	Held       int               `json:"held,omitempty"`     // migrating sessions that would hold it for the new instance
+	Total      int               `json:"total"`              // connections that would receive it
+	Users      int               `json:"users"`              // distinct users among them
+	Recipients []dryRunRecipient `json:"recipients"`
+	Truncated  bool              `json:"truncated,omitempty"`
+
+	Notification json.RawMessage `json:"notification,omitempty"` // the Message clients would receive, in snake_case
+}
+
+// previewRequest resolves a decoded /send request for tenantID as a dry run,
+// failing as the request would if it were sent now.
+func previewRequest(hub *Hub, tenantID string, req *MessageRequest, now time.Time) (dryRunResponse, error) {
+	if _, err := findTenant(tenantID); err != nil {
+		return dryRunResponse{}, err
+	}
+	teamID, err := scopeTeam(tenantID, req.TargetTeamID)
+	if err != nil {
+		return dryRunResponse{}, err
+	}
+	visibility, err := compileVisibility(req.Visibility)
+	if err != nil {
+		return dryRunResponse{}, err
+	}
+	message := outboundMessage{
+		tenantID:       tenantID,
+		teamID:         teamID,
+		messageType:    req.MessageType,
+		notificationID: req.NotificationID,
+		fanout:         newFanoutCache(req.Body),
+		visibility:     visibility,
+		channel:        req.TargetChannel,
+		urgency:        firstNonEmpty(req.Urgency, AppConfig().Urgency.Default),
+	}
+	return hub.previewSend(req, message, now), nil
+}
+
+// previewSend resolves the connections a /send would reach without queueing
+// anything. Targets are resolved as for a real send, and each client's
+// subscription filter and the notification's visibility rules are applied.
+// Sessions a handover would hold the notification for are counted in Held,
+// and a direct send is Queued when the real send would store it for a user
+// with no session.
+func (h *Hub) previewSend(req *MessageRequest, message outboundMessage, now time.Time) dryRunResponse {
+	target := sentNotification{
+		tenantID:   message.tenantID,
+		teamID:     message.teamID,
+		broadcast:  req.Broadcast || req.TargetChannel != "",
+		channel:    req.TargetChannel,
+		visibility: message.visibility,
+	}
+	if !target.broadcast {
+		target.userID = req.TargetUserID
+	}
+
+	response := dryRunResponse{DryRun: true, Recipients: []dryRunRecipient{}}
+	users := make(map[string]struct{})
+	deferredTeams := make(map[string]bool)
+	policy := urgencyPolicyOf(message.urgency)
+	var audience []*Client
+	if _, onCall := onCallTeam(target.userID); onCall {
+		for _, userID := range onCallSchedules.onCall(message.teamID, now) {
+			target.userID = userID
+			audience = append(audience, h.previewTarget(target, &response)...)
+		}
+	} else {
+		audience = h.previewTarget(target, &response)
+	}
+	for _, client := range audience {
+		if policy.Mutes && !client.filter.accepts(message) {
+			continue
+		}
+		if policy.DND && client.inDND() {
+			continue
+		}
+		recipient := dryRunRecipient{
+			TeamID:       unscopedTeamID(client.tenantID, client.teamID),
+			UserID:       client.userID,
+			ConnectionID: client.connID,
+		}
+		if target.broadcast {
+			deferred, seen := deferredTeams[client.teamID]
+			if !seen {
+				deferred = teamBlackouts.defers(client.teamID, message, now)
+				deferredTeams[client.teamID] = deferred
+			}
+			recipient.Deferred = deferred
+		}
+		recipient.Digest = !recipient.Deferred && policy.Digest && client.digest.wants(message)
+
+		response.Total++
+		users[client.teamID+"\n"+client.userID] = struct{}{}
+		if len(response.Recipients) < maxDryRunRecipients {
+			response.Recipients = append(response.Recipients, recipient)
+		} else {
+			response.Truncated = true
+		}
+	}
+	response.Users = len(users)
+	response.Deferred = target.broadcast && message.teamID != "" && teamBlackouts.defers(message.teamID, message, now)
+	response.Success = response.Total > 0 || response.Deferred || response.Queued || response.Held > 0
+
+	sort.Slice(response.Recipients, func(i, j int) bool {
+		a, b := response.Recipients[i], response.Recipients[j]
+		if a.TeamID != b.TeamID {
+			return a.TeamID < b.TeamID
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.ConnectionID < b.ConnectionID
+	})
+	return response
+}
+
+// previewTarget returns the clients target would reach and adds the handover
+// sessions that would hold it to response. A direct send that no session
+// would take, to a user with no connection, is marked queued when the
+// offline queue is enabled, as routeSend would store it.
+func (h *Hub) previewTarget(target sentNotification, response *dryRunResponse) []*Client {
+	var match func(session *handoverSession) bool
+	switch {
+	case target.channel != "":
+		// Subscriptions do not survive a handover, so channels hold nothing.
+	case target.broadcast && target.teamID != "":
+		match = func(session *handoverSession) bool { return session.teamID == target.teamID }
+	case target.broadcast:
+		match = func(session *handoverSession) bool { return session.tenantID == target.tenantID }
+	case target.teamID != "":
+		match = func(session *handoverSession) bool {
+			return session.teamID == target.teamID && session.userID == target.userID
+		}
+	default:
+		match = func(session *handoverSession) bool {
+			return session.tenantID == target.tenantID && session.userID == target.userID
+		}
+	}
+	held := 0
+	if match != nil {
+		held = h.handover.Load().holding(match)
+	}
+	response.Held += held
+
+	if !target.broadcast && held == 0 && offlineNotifications != nil && !h.userConnected(target.tenantID, target.teamID, target.userID) {
+		response.Queued = true
+	}
+	return h.audienceClients(target)
+}
@@ -0,0 +1,164 @@
+// dry_run_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleSendMessage_DryRun(t *testing.T) {
+	setupTestAppConfig()
+	conversationReads = newConversationIndex(10, 100)
+	notificationRecalls = newRecallLedger(time.Hour, 100)
+	teamBlackouts = newBlackoutSchedule(nil, 10)
+	defer func() { conversationReads, notificationRecalls, teamBlackouts = nil, nil, nil }()
+
+	hub := newHub()
+	newTestClient := func(teamID, userID, connID string) *Client {
+		client := &Client{hub: hub, teamID: teamID, userID: userID, connID: connID, send: make(chan outboundMessage, 1)}
+		if hub.clients[teamID] == nil {
+			hub.clients[teamID] = map[string]map[*Client]struct{}{}
+		}
+		if hub.clients[teamID][userID] == nil {
+			hub.clients[teamID][userID] = map[*Client]struct{}{}
+		}
+		hub.clients[teamID][userID][client] = struct{}{}
+		return client
+	}
+	admin := newTestClient("team-1", "alice", "c1")
+	admin.attributes.setIdentity(nil, nil, true)
+	phone := newTestClient("team-1", "alice", "c2")
+	phone.attributes.setIdentity(nil, nil, true)
+	phone.digest = &clientDigest{maxMessages: 10, flush: make(chan struct{}, 1)}
+	newTestClient("team-1", "bob", "c3")
+	filtered := newTestClient("team-2", "carol", "c4")
+	filtered.filter, _ = compileFilter(&SubscriptionFilter{MessageTypes: []string{"chat"}})
+	newTestClient("team-3", "dave", "c5")
+
+	if _, err := teamBlackouts.add(blackoutWindow{TeamID: "team-3", Start: time.Now().Add(-time.Minute), End: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	preview := func(body string) dryRunResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleSendMessage(hub, rec, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response dryRunResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return response
+	}
+
+	response := preview(`{"notification_id":"n1","target_team_id":"team-1","message_type":"alert","body":"admins","broadcast":true,"dry_run":true,"visibility":[{"attribute":"role","in":["admin"]}]}`)
+	if !response.DryRun || !response.Success || response.Delivered != 0 || response.Total != 2 || response.Users != 1 {
+		t.Fatalf("unexpected team preview: %+v", response)
+	}
+	if got := response.Recipients; got[0].ConnectionID != "c1" || got[0].Digest || got[1].ConnectionID != "c2" || !got[1].Digest {
+		t.Fatalf("unexpected recipients: %+v", got)
+	}
+	if notification := string(response.Notification); !strings.Contains(notification, `"notification_id":"n1"`) || !strings.Contains(notification, `"target_team_id":"team-1"`) {
+		t.Fatalf("expected the notification in snake_case, got %s", notification)
+	}
+
+	response = preview(`{"message_type":"alert","body":"everyone","broadcast":true,"dry_run":true}`)
+	if response.Total != 4 || response.Users != 3 {
+		t.Fatalf("expected the filtered client to be left out of the global preview, got %+v", response)
+	}
+	if last := response.Recipients[3]; last.UserID != "dave" || !last.Deferred {
+		t.Fatalf("expected the blacked-out team to be marked deferred, got %+v", last)
+	}
+
+	response = preview(`{"target_team_id":"team-3","message_type":"alert","body":"later","broadcast":true,"dry_run":true}`)
+	if !response.Success || !response.Deferred {
+		t.Fatalf("expected the team broadcast to be reported as deferred, got %+v", response)
+	}
+
+	response = preview(`{"target_user_id":"alice","message_type":"alert","body":"direct","dry_run":true}`)
+	if response.Total != 2 || response.Users != 1 {
+		t.Fatalf("unexpected direct preview: %+v", response)
+	}
+
+	if len(admin.send) != 0 || len(phone.send) != 0 || len(phone.digest.drain()) != 0 {
+		t.Fatal("expected a dry run not to queue anything")
+	}
+	if len(teamBlackouts.deferred) != 0 || len(conversationReads.list("team-1", "alice")) != 0 {
+		t.Fatal("expected a dry run not to defer or record anything")
+	}
+	if _, err := notificationRecalls.revoke("", "n1"); err != errRecallNotFound {
+		t.Fatalf("expected a dry run not to be recallable, got %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleSendMessage(hub, rec, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(`{"message_type":"alert","dry_run":true,"broadcast":true}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a dry run to be validated like a send, got %d", rec.Code)
+	}
+}
+
+func TestHandleSendMessage_DryRunQueuedAndHeld(t *testing.T) {
+	setupTestAppConfig()
+	offlineNotifications = newOfflineQueue(newMemoryStore(), 10, time.Hour)
+	defer func() { offlineNotifications = nil }()
+
+	hub := newHub()
+	send := func(body string) map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleSendMessage(hub, rec, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return response
+	}
+
+	// A user with no session previews as queued, as the real send reports.
+	preview := send(`{"target_user_id":"zoe","message_type":"alert","body":"later","dry_run":true}`)
+	if preview["success"] != true || preview["queued"] != true || preview["total"] != float64(0) {
+		t.Fatalf("expected the preview to report the send as queued, got %v", preview)
+	}
+	if pending, _ := offlineNotifications.store.PendingFor(context.Background(), offlineRecipient("", "zoe")); len(pending) != 0 {
+		t.Fatalf("expected a dry run not to queue anything, got %d", len(pending))
+	}
+	if real := send(`{"target_user_id":"zoe","message_type":"alert","body":"later"}`); real["success"] != true || real["queued"] != true {
+		t.Fatalf("expected the real send to be queued, got %v", real)
+	}
+
+	offlineNotifications = nil
+	if preview := send(`{"target_user_id":"zoe","message_type":"alert","body":"later","dry_run":true}`); preview["success"] != false || preview["queued"] != nil {
+		t.Fatalf("expected no queue without the offline queue, got %v", preview)
+	}
+
+	// A migrating session holds the notification for the new instance,
+	// even once its connection has gone.
+	alice := &Client{hub: hub, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 1), control: make(chan outboundMessage, 1)}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {alice: {}}}
+	if _, _, err := hub.startHandover("wss://green.example.com/ws", time.Minute, 10); err != nil {
+		t.Fatalf("startHandover failed: %v", err)
+	}
+	delete(hub.clients, "team-1")
+
+	preview = send(`{"target_team_id":"team-1","target_user_id":"alice","message_type":"alert","body":"held","dry_run":true}`)
+	if preview["success"] != true || preview["held"] != float64(1) || preview["queued"] != nil {
+		t.Fatalf("expected the preview to count the held session, got %v", preview)
+	}
+	preview = send(`{"target_team_id":"team-1","message_type":"alert","body":"held","broadcast":true,"dry_run":true}`)
+	if preview["success"] != true || preview["held"] != float64(1) {
+		t.Fatalf("expected the broadcast preview to count the held session, got %v", preview)
+	}
+	if status := hub.handover.Load().status(); status.Held != 0 {
+		t.Fatalf("expected a dry run not to hold anything, got %d", status.Held)
+	}
+}
@@ -0,0 +1,483 @@
+// escalation.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Steps of an escalation chain. A chain lists the steps after the
+// notification's own recipient, in the order they are tried.
+const (
+	escalationStepUser   = "user"   // the original recipient; always the first level
+	escalationStepGroup  = "group"  // members sharing a group with the original recipient
+	escalationStepAdmins = "admins" // the team's admins
+)
+
+// Escalation states, as reported by /admin/escalations.
+const (
+	escalationPending      = "pending"
+	escalationAcknowledged = "acknowledged"
+	escalationExhausted    = "exhausted"
+	escalationCancelled    = "cancelled"
+)
+
+// notificationEscalations is nil unless escalation.enabled is set, and all
+// methods are nil-safe.
+var notificationEscalations *escalationManager
+
+// validateEscalationChain checks the steps of a chain: group, admins,
+// group:<name> or user:<id>.
+func validateEscalationChain(chain []string) error {
+	for _, step := range chain {
+		switch kind, name, named := strings.Cut(step, ":"); {
+		case !named && (kind == escalationStepGroup || kind == escalationStepAdmins):
+		case named && (kind == escalationStepGroup || kind == escalationStepUser) && strings.TrimSpace(name) != "":
+		default:
+			return fmt.Errorf("escalation step %q must be group, admins, group:<name> or user:<id>", step)
+		}
+	}
+	return nil
+}
+
+// escalationLevel is one step of an escalation's trail: who the notification
+// was re-targeted at, and when. A step none of whose users were connected is
+// recorded as skipped.
+type escalationLevel struct {
+	Level      int       `json:"level"`
+	Step       string    `json:"step"`
+	Recipients []string  `json:"recipients"`
+	Skipped    bool      `json:"skipped,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// escalation follows one critical notification until it is acknowledged or
+// its chain runs out.
+type escalation struct {
+	NotificationID string            `json:"notificationId"`
+	TenantID       string            `json:"tenantId,omitempty"`
+	TeamID         string            `json:"teamId"`
+	UserID         string            `json:"userId"`
+	MessageType    string            `json:"messageType"`
+	Chain          []string          `json:"chain"`
+	State          string            `json:"state"`
+	AcknowledgedBy string            `json:"acknowledgedBy,omitempty"`
+	StartedAt      time.Time         `json:"startedAt"`
+	EndedAt        *time.Time        `json:"endedAt,omitempty"`
+	NextAt         *time.Time        `json:"nextAt,omitempty"`
+	Trail          []escalationLevel `json:"trail"`
+
+	message  Message         // as first sent
+	sent     outboundMessage // carries the tenant, team, type and visibility for re-sends
+	groups   []string        // the original recipient's groups when it was sent
+	next     int             // index in Chain of the next step to try
+	notified map[string]struct{}
+}
+
+type escalationKey struct {
+	tenantID       string
+	notificationID string
+}
+
+// escalationManager re-targets critical direct notifications nobody has
+// acknowledged within ackTimeout at the next step of the team's chain, and
+// keeps the trail of each. Escalations live in memory only and are lost on
+// restart.
+type escalationManager struct {
+	ackTimeout time.Duration
+	maxTrails  int
+	chain      []string
+
+	mu       sync.Mutex
+	chains   map[string][]string // per team, from config and /admin/escalations/chains
+	active   map[escalationKey]*escalation
+	finished []*escalation // oldest first, at most maxTrails
+}
+
+func newEscalationManager(chain []string, teams map[string][]string, ackTimeout time.Duration, maxTrails int) *escalationManager {
+	m := &escalationManager{
+		ackTimeout: ackTimeout,
+		maxTrails:  maxTrails,
+		chain:      chain,
+		chains:     make(map[string][]string, len(teams)),
+		active:     make(map[escalationKey]*escalation),
+	}
+	for teamID, teamChain := range teams {
+		m.chains[teamID] = teamChain
+	}
+	return m
+}
+
+func (m *escalationManager) chainLocked(teamID string) []string {
+	if chain, ok := m.chains[teamID]; ok {
+		return chain
+	}
+	return m.chain
+}
+
+// setChain replaces teamID's chain. An empty chain turns escalation off for
+// the team.
+func (m *escalationManager) setChain(teamID string, chain []string) error {
+	if err := validateEscalationChain(chain); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chains[teamID] = slices.Clone(chain)
+	return nil
+}
+
+// removeChain returns teamID to the default chain.
+func (m *escalationManager) removeChain(teamID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.chains[teamID]; !ok {
+		return false
+	}
+	delete(m.chains, teamID)
+	return true
+}
+
+func (m *escalationManager) listChains() map[string][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	chains := make(map[string][]string, len(m.chains))
+	for teamID, chain := range m.chains {
+		chains[teamID] = slices.Clone(chain)
+	}
+	return chains
+}
+
+// start begins escalating a critical notification /send made to one user of
+// a team. The recipient's groups are taken from the connected roster now,
+// since they may have gone offline by the time the notification escalates.
+// It reports whether an escalation was started.
+func (m *escalationManager) start(hub NotificationHub, message *Message, sent outboundMessage, now time.Time) bool {
+	if m == nil || message.NotificationID == "" || message.TargetUserID == "" || sent.teamID == "" {
+		return false
+	}
+	var groups []string
+	for _, member := range hub.TeamMembers(sent.teamID) {
+		if member.UserID == message.TargetUserID {
+			groups = member.Groups
+			break
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	chain := m.chainLocked(sent.teamID)
+	key := escalationKey{sent.tenantID, message.NotificationID}
+	if _, ok := m.active[key]; len(chain) == 0 || ok {
+		return false
+	}
+	next := now.Add(m.ackTimeout)
+	m.active[key] = &escalation{
+		NotificationID: message.NotificationID,
+		TenantID:       sent.tenantID,
+		TeamID:         sent.teamID,
+		UserID:         message.TargetUserID,
+		MessageType:    message.MessageType,
+		Chain:          slices.Clone(chain),
+		State:          escalationPending,
+		StartedAt:      now,
+		NextAt:         &next,
+		Trail:          []escalationLevel{{Step: escalationStepUser, Recipients: []string{message.TargetUserID}, At: now}},
+		message:        *message,
+		sent:           sent,
+		groups:         groups,
+		notified:       map[string]struct{}{message.TargetUserID: {}},
+	}
+	appMetrics.Count("escalation.started", 1, tenantTags(sent.tenantID)...)
+	return true
+}
+
+// acknowledge ends the escalations of notificationIDs that client, one of
+// their recipients, acknowledged.
+func (m *escalationManager) acknowledge(client *Client, notificationIDs []string, now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range notificationIDs {
+		e, ok := m.active[escalationKey{client.tenantID, id}]
+		if !ok || e.TeamID != client.teamID {
+			continue
+		}
+		if _, notified := e.notified[client.userID]; !notified {
+			continue
+		}
+		e.AcknowledgedBy = client.userID
+		m.finishLocked(e, escalationAcknowledged, now)
+		appMetrics.Count("escalation.acknowledged", 1, tenantTags(e.TenantID, metricTag("level", fmt.Sprint(len(e.Trail)-1)))...)
+		slog.Info("Escalation acknowledged", "notification", id, "team", e.TeamID, "user", client.userID, "level", len(e.Trail)-1)
+	}
+}
+
+// cancel ends the escalation of a notification that was recalled or
+// superseded, so nobody else is paged for it.
+func (m *escalationManager) cancel(tenantID, notificationID string, now time.Time) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.active[escalationKey{tenantID, notificationID}]
+	if !ok {
+		return false
+	}
+	m.finishLocked(e, escalationCancelled, now)
+	return true
+}
+
+func (m *escalationManager) finishLocked(e *escalation, state string, now time.Time) {
+	e.State = state
+	e.EndedAt = &now
+	e.NextAt = nil
+	delete(m.active, escalationKey{e.TenantID, e.NotificationID})
+	if len(m.finished) >= m.maxTrails {
+		m.finished = m.finished[len(m.finished)-m.maxTrails+1:]
+	}
+	m.finished = append(m.finished, e)
+}
+
+// escalate moves every escalation whose step has timed out on to the next
+// step of its chain that has a connected user, skipping steps that have
+// none. An escalation past the end of its chain is exhausted.
+func (m *escalationManager) escalate(hub NotificationHub, now time.Time) int {
+	m.mu.Lock()
+	var due []*escalation
+	for _, e := range m.active {
+		if !e.NextAt.After(now) {
+			due = append(due, e)
+		}
+	}
+	m.mu.Unlock()
+
+	escalated := 0
+	for _, e := range due {
+		roster := hub.TeamMembers(e.TeamID)
+
+		m.mu.Lock()
+		if e.State != escalationPending {
+			// Acknowledged while the roster was fetched.
+			m.mu.Unlock()
+			continue
+		}
+		var recipients []string
+		var step string
+		for recipients == nil && e.next < len(e.Chain) {
+			step = e.Chain[e.next]
+			e.next++
+			recipients = e.recipients(step, roster)
+			if recipients == nil {
+				e.Trail = append(e.Trail, escalationLevel{Level: len(e.Trail), Step: step, Recipients: []string{}, Skipped: true, At: now})
+			}
+		}
+		if recipients == nil {
+			m.finishLocked(e, escalationExhausted, now)
+			m.mu.Unlock()
+			appMetrics.Count("escalation.exhausted", 1, tenantTags(e.TenantID)...)
+			slog.Warn("Escalation exhausted its chain without an acknowledgement", "notification", e.NotificationID, "team", e.TeamID)
+			continue
+		}
+		level := len(e.Trail)
+		e.Trail = append(e.Trail, escalationLevel{Level: level, Step: step, Recipients: recipients, At: now})
+		for _, userID := range recipients {
+			e.notified[userID] = struct{}{}
+		}
+		next := now.Add(m.ackTimeout)
+		e.NextAt = &next
+		message, sent := e.message, e.sent
+		m.mu.Unlock()
+
+		message.EscalationLevel = level
+		message.EscalationStep = step
+		payload, err := message.ToJSON()
+		if err != nil {
+			slog.Error("Failed to encode an escalation", "notification", e.NotificationID, "error", err)
+			continue
+		}
+		// The payload differs from the first send's, and escalation is not
+		// delivery latency.
+		sent.payload = payload
+		sent.receivedAt = time.Time{}
+		sent.fanout = nil
+		sent.links = newAttachmentLinks(attachmentPresigner, message)
+		for _, userID := range recipients {
+			hub.SendToUser(e.TeamID, userID, sent)
+		}
+		escalated++
+		appMetrics.Count("escalation.steps", 1, tenantTags(e.TenantID, metricTag("step", step))...)
+		slog.Info("Escalated a notification", "notification", e.NotificationID, "team", e.TeamID, "step", step, "level", level, "recipients", recipients)
+	}
+	return escalated
+}
+
+// recipients resolves a chain step against the team's connected roster,
+// leaving out users the notification already reached. It returns nil when
+// nobody is left.
+func (e *escalation) recipients(step string, roster []teamMember) []string {
+	kind, name, _ := strings.Cut(step, ":")
+	var recipients []string
+	for _, member := range roster {
+		if _, notified := e.notified[member.UserID]; notified {
+			continue
+		}
+		var match bool
+		switch {
+		case kind == escalationStepAdmins:
+			match = member.Admin
+		case kind == escalationStepUser:
+			match = member.UserID == name
+		case name != "":
+			match = slices.Contains(member.Groups, name)
+		default:
+			match = slices.ContainsFunc(e.groups, func(group string) bool { return slices.Contains(member.Groups, group) })
+		}
+		if match {
+			recipients = append(recipients, member.UserID)
+		}
+	}
+	sort.Strings(recipients)
+	return recipients
+}
+
+// list returns the pending escalations and the most recently finished ones
+// for one team, or for every team when teamID is empty, newest first.
+func (m *escalationManager) list(teamID string) (active, finished []escalation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	active, finished = []escalation{}, []escalation{}
+	for _, e := range m.active {
+		if teamID == "" || e.TeamID == teamID {
+			active = append(active, e.view())
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].StartedAt.After(active[j].StartedAt) })
+	for i := len(m.finished) - 1; i >= 0; i-- {
+		if e := m.finished[i]; teamID == "" || e.TeamID == teamID {
+			finished = append(finished, e.view())
+		}
+	}
+	return active, finished
+}
+
+// view copies what /admin/escalations reports, so it can be encoded after
+// the lock is released.
+func (e *escalation) view() escalation {
+	return escalation{
+		NotificationID: e.NotificationID,
+		TenantID:       e.TenantID,
+		TeamID:         e.TeamID,
+		UserID:         e.UserID,
+		MessageType:    e.MessageType,
+		Chain:          e.Chain,
+		State:          e.State,
+		AcknowledgedBy: e.AcknowledgedBy,
+		StartedAt:      e.StartedAt,
+		EndedAt:        e.EndedAt,
+		NextAt:         e.NextAt,
+		Trail:          slices.Clone(e.Trail),
+	}
+}
+
+// run escalates timed-out notifications until stop is closed.
+func (m *escalationManager) run(hub NotificationHub, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.escalate(hub, time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// handleAdminEscalations lists pending and recently finished escalations
+// with their trails (GET ?teamId=).
+func handleAdminEscalations(w http.ResponseWriter, r *http.Request) {
+	if notificationEscalations == nil {
+		http.Error(w, "Escalation is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	active, finished := notificationEscalations.list(strings.TrimSpace(r.URL.Query().Get("teamId")))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"active":   active,
+		"finished": finished,
+	})
+}
+
+type escalationChainRequest struct {
+	TeamID string   `json:"teamId"`
+	Chain  []string `json:"chain"`
+}
+
+// handleAdminEscalationChains lists (GET), sets (PUT) and removes (DELETE
+// ?teamId=) team escalation chains. Chains set here replace escalation.teams
+// until restart.
+func handleAdminEscalationChains(w http.ResponseWriter, r *http.Request) {
+	if notificationEscalations == nil {
+		http.Error(w, "Escalation is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSONWithETag(w, r, map[string]interface{}{
+			"default": notificationEscalations.chain,
+			"teams":   notificationEscalations.listChains(),
+		})
+
+	case http.MethodPut:
+		var req escalationChainRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.TeamID = strings.TrimSpace(req.TeamID)
+		if req.TeamID == "" {
+			http.Error(w, "teamId is required", http.StatusBadRequest)
+			return
+		}
+		if req.Chain == nil {
+			req.Chain = []string{}
+		}
+		if err := notificationEscalations.setChain(req.TeamID, req.Chain); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("Escalation chain set", "team", req.TeamID, "chain", req.Chain)
+		writeJSON(w, http.StatusOK, req)
+
+	case http.MethodDelete:
+		teamID := strings.TrimSpace(r.URL.Query().Get("teamId"))
+		if teamID == "" {
+			http.Error(w, "teamId is required", http.StatusBadRequest)
+			return
+		}
+		if !notificationEscalations.removeChain(teamID) {
+			http.Error(w, "Team has no escalation chain of its own", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
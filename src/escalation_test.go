@@ -0,0 +1,149 @@
+// escalation_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEscalationTeam(hub *syncHub, members map[string][]string, admins ...string) map[string]*Client {
+	clients := make(map[string]*Client, len(members))
+	for userID, groups := range members {
+		client := &Client{teamID: "team-1", userID: userID, send: make(chan outboundMessage, 4)}
+		client.teamAdmin = slices.Contains(admins, userID)
+		client.attributes.setIdentity(nil, groups, client.teamAdmin)
+		hub.Register(client)
+		clients[userID] = client
+	}
+	return clients
+}
+
+func startEscalation(t *testing.T, m *escalationManager, hub NotificationHub, now time.Time) {
+	t.Helper()
+	message := NewMessage("inc-1", "team-1", "alice", "pager", "alert", "db down", true)
+	message.Urgency = urgencyCritical
+	payload, err := message.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.start(hub, message, outboundMessage{payload: payload, teamID: "team-1", messageType: "alert", notificationID: "inc-1", urgency: urgencyCritical}, now) {
+		t.Fatal("expected the escalation to start")
+	}
+}
+
+func TestEscalationManager_Chain(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	clients := newEscalationTeam(hub, map[string][]string{
+		"alice": {"sre"},
+		"bob":   {"sre"},
+		"carol": nil,
+		"dave":  {"ops"},
+	}, "carol")
+	m := newEscalationManager([]string{"group", "group:dba", "admins"}, nil, time.Minute, 10)
+	now := time.Now()
+	startEscalation(t, m, hub, now)
+
+	if escalated := m.escalate(hub, now.Add(30*time.Second)); escalated != 0 {
+		t.Fatalf("expected nothing to escalate before ack_timeout, got %d", escalated)
+	}
+	if escalated := m.escalate(hub, now.Add(time.Minute)); escalated != 1 || len(clients["bob"].send) != 1 {
+		t.Fatalf("expected the notification to reach alice's group, escalated %d", escalated)
+	}
+	var message Message
+	if err := json.Unmarshal((<-clients["bob"].send).payload, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.NotificationID != "inc-1" || message.EscalationLevel != 1 || message.EscalationStep != "group" {
+		t.Fatalf("expected an escalated copy of inc-1, got %+v", message)
+	}
+
+	// Nobody is in group dba, so the admins are next.
+	m.escalate(hub, now.Add(2*time.Minute))
+	if len(clients["carol"].send) != 1 || len(clients["dave"].send) != 0 {
+		t.Fatalf("expected only the admin to be paged, got %d and %d", len(clients["carol"].send), len(clients["dave"].send))
+	}
+
+	m.escalate(hub, now.Add(3*time.Minute))
+	active, finished := m.list("")
+	if len(active) != 0 || len(finished) != 1 || finished[0].State != escalationExhausted {
+		t.Fatalf("expected the escalation to be exhausted, got %+v %+v", active, finished)
+	}
+	var steps []string
+	for _, level := range finished[0].Trail {
+		steps = append(steps, strings.Join(append([]string{level.Step}, level.Recipients...), "="))
+	}
+	if want := []string{"user=alice", "group=bob", "group:dba", "admins=carol"}; !reflect.DeepEqual(steps, want) {
+		t.Fatalf("expected trail %v, got %v", want, steps)
+	}
+	if !finished[0].Trail[2].Skipped {
+		t.Fatal("expected the empty step to be recorded as skipped")
+	}
+}
+
+func TestEscalationManager_Acknowledge(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	clients := newEscalationTeam(hub, map[string][]string{"alice": {"sre"}, "bob": {"sre"}, "carol": nil}, "carol")
+	m := newEscalationManager([]string{"group", "admins"}, map[string][]string{"team-2": {}}, time.Minute, 10)
+	now := time.Now()
+	startEscalation(t, m, hub, now)
+	m.escalate(hub, now.Add(time.Minute))
+
+	// carol has not been paged yet, so her ack does not count.
+	m.acknowledge(clients["carol"], []string{"inc-1"}, now.Add(90*time.Second))
+	m.acknowledge(clients["bob"], []string{"inc-1"}, now.Add(90*time.Second))
+	if escalated := m.escalate(hub, now.Add(2*time.Minute)); escalated != 0 || len(clients["carol"].send) != 0 {
+		t.Fatalf("expected the acknowledged escalation to stop, escalated %d", escalated)
+	}
+	_, finished := m.list("team-1")
+	if len(finished) != 1 || finished[0].State != escalationAcknowledged || finished[0].AcknowledgedBy != "bob" {
+		t.Fatalf("expected bob's acknowledgement, got %+v", finished)
+	}
+
+	// An empty team chain turns escalation off.
+	message := NewMessage("inc-2", "team-2", "alice", "pager", "alert", "x", true)
+	if m.start(hub, message, outboundMessage{teamID: "team-2"}, now) {
+		t.Fatal("expected no escalation for a team with an empty chain")
+	}
+}
+
+func TestHandleAdminEscalationChains(t *testing.T) {
+	setupTestAppConfig()
+	notificationEscalations = newEscalationManager([]string{"admins"}, nil, time.Minute, 10)
+	defer func() { notificationEscalations = nil }()
+
+	rr := httptest.NewRecorder()
+	handleAdminEscalationChains(rr, httptest.NewRequest(http.MethodPut, "/admin/escalations/chains", strings.NewReader(`{"teamId":"team-1","chain":["group:sre","user:lead"]}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	handleAdminEscalationChains(rr, httptest.NewRequest(http.MethodGet, "/admin/escalations/chains", nil))
+	if !strings.Contains(rr.Body.String(), `"team-1":["group:sre","user:lead"]`) {
+		t.Fatalf("expected the team's chain to be listed, got %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminEscalationChains(rr, httptest.NewRequest(http.MethodPut, "/admin/escalations/chains", strings.NewReader(`{"teamId":"team-1","chain":["everyone"]}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown step to be rejected, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminEscalationChains(rr, httptest.NewRequest(http.MethodDelete, "/admin/escalations/chains?teamId=team-1", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handleAdminEscalationChains(rr, httptest.NewRequest(http.MethodDelete, "/admin/escalations/chains?teamId=team-1", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 once the chain is gone, got %d", rr.Code)
+	}
+}
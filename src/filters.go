@@ -0,0 +1,205 @@
+// filters.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	maxFilterMessageTypes = 64
+	maxFilterPredicates   = 16
+)
+
+// clientFilter is a compiled SubscriptionFilter. key is a canonical encoding
+// of the filter so clients with identical filters share cached decisions.
+type clientFilter struct {
+	key          string
+	messageTypes map[string]struct{}
+	predicates   []compiledPredicate
+}
+
+type compiledPredicate struct {
+	path   []string
+	equals interface{}
+	in     []interface{}
+	exists *bool
+}
+
+// compileFilter validates a client's filter and prepares it for fan-out. A nil
+// filter (or one with no conditions) accepts everything and compiles to nil.
+func compileFilter(filter *SubscriptionFilter) (*clientFilter, error) {
+	if filter == nil || (len(filter.MessageTypes) == 0 && len(filter.Body) == 0) {
+		return nil, nil
+	}
+	if len(filter.MessageTypes) > maxFilterMessageTypes {
+		return nil, fmt.Errorf("filters.messageTypes supports at most %d entries", maxFilterMessageTypes)
+	}
+	if len(filter.Body) > maxFilterPredicates {
+		return nil, fmt.Errorf("filters.body supports at most %d predicates", maxFilterPredicates)
+	}
+
+	compiled := &clientFilter{}
+	if len(filter.MessageTypes) > 0 {
+		compiled.messageTypes = make(map[string]struct{}, len(filter.MessageTypes))
+		for _, messageType := range filter.MessageTypes {
+			messageType = strings.TrimSpace(messageType)
+			if messageType == "" {
+				return nil, errors.New("filters.messageTypes entries must not be empty")
+			}
+			compiled.messageTypes[messageType] = struct{}{}
+		}
+	}
+
+	for i, predicate := range filter.Body {
+		path := strings.TrimPrefix(strings.TrimSpace(predicate.Path), "$.")
+		if path == "" {
+			return nil, fmt.Errorf("filters.body[%d].path is required", i)
+		}
+		conditions := 0
+		if predicate.Equals != nil {
+			conditions++
+		}
+		if predicate.In != nil {
+			conditions++
+		}
+		if predicate.Exists != nil {
+			conditions++
+		}
+		if conditions != 1 {
+			return nil, fmt.Errorf("filters.body[%d] must set exactly one of equals, in or exists", i)
+		}
+		compiled.predicates = append(compiled.predicates, compiledPredicate{
+			path:   strings.Split(path, "."),
+			equals: predicate.Equals,
+			in:     predicate.In,
+			exists: predicate.Exists,
+		})
+	}
+
+	types := make([]string, 0, len(compiled.messageTypes))
+	for messageType := range compiled.messageTypes {
+		types = append(types, messageType)
+	}
+	sort.Strings(types)
+	key, err := json.Marshal(struct {
+		Types []string
+		Body  []BodyPredicate
+	}{types, filter.Body})
+	if err != nil {
+		return nil, err
+	}
+	compiled.key = string(key)
+
+	return compiled, nil
+}
+
+// fanoutCache is shared by every copy of one outbound message during fan-out.
+// It parses the JSON body at most once and memoizes each distinct filter's
+// decision, so a broadcast to many clients with the same filter evaluates it
+// once. It also keeps the notification's frame for each wire protocol.
+type fanoutCache struct {
+	mu        sync.Mutex
+	body      string
+	parsed    bool
+	bodyValue interface{}
+	bodyIsObj bool
+	decisions map[string]bool
+	frames    map[wireProtocol]encodedFrame // see frameFor
+}
+
+func newFanoutCache(body string) *fanoutCache {
+	return &fanoutCache{body: body, decisions: make(map[string]bool)}
+}
+
+func (c *fanoutCache) parsedBody() (interface{}, bool) {
+	if !c.parsed {
+		c.parsed = true
+		if err := json.Unmarshal([]byte(c.body), &c.bodyValue); err == nil {
+			_, c.bodyIsObj = c.bodyValue.(map[string]interface{})
+		}
+	}
+	return c.bodyValue, c.bodyIsObj
+}
+
+// accepts reports whether the filter lets message through.
+func (f *clientFilter) accepts(message outboundMessage) bool {
+	if f == nil || message.messageType == "" {
+		// No filter, or a control/system frame that filters never apply to.
+		return true
+	}
+	if message.fanout == nil {
+		return f.evaluate(message.messageType, newFanoutCache(""))
+	}
+
+	message.fanout.mu.Lock()
+	defer message.fanout.mu.Unlock()
+
+	if decision, ok := message.fanout.decisions[f.key]; ok {
+		return decision
+	}
+	decision := f.evaluate(message.messageType, message.fanout)
+	message.fanout.decisions[f.key] = decision
+	return decision
+}
+
+func (f *clientFilter) evaluate(messageType string, cache *fanoutCache) bool {
+	if f.messageTypes != nil {
+		if _, ok := f.messageTypes[messageType]; !ok {
+			return false
+		}
+	}
+	if len(f.predicates) == 0 {
+		return true
+	}
+
+	body, isObject := cache.parsedBody()
+	if !isObject {
+		// Predicates only match structured bodies.
+		return false
+	}
+	for _, predicate := range f.predicates {
+		if !predicate.matches(body) {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupJSONPath(value interface{}, path []string) (interface{}, bool) {
+	for _, segment := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = object[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func (p compiledPredicate) matches(body interface{}) bool {
+	value, found := lookupJSONPath(body, p.path)
+	switch {
+	case p.exists != nil:
+		return found == *p.exists
+	case !found:
+		return false
+	case p.equals != nil:
+		return reflect.DeepEqual(value, p.equals)
+	default:
+		for _, candidate := range p.in {
+			if reflect.DeepEqual(value, candidate) {
+				return true
+			}
+		}
+		return false
+	}
+}
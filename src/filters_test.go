@@ -0,0 +1,84 @@
+// filters_test.go
+package main
+
+import "testing"
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func TestClientFilter_Accepts(t *testing.T) {
+	filter, err := compileFilter(&SubscriptionFilter{
+		MessageTypes: []string{"system_alert", "ai_response"},
+		Body: []BodyPredicate{
+			{Path: "severity", In: []interface{}{"high", "critical"}},
+			{Path: "$.build.status", Equals: "failed"},
+			{Path: "muted", Exists: boolPtr(false)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("compileFilter failed: %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		messageType string
+		body        string
+		expected    bool
+	}{
+		{"matching alert", "system_alert", `{"severity":"critical","build":{"status":"failed"}}`, true},
+		{"wrong message type", "user_message", `{"severity":"critical","build":{"status":"failed"}}`, false},
+		{"severity not in set", "system_alert", `{"severity":"low","build":{"status":"failed"}}`, false},
+		{"nested value differs", "ai_response", `{"severity":"high","build":{"status":"passed"}}`, false},
+		{"excluded field present", "system_alert", `{"severity":"high","build":{"status":"failed"},"muted":true}`, false},
+		{"plain text body", "system_alert", `server restarting`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message := outboundMessage{messageType: tc.messageType, fanout: newFanoutCache(tc.body)}
+			if got := filter.accepts(message); got != tc.expected {
+				t.Fatalf("accepts() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestClientFilter_ControlFramesAlwaysPass(t *testing.T) {
+	filter, _ := compileFilter(&SubscriptionFilter{MessageTypes: []string{"system_alert"}})
+	if !filter.accepts(outboundMessage{payload: []byte(`{"type":"backpressure"}`)}) {
+		t.Fatal("filters must not apply to control frames")
+	}
+}
+
+func TestCompileFilter_Validation(t *testing.T) {
+	if filter, err := compileFilter(&SubscriptionFilter{}); err != nil || filter != nil {
+		t.Fatalf("expected an empty filter to compile to nil, got %v, %v", filter, err)
+	}
+	if _, err := compileFilter(&SubscriptionFilter{Body: []BodyPredicate{{Path: "severity"}}}); err == nil {
+		t.Fatal("expected an error for a predicate without a condition")
+	}
+	if _, err := compileFilter(&SubscriptionFilter{Body: []BodyPredicate{{Path: "a", Equals: "x", Exists: boolPtr(true)}}}); err == nil {
+		t.Fatal("expected an error for a predicate with multiple conditions")
+	}
+}
+
+func TestHub_FiltersAppliedDuringFanout(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+
+	alertsOnly, _ := compileFilter(&SubscriptionFilter{MessageTypes: []string{"system_alert"}})
+	filtered := &Client{teamID: "team-a", userID: "thin", send: make(chan outboundMessage, 2), filter: alertsOnly}
+	unfiltered := &Client{teamID: "team-a", userID: "full", send: make(chan outboundMessage, 2)}
+	hub.clients = map[string]map[string]map[*Client]struct{}{
+		"team-a": {"thin": {filtered: {}}, "full": {unfiltered: {}}},
+	}
+
+	message := outboundMessage{payload: []byte("typing"), messageType: "typing", fanout: newFanoutCache("")}
+	if delivered := hub.broadcastToTeam("team-a", message); delivered != 1 {
+		t.Fatalf("expected the filtered client to be skipped, delivered=%d", delivered)
+	}
+	if len(filtered.send) != 0 {
+		t.Fatal("filtered client should not receive non-matching messages")
+	}
+}
@@ -0,0 +1,249 @@
+// firehose.go
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// firehoseBuffer is how many events a firehose subscriber may fall behind
+// before further events are dropped for it.
+const firehoseBuffer = 1024
+
+// Routing outcomes reported by the firehose. The per-connection outcomes come
+// from enqueueMessage and the writePump; the per-send ones from /send.
+const (
+	firehoseQueued     = "queued"     // in the connection's send queue
+	firehoseWritten    = "written"    // written to the socket
+	firehoseDropped    = "dropped"    // send queue full, connection closed
+	firehoseFiltered   = "filtered"   // excluded by the connection's filter
+	firehoseHidden     = "hidden"     // excluded by visibility rules
+	firehoseRecalled   = "recalled"   // recalled before it was queued
+	firehoseDigested   = "digested"   // folded into the connection's digest
+	firehoseHeld       = "held"       // held for a handover
+	firehoseWithheld   = "withheld"   // withheld from a do-not-disturb connection
+	firehoseRouted     = "routed"     // /send reached at least one connection
+	firehoseUnrouted   = "unrouted"   // /send reached no connection
+	firehoseDeferred   = "deferred"   // /send deferred by a blackout
+	firehoseStored     = "stored"     // /send queued for a user with no connection
+	firehoseSuppressed = "suppressed" // /send grouped into an incident's rollup
+)
+
+// firehoseEvent is the metadata of one routing step. It never carries the
+// message payload.
+type firehoseEvent struct {
+	Type           string    `json:"type"` // always "message"
+	Time           time.Time `json:"time"`
+	Outcome        string    `json:"outcome"`
+	NotificationID string    `json:"notificationId,omitempty"`
+	MessageType    string    `json:"messageType,omitempty"`
+	TenantID       string    `json:"tenantId,omitempty"`
+	TeamID         string    `json:"teamId,omitempty"`
+	UserID         string    `json:"userId,omitempty"`
+	ConnID         string    `json:"connectionId,omitempty"`
+	Size           int       `json:"size"`
+	Recipients     *int      `json:"recipients,omitempty"` // per-send outcomes only
+	LatencyMs      float64   `json:"latencyMs"`            // since /send received the message
+}
+
+// firehoseFilter selects events server-side. Empty fields match everything.
+type firehoseFilter struct {
+	TeamID         string   `json:"teamId,omitempty"`
+	UserID         string   `json:"userId,omitempty"`
+	MessageType    string   `json:"messageType,omitempty"`
+	NotificationID string   `json:"notificationId,omitempty"`
+	Outcomes       []string `json:"outcomes,omitempty"`
+}
+
+func (f *firehoseFilter) matches(event firehoseEvent) bool {
+	if f == nil {
+		return true
+	}
+	if f.TeamID != "" && f.TeamID != event.TeamID && f.TeamID != unscopedTeamID(event.TenantID, event.TeamID) {
+		return false
+	}
+	if f.UserID != "" && f.UserID != event.UserID {
+		return false
+	}
+	if f.MessageType != "" && f.MessageType != event.MessageType {
+		return false
+	}
+	if f.NotificationID != "" && f.NotificationID != event.NotificationID {
+		return false
+	}
+	if len(f.Outcomes) > 0 {
+		for _, outcome := range f.Outcomes {
+			if outcome == event.Outcome {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+type firehoseSubscriber struct {
+	filter  atomic.Pointer[firehoseFilter]
+	events  chan firehoseEvent
+	dropped atomic.Int64
+}
+
+// firehose fans routing events out to /debug/firehose subscribers. Without
+// subscribers, recording an event is a single atomic load.
+type firehose struct {
+	mu          sync.Mutex
+	subscribers map[*firehoseSubscriber]struct{}
+	count       atomic.Int32
+}
+
+var messageFirehose = newFirehose()
+
+func newFirehose() *firehose {
+	return &firehose{subscribers: make(map[*firehoseSubscriber]struct{})}
+}
+
+func (f *firehose) subscribe(filter *firehoseFilter) *firehoseSubscriber {
+	subscriber := &firehoseSubscriber{events: make(chan firehoseEvent, firehoseBuffer)}
+	subscriber.filter.Store(filter)
+	f.mu.Lock()
+	f.subscribers[subscriber] = struct{}{}
+	f.count.Store(int32(len(f.subscribers)))
+	f.mu.Unlock()
+	return subscriber
+}
+
+func (f *firehose) unsubscribe(subscriber *firehoseSubscriber) {
+	f.mu.Lock()
+	delete(f.subscribers, subscriber)
+	f.count.Store(int32(len(f.subscribers)))
+	f.mu.Unlock()
+}
+
+// record reports what happened to message on client's connection.
+func (f *firehose) record(message outboundMessage, client *Client, outcome string) {
+	if f.count.Load() == 0 {
+		return
+	}
+	event := firehoseEventFor(message, outcome)
+	event.TenantID = client.tenantID
+	event.TeamID = client.teamID
+	event.UserID = client.userID
+	event.ConnID = client.connID
+	f.publish(event)
+}
+
+// recordSend reports how /send routed message to userID, or to the team when
+// userID is empty.
+func (f *firehose) recordSend(message outboundMessage, userID, outcome string, recipients int) {
+	if f.count.Load() == 0 {
+		return
+	}
+	event := firehoseEventFor(message, outcome)
+	event.UserID = userID
+	event.Recipients = &recipients
+	f.publish(event)
+}
+
+func firehoseEventFor(message outboundMessage, outcome string) firehoseEvent {
+	event := firehoseEvent{
+		Type:           "message",
+		Time:           time.Now().UTC(),
+		Outcome:        outcome,
+		NotificationID: message.notificationID,
+		MessageType:    message.messageType,
+		TenantID:       message.tenantID,
+		TeamID:         message.teamID,
+		Size:           len(message.payload),
+	}
+	if !message.receivedAt.IsZero() {
+		event.LatencyMs = float64(time.Since(message.receivedAt).Microseconds()) / 1000
+	}
+	return event
+}
+
+func (f *firehose) publish(event firehoseEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for subscriber := range f.subscribers {
+		if !subscriber.filter.Load().matches(event) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			subscriber.dropped.Add(1)
+		}
+	}
+}
+
+// firehoseAuth is the first frame on /debug/firehose: the admin feed auth
+// plus an optional filter. Later {"type": "filter", "filter": {...}} frames
+// replace the filter.
+type firehoseAuth struct {
+	Type   string          `json:"type"`
+	Filter *firehoseFilter `json:"filter,omitempty"`
+}
+
+// handleDebugFirehose streams the routing metadata of every message to an
+// admin until the admin disconnects.
+func handleDebugFirehose(w http.ResponseWriter, r *http.Request) {
+	conn, frame, ok := acceptAdminSocket(w, r, "firehose")
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	var auth firehoseAuth
+	_ = json.Unmarshal(frame, &auth)
+	subscriber := messageFirehose.subscribe(auth.Filter)
+	defer messageFirehose.unsubscribe(subscriber)
+	slog.Info("Firehose subscriber connected", "remote_addr", r.RemoteAddr)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var update firehoseAuth
+			if err := conn.ReadJSON(&update); err != nil {
+				return
+			}
+			if update.Type == "filter" {
+				subscriber.filter.Store(update.Filter)
+			}
+		}
+	}()
+
+	// acceptAdminSocket expects a ping every PingPeriod, so an idle
+	// subscriber that has gone away is still noticed.
+	pings := time.NewTicker(AppConfig().WebSocket.PingPeriod)
+	defer pings.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-pings.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case event := <-subscriber.events:
+			_ = conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+			if dropped := subscriber.dropped.Swap(0); dropped > 0 {
+				appMetrics.Count("firehose.dropped", dropped)
+				if err := conn.WriteJSON(map[string]interface{}{"type": "lagged", "dropped": dropped}); err != nil {
+					return
+				}
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// firehose_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDebugFirehose_StreamsFilteredMetadata(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Security.APIKey = "admin-secret"
+	authFailures = nil
+
+	server := httptest.NewServer(http.HandlerFunc(handleDebugFirehose))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if err := conn.WriteJSON(map[string]interface{}{
+		"type":   "auth",
+		"apiKey": "admin-secret",
+		"filter": firehoseFilter{TeamID: "team-f", Outcomes: []string{firehoseQueued, firehoseDropped}},
+	}); err != nil {
+		t.Fatalf("failed to send auth: %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); messageFirehose.count.Load() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the subscription")
+		}
+	}
+
+	hub := newHub()
+	watched := &Client{hub: hub, teamID: "team-f", userID: "alice", connID: "c1", send: make(chan outboundMessage, 1)}
+	other := &Client{hub: hub, teamID: "team-g", userID: "bob", connID: "c2", send: make(chan outboundMessage, 1)}
+	message := outboundMessage{
+		payload:        []byte(`{"body":"secret"}`),
+		receivedAt:     time.Now(),
+		teamID:         "team-f",
+		messageType:    "chat",
+		notificationID: "n1",
+	}
+	hub.enqueueMessage(other, message)
+	hub.enqueueMessage(watched, message)
+	hub.enqueueMessage(watched, message) // the queue holds one message
+
+	for _, want := range []string{firehoseQueued, firehoseDropped} {
+		var event firehoseEvent
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read an event: %v", err)
+		}
+		if strings.Contains(string(frame), "secret") {
+			t.Fatalf("expected the payload to be redacted, got %s", frame)
+		}
+		if err := json.Unmarshal(frame, &event); err != nil {
+			t.Fatalf("unexpected frame %s: %v", frame, err)
+		}
+		if event.Outcome != want || event.ConnID != "c1" || event.NotificationID != "n1" || event.Size != len(message.payload) {
+			t.Fatalf("expected a %s event for c1, got %+v", want, event)
+		}
+	}
+}
+
+func TestDebugFirehose_RejectsInvalidKey(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Security.APIKey = "admin-secret"
+	authFailures = nil
+
+	server := httptest.NewServer(http.HandlerFunc(handleDebugFirehose))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if err := conn.WriteJSON(adminFeedAuth{Type: "auth", APIKey: "wrong"}); err != nil {
+		t.Fatalf("failed to send auth: %v", err)
+	}
+	var frame map[string]interface{}
+	if err := conn.ReadJSON(&frame); err != nil || frame["type"] != "auth_error" {
+		t.Fatalf("expected auth_error, got %v, %v", frame, err)
+	}
+}
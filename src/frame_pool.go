@@ -0,0 +1,79 @@
+// frame_pool.go
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+)
+
+// maxPooledFrameBuffer is the largest buffer returned to frameBuffers; the
+// occasional huge frame should not pin its memory in the pool.
+const maxPooledFrameBuffer = 64 << 10
+
+// frameBuffers holds scratch buffers for encoding frames. Encoded frames are
+// copied out of them, since a frame may be kept after the buffer is reused.
+var frameBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getFrameBuffer() *bytes.Buffer {
+	buf := frameBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// releaseFrameBuffer returns a copy of buf's contents, sized exactly, and
+// puts buf back in the pool.
+func releaseFrameBuffer(buf *bytes.Buffer) []byte {
+	data := append([]byte(nil), buf.Bytes()...)
+	if buf.Cap() <= maxPooledFrameBuffer {
+		frameBuffers.Put(buf)
+	}
+	return data
+}
+
+// encodedFrame is a notification encoded for one wire protocol. data is
+// shared by every connection it is written to and must not be modified.
+type encodedFrame struct {
+	messageType int
+	data        []byte
+}
+
+// frameFor returns the notification frame of payload for protocol. Every
+// recipient of a fanned-out notification that speaks the same protocol gets
+// the same frame, encoded once. Messages with attachment links do not use it:
+// their links are renewed between writes.
+func (c *fanoutCache) frameFor(protocol wireProtocol, payload []byte) (int, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if frame, ok := c.frames[protocol]; ok {
+		return frame.messageType, frame.data, nil
+	}
+	messageType, data, err := protocol.encodeFrame(payload, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	if c.frames == nil {
+		c.frames = make(map[wireProtocol]encodedFrame, 1)
+	}
+	c.frames[protocol] = encodedFrame{messageType: messageType, data: data}
+	return messageType, data, nil
+}
+
+// encodeBatchFrame builds the JSON batch frame of messages. The payloads are
+// JSON already, so they are copied in rather than re-encoded.
+func encodeBatchFrame(messages []outboundMessage) []byte {
+	buf := getFrameBuffer()
+	buf.WriteString(`{"type":"batch","count":`)
+	buf.WriteString(strconv.Itoa(len(messages)))
+	buf.WriteString(`,"messages":[`)
+	for i, message := range messages {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(message.deliveryPayload())
+	}
+	buf.WriteString(`]}`)
+	return releaseFrameBuffer(buf)
+}
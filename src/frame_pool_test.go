@@ -0,0 +1,126 @@
+// frame_pool_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEncodeBatchFrame_MatchesJSON(t *testing.T) {
+	// Payloads are encoded by the server, and so already escaped.
+	first, _ := (&Message{NotificationID: "n1", Body: "<b>hi</b>"}).ToJSON()
+	second, _ := (&Message{NotificationID: "n2", Body: "x"}).ToJSON()
+	messages := []outboundMessage{{payload: first}, {payload: second}}
+	want, _ := json.Marshal(BatchFrame{Type: "batch", Count: 2, Messages: []json.RawMessage{messages[0].payload, messages[1].payload}})
+	if got := encodeBatchFrame(messages); !bytes.Equal(got, want) {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	// The frame outlives the pooled buffer it was built in.
+	frame := encodeBatchFrame(messages[:1])
+	encodeBatchFrame(messages[1:])
+	if !bytes.Contains(frame, []byte(`"n1"`)) || bytes.Contains(frame, []byte(`"n2"`)) {
+		t.Fatalf("expected the first frame to be unchanged, got %s", frame)
+	}
+}
+
+func TestFanoutCache_SharesFramesPerProtocol(t *testing.T) {
+	payload := []byte(`{"notificationId":"n1","messageType":"chat","body":"hi"}`)
+	cache := newFanoutCache("hi")
+
+	_, v2, err := cache.frameFor(protocolJSONv2, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(struct {
+		Type         string          `json:"type"`
+		Notification json.RawMessage `json:"notification"`
+	}{"notification", payload})
+	if !bytes.Equal(v2, want) {
+		t.Fatalf("expected %s, got %s", want, v2)
+	}
+	if _, again, _ := cache.frameFor(protocolJSONv2, payload); &again[0] != &v2[0] {
+		t.Error("expected the json.v2 frame to be encoded once")
+	}
+	if _, msgpack, _ := cache.frameFor(protocolMsgpackV1, payload); bytes.Equal(msgpack, v2) {
+		t.Error("expected msgpack.v1 to get a frame of its own")
+	}
+
+	// Binary-capable clients share the frame but get it as a binary message.
+	client := &Client{protocol: protocolJSONv2}
+	client.caps.binary = true
+	messageType, data, _ := client.encodeNotification(outboundMessage{payload: payload, messageType: "chat", fanout: cache})
+	if messageType != websocket.BinaryMessage || &data[0] != &v2[0] {
+		t.Errorf("expected the shared frame as a binary message, got type %d", messageType)
+	}
+}
+
+// discardConn drops everything written to it, so benchmarks measure encoding
+// rather than the mock's bookkeeping.
+type discardConn struct {
+	*mockConn
+}
+
+func (discardConn) WriteMessage(int, []byte) error { return nil }
+
+// BenchmarkBroadcastToTeam fans one notification out to 1000 clients spread
+// over the three wire protocols and writes it to each of them, as their
+// writePumps would.
+func BenchmarkBroadcastToTeam(b *testing.B) {
+	setupTestAppConfig()
+	hub := newHub()
+	go hub.run()
+
+	protocols := []wireProtocol{protocolJSONv1, protocolJSONv2, protocolMsgpackV1}
+	clients := make([]*Client, 1000)
+	for i := range clients {
+		clients[i] = &Client{
+			hub:      hub,
+			conn:     discardConn{newMockConn()},
+			teamID:   "team-bench",
+			userID:   fmt.Sprintf("user-%d", i),
+			protocol: protocols[i%len(protocols)],
+			send:     make(chan outboundMessage, 4),
+		}
+		if err := hub.RegisterAndWait(context.Background(), clients[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	message := &Message{
+		NotificationID: "notif-bench",
+		TargetTeamID:   "team-bench",
+		MessageType:    "system_alert",
+		Body:           `{"title":"Deploy finished","service":"api","status":"ok","durationMs":48213}`,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		payload, err := message.ToJSON()
+		if err != nil {
+			b.Fatal(err)
+		}
+		outbound := outboundMessage{
+			payload:        payload,
+			receivedAt:     time.Now(),
+			teamID:         message.TargetTeamID,
+			messageType:    message.MessageType,
+			notificationID: message.NotificationID,
+			fanout:         newFanoutCache(message.Body),
+		}
+		if sent := hub.broadcastToTeam("team-bench", outbound); sent != len(clients) {
+			b.Fatalf("expected %d recipients, got %d", len(clients), sent)
+		}
+		for _, client := range clients {
+			if err := client.writeNotification(<-client.send); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -3,44 +3,63 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-func newUpgrader() websocket.Upgrader {
+// newUpgrader builds the websocket upgrader. With a tenant hint the origin
+// must satisfy that tenant's policy; without one, any tenant's origins are
+// accepted until the auth frame names the tenant.
+func newUpgrader(tenant *TenantConfig) websocket.Upgrader {
 	return websocket.Upgrader{
-		ReadBufferSize:  AppConfig.WebSocket.BufferSize.Read,
-		WriteBufferSize: AppConfig.WebSocket.BufferSize.Write,
+		ReadBufferSize:  AppConfig().WebSocket.BufferSize.Read,
+		WriteBufferSize: AppConfig().WebSocket.BufferSize.Write,
 		CheckOrigin: func(r *http.Request) bool {
 			origin := r.Header.Get("Origin")
 
 			if ShouldAllowAllOrigins() {
 				if IsDevelopment() {
-					log.Printf("🧪 DEV: Allowing origin %s (development mode)", origin)
+					slog.Debug("Allowing origin in development mode", "origin", origin)
 				} else {
-					log.Printf("⚠️  WARNING: Allowing all origins in production!")
+					slog.Warn("Allowing all origins in production")
 				}
 				return true
 			}
 
+			if tenant != nil && len(tenant.AllowedOrigins) > 0 {
+				if slices.Contains(tenant.AllowedOrigins, origin) {
+					return true
+				}
+				slog.Warn("Origin rejected for tenant", "origin", origin, "tenant", tenant.ID)
+				return false
+			}
+			if tenant == nil && originAllowedByAnyTenant(origin) {
+				return true
+			}
 			return IsOriginAllowed(origin)
 		},
 	}
 }
 
-func writeWebSocketAuthError(conn Conn, message string) {
-	_ = conn.SetWriteDeadline(time.Now().Add(AppConfig.WebSocket.WriteWait))
-	if err := conn.WriteJSON(map[string]string{
+func writeWebSocketAuthError(conn Conn, protocol wireProtocol, message string) {
+	_ = conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+	if err := writeJSONFrame(conn, protocol, map[string]string{
 		"type":    "auth_error",
 		"message": message,
 	}); err != nil {
-		log.Printf("failed to send websocket auth error: %v", err)
+		slog.Error("Failed to send the websocket auth error", "error", err)
 	}
 }
 
@@ -84,6 +103,141 @@ func decodeAuthMessage(body []byte) (*AuthMessage, error) {
 	return &authMsg, nil
 }
 
+// authRejection is why a connection was refused while authenticating. status
+// is the HTTP status used when authentication happens before the upgrade.
+type authRejection struct {
+	status  int
+	message string
+}
+
+// authMessageFromQuery builds the auth payload for handshake authentication
+// (?token=&teamId=&userId=).
+func authMessageFromQuery(query url.Values) *AuthMessage {
+	authMsg := &AuthMessage{
+		Type:   "auth",
+		UserID: query.Get("userId"),
+		TeamID: query.Get("teamId"),
+		Token:  query.Get("token"),
+
+		ResumeToken: query.Get("resumeToken"),
+	}
+	authMsg.Normalize()
+	return authMsg
+}
+
+// authenticateConnection runs every check between a decoded auth payload and
+// registration: team and tenant selection, origin policy, filters, lockouts,
+// credentials, bans and client limits. It fills in client on success.
+func authenticateConnection(hub *Hub, r *http.Request, client *Client, authMsg *AuthMessage, pathTeam string, hinted *TenantConfig) *authRejection {
+	clientIP := clientIPFromRequest(r)
+
+	if pathTeam != "" {
+		// The team was validated from the path; the auth payload may omit it.
+		if authMsg.TeamID == "" {
+			authMsg.TeamID = pathTeam
+		}
+		if authMsg.TeamID != pathTeam {
+			return &authRejection{http.StatusBadRequest, "teamId does not match the team in the URL"}
+		}
+		if hinted == nil && authMsg.TenantID != "" {
+			return &authRejection{http.StatusBadRequest, "connect to /ws/{teamId}?tenant=<tenantId> to join a tenant's team"}
+		}
+	}
+
+	tenant, err := findTenant(authMsg.TenantID)
+	if err != nil {
+		slog.Warn("Rejecting connection", "conn", client.connID, "error", err)
+		return &authRejection{http.StatusNotFound, err.Error()}
+	}
+	if hinted != nil {
+		if tenant != nil && tenant.ID != hinted.ID {
+			return &authRejection{http.StatusBadRequest, "tenantId does not match the tenant in the URL"}
+		}
+		tenant = hinted
+	}
+	if tenant != nil && authMsg.TeamID == statsTeamID {
+		return &authRejection{http.StatusBadRequest, "teamId " + statsTeamID + " is reserved"}
+	}
+	if origin := r.Header.Get("Origin"); !originAllowedForTenant(tenant, origin) {
+		slog.Warn("Origin not allowed for tenant", "conn", client.connID, "origin", origin, "tenant", tenantIDOf(tenant))
+		return &authRejection{http.StatusForbidden, "Origin not allowed"}
+	}
+
+	filter, err := compileFilter(authMsg.Filters)
+	if err != nil {
+		slog.Warn("Invalid subscription filter", "conn", client.connID, "error", err)
+		return &authRejection{http.StatusBadRequest, err.Error()}
+	}
+	client.filter = filter
+
+	digest, err := compileDigest(authMsg.Digest, AppConfig().Limits.MaxDigestMessages)
+	if err != nil {
+		slog.Warn("Invalid digest settings", "conn", client.connID, "error", err)
+		return &authRejection{http.StatusBadRequest, err.Error()}
+	}
+	client.digest = digest
+
+	caps, err := compileCapabilities(authMsg.Capabilities, client.protocol)
+	if err != nil {
+		slog.Warn("Invalid capabilities", "conn", client.connID, "error", err)
+		return &authRejection{http.StatusBadRequest, err.Error()}
+	}
+	client.caps = caps
+	if err := client.attributes.setStatus(authMsg.Status); err != nil {
+		slog.Warn("Invalid status", "conn", client.connID, "error", err)
+		return &authRejection{http.StatusBadRequest, err.Error()}
+	}
+	if caps.ack {
+		client.acks = newAckTracker()
+	}
+
+	tokenKey := tokenFailureKey(authMsg.Token)
+	if _, locked := authFailures.lockedUntil(tokenKey); locked {
+		slog.Warn("Rejecting locked-out token", "conn", client.connID, "remote_ip", clientIP)
+		return &authRejection{http.StatusTooManyRequests, "Too many failed authentication attempts"}
+	}
+
+	// Authenticate the client
+	if err := client.authenticate(*authMsg); err != nil {
+		slog.Warn("Authentication failed", "conn", client.connID, "error", err)
+		appMetrics.Count("auth.failures", 1)
+		serviceLevels.authenticated(false, time.Now())
+		if !isCredentialFailure(err) {
+			return &authRejection{http.StatusServiceUnavailable, err.Error()}
+		}
+		authFailures.recordFailure(ipFailureKey(clientIP), "ws")
+		authFailures.recordFailure(tokenKey, "ws")
+		var mismatch *teamMismatchError
+		if errors.As(err, &mismatch) {
+			abuseGuard.record(userSubject(mismatch.userID), violationTeamSpoofing)
+			return &authRejection{http.StatusForbidden, err.Error()}
+		}
+		return &authRejection{http.StatusUnauthorized, err.Error()}
+	}
+
+	authFailures.recordSuccess(tokenKey)
+	serviceLevels.authenticated(true, time.Now())
+
+	if _, banned := abuseGuard.bannedUntil(userSubject(client.userID)); banned {
+		client.logger().Warn("Rejecting banned user")
+		return &authRejection{http.StatusForbidden, "Temporarily banned"}
+	}
+
+	scopedTeam, err := scopeTeam(tenantIDOf(tenant), client.teamID)
+	if err != nil {
+		return &authRejection{http.StatusBadRequest, err.Error()}
+	}
+	client.tenantID = tenantIDOf(tenant)
+	client.teamID = scopedTeam
+
+	// Check team and tenant client limits, holding the place until registration
+	if reason := reserveClient(hub, tenant, client); reason != "" {
+		client.logger().Warn("Rejecting connection", "reason", reason)
+		return &authRejection{http.StatusServiceUnavailable, reason}
+	}
+	return nil
+}
+
 // handleWebSocket handles WebSocket connections
 func handleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -91,113 +245,256 @@ func handleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	pathTeam, err := pathTeamID(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if drainMode.Load() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Check if we can accept more clients (optional global limit)
 	totalClients := hub.getTotalClientCount()
-	maxGlobalClients := AppConfig.Limits.MaxClientsPerTeam * 100 // Rough global limit
+	maxGlobalClients := AppConfig().Limits.MaxClientsPerTeam * 100 // Rough global limit
 	if totalClients >= maxGlobalClients {
-		log.Printf("❌ Global client limit reached: %d", totalClients)
+		slog.Warn("Global client limit reached", "clients", totalClients)
 		http.Error(w, "Server at capacity", http.StatusServiceUnavailable)
 		return
 	}
 
-	// Upgrade HTTP connection to WebSocket
-	upgrader := newUpgrader()
-	conn, err := upgrader.Upgrade(w, r, nil)
+	clientIP := clientIPFromRequest(r)
+	if until, banned := abuseGuard.bannedUntil(ipSubject(clientIP)); banned {
+		slog.Warn("Rejecting connection from a banned address", "remote_ip", clientIP)
+		w.Header().Set("Retry-After", retryAfterSeconds(until))
+		http.Error(w, "Temporarily banned", http.StatusForbidden)
+		return
+	}
+	if until, locked := authFailures.lockedUntil(ipFailureKey(clientIP)); locked {
+		slog.Warn("Rejecting connection from a locked-out address", "remote_ip", clientIP)
+		w.Header().Set("Retry-After", retryAfterSeconds(until))
+		http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	// An optional ?tenant= hint lets the upgrade apply that tenant's origin policy.
+	hinted, err := findTenant(strings.TrimSpace(r.URL.Query().Get("tenant")))
 	if err != nil {
-		log.Printf("❌ Failed to upgrade connection: %v", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	// Create a new client
-	client := &Client{
-		hub:  hub,
-		conn: conn,
-		send: make(chan []byte, AppConfig.Limits.SendChannelBuffer),
+	// The frame format comes from Sec-WebSocket-Protocol; an offer with no
+	// supported protocol is refused rather than silently downgraded.
+	protocol, offered, err := negotiateProtocol(r)
+	if err != nil {
+		http.Error(w, "Unsupported websocket subprotocol; supported: "+supportedProtocolList(), http.StatusBadRequest)
+		return
 	}
 
-	// Set initial read deadline for authentication
-	conn.SetReadLimit(AppConfig.WebSocket.AuthMaxMessageSize)
-	conn.SetReadDeadline(time.Now().Add(AppConfig.WebSocket.ReadDeadline))
+	// With websocket.allow_query_token, ?token= authenticates the handshake
+	// itself and no auth frame is expected.
+	queryAuth := AppConfig().WebSocket.AllowQueryToken && r.URL.Query().Has("token")
 
-	// First message MUST be authentication
-	_, message, err := conn.ReadMessage()
-	if err != nil {
-		log.Printf("❌ Failed to read auth message: %v", err)
-		conn.Close()
+	upgrader := newUpgrader(hinted)
+	if offered {
+		upgrader.Subprotocols = []string{string(protocol)}
+	}
+	if pathTeam != "" || queryAuth {
+		if !upgrader.CheckOrigin(r) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+	}
+	if pathTeam != "" && !precheckPathTeam(hub, w, hinted, pathTeam) {
 		return
 	}
 
-	authMsg, err := decodeAuthMessage(message)
+	// Create a new client
+	client := &Client{
+		hub:      hub,
+		send:     make(chan outboundMessage, AppConfig().Limits.SendChannelBuffer),
+		control:  make(chan outboundMessage, AppConfig().Limits.ControlChannelBuffer),
+		connID:   newConnectionID(),
+		protocol: protocol,
+	}
+	registered := false
+	defer func() {
+		if !registered {
+			hub.releaseReservation(client)
+		}
+	}()
+
+	resumeToken := ""
+	if queryAuth {
+		authMsg := authMessageFromQuery(r.URL.Query())
+		if rejection := authenticateConnection(hub, r, client, authMsg, pathTeam, hinted); rejection != nil {
+			http.Error(w, rejection.message, rejection.status)
+			return
+		}
+		resumeToken = authMsg.ResumeToken
+	}
+
+	// Upgrade HTTP connection to WebSocket
+	conn, err := upgrader.Upgrade(w, r, http.Header{"X-Connection-Id": {client.connID}})
 	if err != nil {
-		log.Printf("❌ Failed to unmarshal auth message: %v", err)
-		writeWebSocketAuthError(conn, "Invalid auth payload")
-		conn.Close()
+		slog.Error("Failed to upgrade connection", "conn", client.connID, "error", err)
 		return
 	}
+	client.conn = conn
+
+	if !queryAuth {
+		// Set initial read deadline for authentication
+		conn.SetReadLimit(AppConfig().WebSocket.AuthMaxMessageSize)
+		conn.SetReadDeadline(time.Now().Add(AppConfig().WebSocket.ReadDeadline))
+
+		// First message MUST be authentication
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			slog.Warn("Failed to read auth message", "conn", client.connID, "error", err)
+			conn.Close()
+			return
+		}
 
-	if authMsg.Type != "auth" {
-		log.Printf("❌ Wrong message type: got '%s', expected 'auth'", authMsg.Type)
-		writeWebSocketAuthError(conn, "First websocket message must be auth")
-		conn.Close()
-		return
+		message, err = protocol.decodeFrame(messageType, message)
+		if err != nil {
+			slog.Warn("Failed to decode auth frame", "conn", client.connID, "error", err)
+			writeWebSocketAuthError(conn, client.protocol, "Invalid auth payload")
+			conn.Close()
+			return
+		}
+
+		authMsg, err := decodeAuthMessage(message)
+		if err != nil {
+			slog.Warn("Failed to unmarshal auth message", "conn", client.connID, "error", err)
+			writeWebSocketAuthError(conn, client.protocol, "Invalid auth payload")
+			conn.Close()
+			return
+		}
+
+		if authMsg.Type != "auth" {
+			slog.Warn("Wrong message type, expected auth", "conn", client.connID, "type", authMsg.Type)
+			writeWebSocketAuthError(conn, client.protocol, "First websocket message must be auth")
+			conn.Close()
+			return
+		}
+
+		if rejection := authenticateConnection(hub, r, client, authMsg, pathTeam, hinted); rejection != nil {
+			writeWebSocketAuthError(conn, client.protocol, rejection.message)
+			conn.Close()
+			return
+		}
+		resumeToken = authMsg.ResumeToken
 	}
 
-	// Authenticate the client
-	if err := client.authenticate(*authMsg); err != nil {
-		log.Printf("❌ Authentication failed: %v", err)
-		writeWebSocketAuthError(conn, err.Error())
+	// authSuccess goes on the send queue before the client is registered,
+	// so it precedes anything queued once a send can reach the client.
+	if err := queueAuthSuccess(r.Context(), client); err != nil {
+		client.logger().Error("Failed to queue authSuccess", "error", err)
 		conn.Close()
 		return
 	}
-
-	// Check team-specific client limits
-	if !hub.canAddClient(client.teamID) {
-		log.Printf("❌ Team client limit reached for team %s", client.teamID)
-		writeWebSocketAuthError(conn, "Team client limit reached")
+	if err := hub.RegisterAndWait(r.Context(), client); err != nil {
+		client.logger().Error("Failed to register client", "error", err)
 		conn.Close()
 		return
 	}
+	registered = true
 
-	// Register client first
-	hub.register <- client
+	startClient(hub, client, resumeToken)
 
-	// Send success response
-	_ = conn.SetWriteDeadline(time.Now().Add(AppConfig.WebSocket.WriteWait))
-	conn.WriteJSON(map[string]interface{}{
-		"type":    "authSuccess",
-		"message": "Successfully authenticated",
-	})
+	client.logger().Info("New WebSocket connection")
+}
+
+// queueAuthSuccess puts the authSuccess frame on the empty send queue of an
+// authenticated client that has not been registered yet. Every transport
+// calls it before registering, and the writePump writes the frame first.
+func queueAuthSuccess(ctx context.Context, client *Client) error {
+	authSuccess := AuthSuccess{
+		Type:         "authSuccess",
+		Message:      "Successfully authenticated",
+		Protocol:     client.protocol,
+		ConnectionID: client.connID,
+		ServerTime:   time.Now().UnixMilli(),
+		Heartbeat: HeartbeatParams{
+			PingIntervalSeconds: int(AppConfig().WebSocket.PingPeriod / time.Second),
+			PongTimeoutSeconds:  int(AppConfig().WebSocket.PongWait / time.Second),
+		},
+		Pending: offlineNotifications.count(ctx, client),
+	}
+	if conversationReads != nil {
+		unread := 0
+		for _, conversation := range conversationReads.list(client.teamID, client.userID) {
+			unread += conversation.Unread
+		}
+		authSuccess.Unread = &unread
+	}
+	if client.caps.declared {
+		view := client.caps.view()
+		authSuccess.Capabilities = &view
+	}
+	payload, err := json.Marshal(authSuccess)
+	if err != nil {
+		return err
+	}
+	client.send <- outboundMessage{payload: payload, handshake: true}
+	client.handshakeQueued = true
+	return nil
+}
 
+// startClient starts the pumps of a registered client, which write its
+// authSuccess first. Every transport shares it once the client is
+// authenticated.
+func startClient(hub *Hub, client *Client, resumeToken string) {
 	// Clear read deadline and start normal operation
-	conn.SetReadDeadline(time.Time{})
+	client.conn.SetReadDeadline(time.Time{})
+
+	appMetrics.Count("connections.opened", 1, tenantTags(client.tenantID)...)
+
+	pumpWatchdog.track(client)
+	client.lastPong.Store(time.Now().UnixNano())
 
 	// Start the client's read and write pumps
-	go client.writePump()
-	go client.readPump()
+	client.startPumps()
 
-	log.Printf("✅ New WebSocket connection: team=%s, user=%s", client.teamID, client.userID)
+	// A client migrating from another instance gets what was held for it there.
+	if resumeToken != "" && AppConfig().Handover.PeerURL != "" {
+		client.spawn("handover", func(context.Context) { resumeHandover(hub, client, resumeToken) })
+	}
+	if offlineNotifications != nil {
+		client.spawn("offline", func(ctx context.Context) { offlineNotifications.deliver(ctx, hub, client) })
+	}
 }
 
 // handleSendMessage handles the REST endpoint for sending messages
-func handleSendMessage(hub *Hub, w http.ResponseWriter, r *http.Request) {
+func handleSendMessage(hub NotificationHub, w http.ResponseWriter, r *http.Request) {
+	receivedAt := time.Now()
+
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, AppConfig.WebSocket.MaxMessageSize)
+	r.Body = http.MaxBytesReader(w, r.Body, AppConfig().WebSocket.MaxMessageSize)
 	defer r.Body.Close()
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("❌ Error reading request body: %v", err)
+		slog.Warn("Failed to read the request body", "error", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Request body exceeds the %d byte limit (websocket.max_message_size)", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Error reading request body", http.StatusBadRequest)
 		return
 	}
 
 	req, err := decodeMessageRequest(body)
 	if err != nil {
-		log.Printf("❌ Invalid JSON: %v", err)
+		slog.Warn("Invalid JSON in the request body", "error", err)
 		switch {
 		case errors.Is(err, io.EOF):
 			http.Error(w, "Request body is required", http.StatusBadRequest)
@@ -207,54 +504,257 @@ func handleSendMessage(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf(
-		"📨 send request: type=%s broadcast=%t team=%s target_user=%s body_bytes=%d",
-		req.MessageType,
-		req.Broadcast,
-		req.TargetTeamID,
-		req.TargetUserID,
-		len(req.Body),
-	)
+	tenantID, err := sendTenantID(r, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	teamID, err := scopeTeam(tenantID, req.TargetTeamID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	visibility, err := compileVisibility(req.Visibility)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	urgency := firstNonEmpty(req.Urgency, AppConfig().Urgency.Default)
+	_, onCall := onCallTeam(req.TargetUserID)
 
 	// Create the message
 	message := NewMessage(req.NotificationID, req.TargetTeamID, req.TargetUserID, req.SenderUserID, req.MessageType, req.Body, req.ActionRequired)
+	message.ReplacesID = req.ReplacesID
+	message.Channel = req.TargetChannel
+	message.GroupKey = req.GroupKey
+	if urgency != urgencyNormal {
+		message.Urgency = urgency
+	}
+	message.Attachments = attachmentsFromRequest(req.Attachments)
 	messageJSON, err := message.ToJSON()
 	if err != nil {
-		log.Printf("❌ Error encoding message: %v", err)
+		slog.Error("Failed to encode the message", "error", err)
 		http.Error(w, "Error encoding message", http.StatusInternalServerError)
 		return
 	}
+	outbound := outboundMessage{
+		payload:        messageJSON,
+		receivedAt:     receivedAt,
+		tenantID:       tenantID,
+		teamID:         teamID,
+		messageType:    req.MessageType,
+		notificationID: req.NotificationID,
+		fanout:         newFanoutCache(req.Body),
+		links:          newAttachmentLinks(attachmentPresigner, *message),
+		visibility:     visibility,
+		channel:        req.TargetChannel,
+		urgency:        urgency,
+	}
 
-	var delivered int
-	var success bool
+	if req.DryRun {
+		appMetrics.Count("send.dry_runs", 1, tenantTags(tenantID, metricTag("message_type", req.MessageType))...)
+		preview := hub.PreviewSend(req, outbound)
+		// REST responses use snake_case, so the notification is rendered in
+		// that convention rather than as the camelCase websocket frame.
+		if preview.Notification, err = marshalJSONCase(message, jsonSnakeCase); err != nil {
+			slog.Error("Failed to encode the dry-run message", "error", err)
+			http.Error(w, "Error encoding message", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, preview)
+		return
+	}
 
-	// Determine delivery method based on request parameters
-	if req.Broadcast {
-		if req.TargetTeamID != "" {
-			// Team-specific broadcast: send to all users in the specified team
-			delivered = hub.broadcastToTeam(req.TargetTeamID, messageJSON)
-			success = delivered > 0
-			log.Printf("🎯 Team broadcast to %s: %d recipients", req.TargetTeamID, delivered)
-		} else {
-			// Global broadcast: send to all users in all teams
-			delivered = hub.broadcastToAllTeams(messageJSON)
-			success = delivered > 0
-			log.Printf("🌍 Global broadcast message: %d recipients across all teams", delivered)
+	// A duplicate of an alert that opened an incident is only counted, and
+	// reaches its recipients in the incident's next rollup.
+	incidentID, suppressed := alertIncidents.observe(hub, req, message, outbound, receivedAt)
+	if suppressed {
+		notificationRecalls.recordSent(outbound, req.TargetUserID, req.Broadcast || req.TargetChannel != "" || onCall)
+		appMetrics.Count("send.requests", 1, tenantTags(tenantID, metricTag("message_type", req.MessageType))...)
+		recordSendOutcome(r, req, message, outbound, firehoseSuppressed, 0, receivedAt)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":     true,
+			"delivered":   0,
+			"suppressed":  true,
+			"incident_id": incidentID,
+		})
+		return
+	}
+
+	if req.TargetChannel == "" && !onCall && (visibility == nil || !req.Broadcast) {
+		// A team conversation is shared by every member, so it cannot hold a
+		// broadcast only some of them see, nor a channel's messages or pages.
+		conversationReads.recordSend(teamID, message, req.Broadcast)
+	}
+	// Who is on call changes, so a page is recalled from the whole team.
+	notificationRecalls.recordSent(outbound, req.TargetUserID, req.Broadcast || req.TargetChannel != "" || onCall)
+
+	// The superseded notification is dropped wherever it is still queued
+	// before its replacement is delivered.
+	var replaced bool
+	if req.ReplacesID != "" {
+		if previous, ok := notificationRecalls.supersede(tenantID, req.ReplacesID, req.NotificationID); ok {
+			replaced = true
+			hub.PurgeNotification(previous, req.ReplacesID)
+			notificationEscalations.cancel(tenantID, req.ReplacesID, receivedAt)
+			onCallSchedules.cancel(tenantID, req.ReplacesID)
+			appMetrics.Count("notifications.replaced", 1, tenantTags(tenantID)...)
 		}
-	} else {
-		// Send to a specific user. If no team is provided, deliver to all connected sessions for that user.
-		delivered = hub.sendToUser(req.TargetTeamID, req.TargetUserID, messageJSON)
-		success = delivered > 0
-		if success {
-			log.Printf("📤 Message sent to user %s in team %s (%d recipients)", req.TargetUserID, req.TargetTeamID, delivered)
+	}
+
+	route := routeSend(r.Context(), hub, req, message, outbound, receivedAt)
+	delivered, deferred, queued := route.delivered, route.deferred, route.queued
+	success := delivered > 0 || deferred || queued
+
+	// A broadcast held back by a blackout does not mention or alert anyone
+	// either. Keyword alerts are for messages to the whole team or a channel.
+	var mentioned, keywordAlerted int
+	if !deferred {
+		mentioned = teamMentions.notify(hub, message, outbound)
+		if req.Broadcast || req.TargetChannel != "" {
+			keywordAlerted = keywordAlerts.notify(hub, message, outbound)
 		}
 	}
 
+	// A critical notification to one user of a team escalates along the
+	// team's chain until someone acknowledges it.
+	var escalating bool
+	if urgency == urgencyCritical && req.TargetUserID != "" && !onCall && teamID != "" {
+		escalating = notificationEscalations.start(hub, message, outbound, receivedAt)
+	}
+
+	appMetrics.Count("send.requests", 1, tenantTags(tenantID, metricTag("message_type", req.MessageType))...)
+	appMetrics.Count("messages.delivered", int64(delivered), tenantTags(tenantID, metricTag("message_type", req.MessageType))...)
+	outcome := firehoseUnrouted
+	switch {
+	case deferred:
+		outcome = firehoseDeferred
+	case queued:
+		outcome = firehoseStored
+	case delivered > 0:
+		outcome = firehoseRouted
+	}
+	recordSendOutcome(r, req, message, outbound, outcome, delivered, receivedAt)
+
 	// Return the result
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]interface{}{
 		"success":   success,
 		"delivered": delivered,
 	}
+	if deferred {
+		response["deferred"] = true
+	}
+	if queued {
+		response["queued"] = true
+	}
+	if replaced {
+		response["replaced"] = true
+	}
+	if mentioned > 0 {
+		response["mentioned"] = mentioned
+	}
+	if keywordAlerted > 0 {
+		response["keyword_alerted"] = keywordAlerted
+	}
+	if escalating {
+		response["escalating"] = true
+	}
+	if incidentID != "" {
+		response["incident_id"] = incidentID
+	}
+	if onCall {
+		if route.onCallUsers == nil {
+			route.onCallUsers = []string{}
+		}
+		response["on_call"] = route.onCallUsers
+	}
 	json.NewEncoder(w).Encode(response)
 }
+
+// recordSendOutcome records a handled /send in the audit log, the message
+// history, the firehose and archive, and reports it to team webhooks and live
+// events.
+func recordSendOutcome(r *http.Request, req *MessageRequest, message *Message, outbound outboundMessage, outcome string, delivered int, receivedAt time.Time) {
+	auditSend(outbound.tenantID, req, delivered, outcome)
+	messageHistory.recordSend(outbound, delivered, receivedAt)
+	messageFirehose.recordSend(outbound, req.TargetUserID, outcome, delivered)
+	payloadArchive.record(r, req, outbound, outcome, delivered)
+	if outbound.teamID != "" {
+		teamWebhooks.notify(outbound.tenantID, outbound.teamID, teamWebhookEvent{
+			Event:          teamEventDelivery,
+			Type:           outcome,
+			UserID:         req.TargetUserID,
+			NotificationID: message.NotificationID,
+			MessageType:    req.MessageType,
+			Recipients:     &delivered,
+		})
+	}
+	liveEvents.publish(controlEvent{Type: "send", TeamID: outbound.teamID, UserID: req.TargetUserID, Details: map[string]string{
+		"notificationId": message.NotificationID,
+		"messageType":    req.MessageType,
+		"delivered":      strconv.Itoa(delivered),
+	}})
+}
+
+// sendRoute is the outcome of delivering a /send to its targets.
+type sendRoute struct {
+	delivered   int
+	deferred    bool     // held back by a blackout
+	queued      bool     // stored for an offline user
+	onCallUsers []string // the users paged for oncall:<team>
+}
+
+// routeSend delivers message to the targets req names: a channel, a team or
+// every team, the users on call for a team, or one user.
+func routeSend(ctx context.Context, hub NotificationHub, req *MessageRequest, message *Message, outbound outboundMessage, now time.Time) sendRoute {
+	var route sendRoute
+
+	// Determine delivery method based on request parameters
+	if req.TargetChannel != "" {
+		if teamBlackouts.deferBroadcast(outbound.teamID, outbound, now) {
+			// Released to the channel's subscribers when the blackout ends.
+			route.deferred = true
+			slog.Debug("Channel publish deferred by blackout", "team", outbound.teamID, "channel", req.TargetChannel)
+		} else {
+			route.delivered = hub.PublishToChannel(outbound.teamID, req.TargetChannel, outbound)
+			slog.Debug("Channel publish", "team", outbound.teamID, "channel", req.TargetChannel, "recipients", route.delivered)
+		}
+	} else if req.Broadcast {
+		if outbound.teamID != "" {
+			if teamBlackouts.deferBroadcast(outbound.teamID, outbound, now) {
+				// The team is in a blackout window; delivery happens when it closes.
+				route.deferred = true
+				slog.Debug("Team broadcast deferred by blackout", "team", outbound.teamID)
+			} else {
+				// Team-specific broadcast: send to all users in the specified team
+				route.delivered = hub.BroadcastToTeam(outbound.teamID, outbound)
+				slog.Debug("Team broadcast", "team", outbound.teamID, "recipients", route.delivered)
+			}
+		} else {
+			// Global broadcast: send to all users in the tenant's teams outside a blackout
+			route.delivered = hub.BroadcastToAllTeams(outbound)
+			slog.Debug("Global broadcast", "recipients", route.delivered)
+		}
+	} else if _, onCall := onCallTeam(req.TargetUserID); onCall {
+		// Page whoever is on call for the team now.
+		var stored int
+		route.onCallUsers, route.delivered, stored = onCallSchedules.page(ctx, hub, message, outbound, now)
+		route.queued = stored > 0
+		slog.Debug("On-call page", "team", outbound.teamID, "users", route.onCallUsers, "recipients", route.delivered)
+	} else {
+		// Send to a specific user. If no team is provided, deliver to all of the user's sessions in the tenant.
+		route.delivered = hub.SendToUser(outbound.teamID, req.TargetUserID, outbound)
+		if route.delivered > 0 {
+			slog.Debug("Message sent to user", "user", req.TargetUserID, "team", outbound.teamID, "recipients", route.delivered)
+		} else if !hub.UserConnected(outbound.tenantID, outbound.teamID, req.TargetUserID) && offlineNotifications.enqueue(ctx, outbound.tenantID, message, now) {
+			// Delivery happens when the user connects. A connected user
+			// whose connections all dropped the notification, for filters,
+			// visibility or do-not-disturb, does not get it on reconnect.
+			route.queued = true
+			slog.Debug("Message to offline user queued", "user", req.TargetUserID)
+		}
+	}
+	return route
+}
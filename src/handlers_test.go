@@ -3,17 +3,72 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// newWebSocketTestServer serves handleWebSocket and returns its ws:// URL.
+// Cleanup waits for every handler and client pump to return, so none
+// outlives the test.
+func newWebSocketTestServer(t *testing.T, hub *Hub) string {
+	t.Helper()
+	watchdog := newLeakWatchdog(time.Hour, 0)
+	pumpWatchdog = watchdog
+	var handlers sync.WaitGroup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		handleWebSocket(hub, w, r)
+	}))
+	t.Cleanup(func() {
+		server.CloseClientConnections()
+		server.Close()
+		handlers.Wait()
+		waitForClientPumps(t, watchdog)
+		pumpWatchdog = nil
+	})
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// waitForClientPumps waits for the read and write pumps that handleWebSocket
+// started to exit, so they cannot read the config of a later test. Tests must
+// close their sockets for the pumps to stop.
+func waitForClientPumps(t *testing.T, watchdog *leakWatchdog) {
+	t.Helper()
+	buf := make([]byte, 1<<20)
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		stacks := string(buf[:runtime.Stack(buf, true)])
+		if !strings.Contains(stacks, ").readPump(") && !strings.Contains(stacks, ").writePump(") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Error("client pumps are still running after the test")
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The pumps clear their liveness flags on the way out; loading them
+	// orders everything the pumps did before the next test's config writes.
+	watchdog.mu.Lock()
+	defer watchdog.mu.Unlock()
+	for client := range watchdog.clients {
+		if client.readPumpAlive.Load() || client.writePumpAlive.Load() {
+			t.Errorf("client %s:%s:%s pumps are still running after the test", client.teamID, client.userID, client.connID)
+		}
+	}
+}
+
 type deliveredMessage struct {
 	NotificationID string `json:"notificationId"`
 	TargetTeamID   string `json:"targetTeamId"`
@@ -109,6 +164,14 @@ func TestHandleSendMessage(t *testing.T) {
 			expectBroadcast:  false,
 			expectSendToUser: false,
 		},
+		{
+			name:             "Failure - Body Too Large",
+			requestBody:      `{"message_type": "test", "broadcast": true, "body": "` + strings.Repeat("x", 600000) + `"}`,
+			expectedStatus:   http.StatusRequestEntityTooLarge,
+			expectedBody:     `exceeds the 524288 byte limit`,
+			expectBroadcast:  false,
+			expectSendToUser: false,
+		},
 		{
 			name:             "Success - Teamless Direct Message",
 			requestBody:      `{"target_user_id": "user-1", "message_type": "user_message", "body": "hello direct"}`,
@@ -122,7 +185,7 @@ func TestHandleSendMessage(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// Need to register at least one client for the broadcast/send to succeed
-			client := &Client{teamID: "team-1", userID: "user-1", send: make(chan []byte, 1)}
+			client := &Client{teamID: "team-1", userID: "user-1", send: make(chan outboundMessage, 1)}
 			hub.clients = map[string]map[string]map[*Client]struct{}{
 				"team-1": {"user-1": {client: {}}},
 			}
@@ -152,7 +215,7 @@ func TestHandleSendMessage(t *testing.T) {
 func TestHandleSendMessage_ActionRequiredForwardedToWebSocketPayload(t *testing.T) {
 	setupTestAppConfig()
 	hub := newHub()
-	client := &Client{teamID: "team-1", userID: "user-1", send: make(chan []byte, 1)}
+	client := &Client{teamID: "team-1", userID: "user-1", send: make(chan outboundMessage, 1)}
 	hub.clients = map[string]map[string]map[*Client]struct{}{
 		"team-1": {"user-1": {client: {}}},
 	}
@@ -173,7 +236,7 @@ func TestHandleSendMessage_ActionRequiredForwardedToWebSocketPayload(t *testing.
 	select {
 	case payload := <-client.send:
 		var delivered deliveredMessage
-		if err := json.Unmarshal(payload, &delivered); err != nil {
+		if err := json.Unmarshal(payload.payload, &delivered); err != nil {
 			t.Fatalf("failed to decode delivered payload: %v", err)
 		}
 		if delivered.NotificationID != "notif-123" {
@@ -254,14 +317,14 @@ func TestHandleWebSocket(t *testing.T) {
 	// Create a test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// This mock handler simulates the real handleWebSocket but with mocked authentication.
-		upgrader := newUpgrader()
+		upgrader := newUpgrader(nil)
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			return
 		}
 
 		// Because we refactored to use the Conn interface, the real conn is fine here.
-		client := &Client{hub: hub, conn: conn, send: make(chan []byte, 1)}
+		client := &Client{hub: hub, conn: conn, send: make(chan outboundMessage, 1)}
 
 		// Read auth message
 		_, msgBytes, err := conn.ReadMessage()
@@ -277,7 +340,10 @@ func TestHandleWebSocket(t *testing.T) {
 			client.conn.Close()
 			return
 		}
-		hub.register <- client
+		if err := hub.RegisterAndWait(r.Context(), client); err != nil {
+			t.Errorf("failed to register: %v", err)
+			return
+		}
 		client.conn.WriteJSON(map[string]string{"type": "auth_success"})
 
 	}))
@@ -311,7 +377,6 @@ func TestHandleWebSocket(t *testing.T) {
 		}
 
 		// Check if client was registered in the hub
-		time.Sleep(100 * time.Millisecond) // allow time for registration
 		hub.mu.RLock()
 		defer hub.mu.RUnlock()
 		if _, ok := hub.clients["team-ws"]["user-ws"]; !ok {
@@ -354,3 +419,226 @@ func TestHandleWebSocket(t *testing.T) {
 		}
 	})
 }
+
+func TestHandleWebSocket_QueryTokenAuth(t *testing.T) {
+	setupTestAppConfig()
+	authFailures = nil
+	hub := newHub()
+	go hub.run()
+	wsURL := newWebSocketTestServer(t, hub)
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		mode       string
+		query      string
+		wantStatus int
+		wantFrame  string // first frame without sending an auth frame; "" for none
+	}{
+		{"valid token", true, "development", "?token=fake_development_token&teamId=team-q&userId=user-q", http.StatusSwitchingProtocols, "authSuccess"},
+		{"invalid token", true, "production", "?token=fake_development_token&teamId=team-q&userId=user-q", http.StatusUnauthorized, ""},
+		{"missing team", true, "development", "?token=fake_development_token&userId=user-q", http.StatusUnauthorized, ""},
+		{"disabled", false, "development", "?token=fake_development_token&teamId=team-q&userId=user-q", http.StatusSwitchingProtocols, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AppConfig().WebSocket.AllowQueryToken = tt.enabled
+			AppConfig().Environment.Mode = tt.mode
+			AppConfig().Environment.EnableFakeAuth = tt.mode == "development"
+
+			ws, resp, err := websocket.DefaultDialer.Dial(wsURL+tt.query, nil)
+			if resp == nil {
+				t.Fatalf("no handshake response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if ws == nil {
+				return
+			}
+			defer ws.Close()
+
+			if tt.wantFrame == "" {
+				// Without handshake auth the server still waits for an auth frame.
+				_ = ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				if _, _, err := ws.ReadMessage(); err == nil {
+					t.Fatal("expected no frame before an auth frame is sent")
+				}
+				return
+			}
+
+			var frame map[string]interface{}
+			_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if err := ws.ReadJSON(&frame); err != nil {
+				t.Fatalf("failed to read frame: %v", err)
+			}
+			if frame["type"] != tt.wantFrame {
+				t.Fatalf("expected %s frame, got %v", tt.wantFrame, frame)
+			}
+
+			ws.Close()
+			for deadline := time.Now().Add(2 * time.Second); hub.getTotalClientCount() > 0; {
+				if time.Now().After(deadline) {
+					t.Fatal("client was not unregistered after closing")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestHandleWebSocket_ConnectionID(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Environment.Mode = "development"
+	AppConfig().Environment.EnableFakeAuth = true
+	authFailures = nil
+	hub := newHub()
+	go hub.run()
+	wsURL := newWebSocketTestServer(t, hub)
+
+	seen := make(map[string]bool)
+	var sockets []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		ws, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		sockets = append(sockets, ws)
+
+		connID := resp.Header.Get("X-Connection-Id")
+		if connID == "" || seen[connID] {
+			t.Fatalf("expected a unique connection ID header, got %q", connID)
+		}
+		seen[connID] = true
+
+		if err := ws.WriteJSON(AuthMessage{Type: "auth", TeamID: "team-c", UserID: "user-c", Token: "fake_development_token"}); err != nil {
+			t.Fatalf("failed to send auth: %v", err)
+		}
+		var reply map[string]interface{}
+		_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := ws.ReadJSON(&reply); err != nil {
+			t.Fatalf("failed to read auth reply: %v", err)
+		}
+		if reply["type"] != "authSuccess" || reply["connectionId"] != connID {
+			t.Fatalf("expected authSuccess with connectionId %q, got %v", connID, reply)
+		}
+	}
+
+	for _, ws := range sockets {
+		ws.Close()
+	}
+	for deadline := time.Now().Add(2 * time.Second); hub.getTotalClientCount() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("clients were not unregistered after closing")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueueAuthSuccess_PrecedesFramesQueuedAfterRegistration(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	conn := newMockConn()
+	client := &Client{hub: hub, conn: conn, teamID: "team-1", userID: "alice", connID: "c1",
+		send: make(chan outboundMessage, 4), control: make(chan outboundMessage, 4)}
+	if err := queueAuthSuccess(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {client: {}}}
+
+	// A replayed notification and a control frame are queued before the
+	// pumps start. Control frames otherwise jump the send queue.
+	hub.enqueueMessage(client, queuedNotification("n1", "replayed"))
+	hub.enqueueControl(client, outboundMessage{payload: []byte(`{"type":"quotaWarning"}`)})
+	go client.writePump(context.Background())
+	defer stopWritePump(t, client)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn.mu.Lock()
+		written := append([][]byte(nil), conn.written...)
+		conn.mu.Unlock()
+		if len(written) == 3 {
+			for i, want := range []string{`"type":"authSuccess"`, `"type":"quotaWarning"`, `"n1"`} {
+				if !strings.Contains(string(written[i]), want) {
+					t.Fatalf("frame %d: expected %s, got %s", i, want, written[i])
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for 3 frames, got %d", len(written))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandleWebSocket_AuthSuccessPrecedesOfflineMessages(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Environment.Mode = "development"
+	AppConfig().Environment.EnableFakeAuth = true
+	authFailures = nil
+	offlineNotifications = newOfflineQueue(newMemoryStore(), 10, time.Hour)
+	defer func() { offlineNotifications = nil }()
+	for _, id := range []string{"n1", "n2"} {
+		offlineNotifications.enqueue(context.Background(), "", &Message{NotificationID: id, TargetUserID: "user-o", Body: id}, time.Now())
+	}
+	hub := newHub()
+	go hub.run()
+	wsURL := newWebSocketTestServer(t, hub)
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"auth","teamId":"team-o","userId":"user-o","token":"fake_development_token"}`)); err != nil {
+		t.Fatalf("failed to send auth: %v", err)
+	}
+
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var authSuccess AuthSuccess
+	if err := ws.ReadJSON(&authSuccess); err != nil || authSuccess.Type != "authSuccess" {
+		t.Fatalf("expected authSuccess first, got %+v, %v", authSuccess, err)
+	}
+	if authSuccess.Pending != 2 {
+		t.Errorf("expected authSuccess to announce 2 pending notifications, got %d", authSuccess.Pending)
+	}
+	for i, want := range []string{`"n1"`, `"n2"`} {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read notification %d: %v", i, err)
+		}
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("notification %d: expected %s, got %s", i, want, data)
+		}
+	}
+}
+
+func TestQueueAuthSuccess_SessionMetadata(t *testing.T) {
+	setupTestAppConfig()
+	conversationReads = newConversationIndex(10, 40)
+	defer func() { conversationReads = nil }()
+	conversationReads.recordSend("team-1", &Message{NotificationID: "n1", TargetTeamID: "team-1", SenderUserID: "bob", Body: "hi"}, true)
+
+	client := &Client{teamID: "team-1", userID: "alice", connID: "c1", protocol: protocolJSONv2, send: make(chan outboundMessage, 1)}
+	client.caps.declared = true
+	before := time.Now().UnixMilli()
+	if err := queueAuthSuccess(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+	var authSuccess AuthSuccess
+	if err := json.Unmarshal((<-client.send).payload, &authSuccess); err != nil {
+		t.Fatal(err)
+	}
+	if authSuccess.ConnectionID != "c1" || authSuccess.Protocol != protocolJSONv2 || authSuccess.ServerTime < before {
+		t.Fatalf("unexpected session fields: %+v", authSuccess)
+	}
+	if authSuccess.Heartbeat != (HeartbeatParams{PingIntervalSeconds: 54, PongTimeoutSeconds: 60}) {
+		t.Errorf("unexpected heartbeat: %+v", authSuccess.Heartbeat)
+	}
+	if authSuccess.Unread == nil || *authSuccess.Unread != 1 || authSuccess.Pending != 0 || authSuccess.Capabilities == nil {
+		t.Errorf("unexpected counts or capabilities: %+v", authSuccess)
+	}
+}
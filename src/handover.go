@@ -0,0 +1,389 @@
+// handover.go
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// handoverClaimTimeout bounds a new instance's request for a resumed
+// session's messages.
+const handoverClaimTimeout = 5 * time.Second
+
+var handoverClient = &http.Client{Timeout: handoverClaimTimeout}
+
+var (
+	errHandoverInProgress = errors.New("a handover is already in progress")
+	errHandoverUnknown    = errors.New("unknown or expired resume token")
+)
+
+// handoverSession is one migrating connection. Until the new instance claims
+// it, the messages its user would have received here are held for replay.
+type handoverSession struct {
+	token    string
+	tenantID string
+	teamID   string // hub key
+	userID   string
+	held     []deferredSnapshot
+}
+
+// handover moves every connected client to another instance during a
+// blue/green deploy. Clients are sent a migrate frame with the new endpoint
+// and a resume token, and from then on their messages are held until the new
+// instance claims them with the token or expiresAt passes.
+type handover struct {
+	mu        sync.Mutex
+	endpoint  string
+	startedAt time.Time
+	expiresAt time.Time
+	maxHeld   int
+	sessions  map[string]*handoverSession // by resume token
+}
+
+// HandoverMigrate tells a client to reconnect to endpoint and present
+// resumeToken in its auth message.
+type HandoverMigrate struct {
+	Type             string `json:"type"`
+	Endpoint         string `json:"endpoint"`
+	ResumeToken      string `json:"resumeToken"`
+	ExpiresInSeconds int    `json:"expiresInSeconds"`
+}
+
+// handoverStatus describes the current handover for GET /admin/handover.
+type handoverStatus struct {
+	Endpoint  string    `json:"endpoint"`
+	StartedAt time.Time `json:"startedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Pending   int       `json:"pending"` // sessions not claimed yet
+	Held      int       `json:"held"`    // messages held for them
+}
+
+func newResumeToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return newNotificationID()
+	}
+	return hex.EncodeToString(buf)
+}
+
+// active reports whether the handover still holds messages at now.
+func (ho *handover) active(now time.Time) bool {
+	return ho != nil && now.Before(ho.expiresAt)
+}
+
+// hold keeps message for every unclaimed session that match selects and
+// returns how many took it. The oldest held message is dropped once a session
+// holds maxHeld.
+func (ho *handover) hold(message outboundMessage, match func(session *handoverSession) bool) int {
+	if !ho.active(time.Now()) {
+		return 0
+	}
+
+	ho.mu.Lock()
+	defer ho.mu.Unlock()
+
+	held := 0
+	for _, session := range ho.sessions {
+		if !match(session) {
+			continue
+		}
+		session.held = append(session.held, newDeferredSnapshot(session.teamID, message))
+		if len(session.held) > ho.maxHeld {
+			session.held = session.held[1:]
+			appMetrics.Count("handover.dropped", 1, tenantTags(session.tenantID)...)
+		}
+		held++
+	}
+	return held
+}
+
+// holding counts the unclaimed sessions that match selects, which hold would
+// keep a message for. Dry runs use it to preview a send.
+func (ho *handover) holding(match func(session *handoverSession) bool) int {
+	if !ho.active(time.Now()) {
+		return 0
+	}
+
+	ho.mu.Lock()
+	defer ho.mu.Unlock()
+
+	held := 0
+	for _, session := range ho.sessions {
+		if match(session) {
+			held++
+		}
+	}
+	return held
+}
+
+// requeue puts messages that were still queued on a migrating connection when
+// it closed ahead of the ones held since.
+func (ho *handover) requeue(token string, messages []outboundMessage) {
+	if ho == nil || len(messages) == 0 {
+		return
+	}
+
+	ho.mu.Lock()
+	defer ho.mu.Unlock()
+
+	session, ok := ho.sessions[token]
+	if !ok {
+		return
+	}
+	queued := make([]deferredSnapshot, 0, len(messages)+len(session.held))
+	for _, message := range messages {
+		queued = append(queued, newDeferredSnapshot(session.teamID, message))
+	}
+	session.held = append(queued, session.held...)
+	if excess := len(session.held) - ho.maxHeld; excess > 0 {
+		session.held = session.held[excess:]
+		appMetrics.Count("handover.dropped", int64(excess), tenantTags(session.tenantID)...)
+	}
+}
+
+// claim hands over the messages held for token and forgets the session. The
+// token only resumes the connection it was issued to: the team and user the
+// new instance authenticated must match.
+func (ho *handover) claim(token, teamID, userID string, now time.Time) ([]deferredSnapshot, error) {
+	if !ho.active(now) {
+		return nil, errHandoverUnknown
+	}
+
+	ho.mu.Lock()
+	defer ho.mu.Unlock()
+
+	session, ok := ho.sessions[token]
+	if !ok || session.teamID != teamID || session.userID != userID {
+		return nil, errHandoverUnknown
+	}
+	delete(ho.sessions, token)
+	return session.held, nil
+}
+
+func (ho *handover) status() handoverStatus {
+	ho.mu.Lock()
+	defer ho.mu.Unlock()
+
+	status := handoverStatus{
+		Endpoint:  ho.endpoint,
+		StartedAt: ho.startedAt,
+		ExpiresAt: ho.expiresAt,
+		Pending:   len(ho.sessions),
+	}
+	for _, session := range ho.sessions {
+		status.Held += len(session.held)
+	}
+	return status
+}
+
+// startHandover sends every connected client a migrate frame for endpoint.
+// Their messages are held for ttl, at most maxHeld per connection, and the
+// number of migrating clients is returned.
+func (h *Hub) startHandover(endpoint string, ttl time.Duration, maxHeld int) (*handover, int, error) {
+	now := time.Now()
+	if h.handover.Load().active(now) {
+		return nil, 0, errHandoverInProgress
+	}
+
+	ho := &handover{
+		endpoint:  endpoint,
+		startedAt: now,
+		expiresAt: now.Add(ttl),
+		maxHeld:   maxHeld,
+		sessions:  make(map[string]*handoverSession),
+	}
+	clients := h.snapshotAllClients()
+	migrating := make([]*Client, 0, len(clients))
+	for _, client := range clients {
+		if client.teamID == statsTeamID {
+			continue
+		}
+		token := newResumeToken()
+		ho.sessions[token] = &handoverSession{token: token, tenantID: client.tenantID, teamID: client.teamID, userID: client.userID}
+		client.resumeToken = token
+		migrating = append(migrating, client)
+	}
+	h.handover.Store(ho)
+
+	for _, client := range migrating {
+		client.migrating.Store(true)
+		payload, err := json.Marshal(HandoverMigrate{
+			Type:             "migrate",
+			Endpoint:         endpoint,
+			ResumeToken:      client.resumeToken,
+			ExpiresInSeconds: int(ttl / time.Second),
+		})
+		if err != nil {
+			slog.Error("Failed to encode the migrate frame", "error", err)
+			continue
+		}
+		h.enqueueControl(client, outboundMessage{payload: payload})
+	}
+
+	appMetrics.Count("handover.migrated", int64(len(migrating)))
+	slog.Info("Handing clients over", "clients", len(migrating), "endpoint", endpoint, "until", ho.expiresAt)
+	return ho, len(migrating), nil
+}
+
+// holdForHandover holds message for the migrating sessions match selects.
+// Delivery counts include them, since the new instance replays the message.
+func (h *Hub) holdForHandover(message outboundMessage, match func(session *handoverSession) bool) int {
+	return h.handover.Load().hold(message, match)
+}
+
+// handingOver reports whether client has been told to migrate and its
+// messages are being held instead of queued.
+func (h *Hub) handingOver(client *Client) bool {
+	return client.migrating.Load() && h.handover.Load().active(time.Now())
+}
+
+// handoverClaim is the body of POST /admin/handover/claim.
+type handoverClaim struct {
+	Token  string `json:"token"`
+	TeamID string `json:"teamId"` // hub key
+	UserID string `json:"userId"`
+}
+
+// claimHandover asks the instance at peerURL for the messages held for token.
+func claimHandover(peerURL string, claim handoverClaim) ([]deferredSnapshot, error) {
+	body, err := json.Marshal(claim)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(peerURL, "/")+"/admin/handover/claim", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", AppConfig().Security.APIKey)
+
+	res, err := handoverClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, errHandoverUnknown
+	case res.StatusCode != http.StatusOK:
+		io.Copy(io.Discard, res.Body)
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	var claimed struct {
+		Messages []deferredSnapshot `json:"messages"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&claimed); err != nil {
+		return nil, err
+	}
+	return claimed.Messages, nil
+}
+
+// resumeHandover replays the messages held for a client that reconnected with
+// a resume token, then tells it how many it got. A client whose token cannot
+// be claimed is sent resumeFailed and should resynchronize as after any
+// reconnect.
+func resumeHandover(hub *Hub, client *Client, token string) {
+	messages, err := claimHandover(AppConfig().Handover.PeerURL, handoverClaim{Token: token, TeamID: client.teamID, UserID: client.userID})
+	if err != nil {
+		client.logger().Error("Failed to resume the handed over session", "error", err)
+		appMetrics.Count("handover.resume_failed", 1, tenantTags(client.tenantID)...)
+		payload, _ := json.Marshal(map[string]string{"type": "resumeFailed", "message": err.Error()})
+		hub.enqueueControl(client, outboundMessage{payload: payload})
+		return
+	}
+
+	replayed := 0
+	for _, saved := range messages {
+		message, err := saved.message()
+		if err != nil {
+			client.logger().Error("Skipping a handed over message", "error", err)
+			continue
+		}
+		if hub.enqueueMessage(client, message) {
+			replayed++
+		}
+	}
+	appMetrics.Count("handover.resumed", 1, tenantTags(client.tenantID)...)
+	client.logger().Info("Resumed a handed over session", "replayed", replayed)
+	payload, _ := json.Marshal(map[string]interface{}{"type": "resumed", "replayed": replayed})
+	hub.enqueueControl(client, outboundMessage{payload: payload})
+}
+
+type handoverRequest struct {
+	Endpoint string `json:"endpoint"`
+}
+
+// handleAdminHandover starts a handover with POST and reports on it with GET.
+func handleAdminHandover(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ho := hub.handover.Load()
+		if ho == nil {
+			http.Error(w, "No handover has been started", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, ho.status())
+
+	case http.MethodPost:
+		var req handoverRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		endpoint, err := url.Parse(strings.TrimSpace(req.Endpoint))
+		if err != nil || (endpoint.Scheme != "ws" && endpoint.Scheme != "wss") || endpoint.Host == "" {
+			http.Error(w, "endpoint must be an absolute ws or wss URL", http.StatusBadRequest)
+			return
+		}
+		ho, migrating, err := hub.startHandover(endpoint.String(), AppConfig().Handover.TTL, AppConfig().Handover.MaxHeld)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		recordAudit(auditEvent{Action: "handover.start", Subject: endpoint.String(), Details: map[string]string{"clients": strconv.Itoa(migrating)}})
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"migrating": migrating,
+			"expiresAt": ho.expiresAt,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminHandoverClaim is called by the new instance when a migrated
+// client reconnects there, and returns the messages held for it.
+func handleAdminHandoverClaim(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var claim handoverClaim
+	if err := decodeJSONBody(w, r, &claim); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messages, err := hub.handover.Load().claim(claim.Token, claim.TeamID, claim.UserID, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if messages == nil {
+		messages = []deferredSnapshot{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"messages": messages})
+}
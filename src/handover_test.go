@@ -0,0 +1,110 @@
+// handover_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandover_HoldsAndReplaysMessages(t *testing.T) {
+	setupTestAppConfig()
+
+	// The old instance has alice connected with one message still queued.
+	old := newHub()
+	alice := &Client{hub: old, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 10), control: make(chan outboundMessage, 10)}
+	old.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {alice: {}}}
+	old.sendToUser("team-1", "alice", outboundMessage{payload: []byte(`{"notificationId":"n1"}`), teamID: "team-1", notificationID: "n1"})
+
+	_, migrating, err := old.startHandover("wss://green.example.com/ws", time.Minute, 10)
+	if err != nil || migrating != 1 {
+		t.Fatalf("startHandover returned %d, %v", migrating, err)
+	}
+	if _, _, err := old.startHandover("wss://green.example.com/ws", time.Minute, 10); err != errHandoverInProgress {
+		t.Fatalf("expected a second handover to be refused, got %v", err)
+	}
+	var migrate HandoverMigrate
+	if err := json.Unmarshal((<-alice.control).payload, &migrate); err != nil || migrate.Type != "migrate" || migrate.Endpoint != "wss://green.example.com/ws" || migrate.ResumeToken == "" {
+		t.Fatalf("unexpected migrate frame: %+v, %v", migrate, err)
+	}
+
+	// Messages sent after the migrate frame are held rather than queued,
+	// before and after the client disconnects.
+	if count := old.broadcastToTeam("team-1", outboundMessage{payload: []byte(`{"notificationId":"n2"}`), teamID: "team-1", notificationID: "n2"}); count != 1 {
+		t.Fatalf("expected the held broadcast to count as delivered, got %d", count)
+	}
+	if len(alice.send) != 1 {
+		t.Fatalf("expected only the earlier message on the socket, got %d", len(alice.send))
+	}
+	old.removeClient(alice)
+	old.sendToUser("team-1", "alice", outboundMessage{payload: []byte(`{"notificationId":"n3"}`), teamID: "team-1", notificationID: "n3"})
+	if status := old.handover.Load().status(); status.Pending != 1 || status.Held != 3 {
+		t.Fatalf("unexpected handover status: %+v", status)
+	}
+
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != AppConfig().Security.APIKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handleAdminHandoverClaim(old, w, r)
+	}))
+	defer peer.Close()
+	AppConfig().Handover.PeerURL = peer.URL
+
+	// The new instance claims the session when alice reconnects.
+	next := newHub()
+	resumed := &Client{hub: next, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 10), control: make(chan outboundMessage, 10)}
+	resumeHandover(next, resumed, migrate.ResumeToken)
+	for _, want := range []string{"n1", "n2", "n3"} {
+		if got := (<-resumed.send).notificationID; got != want {
+			t.Fatalf("replayed %s, want %s", got, want)
+		}
+	}
+	if frame := string((<-resumed.control).payload); frame != `{"replayed":3,"type":"resumed"}` {
+		t.Fatalf("unexpected resumed frame: %s", frame)
+	}
+
+	// A token is claimed once.
+	resumeHandover(next, resumed, migrate.ResumeToken)
+	if frame := string((<-resumed.control).payload); !strings.Contains(frame, "resumeFailed") {
+		t.Fatalf("expected a second claim to fail, got %s", frame)
+	}
+}
+
+func TestHandoverClaim_RequiresMatchingUser(t *testing.T) {
+	setupTestAppConfig()
+
+	hub := newHub()
+	client := &Client{hub: hub, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 1), control: make(chan outboundMessage, 1)}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {client: {}}}
+	if _, _, err := hub.startHandover("wss://green.example.com/ws", time.Minute, 10); err != nil {
+		t.Fatalf("startHandover failed: %v", err)
+	}
+
+	claim := func(userID string) int {
+		body := `{"token":"` + client.resumeToken + `","teamId":"team-1","userId":"` + userID + `"}`
+		rec := httptest.NewRecorder()
+		handleAdminHandoverClaim(hub, rec, httptest.NewRequest(http.MethodPost, "/admin/handover/claim", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := claim("mallory"); code != http.StatusNotFound {
+		t.Fatalf("expected another user's claim to be refused, got %d", code)
+	}
+	if code := claim("alice"); code != http.StatusOK {
+		t.Fatalf("expected alice's claim to succeed, got %d", code)
+	}
+}
+
+func TestHandleAdminHandover_RejectsNonWebSocketEndpoints(t *testing.T) {
+	setupTestAppConfig()
+
+	rec := httptest.NewRecorder()
+	handleAdminHandover(newHub(), rec, httptest.NewRequest(http.MethodPost, "/admin/handover", strings.NewReader(`{"endpoint":"https://green.example.com"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,101 @@
+// health.go
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// processStartedAt is when the server started, for the uptime /healthz
+// reports.
+var processStartedAt = time.Now()
+
+// healthMemStatsMaxAge bounds how stale the memory figures in /healthz may
+// be. runtime.ReadMemStats stops the world, so probes share one reading
+// rather than each taking their own.
+const healthMemStatsMaxAge = 10 * time.Second
+
+type healthResponse struct {
+	Status       string `json:"status"`
+	Message      string `json:"message"`
+	TotalTeams   int    `json:"total_teams"`
+	TotalClients int    `json:"total_clients"`
+}
+
+type healthzResponse struct {
+	healthResponse
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Goroutines    int            `json:"goroutines"`
+	Memory        healthMemStats `json:"memory"`
+}
+
+// healthMemStats is the part of runtime.MemStats worth watching from a
+// liveness probe.
+type healthMemStats struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64 `json:"heap_inuse_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+}
+
+// memStatsCache keeps the last runtime.ReadMemStats reading for
+// healthMemStatsMaxAge.
+type memStatsCache struct {
+	mu     sync.Mutex
+	readAt time.Time
+	stats  healthMemStats
+}
+
+var healthMemory memStatsCache
+
+// read returns the cached figures, reading them again once they are older
+// than healthMemStatsMaxAge at now.
+func (c *memStatsCache) read(now time.Time) healthMemStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readAt.IsZero() || now.Sub(c.readAt) >= healthMemStatsMaxAge {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		c.stats = healthMemStats{
+			HeapAllocBytes: mem.HeapAlloc,
+			HeapInuseBytes: mem.HeapInuse,
+			SysBytes:       mem.Sys,
+			NumGC:          mem.NumGC,
+		}
+		c.readAt = now
+	}
+	return c.stats
+}
+
+// handleHealth is the original liveness check, kept for existing probes. It
+// only counts the hub's teams and clients.
+func handleHealth(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	health := hub.healthCheck()
+	writeJSON(w, http.StatusOK, healthResponse{
+		Status:       "healthy",
+		Message:      "WebSocket server is running",
+		TotalTeams:   health.TotalTeams,
+		TotalClients: health.TotalClients,
+	})
+}
+
+// handleHealthz reports that the process is alive, with its uptime,
+// goroutines and memory. It never looks at the backend, so a liveness probe
+// does not restart an instance for an outage elsewhere; /readyz does that.
+func handleHealthz(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	health := hub.healthCheck()
+	writeJSON(w, http.StatusOK, healthzResponse{
+		healthResponse: healthResponse{
+			Status:       "healthy",
+			Message:      "WebSocket server is running",
+			TotalTeams:   health.TotalTeams,
+			TotalClients: health.TotalClients,
+		},
+		UptimeSeconds: int64(now.Sub(processStartedAt).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		Memory:        healthMemory.read(now),
+	})
+}
@@ -0,0 +1,68 @@
+// health_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleHealthz(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {&Client{}: {}}, "bob": {&Client{}: {}, &Client{}: {}}}
+
+	rr := httptest.NewRecorder()
+	handleHealthz(hub, rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var health healthzResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if rr.Code != http.StatusOK || health.Status != "healthy" || health.TotalTeams != 1 || health.TotalClients != 3 {
+		t.Fatalf("unexpected health %d %+v", rr.Code, health)
+	}
+	if health.Goroutines < 1 || health.Memory.HeapAllocBytes == 0 || health.Memory.SysBytes == 0 || health.UptimeSeconds < 0 {
+		t.Fatalf("expected runtime figures, got %+v", health)
+	}
+}
+
+func TestHandleHealth_SkipsRuntimeFigures(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {&Client{}: {}}}
+
+	rr := httptest.NewRecorder()
+	handleHealth(hub, rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var health healthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if rr.Code != http.StatusOK || health.Status != "healthy" || health.TotalTeams != 1 || health.TotalClients != 1 {
+		t.Fatalf("unexpected health %d %+v", rr.Code, health)
+	}
+	if strings.Contains(rr.Body.String(), "memory") {
+		t.Fatalf("expected /health to leave out the memory figures, got %s", rr.Body.String())
+	}
+}
+
+func TestMemStatsCache_RereadsAfterMaxAge(t *testing.T) {
+	var cache memStatsCache
+	start := time.Now()
+
+	first := cache.read(start)
+	if first.SysBytes == 0 || !cache.readAt.Equal(start) {
+		t.Fatalf("expected a first reading at %v, got %+v at %v", start, first, cache.readAt)
+	}
+	cache.read(start.Add(healthMemStatsMaxAge / 2))
+	if !cache.readAt.Equal(start) {
+		t.Fatalf("expected the reading to be reused within the max age, read again at %v", cache.readAt)
+	}
+	later := start.Add(healthMemStatsMaxAge)
+	cache.read(later)
+	if !cache.readAt.Equal(later) {
+		t.Fatalf("expected a fresh reading after the max age, last read at %v", cache.readAt)
+	}
+}
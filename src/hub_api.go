@@ -0,0 +1,155 @@
+// hub_api.go
+package main
+
+import "context"
+
+// NotificationHub is what connections and the /send handler need from a
+// hub. *Hub is the in-process implementation; a clustered one can route to
+// clients on other instances behind the same methods. Methods that return a
+// count return the connections the message was queued for.
+type NotificationHub interface {
+	// Register adds an authenticated client. It returns once the hub has
+	// taken the client, which may be before its roster is updated.
+	Register(client *Client)
+	// RegisterAndWait returns once the client is in the roster, so that
+	// sends from then on reach it. It fails only if ctx ends before the hub
+	// takes the client, in which case the client was not registered.
+	RegisterAndWait(ctx context.Context, client *Client) error
+	// Unregister removes a client whose connection has ended.
+	Unregister(client *Client)
+	// UnregisterAndWait returns once the client has been removed, with the
+	// same guarantee as RegisterAndWait when ctx ends.
+	UnregisterAndWait(ctx context.Context, client *Client) error
+
+	// SendToUser delivers to userID's connections in teamID, or in every
+	// team of the message's tenant when teamID is empty.
+	SendToUser(teamID, userID string, message outboundMessage) int
+	// UserConnected reports whether userID has a connection in teamID, or
+	// in any team of tenantID when teamID is empty.
+	UserConnected(tenantID, teamID, userID string) bool
+	// BroadcastToTeam delivers to every connection in teamID.
+	BroadcastToTeam(teamID string, message outboundMessage) int
+	// BroadcastToAllTeams delivers to every connection in the message's
+	// tenant, deferring a copy for each team in a blackout window.
+	BroadcastToAllTeams(message outboundMessage) int
+	// SendControl queues a control frame, such as a response to a client
+	// request, ahead of the client's notifications.
+	SendControl(client *Client, message outboundMessage) bool
+	// SendControlToUser queues a control frame on every connection of
+	// userID in tenantID except except, which may be nil.
+	SendControlToUser(tenantID, userID string, except *Client, message outboundMessage) int
+
+	// Subscribe adds client to a channel of its team and returns the
+	// channels it is subscribed to.
+	Subscribe(client *Client, channel string) ([]string, error)
+	// Unsubscribe removes client from a channel and returns the channels it
+	// is still subscribed to.
+	Unsubscribe(client *Client, channel string) []string
+	// PublishToChannel delivers to the connections in teamID subscribed to
+	// channel.
+	PublishToChannel(teamID, channel string, message outboundMessage) int
+
+	// TeamMembers lists the users connected to teamID, which is the roster
+	// @mentions are matched against.
+	TeamMembers(teamID string) []teamMember
+
+	// PreviewSend resolves the recipients of a dry-run /send.
+	PreviewSend(req *MessageRequest, message outboundMessage) dryRunResponse
+	// PurgeNotification drops the still-queued copies of a notification that
+	// was superseded, returning how many were dropped.
+	PurgeNotification(sent sentNotification, notificationID string) int
+
+	Stats() HubHealth
+}
+
+var _ NotificationHub = (*Hub)(nil)
+
+func (h *Hub) Register(client *Client) {
+	h.register <- hubRequest{client: client}
+}
+
+func (h *Hub) RegisterAndWait(ctx context.Context, client *Client) error {
+	return submitHubRequest(ctx, h.register, client)
+}
+
+func (h *Hub) Unregister(client *Client) {
+	h.unregister <- hubRequest{client: client}
+}
+
+func (h *Hub) UnregisterAndWait(ctx context.Context, client *Client) error {
+	return submitHubRequest(ctx, h.unregister, client)
+}
+
+// submitHubRequest hands client to the run loop and waits until it has been
+// applied. Once the run loop has it, ctx no longer matters: the caller must
+// not treat a request that is about to be applied as failed.
+func submitHubRequest(ctx context.Context, requests chan<- hubRequest, client *Client) error {
+	done := make(chan struct{})
+	select {
+	case requests <- hubRequest{client: client, done: done}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-done
+	return nil
+}
+
+func (r hubRequest) ack() {
+	if r.done != nil {
+		close(r.done)
+	}
+}
+
+func (h *Hub) SendToUser(teamID, userID string, message outboundMessage) int {
+	return h.sendToUser(teamID, userID, message)
+}
+
+func (h *Hub) UserConnected(tenantID, teamID, userID string) bool {
+	return h.userConnected(tenantID, teamID, userID)
+}
+
+func (h *Hub) BroadcastToTeam(teamID string, message outboundMessage) int {
+	return h.broadcastToTeam(teamID, message)
+}
+
+// BroadcastToAllTeams defers blacked-out teams' copies as of when the
+// message was received.
+func (h *Hub) BroadcastToAllTeams(message outboundMessage) int {
+	return broadcastToAllTeamsOutsideBlackouts(h, message, message.receivedAt)
+}
+
+func (h *Hub) SendControl(client *Client, message outboundMessage) bool {
+	return h.enqueueControl(client, message)
+}
+
+func (h *Hub) SendControlToUser(tenantID, userID string, except *Client, message outboundMessage) int {
+	return h.sendControlToUser(tenantID, userID, except, message)
+}
+
+func (h *Hub) Subscribe(client *Client, channel string) ([]string, error) {
+	return h.subscribe(client, channel, AppConfig().Limits.MaxChannelsPerClient)
+}
+
+func (h *Hub) Unsubscribe(client *Client, channel string) []string {
+	return h.unsubscribe(client, channel)
+}
+
+func (h *Hub) PublishToChannel(teamID, channel string, message outboundMessage) int {
+	return h.publishToChannel(teamID, channel, message)
+}
+
+func (h *Hub) TeamMembers(teamID string) []teamMember {
+	return h.teamMembers(teamID)
+}
+
+func (h *Hub) PreviewSend(req *MessageRequest, message outboundMessage) dryRunResponse {
+	return h.previewSend(req, message, message.receivedAt)
+}
+
+func (h *Hub) PurgeNotification(sent sentNotification, notificationID string) int {
+	return h.purgeCopies(sent, notificationID, h.audienceClients(sent))
+}
+
+func (h *Hub) Stats() HubHealth {
+	return h.healthCheck()
+}
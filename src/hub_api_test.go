@@ -0,0 +1,237 @@
+// hub_api_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncHub is a synchronous NotificationHub for tests. It queues straight onto
+// the clients it holds, with no run loop, and records what it was asked to
+// do.
+type syncHub struct {
+	mu           sync.Mutex
+	clients      []*Client
+	unregistered []*Client
+	broadcasts   []string // team IDs, "" for all teams
+	controls     []outboundMessage
+}
+
+var _ NotificationHub = (*syncHub)(nil)
+
+func (h *syncHub) Register(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients = append(h.clients, client)
+}
+
+func (h *syncHub) RegisterAndWait(ctx context.Context, client *Client) error {
+	h.Register(client)
+	return nil
+}
+
+func (h *syncHub) UnregisterAndWait(ctx context.Context, client *Client) error {
+	h.Unregister(client)
+	return nil
+}
+
+func (h *syncHub) Unregister(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, registered := range h.clients {
+		if registered == client {
+			h.clients = append(h.clients[:i], h.clients[i+1:]...)
+			break
+		}
+	}
+	h.unregistered = append(h.unregistered, client)
+}
+
+func (h *syncHub) deliver(message outboundMessage, match func(client *Client) bool) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	count := 0
+	for _, client := range h.clients {
+		if match(client) && message.visibility.allows(client) {
+			client.send <- message
+			count++
+		}
+	}
+	return count
+}
+
+func (h *syncHub) SendToUser(teamID, userID string, message outboundMessage) int {
+	return h.deliver(message, func(client *Client) bool {
+		return client.userID == userID && (client.teamID == teamID || (teamID == "" && message.reaches(client)))
+	})
+}
+
+func (h *syncHub) UserConnected(tenantID, teamID, userID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, client := range h.clients {
+		if client.userID == userID && client.tenantID == tenantID && (client.teamID == teamID || teamID == "") {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *syncHub) BroadcastToTeam(teamID string, message outboundMessage) int {
+	h.broadcasts = append(h.broadcasts, teamID)
+	return h.deliver(message, func(client *Client) bool { return client.teamID == teamID })
+}
+
+func (h *syncHub) BroadcastToAllTeams(message outboundMessage) int {
+	h.broadcasts = append(h.broadcasts, "")
+	return h.deliver(message, message.reaches)
+}
+
+func (h *syncHub) SendControl(client *Client, message outboundMessage) bool {
+	h.mu.Lock()
+	h.controls = append(h.controls, message)
+	h.mu.Unlock()
+	select {
+	case client.control <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *syncHub) SendControlToUser(tenantID, userID string, except *Client, message outboundMessage) int {
+	h.mu.Lock()
+	clients := append([]*Client(nil), h.clients...)
+	h.mu.Unlock()
+	count := 0
+	for _, client := range clients {
+		if client != except && client.tenantID == tenantID && client.userID == userID && h.SendControl(client, message) {
+			count++
+		}
+	}
+	return count
+}
+
+func (h *syncHub) Subscribe(client *Client, channel string) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if client.channels == nil {
+		client.channels = make(map[string]struct{})
+	}
+	client.channels[channel] = struct{}{}
+	return subscribedChannelsLocked(client), nil
+}
+
+func (h *syncHub) Unsubscribe(client *Client, channel string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(client.channels, channel)
+	return subscribedChannelsLocked(client)
+}
+
+func (h *syncHub) PublishToChannel(teamID, channel string, message outboundMessage) int {
+	h.broadcasts = append(h.broadcasts, teamID+"#"+channel)
+	return h.deliver(message, func(client *Client) bool {
+		_, subscribed := client.channels[channel]
+		return client.teamID == teamID && subscribed
+	})
+}
+
+func (h *syncHub) TeamMembers(teamID string) []teamMember {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var members []teamMember
+	seen := make(map[string]bool)
+	for _, client := range h.clients {
+		if client.teamID == teamID && !seen[client.userID] {
+			seen[client.userID] = true
+			member := teamMember{UserID: client.userID, Email: client.email, Admin: client.teamAdmin}
+			for group := range client.attributes.groups {
+				member.Groups = append(member.Groups, group)
+			}
+			sort.Strings(member.Groups)
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+func (h *syncHub) PreviewSend(req *MessageRequest, message outboundMessage) dryRunResponse {
+	return dryRunResponse{Success: true, DryRun: true, Recipients: []dryRunRecipient{}}
+}
+
+func (h *syncHub) PurgeNotification(sent sentNotification, notificationID string) int {
+	return 0
+}
+
+func (h *syncHub) Stats() HubHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	teams := make(map[string]bool)
+	for _, client := range h.clients {
+		teams[client.teamID] = true
+	}
+	return HubHealth{TotalTeams: len(teams), TotalClients: len(h.clients)}
+}
+
+func TestHandleSendMessage_SyncHub(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	alice := &Client{teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 4)}
+	bob := &Client{teamID: "team-2", userID: "bob", send: make(chan outboundMessage, 4)}
+	hub.Register(alice)
+	hub.Register(bob)
+
+	send := func(body string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleSendMessage(hub, rec, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	if body := send(`{"target_team_id":"team-1","target_user_id":"alice","message_type":"alert","body":"hi"}`); !strings.Contains(body, `"delivered":1`) {
+		t.Fatalf("expected one delivery, got %s", body)
+	}
+	if len(alice.send) != 1 || len(bob.send) != 0 {
+		t.Fatalf("expected the message on alice's queue only, got %d and %d", len(alice.send), len(bob.send))
+	}
+	if body := send(`{"message_type":"alert","body":"everyone","broadcast":true}`); !strings.Contains(body, `"delivered":2`) {
+		t.Fatalf("expected a global broadcast to reach both clients, got %s", body)
+	}
+	if len(hub.broadcasts) != 1 || hub.broadcasts[0] != "" {
+		t.Fatalf("expected one global broadcast, got %q", hub.broadcasts)
+	}
+	if stats := hub.Stats(); stats.TotalTeams != 2 || stats.TotalClients != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestPumps_SyncHub(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	conn := newMockConn()
+	client := &Client{hub: hub, conn: conn, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 1), control: make(chan outboundMessage, 1)}
+	hub.Register(client)
+
+	conn.read <- []byte(`{"type":"request","requestId":"r1","method":"missing.method"}`)
+	conn.read <- []byte(`hello`)
+	runPumpsNow(client) // returns once the malformed frame has stopped both pumps
+	hub.mu.Lock()
+	response := string(hub.controls[0].payload)
+	hub.mu.Unlock()
+
+	if !strings.Contains(response, `"requestId":"r1"`) || !strings.Contains(response, "unknown method") {
+		t.Fatalf("expected an error response on the control queue, got %s", response)
+	}
+	if len(hub.unregistered) != 1 || hub.unregistered[0] != client || hub.Stats().TotalClients != 0 {
+		t.Fatalf("expected the supervisor to unregister the client, got %v", hub.unregistered)
+	}
+}
@@ -0,0 +1,355 @@
+// incidents.go
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of an incident notice.
+const (
+	incidentRollup   = "rollup"
+	incidentResolved = "resolved"
+)
+
+// IncidentGroupPolicy overrides the incidents settings for one group key.
+type IncidentGroupPolicy struct {
+	Disabled       bool          `yaml:"disabled"`        // Deliver every alert of the key
+	RollupInterval time.Duration `yaml:"rollup_interval"` // 0 keeps incidents.rollup_interval
+	ResolveAfter   time.Duration `yaml:"resolve_after"`   // 0 keeps incidents.resolve_after
+}
+
+// alertIncidents is nil unless incidents.enabled is set, and all methods are
+// nil-safe.
+var alertIncidents *incidentTracker
+
+type incidentKey struct {
+	tenantID string
+	teamID   string
+	groupKey string
+}
+
+// alertIncident is a group of alerts sharing a group key. The first alert is
+// delivered as usual; the group becomes an incident when a duplicate arrives
+// before it goes quiet. Duplicates are suppressed, and summed up in rollups
+// sent to the first alert's targets.
+type alertIncident struct {
+	ID         string    `json:"id"`
+	TenantID   string    `json:"tenantId,omitempty"`
+	TeamID     string    `json:"teamId"`
+	GroupKey   string    `json:"groupKey"`
+	Alerts     int       `json:"alerts"`     // including the one delivered
+	Suppressed int       `json:"suppressed"` // alerts not delivered
+	Pending    int       `json:"pending"`    // suppressed since the last rollup
+	FirstAt    time.Time `json:"firstAt"`
+	LastAt     time.Time `json:"lastAt"`
+	RolledUpAt time.Time `json:"rolledUpAt,omitempty"`
+
+	policy  IncidentGroupPolicy
+	request MessageRequest  // routes the rollups and resolution
+	first   Message         // the alert delivered
+	latest  Message         // the last alert suppressed
+	sent    outboundMessage // the first alert's delivery, for its tenant, team and type
+}
+
+// notice builds the rollup or resolution of the incident at now.
+func (g *alertIncident) notice(state string, now time.Time) (Message, outboundMessage) {
+	// A rollup repeats the latest alert, and the resolution the first.
+	message := g.latest
+	if state == incidentResolved {
+		message = g.first
+	}
+	message.NotificationID = ""
+	message.ReplacesID = ""
+	message.Timestamp = now.UnixMilli()
+	message.Incident = &IncidentNotice{
+		ID:         g.ID,
+		GroupKey:   g.GroupKey,
+		State:      state,
+		Alerts:     g.Alerts,
+		Suppressed: g.Pending,
+		FirstAt:    g.FirstAt.UnixMilli(),
+		LastAt:     g.LastAt.UnixMilli(),
+	}
+
+	sent := g.sent
+	sent.receivedAt = now
+	sent.notificationID = ""
+	sent.fanout = newFanoutCache(message.Body)
+	sent.links = newAttachmentLinks(attachmentPresigner, message)
+	return message, sent
+}
+
+// incidentTracker groups alerts by group key into incidents, and sends their
+// rollups and resolutions. Incidents live in memory and are not kept across
+// restarts.
+type incidentTracker struct {
+	messageTypes   map[string]struct{}
+	rollupInterval time.Duration
+	resolveAfter   time.Duration
+	maxOpen        int
+	groups         map[string]IncidentGroupPolicy
+
+	mu        sync.Mutex
+	incidents map[incidentKey]*alertIncident
+}
+
+func newIncidentTracker(messageTypes []string, rollupInterval, resolveAfter time.Duration, maxOpen int, groups map[string]IncidentGroupPolicy) *incidentTracker {
+	t := &incidentTracker{
+		messageTypes:   make(map[string]struct{}, len(messageTypes)),
+		rollupInterval: rollupInterval,
+		resolveAfter:   resolveAfter,
+		maxOpen:        maxOpen,
+		groups:         groups,
+		incidents:      make(map[incidentKey]*alertIncident),
+	}
+	for _, messageType := range messageTypes {
+		t.messageTypes[messageType] = struct{}{}
+	}
+	return t
+}
+
+// policy returns the settings of groupKey, with the defaults filled in.
+func (t *incidentTracker) policy(groupKey string) IncidentGroupPolicy {
+	policy := t.groups[groupKey]
+	if policy.RollupInterval == 0 {
+		policy.RollupInterval = t.rollupInterval
+	}
+	if policy.ResolveAfter == 0 {
+		policy.ResolveAfter = t.resolveAfter
+	}
+	return policy
+}
+
+// observe adds an alert sent with a group key to its group. It returns the
+// incident ID, and whether the alert is a duplicate that must not be
+// delivered. Alerts without a group key, of other message types, or of a
+// disabled key are not grouped and return an empty ID.
+func (t *incidentTracker) observe(hub NotificationHub, req *MessageRequest, message *Message, sent outboundMessage, now time.Time) (string, bool) {
+	if t == nil || req.GroupKey == "" {
+		return "", false
+	}
+	if _, ok := t.messageTypes[req.MessageType]; !ok {
+		return "", false
+	}
+	policy := t.policy(req.GroupKey)
+	if policy.Disabled {
+		return "", false
+	}
+	key := incidentKey{sent.tenantID, sent.teamID, req.GroupKey}
+
+	t.mu.Lock()
+	incident, ok := t.incidents[key]
+	if ok && now.Sub(incident.LastAt) < incident.policy.ResolveAfter {
+		incident.Alerts++
+		incident.Suppressed++
+		incident.Pending++
+		incident.LastAt = now
+		incident.latest = *message
+		id, opened := incident.ID, incident.Suppressed == 1
+		t.mu.Unlock()
+
+		if opened {
+			appMetrics.Count("incidents.opened", 1, tenantTags(sent.tenantID)...)
+			slog.Info("Incident opened, grouping alerts until it goes quiet", "incident", id, "group_key", req.GroupKey, "team", sent.teamID)
+		}
+		appMetrics.Count("incidents.suppressed", 1, tenantTags(sent.tenantID)...)
+		return id, true
+	}
+
+	// A group that went quiet before the loop noticed is resolved now, so
+	// that this alert starts a new one.
+	var resolved *alertIncident
+	if ok {
+		resolved = incident
+		delete(t.incidents, key)
+	}
+	if len(t.incidents) >= t.maxOpen {
+		t.mu.Unlock()
+		t.resolve(hub, resolved, now)
+		return "", false
+	}
+	incident = &alertIncident{
+		ID:         newNotificationID(),
+		TenantID:   sent.tenantID,
+		TeamID:     sent.teamID,
+		GroupKey:   req.GroupKey,
+		Alerts:     1,
+		FirstAt:    now,
+		LastAt:     now,
+		RolledUpAt: now,
+		policy:     policy,
+		request:    *req,
+		first:      *message,
+		sent:       sent,
+	}
+	t.incidents[key] = incident
+	t.mu.Unlock()
+
+	t.resolve(hub, resolved, now)
+	return incident.ID, false
+}
+
+// tick sends the rollups that are due, and resolves incidents that have
+// gone quiet. It returns how many notices were sent.
+func (t *incidentTracker) tick(hub NotificationHub, now time.Time) int {
+	var rollups []*alertIncident
+	var resolved []*alertIncident
+
+	t.mu.Lock()
+	for key, incident := range t.incidents {
+		switch {
+		case now.Sub(incident.LastAt) >= incident.policy.ResolveAfter:
+			delete(t.incidents, key)
+			resolved = append(resolved, incident)
+		case incident.Pending > 0 && now.Sub(incident.RolledUpAt) >= incident.policy.RollupInterval:
+			copied := *incident
+			rollups = append(rollups, &copied)
+			incident.Pending = 0
+			incident.RolledUpAt = now
+		}
+	}
+	t.mu.Unlock()
+
+	sent := 0
+	for _, incident := range rollups {
+		t.send(hub, incident, incidentRollup, now)
+		appMetrics.Count("incidents.rollups", 1, tenantTags(incident.TenantID)...)
+		sent++
+	}
+	for _, incident := range resolved {
+		if t.resolve(hub, incident, now) {
+			sent++
+		}
+	}
+	return sent
+}
+
+// resolve sends the resolution of an incident that was removed. A group
+// that never had a duplicate was not an incident and ends silently.
+func (t *incidentTracker) resolve(hub NotificationHub, incident *alertIncident, now time.Time) bool {
+	if incident == nil || incident.Suppressed == 0 {
+		return false
+	}
+	t.send(hub, incident, incidentResolved, now)
+	appMetrics.Count("incidents.resolved", 1, tenantTags(incident.TenantID)...)
+	slog.Info("Incident resolved", "incident", incident.ID, "group_key", incident.GroupKey, "team", incident.TeamID, "alerts", incident.Alerts)
+	return true
+}
+
+func (t *incidentTracker) send(hub NotificationHub, incident *alertIncident, state string, now time.Time) {
+	message, sent := incident.notice(state, now)
+	payload, err := message.ToJSON()
+	if err != nil {
+		slog.Error("Failed to encode an incident notice", "incident", incident.ID, "state", state, "error", err)
+		return
+	}
+	sent.payload = payload
+	routeSend(context.Background(), hub, &incident.request, &message, sent, now)
+}
+
+// resolveNow resolves the incident with id at once, reporting whether it
+// was open.
+func (t *incidentTracker) resolveNow(hub NotificationHub, id string, now time.Time) bool {
+	t.mu.Lock()
+	var resolved *alertIncident
+	for key, incident := range t.incidents {
+		if incident.ID == id {
+			resolved = incident
+			delete(t.incidents, key)
+			break
+		}
+	}
+	t.mu.Unlock()
+
+	if resolved == nil {
+		return false
+	}
+	t.resolve(hub, resolved, now)
+	return true
+}
+
+// list returns the open groups of one team, or of every team when teamID is
+// empty, the most recently active first. Only groups with suppressed alerts
+// are listed unless all is set.
+func (t *incidentTracker) list(teamID string, all bool) []alertIncident {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	incidents := []alertIncident{}
+	for _, incident := range t.incidents {
+		if teamID != "" && incident.TeamID != teamID {
+			continue
+		}
+		if all || incident.Suppressed > 0 {
+			incidents = append(incidents, *incident)
+		}
+	}
+	sort.Slice(incidents, func(i, j int) bool { return incidents[i].LastAt.After(incidents[j].LastAt) })
+	return incidents
+}
+
+// run sends rollups and resolutions until stop is closed.
+func (t *incidentTracker) run(hub NotificationHub, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.tick(hub, time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// groupedMessageTypes returns the message types incidents group, sorted.
+func (t *incidentTracker) groupedMessageTypes() []string {
+	types := make([]string, 0, len(t.messageTypes))
+	for messageType := range t.messageTypes {
+		types = append(types, messageType)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// handleAdminIncidents lists open incidents (GET ?teamId=, &all=true to
+// include groups with a single alert so far) and resolves one at once
+// (DELETE ?id=).
+func handleAdminIncidents(hub NotificationHub, w http.ResponseWriter, r *http.Request) {
+	if alertIncidents == nil {
+		http.Error(w, "Incident mode is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"messageTypes": alertIncidents.groupedMessageTypes(),
+			"incidents":    alertIncidents.list(strings.TrimSpace(query.Get("teamId")), query.Get("all") == "true"),
+		})
+
+	case http.MethodDelete:
+		id := strings.TrimSpace(r.URL.Query().Get("id"))
+		if id == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		if !alertIncidents.resolveNow(hub, id, time.Now()) {
+			http.Error(w, "Incident not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,161 @@
+// incidents_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func observeAlert(t *testing.T, tracker *incidentTracker, hub NotificationHub, groupKey, body string, now time.Time) (string, bool) {
+	t.Helper()
+	req := &MessageRequest{TargetTeamID: "team-1", MessageType: "system_alert", Body: body, Broadcast: true, GroupKey: groupKey}
+	message := NewMessage("", "team-1", "", "monitor", "system_alert", body, false)
+	message.GroupKey = groupKey
+	return tracker.observe(hub, req, message, outboundMessage{teamID: "team-1", messageType: "system_alert"}, now)
+}
+
+func nextIncidentNotice(t *testing.T, client *Client) Message {
+	t.Helper()
+	var message Message
+	if err := json.Unmarshal((<-client.send).payload, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Incident == nil {
+		t.Fatalf("expected an incident notice, got %+v", message)
+	}
+	return message
+}
+
+func TestIncidentTracker_RollupAndResolve(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	client := &Client{teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 4)}
+	hub.Register(client)
+	tracker := newIncidentTracker([]string{"system_alert"}, time.Minute, 5*time.Minute, 10, nil)
+	now := time.Now()
+
+	id, suppressed := observeAlert(t, tracker, hub, "db-down", "db down (1)", now)
+	if id == "" || suppressed {
+		t.Fatalf("expected the first alert to be delivered, got %q %v", id, suppressed)
+	}
+	for i, body := range []string{"db down (2)", "db down (3)"} {
+		if again, suppressed := observeAlert(t, tracker, hub, "db-down", body, now.Add(time.Duration(i+1)*time.Second)); again != id || !suppressed {
+			t.Fatalf("expected %q to be suppressed into %s, got %q %v", body, id, again, suppressed)
+		}
+	}
+
+	if sent := tracker.tick(hub, now.Add(30*time.Second)); sent != 0 {
+		t.Fatalf("expected no rollup before rollup_interval, got %d", sent)
+	}
+	if sent := tracker.tick(hub, now.Add(time.Minute)); sent != 1 {
+		t.Fatalf("expected a rollup, got %d", sent)
+	}
+	rollup := nextIncidentNotice(t, client)
+	if rollup.Body != "db down (3)" || rollup.GroupKey != "db-down" || *rollup.Incident != (IncidentNotice{ID: id, GroupKey: "db-down", State: incidentRollup, Alerts: 3, Suppressed: 2, FirstAt: now.UnixMilli(), LastAt: now.Add(2 * time.Second).UnixMilli()}) {
+		t.Fatalf("expected a rollup of the two duplicates, got %+v %+v", rollup, rollup.Incident)
+	}
+	if sent := tracker.tick(hub, now.Add(2*time.Minute)); sent != 0 {
+		t.Fatalf("expected no rollup without new alerts, got %d", sent)
+	}
+
+	if sent := tracker.tick(hub, now.Add(6*time.Minute)); sent != 1 {
+		t.Fatalf("expected the quiet incident to be resolved, got %d", sent)
+	}
+	resolution := nextIncidentNotice(t, client)
+	if resolution.Body != "db down (1)" || resolution.Incident.State != incidentResolved || resolution.Incident.Alerts != 3 {
+		t.Fatalf("expected a resolution of the incident, got %+v %+v", resolution, resolution.Incident)
+	}
+
+	// A single alert is not an incident, and ends without a resolution.
+	if _, suppressed := observeAlert(t, tracker, hub, "db-down", "db down again", now.Add(7*time.Minute)); suppressed {
+		t.Fatal("expected a new group after the incident was resolved")
+	}
+	if sent := tracker.tick(hub, now.Add(20*time.Minute)); sent != 0 || len(client.send) != 0 {
+		t.Fatalf("expected a lone alert to end silently, got %d", sent)
+	}
+}
+
+func TestIncidentTracker_Policies(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	tracker := newIncidentTracker([]string{"system_alert"}, time.Minute, 5*time.Minute, 1, map[string]IncidentGroupPolicy{
+		"noisy": {Disabled: true},
+		"slow":  {ResolveAfter: time.Hour},
+	})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if id, suppressed := observeAlert(t, tracker, hub, "noisy", "x", now); id != "" || suppressed {
+			t.Fatalf("expected a disabled group key not to be grouped, got %q %v", id, suppressed)
+		}
+	}
+	observeAlert(t, tracker, hub, "slow", "x", now)
+	if _, suppressed := observeAlert(t, tracker, hub, "slow", "x", now.Add(30*time.Minute)); !suppressed {
+		t.Fatal("expected the group's resolve_after to keep it open")
+	}
+	// max_open is 1, so another group key is delivered ungrouped.
+	if id, suppressed := observeAlert(t, tracker, hub, "other", "x", now); id != "" || suppressed {
+		t.Fatalf("expected alerts beyond max_open not to be grouped, got %q %v", id, suppressed)
+	}
+
+	req := &MessageRequest{TargetTeamID: "team-1", MessageType: "chat", Body: "x", Broadcast: true, GroupKey: "slow"}
+	if _, suppressed := tracker.observe(hub, req, NewMessage("", "team-1", "", "u", "chat", "x", false), outboundMessage{teamID: "team-1"}, now); suppressed {
+		t.Fatal("expected other message types not to be grouped")
+	}
+}
+
+func TestHandleSendMessage_Incidents(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	client := &Client{teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 4)}
+	hub.Register(client)
+	alertIncidents = newIncidentTracker([]string{"system_alert"}, time.Minute, 5*time.Minute, 10, nil)
+	defer func() { alertIncidents = nil }()
+	recentSends = newAuditRing(10)
+	defer func() { recentSends = nil }()
+	send := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		body := `{"target_team_id":"team-1","message_type":"system_alert","body":"disk full","broadcast":true,"group_key":"disk"}`
+		handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		return rr
+	}
+
+	rr := send()
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"delivered":1`) || !strings.Contains(rr.Body.String(), `"incident_id"`) {
+		t.Fatalf("expected the first alert to be delivered, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = send()
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"suppressed":true`) || len(client.send) != 1 {
+		t.Fatalf("expected the duplicate to be suppressed, got %d %s and %d frames", rr.Code, rr.Body.String(), len(client.send))
+	}
+	// The suppressed alert is still audited, so it can be replayed.
+	if sends := recentSends.chronological(); len(sends) != 2 || sends[0].Details["suppressed"] != "" || sends[1].Details["suppressed"] != "true" {
+		t.Fatalf("expected both alerts to be audited, the duplicate as suppressed, got %+v", sends)
+	}
+	var first Message
+	if err := json.Unmarshal((<-client.send).payload, &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.GroupKey != "disk" {
+		t.Fatalf("expected the alert to carry its group key, got %+v", first)
+	}
+
+	incidents := alertIncidents.list("", false)
+	if len(incidents) != 1 {
+		t.Fatalf("expected one open incident, got %+v", incidents)
+	}
+	rr = httptest.NewRecorder()
+	handleAdminIncidents(hub, rr, httptest.NewRequest(http.MethodDelete, "/admin/incidents?id="+incidents[0].ID, nil))
+	if rr.Code != http.StatusNoContent || nextIncidentNotice(t, client).Incident.State != incidentResolved {
+		t.Fatalf("expected the incident to be resolved, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handleAdminIncidents(hub, rr, httptest.NewRequest(http.MethodDelete, "/admin/incidents?id="+incidents[0].ID, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 once the incident is resolved, got %d", rr.Code)
+	}
+}
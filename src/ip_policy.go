@@ -0,0 +1,89 @@
+// ip_policy.go
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ipPolicy restricts the REST API to client addresses by CIDR range. A
+// denylist match always rejects; an empty allowlist allows everything else.
+type ipPolicy struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// restAPIPolicy guards /send and the admin endpoints. It is nil when neither
+// list is configured.
+var restAPIPolicy *ipPolicy
+
+func newIPPolicy(allowlist, denylist []string) (*ipPolicy, error) {
+	if len(allowlist) == 0 && len(denylist) == 0 {
+		return nil, nil
+	}
+
+	allow, err := parsePrefixes(allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("security.ip_allowlist: %v", err)
+	}
+	deny, err := parsePrefixes(denylist)
+	if err != nil {
+		return nil, fmt.Errorf("security.ip_denylist: %v", err)
+	}
+	return &ipPolicy{allow: allow, deny: deny}, nil
+}
+
+// parsePrefixes accepts CIDR ranges and bare addresses, which match only themselves.
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Allows reports whether ip may use the REST API. Unparseable addresses are
+// rejected whenever a policy is configured.
+func (p *ipPolicy) Allows(ip string) bool {
+	if p == nil {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range p.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(p.allow) == 0 {
+		return true
+	}
+	for _, prefix := range p.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+// json_case.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// jsonKeyCase is the naming convention of the keys in a JSON document.
+// Websocket frames use camelCase and the REST API uses snake_case.
+type jsonKeyCase int
+
+const (
+	jsonCamelCase jsonKeyCase = iota
+	jsonSnakeCase
+)
+
+// key converts a struct field's JSON name to the convention.
+func (c jsonKeyCase) key(name string) string {
+	if c == jsonSnakeCase {
+		return snakeCaseKey(name)
+	}
+	return camelCaseKey(name)
+}
+
+// snakeCaseKey turns "notificationId" or "URLExpiresAt" into
+// "notification_id" and "url_expires_at". snake_case names are unchanged.
+func snakeCaseKey(name string) string {
+	runes := []rune(name)
+	var key strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			acronymEnd := i > 0 && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || acronymEnd {
+				key.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		key.WriteRune(r)
+	}
+	return key.String()
+}
+
+// camelCaseKey turns "target_team_id" into "targetTeamId". camelCase names
+// are unchanged.
+func camelCaseKey(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// marshalJSONCase encodes v like json.Marshal, with struct field names
+// converted to keyCase, so one struct serves both the websocket and the REST
+// conventions. Map keys are data, such as team IDs, and are left alone, as
+// are values with their own MarshalJSON.
+func marshalJSONCase(v interface{}, keyCase jsonKeyCase) ([]byte, error) {
+	converted, err := caseValue(reflect.ValueOf(v), keyCase)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(converted)
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+func caseValue(v reflect.Value, keyCase jsonKeyCase) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil, nil
+		}
+		encoded, err := v.Interface().(json.Marshaler).MarshalJSON()
+		return json.RawMessage(encoded), err
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return caseValue(v.Elem(), keyCase)
+
+	case reflect.Struct:
+		object := &orderedObject{}
+		if err := object.addFields(v, keyCase); err != nil {
+			return nil, err
+		}
+		return object, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		converted := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			value, err := caseValue(iter.Value(), keyCase)
+			if err != nil {
+				return nil, err
+			}
+			converted[fmt.Sprint(iter.Key().Interface())] = value
+		}
+		return converted, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface(), nil // []byte is base64, as with json.Marshal
+		}
+		converted := make([]interface{}, v.Len())
+		for i := range converted {
+			value, err := caseValue(v.Index(i), keyCase)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = value
+		}
+		return converted, nil
+
+	default:
+		return v.Interface(), nil
+	}
+}
+
+// orderedObject is a JSON object that keeps the struct's field order.
+type orderedObject struct {
+	keys   []string
+	values []interface{}
+}
+
+// addFields adds v's exported fields as json.Marshal would, honouring json
+// tags, omitempty and embedded structs.
+func (o *orderedObject) addFields(v reflect.Value, keyCase jsonKeyCase) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		value := v.Field(i)
+
+		if field.Anonymous && name == "" {
+			embedded := value
+			if embedded.Kind() == reflect.Pointer {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := o.addFields(embedded, keyCase); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if strings.Contains(","+options+",", ",omitempty,") && isEmptyJSONValue(value) {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		converted, err := caseValue(value, keyCase)
+		if err != nil {
+			return err
+		}
+		o.keys = append(o.keys, keyCase.key(name))
+		o.values = append(o.values, converted)
+	}
+	return nil
+}
+
+// isEmptyJSONValue reports whether omitempty drops v, as in encoding/json.
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Pointer, reflect.Interface:
+		return v.IsZero()
+	}
+	return false
+}
+
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		encodedValue, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		buf.Write(encodedValue)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
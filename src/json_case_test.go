@@ -0,0 +1,71 @@
+// json_case_test.go
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCaseKeys(t *testing.T) {
+	for name, want := range map[string]string{
+		"notificationId": "notification_id",
+		"urlExpiresAt":   "url_expires_at",
+		"URLExpiresAt":   "url_expires_at",
+		"target_team_id": "target_team_id",
+		"body":           "body",
+	} {
+		if got := snakeCaseKey(name); got != want {
+			t.Errorf("snakeCaseKey(%q) = %q, want %q", name, got, want)
+		}
+	}
+	for name, want := range map[string]string{
+		"not_in":         "notIn",
+		"target_team_id": "targetTeamId",
+		"notificationId": "notificationId",
+	} {
+		if got := camelCaseKey(name); got != want {
+			t.Errorf("camelCaseKey(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestMarshalJSONCase(t *testing.T) {
+	expires := time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC)
+	message := &Message{
+		NotificationID: "n1",
+		TargetTeamID:   "team-1",
+		MessageType:    "alert",
+		Attachments:    []Attachment{{Name: "report.pdf", StorageKey: "k", URLExpiresAt: &expires}},
+	}
+
+	snake, err := marshalJSONCase(message, jsonSnakeCase)
+	if err != nil {
+		t.Fatalf("marshalJSONCase failed: %v", err)
+	}
+	want := `{"notification_id":"n1","target_team_id":"team-1","target_user_id":"","sender_user_id":"","message_type":"alert","body":"","action_required":false,"timestamp":0,` +
+		`"attachments":[{"name":"report.pdf","storage_key":"k","url_expires_at":"2025-01-10T15:00:00Z"}]}`
+	if string(snake) != want {
+		t.Fatalf("unexpected snake_case rendering:\n got %s\nwant %s", snake, want)
+	}
+
+	// Rendering back in camelCase matches the websocket frame.
+	camel, err := marshalJSONCase(message, jsonCamelCase)
+	if err != nil {
+		t.Fatalf("marshalJSONCase failed: %v", err)
+	}
+	frame, _ := message.ToJSON()
+	if string(camel) != string(frame) {
+		t.Fatalf("expected the camelCase rendering to match ToJSON:\n got %s\nwant %s", camel, frame)
+	}
+
+	// Map keys are data and keep their case.
+	encoded, err := marshalJSONCase(map[string]VisibilityRule{"team_One": {Attribute: "role", NotIn: []string{"guest"}}}, jsonCamelCase)
+	if err != nil {
+		t.Fatalf("marshalJSONCase failed: %v", err)
+	}
+	var decoded map[string]map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil || decoded["team_One"]["notIn"] == nil {
+		t.Fatalf("unexpected map rendering: %s (%v)", encoded, err)
+	}
+}
@@ -0,0 +1,381 @@
+// jwt_auth.go
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefetchInterval is how soon after one fetch a token signed with an
+// unknown key may fetch the key set again, so a flood of forged key IDs
+// cannot hammer the identity provider.
+const jwksRefetchInterval = 30 * time.Second
+
+var errInvalidJWT = errors.New("invalid JWT token provided")
+
+// tokenVerifier is nil unless backend.jwt.jwks_url is set. While it is set,
+// tokens are verified locally and the backend is not asked about them.
+var tokenVerifier *jwtVerifier
+
+// jwksCache holds the signing keys published at a JWKS URL, by key ID. Keys
+// are fetched again once refresh has passed, or sooner when a token names a
+// key the cache does not know, which is how rotated keys are picked up. A
+// failed fetch keeps the keys already held.
+type jwksCache struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+	now     func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func newJWKSCache(url string, client *http.Client, refresh time.Duration) *jwksCache {
+	return &jwksCache{url: url, client: client, refresh: refresh, now: time.Now}
+}
+
+// key returns the key with ID kid. The error is a circuit breaker failure
+// when the key set could not be fetched at all, so it is not held against
+// the token.
+func (j *jwksCache) key(kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	_, known := j.keys[kid]
+	stale := now.Sub(j.fetchedAt) >= j.refresh
+	if (stale || !known) && now.Sub(j.attemptedAt) >= jwksRefetchInterval {
+		j.attemptedAt = now
+		if err := j.fetchLocked(); err != nil {
+			appMetrics.Count("auth.jwks.failed", 1)
+			slog.Warn("Failed to fetch the JWKS", "url", redactURL(j.url), "error", err)
+			if j.keys == nil {
+				return nil, markCircuitBreakerFailure(err)
+			}
+		}
+	}
+
+	key, ok := j.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// prefetch fetches the key set now, so the first connections do not wait
+// for it. It returns how many signing keys were loaded.
+func (j *jwksCache) prefetch() (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.attemptedAt = j.now()
+	if err := j.fetchLocked(); err != nil {
+		return 0, err
+	}
+	return len(j.keys), nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j *jwksCache) fetchLocked() error {
+	res, err := j.client.Get(j.url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&set); err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			slog.Warn("Skipping a JWKS key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("the key set has no usable signing keys")
+	}
+	j.keys = keys
+	j.fetchedAt = j.now()
+	appMetrics.Count("auth.jwks.fetched", 1)
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWTInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWTInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("unsupported RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeJWTInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWTInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeJWTInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("malformed key parameter")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// jwtVerifier checks the signature and registered claims of a JWT and maps
+// its claims to the user it identifies.
+type jwtVerifier struct {
+	keys       *jwksCache
+	issuer     string
+	audience   string
+	leeway     time.Duration
+	userClaim  string
+	teamClaim  string
+	emailClaim string
+	now        func() time.Time
+}
+
+// newJWTVerifier builds a verifier from the backend.jwt section.
+func newJWTVerifier(config *Config, client *http.Client) *jwtVerifier {
+	settings := config.Backend.JWT
+	return &jwtVerifier{
+		keys:       newJWKSCache(settings.JWKSURL, client, settings.RefreshInterval),
+		issuer:     settings.Issuer,
+		audience:   settings.Audience,
+		leeway:     settings.Leeway,
+		userClaim:  settings.UserClaim,
+		teamClaim:  settings.TeamClaim,
+		emailClaim: settings.EmailClaim,
+		now:        time.Now,
+	}
+}
+
+// jwtAlgorithms maps the supported JWS algorithms to their hash.
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verify returns the user token identifies. Errors other than circuit
+// breaker failures mean the token itself was rejected.
+func (v *jwtVerifier) verify(token string) (*verifiedUser, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidJWT
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, errInvalidJWT
+	}
+	hashID, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+	key, err := v.keys.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidJWT
+	}
+	if !verifyJWTSignature(key, header.Alg, hashID, parts[0]+"."+parts[1], signature) {
+		return nil, errInvalidJWT
+	}
+
+	var claims map[string]any
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, errInvalidJWT
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	userID, ok := scalarToString(claims[v.userClaim])
+	if !ok {
+		return nil, fmt.Errorf("token has no %s claim", v.userClaim)
+	}
+	teamID, _ := scalarToString(claims[v.teamClaim])
+	email, _ := scalarToString(claims[v.emailClaim])
+	return &verifiedUser{
+		ID:             userID,
+		Email:          email,
+		SelectedTeamID: teamID,
+		TeamAdmin:      extractTeamAdmin(claims),
+		Roles:          extractStringList(claims, "roles"),
+		Groups:         extractStringList(claims, "groups"),
+	}, nil
+}
+
+func decodeJWTSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func verifyJWTSignature(key crypto.PublicKey, alg string, hashID crypto.Hash, signed string, signature []byte) bool {
+	var digest hash.Hash
+	switch hashID {
+	case crypto.SHA256:
+		digest = sha256.New()
+	case crypto.SHA384:
+		digest = sha512.New384()
+	default:
+		digest = sha512.New()
+	}
+	digest.Write([]byte(signed))
+	sum := digest.Sum(nil)
+
+	switch typed := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(typed, hashID, sum, signature) == nil
+	case *ecdsa.PublicKey:
+		size := (typed.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(typed, sum, r, s)
+	}
+	return false
+}
+
+// checkClaims enforces exp, which is required, nbf, and iss and aud when
+// they are configured.
+func (v *jwtVerifier) checkClaims(claims map[string]any) error {
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.leeway)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return errors.New("token has the wrong issuer")
+		}
+	}
+	if v.audience != "" && !jwtAudienceIncludes(claims["aud"], v.audience) {
+		return errors.New("token is not for this audience")
+	}
+	return nil
+}
+
+func jwtAudienceIncludes(aud any, audience string) bool {
+	switch typed := aud.(type) {
+	case string:
+		return typed == audience
+	case []any:
+		for _, item := range typed {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authenticateJWT verifies token locally and signs the client in as the
+// user it names, if the token selects teamID.
+func (c *Client) authenticateJWT(verifier *jwtVerifier, token, teamID string) error {
+	user, err := verifier.verify(token)
+	if err != nil {
+		return err
+	}
+	if user.SelectedTeamID == "" {
+		return fmt.Errorf("token has no %s claim", verifier.teamClaim)
+	}
+	if user.SelectedTeamID != teamID {
+		return &teamMismatchError{userID: user.ID, requested: teamID, selected: user.SelectedTeamID}
+	}
+
+	c.userID = user.ID
+	c.email = user.Email
+	c.teamID = teamID
+	c.teamAdmin = user.TeamAdmin
+	c.attributes.setIdentity(user.Roles, user.Groups, user.TeamAdmin)
+	c.isAuthenticated = true
+	appMetrics.Count("auth.jwt", 1)
+	slog.Debug("Client authenticated with a JWT", "user", user.ID, "team", teamID)
+	return nil
+}
@@ -0,0 +1,222 @@
+// jwt_auth_test.go
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, key crypto.Signer, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch typed := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, typed, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, typed, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testJWK(kid string, key crypto.PublicKey) map[string]string {
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	switch typed := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": encode(typed.N), "e": encode(big.NewInt(int64(typed.E)))}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": encode(typed.X), "y": encode(typed.Y)}
+	}
+	return nil
+}
+
+func TestJWTVerifier(t *testing.T) {
+	setupTestAppConfig()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches atomic.Int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []any{
+			testJWK("rsa-1", &rsaKey.PublicKey),
+			testJWK("ec-1", &ecKey.PublicKey),
+			map[string]string{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		}})
+	}))
+	defer jwks.Close()
+
+	AppConfig().Backend.JWT.JWKSURL = jwks.URL
+	AppConfig().Backend.JWT.Issuer = "https://id.example.com"
+	AppConfig().Backend.JWT.Audience = "notifications"
+	verifier := newJWTVerifier(AppConfig(), jwks.Client())
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]any) map[string]any {
+		base := map[string]any{
+			"sub":          "user-1",
+			"email":        "user-1@example.com",
+			"selectedTeam": "team-1",
+			"roles":        []string{"ops"},
+			"iss":          "https://id.example.com",
+			"aud":          []string{"other", "notifications"},
+			"exp":          now.Add(time.Minute).Unix(),
+		}
+		for key, value := range overrides {
+			if value == nil {
+				delete(base, key)
+			} else {
+				base[key] = value
+			}
+		}
+		return base
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"RSA key", signTestJWT(t, rsaKey, "RS256", "rsa-1", claims(nil)), ""},
+		{"EC key", signTestJWT(t, ecKey, "ES256", "ec-1", claims(nil)), ""},
+		{"expired within leeway", signTestJWT(t, rsaKey, "RS256", "rsa-1", claims(map[string]any{"exp": now.Add(-10 * time.Second).Unix()})), ""},
+		{"expired", signTestJWT(t, rsaKey, "RS256", "rsa-1", claims(map[string]any{"exp": now.Add(-time.Minute).Unix()})), "token has expired"},
+		{"no exp", signTestJWT(t, rsaKey, "RS256", "rsa-1", claims(map[string]any{"exp": nil})), "token has no exp claim"},
+		{"not yet valid", signTestJWT(t, rsaKey, "RS256", "rsa-1", claims(map[string]any{"nbf": now.Add(time.Minute).Unix()})), "token is not valid yet"},
+		{"wrong issuer", signTestJWT(t, rsaKey, "RS256", "rsa-1", claims(map[string]any{"iss": "https://evil.example.com"})), "token has the wrong issuer"},
+		{"wrong audience", signTestJWT(t, rsaKey, "RS256", "rsa-1", claims(map[string]any{"aud": "other"})), "token is not for this audience"},
+		{"algorithm of the other key type", signTestJWT(t, rsaKey, "ES256", "rsa-1", claims(nil)), errInvalidJWT.Error()},
+		{"signed by another key", signTestJWT(t, ecKey, "RS256", "rsa-1", claims(nil)), errInvalidJWT.Error()},
+		{"encryption key", signTestJWT(t, rsaKey, "RS256", "enc-1", claims(nil)), `unknown signing key "enc-1"`},
+		{"unsigned", "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ1c2VyLTEifQ.", `unsupported JWT algorithm "none"`},
+		{"malformed", "not-a-jwt", errInvalidJWT.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := verifier.verify(tt.token)
+			if tt.want != "" {
+				if err == nil || err.Error() != tt.want {
+					t.Fatalf("expected %q, got %v", tt.want, err)
+				}
+				if !isCredentialFailure(err) {
+					t.Errorf("expected a rejected token to count as a credential failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != "user-1" || user.Email != "user-1@example.com" || user.SelectedTeamID != "team-1" || len(user.Roles) != 1 {
+				t.Fatalf("unexpected user %+v", user)
+			}
+		})
+	}
+	// The unknown key did not fetch the set again within the refetch
+	// interval.
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("expected 1 JWKS fetch, got %d", got)
+	}
+}
+
+func TestJWKSCache_RotatesAndSurvivesOutages(t *testing.T) {
+	setupTestAppConfig()
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var published atomic.Value
+	published.Store(map[string]any{"keys": []any{testJWK("old", &oldKey.PublicKey)}})
+	var down atomic.Bool
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(published.Load())
+	}))
+	defer jwks.Close()
+
+	now := time.Now()
+	cache := newJWKSCache(jwks.URL, jwks.Client(), time.Hour)
+	cache.now = func() time.Time { return now }
+	if _, err := cache.key("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token signed with a rotated-in key fetches the set again.
+	published.Store(map[string]any{"keys": []any{testJWK("new", &newKey.PublicKey)}})
+	now = now.Add(jwksRefetchInterval)
+	if _, err := cache.key("new"); err != nil {
+		t.Fatalf("expected the rotated key to be fetched, got %v", err)
+	}
+
+	// Stale keys are still used while the JWKS cannot be fetched.
+	down.Store(true)
+	now = now.Add(2 * time.Hour)
+	if _, err := cache.key("new"); err != nil {
+		t.Fatalf("expected the held keys to be used, got %v", err)
+	}
+
+	// With no keys at all, the outage is not the token's fault.
+	empty := newJWKSCache(jwks.URL, jwks.Client(), time.Hour)
+	if _, err := empty.key("new"); err == nil || isCredentialFailure(err) {
+		t.Fatalf("expected a backend failure, got %v", err)
+	}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	setupTestAppConfig()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []any{testJWK("k1", &key.PublicKey)}})
+	}))
+	defer jwks.Close()
+	AppConfig().Backend.JWT.JWKSURL = jwks.URL
+	verifier := newJWTVerifier(AppConfig(), jwks.Client())
+
+	token := signTestJWT(t, key, "ES256", "k1", map[string]any{
+		"sub": 42, "email": "ada@example.com", "selectedTeam": "team-1", "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	client := &Client{}
+	if err := client.authenticateJWT(verifier, token, "team-1"); err != nil {
+		t.Fatal(err)
+	}
+	if !client.isAuthenticated || client.userID != "42" || client.email != "ada@example.com" || client.teamID != "team-1" {
+		t.Fatalf("unexpected client %+v", client)
+	}
+
+	var mismatch *teamMismatchError
+	if err := (&Client{}).authenticateJWT(verifier, token, "team-2"); !errors.As(err, &mismatch) {
+		t.Fatalf("expected a team mismatch, got %v", err)
+	}
+}
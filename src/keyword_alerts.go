@@ -0,0 +1,291 @@
+// keyword_alerts.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// keywordAlertMessageType is the type of the notification sent to a user
+// whose keyword a team message contains.
+const keywordAlertMessageType = "keywordAlert"
+
+// keywordWatchesPreference is the preference holding a user's keywords, as a
+// JSON array of strings.
+const keywordWatchesPreference = "keywordWatches"
+
+// keywordAlerts is nil unless keyword_alerts.enabled is set, and all methods
+// are nil-safe.
+var keywordAlerts *keywordWatcher
+
+// keywordWatcher alerts users when a team message contains one of the
+// keywords in their preferences. Each connection's keywords are loaded with
+// its preferences snapshot, and each team shares one matcher over the
+// keywords of its connections, rebuilt when they change.
+type keywordWatcher struct {
+	maxPerUser int
+	maxLength  int
+
+	mu    sync.Mutex
+	teams map[string]*teamKeywords
+}
+
+type teamKeywords struct {
+	watches map[*Client][]string // every loaded connection, with or without keywords
+	// matcher and owners are nil until a message is matched after watches
+	// changed. owners holds the users watching each of the matcher's
+	// patterns.
+	matcher  *keywordMatcher
+	patterns []string
+	owners   []map[string]struct{}
+}
+
+func newKeywordWatcher(maxPerUser, maxLength int) *keywordWatcher {
+	return &keywordWatcher{maxPerUser: maxPerUser, maxLength: maxLength, teams: make(map[string]*teamKeywords)}
+}
+
+// parse reads a keywordWatches preference value. Keywords are matched
+// without regard to case, so they are kept lower-cased and without
+// duplicates. A null or missing value watches nothing.
+func (w *keywordWatcher) parse(value json.RawMessage) ([]string, error) {
+	if len(value) == 0 || string(value) == "null" {
+		return nil, nil
+	}
+	var raw []string
+	if err := json.Unmarshal(value, &raw); err != nil {
+		return nil, errors.New(keywordWatchesPreference + " must be an array of strings")
+	}
+	var keywords []string
+	seen := make(map[string]struct{}, len(raw))
+	for _, keyword := range raw {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword == "" || utf8.RuneCountInString(keyword) > w.maxLength {
+			return nil, fmt.Errorf("%s entries must be 1 to %d characters", keywordWatchesPreference, w.maxLength)
+		}
+		if _, dup := seen[keyword]; dup {
+			continue
+		}
+		seen[keyword] = struct{}{}
+		keywords = append(keywords, keyword)
+	}
+	if len(keywords) > w.maxPerUser {
+		return nil, fmt.Errorf("at most %d %s are kept per user", w.maxPerUser, keywordWatchesPreference)
+	}
+	return keywords, nil
+}
+
+// watch records client's keywords from its preferences snapshot. Clients
+// without keywords are recorded too, so that keywords they set later apply
+// to them. A client that has already been unregistered is ignored, so it is
+// not left behind.
+func (w *keywordWatcher) watch(client *Client, preferences map[string]json.RawMessage) {
+	if w == nil || client.teamID == statsTeamID {
+		return
+	}
+	keywords, err := w.parse(preferences[keywordWatchesPreference])
+	if err != nil {
+		client.logger().Warn("Ignoring keyword watches", "error", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if client.unregisteredAt.Load() != 0 {
+		return
+	}
+	team := w.teams[client.teamID]
+	if team == nil {
+		team = &teamKeywords{watches: make(map[*Client][]string)}
+		w.teams[client.teamID] = team
+	}
+	team.watches[client] = keywords
+	team.matcher = nil
+}
+
+// update applies a user's changed keywords to all of their connections in
+// tenantID.
+func (w *keywordWatcher) update(tenantID, userID string, keywords []string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, team := range w.teams {
+		for client := range team.watches {
+			if client.tenantID == tenantID && client.userID == userID {
+				team.watches[client] = keywords
+				team.matcher = nil
+			}
+		}
+	}
+}
+
+// forget drops a connection that has left the hub.
+func (w *keywordWatcher) forget(client *Client) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	team := w.teams[client.teamID]
+	if team == nil {
+		return
+	}
+	if _, ok := team.watches[client]; !ok {
+		return
+	}
+	delete(team.watches, client)
+	team.matcher = nil
+	if len(team.watches) == 0 {
+		delete(w.teams, client.teamID)
+	}
+}
+
+// match returns the users of teamID watching a keyword body contains, each
+// with the keywords it contains, sorted. A keyword matches whole words only:
+// "go" matches "Go live!" but not "good".
+func (w *keywordWatcher) match(teamID, body string) map[string][]string {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	team := w.teams[teamID]
+	if team == nil {
+		w.mu.Unlock()
+		return nil
+	}
+	if team.matcher == nil {
+		team.build()
+	}
+	matcher, patterns, owners := team.matcher, team.patterns, team.owners
+	w.mu.Unlock()
+
+	text := strings.ToLower(body)
+	hits := make(map[int]struct{})
+	matcher.match(text, func(pattern, end int) {
+		if isWordBoundary(text, end-len(patterns[pattern]), end) {
+			hits[pattern] = struct{}{}
+		}
+	})
+	if len(hits) == 0 {
+		return nil
+	}
+	matched := make(map[string][]string)
+	for pattern := range hits {
+		for userID := range owners[pattern] {
+			matched[userID] = append(matched[userID], patterns[pattern])
+		}
+	}
+	for _, keywords := range matched {
+		sort.Strings(keywords)
+	}
+	return matched
+}
+
+// build compiles the team's current keywords into a matcher.
+func (t *teamKeywords) build() {
+	index := make(map[string]int)
+	t.patterns, t.owners = nil, nil
+	for client, keywords := range t.watches {
+		for _, keyword := range keywords {
+			i, ok := index[keyword]
+			if !ok {
+				i = len(t.patterns)
+				index[keyword] = i
+				t.patterns = append(t.patterns, keyword)
+				t.owners = append(t.owners, make(map[string]struct{}))
+			}
+			t.owners[i][client.userID] = struct{}{}
+		}
+	}
+	t.matcher = newKeywordMatcher(t.patterns)
+}
+
+// isWordBoundary reports whether text[start:end] is not part of a longer
+// word.
+func isWordBoundary(text string, start, end int) bool {
+	if start > 0 {
+		if r, _ := utf8.DecodeLastRuneInString(text[:start]); isWordRune(r) {
+			return false
+		}
+	}
+	if end < len(text) {
+		if r, _ := utf8.DecodeRuneInString(text[end:]); isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// keywordAlertBody is the body of a keyword alert. It carries the message
+// that matched, so the client can show it without a lookup.
+type keywordAlertBody struct {
+	NotificationID string   `json:"notificationId"`
+	MessageType    string   `json:"messageType"`
+	Body           string   `json:"body"`
+	Keywords       []string `json:"keywords"`
+	Channel        string   `json:"channel,omitempty"`
+}
+
+// notify sends a keywordAlert to every user of the message's team watching a
+// keyword its body contains, other than the sender. Members the message's
+// visibility rules hide it from are not alerted. It returns how many users
+// were alerted.
+func (w *keywordWatcher) notify(hub NotificationHub, message *Message, sent outboundMessage) int {
+	if w == nil || sent.teamID == "" {
+		return 0
+	}
+	matched := w.match(sent.teamID, message.Body)
+	userIDs := make([]string, 0, len(matched))
+	for userID := range matched {
+		if userID != message.SenderUserID {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Strings(userIDs)
+
+	alerted := 0
+	for _, userID := range userIDs {
+		body, err := json.Marshal(keywordAlertBody{
+			NotificationID: message.NotificationID,
+			MessageType:    message.MessageType,
+			Body:           message.Body,
+			Keywords:       matched[userID],
+			Channel:        message.Channel,
+		})
+		if err != nil {
+			continue
+		}
+		alert := NewMessage(newNotificationID(), message.TargetTeamID, userID, message.SenderUserID, keywordAlertMessageType, string(body), false)
+		payload, err := alert.ToJSON()
+		if err != nil {
+			continue
+		}
+		delivered := hub.SendToUser(sent.teamID, userID, outboundMessage{
+			payload:        payload,
+			receivedAt:     sent.receivedAt,
+			tenantID:       sent.tenantID,
+			teamID:         sent.teamID,
+			messageType:    keywordAlertMessageType,
+			notificationID: alert.NotificationID,
+			visibility:     sent.visibility,
+		})
+		if delivered > 0 {
+			alerted++
+		}
+	}
+	if alerted > 0 {
+		appMetrics.Count("keywords.alerts", int64(alerted), tenantTags(sent.tenantID)...)
+	}
+	return alerted
+}
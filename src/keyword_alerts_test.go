@@ -0,0 +1,109 @@
+// keyword_alerts_test.go
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestKeywordWatcher_Parse(t *testing.T) {
+	watcher := newKeywordWatcher(2, 8)
+	keywords, err := watcher.parse(json.RawMessage(`[" Outage ", "outage", "deploy"]`))
+	if err != nil || !reflect.DeepEqual(keywords, []string{"outage", "deploy"}) {
+		t.Fatalf("unexpected keywords %v, %v", keywords, err)
+	}
+	if keywords, err := watcher.parse(nil); err != nil || keywords != nil {
+		t.Fatalf("expected no keywords, got %v, %v", keywords, err)
+	}
+	for _, value := range []string{`"outage"`, `[""]`, `["much too long"]`, `["a", "b", "c"]`} {
+		if _, err := watcher.parse(json.RawMessage(value)); err == nil {
+			t.Errorf("expected %s to be rejected", value)
+		}
+	}
+}
+
+func TestKeywordWatcher_Match(t *testing.T) {
+	watcher := newKeywordWatcher(10, 64)
+	alice := &Client{teamID: "team-1", userID: "alice"}
+	bob := &Client{teamID: "team-1", userID: "bob"}
+	carol := &Client{teamID: "team-2", userID: "carol"}
+	watcher.watch(alice, map[string]json.RawMessage{keywordWatchesPreference: json.RawMessage(`["go", "outage"]`)})
+	watcher.watch(bob, map[string]json.RawMessage{keywordWatchesPreference: json.RawMessage(`["outage", "café"]`)})
+	watcher.watch(carol, map[string]json.RawMessage{keywordWatchesPreference: json.RawMessage(`["outage"]`)})
+
+	tests := []struct {
+		body string
+		want map[string][]string
+	}{
+		{"OUTAGE in eu-west, go!", map[string][]string{"alice": {"go", "outage"}, "bob": {"outage"}}},
+		{"good news: outages are over", nil},
+		{"meet at the Café", map[string][]string{"bob": {"café"}}},
+		{"nothing here", nil},
+	}
+	for _, tt := range tests {
+		if got := watcher.match("team-1", tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("match(%q) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+
+	watcher.update("", "bob", []string{"deploy"})
+	if got := watcher.match("team-1", "outage during deploy"); !reflect.DeepEqual(got, map[string][]string{"alice": {"outage"}, "bob": {"deploy"}}) {
+		t.Fatalf("expected bob's new keywords to apply, got %v", got)
+	}
+	watcher.forget(alice)
+	watcher.forget(bob)
+	if len(watcher.teams) != 1 {
+		t.Fatalf("expected only team-2 to be left, got %v", watcher.teams)
+	}
+
+	// A connection that left before its preferences were loaded is not
+	// recorded.
+	gone := &Client{teamID: "team-1", userID: "dave"}
+	gone.unregisteredAt.Store(1)
+	watcher.watch(gone, map[string]json.RawMessage{keywordWatchesPreference: json.RawMessage(`["outage"]`)})
+	if watcher.match("team-1", "outage") != nil {
+		t.Fatal("expected the departed connection to be ignored")
+	}
+}
+
+func TestKeywordWatcher_Notify(t *testing.T) {
+	setupTestAppConfig()
+	watcher := newKeywordWatcher(10, 64)
+	hub := &syncHub{}
+	newMember := func(userID, keywords string) *Client {
+		c := &Client{teamID: "team-1", userID: userID, send: make(chan outboundMessage, 4)}
+		hub.Register(c)
+		watcher.watch(c, map[string]json.RawMessage{keywordWatchesPreference: json.RawMessage(keywords)})
+		return c
+	}
+	alice := newMember("alice", `["outage"]`)
+	bob := newMember("bob", `["outage"]`)
+	carol := newMember("carol", `[]`)
+
+	message := NewMessage("msg-1", "team-1", "", "alice", "userMessage", "Outage in progress", false)
+	message.Channel = "ops"
+	if alerted := watcher.notify(hub, message, outboundMessage{teamID: "team-1"}); alerted != 1 {
+		t.Fatalf("expected bob alone to be alerted, got %d", alerted)
+	}
+	if len(alice.send) != 0 || len(bob.send) != 1 || len(carol.send) != 0 {
+		t.Fatalf("expected an alert for bob only, got %d, %d and %d", len(alice.send), len(bob.send), len(carol.send))
+	}
+	var alert Message
+	if err := json.Unmarshal((<-bob.send).payload, &alert); err != nil {
+		t.Fatal(err)
+	}
+	var body keywordAlertBody
+	if err := json.Unmarshal([]byte(alert.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if alert.MessageType != keywordAlertMessageType || alert.TargetUserID != "bob" || alert.SenderUserID != "alice" ||
+		!reflect.DeepEqual(body.Keywords, []string{"outage"}) || body.Body != "Outage in progress" || body.NotificationID != "msg-1" || body.Channel != "ops" {
+		t.Fatalf("unexpected alert %+v with body %+v", alert, body)
+	}
+
+	var disabled *keywordWatcher
+	if alerted := disabled.notify(hub, message, outboundMessage{teamID: "team-1"}); alerted != 0 {
+		t.Fatalf("expected no alerts when disabled, got %d", alerted)
+	}
+}
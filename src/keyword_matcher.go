@@ -0,0 +1,86 @@
+// keyword_matcher.go
+package main
+
+// keywordMatcher finds any of a set of patterns in a text in one pass, with
+// an Aho-Corasick automaton over the patterns' bytes. It is built once and
+// safe for concurrent use.
+type keywordMatcher struct {
+	next    []map[byte]int32
+	fail    []int32
+	outputs [][]int // patterns ending at each state, including through fail links
+	lengths []int
+}
+
+func newKeywordMatcher(patterns []string) *keywordMatcher {
+	m := &keywordMatcher{
+		next:    []map[byte]int32{{}},
+		fail:    []int32{0},
+		outputs: [][]int{nil},
+		lengths: make([]int, len(patterns)),
+	}
+	for i, pattern := range patterns {
+		m.lengths[i] = len(pattern)
+		if pattern == "" {
+			continue
+		}
+		state := int32(0)
+		for j := 0; j < len(pattern); j++ {
+			child, ok := m.next[state][pattern[j]]
+			if !ok {
+				child = int32(len(m.next))
+				m.next = append(m.next, map[byte]int32{})
+				m.fail = append(m.fail, 0)
+				m.outputs = append(m.outputs, nil)
+				m.next[state][pattern[j]] = child
+			}
+			state = child
+		}
+		m.outputs[state] = append(m.outputs[state], i)
+	}
+
+	// Breadth first, so each state's fail link is final before its
+	// children's are worked out from it.
+	queue := make([]int32, 0, len(m.next))
+	for _, child := range m.next[0] {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for b, child := range m.next[state] {
+			fail := m.fail[state]
+			for fail != 0 {
+				if _, ok := m.next[fail][b]; ok {
+					break
+				}
+				fail = m.fail[fail]
+			}
+			if target, ok := m.next[fail][b]; ok && target != child {
+				m.fail[child] = target
+			}
+			m.outputs[child] = append(m.outputs[child], m.outputs[m.fail[child]]...)
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+// match calls found with the index of each pattern that occurs in text and
+// the byte offset just past the occurrence, in the order the occurrences end.
+func (m *keywordMatcher) match(text string, found func(pattern, end int)) {
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		for state != 0 {
+			if _, ok := m.next[state][text[i]]; ok {
+				break
+			}
+			state = m.fail[state]
+		}
+		if child, ok := m.next[state][text[i]]; ok {
+			state = child
+		}
+		for _, pattern := range m.outputs[state] {
+			found(pattern, i+1)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// keyword_matcher_test.go
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestKeywordMatcher(t *testing.T) {
+	patterns := []string{"he", "she", "his", "hers", "ushers", "é"}
+	text := "ushers say hé"
+	var found []string
+	newKeywordMatcher(patterns).match(text, func(pattern, end int) {
+		found = append(found, text[end-len(patterns[pattern]):end])
+	})
+	want := []string{"she", "he", "hers", "ushers", "é"}
+	sort.Strings(found)
+	sort.Strings(want)
+	if !reflect.DeepEqual(found, want) {
+		t.Fatalf("expected %v, got %v", want, found)
+	}
+
+	// Every occurrence a naive search finds is found, in a text that makes
+	// the automaton follow many fail links.
+	patterns = []string{"a", "aa", "aab", "ab", "b", "bab"}
+	text = strings.Repeat("aab", 20) + strings.Repeat("ba", 20)
+	counts := make([]int, len(patterns))
+	newKeywordMatcher(patterns).match(text, func(pattern, end int) { counts[pattern]++ })
+	for i, pattern := range patterns {
+		naive := 0
+		for start := 0; start+len(pattern) <= len(text); start++ {
+			if text[start:start+len(pattern)] == pattern {
+				naive++
+			}
+		}
+		if counts[i] != naive {
+			t.Errorf("expected %q %d times, found %d", pattern, naive, counts[i])
+		}
+	}
+
+	newKeywordMatcher(nil).match("anything", func(int, int) { t.Fatal("expected no matches without patterns") })
+}
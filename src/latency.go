@@ -0,0 +1,182 @@
+// latency.go
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBucketBounds are the histogram upper bounds in milliseconds.
+var latencyBucketBounds = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// maxLatencySeries caps how many distinct teams or message types are tracked
+// individually; anything beyond it is folded into the "other" series.
+const maxLatencySeries = 1000
+
+const otherLatencySeries = "other"
+
+// latencyHistogram is a fixed-bucket histogram of delivery latencies.
+type latencyHistogram struct {
+	counts []uint64 // len(latencyBucketBounds)+1, last bucket is +Inf
+	total  uint64
+	sumMs  float64
+	maxMs  float64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]uint64, len(latencyBucketBounds)+1)}
+}
+
+func (h *latencyHistogram) observe(ms float64) {
+	idx := sort.SearchFloat64s(latencyBucketBounds, ms)
+	h.counts[idx]++
+	h.total++
+	h.sumMs += ms
+	if ms > h.maxMs {
+		h.maxMs = ms
+	}
+}
+
+// quantile estimates the q-th quantile by interpolating linearly inside the
+// bucket that contains it.
+func (h *latencyHistogram) quantile(q float64) float64 {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := q * float64(h.total)
+	var cumulative uint64
+	for i, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		if float64(cumulative+count) >= rank {
+			lower := 0.0
+			if i > 0 {
+				lower = latencyBucketBounds[i-1]
+			}
+			upper := h.maxMs
+			if i < len(latencyBucketBounds) && latencyBucketBounds[i] < upper {
+				upper = latencyBucketBounds[i]
+			}
+			if upper < lower {
+				return upper
+			}
+			fraction := (rank - float64(cumulative)) / float64(count)
+			return lower + (upper-lower)*fraction
+		}
+		cumulative += count
+	}
+	return h.maxMs
+}
+
+type latencyBucket struct {
+	LeMs  float64 `json:"le_ms"` // 0 means +Inf
+	Count uint64  `json:"count"`
+}
+
+type latencySummary struct {
+	Count   uint64          `json:"count"`
+	MeanMs  float64         `json:"mean_ms"`
+	P50Ms   float64         `json:"p50_ms"`
+	P95Ms   float64         `json:"p95_ms"`
+	P99Ms   float64         `json:"p99_ms"`
+	MaxMs   float64         `json:"max_ms"`
+	Buckets []latencyBucket `json:"buckets"`
+}
+
+func (h *latencyHistogram) summary() latencySummary {
+	summary := latencySummary{
+		Count:   h.total,
+		P50Ms:   h.quantile(0.50),
+		P95Ms:   h.quantile(0.95),
+		P99Ms:   h.quantile(0.99),
+		MaxMs:   h.maxMs,
+		Buckets: make([]latencyBucket, 0, len(h.counts)),
+	}
+	if h.total > 0 {
+		summary.MeanMs = h.sumMs / float64(h.total)
+	}
+	for i, count := range h.counts {
+		bucket := latencyBucket{Count: count}
+		if i < len(latencyBucketBounds) {
+			bucket.LeMs = latencyBucketBounds[i]
+		}
+		summary.Buckets = append(summary.Buckets, bucket)
+	}
+	return summary
+}
+
+// latencyRecorder tracks end-to-end delivery latency overall, per team and per
+// message type.
+type latencyRecorder struct {
+	mu            sync.Mutex
+	overall       *latencyHistogram
+	byTeam        map[string]*latencyHistogram
+	byMessageType map[string]*latencyHistogram
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{
+		overall:       newLatencyHistogram(),
+		byTeam:        make(map[string]*latencyHistogram),
+		byMessageType: make(map[string]*latencyHistogram),
+	}
+}
+
+var deliveryLatency = newLatencyRecorder()
+
+func seriesFor(series map[string]*latencyHistogram, key string) *latencyHistogram {
+	if key == "" {
+		key = "none"
+	}
+	if h, ok := series[key]; ok {
+		return h
+	}
+	if len(series) >= maxLatencySeries {
+		key = otherLatencySeries
+		if h, ok := series[key]; ok {
+			return h
+		}
+	}
+	h := newLatencyHistogram()
+	series[key] = h
+	return h
+}
+
+func (r *latencyRecorder) Observe(teamID, messageType string, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+
+	r.mu.Lock()
+	r.overall.observe(ms)
+	seriesFor(r.byTeam, teamID).observe(ms)
+	seriesFor(r.byMessageType, messageType).observe(ms)
+	r.mu.Unlock()
+
+	appMetrics.Timing("delivery.latency", d, metricTag("message_type", messageType))
+}
+
+type latencyReport struct {
+	Overall       latencySummary            `json:"overall"`
+	ByTeam        map[string]latencySummary `json:"by_team"`
+	ByMessageType map[string]latencySummary `json:"by_message_type"`
+}
+
+func (r *latencyRecorder) Report() latencyReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := latencyReport{
+		Overall:       r.overall.summary(),
+		ByTeam:        make(map[string]latencySummary, len(r.byTeam)),
+		ByMessageType: make(map[string]latencySummary, len(r.byMessageType)),
+	}
+	for team, h := range r.byTeam {
+		report.ByTeam[team] = h.summary()
+	}
+	for messageType, h := range r.byMessageType {
+		report.ByMessageType[messageType] = h.summary()
+	}
+	return report
+}
@@ -0,0 +1,90 @@
+// latency_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram_Quantiles(t *testing.T) {
+	h := newLatencyHistogram()
+	for i := 1; i <= 100; i++ {
+		h.observe(float64(i))
+	}
+
+	summary := h.summary()
+	if summary.Count != 100 {
+		t.Fatalf("expected 100 observations, got %d", summary.Count)
+	}
+	if summary.P50Ms < 25 || summary.P50Ms > 50 {
+		t.Fatalf("expected p50 within the 25-50ms bucket, got %v", summary.P50Ms)
+	}
+	if summary.P99Ms < 50 || summary.P99Ms > 100 {
+		t.Fatalf("expected p99 within the 50-100ms bucket, got %v", summary.P99Ms)
+	}
+	if summary.MaxMs != 100 {
+		t.Fatalf("expected max 100ms, got %v", summary.MaxMs)
+	}
+}
+
+func TestWritePump_RecordsDeliveryLatency(t *testing.T) {
+	setupTestAppConfig()
+	deliveryLatency = newLatencyRecorder()
+	hub := newHub()
+	go hub.run()
+
+	conn := newMockConn()
+	client := &Client{hub: hub, conn: conn, teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 1)}
+	go client.writePump(context.Background())
+
+	client.send <- outboundMessage{
+		payload:     []byte(`{"body":"hello"}`),
+		receivedAt:  time.Now().Add(-20 * time.Millisecond),
+		teamID:      "team-a",
+		messageType: "system_alert",
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for deliveryLatency.Report().Overall.Count == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for latency observation")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stopWritePump(t, client)
+
+	report := deliveryLatency.Report()
+	if report.ByTeam["team-a"].Count != 1 {
+		t.Fatalf("expected one observation for team-a, got %+v", report.ByTeam)
+	}
+	if report.ByMessageType["system_alert"].MaxMs < 20 {
+		t.Fatalf("expected max latency of at least 20ms, got %v", report.ByMessageType["system_alert"].MaxMs)
+	}
+}
+
+func TestHandleAdminStats(t *testing.T) {
+	setupTestAppConfig()
+	deliveryLatency = newLatencyRecorder()
+	deliveryLatency.Observe("team-a", "system_alert", 10*time.Millisecond)
+	hub := newHub()
+
+	rr := httptest.NewRecorder()
+	handleAdminStats(hub, rr, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var stats adminStatsResponse
+	if err := json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if stats.DeliveryLatency.Overall.Count != 1 {
+		t.Fatalf("expected one latency observation, got %d", stats.DeliveryLatency.Overall.Count)
+	}
+}
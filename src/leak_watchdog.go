@@ -0,0 +1,138 @@
+// leak_watchdog.go
+package main
+
+import (
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// leakWatchdog is a development-only helper that periodically snapshots
+// goroutine counts and per-client pump state, logging anything that looks
+// like a leak: pumps still running after unregister, send queues that never
+// drain, or a goroutine count that keeps climbing.
+type leakWatchdog struct {
+	mu               sync.Mutex
+	clients          map[*Client]*clientWatch
+	interval         time.Duration
+	unregisterGrace  time.Duration
+	stackSampleBytes int
+	growthChecks     int
+
+	baselineGoroutines int
+	lastGoroutines     int
+	growingFor         int
+}
+
+type clientWatch struct {
+	lastDepth   int
+	stuckChecks int
+}
+
+// pumpWatchdog is nil unless the watchdog is enabled, and all methods are nil-safe.
+var pumpWatchdog *leakWatchdog
+
+func newLeakWatchdog(interval time.Duration, stackSampleBytes int) *leakWatchdog {
+	goroutines := runtime.NumGoroutine()
+	return &leakWatchdog{
+		clients:            make(map[*Client]*clientWatch),
+		interval:           interval,
+		unregisterGrace:    interval,
+		stackSampleBytes:   stackSampleBytes,
+		growthChecks:       3,
+		baselineGoroutines: goroutines,
+		lastGoroutines:     goroutines,
+	}
+}
+
+// track starts watching a client whose pumps are about to start.
+func (w *leakWatchdog) track(client *Client) {
+	if w == nil || client == nil {
+		return
+	}
+
+	w.mu.Lock()
+	w.clients[client] = &clientWatch{}
+	w.mu.Unlock()
+}
+
+func (w *leakWatchdog) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (w *leakWatchdog) check(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	goroutines := runtime.NumGoroutine()
+	if goroutines > w.lastGoroutines {
+		w.growingFor++
+	} else {
+		w.growingFor = 0
+	}
+	w.lastGoroutines = goroutines
+
+	suspected := false
+	if w.growingFor >= w.growthChecks {
+		slog.Warn("LEAK? goroutine count keeps growing",
+			"checks", w.growingFor, "goroutines", goroutines, "baseline", w.baselineGoroutines, "clients", len(w.clients))
+		suspected = true
+	}
+
+	for client, watch := range w.clients {
+		readAlive := client.readPumpAlive.Load()
+		writeAlive := client.writePumpAlive.Load()
+		unregisteredAt := client.unregisteredAt.Load()
+
+		if unregisteredAt != 0 {
+			if !readAlive && !writeAlive {
+				delete(w.clients, client)
+				continue
+			}
+			if since := now.Sub(time.Unix(0, unregisteredAt)); since > w.unregisterGrace {
+				client.logger().Warn("LEAK? pumps still alive after unregister",
+					"since", since.Round(time.Millisecond), "read_pump", readAlive, "write_pump", writeAlive)
+				suspected = true
+			}
+			continue
+		}
+
+		depth := len(client.send)
+		if depth > 0 && depth >= watch.lastDepth {
+			watch.stuckChecks++
+		} else {
+			watch.stuckChecks = 0
+		}
+		watch.lastDepth = depth
+
+		if depth > 0 && !writeAlive {
+			client.logger().Warn("LEAK? queued messages but writePump has exited", "depth", depth)
+			suspected = true
+		} else if watch.stuckChecks >= w.growthChecks {
+			client.logger().Warn("LEAK? send queue has not drained",
+				"checks", watch.stuckChecks, "depth", depth)
+			suspected = true
+		}
+	}
+
+	if suspected {
+		slog.Warn("LEAK? goroutine stack sample", "stacks", w.stackSample())
+	}
+}
+
+func (w *leakWatchdog) stackSample() string {
+	buf := make([]byte, w.stackSampleBytes)
+	n := runtime.Stack(buf, true)
+	return string(buf[:n])
+}
@@ -0,0 +1,82 @@
+// leak_watchdog_test.go
+package main
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs makes a text logger writing to the returned buffer the default
+// until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger(&buf, slog.LevelDebug, "text"))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	})
+	return &buf
+}
+
+func TestLeakWatchdog_FlagsPumpAliveAfterUnregister(t *testing.T) {
+	logs := captureLogs(t)
+	watchdog := newLeakWatchdog(time.Second, 4096)
+
+	client := &Client{teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 1)}
+	watchdog.track(client)
+	client.writePumpAlive.Store(true)
+	client.unregisteredAt.Store(time.Now().Add(-time.Minute).UnixNano())
+
+	watchdog.check(time.Now())
+
+	if !strings.Contains(logs.String(), "pumps still alive") {
+		t.Fatalf("expected leak warning for lingering writePump, got logs: %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "goroutine stack sample") {
+		t.Fatal("expected a goroutine stack sample alongside the leak warning")
+	}
+}
+
+func TestLeakWatchdog_ForgetsCleanlyExitedClients(t *testing.T) {
+	logs := captureLogs(t)
+	watchdog := newLeakWatchdog(time.Second, 4096)
+
+	client := &Client{teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 1)}
+	watchdog.track(client)
+	client.unregisteredAt.Store(time.Now().Add(-time.Minute).UnixNano())
+
+	watchdog.check(time.Now())
+
+	if strings.Contains(logs.String(), "LEAK?") {
+		t.Fatalf("did not expect a leak warning, got logs: %s", logs.String())
+	}
+	if len(watchdog.clients) != 0 {
+		t.Fatalf("expected exited client to be forgotten, still tracking %d", len(watchdog.clients))
+	}
+}
+
+func TestLeakWatchdog_FlagsUndrainedQueue(t *testing.T) {
+	logs := captureLogs(t)
+	watchdog := newLeakWatchdog(time.Second, 4096)
+
+	client := &Client{teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 2)}
+	client.writePumpAlive.Store(true)
+	client.send <- outboundMessage{payload: []byte("stuck")}
+	watchdog.track(client)
+
+	for i := 0; i < watchdog.growthChecks; i++ {
+		watchdog.check(time.Now())
+	}
+
+	if !strings.Contains(logs.String(), "send queue has not drained") {
+		t.Fatalf("expected undrained queue warning, got logs: %s", logs.String())
+	}
+}
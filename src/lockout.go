@@ -0,0 +1,158 @@
+// lockout.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// failureLimiter slows credential stuffing by locking out keys (client IPs or
+// presented tokens) after repeated authentication failures. Each lockout a key
+// earns doubles the next one, up to maxLockout.
+type failureLimiter struct {
+	mu          sync.Mutex
+	entries     map[string]*failureEntry
+	maxFailures int
+	window      time.Duration
+	baseLockout time.Duration
+	maxLockout  time.Duration
+	nextCleanup time.Time
+	now         func() time.Time
+}
+
+type failureEntry struct {
+	failures     int
+	firstFailure time.Time
+	lockouts     int
+	lockedUntil  time.Time
+	lastSeen     time.Time
+}
+
+// authFailures is nil until main configures it, and all methods are nil-safe.
+var authFailures *failureLimiter
+
+func newFailureLimiter(maxFailures int, window, baseLockout, maxLockout time.Duration) *failureLimiter {
+	return &failureLimiter{
+		entries:     make(map[string]*failureEntry),
+		maxFailures: maxFailures,
+		window:      window,
+		baseLockout: baseLockout,
+		maxLockout:  maxLockout,
+		now:         time.Now,
+	}
+}
+
+func ipFailureKey(ip string) string { return "ip:" + ip }
+
+// tokenFailureKey identifies a presented credential without keeping it in memory.
+func tokenFailureKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:8])
+}
+
+// lockedUntil returns when key's lockout ends, or false if it is not locked out.
+func (l *failureLimiter) lockedUntil(key string) (time.Time, bool) {
+	if l == nil {
+		return time.Time{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok || !l.now().Before(entry.lockedUntil) {
+		return time.Time{}, false
+	}
+	return entry.lockedUntil, true
+}
+
+// recordFailure counts a failed attempt for key and returns the lockout
+// expiry when this failure triggered one.
+func (l *failureLimiter) recordFailure(key, scope string) (time.Time, bool) {
+	if l == nil {
+		return time.Time{}, false
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	if !now.Before(l.nextCleanup) {
+		l.cleanupLocked(now)
+		l.nextCleanup = now.Add(l.window)
+	}
+
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &failureEntry{}
+		l.entries[key] = entry
+	}
+	entry.lastSeen = now
+	if entry.failures == 0 || now.Sub(entry.firstFailure) > l.window {
+		entry.failures = 0
+		entry.firstFailure = now
+	}
+	entry.failures++
+
+	if entry.failures < l.maxFailures {
+		l.mu.Unlock()
+		return time.Time{}, false
+	}
+
+	penalty := l.baseLockout << entry.lockouts
+	if penalty <= 0 || penalty > l.maxLockout {
+		penalty = l.maxLockout
+	}
+	entry.lockouts++
+	entry.failures = 0
+	entry.lockedUntil = now.Add(penalty)
+	until := entry.lockedUntil
+	lockouts := entry.lockouts
+	l.mu.Unlock()
+
+	appMetrics.Count("auth.lockouts", 1, metricTag("scope", scope))
+	recordAudit(auditEvent{
+		Action:  "auth.lockout",
+		Subject: key,
+		Details: map[string]string{
+			"scope":        scope,
+			"locked_until": until.Format(time.RFC3339),
+			"lockouts":     strconv.Itoa(lockouts),
+		},
+	})
+	return until, true
+}
+
+// recordSuccess clears key's failure history after a successful attempt.
+func (l *failureLimiter) recordSuccess(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	delete(l.entries, key)
+	l.mu.Unlock()
+}
+
+func (l *failureLimiter) cleanupLocked(now time.Time) {
+	for key, entry := range l.entries {
+		// Keep lockout history around long enough for the doubling penalty to matter.
+		if now.After(entry.lockedUntil) && now.Sub(entry.lastSeen) > l.maxLockout {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// isCredentialFailure reports whether an authentication error was caused by
+// what the client presented, as opposed to the backend being unavailable.
+func isCredentialFailure(err error) bool {
+	var backendFailure *circuitBreakerFailure
+	return err != nil && !errors.As(err, &backendFailure) && !errors.Is(err, errCircuitOpen)
+}
+
+func retryAfterSeconds(until time.Time) string {
+	return strconv.Itoa(int(time.Until(until).Seconds()) + 1)
+}
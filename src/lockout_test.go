@@ -0,0 +1,99 @@
+// lockout_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFailureLimiter_ExponentialLockouts(t *testing.T) {
+	setupTestAppConfig()
+	now := time.Now()
+	limiter := newFailureLimiter(3, time.Minute, 10*time.Second, 25*time.Second)
+	limiter.now = func() time.Time { return now }
+
+	expectedPenalties := []time.Duration{10 * time.Second, 20 * time.Second, 25 * time.Second}
+	for round, penalty := range expectedPenalties {
+		for i := 1; i <= 3; i++ {
+			until, locked := limiter.recordFailure("ip:203.0.113.10", "test")
+			if locked != (i == 3) {
+				t.Fatalf("round %d failure %d: locked = %v", round, i, locked)
+			}
+			if locked && !until.Equal(now.Add(penalty)) {
+				t.Fatalf("round %d: expected lockout of %s, got %s", round, penalty, until.Sub(now))
+			}
+		}
+		if _, locked := limiter.lockedUntil("ip:203.0.113.10"); !locked {
+			t.Fatalf("round %d: expected key to be locked out", round)
+		}
+		now = now.Add(penalty + time.Second)
+		if _, locked := limiter.lockedUntil("ip:203.0.113.10"); locked {
+			t.Fatalf("round %d: expected lockout to expire", round)
+		}
+	}
+}
+
+func TestFailureLimiter_WindowAndSuccessReset(t *testing.T) {
+	setupTestAppConfig()
+	now := time.Now()
+	limiter := newFailureLimiter(2, time.Minute, time.Minute, time.Hour)
+	limiter.now = func() time.Time { return now }
+
+	limiter.recordFailure("token:a", "test")
+	now = now.Add(2 * time.Minute)
+	if _, locked := limiter.recordFailure("token:a", "test"); locked {
+		t.Fatal("expected failures outside the window not to accumulate")
+	}
+
+	limiter.recordSuccess("token:a")
+	if _, locked := limiter.recordFailure("token:a", "test"); locked {
+		t.Fatal("expected a success to clear earlier failures")
+	}
+}
+
+func TestApiKeyMiddleware_LocksOutRepeatedFailures(t *testing.T) {
+	setupTestAppConfig()
+	authFailures = newFailureLimiter(2, time.Minute, time.Minute, time.Hour)
+	defer func() { authFailures = nil }()
+
+	handlerToTest := apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	send := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://testing/send", nil)
+		req.RemoteAddr = "203.0.113.10:1234"
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		handlerToTest(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := send("wrong-key"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, rr.Code)
+		}
+	}
+
+	rr := send("test-api-key")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected locked-out address to get 429 even with a valid key, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+}
+
+func TestIsCredentialFailure(t *testing.T) {
+	if isCredentialFailure(errCircuitOpen) {
+		t.Fatal("an open circuit is not a credential failure")
+	}
+	if isCredentialFailure(markCircuitBreakerFailure(http.ErrHandlerTimeout)) {
+		t.Fatal("backend failures are not credential failures")
+	}
+	if !isCredentialFailure(&teamMismatchError{userID: "u", requested: "a", selected: "b"}) {
+		t.Fatal("a team mismatch is a credential failure")
+	}
+}
@@ -0,0 +1,72 @@
+// logging.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// parseLogLevel maps logging.level to a slog level.
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("logging.level must be debug, info, warn or error")
+}
+
+// newLogger returns a logger that writes records at level or above to w, as
+// JSON objects when format is "json" and as key=value text otherwise.
+func newLogger(w io.Writer, level slog.Leveler, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+// configureLogging makes the logger described by config's logging section
+// the default. Per-message logging is at debug level, which production never
+// logs.
+func configureLogging(config *Config, w io.Writer) {
+	suppressed := setLogLevel(config)
+	logger := newLogger(w, &logLevel, config.Logging.Format)
+	slog.SetDefault(logger)
+	if suppressed {
+		logger.Warn("debug logging is not available in production; logging at info")
+	}
+}
+
+// logLevel is the default logger's level. A config reload changes it in
+// place, so loggers derived from the default follow it too.
+var logLevel slog.LevelVar
+
+// setLogLevel applies config's logging.level. It reports whether debug was
+// asked for in production and info is used instead.
+func setLogLevel(config *Config) bool {
+	level, err := parseLogLevel(config.Logging.Level)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+	suppressed := config.Environment.Mode == "production" && level < slog.LevelInfo
+	if suppressed {
+		level = slog.LevelInfo
+	}
+	logLevel.Set(level)
+	return suppressed
+}
+
+// fatal logs an error that keeps the server from starting, and exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,80 @@
+// logging_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureJSONLogs makes a JSON logger writing to the returned buffer the
+// default until the test ends.
+func captureJSONLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger(&buf, slog.LevelDebug, "json"))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	})
+	return &buf
+}
+
+func TestConfigureLogging(t *testing.T) {
+	setupTestAppConfig()
+	previous := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	})
+
+	var buf bytes.Buffer
+	config := *AppConfig()
+	config.Logging.Level = "debug"
+	config.Logging.Format = "json"
+	config.Environment.Mode = "development"
+	configureLogging(&config, &buf)
+
+	client := &Client{tenantID: "acme", teamID: "acme/team-1", userID: "alice", connID: "c1"}
+	client.logger().Debug("ReadPump started")
+	slog.Warn("Backend is down", "url", "https://backend.example.com")
+	// Lines of the log package go through the default logger too.
+	log.Printf("third-party line")
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected JSON records, got %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d: %s", len(records), buf.String())
+	}
+	if records[0]["level"] != "DEBUG" || records[0]["team"] != "acme/team-1" || records[0]["user"] != "alice" || records[0]["conn"] != "c1" || records[0]["tenant"] != "acme" {
+		t.Errorf("expected the connection's fields at debug, got %v", records[0])
+	}
+	if records[1]["level"] != "WARN" || records[1]["url"] != "https://backend.example.com" {
+		t.Errorf("expected a warning with its attributes, got %v", records[1])
+	}
+	if records[2]["level"] != "INFO" || records[2]["msg"] != "third-party line" {
+		t.Errorf("expected the log package line at info, got %v", records[2])
+	}
+
+	// Production never logs per-message debug records.
+	buf.Reset()
+	config.Environment.Mode = "production"
+	configureLogging(&config, &buf)
+	client.logger().Debug("ReadPump started")
+	if strings.Contains(buf.String(), "ReadPump") || !strings.Contains(buf.String(), "debug logging is not available in production") {
+		t.Errorf("expected debug records to be suppressed in production, got %s", buf.String())
+	}
+}
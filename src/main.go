@@ -2,36 +2,45 @@
 package main
 
 import (
+	"context"
 	"crypto/subtle"
-	"encoding/json"
 	"errors"
-	"log"
+	"flag"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// shutdownTimeout bounds how long a graceful shutdown waits for in-flight
+// REST requests before the snapshot is written.
+const shutdownTimeout = 10 * time.Second
+
 var httpClient *http.Client
-var requestRateLimiter *ipRateLimiter
 
-type healthResponse struct {
-	Status       string `json:"status"`
-	Message      string `json:"message"`
-	TotalTeams   int    `json:"total_teams"`
-	TotalClients int    `json:"total_clients"`
-}
+// rateLimitRedis is the Redis connection of rate_limit.backend redis, or nil.
+var rateLimitRedis *redisClient
 
 // Middleware functions
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+
+// corsMiddleware answers preflights and adds the CORS and security headers
+// configured for group. Allow-Origin depends on the request's Origin, so
+// every response varies by it, including those for denied origins.
+func corsMiddleware(group routeGroup, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
+		headers := group.headers()
 
-		w.Header().Set("X-Content-Type-Options", "nosniff")
+		setSecurityHeaders(w, r, headers)
+		w.Header().Add("Vary", "Origin")
 
 		// Check if origin is allowed
-		if origin != "" && IsOriginAllowed(origin) {
-			w.Header().Add("Vary", "Origin")
+		if origin != "" && (originAllowedByAnyTenant(origin) || IsOriginAllowed(origin)) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 		}
 
@@ -39,6 +48,9 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 		if r.Method == "OPTIONS" {
+			if headers.PreflightMaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.FormatInt(int64(headers.PreflightMaxAge/time.Second), 10))
+			}
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -47,14 +59,63 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// apiKeyMiddleware requires the operator API key, security.api_key.
 func apiKeyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return requireAPIKey(next, false)
+}
+
+// tenantAPIKeyMiddleware also accepts a tenant's API key and records the
+// tenant on the request context for requestTenant.
+func tenantAPIKeyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return requireAPIKey(next, true)
+}
+
+func requireAPIKey(next http.HandlerFunc, allowTenants bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		clientKey := ipFailureKey(clientIPFromRequest(r))
+		if until, locked := authFailures.lockedUntil(clientKey); locked {
+			w.Header().Set("Retry-After", retryAfterSeconds(until))
+			http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+			return
+		}
+
 		// Check for API key in header
 		apiKey := r.Header.Get("X-API-Key")
-		expectedAPIKey := AppConfig.Security.APIKey
+		expectedAPIKey := AppConfig().Security.APIKey
 		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(expectedAPIKey)) != 1 {
-			log.Printf("Invalid API key attempt from %s", r.RemoteAddr)
-			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			tenant, ok := tenantForAPIKey(apiKey)
+			if !ok || !allowTenants {
+				slog.Warn("Invalid API key", "remote_addr", r.RemoteAddr)
+				appMetrics.Count("auth.api_key_failures", 1)
+				authFailures.recordFailure(clientKey, "api_key")
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			// Browser callers must also come from one of the tenant's origins.
+			if origin := r.Header.Get("Origin"); !originAllowedForTenant(tenant, origin) {
+				slog.Warn("Origin not allowed for tenant", "origin", origin, "tenant", tenant.ID)
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			r = withTenant(r, tenant)
+			setAccessLogCaller(r, "tenant:"+tenant.ID)
+		} else {
+			setAccessLogCaller(r, "operator")
+		}
+		authFailures.recordSuccess(clientKey)
+		next(w, r)
+	}
+}
+
+// ipPolicyMiddleware rejects REST API callers outside security.ip_allowlist or
+// inside security.ip_denylist.
+func ipPolicyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientIP := clientIPFromRequest(r)
+		if !restAPIPolicy.Allows(clientIP) {
+			slog.Warn("Blocked by IP policy", "path", r.URL.Path, "remote_ip", clientIP)
+			appMetrics.Count("http.ip_blocked", 1, metricTag("path", r.URL.Path))
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 		next(w, r)
@@ -63,13 +124,27 @@ func apiKeyMiddleware(next http.HandlerFunc) http.HandlerFunc {
 
 func rateLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if requestRateLimiter != nil && r.URL.Path != "/health" {
+		if r.URL.Path != "/health" && r.URL.Path != "/healthz" && r.URL.Path != "/readyz" {
 			clientIP := clientIPFromRequest(r)
-			if !requestRateLimiter.Allow(clientIP) {
-				log.Printf("rate limit exceeded for %s on %s", clientIP, r.URL.Path)
-				w.Header().Set("Retry-After", "1")
-				http.Error(w, "Too many requests", http.StatusTooManyRequests)
-				return
+			apiKey := r.Header.Get("X-API-Key")
+			limits := activeRateLimits.Load()
+			var limiter RateLimiter
+			key, cost, policy := rateLimitKey(AppConfig().RateLimit.Key, clientIP, apiKey), 1, defaultRateLimitPolicy
+			if limits != nil {
+				limiter = limits.fallback
+				if route := limits.match(r.URL.Path); route != nil {
+					limiter, key, cost, policy = route.limiter, route.key(clientIP, apiKey), route.policy.Cost, route.policy.Name
+				}
+			}
+			if limiter != nil {
+				if allowed, wait := allowRequest(limiter, key, cost); !allowed {
+					slog.Warn("Rate limit exceeded", "key", key, "path", r.URL.Path, "policy", policy)
+					appMetrics.Count("http.rate_limited", 1, metricTag("path", r.URL.Path), metricTag("policy", policy))
+					abuseGuard.record(ipSubject(clientIP), violationRateLimited)
+					w.Header().Set("Retry-After", retryAfterSeconds(time.Now().Add(wait)))
+					http.Error(w, "Too many requests", http.StatusTooManyRequests)
+					return
+				}
 			}
 		}
 
@@ -77,95 +152,501 @@ func rateLimitMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// configureRateLimits builds the default and per-route limiters from
+// config's rate_limit section. It is called again when a reload changes the
+// section, and the new limiters start with full buckets.
+func configureRateLimits(config *Config) {
+	localRateLimiter := newIPRateLimiter(
+		config.RateLimit.RequestsPerSecond,
+		config.RateLimit.Burst,
+		config.RateLimit.EntryTTL,
+		config.RateLimit.CleanupInterval,
+	)
+	var limiter RateLimiter = localRateLimiter
+	if rateLimitRedis != nil {
+		limiter = newRedisRateLimiter(
+			rateLimitRedis,
+			config.Storage.Redis.KeyPrefix,
+			defaultRateLimitPolicy,
+			config.RateLimit.RequestsPerSecond,
+			config.RateLimit.Burst,
+			localRateLimiter,
+		)
+	}
+	routes := newRouteRateLimits(config.RateLimit.Policies, func(policy RateLimitPolicy) RateLimiter {
+		local := newIPRateLimiter(policy.RequestsPerSecond, policy.Burst, config.RateLimit.EntryTTL, config.RateLimit.CleanupInterval)
+		if rateLimitRedis == nil {
+			return local
+		}
+		return newRedisRateLimiter(rateLimitRedis, config.Storage.Redis.KeyPrefix, policy.Name, policy.RequestsPerSecond, policy.Burst, local)
+	})
+	setRateLimits(limiter, routes)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		os.Exit(runClientCommand(os.Args[2:], os.Stdin, os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "notifyctl" {
+		os.Exit(runNotifyctlCommand(os.Args[2:], os.Stdout))
+	}
+
+	migrateOnly := flag.Bool("migrate-only", false, "apply storage schema migrations and exit")
+	validateOnly := flag.Bool("validate-config", false, "load and validate the configuration, then exit without starting listeners")
+	flag.Parse()
+
 	// Load configuration
 	configPath := "local_settings.yaml"
 	if envPath := os.Getenv("CONFIG_PATH"); envPath != "" {
 		configPath = envPath
 	}
 
+	if *validateOnly {
+		os.Exit(runValidateConfig(configPath))
+	}
+
 	if err := LoadConfig(configPath); err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load the configuration", "error", err)
+	}
+	configureLogging(AppConfig(), os.Stderr)
+
+	if AppConfig().Secrets.RefreshInterval > 0 && len(secretRefs.bindings) > 0 {
+		go runSecretRefresh(secretRefs.resolver, secretRefs.bindings, AppConfig().Secrets.RefreshInterval, nil)
 	}
 
 	// Initialize HTTP client with configured timeout
 	httpClient = &http.Client{
-		Timeout: AppConfig.Backend.Timeout,
+		Timeout: AppConfig().Backend.Timeout,
 	}
-	requestRateLimiter = newIPRateLimiter(
-		AppConfig.RateLimit.RequestsPerSecond,
-		AppConfig.RateLimit.Burst,
-		AppConfig.RateLimit.EntryTTL,
-		AppConfig.RateLimit.CleanupInterval,
+	if AppConfig().RateLimit.Backend == "redis" {
+		rateLimitRedis = newRedisClient(AppConfig().Storage.Redis.Address, AppConfig().Storage.Redis.Password, AppConfig().Storage.Redis.DB, AppConfig().Storage.Redis.Timeout)
+	}
+	configureRateLimits(AppConfig())
+
+	policy, err := newIPPolicy(AppConfig().Security.IPAllowlist, AppConfig().Security.IPDenylist)
+	if err != nil {
+		fatal("Failed to load the IP policy", "error", err)
+	}
+	restAPIPolicy = policy
+
+	authFailures = newFailureLimiter(
+		AppConfig().Security.BruteForce.MaxFailures,
+		AppConfig().Security.BruteForce.Window,
+		AppConfig().Security.BruteForce.BaseLockout,
+		AppConfig().Security.BruteForce.MaxLockout,
 	)
 
+	emitter, err := newMetricsEmitter(AppConfig())
+	if err != nil {
+		fatal("Failed to initialize metrics", "error", err)
+	}
+	appMetrics = emitter
+
+	if *migrateOnly {
+		runMigrationsOnly(AppConfig())
+		return
+	}
+
+	store, err := newStore(AppConfig())
+	if err != nil {
+		fatal("Failed to initialize storage", "error", err)
+	}
+	notificationStore = store
+
+	var snapshot *hubSnapshot
+	if AppConfig().Snapshot.Path != "" {
+		snapshot, err = loadHubSnapshot(AppConfig().Snapshot.Path, time.Now(), AppConfig().Snapshot.MaxAge)
+		if err != nil {
+			slog.Error("Not restoring the hub snapshot", "path", AppConfig().Snapshot.Path, "error", err)
+		} else if snapshot != nil {
+			snapshot.restoreStore(notificationStore)
+		}
+	}
+	go runStorePruner(notificationStore, AppConfig().Storage.PruneInterval, nil)
+
 	// Initialize the hub
 	hub := newHub()
+	hub.batchWindow = AppConfig().WebSocket.RegisterBatchWindow
+	hub.batchSize = AppConfig().WebSocket.RegisterBatchSize
 	go hub.run()
+	go hub.runReaper(AppConfig().WebSocket.ReaperInterval, nil)
+	go reportHubMetrics(hub, AppConfig().Metrics.FlushInterval, nil)
+
+	outboundWebhooks = newWebhookDispatcher(&http.Client{Timeout: AppConfig().Webhooks.Timeout}, AppConfig().Webhooks.QueueSize,
+		AppConfig().Webhooks.MaxAttempts, AppConfig().Webhooks.InitialBackoff, AppConfig().Webhooks.MaxBackoff)
+	outboundWebhooks.outbox = notificationStore
+	go outboundWebhooks.run(AppConfig().Webhooks.Workers, nil)
+	if replayed, err := outboundWebhooks.replayOutbox(context.Background()); err != nil {
+		slog.Error("Failed to replay the webhook outbox", "error", err)
+	} else if replayed > 0 {
+		slog.Info("Replaying pending webhooks from the outbox", "webhooks", replayed)
+	}
+
+	if AppConfig().Webhooks.MaxPerTeam > 0 {
+		teamWebhooks = newTeamWebhookRegistry(notificationStore, outboundWebhooks, AppConfig().Webhooks.MaxPerTeam)
+		if loaded, err := teamWebhooks.load(context.Background()); err != nil {
+			fatal("Failed to load team webhooks", "error", err)
+		} else if loaded > 0 {
+			slog.Info("Loaded team webhooks", "webhooks", loaded)
+		}
+	}
+
+	if AppConfig().Attachments.Provider != "none" {
+		presigner, err := newStoragePresigner(AppConfig())
+		if err != nil {
+			fatal("Failed to configure attachment links", "error", err)
+		}
+		attachmentPresigner = presigner
+		if AppConfig().Attachments.MaxUploadSize > 0 {
+			attachmentUploads = newAttachmentUploader(presigner, &http.Client{Timeout: 30 * time.Second}, AppConfig().Attachments.MaxUploadSize, AppConfig().Attachments.UploadPrefix)
+		}
+	}
+
+	if AppConfig().ClientConfig.SigningKeyFile != "" {
+		signer, err := loadClientConfigSigner(AppConfig().ClientConfig.SigningKeyFile)
+		if err != nil {
+			fatal("Failed to load the client config signing key", "error", err)
+		}
+		clientConfigSigner = signer
+	}
+
+	if AppConfig().Quota.Enabled {
+		teamQuotas = newQuotaWatcher(AppConfig().Quota.WarnRatio, AppConfig().Quota.WarnCooldown, AppConfig().Quota.WebhookURL, outboundWebhooks)
+	}
+
+	if AppConfig().Conversations.Enabled {
+		conversationReads = newConversationIndex(AppConfig().Conversations.MaxPerUser, AppConfig().Conversations.SnippetLength)
+	}
+	if AppConfig().Mentions.Enabled {
+		teamMentions = newMentionNotifier(AppConfig().Mentions.MessageTypes, AppConfig().Mentions.MaxPerMessage)
+	}
+
+	if AppConfig().Audit.Sends {
+		recentSends = newAuditRing(AppConfig().Audit.ReplayBuffer)
+	}
+	if AppConfig().Analytics.Presence {
+		presenceHistory = newPresenceRecorder(AppConfig().Analytics.Instance)
+		go presenceHistory.run(notificationStore, AppConfig().Analytics.FlushInterval, nil)
+	}
+	if AppConfig().Analytics.Messages {
+		messageHistory = newMessageRecorder(AppConfig().Analytics.Instance)
+		go messageHistory.run(notificationStore, AppConfig().Analytics.FlushInterval, nil)
+	}
+	if AppConfig().Limits.OfflineQueueDepth > 0 {
+		offlineNotifications = newOfflineQueue(notificationStore, AppConfig().Limits.OfflineQueueDepth, AppConfig().Limits.OfflineTTL)
+	}
+	if AppConfig().Preferences.Sync {
+		userPreferences = newPreferenceSync(notificationStore, AppConfig().Preferences.MaxKeys, AppConfig().Preferences.MaxValueSize)
+	}
+	if AppConfig().KeywordAlerts.Enabled {
+		keywordAlerts = newKeywordWatcher(AppConfig().KeywordAlerts.MaxPerUser, AppConfig().KeywordAlerts.MaxLength)
+	}
+	if AppConfig().Archive.SampleRate > 0 {
+		payloadArchive = newMessageArchiver(AppConfig().Archive.SampleRate, AppConfig().Archive.TTL)
+		go payloadArchive.run(notificationStore, nil)
+	}
+	teamPresenceSummaries = newPresenceSummaryCache(hub, AppConfig().Presence.SummaryTTL)
+	teamRetention = newRetentionTable(AppConfig().Retention)
+	notificationRecalls = newRecallLedger(AppConfig().Recall.Window, AppConfig().Recall.MaxTracked)
+
+	if AppConfig().Abuse.Enabled {
+		abuseGuard = newAbuseTracker(AppConfig().Abuse.Threshold, AppConfig().Abuse.ScoreHalfLife, AppConfig().Abuse.BanDuration, map[violationKind]float64{
+			violationRateLimited:      AppConfig().Abuse.Weights.RateLimited,
+			violationMalformedMessage: AppConfig().Abuse.Weights.MalformedMessage,
+			violationTeamSpoofing:     AppConfig().Abuse.Weights.TeamSpoofing,
+		})
+		abuseGuard.onBan = banHandler(hub, AppConfig().Abuse.WebhookURL, outboundWebhooks)
+	}
+
+	if AppConfig().Backend.AuthCacheTTL > 0 {
+		backendAuthCache = newAuthCache(AppConfig().Backend.AuthCacheTTL)
+	}
+	if AppConfig().Backend.JWT.JWKSURL != "" {
+		tokenVerifier = newJWTVerifier(AppConfig(), httpClient)
+		// Tokens are verified anyway if this fails; the keys are fetched
+		// again on the first connection.
+		if keys, err := tokenVerifier.keys.prefetch(); err != nil {
+			slog.Warn("Failed to fetch the JWKS at startup", "url", redactURL(AppConfig().Backend.JWT.JWKSURL), "error", err)
+		} else {
+			slog.Info("Verifying WebSocket tokens against the JWKS", "url", redactURL(AppConfig().Backend.JWT.JWKSURL), "keys", keys)
+		}
+	}
+	authBackends = newBackendPool(backendURLs(AppConfig()), AppConfig().Backend.Strategy == "round_robin")
+	if AppConfig().Backend.HealthPath != "" {
+		authBackends.monitor(AppConfig().Backend.HealthPath, AppConfig().Backend.HealthInterval, AppConfig().Backend.HealthThreshold, httpClient)
+		authBackends.onChange = func(status backendStatus) { announceBackendStatus(hub, status) }
+		authBackends.run(nil)
+	}
+
+	if AppConfig().Backend.TeamCheckPath != "" {
+		teamDirectory = newTeamChecker(authBackends, AppConfig().Backend.TeamCheckPath, AppConfig().Backend.TeamCheckTTL, httpClient)
+	}
+
+	teamBlackouts = newBlackoutSchedule(AppConfig().Blackout.CriticalMessageTypes, AppConfig().Blackout.MaxDeferredPerTeam)
+	if AppConfig().OnCall.Enabled {
+		onCallSchedules = newOnCallSchedule(AppConfig().OnCall.HandoffWindow, AppConfig().OnCall.MaxShiftsPerTeam)
+	}
+	if snapshot != nil {
+		snapshot.restoreHub(hub, snapshot.TakenAt.Add(AppConfig().Snapshot.MaxAge))
+	}
+	go teamBlackouts.run(hub, AppConfig().Blackout.CheckInterval, nil)
+	if onCallSchedules != nil {
+		go onCallSchedules.run(hub, AppConfig().OnCall.CheckInterval, nil)
+	}
+	if AppConfig().Escalation.Enabled {
+		notificationEscalations = newEscalationManager(AppConfig().Escalation.Chain, AppConfig().Escalation.Teams, AppConfig().Escalation.AckTimeout, AppConfig().Escalation.MaxTrails)
+		go notificationEscalations.run(hub, AppConfig().Escalation.CheckInterval, nil)
+	}
+	if AppConfig().Incidents.Enabled {
+		alertIncidents = newIncidentTracker(AppConfig().Incidents.MessageTypes, AppConfig().Incidents.RollupInterval, AppConfig().Incidents.ResolveAfter, AppConfig().Incidents.MaxOpen, AppConfig().Incidents.Groups)
+		go alertIncidents.run(hub, AppConfig().Incidents.CheckInterval, nil)
+		slog.Info("Incident mode groups alerts by group_key", "message_types", AppConfig().Incidents.MessageTypes)
+	}
+	go runStatsFeed(hub, AppConfig().Stats.Interval, nil)
+
+	if AppConfig().Schedules.Enabled {
+		broadcastSchedules = newBroadcastScheduler(hub, notificationStore, AppConfig().Schedules.MisfireGrace, AppConfig().Schedules.HistorySize)
+		if loaded, err := broadcastSchedules.load(context.Background()); err != nil {
+			fatal("Failed to load scheduled broadcasts", "error", err)
+		} else if loaded > 0 {
+			slog.Info("Loaded scheduled broadcasts", "schedules", loaded)
+		}
+		go broadcastSchedules.run(AppConfig().Schedules.CheckInterval, nil)
+	}
+
+	if IsLeakWatchdogEnabled() {
+		pumpWatchdog = newLeakWatchdog(AppConfig().Debug.WatchdogInterval, AppConfig().Debug.StackSampleBytes)
+		go pumpWatchdog.run(nil)
+	}
 
 	// Create router with middleware
 	mux := http.NewServeMux()
 
 	// Set up HTTP handlers with security middleware
-	mux.HandleFunc("/ws", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/ws", corsMiddleware(routeGroupWebSocket, func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(hub, w, r)
+	}))
+	mux.HandleFunc("/ws/", corsMiddleware(routeGroupWebSocket, func(w http.ResponseWriter, r *http.Request) {
 		handleWebSocket(hub, w, r)
 	}))
 
-	mux.HandleFunc("/send", corsMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/send", corsMiddleware(routeGroupAPI, ipPolicyMiddleware(tenantAPIKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		handleSendMessage(hub, w, r)
+	}))))
+
+	// Chat UIs restore per-conversation badge counts after reconnecting.
+	mux.HandleFunc("/users/", corsMiddleware(routeGroupAPI, ipPolicyMiddleware(tenantAPIKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/connections") {
+			handleUserConnections(hub, w, r)
+			return
+		}
+		handleUserConversations(w, r)
+	}))))
+	mux.HandleFunc("/notifications/", corsMiddleware(routeGroupAPI, ipPolicyMiddleware(tenantAPIKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleNotificationRecall(hub, w, r)
+	}))))
+
+	// Dashboards poll presence for many teams at once.
+	mux.HandleFunc("/teams/presence-summary", corsMiddleware(routeGroupAPI, ipPolicyMiddleware(tenantAPIKeyMiddleware(handleTeamPresenceSummary))))
+
+	// Chat clients upload small files with the token they connect with.
+	mux.HandleFunc("/attachments", corsMiddleware(routeGroupAPI, func(w http.ResponseWriter, r *http.Request) {
+		handleAttachmentUpload(attachmentUploads, w, r)
+	}))
+
+	// Frontends bootstrap from /client-config before they have any credentials.
+	mux.HandleFunc("/client-config", corsMiddleware(routeGroupAPI, handleClientConfig))
+
+	mux.HandleFunc("/admin/stats", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminStats(hub, w, r)
+	})))
+
+	mux.HandleFunc("/admin/analytics/presence", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminPresenceAnalytics(presenceHistory, notificationStore, w, r)
+	})))
+	mux.HandleFunc("/admin/analytics/messages", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminMessageAnalytics(messageHistory, notificationStore, w, r)
+	})))
+	mux.HandleFunc("/admin/slo", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminSLO(serviceLevels, w, r)
+	})))
+	mux.HandleFunc("/admin/archive", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminArchive(notificationStore, w, r)
+	})))
+	mux.HandleFunc("/admin/audit", ipPolicyMiddleware(apiKeyMiddleware(handleAdminAudit)))
+	mux.HandleFunc("/admin/audit/replay", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminAuditReplay(hub, w, r)
+	})))
+
+	// The dashboard page is static; its API calls and live feed carry the API key.
+	mux.HandleFunc("/admin/ui", ipPolicyMiddleware(handleAdminUI))
+	mux.HandleFunc("/admin/ui/", ipPolicyMiddleware(handleAdminUI))
+	mux.HandleFunc("/admin/ui/feed", ipPolicyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminUIFeed(hub, w, r)
+	}))
+
+	// Like the dashboard feed, the firehose takes the API key in its first frame.
+	mux.HandleFunc("/debug/firehose", ipPolicyMiddleware(handleDebugFirehose))
+
+	mux.HandleFunc("/admin/config", ipPolicyMiddleware(apiKeyMiddleware(handleAdminConfig)))
+	mux.HandleFunc("/admin/config/validate", ipPolicyMiddleware(apiKeyMiddleware(handleAdminConfigValidate)))
+	mux.HandleFunc("/admin/config/reload", ipPolicyMiddleware(apiKeyMiddleware(handleAdminConfigReload)))
+	mux.HandleFunc("/admin/blackouts", ipPolicyMiddleware(apiKeyMiddleware(handleAdminBlackouts)))
+	mux.HandleFunc("/admin/escalations", ipPolicyMiddleware(apiKeyMiddleware(handleAdminEscalations)))
+	mux.HandleFunc("/admin/escalations/chains", ipPolicyMiddleware(apiKeyMiddleware(handleAdminEscalationChains)))
+	mux.HandleFunc("/admin/oncall", ipPolicyMiddleware(apiKeyMiddleware(handleAdminOnCall)))
+	mux.HandleFunc("/admin/incidents", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminIncidents(hub, w, r)
+	})))
+	mux.HandleFunc("/admin/retention", ipPolicyMiddleware(apiKeyMiddleware(handleAdminRetention)))
+	mux.HandleFunc("/admin/teams/export", ipPolicyMiddleware(apiKeyMiddleware(handleAdminTeamExport)))
+	mux.HandleFunc("/admin/teams/import", ipPolicyMiddleware(apiKeyMiddleware(handleAdminTeamImport)))
+	mux.HandleFunc("/admin/schedules", ipPolicyMiddleware(apiKeyMiddleware(handleAdminSchedules)))
+	mux.HandleFunc("/admin/schedules/history", ipPolicyMiddleware(apiKeyMiddleware(handleAdminScheduleHistory)))
+	mux.HandleFunc("/admin/webhooks/outbox", ipPolicyMiddleware(apiKeyMiddleware(handleAdminWebhookOutbox)))
+	mux.HandleFunc("/admin/webhooks/teams", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminTeamWebhooks(teamWebhooks, w, r)
+	})))
+	mux.HandleFunc("/admin/handover", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminHandover(hub, w, r)
+	})))
+	mux.HandleFunc("/admin/handover/claim", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminHandoverClaim(hub, w, r)
+	})))
+	mux.HandleFunc("/admin/shutdown", ipPolicyMiddleware(apiKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminShutdown(plannedShutdown, w, r)
 	})))
 
 	// Health check endpoint
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		handleHealthz(hub, w, r)
+	})
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		health := hub.healthCheck()
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-
-		if err := json.NewEncoder(w).Encode(healthResponse{
-			Status:       "healthy",
-			Message:      "WebSocket server is running",
-			TotalTeams:   health.TotalTeams,
-			TotalClients: health.TotalClients,
-		}); err != nil {
-			log.Printf("failed to encode health response: %v", err)
-		}
+		handleHealth(hub, w, r)
 	})
 
+	mux.HandleFunc("/readyz", handleReadyz)
+
 	// Configure the server with values from config
 	server := &http.Server{
-		Addr:              ":" + AppConfig.Server.Port,
-		Handler:           rateLimitMiddleware(mux),
-		ReadTimeout:       AppConfig.Server.ReadTimeout,
+		Addr:              ":" + AppConfig().Server.Port,
+		Handler:           accessLogMiddleware(rateLimitMiddleware(compressionMiddleware(mux))),
+		ReadTimeout:       AppConfig().Server.ReadTimeout,
 		ReadHeaderTimeout: 5 * time.Second,
-		WriteTimeout:      AppConfig.Server.WriteTimeout,
-		IdleTimeout:       AppConfig.Server.IdleTimeout,
+		WriteTimeout:      AppConfig().Server.WriteTimeout,
+		IdleTimeout:       AppConfig().Server.IdleTimeout,
 		MaxHeaderBytes:    1 << 20,
 	}
 
+	if AppConfig().TLS.CertFile != "" {
+		reloader, err := newCertReloader("tls", AppConfig().TLS.CertFile, AppConfig().TLS.KeyFile, AppConfig().TLS.OCSPStapleFile)
+		if err != nil {
+			fatal("Failed to load the server certificate", "error", err)
+		}
+		server.TLSConfig = reloader.tlsConfig()
+	}
+
 	// Log startup information
-	log.Printf("=== WebSocket Notification Server Starting ===")
-	log.Printf("Port: %s", AppConfig.Server.Port)
-	log.Printf("Backend URL: %s", AppConfig.Backend.URL)
+	slog.Info("WebSocket notification server starting",
+		"port", AppConfig().Server.Port,
+		"tls", server.TLSConfig != nil,
+		"mode", AppConfig().Environment.Mode,
+		"backends", backendURLs(AppConfig()),
+		"backend_strategy", AppConfig().Backend.Strategy,
+		"allowed_origins", AppConfig().Server.AllowedOrigins,
+		"max_clients_per_team", AppConfig().Limits.MaxClientsPerTeam,
+		"metrics_backend", AppConfig().Metrics.Backend,
+		"storage_driver", AppConfig().Storage.Driver,
+		"abuse_detection", AppConfig().Abuse.Enabled)
 	if IsDevelopment() {
-		log.Printf("🧪 DEVELOPMENT MODE ENABLED")
-		log.Printf("🧪 CORS: %s", func() string {
-			if ShouldAllowAllOrigins() {
-				return "Allow all origins"
+		slog.Warn("Development mode is enabled",
+			"allow_all_origins", ShouldAllowAllOrigins(),
+			"fake_auth", IsFakeAuthEnabled(),
+			"leak_watchdog", IsLeakWatchdogEnabled())
+	}
+
+	var tcpListener net.Listener
+	if AppConfig().TCP.Address != "" {
+		tcpListener, err = newTCPListener()
+		if err != nil {
+			fatal("Failed to start the TCP listener", "error", err)
+		}
+		slog.Info("TCP listener started", "address", AppConfig().TCP.Address, "tls", AppConfig().TCP.CertFile != "")
+		go serveTCP(hub, tcpListener)
+	}
+
+	var controlListener net.Listener
+	if AppConfig().Control.Socket != "" {
+		controlListener, err = listenControlSocket(AppConfig().Control.Socket)
+		if err != nil {
+			fatal("Failed to open the control socket", "error", err)
+		}
+		slog.Info("Control socket opened", "path", AppConfig().Control.Socket)
+		go serveControlSocket(hub, controlListener)
+	}
+
+	// SIGHUP reloads the certificates of the TLS listeners and the settings
+	// of the config file that can change while running. Only new handshakes
+	// use new certificates, so established connections are kept.
+	go func() {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		for range hangups {
+			slog.Info("Received SIGHUP, reloading TLS certificates and configuration", "path", activeConfigPath)
+			reloadCertificates(false)
+			if _, err := reloadConfig(activeConfigPath); err != nil {
+				slog.Error("Configuration reload failed, keeping the running configuration", "path", activeConfigPath, "error", err)
+			}
+		}
+	}()
+	if AppConfig().TLS.ReloadInterval > 0 && (AppConfig().TLS.CertFile != "" || tcpListener != nil && AppConfig().TCP.CertFile != "") {
+		go watchCertificates(AppConfig().TLS.ReloadInterval, nil)
+	}
+
+	// On SIGINT or SIGTERM, tell clients why, stop accepting requests and
+	// write the snapshot.
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		received := <-signals
+		slog.Info("Shutting down", "signal", received.String())
+		notifyShutdown(hub)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if tcpListener != nil {
+			tcpListener.Close()
+		}
+		if controlListener != nil {
+			controlListener.Close()
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Graceful shutdown did not finish", "error", err)
+		}
+		if err := presenceHistory.flush(ctx, notificationStore, time.Now()); err != nil {
+			slog.Error("Failed to save presence rollups", "error", err)
+		}
+		if err := messageHistory.flush(ctx, notificationStore, time.Now()); err != nil {
+			slog.Error("Failed to save message rollups", "error", err)
+		}
+		if AppConfig().Snapshot.Path != "" {
+			if err := writeHubSnapshot(AppConfig().Snapshot.Path, takeHubSnapshot(hub, time.Now())); err != nil {
+				slog.Error("Failed to write the hub snapshot", "path", AppConfig().Snapshot.Path, "error", err)
+			} else {
+				slog.Info("Wrote the hub snapshot", "path", AppConfig().Snapshot.Path)
 			}
-			return "Restricted origins"
-		}())
-		log.Printf("🧪 Fake Auth: %v", IsFakeAuthEnabled())
-	} else {
-		log.Printf("🔒 PRODUCTION MODE")
-		log.Printf("🔒 CORS: Restricted to allowed origins only")
-		log.Printf("🔒 Fake Auth: Disabled")
-	}
-	log.Printf("Allowed Origins: %s", strings.Join(AppConfig.Server.AllowedOrigins, ", "))
-	log.Printf("Max Clients Per Team: %d", AppConfig.Limits.MaxClientsPerTeam)
-	log.Printf("===============================================")
+		}
+	}()
 
 	// Start the server
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("Server failed to start: %v", err)
+	serve := server.ListenAndServe
+	if server.TLSConfig != nil {
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+	}
+	if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal("Server failed to start", "error", err)
 	}
+	<-stopped
 }
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -107,14 +108,14 @@ func TestCorsMiddleware(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			AppConfig.Environment.Mode = tc.mode
-			AppConfig.Server.AllowedOrigins = tc.allowedOrigins
+			AppConfig().Environment.Mode = tc.mode
+			AppConfig().Server.AllowedOrigins = tc.allowedOrigins
 
 			req := httptest.NewRequest("GET", "http://testing/ws", nil)
 			req.Header.Set("Origin", tc.requestOrigin)
 			rr := httptest.NewRecorder()
 
-			handlerToTest := corsMiddleware(nextHandler)
+			handlerToTest := corsMiddleware(routeGroupWebSocket, nextHandler)
 			handlerToTest.ServeHTTP(rr, req)
 
 			header := rr.Header().Get("Access-Control-Allow-Origin")
@@ -125,6 +126,62 @@ func TestCorsMiddleware(t *testing.T) {
 	}
 }
 
+func TestCorsMiddleware_SecurityHeaders(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Server.AllowedOrigins = []string{"http://safe.com"}
+	AppConfig().Headers.API.PreflightMaxAge = 10 * time.Minute
+	AppConfig().Headers.API.HSTSIncludeSubdomains = true
+	AppConfig().Headers.WebSocket.PreflightMaxAge = -1
+	AppConfig().Headers.WebSocket.ReferrerPolicy = "same-origin"
+
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(group routeGroup, method, origin string, overTLS bool) http.Header {
+		req := httptest.NewRequest(method, "http://testing/send", nil)
+		req.Header.Set("Origin", origin)
+		if overTLS {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rr := httptest.NewRecorder()
+		corsMiddleware(group, nextHandler).ServeHTTP(rr, req)
+		return rr.Header()
+	}
+
+	header := serve(routeGroupAPI, "OPTIONS", "http://safe.com", false)
+	if got := header.Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("expected the api preflight to be cached for 600s, got %q", got)
+	}
+	if got := header.Get("Referrer-Policy"); got != "no-referrer" {
+		t.Errorf("expected the default Referrer-Policy, got %q", got)
+	}
+	if got := header.Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("expected no HSTS over plain HTTP, got %q", got)
+	}
+
+	header = serve(routeGroupAPI, "POST", "http://safe.com", true)
+	if got := header.Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Errorf("expected HSTS over TLS, got %q", got)
+	}
+	if got := header.Get("Access-Control-Max-Age"); got != "" {
+		t.Errorf("expected Max-Age only on preflights, got %q", got)
+	}
+
+	header = serve(routeGroupWebSocket, "OPTIONS", "http://unsafe.com", false)
+	if got := header.Get("Access-Control-Max-Age"); got != "" {
+		t.Errorf("expected a negative preflight_max_age to omit Max-Age, got %q", got)
+	}
+	if got := header.Get("Referrer-Policy"); got != "same-origin" {
+		t.Errorf("expected the websocket group's Referrer-Policy, got %q", got)
+	}
+	if got := header.Get("Vary"); got != "Origin" {
+		t.Errorf("expected Vary: Origin for a denied origin, got %q", got)
+	}
+	if got := header.Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("expected X-Content-Type-Options: nosniff, got %q", got)
+	}
+}
+
 // TestHealthCheckHandler tests the /health endpoint.
 func TestHealthCheckHandler(t *testing.T) {
 	hub := newHub()
@@ -175,8 +232,8 @@ func TestHealthCheckHandler(t *testing.T) {
 
 func TestRateLimitMiddleware(t *testing.T) {
 	setupTestAppConfig()
-	requestRateLimiter = newIPRateLimiter(1, 2, time.Minute, time.Minute)
-	defer func() { requestRateLimiter = nil }()
+	setRateLimits(newIPRateLimiter(1, 2, time.Minute, time.Minute), nil)
+	defer setRateLimits(nil, nil)
 
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -200,3 +257,103 @@ func TestRateLimitMiddleware(t *testing.T) {
 		}
 	}
 }
+
+func TestRateLimitMiddleware_RetryAfterAndAPIKeys(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().RateLimit.Key = "api_key"
+	AppConfig().Tenants = []TenantConfig{{ID: "acme", APIKey: "acme-key"}}
+	// One request every 10 seconds.
+	setRateLimits(newIPRateLimiter(0.1, 1, time.Minute, time.Minute), nil)
+	defer setRateLimits(nil, nil)
+
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(remoteAddr, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/send", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The operator key is one caller whatever address it comes from.
+	if rr := request("203.0.113.10:1234", "test-api-key"); rr.Code != http.StatusOK {
+		t.Fatalf("expected the first request to pass, got %d", rr.Code)
+	}
+	rr := request("203.0.113.11:1234", "test-api-key")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the operator key to be limited across addresses, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("expected Retry-After: 10, got %q", got)
+	}
+
+	// Tenant keys have buckets of their own, and unknown keys are counted by
+	// address.
+	if rr := request("203.0.113.10:1234", "acme-key"); rr.Code != http.StatusOK {
+		t.Fatalf("expected the tenant key to have its own bucket, got %d", rr.Code)
+	}
+	if request("203.0.113.12:1234", "made-up-1").Code != http.StatusOK || request("203.0.113.12:1234", "made-up-2").Code != http.StatusTooManyRequests {
+		t.Fatal("expected unknown keys from one address to share its bucket")
+	}
+}
+
+func TestIPPolicyMiddleware(t *testing.T) {
+	setupTestAppConfig()
+	policy, err := newIPPolicy([]string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.7"}, []string{"10.9.0.0/16"})
+	if err != nil {
+		t.Fatalf("newIPPolicy failed: %v", err)
+	}
+	restAPIPolicy = policy
+	defer func() { restAPIPolicy = nil }()
+
+	handlerToTest := ipPolicyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		expected   int
+	}{
+		{"allowlisted range", "10.1.2.3:1234", http.StatusOK},
+		{"allowlisted single address", "192.0.2.7:1234", http.StatusOK},
+		{"allowlisted IPv6", "[2001:db8::1]:1234", http.StatusOK},
+		{"IPv4-mapped IPv6", "[::ffff:10.1.2.3]:1234", http.StatusOK},
+		{"denylist wins over allowlist", "10.9.1.1:1234", http.StatusForbidden},
+		{"outside allowlist", "198.51.100.1:1234", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://testing/send", nil)
+			req.RemoteAddr = tc.remoteAddr
+			rr := httptest.NewRecorder()
+
+			handlerToTest(rr, req)
+
+			if rr.Code != tc.expected {
+				t.Fatalf("got %d, want %d", rr.Code, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNewIPPolicy(t *testing.T) {
+	if policy, err := newIPPolicy(nil, nil); err != nil || policy != nil {
+		t.Fatalf("expected no policy without lists, got %v, %v", policy, err)
+	}
+	if _, err := newIPPolicy([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Fatal("expected an error for an invalid CIDR range")
+	}
+
+	denyOnly, err := newIPPolicy(nil, []string{"203.0.113.0/24"})
+	if err != nil {
+		t.Fatalf("newIPPolicy failed: %v", err)
+	}
+	if !denyOnly.Allows("198.51.100.1") || denyOnly.Allows("203.0.113.9") {
+		t.Fatal("expected a denylist-only policy to allow everything but the denied range")
+	}
+}
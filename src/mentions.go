@@ -0,0 +1,169 @@
+// mentions.go
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// mentionMessageType is the type of the notification sent to a mentioned
+// user.
+const mentionMessageType = "mention"
+
+// priorityHigh marks notifications that skip subscription filters and
+// digests, so a user who muted a team's messages still hears about them.
+const priorityHigh = "high"
+
+// mentionPattern finds @name tokens that do not follow a word character, so
+// email addresses are not mistaken for mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@.])@([\p{L}\p{N}_][\p{L}\p{N}_.\-]*)`)
+
+// teamMentions is nil unless mentions.enabled is set, and all methods are
+// nil-safe.
+var teamMentions *mentionNotifier
+
+// mentionNotifier sends a mention notification to each team member named
+// with @ in a chat message, in addition to the message itself.
+type mentionNotifier struct {
+	messageTypes  map[string]struct{}
+	maxPerMessage int
+}
+
+func newMentionNotifier(messageTypes []string, maxPerMessage int) *mentionNotifier {
+	types := make(map[string]struct{}, len(messageTypes))
+	for _, messageType := range messageTypes {
+		types[messageType] = struct{}{}
+	}
+	return &mentionNotifier{messageTypes: types, maxPerMessage: maxPerMessage}
+}
+
+// teamMember is a user connected to a team. Mentions are matched by user ID,
+// or by the part of their email before the @; escalation chains pick users
+// by group and admin status.
+type teamMember struct {
+	UserID string
+	Email  string
+	Groups []string // sorted
+	Admin  bool
+}
+
+// teamMembers lists the users connected to teamID, ordered by user ID.
+func (h *Hub) teamMembers(teamID string) []teamMember {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	members := make([]teamMember, 0, len(h.clients[teamID]))
+	for userID, clients := range h.clients[teamID] {
+		member := teamMember{UserID: userID}
+		groups := make(map[string]struct{})
+		for client := range clients {
+			if member.Email == "" {
+				member.Email = client.email
+			}
+			member.Admin = member.Admin || client.teamAdmin
+			for group := range client.attributes.groups {
+				groups[group] = struct{}{}
+			}
+		}
+		for group := range groups {
+			member.Groups = append(member.Groups, group)
+		}
+		sort.Strings(member.Groups)
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	return members
+}
+
+// parseMentions returns the users of roster that body mentions, in the order
+// they are first mentioned, without duplicates and at most limit of them.
+// Names are matched without regard to case; a trailing full stop or hyphen
+// is taken as punctuation.
+func parseMentions(body string, roster []teamMember, limit int) []string {
+	byName := make(map[string]string, len(roster)*2)
+	for _, member := range roster {
+		if local, _, ok := strings.Cut(member.Email, "@"); ok && local != "" {
+			byName[strings.ToLower(local)] = member.UserID
+		}
+	}
+	// User IDs win over email names that collide with them.
+	for _, member := range roster {
+		byName[strings.ToLower(member.UserID)] = member.UserID
+	}
+
+	var mentioned []string
+	seen := make(map[string]struct{})
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		if len(mentioned) >= limit {
+			break
+		}
+		name := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		userID, ok := byName[name]
+		if !ok {
+			continue
+		}
+		if _, dup := seen[userID]; dup {
+			continue
+		}
+		seen[userID] = struct{}{}
+		mentioned = append(mentioned, userID)
+	}
+	return mentioned
+}
+
+// mentionBody is the body of a mention notification. It carries the message
+// the user was mentioned in, so the client can show it without a lookup.
+type mentionBody struct {
+	NotificationID string `json:"notificationId"`
+	MessageType    string `json:"messageType"`
+	Body           string `json:"body"`
+}
+
+// notify sends a mention notification for message, which /send delivered as
+// sent, to every connected member of its team it mentions other than the
+// sender and, for a direct message, the recipient. Members the message's
+// visibility rules hide it from are not told about it. It returns how many
+// users were notified.
+func (m *mentionNotifier) notify(hub NotificationHub, message *Message, sent outboundMessage) int {
+	if m == nil || sent.teamID == "" {
+		return 0
+	}
+	if _, ok := m.messageTypes[message.MessageType]; !ok {
+		return 0
+	}
+	body, err := json.Marshal(mentionBody{NotificationID: message.NotificationID, MessageType: message.MessageType, Body: message.Body})
+	if err != nil {
+		return 0
+	}
+	notified := 0
+	for _, userID := range parseMentions(message.Body, hub.TeamMembers(sent.teamID), m.maxPerMessage) {
+		if userID == message.SenderUserID || userID == message.TargetUserID {
+			continue
+		}
+		mention := NewMessage(newNotificationID(), message.TargetTeamID, userID, message.SenderUserID, mentionMessageType, string(body), false)
+		mention.Priority = priorityHigh
+		payload, err := mention.ToJSON()
+		if err != nil {
+			continue
+		}
+		delivered := hub.SendToUser(sent.teamID, userID, outboundMessage{
+			payload:        payload,
+			receivedAt:     sent.receivedAt,
+			tenantID:       sent.tenantID,
+			teamID:         sent.teamID,
+			messageType:    mentionMessageType,
+			notificationID: mention.NotificationID,
+			visibility:     sent.visibility,
+			priority:       true,
+		})
+		if delivered > 0 {
+			notified++
+		}
+	}
+	if notified > 0 {
+		appMetrics.Count("mentions.sent", int64(notified), tenantTags(sent.tenantID)...)
+	}
+	return notified
+}
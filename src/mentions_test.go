@@ -0,0 +1,105 @@
+// mentions_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	roster := []teamMember{
+		{UserID: "alice"},
+		{UserID: "42", Email: "Bob.Smith@example.com"},
+		{UserID: "carol", Email: "alice@example.com"},
+		{UserID: "zoë"},
+	}
+	tests := []struct {
+		name  string
+		body  string
+		limit int
+		want  []string
+	}{
+		{"user IDs", "hey @alice and @carol", 10, []string{"alice", "carol"}},
+		{"email name", "ping @bob.smith.", 10, []string{"42"}},
+		{"case and punctuation", "@ALICE, @Zoë!", 10, []string{"alice", "zoë"}},
+		{"user ID wins over email name", "@alice", 10, []string{"alice"}},
+		{"duplicates", "@alice @alice @Alice", 10, []string{"alice"}},
+		{"email addresses are not mentions", "mail alice@example.com or x@alice", 10, nil},
+		{"not on the roster", "@dave", 10, nil},
+		{"limit", "@alice @carol @zoë", 2, []string{"alice", "carol"}},
+		{"JSON body", `{"text":"@carol 🎉 ship it"}`, 10, []string{"carol"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMentions(tt.body, roster, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandleSendMessage_Mentions(t *testing.T) {
+	setupTestAppConfig()
+	teamMentions = newMentionNotifier([]string{"userMessage"}, 20)
+	t.Cleanup(func() { teamMentions = nil })
+
+	hub := newHub()
+	newMember := func(userID string) *Client {
+		return &Client{hub: hub, teamID: "team-1", userID: userID, send: make(chan outboundMessage, 4)}
+	}
+	alice, bob, carol := newMember("alice"), newMember("bob"), newMember("carol")
+	// Carol has muted chat messages.
+	filter, err := compileFilter(&SubscriptionFilter{MessageTypes: []string{"alert"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	carol.filter = filter
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{
+		"alice": {alice: {}},
+		"bob":   {bob: {}},
+		"carol": {carol: {}},
+	}
+
+	send := func(body string) map[string]any {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		var response map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("unexpected response %d %s", rr.Code, rr.Body.String())
+		}
+		return response
+	}
+
+	response := send(`{"target_team_id":"team-1","sender_user_id":"alice","message_type":"userMessage","body":"@alice @carol lunch?","broadcast":true}`)
+	if response["delivered"] != float64(2) || response["mentioned"] != float64(1) {
+		t.Fatalf("expected 2 deliveries and 1 mention, got %v", response)
+	}
+	if len(alice.send) != 1 || len(bob.send) != 1 {
+		t.Fatalf("expected the broadcast alone for alice and bob, got %d and %d", len(alice.send), len(bob.send))
+	}
+	if len(carol.send) != 1 {
+		t.Fatalf("expected only the mention for carol, got %d messages", len(carol.send))
+	}
+	var mention Message
+	if err := json.Unmarshal((<-carol.send).payload, &mention); err != nil {
+		t.Fatal(err)
+	}
+	var body mentionBody
+	if err := json.Unmarshal([]byte(mention.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if mention.MessageType != mentionMessageType || mention.Priority != priorityHigh || mention.TargetUserID != "carol" || mention.SenderUserID != "alice" ||
+		body.MessageType != "userMessage" || body.Body != "@alice @carol lunch?" {
+		t.Fatalf("unexpected mention %+v with body %+v", mention, body)
+	}
+
+	// Other message types are not searched for mentions.
+	if response := send(`{"target_team_id":"team-1","message_type":"alert","body":"@bob","broadcast":true}`); response["mentioned"] != nil {
+		t.Fatalf("expected no mentions, got %v", response)
+	}
+}
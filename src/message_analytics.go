@@ -0,0 +1,260 @@
+// message_analytics.go
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type messageRollupKey struct {
+	tenant      string
+	team        string // as clients know it, without the tenant prefix
+	messageType string
+	start       int64
+}
+
+// messageRecorder counts accepted messages and their size per tenant, team,
+// message type and hour, which flush writes to the store. Open hours are kept
+// in memory until they end and have been flushed.
+type messageRecorder struct {
+	instance string
+
+	mu      sync.Mutex
+	rollups map[messageRollupKey]*MessageRollup
+	dirty   map[messageRollupKey]bool
+}
+
+// messageHistory is nil unless analytics.messages is set, and all methods
+// are nil-safe.
+var messageHistory *messageRecorder
+
+func newMessageRecorder(instance string) *messageRecorder {
+	return &messageRecorder{
+		instance: instance,
+		rollups:  make(map[messageRollupKey]*MessageRollup),
+		dirty:    make(map[messageRollupKey]bool),
+	}
+}
+
+// recordSend counts a message accepted by /send and the connections it was
+// written to. Deferred broadcasts count once, when they are accepted.
+func (m *messageRecorder) recordSend(message outboundMessage, delivered int, now time.Time) {
+	if m == nil {
+		return
+	}
+	start := presenceRollupStart(rollupHour, now)
+	key := messageRollupKey{
+		tenant:      message.tenantID,
+		team:        unscopedTeamID(message.tenantID, message.teamID),
+		messageType: message.messageType,
+		start:       start.Unix(),
+	}
+	size := int64(len(message.payload))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rollup, ok := m.rollups[key]
+	if !ok {
+		rollup = &MessageRollup{
+			Instance:    m.instance,
+			TenantID:    key.tenant,
+			TeamID:      key.team,
+			MessageType: key.messageType,
+			Start:       start,
+		}
+		m.rollups[key] = rollup
+	}
+	rollup.Messages++
+	rollup.Bytes += size
+	rollup.Deliveries += delivered
+	rollup.DeliveredBytes += size * int64(delivered)
+	m.dirty[key] = true
+}
+
+// flush saves the rollups that changed since the last flush, and forgets
+// those whose hour has ended once they are saved.
+func (m *messageRecorder) flush(ctx context.Context, store Store, now time.Time) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	pending := make(map[messageRollupKey]MessageRollup, len(m.dirty))
+	for key := range m.dirty {
+		rollup := *m.rollups[key]
+		rollup.UpdatedAt = now
+		pending[key] = rollup
+	}
+	m.dirty = make(map[messageRollupKey]bool)
+	m.mu.Unlock()
+
+	var firstErr error
+	for key, rollup := range pending {
+		rollup := rollup
+		if err := store.SaveMessageRollup(ctx, &rollup); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			m.mu.Lock()
+			m.dirty[key] = true // retried on the next flush
+			m.mu.Unlock()
+		}
+	}
+
+	m.mu.Lock()
+	for key, rollup := range m.rollups {
+		if !m.dirty[key] && !presenceRollupEnd(rollupHour, rollup.Start).After(now) {
+			delete(m.rollups, key)
+		}
+	}
+	m.mu.Unlock()
+	return firstErr
+}
+
+// run flushes every interval until stop is closed, then flushes once more.
+func (m *messageRecorder) run(store Store, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	flush := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		if err := m.flush(ctx, store, time.Now()); err != nil {
+			slog.Error("Failed to save message rollups", "error", err)
+		}
+	}
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case <-stop:
+			flush()
+			return
+		}
+	}
+}
+
+// messageTraffic is the message and byte volume of a rollup row or a
+// message type's total in /admin/analytics/messages.
+type messageTraffic struct {
+	Messages       int   `json:"messages"`
+	Bytes          int64 `json:"bytes"`
+	Deliveries     int   `json:"deliveries"`
+	DeliveredBytes int64 `json:"deliveredBytes"`
+}
+
+func (t *messageTraffic) add(rollup *MessageRollup) {
+	t.Messages += rollup.Messages
+	t.Bytes += rollup.Bytes
+	t.Deliveries += rollup.Deliveries
+	t.DeliveredBytes += rollup.DeliveredBytes
+}
+
+// messageRollupView is one team's traffic of one message type in one hour,
+// summed over the instances that recorded it.
+type messageRollupView struct {
+	TenantID    string    `json:"tenantId,omitempty"`
+	TeamID      string    `json:"teamId"`
+	MessageType string    `json:"messageType"`
+	Start       time.Time `json:"start"`
+	messageTraffic
+}
+
+// messageTypeTotal is a message type's traffic over the whole range.
+type messageTypeTotal struct {
+	MessageType string `json:"messageType"`
+	messageTraffic
+}
+
+// mergeMessageRollups sums the instances' rollups of each hour, team and
+// message type, and totals each message type over all of them, largest
+// byte volume first.
+func mergeMessageRollups(rollups []*MessageRollup) ([]messageRollupView, []messageTypeTotal) {
+	views := make([]messageRollupView, 0, len(rollups))
+	index := make(map[messageRollupKey]int)
+	totals := make(map[string]*messageTypeTotal)
+	for _, rollup := range rollups {
+		key := messageRollupKey{tenant: rollup.TenantID, team: rollup.TeamID, messageType: rollup.MessageType, start: rollup.Start.Unix()}
+		i, ok := index[key]
+		if !ok {
+			i = len(views)
+			index[key] = i
+			views = append(views, messageRollupView{TenantID: rollup.TenantID, TeamID: rollup.TeamID, MessageType: rollup.MessageType, Start: rollup.Start.UTC()})
+		}
+		views[i].add(rollup)
+
+		total, ok := totals[rollup.MessageType]
+		if !ok {
+			total = &messageTypeTotal{MessageType: rollup.MessageType}
+			totals[rollup.MessageType] = total
+		}
+		total.add(rollup)
+	}
+
+	byType := make([]messageTypeTotal, 0, len(totals))
+	for _, total := range totals {
+		byType = append(byType, *total)
+	}
+	sort.Slice(byType, func(i, j int) bool {
+		if byType[i].Bytes != byType[j].Bytes {
+			return byType[i].Bytes > byType[j].Bytes
+		}
+		return byType[i].MessageType < byType[j].MessageType
+	})
+	return views, byType
+}
+
+// handleAdminMessageAnalytics serves GET /admin/analytics/messages: hourly
+// message counts and byte volumes per team and message type. from and to
+// are RFC 3339 times, defaulting to the last day, and tenant_id, team_id and
+// message_type narrow the result.
+func handleAdminMessageAnalytics(recorder *messageRecorder, store Store, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if recorder == nil {
+		http.Error(w, "Message analytics are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	now := time.Now()
+	from, to, err := analyticsRange(query, now, 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenantID := strings.TrimSpace(query.Get("tenant_id"))
+	teamID := strings.TrimSpace(query.Get("team_id"))
+	messageType := strings.TrimSpace(query.Get("message_type"))
+
+	// Include what this instance has recorded since its last flush.
+	if err := recorder.flush(r.Context(), store, now); err != nil {
+		slog.Error("Failed to save message rollups", "error", err)
+	}
+	from = presenceRollupStart(rollupHour, from)
+	rollups, err := store.MessageRollups(r.Context(), from, to)
+	if err != nil {
+		slog.Error("Failed to read message rollups", "error", err)
+		http.Error(w, "Failed to read message rollups", http.StatusInternalServerError)
+		return
+	}
+	filtered := rollups[:0]
+	for _, rollup := range rollups {
+		if rollup.TenantID == tenantID && (teamID == "" || rollup.TeamID == teamID) && (messageType == "" || rollup.MessageType == messageType) {
+			filtered = append(filtered, rollup)
+		}
+	}
+
+	views, byType := mergeMessageRollups(filtered)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"from":    from,
+		"to":      to.UTC(),
+		"rollups": views,
+		"types":   byType,
+	})
+}
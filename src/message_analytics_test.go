@@ -0,0 +1,100 @@
+// message_analytics_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMessageRecorder_Rollups(t *testing.T) {
+	store := newMemoryStore()
+	recorder := newMessageRecorder("node-1")
+	base := time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	scoped, _ := scopeTeam("acme", "team-a")
+	recorder.recordSend(outboundMessage{payload: make([]byte, 100), teamID: "team-a", messageType: "chat"}, 3, base.Add(time.Minute))
+	recorder.recordSend(outboundMessage{payload: make([]byte, 50), teamID: "team-a", messageType: "chat"}, 0, base.Add(2*time.Minute))
+	recorder.recordSend(outboundMessage{payload: make([]byte, 10), tenantID: "acme", teamID: scoped, messageType: "chat"}, 1, base.Add(3*time.Minute))
+	recorder.recordSend(outboundMessage{payload: make([]byte, 20), teamID: "team-a", messageType: "chat"}, 1, base.Add(61*time.Minute))
+	if err := recorder.flush(ctx, store, base.Add(65*time.Minute)); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	rollups, _ := store.MessageRollups(ctx, base, base.Add(2*time.Hour))
+	if len(rollups) != 3 {
+		t.Fatalf("expected three rollups, got %+v", rollups)
+	}
+	first := rollups[0]
+	if first.TenantID != "" || first.TeamID != "team-a" || first.Messages != 2 || first.Bytes != 150 || first.Deliveries != 3 || first.DeliveredBytes != 300 {
+		t.Errorf("unexpected first hour: %+v", first)
+	}
+	if rollups[1].TenantID != "acme" || rollups[1].TeamID != "team-a" {
+		t.Errorf("expected the tenant's team without its prefix, got %+v", rollups[1])
+	}
+	if !rollups[2].Start.Equal(base.Add(time.Hour)) || rollups[2].Messages != 1 {
+		t.Errorf("unexpected second hour: %+v", rollups[2])
+	}
+
+	recorder.mu.Lock()
+	open := len(recorder.rollups)
+	recorder.mu.Unlock()
+	if open != 1 {
+		t.Errorf("expected only the current hour to stay open, got %d", open)
+	}
+}
+
+func TestHandleAdminMessageAnalytics(t *testing.T) {
+	setupTestAppConfig()
+	recorder := newMessageRecorder("node-1")
+	store := newMemoryStore()
+	ctx := context.Background()
+
+	start := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	for _, rollup := range []*MessageRollup{
+		{Instance: "node-1", TeamID: "team-a", MessageType: "chat", Start: start, Messages: 3, Bytes: 300, Deliveries: 6, DeliveredBytes: 600},
+		{Instance: "node-2", TeamID: "team-a", MessageType: "chat", Start: start, Messages: 1, Bytes: 100, Deliveries: 1, DeliveredBytes: 100},
+		{Instance: "node-1", TeamID: "team-b", MessageType: "alert", Start: start, Messages: 10, Bytes: 5000},
+		{Instance: "node-1", TenantID: "acme", TeamID: "team-a", MessageType: "chat", Start: start, Messages: 9},
+	} {
+		store.SaveMessageRollup(ctx, rollup)
+	}
+	recorder.recordSend(outboundMessage{payload: make([]byte, 40), teamID: "team-b", messageType: "chat"}, 2, time.Now())
+
+	rr := httptest.NewRecorder()
+	handleAdminMessageAnalytics(recorder, store, rr, httptest.NewRequest("GET", "/admin/analytics/messages", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Rollups []messageRollupView `json:"rollups"`
+		Types   []messageTypeTotal  `json:"types"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Rollups) != 3 {
+		t.Fatalf("expected the merged rollups and the unflushed send, got %+v", response.Rollups)
+	}
+	if merged := response.Rollups[0]; merged.TeamID != "team-a" || merged.Messages != 4 || merged.Bytes != 400 || merged.DeliveredBytes != 700 {
+		t.Errorf("unexpected merged rollup: %+v", merged)
+	}
+	if len(response.Types) != 2 || response.Types[0].MessageType != "alert" || response.Types[1].Messages != 5 || response.Types[1].Bytes != 440 {
+		t.Errorf("expected message types by byte volume, got %+v", response.Types)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminMessageAnalytics(recorder, store, rr, httptest.NewRequest("GET", "/admin/analytics/messages?tenant_id=acme&message_type=chat", nil))
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Rollups) != 1 || response.Rollups[0].Messages != 9 {
+		t.Errorf("expected acme's chat rollup only, got %+v", response.Rollups)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminMessageAnalytics(recorder, store, rr, httptest.NewRequest("GET", "/admin/analytics/messages?from=yesterday", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid from, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,141 @@
+// message_archive.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// archiveBuffer is how many sampled messages may wait to be saved before
+// further ones are dropped.
+const archiveBuffer = 256
+
+// archiveSampleScale is the resolution of archive.sample_rate.
+const archiveSampleScale = 1_000_000
+
+// messageArchiver keeps a sample of the messages accepted by /send in the
+// store, with how they were routed, so reports of missing or malformed
+// notifications can be looked into by notification ID. Saving happens off
+// the request path.
+type messageArchiver struct {
+	threshold uint64
+	ttl       time.Duration
+	entries   chan *ArchivedMessage
+}
+
+// payloadArchive is nil unless archive.sample_rate is set, and all methods
+// are nil-safe.
+var payloadArchive *messageArchiver
+
+func newMessageArchiver(sampleRate float64, ttl time.Duration) *messageArchiver {
+	return &messageArchiver{
+		threshold: uint64(sampleRate * archiveSampleScale),
+		ttl:       ttl,
+		entries:   make(chan *ArchivedMessage, archiveBuffer),
+	}
+}
+
+// sampled reports whether the notification is archived. The choice depends
+// only on the ID, so every instance archives the same notifications.
+func (a *messageArchiver) sampled(notificationID string) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(notificationID))
+	return hash.Sum64()%archiveSampleScale < a.threshold
+}
+
+// record archives a send if it is sampled. Sends without a notification ID
+// cannot be looked up and are never archived.
+func (a *messageArchiver) record(r *http.Request, req *MessageRequest, message outboundMessage, outcome string, recipients int) {
+	if a == nil || message.notificationID == "" || !a.sampled(message.notificationID) {
+		return
+	}
+	now := time.Now()
+	entry := &ArchivedMessage{
+		NotificationID: message.notificationID,
+		TenantID:       message.tenantID,
+		TeamID:         req.TargetTeamID,
+		UserID:         req.TargetUserID,
+		MessageType:    message.messageType,
+		Broadcast:      req.Broadcast,
+		ReplacesID:     req.ReplacesID,
+		Outcome:        outcome,
+		Recipients:     recipients,
+		RemoteAddr:     clientIPFromRequest(r),
+		Payload:        string(message.payload),
+		ReceivedAt:     message.receivedAt,
+		ArchivedAt:     now,
+		ExpiresAt:      now.Add(a.ttl),
+	}
+	select {
+	case a.entries <- entry:
+	default:
+		appMetrics.Count("archive.dropped", 1)
+	}
+}
+
+// run saves sampled messages until stop is closed.
+func (a *messageArchiver) run(store Store, stop <-chan struct{}) {
+	for {
+		select {
+		case entry := <-a.entries:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := store.ArchiveMessage(ctx, entry)
+			cancel()
+			if err != nil {
+				slog.Error("Failed to archive a notification", "notification", entry.NotificationID, "error", err)
+				continue
+			}
+			appMetrics.Count("archive.saved", 1, tenantTags(entry.TenantID)...)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// archivedMessageView renders an archived message with its payload as JSON
+// rather than as a string.
+type archivedMessageView struct {
+	*ArchivedMessage
+	Payload json.RawMessage `json:"payload"`
+}
+
+// handleAdminArchive serves GET /admin/archive?notification_id=: every
+// archived send of the notification, oldest first. Archived messages stay
+// readable after sampling is turned off, until they expire.
+func handleAdminArchive(store Store, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	notificationID := strings.TrimSpace(r.URL.Query().Get("notification_id"))
+	if notificationID == "" {
+		http.Error(w, "notification_id is required", http.StatusBadRequest)
+		return
+	}
+
+	messages, err := store.ArchivedMessages(r.Context(), notificationID)
+	if err != nil {
+		slog.Error("Failed to read archived messages", "error", err)
+		http.Error(w, "Failed to read archived messages", http.StatusInternalServerError)
+		return
+	}
+	views := make([]archivedMessageView, 0, len(messages))
+	for _, message := range messages {
+		view := archivedMessageView{ArchivedMessage: message, Payload: json.RawMessage(message.Payload)}
+		if !json.Valid(view.Payload) {
+			// Payloads are JSON; anything else is shown as a string
+			// rather than failing the response.
+			view.Payload, _ = json.Marshal(message.Payload)
+		}
+		views = append(views, view)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"notificationId": notificationID,
+		"messages":       views,
+	})
+}
@@ -0,0 +1,87 @@
+// message_archive_test.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMessageArchiver_Sampling(t *testing.T) {
+	all, none, some := newMessageArchiver(1, time.Hour), newMessageArchiver(0, time.Hour), newMessageArchiver(0.1, time.Hour)
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		id := fmt.Sprintf("n-%d", i)
+		if !all.sampled(id) || none.sampled(id) {
+			t.Fatalf("expected rate 1 to sample and rate 0 to skip %s", id)
+		}
+		if some.sampled(id) {
+			sampled++
+			if !newMessageArchiver(0.1, time.Hour).sampled(id) {
+				t.Fatalf("expected %s to be sampled by every archiver", id)
+			}
+		}
+	}
+	if sampled < 800 || sampled > 1200 {
+		t.Errorf("expected about 10%% of notifications sampled, got %d", sampled)
+	}
+}
+
+func TestMessageArchiver_RecordAndLookup(t *testing.T) {
+	setupTestAppConfig()
+	store := newMemoryStore()
+	archiver := newMessageArchiver(1, time.Hour)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		archiver.run(store, stop)
+		close(done)
+	}()
+
+	req := httptest.NewRequest("POST", "/send", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	send := &MessageRequest{NotificationID: "n-1", TargetTeamID: "team-a", TargetUserID: "user-1", MessageType: "chat"}
+	message := outboundMessage{payload: []byte(`{"notificationId":"n-1","body":"hi"}`), notificationID: "n-1", teamID: "team-a", messageType: "chat", receivedAt: time.Now()}
+	archiver.record(req, send, message, firehoseRouted, 2)
+	archiver.record(req, &MessageRequest{}, outboundMessage{payload: []byte(`{}`)}, firehoseUnrouted, 0) // without an ID
+
+	var archived []*ArchivedMessage
+	for deadline := time.Now().Add(time.Second); len(archived) == 0 && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		archived, _ = store.ArchivedMessages(req.Context(), "n-1")
+	}
+	close(stop)
+	<-done
+	if len(archived) != 1 || archived[0].RemoteAddr != "203.0.113.7" || archived[0].Recipients != 2 || archived[0].UserID != "user-1" {
+		t.Fatalf("unexpected archive: %+v", archived)
+	}
+	if archived[0].ExpiresAt.Sub(archived[0].ArchivedAt) != time.Hour {
+		t.Errorf("expected the entry to expire after the TTL, got %+v", archived[0])
+	}
+
+	rr := httptest.NewRecorder()
+	handleAdminArchive(store, rr, httptest.NewRequest("GET", "/admin/archive?notification_id=n-1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Messages []struct {
+			Outcome string          `json:"outcome"`
+			Payload json.RawMessage `json:"payload"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if len(response.Messages) != 1 || response.Messages[0].Outcome != firehoseRouted || string(response.Messages[0].Payload) != `{"notificationId":"n-1","body":"hi"}` {
+		t.Errorf("expected the payload as JSON, got %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminArchive(store, rr, httptest.NewRequest("GET", "/admin/archive", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a notification ID, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,133 @@
+// metrics.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsEmitter abstracts the metrics backend so handlers and the hub can
+// record counters, gauges and timings without knowing where they end up.
+type metricsEmitter interface {
+	Count(name string, value int64, tags ...string)
+	Gauge(name string, value float64, tags ...string)
+	Timing(name string, d time.Duration, tags ...string)
+	Close() error
+}
+
+// appMetrics is the process-wide emitter. It defaults to a no-op so tests and
+// partially initialized servers never have to nil-check it.
+var appMetrics metricsEmitter = noopMetrics{}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Count(string, int64, ...string)          {}
+func (noopMetrics) Gauge(string, float64, ...string)        {}
+func (noopMetrics) Timing(string, time.Duration, ...string) {}
+func (noopMetrics) Close() error                            { return nil }
+
+// statsdEmitter writes StatsD lines over UDP. When dogstatsd is true, tags are
+// appended using the DogStatsD `|#tag1,tag2` extension; plain StatsD has no
+// notion of tags so they are dropped.
+type statsdEmitter struct {
+	conn       net.Conn
+	prefix     string
+	globalTags []string
+	dogstatsd  bool
+}
+
+func newStatsdEmitter(address, prefix string, tags []string, dogstatsd bool) (*statsdEmitter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial statsd at %s: %v", address, err)
+	}
+
+	prefix = strings.Trim(strings.TrimSpace(prefix), ".")
+	if prefix != "" {
+		prefix += "."
+	}
+
+	return &statsdEmitter{
+		conn:       conn,
+		prefix:     prefix,
+		globalTags: tags,
+		dogstatsd:  dogstatsd,
+	}, nil
+}
+
+func (s *statsdEmitter) Count(name string, value int64, tags ...string) {
+	s.write(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+func (s *statsdEmitter) Gauge(name string, value float64, tags ...string) {
+	s.write(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+func (s *statsdEmitter) Timing(name string, d time.Duration, tags ...string) {
+	s.write(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+func (s *statsdEmitter) Close() error {
+	return s.conn.Close()
+}
+
+func (s *statsdEmitter) format(name, value, kind string, tags []string) string {
+	var line strings.Builder
+	line.WriteString(s.prefix)
+	line.WriteString(name)
+	line.WriteByte(':')
+	line.WriteString(value)
+	line.WriteByte('|')
+	line.WriteString(kind)
+
+	if s.dogstatsd && len(s.globalTags)+len(tags) > 0 {
+		line.WriteString("|#")
+		line.WriteString(strings.Join(append(append([]string{}, s.globalTags...), tags...), ","))
+	}
+	return line.String()
+}
+
+func (s *statsdEmitter) write(name, value, kind string, tags []string) {
+	// UDP writes are fire-and-forget; a missing collector must never affect delivery.
+	_, _ = s.conn.Write([]byte(s.format(name, value, kind, tags)))
+}
+
+// newMetricsEmitter builds the emitter selected by metrics.backend.
+func newMetricsEmitter(config *Config) (metricsEmitter, error) {
+	switch config.Metrics.Backend {
+	case "statsd", "dogstatsd":
+		return newStatsdEmitter(
+			config.Metrics.Address,
+			config.Metrics.Prefix,
+			config.Metrics.Tags,
+			config.Metrics.Backend == "dogstatsd",
+		)
+	default:
+		return noopMetrics{}, nil
+	}
+}
+
+// metricTag renders a key:value tag.
+func metricTag(key, value string) string {
+	return key + ":" + value
+}
+
+// reportHubMetrics periodically publishes hub gauges until stop is closed.
+func reportHubMetrics(hub *Hub, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			health := hub.healthCheck()
+			appMetrics.Gauge("clients.connected", float64(health.TotalClients))
+			appMetrics.Gauge("teams.active", float64(health.TotalTeams))
+		case <-stop:
+			return
+		}
+	}
+}
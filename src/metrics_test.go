@@ -0,0 +1,67 @@
+// metrics_test.go
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsdEmitter_Format(t *testing.T) {
+	testCases := []struct {
+		name      string
+		dogstatsd bool
+		expected  string
+	}{
+		{
+			name:      "plain statsd drops tags",
+			dogstatsd: false,
+			expected:  "notification_server.messages.delivered:3|c",
+		},
+		{
+			name:      "dogstatsd appends global and call tags",
+			dogstatsd: true,
+			expected:  "notification_server.messages.delivered:3|c|#env:test,message_type:system_alert",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to listen for udp: %v", err)
+			}
+			defer listener.Close()
+
+			emitter, err := newStatsdEmitter(listener.LocalAddr().String(), "notification_server.", []string{"env:test"}, tc.dogstatsd)
+			if err != nil {
+				t.Fatalf("failed to create emitter: %v", err)
+			}
+			defer emitter.Close()
+
+			emitter.Count("messages.delivered", 3, metricTag("message_type", "system_alert"))
+
+			buf := make([]byte, 512)
+			listener.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := listener.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("failed to read statsd packet: %v", err)
+			}
+			if got := string(buf[:n]); got != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewMetricsEmitter_DefaultsToNoop(t *testing.T) {
+	setupTestAppConfig()
+
+	emitter, err := newMetricsEmitter(AppConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := emitter.(noopMetrics); !ok {
+		t.Fatalf("expected noop emitter for backend %q, got %T", AppConfig().Metrics.Backend, emitter)
+	}
+}
@@ -0,0 +1,243 @@
+// migrate.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles are the schema migrations, NNNN_description.sql. Each file
+// is split on ";" into statements run one by one, so a migration must not
+// have a ";" inside a string literal or a trigger or function body.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads the embedded NNNN_name.sql files in version order.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		prefix, _, ok := strings.Cut(entry.Name(), "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s must be named NNNN_description.sql", entry.Name())
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s has an invalid version prefix", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: entry.Name(), sql: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// migrator applies embedded migrations to a SQL store. Instances coordinate
+// through a single-row schema_lock table so only one of several servers
+// starting at once changes the schema; the others wait for it to finish.
+type migrator struct {
+	db        *sql.DB
+	rebind    func(string) string
+	owner     string
+	lockWait  time.Duration
+	lockRetry time.Duration
+	staleLock time.Duration
+}
+
+func newMigrator(db *sql.DB, rebind func(string) string, lockWait time.Duration) *migrator {
+	hostname, _ := os.Hostname()
+	return &migrator{
+		db:        db,
+		rebind:    rebind,
+		owner:     fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		lockWait:  lockWait,
+		lockRetry: time.Second,
+		staleLock: 10 * time.Minute,
+	}
+}
+
+const (
+	createSchemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at BIGINT NOT NULL
+)`
+	createSchemaLockTable = `CREATE TABLE IF NOT EXISTS schema_lock (
+	id INTEGER NOT NULL PRIMARY KEY,
+	owner VARCHAR(255) NOT NULL,
+	locked_at BIGINT NOT NULL
+)`
+)
+
+// Migrate applies all pending migrations and returns how many were applied.
+func (m *migrator) Migrate(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, statement := range []string{createSchemaMigrationsTable, createSchemaLockTable} {
+		if _, err := m.db.ExecContext(ctx, statement); err != nil {
+			return 0, fmt.Errorf("failed to prepare migration tables: %v", err)
+		}
+	}
+
+	if err := m.acquireLock(ctx); err != nil {
+		return 0, err
+	}
+	defer m.releaseLock()
+
+	current, err := m.currentVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if latest := migrations[len(migrations)-1].version; current > latest {
+		return 0, fmt.Errorf("database schema version %d is newer than this build supports (%d); refusing to run against it", current, latest)
+	}
+
+	applied := 0
+	for _, mig := range migrations {
+		if mig.version <= current {
+			continue
+		}
+		if err := m.apply(ctx, mig); err != nil {
+			return applied, fmt.Errorf("migration %s failed: %v", mig.name, err)
+		}
+		slog.Info("Applied migration", "migration", mig.name)
+		applied++
+	}
+	return applied, nil
+}
+
+func (m *migrator) currentVersion(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	if err := m.db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return int(version.Int64), nil
+}
+
+// apply runs a migration and records its version in one transaction, so a
+// migration that fails part way leaves neither its changes nor its version.
+func (m *migrator) apply(ctx context.Context, mig migration) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range strings.Split(mig.sql, ";") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		m.rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+		mig.version, mig.name, time.Now().UnixMilli(),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// acquireLock inserts the single schema_lock row, retrying until lockWait
+// elapses. A lock older than staleLock is assumed to belong to a crashed
+// instance and is broken.
+func (m *migrator) acquireLock(ctx context.Context) error {
+	deadline := time.Now().Add(m.lockWait)
+	for {
+		_, err := m.db.ExecContext(ctx,
+			m.rebind(`INSERT INTO schema_lock (id, owner, locked_at) VALUES (1, ?, ?)`),
+			m.owner, time.Now().UnixMilli(),
+		)
+		if err == nil {
+			return nil
+		}
+
+		var holder string
+		var lockedAt int64
+		if scanErr := m.db.QueryRowContext(ctx, `SELECT owner, locked_at FROM schema_lock WHERE id = 1`).Scan(&holder, &lockedAt); scanErr == nil {
+			if time.Since(time.UnixMilli(lockedAt)) > m.staleLock {
+				slog.Warn("Breaking a stale schema lock", "holder", holder)
+				_, err := m.db.ExecContext(ctx, m.rebind(`DELETE FROM schema_lock WHERE id = 1 AND owner = ?`), holder)
+				if err == nil {
+					continue
+				}
+				// The lock may still be held, so wait and try again.
+				slog.Error("Failed to break a stale schema lock", "holder", holder, "error", err)
+			}
+		} else if scanErr != sql.ErrNoRows {
+			return fmt.Errorf("failed to acquire schema lock: %v", err)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for schema lock held by %s", holder)
+		}
+		slog.Info("Waiting for the schema lock", "holder", holder)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.lockRetry):
+		}
+	}
+}
+
+func (m *migrator) releaseLock() {
+	if _, err := m.db.Exec(m.rebind(`DELETE FROM schema_lock WHERE id = 1 AND owner = ?`), m.owner); err != nil {
+		slog.Error("Failed to release the schema lock", "error", err)
+	}
+}
+
+// runMigrationsOnly implements --migrate-only: apply migrations regardless of
+// storage.sql.skip_migrations and exit without starting listeners.
+func runMigrationsOnly(config *Config) {
+	if config.Storage.Driver != "sql" {
+		slog.Info("Storage driver has no schema to migrate", "driver", config.Storage.Driver)
+		return
+	}
+
+	store, err := newSQLStore(config.Storage.SQL.Driver, config.Storage.SQL.DSN, false)
+	if err != nil {
+		fatal("Failed to open the sql store", "error", err)
+	}
+	defer store.Close()
+
+	applied, err := store.Migrate(context.Background())
+	if err != nil {
+		fatal("Migration failed", "error", err)
+	}
+	slog.Info("Migrations complete", "applied", applied)
+}
@@ -0,0 +1,294 @@
+// migrate_test.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadMigrations_OrderedAndVersioned(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations returned error: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("expected embedded migrations")
+	}
+
+	for i, mig := range migrations {
+		if mig.version != i+1 {
+			t.Fatalf("expected contiguous versions starting at 1, got %d at position %d (%s)", mig.version, i, mig.name)
+		}
+		if mig.sql == "" {
+			t.Fatalf("migration %s is empty", mig.name)
+		}
+	}
+}
+
+// fakeSchemaDB is a database/sql driver that understands just the
+// migrator's own queries. It keeps the schema_migrations versions and the
+// schema_lock row, and records the migration statements committed.
+type fakeSchemaDB struct {
+	mu        sync.Mutex
+	versions  map[int]string
+	lockOwner string
+	lockedAt  int64
+	executed  []string
+	failOn    string // a migration statement containing it fails
+	deleteErr error  // returned when deleting the schema_lock row
+}
+
+func newFakeSchemaDB() *fakeSchemaDB {
+	return &fakeSchemaDB{versions: make(map[int]string)}
+}
+
+func (f *fakeSchemaDB) open() *sql.DB { return sql.OpenDB(f) }
+
+func (f *fakeSchemaDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeSchemaConn{db: f}, nil
+}
+func (f *fakeSchemaDB) Driver() driver.Driver { return nil }
+
+func (f *fakeSchemaDB) lock(owner string, lockedAt time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lockOwner, f.lockedAt = owner, lockedAt.UnixMilli()
+}
+
+func (f *fakeSchemaDB) holder() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lockOwner
+}
+
+type fakeSchemaConn struct {
+	db *fakeSchemaDB
+	tx *fakeSchemaTx
+}
+
+// fakeSchemaTx holds a transaction's changes until it commits.
+type fakeSchemaTx struct {
+	conn     *fakeSchemaConn
+	versions map[int]string
+	executed []string
+}
+
+func (c *fakeSchemaConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+func (c *fakeSchemaConn) Close() error { return nil }
+func (c *fakeSchemaConn) Begin() (driver.Tx, error) {
+	c.tx = &fakeSchemaTx{conn: c, versions: make(map[int]string)}
+	return c.tx, nil
+}
+
+func (tx *fakeSchemaTx) Commit() error {
+	db := tx.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for version, name := range tx.versions {
+		db.versions[version] = name
+	}
+	db.executed = append(db.executed, tx.executed...)
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeSchemaTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+func (c *fakeSchemaConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	query = strings.TrimSpace(query)
+
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_"):
+	case strings.HasPrefix(query, "INSERT INTO schema_lock"):
+		if db.lockOwner != "" {
+			return nil, errors.New("UNIQUE constraint failed: schema_lock.id")
+		}
+		db.lockOwner, db.lockedAt = args[0].Value.(string), args[1].Value.(int64)
+	case strings.HasPrefix(query, "DELETE FROM schema_lock"):
+		if db.deleteErr != nil {
+			return nil, db.deleteErr
+		}
+		if db.lockOwner == args[0].Value.(string) {
+			db.lockOwner = ""
+		}
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		c.tx.versions[int(args[0].Value.(int64))] = args[1].Value.(string)
+	default:
+		if db.failOn != "" && strings.Contains(query, db.failOn) {
+			return nil, errors.New("syntax error")
+		}
+		c.tx.executed = append(c.tx.executed, query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeSchemaConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "SELECT MAX(version) FROM schema_migrations"):
+		var latest driver.Value
+		for version := range db.versions {
+			if latest == nil || int64(version) > latest.(int64) {
+				latest = int64(version)
+			}
+		}
+		return &fakeSchemaRows{columns: []string{"max"}, rows: [][]driver.Value{{latest}}}, nil
+	case strings.HasPrefix(query, "SELECT owner, locked_at FROM schema_lock"):
+		rows := &fakeSchemaRows{columns: []string{"owner", "locked_at"}}
+		if db.lockOwner != "" {
+			rows.rows = [][]driver.Value{{db.lockOwner, db.lockedAt}}
+		}
+		return rows, nil
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+type fakeSchemaRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeSchemaRows) Columns() []string { return r.columns }
+func (r *fakeSchemaRows) Close() error      { return nil }
+func (r *fakeSchemaRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func newTestMigrator(db *fakeSchemaDB, lockWait time.Duration) *migrator {
+	m := newMigrator(db.open(), func(query string) string { return query }, lockWait)
+	m.lockRetry = 5 * time.Millisecond
+	return m
+}
+
+func TestMigrator_AppliesAndRecordsVersions(t *testing.T) {
+	migrations, _ := loadMigrations()
+	db := newFakeSchemaDB()
+	m := newTestMigrator(db, time.Second)
+
+	applied, err := m.Migrate(context.Background())
+	if err != nil || applied != len(migrations) {
+		t.Fatalf("expected %d migrations applied, got %d: %v", len(migrations), applied, err)
+	}
+	for _, mig := range migrations {
+		if db.versions[mig.version] != mig.name {
+			t.Fatalf("expected %s recorded as version %d, got %q", mig.name, mig.version, db.versions[mig.version])
+		}
+	}
+	if db.holder() != "" {
+		t.Fatalf("expected the lock to be released, held by %q", db.holder())
+	}
+
+	if applied, err := m.Migrate(context.Background()); err != nil || applied != 0 {
+		t.Fatalf("expected an up-to-date schema to apply nothing, got %d: %v", applied, err)
+	}
+}
+
+func TestMigrator_RefusesNewerSchema(t *testing.T) {
+	migrations, _ := loadMigrations()
+	db := newFakeSchemaDB()
+	db.versions[len(migrations)+1] = "9999_from_the_future.sql"
+
+	_, err := newTestMigrator(db, time.Second).Migrate(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "newer than this build supports") {
+		t.Fatalf("expected a newer schema to be refused, got %v", err)
+	}
+	if len(db.executed) != 0 || db.holder() != "" {
+		t.Fatalf("expected nothing applied and the lock released, got %d statements, lock %q", len(db.executed), db.holder())
+	}
+}
+
+func TestMigrator_LockContention(t *testing.T) {
+	db := newFakeSchemaDB()
+	db.lock("other:1", time.Now())
+
+	_, err := newTestMigrator(db, 30*time.Millisecond).Migrate(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "timed out waiting for schema lock held by other:1") {
+		t.Fatalf("expected to time out on a held lock, got %v", err)
+	}
+	if len(db.versions) != 0 || db.holder() != "other:1" {
+		t.Fatalf("expected nothing applied and the lock left to its holder, got %v, lock %q", db.versions, db.holder())
+	}
+
+	// The holder finishes while the migrator waits.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		db.lock("", time.Time{})
+	}()
+	if applied, err := newTestMigrator(db, time.Second).Migrate(context.Background()); err != nil || applied == 0 {
+		t.Fatalf("expected the migrations to run once the lock was freed, got %d: %v", applied, err)
+	}
+}
+
+func TestMigrator_BreaksStaleLock(t *testing.T) {
+	db := newFakeSchemaDB()
+	db.lock("crashed:1", time.Now().Add(-11*time.Minute))
+	db.deleteErr = errors.New("connection reset")
+
+	// A failed DELETE leaves the lock held, so the migrator keeps waiting.
+	_, err := newTestMigrator(db, 30*time.Millisecond).Migrate(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "timed out waiting for schema lock held by crashed:1") {
+		t.Fatalf("expected to keep waiting when the stale lock cannot be broken, got %v", err)
+	}
+
+	db.deleteErr = nil
+	if applied, err := newTestMigrator(db, 30*time.Millisecond).Migrate(context.Background()); err != nil || applied == 0 {
+		t.Fatalf("expected the stale lock to be broken, got %d: %v", applied, err)
+	}
+	if db.holder() != "" {
+		t.Fatalf("expected the lock to be released, held by %q", db.holder())
+	}
+}
+
+func TestMigrator_PartialFailureRollsBack(t *testing.T) {
+	migrations, _ := loadMigrations()
+	if len(migrations) < 2 {
+		t.Skip("needs at least two migrations")
+	}
+	failing := migrations[1]
+	db := newFakeSchemaDB()
+	db.failOn = strings.TrimSpace(strings.Split(failing.sql, ";")[0])
+
+	applied, err := newTestMigrator(db, time.Second).Migrate(context.Background())
+	if err == nil || !strings.Contains(err.Error(), failing.name) || applied != 1 {
+		t.Fatalf("expected %s to fail after one migration, got %d: %v", failing.name, applied, err)
+	}
+	if _, ok := db.versions[failing.version]; ok || len(db.versions) != 1 {
+		t.Fatalf("expected only the first version recorded, got %v", db.versions)
+	}
+	for _, statement := range db.executed {
+		if strings.Contains(failing.sql, statement) {
+			t.Fatalf("expected the failed migration's statements to be rolled back, found %q", statement)
+		}
+	}
+	if db.holder() != "" {
+		t.Fatalf("expected the lock to be released after the failure, held by %q", db.holder())
+	}
+
+	db.failOn = ""
+	if applied, err := newTestMigrator(db, time.Second).Migrate(context.Background()); err != nil || applied != len(migrations)-1 {
+		t.Fatalf("expected the rest to apply on the next run, got %d: %v", applied, err)
+	}
+}
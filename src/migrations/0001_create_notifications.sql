@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS notifications (
+	user_id VARCHAR(255) NOT NULL,
+	notification_id VARCHAR(255) NOT NULL,
+	payload TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL,
+	delivered_at BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, notification_id)
+);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_notifications_expires_at ON notifications (expires_at);
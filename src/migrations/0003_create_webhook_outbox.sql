@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+	job_id VARCHAR(255) NOT NULL PRIMARY KEY,
+	status VARCHAR(32) NOT NULL,
+	job TEXT NOT NULL,
+	created_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_status ON webhook_outbox (status, created_at);
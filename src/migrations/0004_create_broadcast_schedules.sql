@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS broadcast_schedules (
+	schedule_id VARCHAR(255) NOT NULL PRIMARY KEY,
+	schedule TEXT NOT NULL,
+	created_at BIGINT NOT NULL
+);
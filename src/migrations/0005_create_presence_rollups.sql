@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS presence_rollups (
+	period VARCHAR(16) NOT NULL,
+	period_start BIGINT NOT NULL,
+	instance VARCHAR(255) NOT NULL,
+	tenant_id VARCHAR(64) NOT NULL,
+	team_id VARCHAR(255) NOT NULL,
+	rollup TEXT NOT NULL,
+	PRIMARY KEY (period, period_start, instance, tenant_id, team_id)
+);
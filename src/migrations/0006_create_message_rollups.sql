@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS message_rollups (
+	period_start BIGINT NOT NULL,
+	instance VARCHAR(255) NOT NULL,
+	tenant_id VARCHAR(64) NOT NULL,
+	team_id VARCHAR(255) NOT NULL,
+	message_type VARCHAR(255) NOT NULL,
+	rollup TEXT NOT NULL,
+	PRIMARY KEY (period_start, instance, tenant_id, team_id, message_type)
+);
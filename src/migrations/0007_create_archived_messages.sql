@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS archived_messages (
+	notification_id VARCHAR(255) NOT NULL,
+	archived_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL,
+	entry TEXT NOT NULL,
+	PRIMARY KEY (notification_id, archived_at)
+);
+CREATE INDEX IF NOT EXISTS idx_archived_messages_expires_at ON archived_messages (expires_at);
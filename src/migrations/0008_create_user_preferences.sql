@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_preferences (
+	tenant_id VARCHAR(64) NOT NULL,
+	user_id VARCHAR(255) NOT NULL,
+	preferences TEXT NOT NULL,
+	PRIMARY KEY (tenant_id, user_id)
+);
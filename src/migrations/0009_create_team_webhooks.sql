@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS team_webhooks (
+	webhook_id VARCHAR(255) NOT NULL PRIMARY KEY,
+	webhook TEXT NOT NULL,
+	created_at BIGINT NOT NULL
+);
@@ -3,21 +3,222 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 )
 
 type AuthMessage struct {
-	Type   string `json:"type"`
-	UserID string `json:"userId"`
-	TeamID string `json:"teamId"`
-	Token  string `json:"token"`
+	Type     string              `json:"type"`
+	UserID   string              `json:"userId"`
+	TeamID   string              `json:"teamId"`
+	TenantID string              `json:"tenantId,omitempty"`
+	Token    string              `json:"token"`
+	Status   string              `json:"status,omitempty"` // Matched by status visibility rules; changed with the status.set request
+	Filters  *SubscriptionFilter `json:"filters,omitempty"`
+	Digest   *DigestSettings     `json:"digest,omitempty"`
+
+	// ResumeToken comes from a handover migrate frame and replays the
+	// messages held for this connection by the instance it left.
+	ResumeToken string `json:"resumeToken,omitempty"`
+
+	Capabilities *ClientCapabilities `json:"capabilities,omitempty"`
+}
+
+// ClientCapabilities are optional features a client declares in its auth
+// message. Anything left out keeps the default behavior.
+type ClientCapabilities struct {
+	SupportsBatching bool  `json:"supportsBatching,omitempty"`
+	SupportsBinary   *bool `json:"supportsBinary,omitempty"` // nil means true for msgpack.v1, false otherwise
+	SupportsAck      bool  `json:"supportsAck,omitempty"`
+	SupportsChunking bool  `json:"supportsChunking,omitempty"` // Receive notifications over maxFrameSize in chunks
+	MaxFrameSize     int   `json:"maxFrameSize,omitempty"`     // 0 is unlimited
+}
+
+// BatchFrame carries notifications that were queued back to back, for
+// clients that declared supportsBatching.
+type BatchFrame struct {
+	Type     string            `json:"type"`
+	Count    int               `json:"count"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+// AckFrame is sent by clients that declared supportsAck to acknowledge
+// notifications they have processed.
+type AckFrame struct {
+	Type            string   `json:"type"`
+	NotificationIDs []string `json:"notificationIds"`
+}
+
+// ReadReceiptFrame is sent by clients to mark a conversation read, up to
+// and including NotificationID or, without one, entirely.
+type ReadReceiptFrame struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversationId"`
+	NotificationID string `json:"notificationId,omitempty"`
+}
+
+// ChannelFrame subscribes a client to a channel of its team, or
+// unsubscribes it.
+type ChannelFrame struct {
+	Type    string `json:"type"` // subscribe or unsubscribe
+	Channel string `json:"channel"`
+}
+
+// ChannelSubscriptionsFrame answers a ChannelFrame with the channels the
+// connection is subscribed to afterwards.
+type ChannelSubscriptionsFrame struct {
+	Type     string   `json:"type"` // subscribed or unsubscribed
+	Channel  string   `json:"channel"`
+	Channels []string `json:"channels"`
+	Error    string   `json:"error,omitempty"` // why a subscribe was refused
+}
+
+// SyncEventFrame reports that a user acted on a notification on one of
+// their devices. A client sends it without ConnectionID and Timestamp; the
+// server fills them in and relays it to the user's other connections.
+type SyncEventFrame struct {
+	Type           string `json:"type"`   // always "syncEvent"
+	Action         string `json:"action"` // read or dismissed
+	NotificationID string `json:"notificationId,omitempty"`
+	ConversationID string `json:"conversationId,omitempty"`
+	ConnectionID   string `json:"connectionId,omitempty"` // where the action was taken
+	Timestamp      int64  `json:"timestamp,omitempty"`
+}
+
+// ClientRequestFrame asks the server for something over the socket. The
+// answer is a ClientResponseFrame with the same RequestID.
+type ClientRequestFrame struct {
+	Type      string          `json:"type"` // always "request"
+	RequestID string          `json:"requestId"`
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params,omitempty"`
+}
+
+// ClientResponseFrame answers a ClientRequestFrame. Error is set instead of
+// Result when OK is false.
+type ClientResponseFrame struct {
+	Type      string      `json:"type"` // always "response"
+	RequestID string      `json:"requestId"`
+	OK        bool        `json:"ok"`
+	Result    interface{} `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// PreferencesSnapshotFrame carries all of a user's synced preferences. It
+// follows authSuccess when preference sync is enabled.
+type PreferencesSnapshotFrame struct {
+	Type        string                     `json:"type"` // always "preferencesSnapshot"
+	Version     int64                      `json:"version"`
+	Preferences map[string]json.RawMessage `json:"preferences"`
+}
+
+// PreferencesDeltaFrame is pushed to every connection of a user whose
+// preferences changed. It applies to a client holding FromVersion; a client
+// holding another version asks for a snapshot with preferences.get instead.
+type PreferencesDeltaFrame struct {
+	Type        string                     `json:"type"` // always "preferencesDelta"
+	FromVersion int64                      `json:"fromVersion"`
+	Version     int64                      `json:"version"`
+	Set         map[string]json.RawMessage `json:"set,omitempty"`
+	Removed     []string                   `json:"removed,omitempty"`
+}
+
+// FrameTooLargeNotice replaces a notification that does not fit in the
+// maxFrameSize of a client that cannot receive chunks.
+type FrameTooLargeNotice struct {
+	Type           string `json:"type"`
+	NotificationID string `json:"notificationId,omitempty"`
+	MessageType    string `json:"messageType"`
+	Size           int    `json:"size"`
+	MaxFrameSize   int    `json:"maxFrameSize"`
+	Message        string `json:"message"`
+}
+
+// ChunkStartFrame opens a chunked transfer of one notification frame that
+// exceeds the client's maxFrameSize. Chunks follow in order and carry the
+// frame's bytes, base64 encoded; chunkEnd closes the transfer.
+type ChunkStartFrame struct {
+	Type           string `json:"type"`
+	TransferID     string `json:"transferId"`
+	NotificationID string `json:"notificationId,omitempty"`
+	MessageType    string `json:"messageType"`
+	Size           int    `json:"size"`
+	Chunks         int    `json:"chunks"`
+	Encoding       string `json:"encoding"`
+}
+
+type ChunkFrame struct {
+	Type       string `json:"type"`
+	TransferID string `json:"transferId"`
+	Index      int    `json:"index"`
+	Data       string `json:"data"`
+}
+
+type ChunkEndFrame struct {
+	Type       string `json:"type"`
+	TransferID string `json:"transferId"`
+}
+
+// DigestSettings asks the server to batch matching notifications (all of them
+// when MessageTypes is empty) into one digest frame every IntervalSeconds.
+type DigestSettings struct {
+	IntervalSeconds int      `json:"intervalSeconds"`
+	MessageTypes    []string `json:"messageTypes,omitempty"`
+}
+
+// DigestFrame carries a batch of notifications collected over one digest interval.
+type DigestFrame struct {
+	Type            string            `json:"type"`
+	IntervalSeconds int               `json:"intervalSeconds"`
+	Count           int               `json:"count"`
+	Messages        []json.RawMessage `json:"messages"`
+}
+
+// NotificationRevokedFrame tells a client to remove a recalled notification.
+type NotificationRevokedFrame struct {
+	Type           string `json:"type"`
+	NotificationID string `json:"notificationId"`
+	RevokedAt      int64  `json:"revokedAt"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// StatsFrame is pushed to __stats__ subscribers every stats.interval.
+// Delivered and Dropped count messages since the previous frame.
+type StatsFrame struct {
+	Type               string    `json:"type"`
+	Time               time.Time `json:"time"`
+	IntervalSeconds    float64   `json:"intervalSeconds"`
+	TotalTeams         int       `json:"totalTeams"`
+	TotalClients       int       `json:"totalClients"`
+	Delivered          int64     `json:"delivered"`
+	Dropped            int64     `json:"dropped"`
+	DeliveredPerSecond float64   `json:"deliveredPerSecond"`
+}
+
+// SubscriptionFilter restricts which notifications a connection receives.
+// All conditions must match: the message type must be listed (when
+// MessageTypes is set) and every body predicate must hold against the
+// notification body parsed as a JSON object.
+type SubscriptionFilter struct {
+	MessageTypes []string        `json:"messageTypes,omitempty"`
+	Body         []BodyPredicate `json:"body,omitempty"`
+}
+
+// BodyPredicate tests the value at a dotted JSON path (e.g. "build.status")
+// with exactly one of Equals, In or Exists.
+type BodyPredicate struct {
+	Path   string        `json:"path"`
+	Equals interface{}   `json:"equals,omitempty"`
+	In     []interface{} `json:"in,omitempty"`
+	Exists *bool         `json:"exists,omitempty"`
 }
 
 func (a *AuthMessage) Normalize() {
 	a.Type = strings.TrimSpace(a.Type)
 	a.UserID = strings.TrimSpace(a.UserID)
 	a.TeamID = strings.TrimSpace(a.TeamID)
+	a.TenantID = strings.TrimSpace(a.TenantID)
 	a.Token = strings.TrimSpace(a.Token)
 }
 
@@ -31,6 +232,53 @@ type Message struct {
 	Body           string `json:"body"`
 	ActionRequired bool   `json:"actionRequired"`
 	Timestamp      int64  `json:"timestamp"`
+	ReplacesID     string `json:"replacesId,omitempty"` // The earlier notification this one supersedes
+	Priority       string `json:"priority,omitempty"`   // "high" for mentions, which skip filters and digests
+	Channel        string `json:"channel,omitempty"`    // the channel it was published to, if any
+	Urgency        string `json:"urgency,omitempty"`    // low, high or critical; omitted for normal
+
+	// Set on copies of an unacknowledged critical notification re-sent along
+	// an escalation chain: how many steps it has escalated, and the step.
+	EscalationLevel int    `json:"escalationLevel,omitempty"`
+	EscalationStep  string `json:"escalationStep,omitempty"`
+
+	OnCall string `json:"onCall,omitempty"` // set on pages to oncall:<team>: the team whose on-call user this is
+
+	GroupKey string          `json:"groupKey,omitempty"` // the /send group_key, which groups alert bursts into incidents
+	Incident *IncidentNotice `json:"incident,omitempty"` // set on an incident's rollups and resolution
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// IncidentNotice describes the incident a rollup or resolution reports on.
+// Times are Unix milliseconds, like timestamp.
+type IncidentNotice struct {
+	ID         string `json:"id"`
+	GroupKey   string `json:"groupKey"`
+	State      string `json:"state"`      // rollup or resolved
+	Alerts     int    `json:"alerts"`     // every alert of the incident, including the one delivered
+	Suppressed int    `json:"suppressed"` // alerts suppressed since the previous rollup
+	FirstAt    int64  `json:"firstAt"`
+	LastAt     int64  `json:"lastAt"`
+}
+
+// Attachment references a file in object storage. When attachments.provider
+// is set, URL is a pre-signed link signed as the notification is delivered.
+type Attachment struct {
+	Name         string     `json:"name"`
+	Mime         string     `json:"mime,omitempty"`
+	Size         int64      `json:"size,omitempty"`
+	StorageKey   string     `json:"storageKey"`
+	URL          string     `json:"url,omitempty"`
+	URLExpiresAt *time.Time `json:"urlExpiresAt,omitempty"`
+}
+
+// AttachmentRequest is an attachment in a /send request.
+type AttachmentRequest struct {
+	Name       string `json:"name"`
+	Mime       string `json:"mime"`
+	Size       int64  `json:"size"` // bytes
+	StorageKey string `json:"storage_key"`
 }
 
 // NewMessage creates a new message with the current timestamp
@@ -47,9 +295,46 @@ func NewMessage(notificationID, targetTeamID, targetUserID, senderUserID, messag
 	}
 }
 
+// AuthSuccess confirms a client's authentication. It carries what a client
+// needs to set up its session, so it can initialize from this one frame.
+type AuthSuccess struct {
+	Type         string            `json:"type"`
+	Message      string            `json:"message"`
+	Protocol     wireProtocol      `json:"protocol"`
+	ConnectionID string            `json:"connectionId"`
+	ServerTime   int64             `json:"serverTime"` // Unix milliseconds, for clock skew
+	Heartbeat    HeartbeatParams   `json:"heartbeat"`
+	Pending      int               `json:"pending"`          // offline notifications about to be delivered
+	Unread       *int              `json:"unread,omitempty"` // unread conversation messages; nil without conversations
+	Capabilities *capabilitiesView `json:"capabilities,omitempty"`
+}
+
+// HeartbeatParams tells a client how often the server pings it and how long
+// the server waits for a pong before it drops the connection.
+type HeartbeatParams struct {
+	PingIntervalSeconds int `json:"pingIntervalSeconds"`
+	PongTimeoutSeconds  int `json:"pongTimeoutSeconds"`
+}
+
+// BackpressureNotice advises a client that its send queue is filling up (or has drained again).
+type BackpressureNotice struct {
+	Type          string  `json:"type"`
+	Active        bool    `json:"active"`
+	QueueDepth    int     `json:"queueDepth"`
+	QueueCapacity int     `json:"queueCapacity"`
+	Utilization   float64 `json:"utilization"`
+	Advice        string  `json:"advice,omitempty"`
+}
+
+// ToJSON converts a backpressure notice to JSON bytes
+func (n *BackpressureNotice) ToJSON() ([]byte, error) {
+	return json.Marshal(n)
+}
+
 // MessageRequest represents the incoming REST API request
 type MessageRequest struct {
 	NotificationID string `json:"notification_id"` // Unique ID for the notification
+	TenantID       string `json:"tenant_id"`       // Operator key only; tenant keys always deliver into their own tenant
 	TargetTeamID   string `json:"target_team_id"`
 	SenderUserID   string `json:"sender_user_id"` // Sender user ID
 	TargetUserID   string `json:"target_user_id"`
@@ -57,20 +342,52 @@ type MessageRequest struct {
 	Body           string `json:"body"`
 	ActionRequired bool   `json:"action_required"`
 	Broadcast      bool   `json:"broadcast"`
+	ReplacesID     string `json:"replaces_id"`    // An earlier notification_id this notification supersedes
+	DryRun         bool   `json:"dry_run"`        // Resolve the recipients without delivering
+	TargetChannel  string `json:"target_channel"` // Deliver to the team's subscribers of this channel
+	Urgency        string `json:"urgency"`        // low, normal, high or critical; urgency.default when empty
+	GroupKey       string `json:"group_key"`      // Groups bursts of related alerts into an incident
+
+	Attachments []AttachmentRequest `json:"attachments,omitempty"`
+	Visibility  []VisibilityRule    `json:"visibility,omitempty"`
 }
 
-// ToJSON converts a message to JSON bytes (camelCase for WebSocket)
+// VisibilityRule limits a notification to clients whose attribute has one of
+// the In values, or none of the NotIn values.
+type VisibilityRule struct {
+	Attribute string   `json:"attribute"` // role, group or status
+	In        []string `json:"in,omitempty"`
+	NotIn     []string `json:"not_in,omitempty"`
+}
+
+// ToJSON converts a message to JSON bytes (camelCase for WebSocket). REST
+// responses render it with marshalJSONCase(m, jsonSnakeCase) instead.
 func (m *Message) ToJSON() ([]byte, error) {
 	return json.Marshal(m)
 }
 
 func (r *MessageRequest) Normalize() {
 	r.NotificationID = strings.TrimSpace(r.NotificationID)
+	r.TenantID = strings.TrimSpace(r.TenantID)
 	r.TargetTeamID = strings.TrimSpace(r.TargetTeamID)
 	r.SenderUserID = strings.TrimSpace(r.SenderUserID)
 	r.TargetUserID = strings.TrimSpace(r.TargetUserID)
+	if team, ok := onCallTeam(r.TargetUserID); ok {
+		r.TargetUserID = onCallTargetPrefix + team
+		r.TargetTeamID = firstNonEmpty(r.TargetTeamID, team)
+	}
 	r.MessageType = strings.TrimSpace(r.MessageType)
+	r.ReplacesID = strings.TrimSpace(r.ReplacesID)
+	r.TargetChannel = strings.TrimSpace(r.TargetChannel)
+	r.Urgency = strings.ToLower(strings.TrimSpace(r.Urgency))
+	r.GroupKey = strings.TrimSpace(r.GroupKey)
 	r.Body = strings.TrimSpace(r.Body)
+	for i := range r.Attachments {
+		attachment := &r.Attachments[i]
+		attachment.Name = strings.TrimSpace(attachment.Name)
+		attachment.Mime = strings.TrimSpace(attachment.Mime)
+		attachment.StorageKey = strings.TrimSpace(attachment.StorageKey)
+	}
 }
 
 func (r *MessageRequest) Validate() error {
@@ -78,10 +395,44 @@ func (r *MessageRequest) Validate() error {
 		return errors.New("missing required field: message_type")
 	}
 
+	if r.TargetTeamID == statsTeamID {
+		return errors.New("target_team_id " + statsTeamID + " is reserved")
+	}
+
 	if strings.TrimSpace(r.Body) == "" {
 		return errors.New("missing required field: body")
 	}
 
+	if err := validateAttachments(r.Attachments); err != nil {
+		return err
+	}
+
+	if r.Urgency != "" && !validUrgency(r.Urgency) {
+		return errors.New("urgency must be low, normal, high or critical")
+	}
+
+	if r.ReplacesID != "" {
+		if r.NotificationID == "" {
+			return errors.New("replaces_id requires notification_id")
+		}
+		if r.ReplacesID == r.NotificationID {
+			return errors.New("replaces_id must differ from notification_id")
+		}
+	}
+
+	if r.TargetChannel != "" {
+		if r.TargetTeamID == "" {
+			return errors.New("target_channel requires target_team_id")
+		}
+		if r.Broadcast || r.TargetUserID != "" {
+			return errors.New("cannot specify broadcast or target_user_id with target_channel")
+		}
+		if err := validateChannelName(r.TargetChannel); err != nil {
+			return fmt.Errorf("target_channel: %v", err)
+		}
+		return nil
+	}
+
 	if r.Broadcast {
 		if r.TargetUserID != "" {
 			return errors.New("cannot specify target_user_id when broadcast is true")
@@ -93,5 +444,17 @@ func (r *MessageRequest) Validate() error {
 		return errors.New("must specify target_user_id for non-broadcast messages")
 	}
 
+	if team, ok := onCallTeam(r.TargetUserID); ok {
+		if onCallSchedules == nil {
+			return errors.New("on-call routing is not enabled")
+		}
+		if team == "" {
+			return errors.New("target_user_id " + onCallTargetPrefix + " must name a team")
+		}
+		if team != r.TargetTeamID {
+			return errors.New("target_user_id " + r.TargetUserID + " must be in target_team_id " + team)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,291 @@
+// notifyctl.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultControlSocket is where notifyctl looks for the control socket when
+// -socket is not given.
+const defaultControlSocket = "/run/notification-server/control.sock"
+
+const notifyctlHelp = `Commands:
+  teams                               list connected teams
+  clients [team]                      list connections, optionally of one team
+  kick <user> [team]                  disconnect a user, optionally only in one team
+  drain [on|off]                      show or set drain mode
+  events                              stream live events until interrupted
+  send <team> <user|-> <type> <body>  send a test notification; "-" broadcasts to the team
+`
+
+// runNotifyctlCommand implements `notification-server notifyctl [flags]
+// <command>` and returns the process exit code.
+func runNotifyctlCommand(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("notifyctl", flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	socket := flags.String("socket", defaultControlSocket, "control socket of the server (control.socket)")
+	asJSON := flags.Bool("json", false, "print responses as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(stdout, "Usage: notification-server notifyctl [flags] <command>\n\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(stdout, "\n%s", notifyctlHelp)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	ctl := &notifyctl{
+		out:    stdout,
+		asJSON: *asJSON,
+		http: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", *socket)
+			},
+		}},
+	}
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	if err := ctl.run(command, rest); err != nil {
+		fmt.Fprintf(stdout, "❌ %v\n", err)
+		if _, usage := err.(notifyctlUsageError); usage {
+			fmt.Fprintf(stdout, "\n%s", notifyctlHelp)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+type notifyctlUsageError string
+
+func (e notifyctlUsageError) Error() string { return string(e) }
+
+type notifyctl struct {
+	out    io.Writer
+	asJSON bool
+	http   *http.Client
+}
+
+func (c *notifyctl) run(command string, args []string) error {
+	switch command {
+	case "teams":
+		var teams []TeamStats
+		if err := c.call(http.MethodGet, "/teams", nil, &teams); err != nil {
+			return err
+		}
+		return c.print(teams, func(w io.Writer) {
+			fmt.Fprintln(w, "TEAM\tUSERS\tCLIENTS\tQUEUED")
+			for _, team := range teams {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", team.TeamID, team.Users, team.Clients, team.QueueDepth)
+			}
+		})
+
+	case "clients":
+		if len(args) > 1 {
+			return notifyctlUsageError("clients takes at most one team")
+		}
+		query := url.Values{}
+		if len(args) == 1 {
+			query.Set("team", args[0])
+		}
+		var clients []controlClient
+		if err := c.call(http.MethodGet, "/clients?"+query.Encode(), nil, &clients); err != nil {
+			return err
+		}
+		return c.print(clients, func(w io.Writer) {
+			fmt.Fprintln(w, "TEAM\tUSER\tEMAIL\tCONNECTION\tQUEUED")
+			for _, client := range clients {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", client.TeamID, client.UserID, client.Email, client.ConnID, client.QueueDepth)
+			}
+		})
+
+	case "kick":
+		if len(args) < 1 || len(args) > 2 {
+			return notifyctlUsageError("kick takes a user and an optional team")
+		}
+		query := url.Values{"user": {args[0]}}
+		if len(args) == 2 {
+			query.Set("team", args[1])
+		}
+		var result struct {
+			Disconnected int `json:"disconnected"`
+		}
+		if err := c.call(http.MethodPost, "/kick?"+query.Encode(), nil, &result); err != nil {
+			return err
+		}
+		return c.print(result, func(w io.Writer) {
+			fmt.Fprintf(w, "Disconnected %d connections of %s\n", result.Disconnected, args[0])
+		})
+
+	case "drain":
+		method, path := http.MethodGet, "/drain"
+		switch {
+		case len(args) == 0:
+		case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+			method, path = http.MethodPost, "/drain?on="+fmt.Sprint(args[0] == "on")
+		default:
+			return notifyctlUsageError("drain takes on or off")
+		}
+		var result struct {
+			Draining bool `json:"draining"`
+		}
+		if err := c.call(method, path, nil, &result); err != nil {
+			return err
+		}
+		return c.print(result, func(w io.Writer) {
+			if result.Draining {
+				fmt.Fprintln(w, "Draining: new connections are refused and /readyz answers 503")
+			} else {
+				fmt.Fprintln(w, "Not draining")
+			}
+		})
+
+	case "events":
+		return c.tail()
+
+	case "send":
+		if len(args) < 4 {
+			return notifyctlUsageError("send takes a team, a user or -, a message type and a body")
+		}
+		req := MessageRequest{
+			TargetTeamID: args[0],
+			SenderUserID: "notifyctl",
+			MessageType:  args[2],
+			Body:         strings.Join(args[3:], " "),
+		}
+		if args[1] == "-" {
+			req.Broadcast = true
+		} else {
+			req.TargetUserID = args[1]
+		}
+		var result struct {
+			Success   bool `json:"success"`
+			Delivered int  `json:"delivered"`
+			Deferred  bool `json:"deferred"`
+		}
+		if err := c.call(http.MethodPost, "/send", req, &result); err != nil {
+			return err
+		}
+		return c.print(result, func(w io.Writer) {
+			fmt.Fprintf(w, "Delivered to %d connections", result.Delivered)
+			if result.Deferred {
+				fmt.Fprint(w, " (deferred by a blackout)")
+			}
+			fmt.Fprintln(w)
+		})
+
+	default:
+		return notifyctlUsageError(fmt.Sprintf("unknown command %q", command))
+	}
+}
+
+// call sends a request to the control socket and decodes the JSON answer
+// into result.
+func (c *notifyctl) call(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, "http://notifyctl"+path, reader)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// print writes result as JSON with -json, and as a table otherwise.
+func (c *notifyctl) print(result interface{}, table func(w io.Writer)) error {
+	if c.asJSON {
+		encoder := json.NewEncoder(c.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// tail copies live events to the output until the server closes the stream.
+func (c *notifyctl) tail() error {
+	req, err := http.NewRequest(http.MethodGet, "http://notifyctl/events", nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if c.asJSON {
+			fmt.Fprintln(c.out, scanner.Text())
+			continue
+		}
+		var event controlEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		fmt.Fprintln(c.out, formatControlEvent(event))
+	}
+	return scanner.Err()
+}
+
+// formatControlEvent renders an event as one readable line.
+func formatControlEvent(event controlEvent) string {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s %-10s", event.Time.Format("15:04:05.000"), event.Type)
+	if event.TeamID != "" {
+		fmt.Fprintf(&line, " team=%s", event.TeamID)
+	}
+	if event.UserID != "" {
+		fmt.Fprintf(&line, " user=%s", event.UserID)
+	}
+	if event.ConnID != "" {
+		fmt.Fprintf(&line, " conn=%s", event.ConnID)
+	}
+	keys := make([]string, 0, len(event.Details))
+	for key := range event.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&line, " %s=%s", key, event.Details[key])
+	}
+	return line.String()
+}
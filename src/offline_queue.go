@@ -0,0 +1,176 @@
+// offline_queue.go
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// offlineQueue keeps direct notifications to users with no connection in
+// the store, and delivers them when the user next connects.
+type offlineQueue struct {
+	store Store
+	depth int
+	ttl   time.Duration
+}
+
+// offlineNotifications is nil unless limits.offline_queue_depth is set, and
+// all methods are nil-safe.
+var offlineNotifications *offlineQueue
+
+func newOfflineQueue(store Store, depth int, ttl time.Duration) *offlineQueue {
+	return &offlineQueue{store: store, depth: depth, ttl: ttl}
+}
+
+// offlineRecipient is the store's user ID for userID in tenantID, so that
+// tenants' users with the same ID have separate queues.
+func offlineRecipient(tenantID, userID string) string {
+	if tenantID == "" {
+		return userID
+	}
+	return tenantID + "/" + userID
+}
+
+// enqueue stores message for its target user. When the user's queue is full
+// the oldest notifications are dropped to make room. It reports whether the
+// message was stored.
+func (q *offlineQueue) enqueue(ctx context.Context, tenantID string, message *Message, now time.Time) bool {
+	if q == nil || message.TargetUserID == "" {
+		return false
+	}
+	recipient := offlineRecipient(tenantID, message.TargetUserID)
+	pending, err := q.store.PendingFor(ctx, recipient)
+	if err != nil {
+		slog.Error("Failed to read the offline queue", "user", recipient, "error", err)
+		return false
+	}
+	for i := 0; i <= len(pending)-q.depth; i++ {
+		// Marking it delivered takes it off the queue.
+		if err := q.store.MarkDelivered(ctx, recipient, pending[i].ID()); err != nil {
+			slog.Error("Failed to drop an offline notification", "user", recipient, "error", err)
+			return false
+		}
+		appMetrics.Count("offline.evicted", 1, tenantTags(tenantID)...)
+	}
+
+	stored := &StoredNotification{
+		Message:   *message,
+		UserID:    recipient,
+		CreatedAt: now,
+		ExpiresAt: now.Add(q.ttl),
+	}
+	if stored.Message.NotificationID == "" {
+		stored.Message.NotificationID = newNotificationID()
+	}
+	if err := q.store.SaveNotification(ctx, stored); err != nil {
+		slog.Error("Failed to queue a notification for an offline user", "user", recipient, "error", err)
+		return false
+	}
+	appMetrics.Count("offline.queued", 1, tenantTags(tenantID)...)
+	return true
+}
+
+// discard takes notificationID off userID's queue, so that a recalled or
+// superseded notification is not delivered once the recall ledger has
+// forgotten it. It reports whether the notification was queued.
+func (q *offlineQueue) discard(ctx context.Context, tenantID, userID, notificationID string) bool {
+	if q == nil || userID == "" {
+		return false
+	}
+	recipient := offlineRecipient(tenantID, userID)
+	pending, err := q.store.PendingFor(ctx, recipient)
+	if err != nil {
+		slog.Error("Failed to read the offline queue", "user", recipient, "error", err)
+		return false
+	}
+	for _, stored := range pending {
+		if stored.ID() != notificationID {
+			continue
+		}
+		if err := q.store.MarkDelivered(ctx, recipient, notificationID); err != nil {
+			slog.Error("Failed to drop an offline notification", "user", recipient, "notification", notificationID, "error", err)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// pendingFor returns the notifications queued for client, oldest first.
+// Notifications sent to one of the user's other teams stay queued for a
+// connection in that team.
+func (q *offlineQueue) pendingFor(ctx context.Context, client *Client) ([]*StoredNotification, error) {
+	if q == nil || client.teamID == statsTeamID {
+		return nil, nil
+	}
+	pending, err := q.store.PendingFor(ctx, offlineRecipient(client.tenantID, client.userID))
+	if err != nil {
+		return nil, err
+	}
+	matching := pending[:0]
+	for _, stored := range pending {
+		if stored.Message.TargetTeamID != "" {
+			if teamID, _ := scopeTeam(client.tenantID, stored.Message.TargetTeamID); teamID != client.teamID {
+				continue
+			}
+		}
+		matching = append(matching, stored)
+	}
+	return matching, nil
+}
+
+// count returns how many notifications deliver would queue for client.
+func (q *offlineQueue) count(ctx context.Context, client *Client) int {
+	pending, err := q.pendingFor(ctx, client)
+	if err != nil {
+		client.logger().Error("Failed to read the offline queue", "error", err)
+	}
+	return len(pending)
+}
+
+// deliver queues client's pending notifications on it, oldest first, marks
+// them delivered and returns how many were queued.
+func (q *offlineQueue) deliver(ctx context.Context, hub *Hub, client *Client) int {
+	pending, err := q.pendingFor(ctx, client)
+	if err != nil {
+		client.logger().Error("Failed to read the offline queue", "error", err)
+		return 0
+	}
+
+	recipient := offlineRecipient(client.tenantID, client.userID)
+	delivered := 0
+	for _, stored := range pending {
+		message := stored.Message
+		payload, err := message.ToJSON()
+		if err != nil {
+			client.logger().Error("Skipping offline notification", "notification", stored.ID(), "error", err)
+			continue
+		}
+		// receivedAt is left unset: time spent offline is not delivery
+		// latency.
+		outbound := outboundMessage{
+			payload:        payload,
+			tenantID:       client.tenantID,
+			teamID:         client.teamID,
+			messageType:    message.MessageType,
+			notificationID: message.NotificationID,
+			fanout:         newFanoutCache(message.Body),
+			links:          newAttachmentLinks(attachmentPresigner, message),
+			urgency:        message.Urgency,
+		}
+		// A notification the connection filters out, or that was recalled,
+		// is done with as it would have been had the user been online.
+		if hub.enqueueMessage(client, outbound) {
+			delivered++
+		}
+		if err := q.store.MarkDelivered(ctx, recipient, stored.ID()); err != nil {
+			client.logger().Error("Failed to mark offline notification delivered", "notification", stored.ID(), "error", err)
+		}
+	}
+	if delivered > 0 {
+		appMetrics.Count("offline.delivered", int64(delivered), tenantTags(client.tenantID)...)
+		client.logger().Info("Delivered notifications queued while offline", "delivered", delivered)
+	}
+	return delivered
+}
@@ -0,0 +1,107 @@
+// offline_queue_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOfflineQueue_EvictsOldestAtDepth(t *testing.T) {
+	setupTestAppConfig()
+	store := newMemoryStore()
+	queue := newOfflineQueue(store, 2, time.Hour)
+	now := time.Now()
+
+	for i, id := range []string{"n1", "n2", "n3"} {
+		message := &Message{NotificationID: id, TargetUserID: "alice", Body: id}
+		if !queue.enqueue(context.Background(), "acme", message, now.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("expected %s to be queued", id)
+		}
+	}
+
+	pending, _ := store.PendingFor(context.Background(), "acme/alice")
+	if len(pending) != 2 || pending[0].ID() != "n2" || pending[1].ID() != "n3" {
+		t.Fatalf("expected n2 and n3 to be kept, got %+v", pending)
+	}
+	if !pending[0].ExpiresAt.Equal(now.Add(time.Second + time.Hour)) {
+		t.Errorf("expected the ttl to set the expiry, got %v", pending[0].ExpiresAt)
+	}
+	if others, _ := store.PendingFor(context.Background(), "alice"); len(others) != 0 {
+		t.Errorf("expected tenants' queues to be separate, got %d pending", len(others))
+	}
+}
+
+func TestOfflineQueue_DeliversOnConnect(t *testing.T) {
+	setupTestAppConfig()
+	store := newMemoryStore()
+	queue := newOfflineQueue(store, 10, time.Hour)
+	now := time.Now()
+	queue.enqueue(context.Background(), "", &Message{NotificationID: "n1", TargetUserID: "alice", TargetTeamID: "team-1", Body: "first"}, now)
+	queue.enqueue(context.Background(), "", &Message{NotificationID: "n2", TargetUserID: "alice", TargetTeamID: "team-2", Body: "other team"}, now.Add(time.Second))
+	queue.enqueue(context.Background(), "", &Message{NotificationID: "n3", TargetUserID: "alice", Body: "any team"}, now.Add(2*time.Second))
+
+	hub := newHub()
+	client := &Client{hub: hub, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 4)}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {client: {}}}
+
+	if delivered := queue.deliver(context.Background(), hub, client); delivered != 2 {
+		t.Fatalf("expected 2 notifications delivered, got %d", delivered)
+	}
+	for _, want := range []string{"n1", "n3"} {
+		if got := (<-client.send).notificationID; got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+
+	// The notification for team-2 waits for a connection in that team.
+	pending, _ := store.PendingFor(context.Background(), "alice")
+	if len(pending) != 1 || pending[0].ID() != "n2" {
+		t.Fatalf("expected only n2 to stay queued, got %+v", pending)
+	}
+}
+
+func TestHandleSendMessage_QueuesForOfflineUser(t *testing.T) {
+	setupTestAppConfig()
+	store := newMemoryStore()
+	offlineNotifications = newOfflineQueue(store, 10, time.Hour)
+	defer func() { offlineNotifications = nil }()
+
+	rr := httptest.NewRecorder()
+	handleSendMessage(newHub(), rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(
+		`{"notification_id":"n1","target_team_id":"team-1","target_user_id":"bob","message_type":"chat","body":"hi"}`)))
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"queued":true`) {
+		t.Fatalf("expected the message to be queued, got %d %s", rr.Code, rr.Body.String())
+	}
+	if pending, _ := store.PendingFor(context.Background(), "bob"); len(pending) != 1 || pending[0].ID() != "n1" {
+		t.Fatalf("expected n1 in bob's queue, got %+v", pending)
+	}
+}
+
+func TestHandleSendMessage_DoesNotQueueForConnectedUser(t *testing.T) {
+	setupTestAppConfig()
+	store := newMemoryStore()
+	offlineNotifications = newOfflineQueue(store, 10, time.Hour)
+	defer func() { offlineNotifications = nil }()
+	hub := newHub()
+	// bob is connected but only takes alerts, so the chat message is
+	// filtered out rather than left for his next connection.
+	bob := &Client{hub: hub, teamID: "team-1", userID: "bob", send: make(chan outboundMessage, 1),
+		filter: &clientFilter{messageTypes: map[string]struct{}{"alert": {}}}}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"bob": {bob: {}}}
+
+	rr := httptest.NewRecorder()
+	handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(
+		`{"notification_id":"n1","target_team_id":"team-1","target_user_id":"bob","message_type":"chat","body":"hi"}`)))
+
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), `"queued"`) || len(bob.send) != 0 {
+		t.Fatalf("expected the filtered message not to be queued, got %d %s", rr.Code, rr.Body.String())
+	}
+	if pending, _ := store.PendingFor(context.Background(), "bob"); len(pending) != 0 {
+		t.Fatalf("expected bob's queue to stay empty, got %+v", pending)
+	}
+}
@@ -0,0 +1,411 @@
+// oncall.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// onCallTargetPrefix marks a /send target_user_id naming a team whose on-call
+// users receive the notification, as in oncall:team-123.
+const onCallTargetPrefix = "oncall:"
+
+// onCallTeam returns the team of an oncall:<team> target.
+func onCallTeam(target string) (string, bool) {
+	team, ok := strings.CutPrefix(target, onCallTargetPrefix)
+	return strings.TrimSpace(team), ok
+}
+
+// onCallSchedules is nil unless oncall.enabled is set, and all methods are
+// nil-safe.
+var onCallSchedules *onCallSchedule
+
+// onCallShift puts users on call for a team from Start until End. Shifts may
+// overlap, for example to hand over, and everyone on an open shift is on call.
+type onCallShift struct {
+	ID      string    `json:"id"`
+	TeamID  string    `json:"teamId"`
+	UserIDs []string  `json:"userIds"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+func (s onCallShift) activeAt(now time.Time) bool {
+	return !now.Before(s.Start) && now.Before(s.End)
+}
+
+// onCallPage is a notification sent to a team's on-call users that nobody
+// has acknowledged yet. Users who come on call before it expires receive it
+// too, so a page raised just before a handoff is not left with the users
+// going off call.
+type onCallPage struct {
+	message  Message
+	sent     outboundMessage
+	notified map[string]struct{}
+	expires  time.Time
+}
+
+// onCallSchedule holds each team's on-call shifts, and the recent pages that
+// follow a handoff. Shifts live in memory and are kept across restarts by the
+// snapshot.
+type onCallSchedule struct {
+	handoffWindow time.Duration
+	maxShifts     int
+
+	mu     sync.Mutex
+	shifts map[string][]onCallShift
+	pages  map[recallKey]*onCallPage
+}
+
+func newOnCallSchedule(handoffWindow time.Duration, maxShifts int) *onCallSchedule {
+	return &onCallSchedule{
+		handoffWindow: handoffWindow,
+		maxShifts:     maxShifts,
+		shifts:        make(map[string][]onCallShift),
+		pages:         make(map[recallKey]*onCallPage),
+	}
+}
+
+func (s *onCallSchedule) add(shift onCallShift) (onCallShift, error) {
+	shift.TeamID = strings.TrimSpace(shift.TeamID)
+	if shift.TeamID == "" {
+		return onCallShift{}, errors.New("teamId is required")
+	}
+	var users []string
+	for _, userID := range shift.UserIDs {
+		if userID = strings.TrimSpace(userID); userID != "" && !slices.Contains(users, userID) {
+			users = append(users, userID)
+		}
+	}
+	if len(users) == 0 {
+		return onCallShift{}, errors.New("userIds must name at least one user")
+	}
+	shift.UserIDs = users
+	if shift.Start.IsZero() {
+		shift.Start = time.Now()
+	}
+	if !shift.End.After(shift.Start) {
+		return onCallShift{}, errors.New("end must be after start")
+	}
+	shift.ID = newNotificationID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Ended shifts are dropped here so that they do not count against
+	// maxShifts.
+	now := time.Now()
+	shifts := slices.DeleteFunc(s.shifts[shift.TeamID], func(existing onCallShift) bool {
+		return !now.Before(existing.End)
+	})
+	if len(shifts) >= s.maxShifts {
+		return onCallShift{}, errors.New("the team has too many shifts; remove some first")
+	}
+	shifts = append(shifts, shift)
+	sort.Slice(shifts, func(i, j int) bool { return shifts[i].Start.Before(shifts[j].Start) })
+	s.shifts[shift.TeamID] = shifts
+	return shift, nil
+}
+
+func (s *onCallSchedule) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for teamID, shifts := range s.shifts {
+		for i, shift := range shifts {
+			if shift.ID != id {
+				continue
+			}
+			shifts = append(shifts[:i], shifts[i+1:]...)
+			if len(shifts) == 0 {
+				delete(s.shifts, teamID)
+			} else {
+				s.shifts[teamID] = shifts
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the shifts of one team, or of every team when teamID is
+// empty, that have not ended by now.
+func (s *onCallSchedule) list(teamID string, now time.Time) []onCallShift {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shifts := []onCallShift{}
+	for team, teamShifts := range s.shifts {
+		if teamID != "" && team != teamID {
+			continue
+		}
+		for _, shift := range teamShifts {
+			if now.Before(shift.End) {
+				shifts = append(shifts, shift)
+			}
+		}
+	}
+	sort.Slice(shifts, func(i, j int) bool { return shifts[i].Start.Before(shifts[j].Start) })
+	return shifts
+}
+
+// onCall returns the users on call for teamID at now, sorted.
+func (s *onCallSchedule) onCall(teamID string, now time.Time) []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.onCallLocked(teamID, now)
+}
+
+func (s *onCallSchedule) onCallLocked(teamID string, now time.Time) []string {
+	var users []string
+	for _, shift := range s.shifts[teamID] {
+		if !shift.activeAt(now) {
+			continue
+		}
+		for _, userID := range shift.UserIDs {
+			if !slices.Contains(users, userID) {
+				users = append(users, userID)
+			}
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// page delivers message, sent to oncall:<team>, to each user on call for the
+// team now. Each user gets their own copy, addressed to them and naming the
+// team in onCall; users who are not connected have it queued for when they
+// connect. It returns the users paged, the connections it was queued for and
+// how many offline users it was stored for.
+func (s *onCallSchedule) page(ctx context.Context, hub NotificationHub, message *Message, sent outboundMessage, now time.Time) (users []string, delivered, stored int) {
+	if s == nil {
+		return nil, 0, 0
+	}
+	team, _ := onCallTeam(message.TargetUserID)
+	users = s.onCall(sent.teamID, now)
+	paged := *message
+	paged.OnCall = team
+	for _, userID := range users {
+		connections, queued := deliverPage(ctx, hub, paged, sent, userID, now)
+		delivered += connections
+		if queued {
+			stored++
+		}
+	}
+	if len(users) > 0 {
+		appMetrics.Count("oncall.pages", 1, tenantTags(sent.tenantID)...)
+	}
+
+	if message.NotificationID == "" {
+		return users, delivered, stored
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notified := make(map[string]struct{}, len(users))
+	for _, userID := range users {
+		notified[userID] = struct{}{}
+	}
+	s.pages[recallKey{sent.tenantID, message.NotificationID}] = &onCallPage{
+		message:  paged,
+		sent:     sent,
+		notified: notified,
+		expires:  now.Add(s.handoffWindow),
+	}
+	return users, delivered, stored
+}
+
+// deliverPage sends a user their copy of an on-call page, or queues it for
+// when they connect.
+func deliverPage(ctx context.Context, hub NotificationHub, message Message, sent outboundMessage, userID string, now time.Time) (int, bool) {
+	message.TargetUserID = userID
+	payload, err := message.ToJSON()
+	if err != nil {
+		slog.Error("Failed to encode an on-call page", "notification", message.NotificationID, "user", userID, "error", err)
+		return 0, false
+	}
+	sent.payload = payload
+	sent.fanout = nil
+	sent.links = newAttachmentLinks(attachmentPresigner, message)
+	if delivered := hub.SendToUser(sent.teamID, userID, sent); delivered > 0 || hub.UserConnected(sent.tenantID, sent.teamID, userID) {
+		return delivered, false
+	}
+	return 0, offlineNotifications.enqueue(ctx, sent.tenantID, &message, now)
+}
+
+// acknowledge closes the pages of notificationIDs that client, one of their
+// recipients, acknowledged.
+func (s *onCallSchedule) acknowledge(client *Client, notificationIDs []string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range notificationIDs {
+		key := recallKey{client.tenantID, id}
+		page, ok := s.pages[key]
+		if !ok || page.sent.teamID != client.teamID {
+			continue
+		}
+		if _, notified := page.notified[client.userID]; notified {
+			delete(s.pages, key)
+		}
+	}
+}
+
+// cancel closes the page of a notification that was recalled or superseded.
+func (s *onCallSchedule) cancel(tenantID, notificationID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pages, recallKey{tenantID, notificationID})
+}
+
+// handoff sends each open page to the users who have come on call since it
+// was sent, and drops pages older than the handoff window. It returns how
+// many users were paged.
+func (s *onCallSchedule) handoff(hub NotificationHub, now time.Time) int {
+	type handover struct {
+		page  *onCallPage
+		users []string
+	}
+	var handovers []handover
+
+	s.mu.Lock()
+	for key, page := range s.pages {
+		if !now.Before(page.expires) {
+			delete(s.pages, key)
+			continue
+		}
+		var incoming []string
+		for _, userID := range s.onCallLocked(page.sent.teamID, now) {
+			if _, notified := page.notified[userID]; !notified {
+				page.notified[userID] = struct{}{}
+				incoming = append(incoming, userID)
+			}
+		}
+		if len(incoming) > 0 {
+			handovers = append(handovers, handover{page, incoming})
+		}
+	}
+	s.mu.Unlock()
+
+	paged := 0
+	for _, h := range handovers {
+		for _, userID := range h.users {
+			deliverPage(context.Background(), hub, h.page.message, h.page.sent, userID, now)
+		}
+		paged += len(h.users)
+		appMetrics.Count("oncall.handoffs", int64(len(h.users)), tenantTags(h.page.sent.tenantID)...)
+		slog.Info("On-call handoff paged the next shift", "team", h.page.sent.teamID, "users", h.users, "notification", h.page.message.NotificationID)
+	}
+	return paged
+}
+
+// run hands open pages over as shifts change until stop is closed.
+func (s *onCallSchedule) run(hub NotificationHub, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.handoff(hub, time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// snapshot returns every shift that has not ended, ordered by team.
+func (s *onCallSchedule) snapshot(now time.Time) []onCallShift {
+	if s == nil {
+		return nil
+	}
+	shifts := s.list("", now)
+	sort.SliceStable(shifts, func(i, j int) bool { return shifts[i].TeamID < shifts[j].TeamID })
+	return shifts
+}
+
+// restore adds shifts from a snapshot.
+func (s *onCallSchedule) restore(shifts []onCallShift) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, shift := range shifts {
+		s.shifts[shift.TeamID] = append(s.shifts[shift.TeamID], shift)
+	}
+}
+
+type onCallShiftRequest struct {
+	TeamID  string    `json:"teamId"`
+	UserIDs []string  `json:"userIds"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+// handleAdminOnCall lists (GET ?teamId=), adds (POST) and removes (DELETE
+// ?id=) on-call shifts.
+func handleAdminOnCall(w http.ResponseWriter, r *http.Request) {
+	if onCallSchedules == nil {
+		http.Error(w, "On-call routing is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		now := time.Now()
+		teamID := strings.TrimSpace(r.URL.Query().Get("teamId"))
+		response := map[string]interface{}{"shifts": onCallSchedules.list(teamID, now)}
+		if teamID != "" {
+			users := onCallSchedules.onCall(teamID, now)
+			if users == nil {
+				users = []string{}
+			}
+			response["onCall"] = users
+		}
+		writeJSON(w, http.StatusOK, response)
+
+	case http.MethodPost:
+		var req onCallShiftRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		shift, err := onCallSchedules.add(onCallShift{TeamID: req.TeamID, UserIDs: req.UserIDs, Start: req.Start, End: req.End})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("On-call shift scheduled", "shift", shift.ID, "team", shift.TeamID, "users", shift.UserIDs, "start", shift.Start, "end", shift.End)
+		writeJSON(w, http.StatusCreated, shift)
+
+	case http.MethodDelete:
+		id := strings.TrimSpace(r.URL.Query().Get("id"))
+		if id == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		if !onCallSchedules.remove(id) {
+			http.Error(w, "Shift not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,148 @@
+// oncall_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnCallSchedule_PageAndHandoff(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	clients := make(map[string]*Client)
+	for _, userID := range []string{"alice", "bob", "carol"} {
+		clients[userID] = &Client{teamID: "team-1", userID: userID, send: make(chan outboundMessage, 4)}
+		hub.Register(clients[userID])
+	}
+	schedule := newOnCallSchedule(time.Hour, 10)
+	now := time.Now()
+	if _, err := schedule.add(onCallShift{TeamID: "team-1", UserIDs: []string{"alice"}, Start: now.Add(-time.Hour), End: now.Add(10 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedule.add(onCallShift{TeamID: "team-1", UserIDs: []string{" bob ", "bob"}, Start: now.Add(10 * time.Minute), End: now.Add(2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	message := NewMessage("page-1", "team-1", "oncall:team-1", "monitor", "alert", "disk full", true)
+	users, delivered, _ := schedule.page(context.Background(), hub, message, outboundMessage{teamID: "team-1", messageType: "alert", notificationID: "page-1"}, now)
+	if !reflect.DeepEqual(users, []string{"alice"}) || delivered != 1 {
+		t.Fatalf("expected alice to be paged, got %v and %d deliveries", users, delivered)
+	}
+	var paged Message
+	if err := json.Unmarshal((<-clients["alice"].send).payload, &paged); err != nil {
+		t.Fatal(err)
+	}
+	if paged.TargetUserID != "alice" || paged.OnCall != "team-1" || paged.NotificationID != "page-1" {
+		t.Fatalf("expected alice's own copy of the page, got %+v", paged)
+	}
+
+	if handed := schedule.handoff(hub, now.Add(5*time.Minute)); handed != 0 {
+		t.Fatalf("expected no handoff before the shift changes, got %d", handed)
+	}
+	// bob comes on call mid-incident and gets the page nobody acknowledged.
+	if handed := schedule.handoff(hub, now.Add(10*time.Minute)); handed != 1 || len(clients["bob"].send) != 1 {
+		t.Fatalf("expected the page to be handed over to bob, got %d", handed)
+	}
+	if handed := schedule.handoff(hub, now.Add(11*time.Minute)); handed != 0 {
+		t.Fatalf("expected bob to be paged once, got %d", handed)
+	}
+
+	schedule.acknowledge(clients["carol"], []string{"page-1"})
+	schedule.acknowledge(clients["bob"], []string{"page-1"})
+	if _, err := schedule.add(onCallShift{TeamID: "team-1", UserIDs: []string{"carol"}, Start: now.Add(15 * time.Minute), End: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if handed := schedule.handoff(hub, now.Add(20*time.Minute)); handed != 0 || len(clients["carol"].send) != 0 {
+		t.Fatalf("expected an acknowledged page not to be handed over, got %d", handed)
+	}
+	if users := schedule.onCall("team-1", now.Add(20*time.Minute)); !reflect.DeepEqual(users, []string{"bob", "carol"}) {
+		t.Fatalf("expected overlapping shifts to both be on call, got %v", users)
+	}
+
+	for _, shift := range []onCallShift{
+		{UserIDs: []string{"alice"}, End: now.Add(time.Hour)},
+		{TeamID: "team-1", UserIDs: []string{" "}, End: now.Add(time.Hour)},
+		{TeamID: "team-1", UserIDs: []string{"alice"}, Start: now, End: now},
+	} {
+		if _, err := schedule.add(shift); err == nil {
+			t.Errorf("expected %+v to be rejected", shift)
+		}
+	}
+}
+
+func TestHandleSendMessage_OnCall(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	alice := &Client{teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 4)}
+	hub.Register(alice)
+	send := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := send(`{"target_user_id":"oncall:team-1","message_type":"alert","body":"x"}`); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "not enabled") {
+		t.Fatalf("expected on-call targets to need oncall.enabled, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	onCallSchedules = newOnCallSchedule(time.Hour, 10)
+	defer func() { onCallSchedules = nil }()
+	if _, err := onCallSchedules.add(onCallShift{TeamID: "team-1", UserIDs: []string{"alice", "bob"}, End: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := send(`{"target_user_id":"oncall:team-1","message_type":"alert","body":"disk full"}`)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"on_call":["alice","bob"]`) || !strings.Contains(rr.Body.String(), `"delivered":1`) {
+		t.Fatalf("expected the on-call users to be paged, got %d %s", rr.Code, rr.Body.String())
+	}
+	if len(alice.send) != 1 {
+		t.Fatalf("expected alice to receive the page, got %d", len(alice.send))
+	}
+
+	for body, want := range map[string]string{
+		`{"target_team_id":"team-2","target_user_id":"oncall:team-1","message_type":"alert","body":"x"}`: "must be in target_team_id",
+		`{"target_user_id":"oncall:","message_type":"alert","body":"x"}`:                                 "must name a team",
+	} {
+		if rr := send(body); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected %s to be rejected with %q, got %d %s", body, want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestHandleAdminOnCall(t *testing.T) {
+	setupTestAppConfig()
+	onCallSchedules = newOnCallSchedule(time.Hour, 10)
+	defer func() { onCallSchedules = nil }()
+
+	rr := httptest.NewRecorder()
+	end := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	handleAdminOnCall(rr, httptest.NewRequest(http.MethodPost, "/admin/oncall", strings.NewReader(`{"teamId":"team-1","userIds":["alice"],"end":"`+end+`"}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var created onCallShift
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminOnCall(rr, httptest.NewRequest(http.MethodGet, "/admin/oncall?teamId=team-1", nil))
+	if !strings.Contains(rr.Body.String(), `"onCall":["alice"]`) {
+		t.Fatalf("expected alice to be on call, got %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminOnCall(rr, httptest.NewRequest(http.MethodDelete, "/admin/oncall?id="+created.ID, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if users := onCallSchedules.onCall("team-1", time.Now()); len(users) != 0 {
+		t.Fatalf("expected nobody on call after the shift was removed, got %v", users)
+	}
+}
@@ -0,0 +1,216 @@
+// outbox.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Outbox job statuses. Delivered jobs are deleted rather than kept.
+const (
+	outboxPending = "pending"
+	outboxFailed  = "failed"
+)
+
+// outboxTimeout bounds each outbox read or write made while sending.
+const outboxTimeout = 5 * time.Second
+
+var (
+	errOutboxJobNotFound  = errors.New("outbox job not found")
+	errOutboxJobNotFailed = errors.New("only failed outbox jobs can be retried")
+)
+
+// persist records job in the outbox, if the dispatcher has one. Failures are
+// logged rather than returned so delivery still goes ahead without it.
+func (d *webhookDispatcher) persist(job webhookJob, status, lastError string) {
+	if d.outbox == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), outboxTimeout)
+	defer cancel()
+	err := d.outbox.SaveOutboxJob(ctx, &OutboxJob{
+		ID:        job.id,
+		Kind:      job.Kind,
+		URL:       job.URL,
+		Payload:   json.RawMessage(job.Payload),
+		Headers:   job.Headers,
+		Status:    status,
+		Attempts:  job.attempt,
+		LastError: lastError,
+		CreatedAt: job.queuedAt,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		slog.Warn("Failed to record a webhook in the outbox", "kind", job.Kind, "webhook", job.id, "error", err)
+	}
+}
+
+// complete removes a delivered job from the outbox.
+func (d *webhookDispatcher) complete(job webhookJob) {
+	if d.outbox == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), outboxTimeout)
+	defer cancel()
+	if err := d.outbox.DeleteOutboxJob(ctx, job.id); err != nil {
+		slog.Warn("Failed to clear a delivered webhook from the outbox", "kind", job.Kind, "webhook", job.id, "error", err)
+	}
+}
+
+// replayOutbox queues every pending job left in the outbox, i.e. jobs that
+// were accepted but not finished before the last shutdown or crash. It
+// returns how many were queued.
+func (d *webhookDispatcher) replayOutbox(ctx context.Context) (int, error) {
+	if d == nil || d.outbox == nil {
+		return 0, nil
+	}
+
+	jobs, err := d.outbox.OutboxJobs(ctx, outboxPending)
+	if err != nil {
+		return 0, err
+	}
+	queued := 0
+	for _, job := range jobs {
+		if d.enqueue(webhookJobFromOutbox(job)) {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// retry puts a failed job back on the queue with a fresh set of attempts.
+func (d *webhookDispatcher) retry(ctx context.Context, id string) (*OutboxJob, error) {
+	stored, err := d.outbox.OutboxJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, errOutboxJobNotFound
+	}
+	if stored.Status != outboxFailed {
+		return nil, errOutboxJobNotFailed
+	}
+
+	stored.Status = outboxPending
+	stored.Attempts = 0
+	stored.LastError = ""
+	stored.UpdatedAt = time.Now()
+	if err := d.outbox.SaveOutboxJob(ctx, stored); err != nil {
+		return nil, err
+	}
+	d.enqueue(webhookJobFromOutbox(stored))
+	return stored, nil
+}
+
+func webhookJobFromOutbox(stored *OutboxJob) webhookJob {
+	return webhookJob{
+		Kind:     stored.Kind,
+		URL:      stored.URL,
+		Payload:  []byte(stored.Payload),
+		Headers:  stored.Headers,
+		id:       stored.ID,
+		attempt:  stored.Attempts,
+		queuedAt: stored.CreatedAt,
+	}
+}
+
+// outboxJobView is an outbox job as shown to operators. Header values, which
+// may carry signatures, are left out and the URL is redacted.
+type outboxJobView struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	URL       string          `json:"url"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"lastError,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+func newOutboxJobView(job *OutboxJob) outboxJobView {
+	return outboxJobView{
+		ID:        job.ID,
+		Kind:      job.Kind,
+		URL:       redactURL(job.URL),
+		Payload:   job.Payload,
+		Status:    job.Status,
+		Attempts:  job.Attempts,
+		LastError: job.LastError,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+}
+
+// handleAdminWebhookOutbox lists outbox jobs (GET, optionally filtered by
+// ?status=pending|failed), retries a failed job (POST ?id=) or discards one
+// (DELETE ?id=).
+func handleAdminWebhookOutbox(w http.ResponseWriter, r *http.Request) {
+	if outboundWebhooks == nil || outboundWebhooks.outbox == nil {
+		http.Error(w, "Webhook outbox is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	id := strings.TrimSpace(query.Get("id"))
+	if r.Method != http.MethodGet && id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		status := strings.TrimSpace(query.Get("status"))
+		if status != "" && status != outboxPending && status != outboxFailed {
+			http.Error(w, "status must be pending or failed", http.StatusBadRequest)
+			return
+		}
+		jobs, err := outboundWebhooks.outbox.OutboxJobs(r.Context(), status)
+		if err != nil {
+			slog.Error("Failed to list the webhook outbox", "error", err)
+			http.Error(w, "Failed to read the webhook outbox", http.StatusInternalServerError)
+			return
+		}
+		views := make([]outboxJobView, 0, len(jobs))
+		for _, job := range jobs {
+			views = append(views, newOutboxJobView(job))
+		}
+		writeJSONWithETag(w, r, map[string]interface{}{"jobs": views})
+
+	case http.MethodPost:
+		job, err := outboundWebhooks.retry(r.Context(), id)
+		switch {
+		case errors.Is(err, errOutboxJobNotFound):
+			http.Error(w, "Outbox job not found", http.StatusNotFound)
+			return
+		case errors.Is(err, errOutboxJobNotFailed):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			slog.Error("Failed to retry a webhook", "webhook", id, "error", err)
+			http.Error(w, "Failed to retry the webhook", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(auditEvent{Action: "webhooks.retry", Subject: id, Details: map[string]string{"kind": job.Kind}})
+		writeJSON(w, http.StatusAccepted, newOutboxJobView(job))
+
+	case http.MethodDelete:
+		if err := outboundWebhooks.outbox.DeleteOutboxJob(r.Context(), id); err != nil {
+			slog.Error("Failed to discard a webhook", "webhook", id, "error", err)
+			http.Error(w, "Failed to discard the webhook", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(auditEvent{Action: "webhooks.discard", Subject: id})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,194 @@
+// preferences.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxPreferenceKeyLength bounds the name of a preference.
+const maxPreferenceKeyLength = 128
+
+// preferencesTimeout bounds each store read or write made for a client.
+const preferencesTimeout = 5 * time.Second
+
+// preferenceSync keeps users' preferences in the store and turns changes into
+// versioned deltas. Changes made through this instance are applied one at a
+// time; instances sharing a store do not coordinate.
+type preferenceSync struct {
+	store        Store
+	maxKeys      int
+	maxValueSize int
+
+	mu sync.Mutex
+}
+
+// userPreferences is nil unless preferences.sync is set.
+var userPreferences *preferenceSync
+
+func newPreferenceSync(store Store, maxKeys, maxValueSize int) *preferenceSync {
+	return &preferenceSync{store: store, maxKeys: maxKeys, maxValueSize: maxValueSize}
+}
+
+// snapshot returns all of a user's preferences. A user without any is at
+// version 0.
+func (p *preferenceSync) snapshot(ctx context.Context, tenantID, userID string) (PreferencesSnapshotFrame, error) {
+	frame := PreferencesSnapshotFrame{Type: "preferencesSnapshot", Preferences: map[string]json.RawMessage{}}
+	saved, err := p.store.UserPreferences(ctx, tenantID, userID)
+	if err != nil || saved == nil {
+		return frame, err
+	}
+	frame.Version = saved.Version
+	frame.Preferences = saved.Values
+	return frame, nil
+}
+
+type preferencesSetParams struct {
+	Set    map[string]json.RawMessage `json:"set"`
+	Remove []string                   `json:"remove"`
+	// BaseVersion, when given, rejects the change unless the preferences
+	// are still at that version.
+	BaseVersion *int64 `json:"baseVersion"`
+}
+
+// update applies a change and returns it as a delta. A change that sets
+// every value to what it already is, and removes nothing that exists, keeps
+// the version and returns a delta with FromVersion equal to Version.
+func (p *preferenceSync) update(ctx context.Context, tenantID, userID string, change preferencesSetParams) (PreferencesDeltaFrame, error) {
+	for key, value := range change.Set {
+		if key == "" || len(key) > maxPreferenceKeyLength {
+			return PreferencesDeltaFrame{}, fmt.Errorf("preference names must be 1 to %d bytes", maxPreferenceKeyLength)
+		}
+		if len(value) > p.maxValueSize {
+			return PreferencesDeltaFrame{}, fmt.Errorf("preference %q exceeds %d bytes", key, p.maxValueSize)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	saved, err := p.store.UserPreferences(ctx, tenantID, userID)
+	if err != nil {
+		return PreferencesDeltaFrame{}, err
+	}
+	if saved == nil {
+		saved = &UserPreferences{TenantID: tenantID, UserID: userID, Values: map[string]json.RawMessage{}}
+	} else {
+		saved = copyUserPreferences(saved)
+	}
+	if change.BaseVersion != nil && *change.BaseVersion != saved.Version {
+		return PreferencesDeltaFrame{}, fmt.Errorf("preferences are at version %d, not %d", saved.Version, *change.BaseVersion)
+	}
+
+	delta := PreferencesDeltaFrame{Type: "preferencesDelta", FromVersion: saved.Version, Version: saved.Version}
+	for _, key := range change.Remove {
+		if _, ok := saved.Values[key]; ok && change.Set[key] == nil {
+			delete(saved.Values, key)
+			delta.Removed = append(delta.Removed, key)
+		}
+	}
+	for key, value := range change.Set {
+		if current, ok := saved.Values[key]; ok && string(current) == string(value) {
+			continue
+		}
+		if delta.Set == nil {
+			delta.Set = make(map[string]json.RawMessage)
+		}
+		saved.Values[key] = value
+		delta.Set[key] = value
+	}
+	if len(delta.Set) == 0 && len(delta.Removed) == 0 {
+		return delta, nil
+	}
+	if len(saved.Values) > p.maxKeys {
+		return PreferencesDeltaFrame{}, fmt.Errorf("at most %d preferences are kept per user", p.maxKeys)
+	}
+	sort.Strings(delta.Removed)
+
+	saved.Version++
+	saved.UpdatedAt = time.Now()
+	if err := p.store.SaveUserPreferences(ctx, saved); err != nil {
+		return PreferencesDeltaFrame{}, err
+	}
+	delta.Version = saved.Version
+	return delta, nil
+}
+
+// sendPreferencesSnapshot writes the client's preferences right after
+// authSuccess, from the writePump before it writes anything else. A delta
+// queued for the client since it registered may be older than the snapshot;
+// clients skip deltas whose version they already have.
+func sendPreferencesSnapshot(client *Client) {
+	if userPreferences == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preferencesTimeout)
+	defer cancel()
+	frame, err := userPreferences.snapshot(ctx, client.tenantID, client.userID)
+	if err != nil {
+		client.logger().Error("Failed to load preferences", "error", err)
+		return
+	}
+	keywordAlerts.watch(client, frame.Preferences)
+	writeJSONFrame(client.conn, client.protocol, frame)
+}
+
+type preferencesSetResult struct {
+	Version int64 `json:"version"`
+}
+
+func handlePreferencesGetRequest(c *Client, _ json.RawMessage) (interface{}, error) {
+	if userPreferences == nil {
+		return nil, errors.New("preference sync is not enabled")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preferencesTimeout)
+	defer cancel()
+	return userPreferences.snapshot(ctx, c.tenantID, c.userID)
+}
+
+// handlePreferencesSetRequest applies a change and pushes it to every
+// connection of the user, including this one, ahead of the response.
+func handlePreferencesSetRequest(c *Client, params json.RawMessage) (interface{}, error) {
+	if userPreferences == nil {
+		return nil, errors.New("preference sync is not enabled")
+	}
+	var p preferencesSetParams
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, errors.New("invalid params: " + err.Error())
+		}
+	}
+	var keywords []string
+	if keywordAlerts != nil {
+		var err error
+		if keywords, err = keywordAlerts.parse(p.Set[keywordWatchesPreference]); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), preferencesTimeout)
+	defer cancel()
+	delta, err := userPreferences.update(ctx, c.tenantID, c.userID, p)
+	if err != nil {
+		return nil, err
+	}
+	if delta.Version != delta.FromVersion {
+		payload, err := json.Marshal(delta)
+		if err != nil {
+			return nil, err
+		}
+		c.hub.SendControlToUser(c.tenantID, c.userID, nil, outboundMessage{payload: payload, tenantID: c.tenantID})
+		appMetrics.Count("preferences.changed", 1, tenantTags(c.tenantID)...)
+		_, set := delta.Set[keywordWatchesPreference]
+		if set || slices.Contains(delta.Removed, keywordWatchesPreference) {
+			keywordAlerts.update(c.tenantID, c.userID, keywords)
+		}
+	}
+	return preferencesSetResult{Version: delta.Version}, nil
+}
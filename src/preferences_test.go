@@ -0,0 +1,109 @@
+// preferences_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPreferenceSync_Update(t *testing.T) {
+	sync := newPreferenceSync(newMemoryStore(), 3, 16)
+	ctx := context.Background()
+	set := func(change preferencesSetParams) (PreferencesDeltaFrame, error) {
+		return sync.update(ctx, "", "alice", change)
+	}
+
+	delta, err := set(preferencesSetParams{Set: map[string]json.RawMessage{"theme": json.RawMessage(`"dark"`), "sound": json.RawMessage(`true`)}})
+	if err != nil || delta.FromVersion != 0 || delta.Version != 1 || len(delta.Set) != 2 {
+		t.Fatalf("unexpected first delta: %+v, %v", delta, err)
+	}
+	delta, err = set(preferencesSetParams{Set: map[string]json.RawMessage{"theme": json.RawMessage(`"dark"`)}, Remove: []string{"missing"}})
+	if err != nil || delta.Version != 1 || delta.FromVersion != 1 {
+		t.Errorf("expected an unchanged value to keep the version, got %+v, %v", delta, err)
+	}
+	delta, err = set(preferencesSetParams{Remove: []string{"sound"}, Set: map[string]json.RawMessage{"theme": json.RawMessage(`"light"`)}})
+	if err != nil || delta.FromVersion != 1 || delta.Version != 2 || len(delta.Removed) != 1 || string(delta.Set["theme"]) != `"light"` {
+		t.Errorf("unexpected delta: %+v, %v", delta, err)
+	}
+
+	stale := int64(1)
+	if _, err := set(preferencesSetParams{Set: map[string]json.RawMessage{"theme": json.RawMessage(`"blue"`)}, BaseVersion: &stale}); err == nil {
+		t.Error("expected a change based on an old version to be rejected")
+	}
+	if _, err := set(preferencesSetParams{Set: map[string]json.RawMessage{"theme": json.RawMessage(`"a much longer value"`)}}); err == nil {
+		t.Error("expected a value over max_value_size to be rejected")
+	}
+	if _, err := set(preferencesSetParams{Set: map[string]json.RawMessage{"a": json.RawMessage(`1`), "b": json.RawMessage(`2`), "c": json.RawMessage(`3`)}}); err == nil {
+		t.Error("expected more than max_keys preferences to be rejected")
+	}
+
+	snapshot, err := sync.snapshot(ctx, "", "alice")
+	if err != nil || snapshot.Version != 2 || len(snapshot.Preferences) != 1 || string(snapshot.Preferences["theme"]) != `"light"` {
+		t.Fatalf("expected rejected changes not to be saved, got %+v, %v", snapshot, err)
+	}
+	if other, _ := sync.snapshot(ctx, "acme", "alice"); other.Version != 0 || len(other.Preferences) != 0 {
+		t.Errorf("expected another tenant's alice to have no preferences, got %+v", other)
+	}
+}
+
+func TestHandleWebSocket_PreferencesSync(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Environment.Mode = "development"
+	AppConfig().Environment.EnableFakeAuth = true
+	authFailures = nil
+	userPreferences = newPreferenceSync(newMemoryStore(), 10, 100)
+	defer func() { userPreferences = nil }()
+	userPreferences.update(context.Background(), "", "user-p", preferencesSetParams{Set: map[string]json.RawMessage{"theme": json.RawMessage(`"dark"`)}})
+
+	hub := newHub()
+	go hub.run()
+	wsURL := newWebSocketTestServer(t, hub)
+
+	read := func(ws *websocket.Conn) map[string]json.RawMessage {
+		t.Helper()
+		var frame map[string]json.RawMessage
+		_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := ws.ReadJSON(&frame); err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		return frame
+	}
+	connect := func(team string) *websocket.Conn {
+		t.Helper()
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		ws.WriteJSON(AuthMessage{Type: "auth", TeamID: team, UserID: "user-p", Token: "fake_development_token"})
+		if reply := read(ws); string(reply["type"]) != `"authSuccess"` {
+			t.Fatalf("expected authSuccess, got %v", reply)
+		}
+		var snapshot PreferencesSnapshotFrame
+		frame, _ := json.Marshal(read(ws))
+		json.Unmarshal(frame, &snapshot)
+		if snapshot.Type != "preferencesSnapshot" || snapshot.Version != 1 || string(snapshot.Preferences["theme"]) != `"dark"` {
+			t.Fatalf("expected the preferences after authSuccess, got %s", frame)
+		}
+		return ws
+	}
+	phone, laptop := connect("team-a"), connect("team-b")
+	defer phone.Close()
+	defer laptop.Close()
+
+	phone.WriteJSON(ClientRequestFrame{Type: "request", RequestID: "r-1", Method: "preferences.set", Params: json.RawMessage(`{"set":{"theme":"light"},"baseVersion":1}`)})
+	for _, ws := range []*websocket.Conn{phone, laptop} {
+		var delta PreferencesDeltaFrame
+		frame, _ := json.Marshal(read(ws))
+		json.Unmarshal(frame, &delta)
+		if delta.Type != "preferencesDelta" || delta.FromVersion != 1 || delta.Version != 2 || string(delta.Set["theme"]) != `"light"` {
+			t.Fatalf("expected the delta on every device, got %s", frame)
+		}
+	}
+	if response := read(phone); string(response["ok"]) != "true" || string(response["result"]) != `{"version":2}` {
+		t.Errorf("expected the new version in the response, got %v", response)
+	}
+}
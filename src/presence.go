@@ -0,0 +1,196 @@
+// presence.go
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Presence states reported by /teams/presence-summary. They are derived from
+// the status clients set themselves: away and dnd are recognized, and any
+// other status, or none, counts as online.
+const (
+	presenceOnline = "online"
+	presenceAway   = "away"
+	presenceDND    = "dnd"
+)
+
+// presenceRank orders states from most to least available. A user with
+// several connections counts once, in the most available state of any.
+var presenceRank = map[string]int{presenceOnline: 0, presenceAway: 1, presenceDND: 2}
+
+func presenceOf(status string) string {
+	switch strings.ToLower(status) {
+	case presenceAway:
+		return presenceAway
+	case presenceDND:
+		return presenceDND
+	}
+	return presenceOnline
+}
+
+// presenceCounts counts a team's connected users by presence state.
+type presenceCounts struct {
+	Users  int `json:"users"`
+	Online int `json:"online"`
+	Away   int `json:"away"`
+	DND    int `json:"dnd"`
+}
+
+func (p *presenceCounts) add(state string) {
+	p.Users++
+	switch state {
+	case presenceAway:
+		p.Away++
+	case presenceDND:
+		p.DND++
+	default:
+		p.Online++
+	}
+}
+
+func (p *presenceCounts) addCounts(other presenceCounts) {
+	p.Users += other.Users
+	p.Online += other.Online
+	p.Away += other.Away
+	p.DND += other.DND
+}
+
+// TeamPresence is one team's row in a presence summary. TeamID is the ID as
+// the team's tenant knows it.
+type TeamPresence struct {
+	TeamID string `json:"team_id"`
+	presenceCounts
+	tenantID string
+}
+
+// teamPresence counts every connected team's users by presence state,
+// sorted by tenant and team.
+func (h *Hub) teamPresence() []TeamPresence {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	teams := make([]TeamPresence, 0, len(h.clients))
+	for scopedTeam, teamClients := range h.clients {
+		team := TeamPresence{TeamID: scopedTeam}
+		for _, userClients := range teamClients {
+			state := ""
+			for client := range userClients {
+				team.tenantID = client.tenantID
+				candidate := presenceOf(client.attributes.currentStatus())
+				if state == "" || presenceRank[candidate] < presenceRank[state] {
+					state = candidate
+				}
+			}
+			if state != "" {
+				team.add(state)
+			}
+		}
+		team.TeamID = unscopedTeamID(team.tenantID, scopedTeam)
+		teams = append(teams, team)
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		if teams[i].tenantID != teams[j].tenantID {
+			return teams[i].tenantID < teams[j].tenantID
+		}
+		return teams[i].TeamID < teams[j].TeamID
+	})
+	return teams
+}
+
+// presenceSummaryCache keeps the latest presence of every team for ttl, so
+// dashboards polling the summary do not walk the whole roster each time.
+type presenceSummaryCache struct {
+	hub *Hub
+	ttl time.Duration
+
+	mu      sync.Mutex
+	teams   []TeamPresence
+	takenAt time.Time
+}
+
+// teamPresenceSummaries serves /teams/presence-summary; set in main().
+var teamPresenceSummaries *presenceSummaryCache
+
+func newPresenceSummaryCache(hub *Hub, ttl time.Duration) *presenceSummaryCache {
+	return &presenceSummaryCache{hub: hub, ttl: ttl}
+}
+
+// get returns the cached summary, taking a new one when it is older than
+// ttl. The returned slice must not be modified.
+func (c *presenceSummaryCache) get(now time.Time) ([]TeamPresence, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.takenAt.IsZero() || now.Sub(c.takenAt) >= c.ttl {
+		c.teams = c.hub.teamPresence()
+		c.takenAt = now
+	}
+	return c.teams, c.takenAt
+}
+
+type presenceSummaryResponse struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Teams       []TeamPresence `json:"teams"`
+	Totals      presenceCounts `json:"totals"`
+}
+
+// handleTeamPresenceSummary serves GET /teams/presence-summary: presence
+// counts for every connected team of the caller's tenant or, with team_id,
+// for the listed teams only. team_id may be repeated or comma-separated;
+// listed teams without connections are reported with zero counts.
+func handleTeamPresenceSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tenantID, err := sendTenantID(r, &MessageRequest{TenantID: strings.TrimSpace(r.URL.Query().Get("tenant_id"))})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var wanted []string
+	for _, value := range r.URL.Query()["team_id"] {
+		for _, teamID := range strings.Split(value, ",") {
+			if teamID = strings.TrimSpace(teamID); teamID != "" {
+				wanted = append(wanted, teamID)
+			}
+		}
+	}
+
+	teams, takenAt := teamPresenceSummaries.get(time.Now())
+	response := presenceSummaryResponse{GeneratedAt: takenAt, Teams: []TeamPresence{}}
+	if len(wanted) == 0 {
+		for _, team := range teams {
+			if team.tenantID == tenantID {
+				response.Teams = append(response.Teams, team)
+			}
+		}
+	} else {
+		byTeam := make(map[string]TeamPresence)
+		for _, team := range teams {
+			if team.tenantID == tenantID {
+				byTeam[team.TeamID] = team
+			}
+		}
+		seen := make(map[string]bool, len(wanted))
+		for _, teamID := range wanted {
+			if seen[teamID] {
+				continue
+			}
+			seen[teamID] = true
+			team, ok := byTeam[teamID]
+			if !ok {
+				team = TeamPresence{TeamID: teamID}
+			}
+			response.Teams = append(response.Teams, team)
+		}
+	}
+	for _, team := range response.Teams {
+		response.Totals.addCounts(team.presenceCounts)
+	}
+	writeJSONWithETag(w, r, response)
+}
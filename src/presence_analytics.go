@@ -0,0 +1,354 @@
+// presence_analytics.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// presenceRollupPeriods are the rollups every transition is recorded in.
+var presenceRollupPeriods = []string{rollupHour, rollupDay}
+
+// presenceRollupStart returns the UTC start of the period that t falls in.
+func presenceRollupStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	if period == rollupDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+func presenceRollupEnd(period string, start time.Time) time.Time {
+	if period == rollupDay {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.Add(time.Hour)
+}
+
+type presenceRollupKey struct {
+	team   string // hub key, with the tenant prefix
+	period string
+	start  int64
+}
+
+// presenceRecorder turns connects, disconnects and status changes into
+// hourly and daily rollups per team, which flush writes to the store. Open
+// periods are kept in memory until they end and have been flushed.
+type presenceRecorder struct {
+	instance string
+
+	mu       sync.Mutex
+	users    map[string]map[string]int // team -> user -> open connections
+	tenants  map[string]string         // team -> tenant
+	sessions map[*Client]time.Time     // connected clients and when they registered
+	rollups  map[presenceRollupKey]*PresenceRollup
+	dirty    map[presenceRollupKey]bool
+}
+
+// presenceHistory is nil unless analytics.presence is set, and all methods
+// are nil-safe.
+var presenceHistory *presenceRecorder
+
+func newPresenceRecorder(instance string) *presenceRecorder {
+	return &presenceRecorder{
+		instance: instance,
+		users:    make(map[string]map[string]int),
+		tenants:  make(map[string]string),
+		sessions: make(map[*Client]time.Time),
+		rollups:  make(map[presenceRollupKey]*PresenceRollup),
+		dirty:    make(map[presenceRollupKey]bool),
+	}
+}
+
+// rollupLocked returns team's open rollup for the period containing now. A
+// new period starts with the users already connected as its peak.
+func (p *presenceRecorder) rollupLocked(team, period string, now time.Time) *PresenceRollup {
+	start := presenceRollupStart(period, now)
+	key := presenceRollupKey{team: team, period: period, start: start.Unix()}
+	rollup, ok := p.rollups[key]
+	if !ok {
+		tenantID := p.tenants[team]
+		rollup = &PresenceRollup{
+			Instance:  p.instance,
+			TenantID:  tenantID,
+			TeamID:    unscopedTeamID(tenantID, team),
+			Period:    period,
+			Start:     start,
+			PeakUsers: len(p.users[team]),
+		}
+		p.rollups[key] = rollup
+	}
+	p.dirty[key] = true
+	return rollup
+}
+
+// connected records a client the hub registered.
+func (p *presenceRecorder) connected(client *Client, now time.Time) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.users[client.teamID] == nil {
+		p.users[client.teamID] = make(map[string]int)
+	}
+	p.users[client.teamID][client.userID]++
+	p.tenants[client.teamID] = client.tenantID
+	p.sessions[client] = now
+	for _, period := range presenceRollupPeriods {
+		rollup := p.rollupLocked(client.teamID, period, now)
+		rollup.Connects++
+		if users := len(p.users[client.teamID]); users > rollup.PeakUsers {
+			rollup.PeakUsers = users
+		}
+	}
+}
+
+// disconnected records the end of a client's session.
+func (p *presenceRecorder) disconnected(client *Client, now time.Time) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	connectedAt, ok := p.sessions[client]
+	if !ok {
+		return
+	}
+	delete(p.sessions, client)
+	// A period that starts with this disconnect still had the user at first.
+	for _, period := range presenceRollupPeriods {
+		rollup := p.rollupLocked(client.teamID, period, now)
+		rollup.Sessions++
+		rollup.SessionSeconds += now.Sub(connectedAt).Seconds()
+	}
+	if p.users[client.teamID][client.userID]--; p.users[client.teamID][client.userID] <= 0 {
+		delete(p.users[client.teamID], client.userID)
+	}
+	if len(p.users[client.teamID]) == 0 {
+		delete(p.users, client.teamID)
+	}
+}
+
+// statusChanged records a client moving between online, away and dnd.
+// Changes within one presence state, such as between two custom statuses,
+// are not transitions.
+func (p *presenceRecorder) statusChanged(client *Client, from, to string, now time.Time) {
+	if p == nil || presenceOf(from) == presenceOf(to) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.sessions[client]; !ok {
+		return
+	}
+	for _, period := range presenceRollupPeriods {
+		p.rollupLocked(client.teamID, period, now).StatusChanges++
+	}
+}
+
+// flush saves the rollups that changed since the last flush, and forgets
+// those whose period has ended once they are saved. Teams with connected
+// users get a rollup for the current periods even without transitions, so
+// that their peak is recorded.
+func (p *presenceRecorder) flush(ctx context.Context, store Store, now time.Time) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	for team := range p.users {
+		for _, period := range presenceRollupPeriods {
+			p.rollupLocked(team, period, now)
+		}
+	}
+	pending := make(map[presenceRollupKey]PresenceRollup, len(p.dirty))
+	for key := range p.dirty {
+		rollup := *p.rollups[key]
+		rollup.UpdatedAt = now
+		pending[key] = rollup
+	}
+	p.dirty = make(map[presenceRollupKey]bool)
+	p.mu.Unlock()
+
+	var firstErr error
+	for key, rollup := range pending {
+		rollup := rollup
+		if err := store.SavePresenceRollup(ctx, &rollup); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			p.mu.Lock()
+			p.dirty[key] = true // retried on the next flush
+			p.mu.Unlock()
+		}
+	}
+
+	p.mu.Lock()
+	for key, rollup := range p.rollups {
+		if !p.dirty[key] && !presenceRollupEnd(rollup.Period, rollup.Start).After(now) {
+			delete(p.rollups, key)
+		}
+	}
+	p.mu.Unlock()
+	return firstErr
+}
+
+// run flushes every interval until stop is closed, then flushes once more.
+func (p *presenceRecorder) run(store Store, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	flush := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		if err := p.flush(ctx, store, time.Now()); err != nil {
+			slog.Error("Failed to save presence rollups", "error", err)
+		}
+	}
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case <-stop:
+			flush()
+			return
+		}
+	}
+}
+
+// presenceRollupView is one team's rollup for one period in
+// /admin/analytics/presence, summed over the instances that recorded it.
+type presenceRollupView struct {
+	TenantID              string    `json:"tenantId,omitempty"`
+	TeamID                string    `json:"teamId"`
+	Start                 time.Time `json:"start"`
+	PeakUsers             int       `json:"peakUsers"`
+	Connects              int       `json:"connects"`
+	Sessions              int       `json:"sessions"`
+	AverageSessionSeconds float64   `json:"averageSessionSeconds"`
+	StatusChanges         int       `json:"statusChanges"`
+	Instances             int       `json:"instances"`
+
+	sessionSeconds float64
+}
+
+// mergePresenceRollups sums each team's rollups for the same period across
+// instances. Peaks are added, so with several instances PeakUsers is an
+// upper bound: the instances' peaks need not have happened at once.
+func mergePresenceRollups(rollups []*PresenceRollup) []presenceRollupView {
+	views := make([]presenceRollupView, 0, len(rollups))
+	index := make(map[string]int)
+	for _, rollup := range rollups {
+		key := strings.Join([]string{rollup.Start.UTC().Format(time.RFC3339), rollup.TenantID, rollup.TeamID}, "\n")
+		i, ok := index[key]
+		if !ok {
+			i = len(views)
+			index[key] = i
+			views = append(views, presenceRollupView{TenantID: rollup.TenantID, TeamID: rollup.TeamID, Start: rollup.Start.UTC()})
+		}
+		view := &views[i]
+		view.PeakUsers += rollup.PeakUsers
+		view.Connects += rollup.Connects
+		view.Sessions += rollup.Sessions
+		view.sessionSeconds += rollup.SessionSeconds
+		view.StatusChanges += rollup.StatusChanges
+		view.Instances++
+	}
+	for i := range views {
+		if views[i].Sessions > 0 {
+			views[i].AverageSessionSeconds = views[i].sessionSeconds / float64(views[i].Sessions)
+		}
+	}
+	return views
+}
+
+// analyticsRange reads the from and to parameters of the analytics
+// endpoints as RFC 3339 times. to defaults to now and from to span before to.
+func analyticsRange(query url.Values, now time.Time, span time.Duration) (time.Time, time.Time, error) {
+	to := now
+	if raw := strings.TrimSpace(query.Get("to")); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be an RFC 3339 time")
+		}
+		to = parsed
+	}
+	from := to.Add(-span)
+	if raw := strings.TrimSpace(query.Get("from")); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be an RFC 3339 time")
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// handleAdminPresenceAnalytics serves GET /admin/analytics/presence:
+// period is hour (default) or day, from and to are RFC 3339 times, and
+// tenant_id and team_id narrow the result. Without from, the last day of
+// hourly or the last 30 days of daily rollups are returned.
+func handleAdminPresenceAnalytics(recorder *presenceRecorder, store Store, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if recorder == nil {
+		http.Error(w, "Presence analytics are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	period := firstNonEmpty(strings.TrimSpace(query.Get("period")), rollupHour)
+	if period != rollupHour && period != rollupDay {
+		http.Error(w, "period must be hour or day", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	span := 24 * time.Hour
+	if period == rollupDay {
+		span = 30 * 24 * time.Hour
+	}
+	from, to, err := analyticsRange(query, now, span)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenantID := strings.TrimSpace(query.Get("tenant_id"))
+	teamID := strings.TrimSpace(query.Get("team_id"))
+
+	// Include what this instance has recorded since its last flush.
+	if err := recorder.flush(r.Context(), store, now); err != nil {
+		slog.Error("Failed to save presence rollups", "error", err)
+	}
+	rollups, err := store.PresenceRollups(r.Context(), period, presenceRollupStart(period, from), to)
+	if err != nil {
+		slog.Error("Failed to read presence rollups", "error", err)
+		http.Error(w, "Failed to read presence rollups", http.StatusInternalServerError)
+		return
+	}
+	filtered := rollups[:0]
+	for _, rollup := range rollups {
+		if rollup.TenantID == tenantID && (teamID == "" || rollup.TeamID == teamID) {
+			filtered = append(filtered, rollup)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"period":  period,
+		"from":    presenceRollupStart(period, from),
+		"to":      to.UTC(),
+		"rollups": mergePresenceRollups(filtered),
+	})
+}
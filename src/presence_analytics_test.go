@@ -0,0 +1,106 @@
+// presence_analytics_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPresenceRecorder_Rollups(t *testing.T) {
+	store := newMemoryStore()
+	recorder := newPresenceRecorder("node-1")
+	base := time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	alice := &Client{teamID: "team-a", userID: "alice"}
+	aliceMobile := &Client{teamID: "team-a", userID: "alice"}
+	bob := &Client{teamID: "team-a", userID: "bob"}
+
+	recorder.connected(alice, base.Add(5*time.Minute))
+	recorder.connected(aliceMobile, base.Add(6*time.Minute)) // the same user twice
+	recorder.connected(bob, base.Add(10*time.Minute))
+	recorder.statusChanged(bob, "", "away", base.Add(15*time.Minute))
+	recorder.statusChanged(bob, "away", "AWAY", base.Add(16*time.Minute)) // not a transition
+	recorder.disconnected(aliceMobile, base.Add(36*time.Minute))
+	recorder.disconnected(bob, base.Add(70*time.Minute)) // in the next hour
+	if err := recorder.flush(ctx, store, base.Add(75*time.Minute)); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	hours, _ := store.PresenceRollups(ctx, rollupHour, base, base.Add(2*time.Hour))
+	if len(hours) != 2 {
+		t.Fatalf("expected two hourly rollups, got %+v", hours)
+	}
+	first, second := hours[0], hours[1]
+	if first.PeakUsers != 2 || first.Connects != 3 || first.Sessions != 1 || first.SessionSeconds != 30*60 || first.StatusChanges != 1 {
+		t.Errorf("unexpected first hour: %+v", first)
+	}
+	// The second hour starts with both users still connected.
+	if second.PeakUsers != 2 || second.Connects != 0 || second.Sessions != 1 || second.SessionSeconds != 60*60 || second.Instance != "node-1" {
+		t.Errorf("unexpected second hour: %+v", second)
+	}
+	days, _ := store.PresenceRollups(ctx, rollupDay, base.Truncate(24*time.Hour), base.Add(24*time.Hour))
+	if len(days) != 1 || days[0].Sessions != 2 || days[0].PeakUsers != 2 {
+		t.Fatalf("unexpected daily rollup: %+v", days)
+	}
+
+	// Once its period has ended and it has been saved, an hour is forgotten.
+	recorder.mu.Lock()
+	open := len(recorder.rollups)
+	recorder.mu.Unlock()
+	if open != 2 {
+		t.Errorf("expected only the current hour and day to stay open, got %d", open)
+	}
+
+	// A connected team gets a rollup for an hour without transitions.
+	if err := recorder.flush(ctx, store, base.Add(3*time.Hour)); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if quiet, _ := store.PresenceRollups(ctx, rollupHour, base.Add(3*time.Hour), base.Add(4*time.Hour)); len(quiet) != 1 || quiet[0].PeakUsers != 1 {
+		t.Errorf("expected alice's desktop to be counted in a quiet hour, got %+v", quiet)
+	}
+}
+
+func TestHandleAdminPresenceAnalytics(t *testing.T) {
+	setupTestAppConfig()
+	recorder := newPresenceRecorder("node-1")
+	store := newMemoryStore()
+	ctx := context.Background()
+
+	start := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	for _, rollup := range []*PresenceRollup{
+		{Instance: "node-1", TeamID: "team-a", Period: rollupHour, Start: start, PeakUsers: 3, Sessions: 2, SessionSeconds: 600},
+		{Instance: "node-2", TeamID: "team-a", Period: rollupHour, Start: start, PeakUsers: 2, Sessions: 2, SessionSeconds: 200},
+		{Instance: "node-1", TeamID: "team-b", Period: rollupHour, Start: start, PeakUsers: 1},
+		{Instance: "node-1", TenantID: "acme", TeamID: "team-a", Period: rollupHour, Start: start, PeakUsers: 9},
+	} {
+		store.SavePresenceRollup(ctx, rollup)
+	}
+
+	rr := httptest.NewRecorder()
+	handleAdminPresenceAnalytics(recorder, store, rr, httptest.NewRequest("GET", "/admin/analytics/presence?team_id=team-a", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Rollups []presenceRollupView `json:"rollups"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Rollups) != 1 {
+		t.Fatalf("expected team-a's merged rollup, got %+v", response.Rollups)
+	}
+	merged := response.Rollups[0]
+	if merged.PeakUsers != 5 || merged.Sessions != 4 || merged.AverageSessionSeconds != 200 || merged.Instances != 2 {
+		t.Errorf("unexpected merged rollup: %+v", merged)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminPresenceAnalytics(recorder, store, rr, httptest.NewRequest("GET", "/admin/analytics/presence?period=week", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown period, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,84 @@
+// presence_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleTeamPresenceSummary(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	go hub.run()
+
+	client := func(tenantID, team, user, status string) *Client {
+		teamID, _ := scopeTeam(tenantID, team)
+		c := &Client{hub: hub, tenantID: tenantID, teamID: teamID, userID: user, send: make(chan outboundMessage, 8)}
+		c.attributes.setStatus(status)
+		return c
+	}
+	registerAndWait(t, hub,
+		client("", "team-a", "user-1", ""),
+		client("", "team-a", "user-2", "away"),
+		client("", "team-a", "user-3", "dnd"),
+		client("", "team-a", "user-3", "DND"),
+		client("", "team-a", "user-4", "dnd"),
+		client("", "team-a", "user-4", "away"), // the most available connection counts
+		client("", "team-b", "user-5", "in a meeting"),
+		client("acme", "team-a", "user-6", "away"),
+	)
+	teamPresenceSummaries = newPresenceSummaryCache(hub, time.Minute)
+	defer func() { teamPresenceSummaries = nil }()
+
+	get := func(r *http.Request) presenceSummaryResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handleTeamPresenceSummary(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response presenceSummaryResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode: %v", err)
+		}
+		return response
+	}
+
+	all := get(httptest.NewRequest("GET", "/teams/presence-summary", nil))
+	if len(all.Teams) != 2 {
+		t.Fatalf("expected the two default-namespace teams, got %+v", all.Teams)
+	}
+	want := presenceCounts{Users: 4, Online: 1, Away: 2, DND: 1}
+	if all.Teams[0].TeamID != "team-a" || all.Teams[0].presenceCounts != want {
+		t.Errorf("expected team-a %+v, got %+v", want, all.Teams[0])
+	}
+	if all.Teams[1].TeamID != "team-b" || all.Teams[1].Online != 1 {
+		t.Errorf("expected an unrecognized status to count as online, got %+v", all.Teams[1])
+	}
+	if all.Totals.Users != 5 || all.Totals.Online != 2 {
+		t.Errorf("unexpected totals: %+v", all.Totals)
+	}
+
+	filtered := get(httptest.NewRequest("GET", "/teams/presence-summary?team_id=team-b,team-z&team_id=team-b", nil))
+	if len(filtered.Teams) != 2 || filtered.Teams[0].TeamID != "team-b" || filtered.Teams[1].TeamID != "team-z" || filtered.Teams[1].Users != 0 {
+		t.Errorf("expected team-b and an empty team-z, got %+v", filtered.Teams)
+	}
+
+	tenant := get(withTenant(httptest.NewRequest("GET", "/teams/presence-summary", nil), &TenantConfig{ID: "acme"}))
+	if len(tenant.Teams) != 1 || tenant.Teams[0].TeamID != "team-a" || tenant.Teams[0].Away != 1 {
+		t.Errorf("expected only acme's team-a, got %+v", tenant.Teams)
+	}
+
+	// Until the TTL passes, later changes are not seen.
+	registerAndWait(t, hub, client("", "team-b", "user-7", ""))
+	if cached := get(httptest.NewRequest("GET", "/teams/presence-summary?team_id=team-b", nil)); cached.Teams[0].Users != 1 || !cached.GeneratedAt.Equal(all.GeneratedAt) {
+		t.Errorf("expected the cached summary, got %+v", cached)
+	}
+	teams, _ := teamPresenceSummaries.get(time.Now().Add(time.Minute))
+	if teams[1].Users != 2 {
+		t.Errorf("expected a fresh summary after the TTL, got %+v", teams[1])
+	}
+}
@@ -0,0 +1,111 @@
+// protocol.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// wireProtocol is the frame format negotiated through Sec-WebSocket-Protocol.
+type wireProtocol string
+
+const (
+	// protocolJSONv1 is the original format: every frame is a JSON text frame
+	// and notifications are sent as bare Message objects. Clients that offer
+	// no subprotocol get it without an echoed header.
+	protocolJSONv1 wireProtocol = "json.v1"
+	// protocolJSONv2 wraps notifications as {"type":"notification",
+	// "notification":{...}} so that every frame can be dispatched on "type".
+	protocolJSONv2 wireProtocol = "json.v2"
+	// protocolMsgpackV1 carries the json.v1 frames as MessagePack in binary
+	// frames. The auth frame may be sent as binary MessagePack or text JSON.
+	protocolMsgpackV1 wireProtocol = "msgpack.v1"
+)
+
+// supportedProtocols lists the subprotocols the server accepts.
+var supportedProtocols = []wireProtocol{protocolJSONv1, protocolJSONv2, protocolMsgpackV1}
+
+// negotiateProtocol picks the first subprotocol the client offers that the
+// server supports. offered is false when the client sent no
+// Sec-WebSocket-Protocol header; an error means none of its offers is known.
+func negotiateProtocol(r *http.Request) (protocol wireProtocol, offered bool, err error) {
+	offers := websocket.Subprotocols(r)
+	if len(offers) == 0 {
+		return protocolJSONv1, false, nil
+	}
+	for _, offer := range offers {
+		for _, supported := range supportedProtocols {
+			if offer == string(supported) {
+				return supported, true, nil
+			}
+		}
+	}
+	return "", true, errors.New("unsupported websocket subprotocol")
+}
+
+func supportedProtocolList() string {
+	names := make([]string, len(supportedProtocols))
+	for i, protocol := range supportedProtocols {
+		names[i] = string(protocol)
+	}
+	return strings.Join(names, ", ")
+}
+
+// encodeFrame turns a JSON frame into the websocket message type and payload
+// sent under p. notification marks notification frames, which json.v2 wraps.
+func (p wireProtocol) encodeFrame(payload []byte, notification bool) (int, []byte, error) {
+	switch p {
+	case protocolJSONv2:
+		if !notification {
+			return websocket.TextMessage, payload, nil
+		}
+		// The payload is JSON already, so it is wrapped without re-encoding.
+		const prefix, suffix = `{"type":"notification","notification":`, `}`
+		wrapped := make([]byte, 0, len(prefix)+len(payload)+len(suffix))
+		wrapped = append(append(append(wrapped, prefix...), payload...), suffix...)
+		return websocket.TextMessage, wrapped, nil
+	case protocolMsgpackV1:
+		encoded, err := jsonToMsgpack(payload)
+		return websocket.BinaryMessage, encoded, err
+	default:
+		return websocket.TextMessage, payload, nil
+	}
+}
+
+// decodeFrame returns the JSON form of a frame received from the client.
+func (p wireProtocol) decodeFrame(messageType int, data []byte) ([]byte, error) {
+	if messageType != websocket.BinaryMessage {
+		return data, nil
+	}
+	if p != protocolMsgpackV1 {
+		return nil, errors.New("binary frames require the msgpack.v1 subprotocol")
+	}
+	return msgpackToJSON(data)
+}
+
+// writeFrame writes a JSON frame in the connection's protocol. The caller sets
+// the write deadline.
+func (c *Client) writeFrame(payload []byte, notification bool) error {
+	messageType, data, err := c.encodeFrame(payload, notification)
+	if err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// writeJSONFrame marshals v and writes it as a control frame in protocol.
+func writeJSONFrame(conn Conn, protocol wireProtocol, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	messageType, data, err := protocol.encodeFrame(payload, false)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(messageType, data)
+}
@@ -0,0 +1,164 @@
+// protocol_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	tests := []string{
+		`null`,
+		`true`,
+		`{"a":1,"b":[-1,-33,200,70000,-70000,5000000000,1.5],"c":{"d":null,"e":false}}`,
+		`"` + strings.Repeat("x", 40) + `"`,
+		`{"long":"` + strings.Repeat("y", 300) + `"}`,
+	}
+	for _, input := range tests {
+		encoded, err := jsonToMsgpack([]byte(input))
+		if err != nil {
+			t.Fatalf("jsonToMsgpack(%q): %v", input, err)
+		}
+		decoded, err := msgpackToJSON(encoded)
+		if err != nil {
+			t.Fatalf("msgpackToJSON(%q): %v", input, err)
+		}
+
+		var want, got interface{}
+		_ = json.Unmarshal([]byte(input), &want)
+		_ = json.Unmarshal(decoded, &got)
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("round trip of %q gave %s", input, decoded)
+		}
+	}
+}
+
+func TestMsgpackToJSONRejectsMalformedInput(t *testing.T) {
+	tests := map[string][]byte{
+		"truncated string": {0xa5, 'a'},
+		"trailing data":    {0xc0, 0xc0},
+		"non-string key":   {0x81, 0x01, 0x01},
+		"ext type":         {0xd4, 0x01, 0x00},
+		"huge array":       {0xdd, 0xff, 0xff, 0xff, 0xff},
+	}
+	for name, input := range tests {
+		if _, err := msgpackToJSON(input); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEncodeFrame(t *testing.T) {
+	payload := []byte(`{"messageType":"m","body":"x"}`)
+
+	messageType, data, _ := protocolJSONv1.encodeFrame(payload, true)
+	if messageType != websocket.TextMessage || string(data) != string(payload) {
+		t.Fatalf("json.v1 should send notifications unchanged, got %s", data)
+	}
+
+	_, data, _ = protocolJSONv2.encodeFrame(payload, true)
+	if string(data) != `{"type":"notification","notification":{"messageType":"m","body":"x"}}` {
+		t.Fatalf("json.v2 should wrap notifications, got %s", data)
+	}
+	_, data, _ = protocolJSONv2.encodeFrame(payload, false)
+	if string(data) != string(payload) {
+		t.Fatalf("json.v2 should send control frames unchanged, got %s", data)
+	}
+
+	messageType, data, _ = protocolMsgpackV1.encodeFrame(payload, true)
+	if messageType != websocket.BinaryMessage {
+		t.Fatalf("msgpack.v1 should send binary frames, got type %d", messageType)
+	}
+	if decoded, err := msgpackToJSON(data); err != nil || string(decoded) != `{"body":"x","messageType":"m"}` {
+		t.Fatalf("msgpack.v1 frame decoded to %s, %v", decoded, err)
+	}
+}
+
+func TestHandleWebSocketSubprotocols(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Environment.Mode = "development"
+	AppConfig().Environment.EnableFakeAuth = true
+	authFailures = nil
+	hub := newHub()
+	go hub.run()
+	wsURL := newWebSocketTestServer(t, hub)
+
+	tests := []struct {
+		name         string
+		offer        []string
+		wantStatus   int
+		wantProtocol string
+	}{
+		{"no offer", nil, http.StatusSwitchingProtocols, ""},
+		{"json.v2", []string{"json.v2"}, http.StatusSwitchingProtocols, "json.v2"},
+		{"client preference", []string{"chat", "msgpack.v1", "json.v1"}, http.StatusSwitchingProtocols, "msgpack.v1"},
+		{"unknown only", []string{"json.v9"}, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: tt.offer}
+			ws, resp, err := dialer.Dial(wsURL, nil)
+			if resp == nil {
+				t.Fatalf("no handshake response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if ws == nil {
+				return
+			}
+			defer ws.Close()
+			if ws.Subprotocol() != tt.wantProtocol {
+				t.Fatalf("expected subprotocol %q, got %q", tt.wantProtocol, ws.Subprotocol())
+			}
+
+			auth := []byte(`{"type":"auth","teamId":"team-p","userId":"user-p","token":"fake_development_token"}`)
+			messageType := websocket.TextMessage
+			if tt.wantProtocol == string(protocolMsgpackV1) {
+				auth, _ = jsonToMsgpack(auth)
+				messageType = websocket.BinaryMessage
+			}
+			if err := ws.WriteMessage(messageType, auth); err != nil {
+				t.Fatalf("failed to send auth: %v", err)
+			}
+
+			_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+			gotType, data, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatalf("failed to read auth reply: %v", err)
+			}
+			if gotType != messageType {
+				t.Fatalf("expected reply frame type %d, got %d", messageType, gotType)
+			}
+			if gotType == websocket.BinaryMessage {
+				data, _ = msgpackToJSON(data)
+			}
+			var reply map[string]interface{}
+			if err := json.Unmarshal(data, &reply); err != nil || reply["type"] != "authSuccess" {
+				t.Fatalf("expected authSuccess, got %s", data)
+			}
+			wantProtocol := tt.wantProtocol
+			if wantProtocol == "" {
+				wantProtocol = string(protocolJSONv1)
+			}
+			if reply["protocol"] != wantProtocol {
+				t.Fatalf("expected authSuccess to report %s, got %q", wantProtocol, reply["protocol"])
+			}
+
+			ws.Close()
+			for deadline := time.Now().Add(2 * time.Second); hub.getTotalClientCount() > 0; {
+				if time.Now().After(deadline) {
+					t.Fatal("client was not unregistered after closing")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// pump_supervisor.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// connectionGoroutineBudget bounds the goroutines one connection may run:
+// the supervisor, both pumps and helpers started with spawn.
+const connectionGoroutineBudget = 4
+
+// pumpSupervisor ties a connection's goroutines to one context. When either
+// pump exits, the supervisor stops the other, then unregisters the client.
+type pumpSupervisor struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	goroutines atomic.Int32
+}
+
+// pumpExit is how a pump stopped. err is nil when the connection ended
+// normally, such as the client closing it or the hub unregistering it.
+type pumpExit struct {
+	pump     string
+	err      error
+	panicked bool
+}
+
+// startPumps starts the client's read and write pumps under a supervisor.
+func (c *Client) startPumps() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.supervisor = &pumpSupervisor{ctx: ctx, cancel: cancel}
+	c.supervisor.goroutines.Store(3)
+	go c.supervise()
+}
+
+// supervise runs the pumps until one exits. Teardown is always in the same
+// order: the write pump and helpers are cancelled, the connection is closed,
+// which ends the read pump, and only once both pumps have returned is the
+// client unregistered, so nothing writes to a client the hub has dropped.
+func (c *Client) supervise() {
+	supervisor := c.supervisor
+	defer supervisor.goroutines.Add(-1)
+
+	exits := make(chan pumpExit, 2)
+	go c.runPump("read", c.readPump, exits)
+	go c.runPump("write", c.writePump, exits)
+
+	first := <-exits
+	supervisor.cancel()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	second := <-exits
+
+	for _, exit := range []pumpExit{first, second} {
+		if exit.err == nil {
+			continue
+		}
+		reason := "error"
+		if exit.panicked {
+			reason = "panic"
+		}
+		appMetrics.Count("connections.pump_failures", 1, tenantTags(c.tenantID, metricTag("pump", exit.pump), metricTag("reason", reason))...)
+	}
+	c.logger().Debug("Pumps stopped, unregistering client", "first", first.pump)
+	c.hub.Unregister(c)
+}
+
+// runPump runs one pump and reports how it exited. A panic is recovered so
+// that it tears down this connection rather than the server.
+func (c *Client) runPump(name string, pump func(ctx context.Context) error, exits chan<- pumpExit) {
+	exit := pumpExit{pump: name}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			c.logger().Error("Pump panicked", "pump", name, "panic", recovered, "stack", string(debug.Stack()))
+			exit.err = fmt.Errorf("panic: %v", recovered)
+			exit.panicked = true
+		}
+		c.supervisor.goroutines.Add(-1)
+		exits <- exit
+	}()
+	exit.err = pump(c.supervisor.ctx)
+}
+
+// spawn runs fn for the connection within its goroutine budget. fn's context
+// is cancelled when the connection ends. It returns false, without running
+// fn, when the connection is closing or its budget is spent.
+func (c *Client) spawn(name string, fn func(ctx context.Context)) bool {
+	supervisor := c.supervisor
+	if supervisor == nil || supervisor.ctx.Err() != nil {
+		return false
+	}
+	if supervisor.goroutines.Add(1) > connectionGoroutineBudget {
+		supervisor.goroutines.Add(-1)
+		c.logger().Warn("Not starting pump: the connection's goroutine budget is spent", "pump", name, "budget", connectionGoroutineBudget)
+		appMetrics.Count("connections.goroutine_budget_exceeded", 1, tenantTags(c.tenantID, metricTag("task", name))...)
+		return false
+	}
+	go func() {
+		defer supervisor.goroutines.Add(-1)
+		fn(supervisor.ctx)
+	}()
+	return true
+}
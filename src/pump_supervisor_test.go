@@ -0,0 +1,69 @@
+// pump_supervisor_test.go
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// runPumpsNow runs client's pumps under a supervisor on the calling goroutine
+// and returns once the client has been unregistered.
+func runPumpsNow(client *Client) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client.supervisor = &pumpSupervisor{ctx: ctx, cancel: cancel}
+	client.supervisor.goroutines.Store(3)
+	client.supervise()
+}
+
+// panicConn panics on every write.
+type panicConn struct {
+	*mockConn
+}
+
+func (c panicConn) WriteMessage(int, []byte) error {
+	panic("write failed badly")
+}
+
+func TestPumpSupervisor_RecoversPanicsAndTearsDown(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	conn := panicConn{newMockConn()}
+	client := &Client{hub: hub, conn: conn, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 1), control: make(chan outboundMessage, 1)}
+	hub.Register(client)
+	client.send <- outboundMessage{payload: []byte(`{"notificationId":"n1"}`)}
+
+	// The write pump panics; the supervisor closes the connection so the
+	// read pump stops too, and only then unregisters the client.
+	runPumpsNow(client)
+
+	if client.readPumpAlive.Load() || client.writePumpAlive.Load() {
+		t.Fatal("expected both pumps to have stopped")
+	}
+	if len(hub.unregistered) != 1 || !conn.isClosed {
+		t.Fatalf("expected the client to be unregistered and its connection closed, got %v", hub.unregistered)
+	}
+	if got := client.supervisor.goroutines.Load(); got != 0 {
+		t.Fatalf("expected every goroutine to be accounted for, got %d", got)
+	}
+	if client.spawn("late", func(context.Context) {}) {
+		t.Fatal("expected spawn to refuse work once the connection has stopped")
+	}
+}
+
+func TestPumpSupervisor_GoroutineBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &Client{supervisor: &pumpSupervisor{ctx: ctx, cancel: cancel}}
+	client.supervisor.goroutines.Store(3) // supervisor and pumps
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if !client.spawn("handover", func(ctx context.Context) { close(started); <-release }) {
+		t.Fatal("expected the first helper to fit the budget")
+	}
+	<-started
+	if client.spawn("second", func(context.Context) {}) {
+		t.Fatal("expected a helper beyond the budget to be refused")
+	}
+	close(release)
+}
@@ -0,0 +1,133 @@
+// quota.go
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// teamQuotas is nil unless quota.enabled is set, and all methods are nil-safe.
+var teamQuotas *quotaWatcher
+
+// quotaWarningFrame is pushed to a team's admins when the team nears one of
+// its limits.
+type quotaWarningFrame struct {
+	Type        string  `json:"type"`  // always "quotaWarning"
+	Quota       string  `json:"quota"` // "clients"
+	TeamID      string  `json:"teamId"`
+	Used        int     `json:"used"`
+	Limit       int     `json:"limit"`
+	Utilization float64 `json:"utilization"`
+}
+
+type quotaWebhookPayload struct {
+	Event       string  `json:"event"`
+	TenantID    string  `json:"tenantId,omitempty"`
+	TeamID      string  `json:"teamId"`
+	Quota       string  `json:"quota"`
+	Used        int     `json:"used"`
+	Limit       int     `json:"limit"`
+	Utilization float64 `json:"utilization"`
+}
+
+// quotaWatcher warns a team once its usage reaches ratio of a limit, at most
+// once per cooldown, so the limit is not first discovered when a client is
+// turned away.
+type quotaWatcher struct {
+	ratio      float64
+	cooldown   time.Duration
+	webhookURL string
+	webhooks   *webhookDispatcher
+	now        func() time.Time
+
+	mu     sync.Mutex
+	warned map[string]time.Time // "<quota>\n<scoped team>" -> last warning
+}
+
+func newQuotaWatcher(ratio float64, cooldown time.Duration, webhookURL string, webhooks *webhookDispatcher) *quotaWatcher {
+	return &quotaWatcher{
+		ratio:      ratio,
+		cooldown:   cooldown,
+		webhookURL: webhookURL,
+		webhooks:   webhooks,
+		now:        time.Now,
+		warned:     make(map[string]time.Time),
+	}
+}
+
+// observeClients checks a team's client count after a client registered.
+// It returns whether a warning was sent.
+func (q *quotaWatcher) observeClients(hub *Hub, tenantID, teamID string, used int) bool {
+	if q == nil || teamID == statsTeamID {
+		return false
+	}
+	tenant, _ := findTenant(tenantID)
+	return q.observe(hub, tenantID, teamID, "clients", used, teamClientLimit(tenant))
+}
+
+func (q *quotaWatcher) observe(hub *Hub, tenantID, teamID, quota string, used, limit int) bool {
+	if limit <= 0 || used < int(math.Ceil(q.ratio*float64(limit))) {
+		return false
+	}
+
+	key := quota + "\n" + teamID
+	now := q.now()
+	q.mu.Lock()
+	if last, ok := q.warned[key]; ok && now.Sub(last) < q.cooldown {
+		q.mu.Unlock()
+		return false
+	}
+	q.warned[key] = now
+	q.mu.Unlock()
+
+	utilization := float64(used) / float64(limit)
+	slog.Warn("Team is close to its quota", "team", teamID, "quota", quota, "used", used, "limit", limit, "utilization", utilization)
+	appMetrics.Count("quota.warnings", 1, tenantTags(tenantID, metricTag("quota", quota))...)
+	// Delivery may write to the webhook outbox, so it stays off the caller's
+	// path (the hub loop for client quotas).
+	go q.warn(hub, tenantID, teamID, quota, used, limit, utilization)
+	return true
+}
+
+func (q *quotaWatcher) warn(hub *Hub, tenantID, teamID, quota string, used, limit int, utilization float64) {
+	frame, err := json.Marshal(quotaWarningFrame{
+		Type:        "quotaWarning",
+		Quota:       quota,
+		TeamID:      unscopedTeamID(tenantID, teamID),
+		Used:        used,
+		Limit:       limit,
+		Utilization: utilization,
+	})
+	if err != nil {
+		slog.Error("Failed to encode the quota warning", "error", err)
+		return
+	}
+	for _, client := range hub.snapshotTeamClients(teamID) {
+		if client.teamAdmin {
+			hub.enqueueControl(client, outboundMessage{payload: frame})
+		}
+	}
+
+	if q.webhookURL == "" {
+		return
+	}
+	payload, err := json.Marshal(quotaWebhookPayload{
+		Event:       "quota.warning",
+		TenantID:    tenantID,
+		TeamID:      unscopedTeamID(tenantID, teamID),
+		Quota:       quota,
+		Used:        used,
+		Limit:       limit,
+		Utilization: utilization,
+	})
+	if err != nil {
+		slog.Error("Failed to encode the quota webhook", "error", err)
+		return
+	}
+	if !q.webhooks.enqueue(webhookJob{Kind: "quota_warning", URL: q.webhookURL, Payload: payload}) {
+		slog.Error("Quota webhook dropped", "team", teamID)
+	}
+}
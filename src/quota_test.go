@@ -0,0 +1,119 @@
+// quota_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQuotaWatcher_WarnsAdminsOncePerCooldown(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Limits.MaxClientsPerTeam = 10
+
+	received := make(chan quotaWebhookPayload, 2)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload quotaWebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer backend.Close()
+
+	webhooks := newWebhookDispatcher(backend.Client(), 4, 1, time.Second, time.Second)
+	startTestDispatcher(t, webhooks, 1)
+
+	hub := newHub()
+	admin := &Client{hub: hub, teamID: "team1", userID: "admin", teamAdmin: true, control: make(chan outboundMessage, 2)}
+	member := &Client{hub: hub, teamID: "team1", userID: "member", control: make(chan outboundMessage, 2)}
+	hub.clients["team1"] = map[string]map[*Client]struct{}{
+		"admin":  {admin: {}},
+		"member": {member: {}},
+	}
+
+	now := time.Now()
+	quotas := newQuotaWatcher(0.9, time.Hour, backend.URL, webhooks)
+	quotas.now = func() time.Time { return now }
+
+	if quotas.observeClients(hub, "", "team1", 8) {
+		t.Fatal("expected no warning below the threshold")
+	}
+	if !quotas.observeClients(hub, "", "team1", 9) {
+		t.Fatal("expected a warning at 90% of the limit")
+	}
+	if quotas.observeClients(hub, "", "team1", 10) {
+		t.Fatal("expected no second warning within the cooldown")
+	}
+	if quotas.observeClients(hub, "", statsTeamID, 10) {
+		t.Fatal("expected the stats team to be ignored")
+	}
+
+	select {
+	case msg := <-admin.control:
+		var frame quotaWarningFrame
+		if err := json.Unmarshal(msg.payload, &frame); err != nil {
+			t.Fatalf("failed to decode warning: %v", err)
+		}
+		if frame.Type != "quotaWarning" || frame.Quota != "clients" || frame.TeamID != "team1" || frame.Used != 9 || frame.Limit != 10 {
+			t.Fatalf("unexpected warning frame: %+v", frame)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the team admin to be warned")
+	}
+
+	select {
+	case payload := <-received:
+		if payload.Event != "quota.warning" || payload.TeamID != "team1" || payload.Used != 9 || payload.Limit != 10 {
+			t.Fatalf("unexpected webhook payload: %+v", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the quota webhook to be posted")
+	}
+
+	select {
+	case msg := <-member.control:
+		t.Fatalf("expected members not to be warned, got %s", msg.payload)
+	default:
+	}
+
+	now = now.Add(time.Hour)
+	if !quotas.observeClients(hub, "", "team1", 9) {
+		t.Fatal("expected a new warning once the cooldown passed")
+	}
+}
+
+func TestQuotaWatcher_UsesTenantLimitAndUnscopedTeam(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Tenants = []TenantConfig{{ID: "acme", APIKey: "acme-key", MaxClientsPerTeam: 4}}
+
+	hub := newHub()
+	admin := &Client{hub: hub, tenantID: "acme", teamID: "acme/team1", userID: "admin", teamAdmin: true, control: make(chan outboundMessage, 2)}
+	hub.clients["acme/team1"] = map[string]map[*Client]struct{}{"admin": {admin: {}}}
+
+	quotas := newQuotaWatcher(0.75, time.Hour, "", nil)
+	if quotas.observeClients(hub, "acme", "acme/team1", 2) {
+		t.Fatal("expected no warning below the tenant's threshold")
+	}
+	if !quotas.observeClients(hub, "acme", "acme/team1", 3) {
+		t.Fatal("expected a warning at 75% of the tenant's limit")
+	}
+
+	select {
+	case msg := <-admin.control:
+		var frame quotaWarningFrame
+		json.Unmarshal(msg.payload, &frame)
+		if frame.TeamID != "team1" || frame.Limit != 4 {
+			t.Fatalf("unexpected warning frame: %+v", frame)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the tenant's team admin to be warned")
+	}
+}
+
+func TestQuotaWatcher_NilIsDisabled(t *testing.T) {
+	var quotas *quotaWatcher
+	if quotas.observeClients(newHub(), "", "team1", 100) {
+		t.Fatal("nil watcher must not warn")
+	}
+}
@@ -9,6 +9,35 @@ import (
 	"time"
 )
 
+// RateLimiter decides whether the caller identified by key may make a request
+// that costs cost tokens. ipRateLimiter keeps its state in memory;
+// redisRateLimiter shares it between instances. Plugins can wrap either; see
+// registerRateLimitPlugin.
+type RateLimiter interface {
+	Allow(key string, cost int) bool
+}
+
+// waitingRateLimiter is implemented by the built-in limiters. AllowWait is
+// Allow that also estimates, for a rejected request, how long the caller
+// should wait before the same request would be admitted.
+type waitingRateLimiter interface {
+	RateLimiter
+	AllowWait(key string, cost int) (bool, time.Duration)
+}
+
+// allowRequest checks a request against limiter. Limiters that cannot
+// estimate the wait, such as most plugins, ask rejected callers to wait a
+// second.
+func allowRequest(limiter RateLimiter, key string, cost int) (bool, time.Duration) {
+	if waiting, ok := limiter.(waitingRateLimiter); ok {
+		return waiting.AllowWait(key, cost)
+	}
+	if limiter.Allow(key, cost) {
+		return true, 0
+	}
+	return false, time.Second
+}
+
 type tokenBucket struct {
 	rate   float64
 	burst  float64
@@ -26,7 +55,7 @@ func newTokenBucket(rate float64, burst int) *tokenBucket {
 	}
 }
 
-func (b *tokenBucket) Allow(now time.Time) bool {
+func (b *tokenBucket) Allow(now time.Time, cost int) bool {
 	if b == nil {
 		return true
 	}
@@ -39,14 +68,23 @@ func (b *tokenBucket) Allow(now time.Time) bool {
 	b.tokens = math.Min(b.burst, b.tokens+(elapsed*b.rate))
 	b.last = now
 
-	if b.tokens < 1 {
+	if b.tokens < float64(cost) {
 		return false
 	}
 
-	b.tokens--
+	b.tokens -= float64(cost)
 	return true
 }
 
+// wait returns how long until the bucket holds cost tokens, as of its last
+// Allow.
+func (b *tokenBucket) wait(cost int) time.Duration {
+	if b == nil || b.tokens >= float64(cost) {
+		return 0
+	}
+	return time.Duration((float64(cost) - b.tokens) / b.rate * float64(time.Second))
+}
+
 type ipBucket struct {
 	limiter  *tokenBucket
 	lastSeen time.Time
@@ -76,9 +114,14 @@ func newIPRateLimiter(rate float64, burst int, entryTTL, cleanupInterval time.Du
 	}
 }
 
-func (l *ipRateLimiter) Allow(key string) bool {
+func (l *ipRateLimiter) Allow(key string, cost int) bool {
+	allowed, _ := l.AllowWait(key, cost)
+	return allowed
+}
+
+func (l *ipRateLimiter) AllowWait(key string, cost int) (bool, time.Duration) {
 	if l == nil {
-		return true
+		return true, 0
 	}
 
 	now := l.now()
@@ -102,7 +145,10 @@ func (l *ipRateLimiter) Allow(key string) bool {
 	}
 
 	entry.lastSeen = now
-	return entry.limiter.Allow(now)
+	if entry.limiter.Allow(now, cost) {
+		return true, 0
+	}
+	return false, entry.limiter.wait(cost)
 }
 
 func (l *ipRateLimiter) cleanupLocked(now time.Time) {
@@ -0,0 +1,183 @@
+// rate_limit_policy.go
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// RateLimitPolicy limits the requests to a set of routes separately from the
+// default rate_limit settings.
+type RateLimitPolicy struct {
+	Name              string   `yaml:"name"`
+	Paths             []string `yaml:"paths"`               // Exact paths, or prefixes ending in "/"
+	RequestsPerSecond float64  `yaml:"requests_per_second"` // 0 uses rate_limit.requests_per_second
+	Burst             int      `yaml:"burst"`               // 0 uses rate_limit.burst
+	Cost              int      `yaml:"cost"`                // Tokens each request takes; 0 is 1
+	Key               string   `yaml:"key"`                 // "ip", "tenant" or "api_key"; empty uses rate_limit.key
+	Plugin            string   `yaml:"plugin"`              // Registered plugin that wraps this policy's limiter
+}
+
+// defaultRateLimitPolicy names the rate_limit settings themselves, which
+// apply to routes no policy matches.
+const defaultRateLimitPolicy = "default"
+
+var validRateLimitPolicyName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// RateLimitPlugin returns the limiter to use for policy in place of next,
+// the built-in limiter. It is called once per policy at startup. The
+// returned limiter usually consults next, for example after granting a
+// premium tenant extra burst credits; keys of tenant-keyed policies are
+// "tenant:<id>", and those of api_key-keyed policies "apikey:operator" or
+// "apikey:tenant:<id>".
+type RateLimitPlugin func(policy RateLimitPolicy, next RateLimiter) RateLimiter
+
+var (
+	rateLimitPluginsMu sync.RWMutex
+	rateLimitPlugins   = make(map[string]RateLimitPlugin)
+)
+
+// registerRateLimitPlugin makes plugin available to rate_limit.policies under
+// name. Plugins are compiled in: add a file to this package that registers
+// its plugin from init().
+func registerRateLimitPlugin(name string, plugin RateLimitPlugin) {
+	rateLimitPluginsMu.Lock()
+	defer rateLimitPluginsMu.Unlock()
+	rateLimitPlugins[name] = plugin
+}
+
+func lookupRateLimitPlugin(name string) (RateLimitPlugin, bool) {
+	rateLimitPluginsMu.RLock()
+	defer rateLimitPluginsMu.RUnlock()
+	plugin, ok := rateLimitPlugins[name]
+	return plugin, ok
+}
+
+func validateRateLimitPolicies(config *Config) error {
+	seen := map[string]bool{defaultRateLimitPolicy: true}
+	for i := range config.RateLimit.Policies {
+		policy := &config.RateLimit.Policies[i]
+		policy.Name = strings.TrimSpace(policy.Name)
+		if !validRateLimitPolicyName.MatchString(policy.Name) {
+			return fmt.Errorf("rate_limit.policies[%d].name must be 1-64 letters, digits, '-' or '_'", i)
+		}
+		if seen[policy.Name] {
+			return fmt.Errorf("rate_limit.policies has duplicate or reserved name %q", policy.Name)
+		}
+		seen[policy.Name] = true
+		if len(policy.Paths) == 0 {
+			return fmt.Errorf("rate_limit.policies[%d].paths must not be empty", i)
+		}
+		for _, path := range policy.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("rate_limit.policies[%d].paths must start with /", i)
+			}
+		}
+		if policy.RequestsPerSecond < 0 || policy.Burst < 0 || policy.Cost < 0 {
+			return fmt.Errorf("rate_limit.policies[%d] rates, bursts and costs must not be negative", i)
+		}
+		if policy.RequestsPerSecond == 0 {
+			policy.RequestsPerSecond = config.RateLimit.RequestsPerSecond
+		}
+		if policy.Burst == 0 {
+			policy.Burst = config.RateLimit.Burst
+		}
+		if policy.Cost == 0 {
+			policy.Cost = 1
+		}
+		if policy.Cost > policy.Burst {
+			return fmt.Errorf("rate_limit.policies[%d].cost must not exceed its burst", i)
+		}
+		switch policy.Key {
+		case "":
+			policy.Key = config.RateLimit.Key
+		case "ip", "tenant", "api_key":
+		default:
+			return fmt.Errorf("rate_limit.policies[%d].key must be ip, tenant or api_key", i)
+		}
+		if policy.Plugin != "" {
+			if _, ok := lookupRateLimitPlugin(policy.Plugin); !ok {
+				return fmt.Errorf("rate_limit.policies[%d].plugin %q is not registered in this build", i, policy.Plugin)
+			}
+		}
+	}
+	return nil
+}
+
+// routeRateLimit is a configured policy with its limiter.
+type routeRateLimit struct {
+	policy  RateLimitPolicy
+	limiter RateLimiter
+}
+
+// rateLimits are the limiters rateLimitMiddleware applies: the policies in
+// configuration order, and the default limiter for requests they do not
+// match. A reload publishes a new set rather than changing this one.
+type rateLimits struct {
+	fallback RateLimiter
+	routes   []routeRateLimit
+}
+
+// activeRateLimits is empty until main configures rate limiting.
+var activeRateLimits atomic.Pointer[rateLimits]
+
+// setRateLimits publishes the limiters requests are counted against from now on.
+func setRateLimits(fallback RateLimiter, routes []routeRateLimit) {
+	activeRateLimits.Store(&rateLimits{fallback: fallback, routes: routes})
+}
+
+// newRouteRateLimits builds a limiter for each policy with newLimiter and
+// wraps it in the policy's plugin, if any.
+func newRouteRateLimits(policies []RateLimitPolicy, newLimiter func(policy RateLimitPolicy) RateLimiter) []routeRateLimit {
+	routes := make([]routeRateLimit, 0, len(policies))
+	for _, policy := range policies {
+		limiter := newLimiter(policy)
+		if plugin, ok := lookupRateLimitPlugin(policy.Plugin); ok {
+			limiter = plugin(policy, limiter)
+		}
+		routes = append(routes, routeRateLimit{policy: policy, limiter: limiter})
+	}
+	return routes
+}
+
+// match returns the first policy covering path, or nil.
+func (l *rateLimits) match(path string) *routeRateLimit {
+	for i := range l.routes {
+		for _, pattern := range l.routes[i].policy.Paths {
+			if path == pattern || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern)) {
+				return &l.routes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// key returns who a request matching route is counted against.
+func (route *routeRateLimit) key(clientIP, apiKey string) string {
+	return rateLimitKey(route.policy.Key, clientIP, apiKey)
+}
+
+// rateLimitKey returns who a request is counted against under mode: its
+// client IP, or the tenant or API key it authenticates with. Requests without
+// a configured API key are counted by IP, so that made-up keys do not earn a
+// fresh allowance each.
+func rateLimitKey(mode, clientIP, apiKey string) string {
+	switch mode {
+	case "tenant":
+		if tenant, ok := tenantForAPIKey(apiKey); ok {
+			return "tenant:" + tenant.ID
+		}
+	case "api_key":
+		if apiKey != "" && subtle.ConstantTimeCompare([]byte(apiKey), []byte(AppConfig().Security.APIKey)) == 1 {
+			return "apikey:operator"
+		}
+		if tenant, ok := tenantForAPIKey(apiKey); ok {
+			return "apikey:tenant:" + tenant.ID
+		}
+	}
+	return clientIP
+}
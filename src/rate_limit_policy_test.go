@@ -0,0 +1,113 @@
+// rate_limit_policy_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRateLimitPolicies(t *testing.T) {
+	registerRateLimitPlugin("test-noop", func(_ RateLimitPolicy, next RateLimiter) RateLimiter { return next })
+
+	testCases := []struct {
+		name   string
+		policy RateLimitPolicy
+		want   string
+	}{
+		{"reserved name", RateLimitPolicy{Name: "default", Paths: []string{"/send"}}, "duplicate or reserved"},
+		{"no paths", RateLimitPolicy{Name: "send"}, "paths must not be empty"},
+		{"relative path", RateLimitPolicy{Name: "send", Paths: []string{"send"}}, "must start with /"},
+		{"cost over burst", RateLimitPolicy{Name: "send", Paths: []string{"/send"}, Burst: 2, Cost: 3}, "must not exceed its burst"},
+		{"unknown key", RateLimitPolicy{Name: "send", Paths: []string{"/send"}, Key: "user"}, "must be ip, tenant or api_key"},
+		{"unknown plugin", RateLimitPolicy{Name: "send", Paths: []string{"/send"}, Plugin: "missing"}, "not registered"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &Config{}
+			setDefaults(config)
+			config.RateLimit.Policies = []RateLimitPolicy{tc.policy}
+			if err := validateRateLimitPolicies(config); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+
+	config := &Config{}
+	setDefaults(config)
+	config.RateLimit.Policies = []RateLimitPolicy{{Name: "send", Paths: []string{"/send"}, Plugin: "test-noop"}}
+	if err := validateRateLimitPolicies(config); err != nil {
+		t.Fatalf("expected a valid policy, got %v", err)
+	}
+	if policy := config.RateLimit.Policies[0]; policy.RequestsPerSecond != 20 || policy.Burst != 60 || policy.Cost != 1 || policy.Key != "ip" {
+		t.Fatalf("expected defaults to be filled in, got %+v", policy)
+	}
+}
+
+// creditLimiter admits a fixed number of requests per key before deferring to
+// the built-in limiter, like burst credits for premium tenants.
+type creditLimiter struct {
+	credits map[string]int
+	next    RateLimiter
+}
+
+func (l *creditLimiter) Allow(key string, cost int) bool {
+	if l.credits[key] >= cost {
+		l.credits[key] -= cost
+		return true
+	}
+	return l.next.Allow(key, cost)
+}
+
+func TestRateLimitMiddleware_RoutePolicies(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Tenants = []TenantConfig{{ID: "acme", APIKey: "acme-key"}, {ID: "globex", APIKey: "globex-key"}}
+	registerRateLimitPlugin("test-credits", func(policy RateLimitPolicy, next RateLimiter) RateLimiter {
+		return &creditLimiter{credits: map[string]int{"tenant:acme": 2 * policy.Cost}, next: next}
+	})
+	routes := newRouteRateLimits([]RateLimitPolicy{
+		{Name: "send", Paths: []string{"/send"}, RequestsPerSecond: 1, Burst: 4, Cost: 2, Key: "tenant", Plugin: "test-credits"},
+		{Name: "admin", Paths: []string{"/admin/"}, RequestsPerSecond: 1, Burst: 2, Cost: 1, Key: "ip"},
+	}, func(policy RateLimitPolicy) RateLimiter {
+		return newIPRateLimiter(policy.RequestsPerSecond, policy.Burst, time.Minute, time.Minute)
+	})
+	setRateLimits(newIPRateLimiter(1, 1, time.Minute, time.Minute), routes)
+	defer setRateLimits(nil, nil)
+
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(path, apiKey string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.10:1234"
+		req.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Each /send costs 2 of a burst of 4, counted per tenant.
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := request("/send", "globex-key"); got != want {
+			t.Fatalf("globex send %d returned %d, want %d", i+1, got, want)
+		}
+	}
+	// acme spends two plugin credits before its own bucket.
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := request("/send", "acme-key"); got != want {
+			t.Fatalf("acme send %d returned %d, want %d", i+1, got, want)
+		}
+	}
+
+	// The prefix policy covers every admin route with one bucket.
+	if request("/admin/stats", "") != http.StatusOK || request("/admin/audit", "") != http.StatusOK || request("/admin/stats", "") != http.StatusTooManyRequests {
+		t.Fatal("expected the admin policy to admit a burst of 2 across admin routes")
+	}
+
+	// Other routes use the default limit.
+	if request("/users/team/user/conversations", "") != http.StatusOK || request("/notifications/n1", "") != http.StatusTooManyRequests {
+		t.Fatal("expected unmatched routes to share the default limit")
+	}
+}
@@ -0,0 +1,152 @@
+// rate_limit_redis.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// redisRateLimiter applies the rate limit across every instance sharing a
+// Redis server. It approximates a sliding window with fixed-window counters
+// (<prefix>:ratelimit:<scope>:<key>:<window>): the previous window's count is
+// weighted by how much of it the sliding window still covers. A window lasts
+// burst/rate and admits burst tokens, the same long-run rate and burst as the
+// in-memory token bucket. scope is the policy name, so each policy counts
+// separately.
+//
+// When Redis fails, requests are checked against the fallback limiter of this
+// instance instead, so a Redis outage does not take the REST API down with it.
+type redisRateLimiter struct {
+	client   *redisClient
+	prefix   string
+	scope    string
+	limit    int64
+	window   time.Duration
+	fallback RateLimiter
+	now      func() time.Time
+
+	failing atomic.Bool // logs the switch to and from the fallback once
+}
+
+func newRedisRateLimiter(client *redisClient, prefix, scope string, rate float64, burst int, fallback RateLimiter) *redisRateLimiter {
+	window := time.Duration(float64(burst) / rate * float64(time.Second))
+	if window < time.Millisecond {
+		window = time.Millisecond
+	}
+	return &redisRateLimiter{
+		client:   client,
+		prefix:   strings.TrimSuffix(prefix, ":"),
+		scope:    scope,
+		limit:    int64(burst),
+		window:   window,
+		fallback: fallback,
+		now:      time.Now,
+	}
+}
+
+func (l *redisRateLimiter) Allow(key string, cost int) bool {
+	allowed, _ := l.AllowWait(key, cost)
+	return allowed
+}
+
+func (l *redisRateLimiter) AllowWait(key string, cost int) (bool, time.Duration) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		key = "unknown"
+	}
+
+	allowed, wait, err := l.allow(key, cost, l.now())
+	if err != nil {
+		appMetrics.Count("rate_limit.redis_errors", 1)
+		if !l.failing.Swap(true) {
+			slog.Warn("Redis rate limiter unavailable, limiting per instance", "error", err)
+		}
+		if l.fallback == nil {
+			return true, 0
+		}
+		return allowRequest(l.fallback, key, cost)
+	}
+	if l.failing.Swap(false) {
+		slog.Info("Redis rate limiter recovered")
+	}
+	return allowed, wait
+}
+
+func (l *redisRateLimiter) windowKey(key string, window int64) string {
+	return l.prefix + ":ratelimit:" + l.scope + ":" + key + ":" + strconv.FormatInt(window, 10)
+}
+
+// allow counts the request's cost in the current window and takes it back
+// again if the sliding-window estimate is over the limit, so rejected requests
+// do not use up the allowance. For a rejected request it also returns the
+// wait estimated by slidingWindowWait.
+func (l *redisRateLimiter) allow(key string, cost int, now time.Time) (bool, time.Duration, error) {
+	window := now.UnixNano() / int64(l.window)
+	current := l.windowKey(key, window)
+
+	count, err := redisInt(l.client.Do("INCRBY", current, strconv.Itoa(cost)))
+	if err != nil {
+		return false, 0, err
+	}
+	if count == int64(cost) {
+		// The counter is still read as the previous window during the next one.
+		if _, err := l.client.Do("PEXPIRE", current, strconv.FormatInt(2*l.window.Milliseconds()+1, 10)); err != nil {
+			return false, 0, err
+		}
+	}
+
+	reply, err := l.client.Do("GET", l.windowKey(key, window-1))
+	if err != nil {
+		return false, 0, err
+	}
+	var previous int64
+	if reply != nil {
+		if previous, err = strconv.ParseInt(fmt.Sprint(reply), 10, 64); err != nil {
+			return false, 0, err
+		}
+	}
+
+	elapsed := float64(now.UnixNano()%int64(l.window)) / float64(l.window)
+	if float64(previous)*(1-elapsed)+float64(count) > float64(l.limit) {
+		if _, err := l.client.Do("DECRBY", current, strconv.Itoa(cost)); err != nil {
+			return false, 0, err
+		}
+		return false, slidingWindowWait(l.limit, count-int64(cost), previous, int64(cost), elapsed, l.window), nil
+	}
+	return true, 0, nil
+}
+
+// slidingWindowWait estimates how long until a request costing cost fits
+// under limit, given the current and previous windows' counts and the
+// fraction of the current window that has elapsed. It assumes no other
+// requests are admitted meanwhile.
+func slidingWindowWait(limit, count, previous, cost int64, elapsed float64, window time.Duration) time.Duration {
+	var until float64 // in windows from the start of the current one
+	if count+cost <= limit {
+		// The previous window's weight falls until the request fits.
+		until = 1 - float64(limit-count-cost)/float64(previous)
+	} else {
+		// It only fits once this window's count is weighted down in the next.
+		until = 2 - float64(limit-cost)/float64(count)
+	}
+	if until <= elapsed {
+		return 0
+	}
+	return time.Duration((until - elapsed) * float64(window))
+}
+
+// redisInt converts an integer reply.
+func redisInt(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	value, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("expected integer reply, got %T", reply)
+	}
+	return value, nil
+}
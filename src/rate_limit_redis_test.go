@@ -0,0 +1,63 @@
+// rate_limit_redis_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRedisRateLimiter_SharedBetweenInstances(t *testing.T) {
+	redis := newFakeRedis(t)
+	// 1 request per second with a burst of 2: a 2s window admitting 2.
+	now := time.Unix(1000, 0)
+	newInstance := func() *redisRateLimiter {
+		limiter := newRedisRateLimiter(newRedisClient(redis.address(), "", 0, time.Second), "test:", "default", 1, 2, nil)
+		limiter.now = func() time.Time { return now }
+		return limiter
+	}
+	first, second := newInstance(), newInstance()
+
+	if !first.Allow("203.0.113.10", 1) || !second.Allow("203.0.113.10", 1) {
+		t.Fatal("expected the burst to be shared and admitted")
+	}
+	if first.Allow("203.0.113.10", 1) || second.Allow("203.0.113.10", 1) {
+		t.Fatal("expected both instances to reject once the shared burst is spent")
+	}
+	// The next request fits halfway through the next window.
+	if _, wait := first.AllowWait("203.0.113.10", 1); wait != 3*time.Second {
+		t.Fatalf("expected a 3s wait, got %v", wait)
+	}
+	if !second.Allow("203.0.113.11", 1) {
+		t.Fatal("expected another key to have its own limit")
+	}
+	redis.mu.Lock()
+	count := redis.values["test:ratelimit:default:203.0.113.10:500"]
+	redis.mu.Unlock()
+	if count != "2" {
+		t.Fatalf("expected rejected requests to be taken back, got count %q", count)
+	}
+
+	// Halfway through the next window half of the previous one still counts.
+	now = now.Add(3 * time.Second)
+	if !first.Allow("203.0.113.10", 1) {
+		t.Fatal("expected the window to admit requests again")
+	}
+	if second.Allow("203.0.113.10", 1) {
+		t.Fatal("expected the previous window to still weigh on the estimate")
+	}
+	if _, wait := second.AllowWait("203.0.113.10", 1); wait != time.Second {
+		t.Fatalf("expected to wait for the previous window to end, got %v", wait)
+	}
+}
+
+func TestRedisRateLimiter_FallsBackWhenRedisFails(t *testing.T) {
+	fallback := newIPRateLimiter(1, 1, time.Minute, time.Minute)
+	limiter := newRedisRateLimiter(newRedisClient("127.0.0.1:1", "", 0, 100*time.Millisecond), "test", "default", 1, 5, fallback)
+
+	if !limiter.Allow("203.0.113.10", 1) {
+		t.Fatal("expected the fallback limiter to admit the first request")
+	}
+	if limiter.Allow("203.0.113.10", 1) {
+		t.Fatal("expected the fallback limiter's burst of 1 to apply")
+	}
+}
@@ -0,0 +1,56 @@
+// reaper.go
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// runReaper periodically closes clients that stopped answering pings or whose
+// send buffers stay saturated, instead of waiting for TCP to notice.
+func (h *Hub) runReaper(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.reapStaleClients(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reapStaleClients disconnects clients with no pong within
+// StalePongMultiplier*PongWait or with a send buffer at or above
+// FullBufferRatio for FullBufferSweeps consecutive sweeps. It returns the
+// number of clients reaped.
+func (h *Hub) reapStaleClients(now time.Time) int {
+	maxPongAge := time.Duration(float64(AppConfig().WebSocket.PongWait) * AppConfig().WebSocket.StalePongMultiplier)
+	reaped := 0
+
+	for _, client := range h.snapshotAllClients() {
+		if lastPong := client.lastPong.Load(); lastPong != 0 {
+			if age := now.Sub(time.Unix(0, lastPong)); age > maxPongAge {
+				appMetrics.Count("clients.reaped", 1, metricTag("reason", "pong_timeout"))
+				h.disconnectClient(client, fmt.Sprintf("no pong for %s", age.Round(time.Second)))
+				reaped++
+				continue
+			}
+		}
+
+		if capacity := cap(client.send); capacity > 0 &&
+			float64(len(client.send)) >= float64(capacity)*AppConfig().WebSocket.FullBufferRatio {
+			if client.fullBufferSweeps.Add(1) >= int32(AppConfig().WebSocket.FullBufferSweeps) {
+				appMetrics.Count("clients.reaped", 1, metricTag("reason", "buffer_full"))
+				h.disconnectClient(client, "send buffer persistently full")
+				reaped++
+			}
+			continue
+		}
+		client.fullBufferSweeps.Store(0)
+	}
+
+	return reaped
+}
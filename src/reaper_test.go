@@ -0,0 +1,52 @@
+// reaper_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHub_ReapStaleClients(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().WebSocket.PongWait = time.Second
+	AppConfig().WebSocket.StalePongMultiplier = 2
+	AppConfig().WebSocket.FullBufferSweeps = 2
+	hub := newHub()
+	go hub.run()
+
+	now := time.Now()
+	stale := &Client{hub: hub, conn: newMockConn(), teamID: "team-a", userID: "stale", send: make(chan outboundMessage, 4)}
+	stale.lastPong.Store(now.Add(-3 * time.Second).UnixNano())
+
+	healthy := &Client{hub: hub, conn: newMockConn(), teamID: "team-a", userID: "healthy", send: make(chan outboundMessage, 4)}
+	healthy.lastPong.Store(now.UnixNano())
+
+	saturated := &Client{hub: hub, conn: newMockConn(), teamID: "team-a", userID: "saturated", send: make(chan outboundMessage, 1)}
+	saturated.lastPong.Store(now.UnixNano())
+	saturated.send <- outboundMessage{payload: []byte("backlog")}
+
+	registerAndWait(t, hub, stale, healthy, saturated)
+
+	if reaped := hub.reapStaleClients(now); reaped != 1 {
+		t.Fatalf("expected only the stale client to be reaped on the first sweep, got %d", reaped)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if reaped := hub.reapStaleClients(now); reaped != 1 {
+		t.Fatalf("expected the saturated client to be reaped on the second sweep, got %d", reaped)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	if _, ok := hub.clients["team-a"]["healthy"]; !ok {
+		t.Fatal("healthy client should not have been reaped")
+	}
+	if _, ok := hub.clients["team-a"]["stale"]; ok {
+		t.Fatal("stale client should have been reaped")
+	}
+	if _, ok := hub.clients["team-a"]["saturated"]; ok {
+		t.Fatal("saturated client should have been reaped")
+	}
+}
@@ -0,0 +1,408 @@
+// recall.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRecallReasonLength bounds the reason passed on to clients.
+const maxRecallReasonLength = 256
+
+var (
+	errRecallNotFound = errors.New("notification not found or no longer recallable")
+	errAlreadyRevoked = errors.New("notification was already recalled")
+	errSuperseded     = errors.New("notification was replaced by a later one")
+)
+
+// sentNotification is what the recall ledger remembers about one /send: its
+// audience, so a recall can reach the same clients.
+type sentNotification struct {
+	tenantID   string
+	teamID     string // hub key; empty for deliveries across all teams
+	userID     string // empty for broadcasts
+	broadcast  bool
+	channel    string // set for a channel publish, whose audience is the channel's subscribers
+	visibility visibilityRules
+	sentAt     time.Time
+	expiresAt  time.Time // sentAt plus the team's retention history
+	revokedAt  time.Time // set when recalled or replaced
+	replacedBy string
+}
+
+type recallKey struct {
+	tenantID       string
+	notificationID string
+}
+
+// recallLedger tracks notifications sent with a notification_id for
+// recall.window, or the team's retention history, and which of them have been
+// recalled. Copies still waiting
+// in send queues are dropped when the writePump reaches them.
+type recallLedger struct {
+	window     time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu    sync.RWMutex
+	sent  map[recallKey]*sentNotification
+	order []recallOrder // oldest first
+}
+
+// recallOrder is one send in the ledger's order. An entry whose sentAt no
+// longer matches is stale: the ID was sent again later.
+type recallOrder struct {
+	key    recallKey
+	sentAt time.Time
+}
+
+// notificationRecalls is nil until main configures it, and all methods are
+// nil-safe.
+var notificationRecalls *recallLedger
+
+func newRecallLedger(window time.Duration, maxEntries int) *recallLedger {
+	return &recallLedger{
+		window:     window,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		sent:       make(map[recallKey]*sentNotification),
+	}
+}
+
+// recordSent remembers a delivery to userID, or a broadcast, so it can be
+// recalled. Sending an ID again replaces the earlier entry, including a
+// recall of it.
+func (l *recallLedger) recordSent(message outboundMessage, userID string, broadcast bool) {
+	if l == nil || message.notificationID == "" {
+		return
+	}
+	key := recallKey{tenantID: message.tenantID, notificationID: message.notificationID}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.sent, key)
+	l.pruneLocked(now, l.maxEntries-1)
+	l.order = append(l.order, recallOrder{key: key, sentAt: now})
+	l.sent[key] = &sentNotification{
+		tenantID:   message.tenantID,
+		teamID:     message.teamID,
+		userID:     userID,
+		broadcast:  broadcast,
+		channel:    message.channel,
+		visibility: message.visibility,
+		sentAt:     now,
+		expiresAt:  now.Add(teamRetention.history(message.teamID, l.window)),
+	}
+}
+
+// pruneLocked forgets expired entries at the front of the order, and the
+// oldest entries beyond limit. An expired entry behind one with a longer
+// history stays until it reaches the front, and lookups treat it as gone.
+func (l *recallLedger) pruneLocked(now time.Time, limit int) {
+	for len(l.order) > 0 {
+		oldest := l.order[0]
+		if entry, ok := l.sent[oldest.key]; ok && entry.sentAt.Equal(oldest.sentAt) {
+			if len(l.sent) <= limit && now.Before(entry.expiresAt) {
+				return
+			}
+			delete(l.sent, oldest.key)
+		}
+		l.order = l.order[1:]
+	}
+}
+
+// revoke marks a notification as recalled and returns its audience.
+func (l *recallLedger) revoke(tenantID, notificationID string) (sentNotification, error) {
+	if l == nil {
+		return sentNotification{}, errRecallNotFound
+	}
+	key := recallKey{tenantID: tenantID, notificationID: notificationID}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(now, l.maxEntries)
+	entry, ok := l.sent[key]
+	if !ok || !now.Before(entry.expiresAt) {
+		return sentNotification{}, errRecallNotFound
+	}
+	if entry.replacedBy != "" {
+		return *entry, errSuperseded
+	}
+	if !entry.revokedAt.IsZero() {
+		return *entry, errAlreadyRevoked
+	}
+	entry.revokedAt = now
+	return *entry, nil
+}
+
+// supersede marks a notification as replaced by a later one, so its queued
+// copies are dropped like a recalled notification's. It reports false, and
+// changes nothing, if the notification is unknown, expired or already
+// recalled or replaced.
+func (l *recallLedger) supersede(tenantID, notificationID, replacedBy string) (sentNotification, bool) {
+	if l == nil {
+		return sentNotification{}, false
+	}
+	key := recallKey{tenantID: tenantID, notificationID: notificationID}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(now, l.maxEntries)
+	entry, ok := l.sent[key]
+	if !ok || !now.Before(entry.expiresAt) || !entry.revokedAt.IsZero() {
+		return sentNotification{}, false
+	}
+	entry.revokedAt = now
+	entry.replacedBy = replacedBy
+	return *entry, true
+}
+
+// snapshot returns the ledger's entries in the order they were sent.
+func (l *recallLedger) snapshot() []recallSnapshot {
+	if l == nil {
+		return nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := make([]recallSnapshot, 0, len(l.sent))
+	for _, order := range l.order {
+		entry, ok := l.sent[order.key]
+		if !ok || !entry.sentAt.Equal(order.sentAt) {
+			continue
+		}
+		entries = append(entries, recallSnapshot{
+			TenantID:       entry.tenantID,
+			NotificationID: order.key.notificationID,
+			TeamID:         entry.teamID,
+			UserID:         entry.userID,
+			Broadcast:      entry.broadcast,
+			Channel:        entry.channel,
+			Visibility:     entry.visibility.spec(),
+			SentAt:         entry.sentAt,
+			RevokedAt:      entry.revokedAt,
+			ReplacedBy:     entry.replacedBy,
+		})
+	}
+	return entries
+}
+
+// restore adds entries from a snapshot, oldest first. Entries past the
+// window are dropped as usual.
+func (l *recallLedger) restore(entries []recallSnapshot) {
+	if l == nil {
+		return
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, saved := range entries {
+		visibility, err := compileVisibility(saved.Visibility)
+		if err != nil {
+			continue
+		}
+		key := recallKey{tenantID: saved.TenantID, notificationID: saved.NotificationID}
+		delete(l.sent, key)
+		l.order = append(l.order, recallOrder{key: key, sentAt: saved.SentAt})
+		l.sent[key] = &sentNotification{
+			tenantID:   saved.TenantID,
+			teamID:     saved.TeamID,
+			userID:     saved.UserID,
+			broadcast:  saved.Broadcast,
+			channel:    saved.Channel,
+			visibility: visibility,
+			sentAt:     saved.SentAt,
+			expiresAt:  saved.SentAt.Add(teamRetention.history(saved.TeamID, l.window)),
+			revokedAt:  saved.RevokedAt,
+			replacedBy: saved.ReplacedBy,
+		}
+	}
+	l.pruneLocked(now, l.maxEntries)
+}
+
+// isRevoked reports whether a notification has been recalled.
+func (l *recallLedger) isRevoked(tenantID, notificationID string) bool {
+	if l == nil || notificationID == "" {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entry, ok := l.sent[recallKey{tenantID: tenantID, notificationID: notificationID}]
+	return ok && !entry.revokedAt.IsZero()
+}
+
+// dropRevoked removes recalled notifications from a batch about to be
+// written.
+func (c *Client) dropRevoked(batch []outboundMessage) []outboundMessage {
+	if notificationRecalls == nil {
+		return batch
+	}
+	kept := batch[:0]
+	for _, message := range batch {
+		if notificationRecalls.isRevoked(message.tenantID, message.notificationID) {
+			appMetrics.Count("messages.recalled", 1, tenantTags(message.tenantID)...)
+			continue
+		}
+		kept = append(kept, message)
+	}
+	return kept
+}
+
+// audienceClients returns the connected clients a notification was sent to,
+// following the same rules as /send, including its visibility rules.
+func (h *Hub) audienceClients(sent sentNotification) []*Client {
+	var candidates []*Client
+	if sent.channel != "" {
+		candidates = h.snapshotChannelClients(sent.teamID, sent.channel)
+	} else if sent.broadcast && sent.teamID != "" {
+		candidates = h.snapshotTeamClients(sent.teamID)
+	} else {
+		candidates = h.snapshotAllClients()
+	}
+
+	target := outboundMessage{tenantID: sent.tenantID}
+	var clients []*Client
+	for _, client := range candidates {
+		if !sent.visibility.allows(client) {
+			continue
+		}
+		switch {
+		case sent.broadcast && sent.teamID != "":
+		case sent.broadcast:
+			if !target.reaches(client) {
+				continue
+			}
+		case sent.teamID != "":
+			if client.teamID != sent.teamID || client.userID != sent.userID {
+				continue
+			}
+		default:
+			if !target.reaches(client) || client.userID != sent.userID {
+				continue
+			}
+		}
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// recallNotification purges the queued copies of a recalled notification and
+// tells its audience's connected clients to remove it. It returns how many
+// clients were notified and how many held-back copies were purged.
+func (h *Hub) recallNotification(sent sentNotification, notificationID, reason string) (notified, purged int, err error) {
+	payload, err := json.Marshal(NotificationRevokedFrame{
+		Type:           "notificationRevoked",
+		NotificationID: notificationID,
+		RevokedAt:      sent.revokedAt.UnixMilli(),
+		Reason:         reason,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	clients := h.audienceClients(sent)
+	purged = h.purgeCopies(sent, notificationID, clients)
+	for _, client := range clients {
+		if h.enqueueControl(client, outboundMessage{payload: payload}) {
+			notified++
+		}
+	}
+	return notified, purged, nil
+}
+
+// purgeCopies removes a notification from the digest batches of clients,
+// from blackout deferrals and from its recipient's offline queue, and returns
+// how many copies it removed.
+func (h *Hub) purgeCopies(sent sentNotification, notificationID string, clients []*Client) int {
+	purged := 0
+	if sent.broadcast {
+		purged += teamBlackouts.discard(sent.tenantID, notificationID)
+	} else if offlineNotifications.discard(context.Background(), sent.tenantID, sent.userID, notificationID) {
+		purged++
+	}
+	for _, client := range clients {
+		if client.digest.discard(notificationID) {
+			purged++
+		}
+	}
+	return purged
+}
+
+// handleNotificationRecall serves DELETE /notifications/{id}.
+func handleNotificationRecall(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	notificationID := strings.TrimPrefix(r.URL.Path, "/notifications/")
+	if notificationID == "" || strings.Contains(notificationID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if len(reason) > maxRecallReasonLength {
+		http.Error(w, fmt.Sprintf("reason must be at most %d bytes", maxRecallReasonLength), http.StatusBadRequest)
+		return
+	}
+
+	tenantID, err := sendTenantID(r, &MessageRequest{TenantID: strings.TrimSpace(r.URL.Query().Get("tenant_id"))})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	sent, err := notificationRecalls.revoke(tenantID, notificationID)
+	switch {
+	case errors.Is(err, errRecallNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errAlreadyRevoked), errors.Is(err, errSuperseded):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	notificationEscalations.cancel(tenantID, notificationID, time.Now())
+	onCallSchedules.cancel(tenantID, notificationID)
+	notified, purged, err := hub.recallNotification(sent, notificationID, reason)
+	if err != nil {
+		slog.Error("Failed to encode a recall", "notification", notificationID, "error", err)
+		http.Error(w, "Error encoding recall", http.StatusInternalServerError)
+		return
+	}
+
+	appMetrics.Count("notifications.recalled", 1, tenantTags(tenantID)...)
+	details := map[string]string{"notified": strconv.Itoa(notified), "purged": strconv.Itoa(purged)}
+	if tenantID != "" {
+		details["tenant"] = tenantID
+	}
+	if reason != "" {
+		details["reason"] = reason
+	}
+	recordAudit(auditEvent{Action: "notifications.recall", Subject: notificationID, Details: details})
+	slog.Info("Recalled a notification", "notification", notificationID, "notified", notified, "purged", purged)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"notificationId": notificationID,
+		"revoked":        true,
+		"notified":       notified,
+		"purged":         purged,
+	})
+}
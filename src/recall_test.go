@@ -0,0 +1,248 @@
+// recall_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecallLedger_RevokeAndPrune(t *testing.T) {
+	ledger := newRecallLedger(time.Minute, 2)
+	now := time.Now()
+	ledger.now = func() time.Time { return now }
+
+	ledger.recordSent(outboundMessage{teamID: "team1", notificationID: "n1"}, "alice", false)
+	ledger.recordSent(outboundMessage{teamID: "team1"}, "", true)
+	ledger.recordSent(outboundMessage{tenantID: "acme", teamID: "acme/team1", notificationID: "n1"}, "", true)
+
+	if _, err := ledger.revoke("other", "n1"); err != errRecallNotFound {
+		t.Fatalf("expected another tenant's notification to be unknown, got %v", err)
+	}
+	sent, err := ledger.revoke("", "n1")
+	if err != nil || sent.teamID != "team1" || sent.userID != "alice" || sent.broadcast {
+		t.Fatalf("unexpected revoke result: %+v, %v", sent, err)
+	}
+	if _, err := ledger.revoke("", "n1"); err != errAlreadyRevoked {
+		t.Fatalf("expected a second recall to conflict, got %v", err)
+	}
+	if !ledger.isRevoked("", "n1") || ledger.isRevoked("acme", "n1") {
+		t.Fatal("expected only the default tenant's n1 to be revoked")
+	}
+
+	ledger.recordSent(outboundMessage{teamID: "team1", notificationID: "n1"}, "alice", false)
+	if ledger.isRevoked("", "n1") {
+		t.Fatal("expected sending the ID again to make it deliverable")
+	}
+
+	// A third notification pushes the oldest one out.
+	ledger.recordSent(outboundMessage{teamID: "team1", notificationID: "n2"}, "bob", false)
+	if _, err := ledger.revoke("acme", "n1"); err != errRecallNotFound {
+		t.Fatalf("expected the oldest notification to be forgotten, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := ledger.revoke("", "n2"); err != errRecallNotFound {
+		t.Fatalf("expected notifications to expire after the window, got %v", err)
+	}
+}
+
+func TestRecallNotification_PurgesQueuesAndNotifiesAudience(t *testing.T) {
+	setupTestAppConfig()
+	notificationRecalls = newRecallLedger(time.Hour, 100)
+	teamBlackouts = newBlackoutSchedule(nil, 10)
+	defer func() { notificationRecalls, teamBlackouts = nil, nil }()
+
+	hub := newHub()
+	newTestClient := func(teamID, userID string) *Client {
+		client := &Client{hub: hub, teamID: teamID, userID: userID, send: make(chan outboundMessage, 4), control: make(chan outboundMessage, 4)}
+		if hub.clients[teamID] == nil {
+			hub.clients[teamID] = map[string]map[*Client]struct{}{}
+		}
+		hub.clients[teamID][userID] = map[*Client]struct{}{client: {}}
+		return client
+	}
+	alice := newTestClient("team1", "alice")
+	bob := newTestClient("team1", "bob")
+	carol := newTestClient("team2", "carol")
+	bob.digest = &clientDigest{maxMessages: 10, flush: make(chan struct{}, 1)}
+
+	now := time.Now()
+	if _, err := teamBlackouts.add(blackoutWindow{TeamID: "team2", Start: now.Add(-time.Minute), End: now.Add(time.Minute)}); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	message := outboundMessage{payload: []byte(`{"notificationId":"n1"}`), messageType: "alert", notificationID: "n1"}
+	notificationRecalls.recordSent(outboundMessage{notificationID: "n1"}, "", true)
+	if delivered := broadcastToAllTeamsOutsideBlackouts(hub, message, now); delivered != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", delivered)
+	}
+	if len(alice.send) != 1 || len(bob.digest.pending) != 1 || len(carol.send) != 0 {
+		t.Fatal("expected alice to queue, bob to batch and carol to be deferred")
+	}
+
+	sent, err := notificationRecalls.revoke("", "n1")
+	if err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	notified, purged, err := hub.recallNotification(sent, "n1", "mistake")
+	if err != nil || notified != 3 || purged != 2 {
+		t.Fatalf("expected 3 clients notified and 2 copies purged, got %d, %d, %v", notified, purged, err)
+	}
+	if len(bob.digest.pending) != 0 || len(teamBlackouts.deferred) != 0 {
+		t.Fatal("expected the digest and blackout copies to be purged")
+	}
+
+	var frame NotificationRevokedFrame
+	if err := json.Unmarshal((<-alice.control).payload, &frame); err != nil {
+		t.Fatalf("failed to decode frame: %v", err)
+	}
+	if frame.Type != "notificationRevoked" || frame.NotificationID != "n1" || frame.Reason != "mistake" || frame.RevokedAt == 0 {
+		t.Fatalf("unexpected frame: %+v", frame)
+	}
+
+	if kept := alice.dropRevoked([]outboundMessage{<-alice.send, {notificationID: "n2"}}); len(kept) != 1 || kept[0].notificationID != "n2" {
+		t.Fatalf("expected the queued copy to be dropped at write time, got %+v", kept)
+	}
+	if hub.enqueueMessage(alice, message) {
+		t.Fatal("expected a recalled notification not to be queued again")
+	}
+}
+
+func TestHandleNotificationRecall(t *testing.T) {
+	setupTestAppConfig()
+	notificationRecalls = newRecallLedger(time.Hour, 100)
+	defer func() { notificationRecalls = nil }()
+	hub := newHub()
+	client := &Client{hub: hub, teamID: "team1", userID: "alice", send: make(chan outboundMessage, 1), control: make(chan outboundMessage, 1)}
+	hub.clients["team1"] = map[string]map[*Client]struct{}{"alice": {client: {}}}
+
+	notificationRecalls.recordSent(outboundMessage{teamID: "team1", notificationID: "n1"}, "alice", false)
+
+	recall := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleNotificationRecall(hub, rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	if rec := recall(http.MethodGet, "/notifications/n1"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+	for _, path := range []string{"/notifications/", "/notifications/n1/extra"} {
+		if rec := recall(http.MethodDelete, path); rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for %s, got %d", path, rec.Code)
+		}
+	}
+	if rec := recall(http.MethodDelete, "/notifications/n1?tenant_id=missing"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for an unknown tenant, got %d", rec.Code)
+	}
+	if rec := recall(http.MethodDelete, "/notifications/unknown"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown notification, got %d", rec.Code)
+	}
+
+	rec := recall(http.MethodDelete, "/notifications/n1?reason=typo")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		NotificationID string `json:"notificationId"`
+		Revoked        bool   `json:"revoked"`
+		Notified       int    `json:"notified"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.NotificationID != "n1" || !response.Revoked || response.Notified != 1 || len(client.control) != 1 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	if rec := recall(http.MethodDelete, "/notifications/n1"); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a second recall, got %d", rec.Code)
+	}
+}
+
+func TestHandleSendMessage_ReplacesNotification(t *testing.T) {
+	setupTestAppConfig()
+	notificationRecalls = newRecallLedger(time.Hour, 100)
+	defer func() { notificationRecalls = nil }()
+	hub := newHub()
+	client := &Client{hub: hub, teamID: "team-1", userID: "user-1", send: make(chan outboundMessage, 4)}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"user-1": {client: {}}}
+
+	send := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleSendMessage(hub, rec, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := send(`{"notification_id":"build-1","target_team_id":"team-1","message_type":"build","body":"build running","broadcast":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := send(`{"notification_id":"build-2","replaces_id":"build-1","target_team_id":"team-1","message_type":"build","body":"build passed","broadcast":true}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"replaced":true`) {
+		t.Fatalf("expected the replacement to be reported, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	batch := client.dropRevoked([]outboundMessage{<-client.send, <-client.send})
+	if len(batch) != 1 {
+		t.Fatalf("expected only the replacement to be written, got %d messages", len(batch))
+	}
+	var delivered Message
+	if err := json.Unmarshal(batch[0].payload, &delivered); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if delivered.NotificationID != "build-2" || delivered.ReplacesID != "build-1" {
+		t.Fatalf("unexpected replacement payload: %+v", delivered)
+	}
+
+	if rec := send(`{"notification_id":"build-3","replaces_id":"build-1","target_team_id":"team-1","message_type":"build","body":"again","broadcast":true}`); strings.Contains(rec.Body.String(), `"replaced"`) {
+		t.Fatalf("expected an already replaced notification not to be replaced again, got %s", rec.Body.String())
+	}
+	if _, err := notificationRecalls.revoke("", "build-1"); err != errSuperseded {
+		t.Fatalf("expected recalling a replaced notification to fail, got %v", err)
+	}
+
+	for body, want := range map[string]string{
+		`{"replaces_id":"build-1","target_team_id":"team-1","message_type":"build","body":"x","broadcast":true}`:                 "replaces_id requires notification_id",
+		`{"notification_id":"b","replaces_id":"b","target_team_id":"team-1","message_type":"build","body":"x","broadcast":true}`: "replaces_id must differ from notification_id",
+	} {
+		if rec := send(body); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("expected 400 with %q, got %d: %s", want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestHandleNotificationRecall_PurgesOfflineQueue(t *testing.T) {
+	setupTestAppConfig()
+	store := newMemoryStore()
+	offlineNotifications = newOfflineQueue(store, 10, time.Hour)
+	notificationRecalls = newRecallLedger(time.Hour, 100)
+	defer func() { offlineNotifications, notificationRecalls = nil, nil }()
+	hub := newHub()
+
+	rr := httptest.NewRecorder()
+	handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(
+		`{"notification_id":"n1","target_team_id":"team-1","target_user_id":"bob","message_type":"chat","body":"hi"}`)))
+	if !strings.Contains(rr.Body.String(), `"queued":true`) {
+		t.Fatalf("expected n1 to be queued for bob, got %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleNotificationRecall(hub, rr, httptest.NewRequest(http.MethodDelete, "/notifications/n1", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"purged":1`) {
+		t.Fatalf("expected the queued copy to be purged, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// The ledger forgets the recall long before the queue expires, so the
+	// reconnect must not find the copy.
+	notificationRecalls = newRecallLedger(time.Hour, 100)
+	bob := &Client{hub: hub, teamID: "team-1", userID: "bob", send: make(chan outboundMessage, 1)}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"bob": {bob: {}}}
+	if delivered := offlineNotifications.deliver(context.Background(), hub, bob); delivered != 0 || len(bob.send) != 0 {
+		t.Fatalf("expected the recalled notification not to be delivered on reconnect, got %d", delivered)
+	}
+}
@@ -0,0 +1,219 @@
+// redis_client.go
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisError is an error reply returned by the server (a "-ERR ..." line).
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisClient is a minimal RESP2 client covering the handful of commands the
+// server needs. It keeps one connection, serializes commands over it and
+// redials after any I/O error.
+type redisClient struct {
+	address  string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newRedisClient(address, password string, db int, timeout time.Duration) *redisClient {
+	return &redisClient{
+		address:  address,
+		password: password,
+		db:       db,
+		timeout:  timeout,
+	}
+}
+
+// Do sends a command and returns its reply: string for simple and bulk
+// strings, int64 for integers, []interface{} for arrays and nil for null
+// replies. Error replies are returned as redisError.
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.connectLocked(); err != nil {
+		return nil, err
+	}
+
+	reply, err := c.roundTripLocked(args)
+	if err != nil {
+		var replyErr redisError
+		if !errors.As(err, &replyErr) {
+			c.closeLocked()
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *redisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeLocked()
+	return nil
+}
+
+func (c *redisClient) connectLocked() error {
+	if c.conn != nil {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis at %s: %v", c.address, err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	if c.password != "" {
+		if _, err := c.roundTripLocked([]string{"AUTH", c.password}); err != nil {
+			c.closeLocked()
+			return fmt.Errorf("redis AUTH failed: %v", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTripLocked([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			c.closeLocked()
+			return fmt.Errorf("redis SELECT failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) closeLocked() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = nil
+	c.reader = nil
+}
+
+func (c *redisClient) roundTripLocked(args []string) (interface{}, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if _, err := c.conn.Write(encodeRESPCommand(args)); err != nil {
+		return nil, err
+	}
+	return readRESPReply(c.reader)
+}
+
+func encodeRESPCommand(args []string) []byte {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("malformed redis reply line")
+	}
+	return line[:len(line)-2], nil
+}
+
+func readRESPReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := readRESPReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected redis reply type %q", line[0])
+	}
+}
+
+// redisStrings converts an array reply into strings, skipping null entries.
+func redisStrings(reply interface{}) ([]string, error) {
+	if reply == nil {
+		return nil, nil
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected array reply, got %T", reply)
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		value, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string array item, got %T", item)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
@@ -0,0 +1,233 @@
+// retention.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TeamRetention overrides how much is kept for one team, and for how long.
+// Zero values fall back to the server-wide settings.
+type TeamRetention struct {
+	TeamID          string        `yaml:"team_id"`           // hub key, e.g. "acme/team-123" for a tenant's team
+	ReplayBuffer    int           `yaml:"replay_buffer"`     // Broadcasts kept during a blackout; 0 uses blackout.max_deferred_per_team
+	OfflineQueueTTL time.Duration `yaml:"offline_queue_ttl"` // Deferred broadcasts older than this are dropped instead of released; 0 keeps them
+	History         time.Duration `yaml:"history"`           // How long sent notifications stay recallable; 0 uses recall.window
+}
+
+func validateRetention(config *Config) error {
+	seen := make(map[string]bool, len(config.Retention))
+	for i := range config.Retention {
+		policy := &config.Retention[i]
+		policy.TeamID = strings.TrimSpace(policy.TeamID)
+		if policy.TeamID == "" {
+			return fmt.Errorf("retention[%d].team_id is required", i)
+		}
+		if policy.ReplayBuffer < 0 || policy.OfflineQueueTTL < 0 || policy.History < 0 {
+			return fmt.Errorf("retention[%d] replay_buffer, offline_queue_ttl and history must not be negative", i)
+		}
+		if seen[policy.TeamID] {
+			return fmt.Errorf("retention has duplicate team_id %q", policy.TeamID)
+		}
+		seen[policy.TeamID] = true
+	}
+	return nil
+}
+
+// retentionTable holds the per-team retention policies from the config file
+// and those set through /admin/retention, which take precedence. Policies set
+// through the API live in memory only and are lost on restart.
+type retentionTable struct {
+	mu         sync.RWMutex
+	configured map[string]TeamRetention
+	overrides  map[string]TeamRetention
+}
+
+// teamRetention is nil until main configures it, and all methods are
+// nil-safe.
+var teamRetention *retentionTable
+
+func newRetentionTable(policies []TeamRetention) *retentionTable {
+	table := &retentionTable{
+		configured: make(map[string]TeamRetention, len(policies)),
+		overrides:  make(map[string]TeamRetention),
+	}
+	for _, policy := range policies {
+		table.configured[policy.TeamID] = policy
+	}
+	return table
+}
+
+// policy returns the team's policy and whether one is set.
+func (t *retentionTable) policy(teamID string) (TeamRetention, bool) {
+	if t == nil || teamID == "" {
+		return TeamRetention{}, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if policy, ok := t.overrides[teamID]; ok {
+		return policy, true
+	}
+	policy, ok := t.configured[teamID]
+	return policy, ok
+}
+
+// replayBuffer is how many deferred broadcasts teamID keeps during a
+// blackout.
+func (t *retentionTable) replayBuffer(teamID string, fallback int) int {
+	if policy, _ := t.policy(teamID); policy.ReplayBuffer > 0 {
+		return policy.ReplayBuffer
+	}
+	return fallback
+}
+
+// offlineQueueTTL is how long a deferred broadcast to teamID may wait; 0 is
+// unlimited.
+func (t *retentionTable) offlineQueueTTL(teamID string) time.Duration {
+	policy, _ := t.policy(teamID)
+	return policy.OfflineQueueTTL
+}
+
+// history is how long a notification sent to teamID stays recallable.
+func (t *retentionTable) history(teamID string, fallback time.Duration) time.Duration {
+	if policy, _ := t.policy(teamID); policy.History > 0 {
+		return policy.History
+	}
+	return fallback
+}
+
+func (t *retentionTable) set(policy TeamRetention) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.overrides[policy.TeamID] = policy
+}
+
+// remove drops the policy set through the API, reverting the team to its
+// configured policy, if any.
+func (t *retentionTable) remove(teamID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.overrides[teamID]; !ok {
+		return false
+	}
+	delete(t.overrides, teamID)
+	return true
+}
+
+// retentionView is a team's effective policy as /admin/retention shows it.
+type retentionView struct {
+	TeamID                 string `json:"teamId,omitempty"`
+	ReplayBuffer           int    `json:"replayBuffer"`
+	OfflineQueueTTLSeconds int64  `json:"offlineQueueTtlSeconds"` // 0 keeps deferred broadcasts until released
+	HistorySeconds         int64  `json:"historySeconds"`
+	Source                 string `json:"source,omitempty"` // config or admin
+}
+
+func (t *retentionTable) view(teamID, source string) retentionView {
+	return retentionView{
+		TeamID:                 teamID,
+		ReplayBuffer:           t.replayBuffer(teamID, AppConfig().Blackout.MaxDeferredPerTeam),
+		OfflineQueueTTLSeconds: int64(t.offlineQueueTTL(teamID) / time.Second),
+		HistorySeconds:         int64(t.history(teamID, AppConfig().Recall.Window) / time.Second),
+		Source:                 source,
+	}
+}
+
+// list returns the effective policy of every team that has one, ordered by
+// team.
+func (t *retentionTable) list() []retentionView {
+	t.mu.RLock()
+	sources := make(map[string]string, len(t.configured)+len(t.overrides))
+	for teamID := range t.configured {
+		sources[teamID] = "config"
+	}
+	for teamID := range t.overrides {
+		sources[teamID] = "admin"
+	}
+	t.mu.RUnlock()
+
+	views := make([]retentionView, 0, len(sources))
+	for teamID, source := range sources {
+		views = append(views, t.view(teamID, source))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].TeamID < views[j].TeamID })
+	return views
+}
+
+// retentionRequest is the body of PUT /admin/retention.
+type retentionRequest struct {
+	TeamID                 string `json:"teamId"`
+	ReplayBuffer           int    `json:"replayBuffer"`
+	OfflineQueueTTLSeconds int64  `json:"offlineQueueTtlSeconds"`
+	HistorySeconds         int64  `json:"historySeconds"`
+}
+
+func handleAdminRetention(w http.ResponseWriter, r *http.Request) {
+	if teamRetention == nil {
+		http.Error(w, "Retention policies are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if teamID := strings.TrimSpace(r.URL.Query().Get("teamId")); teamID != "" {
+			writeJSONWithETag(w, r, teamRetention.view(teamID, ""))
+			return
+		}
+		writeJSONWithETag(w, r, map[string]interface{}{
+			"defaults": teamRetention.view("", ""),
+			"teams":    teamRetention.list(),
+		})
+
+	case http.MethodPut:
+		var req retentionRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		teamID := strings.TrimSpace(req.TeamID)
+		if teamID == "" {
+			http.Error(w, "teamId is required", http.StatusBadRequest)
+			return
+		}
+		if req.ReplayBuffer < 0 || req.OfflineQueueTTLSeconds < 0 || req.HistorySeconds < 0 {
+			http.Error(w, "replayBuffer, offlineQueueTtlSeconds and historySeconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		policy := TeamRetention{
+			TeamID:          teamID,
+			ReplayBuffer:    req.ReplayBuffer,
+			OfflineQueueTTL: time.Duration(req.OfflineQueueTTLSeconds) * time.Second,
+			History:         time.Duration(req.HistorySeconds) * time.Second,
+		}
+		teamRetention.set(policy)
+		slog.Info("Retention set", "team", teamID, "replay_buffer", policy.ReplayBuffer, "offline_queue_ttl", policy.OfflineQueueTTL, "history", policy.History)
+		recordAudit(auditEvent{Action: "retention.set", Subject: teamID, Details: map[string]string{
+			"replay_buffer":     fmt.Sprint(policy.ReplayBuffer),
+			"offline_queue_ttl": policy.OfflineQueueTTL.String(),
+			"history":           policy.History.String(),
+		}})
+		writeJSON(w, http.StatusOK, teamRetention.view(teamID, "admin"))
+
+	case http.MethodDelete:
+		teamID := strings.TrimSpace(r.URL.Query().Get("teamId"))
+		if teamID == "" {
+			http.Error(w, "teamId is required", http.StatusBadRequest)
+			return
+		}
+		if !teamRetention.remove(teamID) {
+			http.Error(w, "No retention policy was set for this team through the API", http.StatusNotFound)
+			return
+		}
+		recordAudit(auditEvent{Action: "retention.reset", Subject: teamID})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,87 @@
+// retention_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetention_AppliesPerTeam(t *testing.T) {
+	setupTestAppConfig()
+	teamRetention = newRetentionTable([]TeamRetention{
+		{TeamID: "strict", ReplayBuffer: 1, OfflineQueueTTL: time.Minute, History: time.Minute},
+	})
+	defer func() { teamRetention = nil }()
+
+	schedule := newBlackoutSchedule(nil, 10)
+	now := time.Now()
+	for _, teamID := range []string{"strict", "relaxed"} {
+		schedule.add(blackoutWindow{TeamID: teamID, Start: now, End: now.Add(time.Hour)})
+		schedule.deferBroadcast(teamID, outboundMessage{payload: []byte("first"), messageType: "chat"}, now)
+		schedule.deferBroadcast(teamID, outboundMessage{payload: []byte("second"), messageType: "chat"}, now.Add(30*time.Minute))
+	}
+	released := schedule.release(now.Add(2 * time.Hour))
+	if len(released["relaxed"]) != 2 {
+		t.Fatalf("expected the default replay buffer and no TTL, got %d broadcasts", len(released["relaxed"]))
+	}
+	if len(released["strict"]) != 0 {
+		t.Fatalf("expected the strict team's broadcast to expire, got %d", len(released["strict"]))
+	}
+
+	ledger := newRecallLedger(time.Hour, 10)
+	ledger.now = func() time.Time { return now }
+	ledger.recordSent(outboundMessage{teamID: "strict", notificationID: "n1"}, "alice", false)
+	ledger.recordSent(outboundMessage{teamID: "relaxed", notificationID: "n2"}, "alice", false)
+	now = now.Add(2 * time.Minute)
+	if _, err := ledger.revoke("", "n1"); err != errRecallNotFound {
+		t.Fatalf("expected the strict team's history to have expired, got %v", err)
+	}
+	if _, err := ledger.revoke("", "n2"); err != nil {
+		t.Fatalf("expected recall.window to apply to other teams, got %v", err)
+	}
+}
+
+func TestHandleAdminRetention(t *testing.T) {
+	setupTestAppConfig()
+	teamRetention = newRetentionTable([]TeamRetention{{TeamID: "team1", History: time.Hour}})
+	defer func() { teamRetention = nil }()
+
+	rr := httptest.NewRecorder()
+	handleAdminRetention(rr, httptest.NewRequest(http.MethodPut, "/admin/retention",
+		strings.NewReader(`{"teamId":"team1","replayBuffer":5,"historySeconds":60}`)))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"historySeconds":60`) {
+		t.Fatalf("expected the policy to be set, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := teamRetention.replayBuffer("team1", 10); got != 5 {
+		t.Fatalf("expected the API policy to override the configured one, got replay buffer %d", got)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminRetention(rr, httptest.NewRequest(http.MethodGet, "/admin/retention", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"source":"admin"`) {
+		t.Fatalf("expected the team in the listing, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminRetention(rr, httptest.NewRequest(http.MethodPut, "/admin/retention", strings.NewReader(`{"teamId":"team1","historySeconds":-1}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for a negative value, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminRetention(rr, httptest.NewRequest(http.MethodDelete, "/admin/retention?teamId=team1", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rr.Code)
+	}
+	if got := teamRetention.history("team1", time.Minute); got != time.Hour {
+		t.Fatalf("expected the configured policy to apply again, got history %s", got)
+	}
+	rr = httptest.NewRecorder()
+	handleAdminRetention(rr, httptest.NewRequest(http.MethodDelete, "/admin/retention?teamId=team1", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 without an API policy, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,474 @@
+// schedules.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// misfireFireOnce sends a run that is later than the misfire grace once,
+	// as soon as the scheduler notices it.
+	misfireFireOnce = "fire_once"
+	// misfireSkip records a late run as missed and waits for the next one.
+	misfireSkip = "skip"
+)
+
+var errScheduleNotFound = errors.New("schedule not found")
+
+// broadcastSchedules is nil unless schedules.enabled is set.
+var broadcastSchedules *broadcastScheduler
+
+// scheduleTemplateData is what a schedule's body template can refer to.
+type scheduleTemplateData struct {
+	TeamID       string
+	ScheduledFor time.Time // in the schedule's timezone
+	FiredAt      time.Time // in the schedule's timezone
+}
+
+// scheduledJob is a ScheduledBroadcast ready to run.
+type scheduledJob struct {
+	record   ScheduledBroadcast
+	cron     *cronSchedule
+	template *template.Template
+	teamID   string // scoped
+}
+
+// broadcastScheduler fires recurring team broadcasts. Schedules and their
+// execution history are kept in the store, so runs that fall due while the
+// server is down are handled as misfires once it is back.
+//
+// A run is on time when the scheduler sees it within grace of when it was
+// due. Later than that it has misfired, and the schedule's misfire policy
+// decides whether it is still sent. Either way, a schedule that fell several
+// runs behind is sent at most once to catch up; the older runs are recorded
+// as missed.
+type broadcastScheduler struct {
+	hub         *Hub
+	store       Store
+	grace       time.Duration
+	historySize int
+	now         func() time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob
+}
+
+func newBroadcastScheduler(hub *Hub, store Store, grace time.Duration, historySize int) *broadcastScheduler {
+	return &broadcastScheduler{
+		hub:         hub,
+		store:       store,
+		grace:       grace,
+		historySize: historySize,
+		now:         time.Now,
+		jobs:        make(map[string]*scheduledJob),
+	}
+}
+
+// compileSchedule validates a schedule and prepares it to run.
+func compileSchedule(record ScheduledBroadcast) (*scheduledJob, error) {
+	if record.TeamID == "" {
+		return nil, errors.New("teamId is required")
+	}
+	if record.TeamID == statsTeamID {
+		return nil, errors.New("teamId " + statsTeamID + " is reserved")
+	}
+	if record.MessageType == "" {
+		return nil, errors.New("messageType is required")
+	}
+	if strings.TrimSpace(record.Template) == "" {
+		return nil, errors.New("template is required")
+	}
+	if record.MisfirePolicy != misfireFireOnce && record.MisfirePolicy != misfireSkip {
+		return nil, fmt.Errorf("misfirePolicy must be %s or %s", misfireFireOnce, misfireSkip)
+	}
+
+	if _, err := findTenant(record.TenantID); err != nil {
+		return nil, err
+	}
+	teamID, err := scopeTeam(record.TenantID, record.TeamID)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(record.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", record.Timezone)
+	}
+	cron, err := parseCron(record.Cron, location)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(record.ID).Option("missingkey=error").Parse(record.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return &scheduledJob{record: record, cron: cron, template: tmpl, teamID: teamID}, nil
+}
+
+// load restores the schedules saved in the store. Runs that fell due while
+// the server was down misfire on the next tick.
+func (s *broadcastScheduler) load(ctx context.Context) (int, error) {
+	records, err := s.store.Schedules(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range records {
+		job, err := compileSchedule(*record)
+		if err != nil {
+			slog.Error("Skipping a schedule", "schedule", record.ID, "error", err)
+			continue
+		}
+		s.jobs[record.ID] = job
+	}
+	return len(s.jobs), nil
+}
+
+// add validates and saves a new schedule.
+func (s *broadcastScheduler) add(ctx context.Context, record ScheduledBroadcast) (ScheduledBroadcast, error) {
+	record.ID = newNotificationID()
+	record.CreatedAt = s.now().UTC()
+	record.History = nil
+	job, err := compileSchedule(record)
+	if err != nil {
+		return ScheduledBroadcast{}, err
+	}
+	job.record.NextRunAt = job.cron.next(record.CreatedAt)
+	if job.record.NextRunAt.IsZero() {
+		return ScheduledBroadcast{}, errors.New("cron expression never matches")
+	}
+
+	if err := s.store.SaveSchedule(ctx, &job.record); err != nil {
+		return ScheduledBroadcast{}, err
+	}
+	s.mu.Lock()
+	s.jobs[record.ID] = job
+	s.mu.Unlock()
+	return job.record, nil
+}
+
+func (s *broadcastScheduler) remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	_, ok := s.jobs[id]
+	delete(s.jobs, id)
+	s.mu.Unlock()
+	if !ok {
+		return errScheduleNotFound
+	}
+	return s.store.DeleteSchedule(ctx, id)
+}
+
+// get returns a schedule with its history.
+func (s *broadcastScheduler) get(id string) (ScheduledBroadcast, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return ScheduledBroadcast{}, false
+	}
+	record := job.record
+	record.History = append([]ScheduleExecution(nil), job.record.History...)
+	return record, true
+}
+
+// list returns the schedules, optionally only those for one tenant's team,
+// oldest first and without their history.
+func (s *broadcastScheduler) list(tenantID, teamID string) []ScheduledBroadcast {
+	s.mu.Lock()
+	records := make([]*ScheduledBroadcast, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if teamID != "" && (job.record.TenantID != tenantID || job.record.TeamID != teamID) {
+			continue
+		}
+		record := job.record
+		record.History = nil
+		records = append(records, &record)
+	}
+	s.mu.Unlock()
+
+	sortSchedules(records)
+	list := make([]ScheduledBroadcast, len(records))
+	for i, record := range records {
+		list[i] = *record
+	}
+	return list
+}
+
+// run checks for due schedules every interval until stop is closed.
+func (s *broadcastScheduler) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tick runs every schedule that is due. It returns how many were.
+func (s *broadcastScheduler) tick() int {
+	now := s.now()
+	s.mu.Lock()
+	var due []*scheduledJob
+	for _, job := range s.jobs {
+		// A schedule with no further runs has a zero NextRunAt and is kept
+		// only for its history.
+		if !job.record.NextRunAt.IsZero() && !now.Before(job.record.NextRunAt) {
+			due = append(due, job)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, job := range due {
+		s.runDue(job, now)
+	}
+	return len(due)
+}
+
+// runDue handles every run of job that is due at now.
+func (s *broadcastScheduler) runDue(job *scheduledJob, now time.Time) {
+	s.mu.Lock()
+	if s.jobs[job.record.ID] != job {
+		s.mu.Unlock()
+		return // removed meanwhile
+	}
+	first := job.record.NextRunAt
+	latest := first
+	missed := 0
+	for next := job.cron.next(latest); !next.IsZero() && !next.After(now); next = job.cron.next(next) {
+		latest = next
+		missed++
+	}
+	scheduledFor := latest
+	s.mu.Unlock()
+
+	var executions []ScheduleExecution
+	if missed > 0 {
+		executions = append(executions, ScheduleExecution{ScheduledFor: first.UTC(), Status: "missed", Missed: missed})
+		slog.Warn("Schedule missed runs while the scheduler was behind", "schedule", job.record.ID, "missed", missed)
+		appMetrics.Count("schedules.missed", int64(missed))
+	}
+
+	late := now.Sub(scheduledFor)
+	if late > s.grace && job.record.MisfirePolicy == misfireSkip {
+		executions = append(executions, ScheduleExecution{ScheduledFor: scheduledFor.UTC(), Status: "missed", Error: fmt.Sprintf("misfired by %s", late.Round(time.Second))})
+		slog.Warn("Schedule misfired and was skipped", "schedule", job.record.ID, "late", late.Round(time.Second))
+		appMetrics.Count("schedules.missed", 1)
+	} else {
+		if late > s.grace {
+			slog.Warn("Schedule misfired and is sent now", "schedule", job.record.ID, "late", late.Round(time.Second))
+			appMetrics.Count("schedules.misfired", 1)
+		}
+		executions = append(executions, s.fire(job, scheduledFor, now))
+	}
+
+	s.mu.Lock()
+	if s.jobs[job.record.ID] != job {
+		s.mu.Unlock()
+		return
+	}
+	job.record.NextRunAt = job.cron.next(now)
+	for _, execution := range executions {
+		job.record.History = append([]ScheduleExecution{execution}, job.record.History...)
+	}
+	if len(job.record.History) > s.historySize {
+		job.record.History = job.record.History[:s.historySize]
+	}
+	record := job.record
+	record.History = append([]ScheduleExecution(nil), job.record.History...)
+	s.mu.Unlock()
+
+	if record.NextRunAt.IsZero() {
+		slog.Info("Schedule has no further runs", "schedule", record.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.store.SaveSchedule(ctx, &record); err != nil {
+		slog.Error("Failed to save a schedule", "schedule", record.ID, "error", err)
+	}
+}
+
+// fire renders and broadcasts one run. The notification ID is derived from
+// the schedule and the run, so a run sent again after a crash before its
+// state was saved carries the same ID and clients can drop the duplicate.
+func (s *broadcastScheduler) fire(job *scheduledJob, scheduledFor, now time.Time) ScheduleExecution {
+	execution := ScheduleExecution{
+		ScheduledFor:   scheduledFor.UTC(),
+		FiredAt:        now.UTC(),
+		NotificationID: fmt.Sprintf("schedule-%s-%d", job.record.ID, scheduledFor.Unix()),
+	}
+
+	var body bytes.Buffer
+	err := job.template.Execute(&body, scheduleTemplateData{
+		TeamID:       job.record.TeamID,
+		ScheduledFor: scheduledFor.In(job.cron.location),
+		FiredAt:      now.In(job.cron.location),
+	})
+	if err == nil && strings.TrimSpace(body.String()) == "" {
+		err = errors.New("template rendered an empty body")
+	}
+	if err == nil && int64(body.Len()) > AppConfig().WebSocket.MaxMessageSize {
+		err = fmt.Errorf("rendered body exceeds %d bytes", AppConfig().WebSocket.MaxMessageSize)
+	}
+	if err != nil {
+		execution.Status = "failed"
+		execution.Error = err.Error()
+		slog.Error("Schedule failed", "schedule", job.record.ID, "error", err)
+		appMetrics.Count("schedules.failed", 1)
+		return execution
+	}
+
+	message := NewMessage(execution.NotificationID, job.record.TeamID, "", "", job.record.MessageType, strings.TrimSpace(body.String()), job.record.ActionRequired)
+	payload, err := message.ToJSON()
+	if err != nil {
+		execution.Status = "failed"
+		execution.Error = err.Error()
+		appMetrics.Count("schedules.failed", 1)
+		return execution
+	}
+	outbound := outboundMessage{
+		payload:        payload,
+		receivedAt:     now,
+		tenantID:       job.record.TenantID,
+		teamID:         job.teamID,
+		messageType:    job.record.MessageType,
+		notificationID: execution.NotificationID,
+		fanout:         newFanoutCache(message.Body),
+	}
+	conversationReads.recordSend(job.teamID, message, true)
+	notificationRecalls.recordSent(outbound, "", true)
+
+	switch {
+	case teamBlackouts.deferBroadcast(job.teamID, outbound, now):
+		execution.Status = "deferred"
+	default:
+		execution.Delivered = s.hub.broadcastToTeam(job.teamID, outbound)
+		execution.Status = "delivered"
+		if execution.Delivered == 0 {
+			execution.Status = "no_recipients"
+		}
+	}
+	slog.Info("Schedule fired", "schedule", job.record.ID, "team", job.teamID, "status", execution.Status, "recipients", execution.Delivered)
+	appMetrics.Count("schedules.fired", 1, tenantTags(job.record.TenantID, metricTag("status", execution.Status))...)
+	return execution
+}
+
+type scheduleRequest struct {
+	TenantID       string `json:"tenantId"`
+	TeamID         string `json:"teamId"`
+	Cron           string `json:"cron"`
+	Timezone       string `json:"timezone"`
+	MessageType    string `json:"messageType"`
+	Template       string `json:"template"`
+	ActionRequired bool   `json:"actionRequired"`
+	MisfirePolicy  string `json:"misfirePolicy"`
+}
+
+// handleAdminSchedules lists, registers and removes scheduled broadcasts.
+func handleAdminSchedules(w http.ResponseWriter, r *http.Request) {
+	if broadcastSchedules == nil {
+		http.Error(w, "Scheduled broadcasts are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		writeJSONWithETag(w, r, map[string]interface{}{
+			"schedules": broadcastSchedules.list(strings.TrimSpace(query.Get("tenantId")), strings.TrimSpace(query.Get("teamId"))),
+		})
+
+	case http.MethodPost:
+		var req scheduleRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		schedule, err := broadcastSchedules.add(r.Context(), ScheduledBroadcast{
+			TenantID:       strings.TrimSpace(req.TenantID),
+			TeamID:         strings.TrimSpace(req.TeamID),
+			Cron:           strings.TrimSpace(req.Cron),
+			Timezone:       firstNonEmpty(strings.TrimSpace(req.Timezone), "UTC"),
+			MessageType:    strings.TrimSpace(req.MessageType),
+			Template:       req.Template,
+			ActionRequired: req.ActionRequired,
+			MisfirePolicy:  firstNonEmpty(strings.TrimSpace(req.MisfirePolicy), misfireFireOnce),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("Schedule registered", "schedule", schedule.ID, "team", schedule.TeamID, "cron", schedule.Cron, "next_run", schedule.NextRunAt)
+		recordAudit(auditEvent{Action: "schedules.create", Subject: schedule.ID, Details: map[string]string{"team": schedule.TeamID, "cron": schedule.Cron}})
+		writeJSON(w, http.StatusCreated, schedule)
+
+	case http.MethodDelete:
+		id := strings.TrimSpace(r.URL.Query().Get("id"))
+		if id == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		err := broadcastSchedules.remove(r.Context(), id)
+		switch {
+		case errors.Is(err, errScheduleNotFound):
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		case err != nil:
+			slog.Error("Failed to delete a schedule", "schedule", id, "error", err)
+			http.Error(w, "Failed to delete the schedule", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(auditEvent{Action: "schedules.delete", Subject: id})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminScheduleHistory returns a schedule's latest executions.
+func handleAdminScheduleHistory(w http.ResponseWriter, r *http.Request) {
+	if broadcastSchedules == nil {
+		http.Error(w, "Scheduled broadcasts are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	schedule, ok := broadcastSchedules.get(id)
+	if !ok {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+	executions := schedule.History
+	if executions == nil {
+		executions = []ScheduleExecution{}
+	}
+	writeJSONWithETag(w, r, map[string]interface{}{
+		"id":         schedule.ID,
+		"nextRunAt":  schedule.NextRunAt,
+		"executions": executions,
+	})
+}
@@ -0,0 +1,234 @@
+// schedules_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(t *testing.T, store Store, now *time.Time) (*broadcastScheduler, *Client) {
+	t.Helper()
+	hub := newHub()
+	client := &Client{hub: hub, teamID: "team1", userID: "user1", send: make(chan outboundMessage, 8)}
+	hub.clients["team1"] = map[string]map[*Client]struct{}{"user1": {client: {}}}
+
+	scheduler := newBroadcastScheduler(hub, store, time.Minute, 3)
+	scheduler.now = func() time.Time { return *now }
+	return scheduler, client
+}
+
+func TestBroadcastScheduler_FiresOnTime(t *testing.T) {
+	setupTestAppConfig()
+	now := time.Date(2024, 5, 1, 8, 59, 30, 0, time.UTC)
+	scheduler, client := newTestScheduler(t, newMemoryStore(), &now)
+
+	schedule, err := scheduler.add(context.Background(), ScheduledBroadcast{
+		TeamID:        "team1",
+		Cron:          "0 9 * * *",
+		Timezone:      "UTC",
+		MessageType:   "digest",
+		Template:      "Digest for {{.TeamID}} on {{.ScheduledFor.Format \"2006-01-02\"}}",
+		MisfirePolicy: misfireFireOnce,
+	})
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if want := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC); !schedule.NextRunAt.Equal(want) {
+		t.Fatalf("expected the first run at %s, got %s", want, schedule.NextRunAt)
+	}
+
+	if scheduler.tick() != 0 {
+		t.Fatal("expected nothing due before 09:00")
+	}
+	now = now.Add(40 * time.Second)
+	if scheduler.tick() != 1 {
+		t.Fatal("expected the schedule to be due at 09:00")
+	}
+
+	select {
+	case msg := <-client.send:
+		var message Message
+		if err := json.Unmarshal(msg.payload, &message); err != nil {
+			t.Fatalf("failed to decode broadcast: %v", err)
+		}
+		if message.Body != "Digest for team1 on 2024-05-01" || message.MessageType != "digest" || !strings.HasPrefix(message.NotificationID, "schedule-"+schedule.ID) {
+			t.Fatalf("unexpected broadcast: %+v", message)
+		}
+	default:
+		t.Fatal("expected the team to receive the broadcast")
+	}
+
+	saved, ok := scheduler.get(schedule.ID)
+	if !ok || len(saved.History) != 1 {
+		t.Fatalf("expected one execution, got %+v", saved)
+	}
+	if got := saved.History[0]; got.Status != "delivered" || got.Delivered != 1 || !got.ScheduledFor.Equal(schedule.NextRunAt) {
+		t.Fatalf("unexpected execution: %+v", got)
+	}
+	if want := time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC); !saved.NextRunAt.Equal(want) {
+		t.Fatalf("expected the next run at %s, got %s", want, saved.NextRunAt)
+	}
+}
+
+func TestBroadcastScheduler_Misfires(t *testing.T) {
+	setupTestAppConfig()
+
+	tests := []struct {
+		name       string
+		policy     string
+		wantSent   bool
+		wantStatus string
+	}{
+		{"fire once sends the latest run late", misfireFireOnce, true, "delivered"},
+		{"skip records the latest run as missed", misfireSkip, false, "missed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+			scheduler, client := newTestScheduler(t, newMemoryStore(), &now)
+			schedule, err := scheduler.add(context.Background(), ScheduledBroadcast{
+				TeamID: "team1", Cron: "0 * * * *", Timezone: "UTC", MessageType: "digest", Template: "hourly", MisfirePolicy: tt.policy,
+			})
+			if err != nil {
+				t.Fatalf("add failed: %v", err)
+			}
+
+			// The scheduler was stalled through the 10:00, 11:00 and 12:00 runs.
+			now = time.Date(2024, 5, 1, 12, 5, 0, 0, time.UTC)
+			scheduler.tick()
+
+			sent := 0
+			for len(client.send) > 0 {
+				<-client.send
+				sent++
+			}
+			if tt.wantSent && sent != 1 || !tt.wantSent && sent != 0 {
+				t.Fatalf("expected at most one catch-up broadcast, got %d", sent)
+			}
+
+			saved, _ := scheduler.get(schedule.ID)
+			if len(saved.History) != 2 {
+				t.Fatalf("expected two executions, got %+v", saved.History)
+			}
+			latest, collapsed := saved.History[0], saved.History[1]
+			if latest.Status != tt.wantStatus || !latest.ScheduledFor.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+				t.Fatalf("unexpected latest execution: %+v", latest)
+			}
+			if collapsed.Status != "missed" || collapsed.Missed != 2 || !collapsed.ScheduledFor.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+				t.Fatalf("unexpected collapsed execution: %+v", collapsed)
+			}
+			if want := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC); !saved.NextRunAt.Equal(want) {
+				t.Fatalf("expected the next run at %s, got %s", want, saved.NextRunAt)
+			}
+		})
+	}
+}
+
+func TestBroadcastScheduler_ResumesFromStore(t *testing.T) {
+	setupTestAppConfig()
+	store := newMemoryStore()
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	first, _ := newTestScheduler(t, store, &now)
+	schedule, err := first.add(context.Background(), ScheduledBroadcast{
+		TeamID: "team1", Cron: "30 8 * * *", Timezone: "UTC", MessageType: "digest", Template: "{{.Missing}}", MisfirePolicy: misfireFireOnce,
+	})
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	// A restarted scheduler picks the schedule up from the store.
+	now = time.Date(2024, 5, 1, 8, 30, 10, 0, time.UTC)
+	second, client := newTestScheduler(t, store, &now)
+	if loaded, err := second.load(context.Background()); err != nil || loaded != 1 {
+		t.Fatalf("expected one schedule to load, got %d (err %v)", loaded, err)
+	}
+	second.tick()
+	if len(client.send) != 0 {
+		t.Fatal("expected a failed render not to broadcast")
+	}
+
+	saved, err := store.Schedules(context.Background())
+	if err != nil || len(saved) != 1 || len(saved[0].History) != 1 {
+		t.Fatalf("expected the execution to be saved, got %+v (err %v)", saved, err)
+	}
+	if got := saved[0].History[0]; got.Status != "failed" || got.Error == "" {
+		t.Fatalf("expected a failed execution, got %+v", got)
+	}
+
+	if err := second.remove(context.Background(), schedule.ID); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := second.remove(context.Background(), schedule.ID); err != errScheduleNotFound {
+		t.Fatalf("expected errScheduleNotFound, got %v", err)
+	}
+	if saved, _ := store.Schedules(context.Background()); len(saved) != 0 {
+		t.Fatalf("expected the schedule to be deleted from the store, got %+v", saved)
+	}
+}
+
+func TestHandleAdminSchedules(t *testing.T) {
+	setupTestAppConfig()
+	now := time.Now()
+	broadcastSchedules, _ = newTestScheduler(t, newMemoryStore(), &now)
+	defer func() { broadcastSchedules = nil }()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleAdminSchedules(rec, httptest.NewRequest(http.MethodPost, "/admin/schedules", strings.NewReader(body)))
+		return rec
+	}
+
+	for _, body := range []string{
+		`{"teamId":"team1","cron":"bad","messageType":"digest","template":"x"}`,
+		`{"teamId":"team1","cron":"@daily","messageType":"digest","template":"{{"}`,
+		`{"teamId":"team1","cron":"@daily","messageType":"digest","template":"x","timezone":"Mars/Base"}`,
+		`{"teamId":"team1","cron":"@daily","messageType":"digest","template":"x","misfirePolicy":"later"}`,
+		`{"teamId":"team1","cron":"0 0 30 2 *","messageType":"digest","template":"x"}`,
+		`{"teamId":"__stats__","cron":"@daily","messageType":"digest","template":"x"}`,
+		`{"teamId":"team1","cron":"@daily","template":"x"}`,
+	} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, rec.Code)
+		}
+	}
+
+	rec := post(`{"teamId":"team1","cron":"@daily","timezone":"Europe/Berlin","messageType":"digest","template":"Good morning"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created ScheduledBroadcast
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.ID == "" || created.MisfirePolicy != misfireFireOnce {
+		t.Fatalf("unexpected schedule: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handleAdminSchedules(rec, httptest.NewRequest(http.MethodGet, "/admin/schedules?teamId=team1", nil))
+	var listed struct {
+		Schedules []ScheduledBroadcast `json:"schedules"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed.Schedules) != 1 || listed.Schedules[0].ID != created.ID {
+		t.Fatalf("unexpected list: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handleAdminScheduleHistory(rec, httptest.NewRequest(http.MethodGet, "/admin/schedules/history?id="+created.ID, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"executions":[]`) {
+		t.Fatalf("expected an empty history, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handleAdminSchedules(rec, httptest.NewRequest(http.MethodDelete, "/admin/schedules?id="+created.ID, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handleAdminScheduleHistory(rec, httptest.NewRequest(http.MethodGet, "/admin/schedules/history?id="+created.ID, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after deletion, got %d", rec.Code)
+	}
+}
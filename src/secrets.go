@@ -0,0 +1,362 @@
+// secrets.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Secret references replace a string config value with a secret fetched at
+// startup:
+//
+//	vault://secret/data/notification-server#api_key
+//	awssm://prod/notification-server#api_key
+//
+// The fragment selects a field of the secret. It may be omitted for Vault
+// secrets with a single field and for AWS secrets stored as a plain string.
+type secretRef struct {
+	scheme string
+	path   string
+	field  string
+}
+
+func parseSecretRef(value string) (secretRef, bool) {
+	for _, scheme := range []string{"vault", "awssm"} {
+		rest, ok := strings.CutPrefix(value, scheme+"://")
+		if !ok {
+			continue
+		}
+		path, field, _ := strings.Cut(rest, "#")
+		return secretRef{scheme: scheme, path: strings.Trim(path, "/"), field: field}, true
+	}
+	return secretRef{}, false
+}
+
+func (r secretRef) String() string {
+	if r.field == "" {
+		return r.scheme + "://" + r.path
+	}
+	return r.scheme + "://" + r.path + "#" + r.field
+}
+
+// secretBinding ties a config field to the reference it was resolved from so
+// it can be refreshed later.
+type secretBinding struct {
+	name string
+	ref  secretRef
+	// locate returns the field within a config value.
+	locate func(root reflect.Value) reflect.Value
+}
+
+type secretResolver struct {
+	client *http.Client
+
+	vaultAddress   string
+	vaultToken     string
+	vaultNamespace string
+
+	awsRegion   string
+	awsEndpoint string
+}
+
+func newSecretResolver(config *Config) (*secretResolver, error) {
+	resolver := &secretResolver{
+		client:         &http.Client{Timeout: config.Secrets.Timeout},
+		vaultAddress:   strings.TrimRight(firstNonEmpty(config.Secrets.Vault.Address, os.Getenv("VAULT_ADDR")), "/"),
+		vaultToken:     firstNonEmpty(config.Secrets.Vault.Token, os.Getenv("VAULT_TOKEN")),
+		vaultNamespace: config.Secrets.Vault.Namespace,
+		awsRegion:      firstNonEmpty(config.Secrets.AWS.Region, os.Getenv("AWS_REGION")),
+		awsEndpoint:    strings.TrimRight(config.Secrets.AWS.Endpoint, "/"),
+	}
+	if path := strings.TrimSpace(config.Secrets.Vault.TokenFile); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("secrets.vault.token_file: %v", err)
+		}
+		resolver.vaultToken = strings.TrimSpace(string(data))
+	}
+	if resolver.awsEndpoint == "" && resolver.awsRegion != "" {
+		resolver.awsEndpoint = "https://secretsmanager." + resolver.awsRegion + ".amazonaws.com"
+	}
+	return resolver, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (r *secretResolver) resolve(ctx context.Context, ref secretRef) (string, error) {
+	switch ref.scheme {
+	case "vault":
+		return r.resolveVault(ctx, ref)
+	case "awssm":
+		return r.resolveAWS(ctx, ref)
+	default:
+		return "", fmt.Errorf("unsupported secret scheme %q", ref.scheme)
+	}
+}
+
+func (r *secretResolver) resolveVault(ctx context.Context, ref secretRef) (string, error) {
+	if r.vaultAddress == "" || r.vaultToken == "" {
+		return "", errors.New("vault address and token are required (secrets.vault or VAULT_ADDR/VAULT_TOKEN)")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.vaultAddress+"/v1/"+ref.path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", r.vaultToken)
+	if r.vaultNamespace != "" {
+		req.Header.Set("X-Vault-Namespace", r.vaultNamespace)
+	}
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := r.doJSON(req, &response); err != nil {
+		return "", fmt.Errorf("vault: %v", err)
+	}
+
+	data := response.Data
+	// KV version 2 nests the secret under data.data alongside metadata.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nested
+		}
+	}
+	return selectSecretField(data, ref.field)
+}
+
+func (r *secretResolver) resolveAWS(ctx context.Context, ref secretRef) (string, error) {
+	if r.awsRegion == "" {
+		return "", errors.New("aws region is required (secrets.aws.region or AWS_REGION)")
+	}
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": ref.path})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.awsEndpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, body, creds, r.awsRegion, "secretsmanager", time.Now())
+
+	var response struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := r.doJSON(req, &response); err != nil {
+		return "", fmt.Errorf("aws secrets manager: %v", err)
+	}
+	if ref.field == "" {
+		return response.SecretString, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(response.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object", ref.path)
+	}
+	return selectSecretField(fields, ref.field)
+}
+
+func (r *secretResolver) doJSON(req *http.Request, v interface{}) error {
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		// Error bodies from both providers describe the failure without echoing secrets.
+		return fmt.Errorf("status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+func selectSecretField(data map[string]interface{}, field string) (string, error) {
+	if field == "" {
+		if len(data) != 1 {
+			return "", errors.New("secret has several fields; select one with #field")
+		}
+		for _, value := range data {
+			if s, ok := value.(string); ok {
+				return s, nil
+			}
+		}
+		return "", errors.New("secret field is not a string")
+	}
+
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("secret field %q is not a string", field)
+	}
+	return s, nil
+}
+
+// collectSecretRefs finds every string config value that is a secret
+// reference. The secrets section itself is never resolved.
+func collectSecretRefs(config *Config) []secretBinding {
+	var bindings []secretBinding
+	identity := func(root reflect.Value) reflect.Value { return root }
+	walkSecretRefs(reflect.ValueOf(config).Elem(), "", identity, &bindings)
+	return bindings
+}
+
+func walkSecretRefs(value reflect.Value, name string, locate func(reflect.Value) reflect.Value, bindings *[]secretBinding) {
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.Name == "Secrets" && value.Type() == reflect.TypeOf(Config{}) {
+				continue
+			}
+			index := i
+			walkSecretRefs(value.Field(i), joinConfigName(name, field), func(root reflect.Value) reflect.Value {
+				return locate(root).Field(index)
+			}, bindings)
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			index := i
+			walkSecretRefs(value.Index(i), fmt.Sprintf("%s[%d]", name, i), func(root reflect.Value) reflect.Value {
+				return locate(root).Index(index)
+			}, bindings)
+		}
+	case reflect.String:
+		if ref, ok := parseSecretRef(value.String()); ok {
+			*bindings = append(*bindings, secretBinding{name: name, ref: ref, locate: locate})
+		}
+	}
+}
+
+func joinConfigName(prefix string, field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// resolveSecrets replaces every secret reference in config with its value.
+func resolveSecrets(ctx context.Context, config *Config, resolver *secretResolver, bindings []secretBinding) error {
+	root := reflect.ValueOf(config).Elem()
+	for _, binding := range bindings {
+		value, err := resolver.resolve(ctx, binding.ref)
+		if err != nil {
+			return fmt.Errorf("%s (%s): %v", binding.name, binding.ref, err)
+		}
+		binding.locate(root).SetString(value)
+	}
+	return nil
+}
+
+// secretRefs holds what LoadConfig resolved so main can start the refresher.
+var secretRefs struct {
+	resolver *secretResolver
+	bindings []secretBinding
+}
+
+// loadSecretRefs resolves secret references during LoadConfig and returns
+// the bindings for periodic refresh.
+func loadSecretRefs(config *Config) (*secretResolver, []secretBinding, error) {
+	bindings := collectSecretRefs(config)
+	if len(bindings) == 0 {
+		return nil, nil, nil
+	}
+
+	resolver, err := newSecretResolver(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Secrets.Timeout*time.Duration(len(bindings)))
+	defer cancel()
+	if err := resolveSecrets(ctx, config, resolver, bindings); err != nil {
+		return nil, nil, err
+	}
+	slog.Info("Resolved secret references", "secrets", len(bindings))
+	return resolver, bindings, nil
+}
+
+// runSecretRefresh re-resolves secret references every interval. When a value
+// changes, it publishes a copy of AppConfig carrying the new values with
+// setAppConfig, so requests that start afterwards use them and requests
+// already running keep the config they read.
+func runSecretRefresh(resolver *secretResolver, bindings []secretBinding, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			refreshSecrets(resolver, bindings, interval)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func refreshSecrets(resolver *secretResolver, bindings []secretBinding, timeout time.Duration) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	current := AppConfig()
+	next := *current
+	root := reflect.ValueOf(&next).Elem()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var changed []string
+	for _, binding := range bindings {
+		value, err := resolver.resolve(ctx, binding.ref)
+		if err != nil {
+			slog.Error("Failed to refresh a secret", "secret", binding.name, "error", err)
+			appMetrics.Count("secrets.refresh_failures", 1)
+			continue
+		}
+		field := binding.locate(root)
+		if field.String() != value {
+			field.SetString(value)
+			changed = append(changed, binding.name)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	setAppConfig(&next)
+	slog.Info("Refreshed secrets", "changed", changed)
+	recordAudit(auditEvent{Action: "secrets.refreshed", Subject: "config", Details: map[string]string{"fields": strings.Join(changed, ",")}})
+}
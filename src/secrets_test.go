@@ -0,0 +1,170 @@
+// secrets_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected secretRef
+		ok       bool
+	}{
+		{"vault://secret/data/app#api_key", secretRef{"vault", "secret/data/app", "api_key"}, true},
+		{"awssm://prod/app", secretRef{"awssm", "prod/app", ""}, true},
+		{"plain-value", secretRef{}, false},
+		{"https://backend", secretRef{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			ref, ok := parseSecretRef(tc.value)
+			if ok != tc.ok || ref != tc.expected {
+				t.Fatalf("parseSecretRef(%q) = %+v, %v; want %+v, %v", tc.value, ref, ok, tc.expected, tc.ok)
+			}
+		})
+	}
+}
+
+func newFakeVault(t *testing.T, value *atomic.Value) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/notification-server" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"api_key": value.Load().(string), "other": "x"},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLoadConfig_ResolvesVaultReferences(t *testing.T) {
+	var secret atomic.Value
+	secret.Store("from-vault")
+	vault := newFakeVault(t, &secret)
+
+	configFile, cleanup := createTempConfigFile(t, `
+security:
+  api_key: "vault://secret/data/notification-server#api_key"
+backend:
+  url: "http://localhost:8000"
+secrets:
+  vault:
+    address: "`+vault.URL+`"
+    token: "vault-token"
+`)
+	defer cleanup()
+
+	if err := LoadConfig(configFile); err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if AppConfig().Security.APIKey != "from-vault" {
+		t.Fatalf("expected API key from vault, got %q", AppConfig().Security.APIKey)
+	}
+	if len(secretRefs.bindings) != 1 || secretRefs.bindings[0].name != "security.api_key" {
+		t.Fatalf("expected one binding for security.api_key, got %+v", secretRefs.bindings)
+	}
+
+	// Requests keep reading the config during the refresh, and the one they
+	// hold is never changed underneath them.
+	running := AppConfig()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = AppConfig().Security.APIKey
+			}
+		}
+	}()
+	secret.Store("rotated")
+	refreshSecrets(secretRefs.resolver, secretRefs.bindings, time.Second)
+	close(stop)
+	<-done
+	if AppConfig().Security.APIKey != "rotated" || running.Security.APIKey != "from-vault" {
+		t.Fatalf("expected a refreshed copy of the config, got %q and %q", AppConfig().Security.APIKey, running.Security.APIKey)
+	}
+}
+
+func TestLoadConfig_UnresolvableSecretFails(t *testing.T) {
+	var secret atomic.Value
+	secret.Store("unused")
+	vault := newFakeVault(t, &secret)
+
+	configFile, cleanup := createTempConfigFile(t, `
+security:
+  api_key: "vault://secret/data/notification-server#missing"
+backend:
+  url: "http://localhost:8000"
+secrets:
+  vault:
+    address: "`+vault.URL+`"
+    token: "vault-token"
+`)
+	defer cleanup()
+
+	err := LoadConfig(configFile)
+	if err == nil || !strings.Contains(err.Error(), "security.api_key") {
+		t.Fatalf("expected a resolution error naming the field, got %v", err)
+	}
+}
+
+func TestSecretResolver_AWSSecretsManager(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+			t.Errorf("unexpected target %q", r.Header.Get("X-Amz-Target"))
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			t.Errorf("expected a SigV4 Authorization header, got %q", r.Header.Get("Authorization"))
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["SecretId"] != "prod/notification-server" {
+			t.Errorf("unexpected SecretId %q", body["SecretId"])
+		}
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"api_key":"from-aws"}`})
+	}))
+	defer server.Close()
+
+	config := &Config{}
+	setDefaults(config)
+	config.Secrets.AWS.Region = "eu-west-1"
+	config.Secrets.AWS.Endpoint = server.URL
+	config.Security.APIKey = "awssm://prod/notification-server#api_key"
+
+	resolver, bindings, err := loadSecretRefs(config)
+	if err != nil {
+		t.Fatalf("loadSecretRefs failed: %v", err)
+	}
+	if resolver == nil || len(bindings) != 1 {
+		t.Fatalf("expected one binding, got %d", len(bindings))
+	}
+	if config.Security.APIKey != "from-aws" {
+		t.Fatalf("expected API key from AWS, got %q", config.Security.APIKey)
+	}
+}
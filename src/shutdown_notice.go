@@ -0,0 +1,148 @@
+// shutdown_notice.go
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShutdownNotice tells a client the server is going away, so its UI can say
+// why and for how long instead of showing a generic disconnect.
+type ShutdownNotice struct {
+	Type                     string `json:"type"`
+	Reason                   string `json:"reason"`
+	EstimatedDowntimeSeconds int    `json:"estimatedDowntimeSeconds,omitempty"`
+}
+
+// shutdownPlan holds the notice sent on shutdown. It comes from the shutdown
+// section of the config unless an operator has set one with
+// PUT /admin/shutdown.
+type shutdownPlan struct {
+	mu       sync.Mutex
+	set      bool
+	reason   string
+	downtime time.Duration
+}
+
+var plannedShutdown = &shutdownPlan{}
+
+// notice returns the frame to send, from the operator's input if there is
+// any and the config otherwise.
+func (p *shutdownPlan) notice() ShutdownNotice {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reason, downtime := AppConfig().Shutdown.Reason, AppConfig().Shutdown.EstimatedDowntime
+	if p.set {
+		reason, downtime = p.reason, p.downtime
+	}
+	return ShutdownNotice{
+		Type:                     "shutdown",
+		Reason:                   reason,
+		EstimatedDowntimeSeconds: int((downtime + time.Second - 1) / time.Second),
+	}
+}
+
+func (p *shutdownPlan) update(reason string, downtime time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.set, p.reason, p.downtime = true, reason, downtime
+}
+
+func (p *shutdownPlan) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.set, p.reason, p.downtime = false, "", 0
+}
+
+// announceShutdown queues notice on the control channel of every connected
+// client and returns them. Clients being handed over are left out: they are
+// reconnecting elsewhere, not waiting for this instance.
+func (h *Hub) announceShutdown(notice ShutdownNotice) []*Client {
+	payload, err := json.Marshal(notice)
+	if err != nil {
+		slog.Error("Failed to encode the shutdown notice", "error", err)
+		return nil
+	}
+	notified := make([]*Client, 0)
+	for _, client := range h.snapshotAllClients() {
+		if h.handingOver(client) {
+			continue
+		}
+		if h.enqueueControl(client, outboundMessage{payload: payload}) {
+			notified = append(notified, client)
+		}
+	}
+	appMetrics.Count("shutdown.notified", int64(len(notified)))
+	return notified
+}
+
+// awaitControlFlush waits until the writePumps of clients have taken every
+// queued control frame, or until grace has passed.
+func awaitControlFlush(clients []*Client, grace time.Duration) {
+	deadline := time.Now().Add(grace)
+	for _, client := range clients {
+		for len(client.control) > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// notifyShutdown tells connected clients about the shutdown and gives their
+// writePumps shutdown.notice_grace to send it.
+func notifyShutdown(hub *Hub) {
+	if AppConfig().Shutdown.NoticeGrace <= 0 {
+		return
+	}
+	notice := plannedShutdown.notice()
+	notified := hub.announceShutdown(notice)
+	awaitControlFlush(notified, AppConfig().Shutdown.NoticeGrace)
+	slog.Info("Sent the shutdown notice", "clients", len(notified), "reason", notice.Reason)
+}
+
+type shutdownRequest struct {
+	Reason                   string `json:"reason"`
+	EstimatedDowntimeSeconds int    `json:"estimatedDowntimeSeconds"`
+}
+
+// handleAdminShutdown shows the notice clients will get on shutdown with GET,
+// sets it with PUT and restores the configured one with DELETE.
+func handleAdminShutdown(plan *shutdownPlan, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// The notice is written below.
+
+	case http.MethodPut:
+		var req shutdownRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if req.Reason == "" {
+			http.Error(w, "reason is required", http.StatusBadRequest)
+			return
+		}
+		if req.EstimatedDowntimeSeconds < 0 {
+			http.Error(w, "estimatedDowntimeSeconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		plan.update(req.Reason, time.Duration(req.EstimatedDowntimeSeconds)*time.Second)
+		recordAudit(auditEvent{Action: "shutdown.notice", Subject: req.Reason, Details: map[string]string{
+			"estimatedDowntimeSeconds": strconv.Itoa(req.EstimatedDowntimeSeconds),
+		}})
+
+	case http.MethodDelete:
+		plan.reset()
+		recordAudit(auditEvent{Action: "shutdown.notice.reset"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan.notice())
+}
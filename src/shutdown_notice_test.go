@@ -0,0 +1,72 @@
+// shutdown_notice_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnounceShutdown_SkipsMigratingClients(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Shutdown.EstimatedDowntime = 90 * time.Second
+	hub := newHub()
+	alice := &Client{hub: hub, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 1), control: make(chan outboundMessage, 1)}
+	bob := &Client{hub: hub, teamID: "team-2", userID: "bob", send: make(chan outboundMessage, 1), control: make(chan outboundMessage, 1)}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {alice: {}}}
+	hub.clients["team-2"] = map[string]map[*Client]struct{}{"bob": {bob: {}}}
+	hub.handover.Store(&handover{expiresAt: time.Now().Add(time.Minute)})
+	bob.migrating.Store(true)
+
+	notified := hub.announceShutdown(plannedShutdown.notice())
+	if len(notified) != 1 || notified[0] != alice {
+		t.Fatalf("expected only alice to be notified, got %d clients", len(notified))
+	}
+	var notice ShutdownNotice
+	if err := json.Unmarshal((<-alice.control).payload, &notice); err != nil {
+		t.Fatal(err)
+	}
+	want := ShutdownNotice{Type: "shutdown", Reason: "The server is restarting", EstimatedDowntimeSeconds: 90}
+	if notice != want {
+		t.Fatalf("expected %+v, got %+v", want, notice)
+	}
+
+	// The wait ends at the grace period when a writePump does not drain.
+	alice.control <- outboundMessage{}
+	start := time.Now()
+	awaitControlFlush(notified, 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected to wait out the grace period, waited %v", elapsed)
+	}
+}
+
+func TestHandleAdminShutdown(t *testing.T) {
+	setupTestAppConfig()
+	plan := &shutdownPlan{}
+
+	rr := httptest.NewRecorder()
+	handleAdminShutdown(plan, rr, httptest.NewRequest(http.MethodPut, "/admin/shutdown", strings.NewReader(`{"reason":"Database upgrade","estimatedDowntimeSeconds":600}`)))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"reason":"Database upgrade","estimatedDowntimeSeconds":600`) {
+		t.Fatalf("unexpected response: %d %s", rr.Code, rr.Body.String())
+	}
+	if notice := plan.notice(); notice.Reason != "Database upgrade" {
+		t.Fatalf("expected the operator's reason, got %q", notice.Reason)
+	}
+
+	for _, body := range []string{`{"reason":" "}`, `{"reason":"x","estimatedDowntimeSeconds":-1}`} {
+		rr = httptest.NewRecorder()
+		handleAdminShutdown(plan, rr, httptest.NewRequest(http.MethodPut, "/admin/shutdown", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminShutdown(plan, rr, httptest.NewRequest(http.MethodDelete, "/admin/shutdown", nil))
+	if notice := plan.notice(); rr.Code != http.StatusOK || notice.Reason != AppConfig().Shutdown.Reason || notice.EstimatedDowntimeSeconds != 0 {
+		t.Fatalf("expected the configured notice after DELETE, got %d %+v", rr.Code, notice)
+	}
+}
@@ -0,0 +1,228 @@
+// slo.go
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sloBuckets is how many minutes of outcomes are kept, enough for the
+// longest window.
+const sloBuckets = 24 * 60
+
+// sloWindows are the rolling windows /admin/slo reports.
+var sloWindows = []time.Duration{5 * time.Minute, time.Hour, 24 * time.Hour}
+
+func sloWindowAllowed(d time.Duration) bool {
+	for _, window := range sloWindows {
+		if d == window {
+			return true
+		}
+	}
+	return false
+}
+
+// sloBucket holds the outcomes of one minute.
+type sloBucket struct {
+	minute        int64 // Unix minute the counts belong to
+	delivered     uint64
+	dropped       uint64
+	authSucceeded uint64
+	authFailed    uint64
+	latency       *latencyHistogram
+}
+
+// sloTracker counts delivery and authentication outcomes per minute over the
+// last day, so success ratios and latency can be read over rolling windows.
+type sloTracker struct {
+	mu      sync.Mutex
+	buckets [sloBuckets]sloBucket
+}
+
+var serviceLevels = newSLOTracker()
+
+func newSLOTracker() *sloTracker {
+	return &sloTracker{}
+}
+
+// bucketLocked returns the bucket for now, clearing it if it last held an
+// older minute.
+func (s *sloTracker) bucketLocked(now time.Time) *sloBucket {
+	minute := now.Unix() / 60
+	bucket := &s.buckets[minute%sloBuckets]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+	return bucket
+}
+
+// delivered records a notification written to its socket, and how long it
+// took unless it was never timestamped.
+func (s *sloTracker) delivered(receivedAt, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket := s.bucketLocked(now)
+	bucket.delivered++
+	if !receivedAt.IsZero() {
+		if bucket.latency == nil {
+			bucket.latency = newLatencyHistogram()
+		}
+		bucket.latency.observe(float64(now.Sub(receivedAt)) / float64(time.Millisecond))
+	}
+}
+
+// dropped records a notification that could not be queued for a connection.
+func (s *sloTracker) dropped(now time.Time) {
+	s.mu.Lock()
+	s.bucketLocked(now).dropped++
+	s.mu.Unlock()
+}
+
+// authenticated records the outcome of a websocket authentication.
+func (s *sloTracker) authenticated(ok bool, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok {
+		s.bucketLocked(now).authSucceeded++
+	} else {
+		s.bucketLocked(now).authFailed++
+	}
+}
+
+// sloWindow is what happened over one rolling window. Ratios are 1 when
+// nothing happened: nothing failed.
+type sloWindow struct {
+	Window           string  `json:"window"`
+	Deliveries       uint64  `json:"deliveries"`
+	DeliveryFailures uint64  `json:"deliveryFailures"`
+	DeliverySuccess  float64 `json:"deliverySuccess"`
+	AuthAttempts     uint64  `json:"authAttempts"`
+	AuthFailures     uint64  `json:"authFailures"`
+	AuthSuccess      float64 `json:"authSuccess"`
+	P99LatencyMs     float64 `json:"p99LatencyMs"`
+}
+
+func successRatio(failures, total uint64) float64 {
+	if total == 0 {
+		return 1
+	}
+	return float64(total-failures) / float64(total)
+}
+
+// window sums the minutes within d before now, the current one included.
+func (s *sloTracker) window(d time.Duration, now time.Time) sloWindow {
+	newest := now.Unix() / 60
+	oldest := newest - int64(d/time.Minute) + 1
+	latency := newLatencyHistogram()
+	var delivered, dropped, authSucceeded, authFailed uint64
+
+	s.mu.Lock()
+	for i := range s.buckets {
+		bucket := &s.buckets[i]
+		if bucket.minute < oldest || bucket.minute > newest {
+			continue
+		}
+		delivered += bucket.delivered
+		dropped += bucket.dropped
+		authSucceeded += bucket.authSucceeded
+		authFailed += bucket.authFailed
+		if bucket.latency != nil {
+			for j, count := range bucket.latency.counts {
+				latency.counts[j] += count
+			}
+			latency.total += bucket.latency.total
+			if bucket.latency.maxMs > latency.maxMs {
+				latency.maxMs = bucket.latency.maxMs
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	return sloWindow{
+		Window:           d.String(),
+		Deliveries:       delivered + dropped,
+		DeliveryFailures: dropped,
+		DeliverySuccess:  successRatio(dropped, delivered+dropped),
+		AuthAttempts:     authSucceeded + authFailed,
+		AuthFailures:     authFailed,
+		AuthSuccess:      successRatio(authFailed, authSucceeded+authFailed),
+		P99LatencyMs:     latency.quantile(0.99),
+	}
+}
+
+// sloObjective is one configured objective and how the evaluated window
+// measures up to it.
+type sloObjective struct {
+	Name   string  `json:"name"`
+	Target float64 `json:"target"`
+	Actual float64 `json:"actual"`
+	Events uint64  `json:"events"`
+	Met    bool    `json:"met"`
+}
+
+// judgeObjectives judges the configured objectives against window w. An
+// objective with fewer than slo.min_events events in the window is met, so a
+// handful of failures on a quiet instance do not mark it degraded.
+func judgeObjectives(w sloWindow) []sloObjective {
+	config := AppConfig().SLO
+	result := []sloObjective{}
+	judge := func(name string, target, actual float64, events uint64, met bool) {
+		result = append(result, sloObjective{
+			Name:   name,
+			Target: target,
+			Actual: actual,
+			Events: events,
+			Met:    met || events < uint64(config.MinEvents),
+		})
+	}
+	if config.DeliverySuccess > 0 {
+		judge("deliverySuccess", config.DeliverySuccess, w.DeliverySuccess, w.Deliveries, w.DeliverySuccess >= config.DeliverySuccess)
+	}
+	if config.AuthSuccess > 0 {
+		judge("authSuccess", config.AuthSuccess, w.AuthSuccess, w.AuthAttempts, w.AuthSuccess >= config.AuthSuccess)
+	}
+	if config.P99Latency > 0 {
+		target := float64(config.P99Latency) / float64(time.Millisecond)
+		judge("p99LatencyMs", target, w.P99LatencyMs, w.Deliveries-w.DeliveryFailures, w.P99LatencyMs <= target)
+	}
+	return result
+}
+
+// breached returns the names of the objectives missed over slo.window.
+func (s *sloTracker) breached(now time.Time) []string {
+	var names []string
+	for _, objective := range judgeObjectives(s.window(AppConfig().SLO.Window, now)) {
+		if !objective.Met {
+			names = append(names, objective.Name)
+		}
+	}
+	return names
+}
+
+// handleAdminSLO serves GET /admin/slo: delivery success, auth success and
+// p99 delivery latency over the last 5 minutes, hour and day, and the
+// configured objectives judged over slo.window.
+func handleAdminSLO(tracker *sloTracker, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	windows := make([]sloWindow, 0, len(sloWindows))
+	for _, d := range sloWindows {
+		windows = append(windows, tracker.window(d, now))
+	}
+	judged := judgeObjectives(tracker.window(AppConfig().SLO.Window, now))
+	degraded := false
+	for _, objective := range judged {
+		degraded = degraded || !objective.Met
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"windows":    windows,
+		"window":     AppConfig().SLO.Window.String(),
+		"objectives": judged,
+		"degraded":   degraded,
+	})
+}
@@ -0,0 +1,107 @@
+// slo_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSLOTracker_Windows(t *testing.T) {
+	tracker := newSLOTracker()
+	now := time.Date(2025, 1, 10, 12, 0, 30, 0, time.UTC)
+
+	// Two hours ago: only the day sees it.
+	for i := 0; i < 10; i++ {
+		tracker.authenticated(false, now.Add(-2*time.Hour))
+	}
+	// Half an hour ago: the hour and the day see it.
+	tracker.dropped(now.Add(-30 * time.Minute))
+	// Within the last five minutes.
+	for i := 0; i < 3; i++ {
+		tracker.delivered(now.Add(-time.Minute-20*time.Millisecond), now.Add(-time.Minute))
+		tracker.authenticated(true, now)
+	}
+	tracker.delivered(time.Time{}, now) // not timestamped: no latency
+
+	short := tracker.window(5*time.Minute, now)
+	if short.Deliveries != 4 || short.DeliveryFailures != 0 || short.DeliverySuccess != 1 || short.AuthSuccess != 1 {
+		t.Errorf("unexpected 5m window: %+v", short)
+	}
+	if short.P99LatencyMs <= 10 || short.P99LatencyMs > 25 {
+		t.Errorf("expected a p99 in the 25ms bucket, got %v", short.P99LatencyMs)
+	}
+	hour := tracker.window(time.Hour, now)
+	if hour.Deliveries != 5 || hour.DeliveryFailures != 1 || hour.DeliverySuccess != 0.8 {
+		t.Errorf("unexpected 1h window: %+v", hour)
+	}
+	day := tracker.window(24*time.Hour, now)
+	if day.AuthAttempts != 13 || day.AuthFailures != 10 {
+		t.Errorf("unexpected 24h window: %+v", day)
+	}
+
+	// A day later the ring has moved past every bucket.
+	if later := tracker.window(24*time.Hour, now.Add(25*time.Hour)); later.Deliveries != 0 || later.AuthAttempts != 0 || later.AuthSuccess != 1 {
+		t.Errorf("expected an empty window a day later, got %+v", later)
+	}
+	tracker.authenticated(true, now.Add(24*time.Hour)) // reuses the current minute's bucket
+	if reused := tracker.window(5*time.Minute, now.Add(24*time.Hour)); reused.AuthAttempts != 1 || reused.Deliveries != 0 {
+		t.Errorf("expected the reused bucket to be cleared, got %+v", reused)
+	}
+}
+
+func TestSLOTracker_Breached(t *testing.T) {
+	setupTestAppConfig()
+	tracker := newSLOTracker()
+	now := time.Now()
+
+	if breached := tracker.breached(now); len(breached) != 0 {
+		t.Fatalf("expected no objectives without configuration, got %v", breached)
+	}
+
+	AppConfig().SLO.AuthSuccess = 0.9
+	AppConfig().SLO.P99Latency = 50 * time.Millisecond
+	AppConfig().SLO.MinEvents = 5
+	for i := 0; i < 4; i++ {
+		tracker.authenticated(false, now)
+	}
+	if breached := tracker.breached(now); len(breached) != 0 {
+		t.Fatalf("expected too few events to be judged, got %v", breached)
+	}
+	tracker.authenticated(false, now)
+	for i := 0; i < 5; i++ {
+		tracker.delivered(now.Add(-10*time.Millisecond), now)
+	}
+	if breached := tracker.breached(now); len(breached) != 1 || breached[0] != "authSuccess" {
+		t.Fatalf("expected authSuccess to be breached, got %v", breached)
+	}
+}
+
+func TestHandleAdminSLO(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().SLO.DeliverySuccess = 0.99
+	AppConfig().SLO.MinEvents = 1
+	tracker := newSLOTracker()
+	tracker.delivered(time.Now(), time.Now())
+	tracker.dropped(time.Now())
+
+	rr := httptest.NewRecorder()
+	handleAdminSLO(tracker, rr, httptest.NewRequest("GET", "/admin/slo", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Windows    []sloWindow    `json:"windows"`
+		Objectives []sloObjective `json:"objectives"`
+		Degraded   bool           `json:"degraded"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Windows) != 3 || response.Windows[0].Window != "5m0s" || response.Windows[0].DeliverySuccess != 0.5 {
+		t.Errorf("unexpected windows: %+v", response.Windows)
+	}
+	if len(response.Objectives) != 1 || response.Objectives[0].Met || !response.Degraded {
+		t.Errorf("expected the missed delivery objective to degrade the instance, got %+v", response)
+	}
+}
@@ -0,0 +1,309 @@
+// snapshot.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// hubSnapshotVersion is bumped whenever the snapshot format changes
+// incompatibly; other versions are ignored on start.
+const hubSnapshotVersion = 1
+
+// hubSnapshot is the in-memory state written to snapshot.path on graceful
+// shutdown and restored on the next start, so a short restart of a single
+// node does not lose queued messages. Every list is in a fixed order, so the
+// same state always produces the same file.
+type hubSnapshot struct {
+	Version   int                  `json:"version"`
+	TakenAt   time.Time            `json:"takenAt"`
+	Roster    []rosterEntry        `json:"roster"`
+	Blackouts []blackoutWindow     `json:"blackouts"`
+	Deferred  []deferredSnapshot   `json:"deferred"`
+	OnCall    []onCallShift        `json:"onCall,omitempty"`
+	Recalls   []recallSnapshot     `json:"recalls"`
+	Audit     []auditEvent         `json:"audit"` // oldest first
+	Sends     []auditEvent         `json:"sends,omitempty"`
+	Store     *memoryStoreSnapshot `json:"store,omitempty"` // only with the memory storage driver
+}
+
+// rosterEntry is a user who was connected when the snapshot was taken.
+type rosterEntry struct {
+	TeamID      string `json:"teamId"` // hub key
+	UserID      string `json:"userId"`
+	Connections int    `json:"connections"`
+}
+
+// deferredSnapshot is a broadcast held back by a blackout window.
+type deferredSnapshot struct {
+	TeamID         string           `json:"teamId"` // hub key
+	TenantID       string           `json:"tenantId,omitempty"`
+	MessageType    string           `json:"messageType"`
+	NotificationID string           `json:"notificationId,omitempty"`
+	Body           string           `json:"body"`
+	Payload        json.RawMessage  `json:"payload"`
+	Visibility     []VisibilityRule `json:"visibility,omitempty"`
+	DeferredAt     time.Time        `json:"deferredAt,omitempty"`
+}
+
+func newDeferredSnapshot(teamID string, message outboundMessage) deferredSnapshot {
+	saved := deferredSnapshot{
+		TeamID:         teamID,
+		TenantID:       message.tenantID,
+		MessageType:    message.messageType,
+		NotificationID: message.notificationID,
+		Payload:        json.RawMessage(message.payload),
+		Visibility:     message.visibility.spec(),
+		DeferredAt:     message.deferredAt,
+	}
+	if message.fanout != nil {
+		saved.Body = message.fanout.body
+	}
+	return saved
+}
+
+// message rebuilds the queued message, with fresh attachment links.
+func (d deferredSnapshot) message() (outboundMessage, error) {
+	var decoded Message
+	if err := json.Unmarshal(d.Payload, &decoded); err != nil {
+		return outboundMessage{}, err
+	}
+	visibility, err := compileVisibility(d.Visibility)
+	if err != nil {
+		return outboundMessage{}, err
+	}
+	return outboundMessage{
+		payload:        []byte(d.Payload),
+		tenantID:       d.TenantID,
+		teamID:         d.TeamID,
+		messageType:    d.MessageType,
+		notificationID: d.NotificationID,
+		fanout:         newFanoutCache(d.Body),
+		links:          newAttachmentLinks(attachmentPresigner, decoded),
+		visibility:     visibility,
+		deferredAt:     d.DeferredAt,
+		channel:        decoded.Channel,
+		urgency:        decoded.Urgency,
+	}, nil
+}
+
+// recallSnapshot is a recall ledger entry.
+type recallSnapshot struct {
+	TenantID       string           `json:"tenantId,omitempty"`
+	NotificationID string           `json:"notificationId"`
+	TeamID         string           `json:"teamId,omitempty"`
+	UserID         string           `json:"userId,omitempty"`
+	Broadcast      bool             `json:"broadcast"`
+	Channel        string           `json:"channel,omitempty"`
+	Visibility     []VisibilityRule `json:"visibility,omitempty"`
+	SentAt         time.Time        `json:"sentAt"`
+	RevokedAt      time.Time        `json:"revokedAt"`
+	ReplacedBy     string           `json:"replacedBy,omitempty"`
+}
+
+// memoryStoreSnapshot holds the records of the memory storage driver.
+type memoryStoreSnapshot struct {
+	Notifications  []*StoredNotification `json:"notifications"`
+	Outbox         []*OutboxJob          `json:"outbox"`
+	Schedules      []*ScheduledBroadcast `json:"schedules"`
+	TeamWebhooks   []*TeamWebhook        `json:"teamWebhooks,omitempty"`
+	Rollups        []*PresenceRollup     `json:"rollups,omitempty"`
+	MessageRollups []*MessageRollup      `json:"messageRollups,omitempty"`
+	Archive        []*ArchivedMessage    `json:"archive,omitempty"`
+	Preferences    []*UserPreferences    `json:"preferences,omitempty"`
+}
+
+// snapshotMemoryStore returns the memory store behind store, or nil if store
+// keeps its records elsewhere.
+func snapshotMemoryStore(store Store) *memoryStore {
+	if encrypted, ok := store.(*encryptedStore); ok {
+		store = encrypted.Store
+	}
+	memory, _ := store.(*memoryStore)
+	return memory
+}
+
+// takeHubSnapshot captures the hub's restorable state at now.
+func takeHubSnapshot(hub *Hub, now time.Time) *hubSnapshot {
+	snapshot := &hubSnapshot{
+		Version: hubSnapshotVersion,
+		TakenAt: now.UTC(),
+		Roster:  hub.roster(),
+		Recalls: notificationRecalls.snapshot(),
+		Audit:   recentAudit.chronological(),
+	}
+	snapshot.Blackouts, snapshot.Deferred = teamBlackouts.snapshot()
+	snapshot.OnCall = onCallSchedules.snapshot(now)
+	if recentSends != nil {
+		snapshot.Sends = recentSends.chronological()
+	}
+	if memory := snapshotMemoryStore(notificationStore); memory != nil {
+		snapshot.Store = memory.dump()
+	}
+	return snapshot
+}
+
+// writeHubSnapshot writes snapshot to path through a temporary file, so a
+// crash while writing never leaves a truncated snapshot behind. The file
+// holds notification bodies and is only readable by the owner.
+func writeHubSnapshot(path string, snapshot *hubSnapshot) error {
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadHubSnapshot reads the snapshot at path and removes the file, so a
+// snapshot is restored at most once. It returns nil without an error when
+// there is no snapshot, and an error for one that is unreadable, of another
+// version or older than maxAge.
+func loadHubSnapshot(path string, now time.Time, maxAge time.Duration) (*hubSnapshot, error) {
+	encoded, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot hubSnapshot
+	if err := json.Unmarshal(encoded, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	if snapshot.Version != hubSnapshotVersion {
+		return nil, fmt.Errorf("snapshot version %d is not supported", snapshot.Version)
+	}
+	if age := now.Sub(snapshot.TakenAt); age > maxAge {
+		return nil, fmt.Errorf("snapshot taken %s ago is older than snapshot.max_age", age.Round(time.Second))
+	}
+	return &snapshot, nil
+}
+
+// restoreStore loads the memory store's records. It runs before anything
+// reads the store, so restored webhooks and schedules are picked up as if
+// they had been stored all along.
+func (s *hubSnapshot) restoreStore(store Store) {
+	memory := snapshotMemoryStore(store)
+	if s.Store == nil || memory == nil {
+		return
+	}
+	memory.load(s.Store)
+	slog.Info("Restored the store from the snapshot",
+		"notifications", len(s.Store.Notifications), "webhooks", len(s.Store.Outbox), "schedules", len(s.Store.Schedules))
+}
+
+// restoreHub restores everything else. Users on the roster are awaited until
+// reconnectUntil.
+func (s *hubSnapshot) restoreHub(hub *Hub, reconnectUntil time.Time) {
+	hub.awaitRoster(s.Roster, reconnectUntil)
+	teamBlackouts.restore(s.Blackouts, s.Deferred)
+	onCallSchedules.restore(s.OnCall)
+	notificationRecalls.restore(s.Recalls)
+	for _, event := range s.Audit {
+		recentAudit.add(event)
+	}
+	if recentSends != nil {
+		for _, event := range s.Sends {
+			recentSends.add(event)
+		}
+	}
+	slog.Info("Restored the snapshot",
+		"taken_at", s.TakenAt, "awaited", len(s.Roster), "deferred", len(s.Deferred), "recallable", len(s.Recalls))
+}
+
+// roster lists the connected users, ordered by team and user.
+func (h *Hub) roster() []rosterEntry {
+	h.mu.RLock()
+	entries := make([]rosterEntry, 0)
+	for teamID, teamClients := range h.clients {
+		for userID, userClients := range teamClients {
+			entries = append(entries, rosterEntry{TeamID: teamID, UserID: userID, Connections: len(userClients)})
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].TeamID != entries[j].TeamID {
+			return entries[i].TeamID < entries[j].TeamID
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	return entries
+}
+
+// awaitRoster remembers the users connected before a restart until until.
+// Their reconnects are counted as resumed sessions.
+func (h *Hub) awaitRoster(entries []rosterEntry, until time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.returning = make(map[string]map[string]struct{})
+	h.returningUntil = until
+	for _, entry := range entries {
+		if h.returning[entry.TeamID] == nil {
+			h.returning[entry.TeamID] = make(map[string]struct{})
+		}
+		h.returning[entry.TeamID][entry.UserID] = struct{}{}
+	}
+}
+
+// resumeLocked records that client's user, if it was awaited, is back.
+func (h *Hub) resumeLocked(client *Client) {
+	users, ok := h.returning[client.teamID]
+	if !ok {
+		return
+	}
+	if _, ok := users[client.userID]; !ok {
+		return
+	}
+	delete(users, client.userID)
+	if len(users) == 0 {
+		delete(h.returning, client.teamID)
+	}
+	if time.Now().Before(h.returningUntil) {
+		appMetrics.Count("connections.resumed", 1, tenantTags(client.tenantID)...)
+	}
+}
+
+// awaitingReconnect counts restored roster users that have not reconnected.
+func (h *Hub) awaitingReconnect(now time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !now.Before(h.returningUntil) {
+		h.returning = nil
+		return 0
+	}
+	awaiting := 0
+	for _, users := range h.returning {
+		awaiting += len(users)
+	}
+	return awaiting
+}
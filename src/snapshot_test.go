@@ -0,0 +1,149 @@
+// snapshot_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHubSnapshot_RoundTrip(t *testing.T) {
+	setupTestAppConfig()
+	defer func() { teamBlackouts, notificationRecalls, notificationStore = nil, nil, newMemoryStore() }()
+	now := time.Now()
+
+	// State before the restart.
+	hub := newHub()
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{
+		"bob":   {{teamID: "team-1", userID: "bob"}: {}},
+		"alice": {{teamID: "team-1", userID: "alice"}: {}, {teamID: "team-1", userID: "alice"}: {}},
+	}
+
+	teamBlackouts = newBlackoutSchedule(nil, 10)
+	window, err := teamBlackouts.add(blackoutWindow{TeamID: "team-1", Start: now.Add(-time.Minute), End: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	visibility, _ := compileVisibility([]VisibilityRule{{Attribute: "role", NotIn: []string{"guest", "bot"}}})
+	deferred := outboundMessage{
+		payload:        []byte(`{"notificationId":"n1","body":"{\"severity\":\"low\"}"}`),
+		tenantID:       "",
+		teamID:         "team-1",
+		messageType:    "build",
+		notificationID: "n1",
+		fanout:         newFanoutCache(`{"severity":"low"}`),
+		visibility:     visibility,
+	}
+	if !teamBlackouts.deferBroadcast("team-1", deferred, now) {
+		t.Fatal("expected the broadcast to be deferred")
+	}
+
+	notificationRecalls = newRecallLedger(time.Hour, 100)
+	notificationRecalls.recordSent(outboundMessage{teamID: "team-1", notificationID: "n1", visibility: visibility}, "", true)
+	notificationRecalls.recordSent(outboundMessage{teamID: "team-1", notificationID: "n2"}, "alice", false)
+	notificationRecalls.revoke("", "n2")
+
+	store := newMemoryStore()
+	notificationStore = store
+	store.SaveOutboxJob(context.Background(), &OutboxJob{ID: "job-1", Status: outboxPending, CreatedAt: now})
+	store.SaveNotification(context.Background(), &StoredNotification{Message: Message{NotificationID: "n3"}, UserID: "alice", CreatedAt: now})
+
+	snapshot := takeHubSnapshot(hub, now)
+	first, _ := json.Marshal(snapshot)
+	second, _ := json.Marshal(takeHubSnapshot(hub, now))
+	if !bytes.Equal(first, second) {
+		t.Fatal("expected the same state to produce the same snapshot")
+	}
+	if len(snapshot.Roster) != 2 || snapshot.Roster[0].UserID != "alice" || snapshot.Roster[0].Connections != 2 {
+		t.Fatalf("unexpected roster: %+v", snapshot.Roster)
+	}
+
+	path := filepath.Join(t.TempDir(), "hub.snapshot")
+	if err := writeHubSnapshot(path, snapshot); err != nil {
+		t.Fatalf("writeHubSnapshot failed: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected an owner-only snapshot file, got %v, %v", info, err)
+	}
+
+	// A fresh process restores it.
+	loaded, err := loadHubSnapshot(path, now.Add(time.Minute), 10*time.Minute)
+	if err != nil || loaded == nil {
+		t.Fatalf("loadHubSnapshot failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected the snapshot to be consumed")
+	}
+
+	restoredStore := newMemoryStore()
+	loaded.restoreStore(restoredStore)
+	if jobs, _ := restoredStore.OutboxJobs(context.Background(), ""); len(jobs) != 1 || jobs[0].ID != "job-1" {
+		t.Fatalf("expected the outbox to be restored, got %+v", jobs)
+	}
+	if pending, _ := restoredStore.PendingFor(context.Background(), "alice"); len(pending) != 1 {
+		t.Fatalf("expected alice's stored notification to be restored, got %d", len(pending))
+	}
+
+	restored := newHub()
+	teamBlackouts = newBlackoutSchedule(nil, 10)
+	notificationRecalls = newRecallLedger(time.Hour, 100)
+	loaded.restoreHub(restored, loaded.TakenAt.Add(10*time.Minute))
+
+	windows, queued := teamBlackouts.snapshot()
+	if len(windows) != 1 || windows[0].ID != window.ID || len(queued) != 1 {
+		t.Fatalf("expected the window and its deferred broadcast, got %+v, %+v", windows, queued)
+	}
+	message, err := queued[0].message()
+	if err != nil || string(message.payload) != string(deferred.payload) || message.fanout.body != `{"severity":"low"}` {
+		t.Fatalf("unexpected restored message: %+v, %v", message, err)
+	}
+	if spec := message.visibility.spec(); len(spec) != 1 || strings.Join(spec[0].NotIn, ",") != "bot,guest" {
+		t.Fatalf("expected the visibility rules to survive, got %+v", spec)
+	}
+
+	if _, err := notificationRecalls.revoke("", "n2"); err != errAlreadyRevoked {
+		t.Fatalf("expected the recall to survive, got %v", err)
+	}
+	if sent, err := notificationRecalls.revoke("", "n1"); err != nil || !sent.broadcast || sent.teamID != "team-1" {
+		t.Fatalf("expected n1 to stay recallable, got %+v, %v", sent, err)
+	}
+
+	if awaiting := restored.awaitingReconnect(now); awaiting != 2 {
+		t.Fatalf("expected 2 users to be awaited, got %d", awaiting)
+	}
+	go restored.run()
+	registerAndWait(t, restored, &Client{hub: restored, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 1)})
+	if awaiting := restored.awaitingReconnect(now); awaiting != 1 {
+		t.Fatalf("expected alice's reconnect to be counted, got %d awaited", awaiting)
+	}
+	if awaiting := restored.awaitingReconnect(now.Add(time.Hour)); awaiting != 0 {
+		t.Fatalf("expected the roster to expire, got %d awaited", awaiting)
+	}
+}
+
+func TestLoadHubSnapshot_Rejections(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	if snapshot, err := loadHubSnapshot(filepath.Join(dir, "missing"), now, time.Minute); snapshot != nil || err != nil {
+		t.Fatalf("expected no snapshot and no error for a missing file, got %v, %v", snapshot, err)
+	}
+
+	for name, snapshot := range map[string]*hubSnapshot{
+		"older than snapshot.max_age": {Version: hubSnapshotVersion, TakenAt: now.Add(-time.Hour)},
+		"not supported":               {Version: hubSnapshotVersion + 1, TakenAt: now},
+	} {
+		path := filepath.Join(dir, "hub.snapshot")
+		if err := writeHubSnapshot(path, snapshot); err != nil {
+			t.Fatalf("writeHubSnapshot failed: %v", err)
+		}
+		if _, err := loadHubSnapshot(path, now, time.Minute); err == nil || !strings.Contains(err.Error(), name) {
+			t.Fatalf("expected an error containing %q, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// stats_feed.go
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// statsTeamID is the reserved team that admin websocket clients join to
+// receive periodic hub statistics instead of notifications.
+const statsTeamID = "__stats__"
+
+// authenticateStatsSubscriber admits a client to the __stats__ team. The
+// token must be the admin API key; the backend is not consulted.
+func (c *Client) authenticateStatsSubscriber(userID, token string) error {
+	expected := AppConfig().Security.APIKey
+	if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return errors.New("invalid authentication token")
+	}
+	if userID == "" {
+		userID = "admin"
+	}
+
+	c.userID = userID
+	c.teamID = statsTeamID
+	c.isAuthenticated = true
+
+	slog.Info("Stats subscriber authenticated", "user", userID)
+	return nil
+}
+
+// runStatsFeed pushes a StatsFrame to every __stats__ subscriber each
+// interval until stop is closed.
+func runStatsFeed(hub *Hub, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	lastEnqueued, lastDropped := hub.enqueued.Load(), hub.dropped.Load()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			enqueued, dropped := hub.enqueued.Load(), hub.dropped.Load()
+			publishStats(hub, statsFrame(hub, now, now.Sub(last), enqueued-lastEnqueued, dropped-lastDropped))
+			last, lastEnqueued, lastDropped = now, enqueued, dropped
+		}
+	}
+}
+
+func statsFrame(hub *Hub, now time.Time, elapsed time.Duration, delivered, dropped int64) StatsFrame {
+	health := hub.healthCheck()
+	frame := StatsFrame{
+		Type:            "stats",
+		Time:            now.UTC(),
+		IntervalSeconds: elapsed.Seconds(),
+		TotalTeams:      health.TotalTeams,
+		TotalClients:    health.TotalClients,
+		Delivered:       delivered,
+		Dropped:         dropped,
+	}
+	if elapsed > 0 {
+		frame.DeliveredPerSecond = float64(delivered) / elapsed.Seconds()
+	}
+	return frame
+}
+
+// publishStats sends frame on the control channel of each subscriber, so a
+// slow dashboard misses a sample rather than being disconnected.
+func publishStats(hub *Hub, frame StatsFrame) int {
+	return publishAdminFrame(hub, frame)
+}
+
+// publishAdminFrame sends frame to every __stats__ subscriber on its control
+// channel and returns how many it was queued for.
+func publishAdminFrame(hub *Hub, frame interface{}) int {
+	subscribers := hub.snapshotTeamClients(statsTeamID)
+	if len(subscribers) == 0 {
+		return 0
+	}
+
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		slog.Error("Failed to encode an admin frame", "error", err)
+		return 0
+	}
+
+	count := 0
+	for _, client := range subscribers {
+		if hub.enqueueControl(client, outboundMessage{payload: payload}) {
+			count++
+		}
+	}
+	return count
+}
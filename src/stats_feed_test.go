@@ -0,0 +1,127 @@
+// stats_feed_test.go
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateStatsSubscriber(t *testing.T) {
+	tests := []struct {
+		name     string
+		apiKey   string
+		token    string
+		userID   string
+		wantErr  bool
+		wantUser string
+	}{
+		{"api key", "test-api-key", "test-api-key", "dash-1", false, "dash-1"},
+		{"default user", "test-api-key", "test-api-key", "", false, "admin"},
+		{"wrong key", "test-api-key", "user-token", "dash-1", true, ""},
+		{"no key configured", "", "anything", "dash-1", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestAppConfig()
+			AppConfig().Security.APIKey = tt.apiKey
+
+			client := &Client{}
+			err := client.authenticate(AuthMessage{Type: "auth", TeamID: statsTeamID, UserID: tt.userID, Token: tt.token})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if client.isAuthenticated {
+					t.Fatal("expected client to stay unauthenticated")
+				}
+				return
+			}
+			if client.teamID != statsTeamID || client.userID != tt.wantUser {
+				t.Fatalf("unexpected identity %s/%s", client.teamID, client.userID)
+			}
+		})
+	}
+}
+
+func TestStatsSubscribersAreIsolated(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+
+	subscriber := &Client{hub: hub, teamID: statsTeamID, userID: "user-1", send: make(chan outboundMessage, 4), control: make(chan outboundMessage, 4)}
+	member := &Client{hub: hub, teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 4), control: make(chan outboundMessage, 4)}
+	hub.clients[statsTeamID] = map[string]map[*Client]struct{}{"user-1": {subscriber: {}}}
+	hub.clients["team-a"] = map[string]map[*Client]struct{}{"user-1": {member: {}}}
+
+	message := outboundMessage{payload: []byte(`{"type":"notification"}`), messageType: "system_alert"}
+	if delivered := hub.broadcastToAllTeams(message); delivered != 1 {
+		t.Fatalf("expected global broadcast to reach 1 client, got %d", delivered)
+	}
+	if delivered := hub.sendToUser("", "user-1", message); delivered != 1 {
+		t.Fatalf("expected cross-team direct message to reach 1 client, got %d", delivered)
+	}
+	if len(subscriber.send) != 0 {
+		t.Fatalf("stats subscriber received %d notifications", len(subscriber.send))
+	}
+
+	if published := publishStats(hub, statsFrame(hub, time.Now(), time.Second, 2, 0)); published != 1 {
+		t.Fatalf("expected stats to reach 1 subscriber, got %d", published)
+	}
+	if len(member.control) != 0 {
+		t.Fatal("team member received a stats frame")
+	}
+
+	var frame StatsFrame
+	if err := json.Unmarshal((<-subscriber.control).payload, &frame); err != nil {
+		t.Fatalf("failed to decode stats frame: %v", err)
+	}
+	if frame.Type != "stats" || frame.TotalClients != 2 || frame.Delivered != 2 || frame.DeliveredPerSecond != 2 {
+		t.Fatalf("unexpected stats frame: %+v", frame)
+	}
+}
+
+func TestRunStatsFeedReportsDeltas(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	subscriber := &Client{hub: hub, teamID: statsTeamID, userID: "admin", send: make(chan outboundMessage, 1), control: make(chan outboundMessage, 4)}
+	hub.clients[statsTeamID] = map[string]map[*Client]struct{}{"admin": {subscriber: {}}}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go runStatsFeed(hub, 20*time.Millisecond, stop)
+
+	deadline := time.After(2 * time.Second)
+	select {
+	case <-subscriber.control:
+	case <-deadline:
+		t.Fatal("timed out waiting for the first stats frame")
+	}
+	hub.enqueued.Add(5)
+	hub.dropped.Add(1)
+
+	var total StatsFrame
+	for total.Delivered < 5 || total.Dropped < 1 {
+		select {
+		case message := <-subscriber.control:
+			var frame StatsFrame
+			if err := json.Unmarshal(message.payload, &frame); err != nil {
+				t.Fatalf("failed to decode stats frame: %v", err)
+			}
+			total.Delivered += frame.Delivered
+			total.Dropped += frame.Dropped
+		case <-deadline:
+			t.Fatalf("timed out waiting for stats, got %+v", total)
+		}
+	}
+	if total.Delivered != 5 || total.Dropped != 1 {
+		t.Fatalf("expected deltas to add up to 5 delivered and 1 dropped, got %+v", total)
+	}
+}
+
+func TestMessageRequestRejectsStatsTeam(t *testing.T) {
+	req := MessageRequest{TargetTeamID: statsTeamID, MessageType: "system_alert", Body: "x", Broadcast: true}
+	if err := req.Validate(); err == nil {
+		t.Fatal("expected the stats team to be rejected as a target")
+	}
+}
@@ -0,0 +1,412 @@
+// store.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StoredNotification is a notification persisted for a single recipient.
+type StoredNotification struct {
+	Message     Message   `json:"message"`
+	UserID      string    `json:"userId"` // recipient
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	DeliveredAt time.Time `json:"deliveredAt,omitempty"`
+}
+
+// ID returns the notification ID the record is keyed by.
+func (n *StoredNotification) ID() string {
+	return n.Message.NotificationID
+}
+
+// OutboxJob is an outbound webhook persisted before it is sent, so a crash
+// between accepting the job and delivering it does not lose it.
+type OutboxJob struct {
+	ID        string            `json:"id"`
+	Kind      string            `json:"kind"`
+	URL       string            `json:"url"`
+	Payload   json.RawMessage   `json:"payload"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Status    string            `json:"status"` // outboxPending or outboxFailed
+	Attempts  int               `json:"attempts"`
+	LastError string            `json:"lastError,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// TeamWebhook is an endpoint a team registered through /admin/webhooks/teams
+// to hear about the events it subscribes to.
+type TeamWebhook struct {
+	ID        string    `json:"id"`
+	TenantID  string    `json:"tenantId,omitempty"`
+	TeamID    string    `json:"teamId"` // as given, without the tenant prefix
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"` // signs each delivery
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ScheduledBroadcast is a recurring team broadcast registered through
+// /admin/schedules, together with the state the scheduler needs to resume it
+// after a restart.
+type ScheduledBroadcast struct {
+	ID             string    `json:"id"`
+	TenantID       string    `json:"tenantId,omitempty"`
+	TeamID         string    `json:"teamId"` // as given, without the tenant prefix
+	Cron           string    `json:"cron"`
+	Timezone       string    `json:"timezone"`
+	MessageType    string    `json:"messageType"`
+	Template       string    `json:"template"` // text/template for the body
+	ActionRequired bool      `json:"actionRequired"`
+	MisfirePolicy  string    `json:"misfirePolicy"` // misfireFireOnce or misfireSkip
+	CreatedAt      time.Time `json:"createdAt"`
+	NextRunAt      time.Time `json:"nextRunAt"`
+
+	// History holds the latest executions, newest first.
+	History []ScheduleExecution `json:"history,omitempty"`
+}
+
+// ScheduleExecution records one due run of a scheduled broadcast.
+type ScheduleExecution struct {
+	ScheduledFor   time.Time `json:"scheduledFor"`
+	FiredAt        time.Time `json:"firedAt,omitempty"`
+	Status         string    `json:"status"` // delivered, deferred, no_recipients, missed or failed
+	Delivered      int       `json:"delivered"`
+	NotificationID string    `json:"notificationId,omitempty"`
+	Missed         int       `json:"missed,omitempty"` // earlier runs collapsed into this one
+	Error          string    `json:"error,omitempty"`
+}
+
+// Presence rollup periods.
+const (
+	rollupHour = "hour"
+	rollupDay  = "day"
+)
+
+// PresenceRollup summarizes one team's presence on one instance over an
+// hour or a day. Periods start on UTC boundaries.
+type PresenceRollup struct {
+	Instance       string    `json:"instance"`
+	TenantID       string    `json:"tenantId,omitempty"`
+	TeamID         string    `json:"teamId"` // as clients know it, without the tenant prefix
+	Period         string    `json:"period"` // rollupHour or rollupDay
+	Start          time.Time `json:"start"`
+	PeakUsers      int       `json:"peakUsers"` // most users connected at once
+	Connects       int       `json:"connects"`
+	Sessions       int       `json:"sessions"`       // connections that ended in the period
+	SessionSeconds float64   `json:"sessionSeconds"` // total length of those sessions
+	StatusChanges  int       `json:"statusChanges"`  // moves between online, away and dnd
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// key identifies the rollup within a store.
+func (r *PresenceRollup) key() string {
+	return strings.Join([]string{r.Period, strconv.FormatInt(r.Start.Unix(), 10), r.Instance, r.TenantID, r.TeamID}, "\n")
+}
+
+// MessageRollup counts the messages one instance accepted for one team and
+// message type over an hour. Hours start on UTC boundaries.
+type MessageRollup struct {
+	Instance       string    `json:"instance"`
+	TenantID       string    `json:"tenantId,omitempty"`
+	TeamID         string    `json:"teamId,omitempty"` // empty for global broadcasts and sends without a team
+	MessageType    string    `json:"messageType,omitempty"`
+	Start          time.Time `json:"start"`
+	Messages       int       `json:"messages"`
+	Bytes          int64     `json:"bytes"`      // encoded size of those messages
+	Deliveries     int       `json:"deliveries"` // connections they were written to
+	DeliveredBytes int64     `json:"deliveredBytes"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// key identifies the rollup within a store.
+func (r *MessageRollup) key() string {
+	return strings.Join([]string{strconv.FormatInt(r.Start.Unix(), 10), r.Instance, r.TenantID, r.TeamID, r.MessageType}, "\n")
+}
+
+// ArchivedMessage is a sampled /send kept for troubleshooting, with the
+// message as it was encoded for clients and how it was routed.
+type ArchivedMessage struct {
+	NotificationID string    `json:"notificationId"`
+	TenantID       string    `json:"tenantId,omitempty"`
+	TeamID         string    `json:"teamId,omitempty"` // as the sender gave it, without the tenant prefix
+	UserID         string    `json:"userId,omitempty"` // the target of a direct send
+	MessageType    string    `json:"messageType,omitempty"`
+	Broadcast      bool      `json:"broadcast"`
+	ReplacesID     string    `json:"replacesId,omitempty"`
+	Outcome        string    `json:"outcome"` // routed, unrouted or deferred
+	Recipients     int       `json:"recipients"`
+	RemoteAddr     string    `json:"remoteAddr,omitempty"`
+	Payload        string    `json:"payload"` // the encoded message
+	ReceivedAt     time.Time `json:"receivedAt"`
+	ArchivedAt     time.Time `json:"archivedAt"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+}
+
+// key identifies the entry within a store. A notification ID that is sent
+// again is archived again.
+func (m *ArchivedMessage) key() string {
+	return m.NotificationID + "\n" + strconv.FormatInt(m.ArchivedAt.UnixNano(), 10)
+}
+
+// UserPreferences are the settings a user keeps in sync across devices.
+// Version grows by one with every change.
+type UserPreferences struct {
+	TenantID  string                     `json:"tenantId,omitempty"`
+	UserID    string                     `json:"userId"`
+	Version   int64                      `json:"version"`
+	Values    map[string]json.RawMessage `json:"values"`
+	UpdatedAt time.Time                  `json:"updatedAt"`
+}
+
+func preferencesKey(tenantID, userID string) string {
+	return tenantID + "\n" + userID
+}
+
+// copyUserPreferences returns a copy that shares no map with preferences.
+// Values are never modified in place, so they are shared.
+func copyUserPreferences(preferences *UserPreferences) *UserPreferences {
+	copied := *preferences
+	copied.Values = make(map[string]json.RawMessage, len(preferences.Values))
+	for key, value := range preferences.Values {
+		copied.Values[key] = value
+	}
+	return &copied
+}
+
+// Store persists notifications for offline queues, replay and read state,
+// outbound webhook jobs, scheduled broadcasts, presence and message rollups,
+// archived messages and user preferences. Implementations must be safe for concurrent use.
+type Store interface {
+	// SaveNotification persists a notification for n.UserID. The message
+	// must carry a NotificationID; saving the same ID twice replaces it.
+	SaveNotification(ctx context.Context, n *StoredNotification) error
+	// PendingFor returns undelivered, unexpired notifications for a user,
+	// oldest first.
+	PendingFor(ctx context.Context, userID string) ([]*StoredNotification, error)
+	// MarkDelivered marks a user's notification as delivered so it is no
+	// longer pending.
+	MarkDelivered(ctx context.Context, userID, notificationID string) error
+	// PruneExpired removes notifications whose ExpiresAt is before now and
+	// returns how many were removed.
+	PruneExpired(ctx context.Context, now time.Time) (int, error)
+	// SaveOutboxJob persists a webhook job; saving the same ID twice
+	// replaces it.
+	SaveOutboxJob(ctx context.Context, job *OutboxJob) error
+	// OutboxJob returns a webhook job by ID, or nil if there is none.
+	OutboxJob(ctx context.Context, id string) (*OutboxJob, error)
+	// OutboxJobs returns webhook jobs with the given status, or every job
+	// if status is empty, oldest first.
+	OutboxJobs(ctx context.Context, status string) ([]*OutboxJob, error)
+	// DeleteOutboxJob removes a webhook job once it has been delivered or
+	// discarded. Deleting a missing job is not an error.
+	DeleteOutboxJob(ctx context.Context, id string) error
+	// SaveSchedule persists a scheduled broadcast; saving the same ID twice
+	// replaces it.
+	SaveSchedule(ctx context.Context, schedule *ScheduledBroadcast) error
+	// Schedules returns every scheduled broadcast, oldest first.
+	Schedules(ctx context.Context) ([]*ScheduledBroadcast, error)
+	// DeleteSchedule removes a scheduled broadcast. Deleting a missing one
+	// is not an error.
+	DeleteSchedule(ctx context.Context, id string) error
+	// SaveTeamWebhook persists a team webhook; saving the same ID twice
+	// replaces it.
+	SaveTeamWebhook(ctx context.Context, webhook *TeamWebhook) error
+	// TeamWebhooks returns every team webhook, oldest first.
+	TeamWebhooks(ctx context.Context) ([]*TeamWebhook, error)
+	// DeleteTeamWebhook removes a team webhook. Deleting a missing one is
+	// not an error.
+	DeleteTeamWebhook(ctx context.Context, id string) error
+	// SavePresenceRollup persists a presence rollup; saving the same
+	// instance, team, period and start twice replaces it.
+	SavePresenceRollup(ctx context.Context, rollup *PresenceRollup) error
+	// PresenceRollups returns the rollups of period that start in
+	// [from, to), oldest first, then by tenant, team and instance.
+	PresenceRollups(ctx context.Context, period string, from, to time.Time) ([]*PresenceRollup, error)
+	// SaveMessageRollup persists a message rollup; saving the same
+	// instance, team, message type and hour twice replaces it.
+	SaveMessageRollup(ctx context.Context, rollup *MessageRollup) error
+	// MessageRollups returns the rollups of the hours that start in
+	// [from, to), oldest first, then by tenant, team, type and instance.
+	MessageRollups(ctx context.Context, from, to time.Time) ([]*MessageRollup, error)
+	// ArchiveMessage persists a sampled message until its ExpiresAt.
+	ArchiveMessage(ctx context.Context, message *ArchivedMessage) error
+	// ArchivedMessages returns the archived sends of a notification ID,
+	// oldest first.
+	ArchivedMessages(ctx context.Context, notificationID string) ([]*ArchivedMessage, error)
+	// PruneArchive removes archived messages whose ExpiresAt is before now
+	// and returns how many were removed.
+	PruneArchive(ctx context.Context, now time.Time) (int, error)
+	// SaveUserPreferences persists a user's preferences, replacing any
+	// saved before.
+	SaveUserPreferences(ctx context.Context, preferences *UserPreferences) error
+	// UserPreferences returns a user's preferences, or nil if none were
+	// saved.
+	UserPreferences(ctx context.Context, tenantID, userID string) (*UserPreferences, error)
+	Close() error
+}
+
+// notificationStore is the process-wide store selected by storage.driver.
+var notificationStore Store = newMemoryStore()
+
+func newStore(config *Config) (Store, error) {
+	var store Store
+	switch config.Storage.Driver {
+	case "memory":
+		store = newMemoryStore()
+	case "redis":
+		store = newRedisStore(
+			newRedisClient(config.Storage.Redis.Address, config.Storage.Redis.Password, config.Storage.Redis.DB, config.Storage.Redis.Timeout),
+			config.Storage.Redis.KeyPrefix,
+		)
+	case "sql":
+		sqlStore, err := newSQLStore(config.Storage.SQL.Driver, config.Storage.SQL.DSN, !config.Storage.SQL.SkipMigrations)
+		if err != nil {
+			return nil, err
+		}
+		store = sqlStore
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", config.Storage.Driver)
+	}
+
+	if !config.Storage.Encryption.Enabled {
+		return store, nil
+	}
+
+	keys := make(map[string]string, len(config.Storage.Encryption.Keys))
+	for _, key := range config.Storage.Encryption.Keys {
+		keys[key.ID] = key.Key
+	}
+	ring, err := newKeyring(config.Storage.Encryption.ActiveKeyID, keys)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return newEncryptedStore(store, ring), nil
+}
+
+// newNotificationID generates an ID for notifications sent without one.
+func newNotificationID() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("n-%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// runStorePruner periodically removes expired notifications and archived
+// messages until stop is closed.
+func runStorePruner(store Store, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			pruned, err := store.PruneExpired(ctx, time.Now())
+			cancel()
+			if err != nil {
+				slog.Error("Failed to prune expired notifications", "error", err)
+			} else if pruned > 0 {
+				slog.Info("Pruned expired notifications", "pruned", pruned)
+				appMetrics.Count("store.pruned", int64(pruned))
+			}
+
+			ctx, cancel = context.WithTimeout(context.Background(), interval)
+			archived, err := store.PruneArchive(ctx, time.Now())
+			cancel()
+			if err != nil {
+				slog.Error("Failed to prune archived messages", "error", err)
+			} else if archived > 0 {
+				slog.Info("Pruned archived messages", "pruned", archived)
+				appMetrics.Count("store.archive_pruned", int64(archived))
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// validPresenceRollup rejects a rollup that cannot be keyed.
+func validPresenceRollup(rollup *PresenceRollup) error {
+	if rollup == nil || rollup.TeamID == "" || (rollup.Period != rollupHour && rollup.Period != rollupDay) || rollup.Start.IsZero() {
+		return fmt.Errorf("presence rollup team id, period and start are required")
+	}
+	return nil
+}
+
+// sortPresenceRollups orders rollups by start, tenant, team and instance.
+func sortPresenceRollups(rollups []*PresenceRollup) {
+	sort.Slice(rollups, func(i, j int) bool {
+		a, b := rollups[i], rollups[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		if a.TenantID != b.TenantID {
+			return a.TenantID < b.TenantID
+		}
+		if a.TeamID != b.TeamID {
+			return a.TeamID < b.TeamID
+		}
+		return a.Instance < b.Instance
+	})
+}
+
+// inRollupRange reports whether rollup belongs to a PresenceRollups query.
+func inRollupRange(rollup *PresenceRollup, period string, from, to time.Time) bool {
+	return rollup.Period == period && !rollup.Start.Before(from) && rollup.Start.Before(to)
+}
+
+func validMessageRollup(rollup *MessageRollup) error {
+	if rollup == nil || rollup.Start.IsZero() {
+		return fmt.Errorf("message rollup start is required")
+	}
+	return nil
+}
+
+// sortMessageRollups orders rollups by start, tenant, team, message type and
+// instance.
+func sortMessageRollups(rollups []*MessageRollup) {
+	sort.Slice(rollups, func(i, j int) bool {
+		a, b := rollups[i], rollups[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		if a.TenantID != b.TenantID {
+			return a.TenantID < b.TenantID
+		}
+		if a.TeamID != b.TeamID {
+			return a.TeamID < b.TeamID
+		}
+		if a.MessageType != b.MessageType {
+			return a.MessageType < b.MessageType
+		}
+		return a.Instance < b.Instance
+	})
+}
+
+func validArchivedMessage(message *ArchivedMessage) error {
+	if message == nil || message.NotificationID == "" || message.ArchivedAt.IsZero() {
+		return fmt.Errorf("archived message notification id and archive time are required")
+	}
+	return nil
+}
+
+func sortArchivedMessages(messages []*ArchivedMessage) {
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].ArchivedAt.Equal(messages[j].ArchivedAt) {
+			return messages[i].ArchivedAt.Before(messages[j].ArchivedAt)
+		}
+		return messages[i].NotificationID < messages[j].NotificationID
+	})
+}
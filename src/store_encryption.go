@@ -0,0 +1,247 @@
+// store_encryption.go
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const encryptedBodyPrefix = "enc:v1:"
+
+// keyring holds key-encryption keys by ID. New data is wrapped with the
+// active key; any listed key can unwrap, so rotating means adding a new key,
+// making it active, and removing the old one once its data has expired.
+type keyring struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+func newKeyring(activeID string, keys map[string]string) (*keyring, error) {
+	ring := &keyring{activeID: activeID, keys: make(map[string][]byte, len(keys))}
+	for id, encoded := range keys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q is not valid base64: %v", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes (AES-256), got %d", id, len(key))
+		}
+		ring.keys[id] = key
+	}
+	if _, ok := ring.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not configured", activeID)
+	}
+	return ring, nil
+}
+
+func sealAESGCM(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openAESGCM(key, sealed, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+}
+
+// encrypt seals plaintext with a fresh data key, wraps the data key with the
+// active key-encryption key and returns
+// enc:v1:<key id>:<wrapped data key>:<ciphertext>. aad binds the ciphertext to
+// its record so bodies cannot be swapped between notifications.
+func (k *keyring) encrypt(plaintext string, aad []byte) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	ciphertext, err := sealAESGCM(dataKey, []byte(plaintext), aad)
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := sealAESGCM(k.keys[k.activeID], dataKey, []byte(k.activeID))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedBodyPrefix + k.activeID + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+func (k *keyring) decrypt(value string, aad []byte) (string, error) {
+	if !strings.HasPrefix(value, encryptedBodyPrefix) {
+		// Written before encryption was enabled.
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encryptedBodyPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted body")
+	}
+	keyID := parts[0]
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("encryption key %q is no longer configured", keyID)
+	}
+
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := openAESGCM(kek, wrappedKey, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	plaintext, err := openAESGCM(dataKey, ciphertext, aad)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt body: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+func notificationAAD(n *StoredNotification) []byte {
+	return []byte(n.UserID + "\n" + n.ID())
+}
+
+func archivedMessageAAD(m *ArchivedMessage) []byte {
+	return []byte(m.key())
+}
+
+func teamWebhookAAD(w *TeamWebhook) []byte {
+	return []byte("team-webhook\n" + w.ID)
+}
+
+// encryptedStore wraps another Store and encrypts notification bodies,
+// archived payloads and team webhook secrets before they are persisted,
+// decrypting them again when they are read.
+type encryptedStore struct {
+	Store
+	keys *keyring
+}
+
+func newEncryptedStore(inner Store, keys *keyring) *encryptedStore {
+	return &encryptedStore{Store: inner, keys: keys}
+}
+
+func (s *encryptedStore) SaveNotification(ctx context.Context, n *StoredNotification) error {
+	if n == nil {
+		return s.Store.SaveNotification(ctx, n)
+	}
+
+	encrypted := *n
+	body, err := s.keys.encrypt(n.Message.Body, notificationAAD(n))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt notification body: %v", err)
+	}
+	encrypted.Message.Body = body
+	return s.Store.SaveNotification(ctx, &encrypted)
+}
+
+func (s *encryptedStore) PendingFor(ctx context.Context, userID string) ([]*StoredNotification, error) {
+	pending, err := s.Store.PendingFor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range pending {
+		body, err := s.keys.decrypt(n.Message.Body, notificationAAD(n))
+		if err != nil {
+			return nil, fmt.Errorf("notification %s: %v", n.ID(), err)
+		}
+		n.Message.Body = body
+	}
+	return pending, nil
+}
+
+func (s *encryptedStore) ArchiveMessage(ctx context.Context, m *ArchivedMessage) error {
+	if m == nil {
+		return s.Store.ArchiveMessage(ctx, m)
+	}
+
+	encrypted := *m
+	payload, err := s.keys.encrypt(m.Payload, archivedMessageAAD(m))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt archived payload: %v", err)
+	}
+	encrypted.Payload = payload
+	return s.Store.ArchiveMessage(ctx, &encrypted)
+}
+
+func (s *encryptedStore) ArchivedMessages(ctx context.Context, notificationID string) ([]*ArchivedMessage, error) {
+	messages, err := s.Store.ArchivedMessages(ctx, notificationID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range messages {
+		payload, err := s.keys.decrypt(m.Payload, archivedMessageAAD(m))
+		if err != nil {
+			return nil, fmt.Errorf("archived message %s: %v", m.NotificationID, err)
+		}
+		m.Payload = payload
+	}
+	return messages, nil
+}
+
+func (s *encryptedStore) SaveTeamWebhook(ctx context.Context, w *TeamWebhook) error {
+	if w == nil {
+		return s.Store.SaveTeamWebhook(ctx, w)
+	}
+
+	encrypted := *w
+	secret, err := s.keys.encrypt(w.Secret, teamWebhookAAD(w))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt webhook secret: %v", err)
+	}
+	encrypted.Secret = secret
+	return s.Store.SaveTeamWebhook(ctx, &encrypted)
+}
+
+func (s *encryptedStore) TeamWebhooks(ctx context.Context) ([]*TeamWebhook, error) {
+	webhooks, err := s.Store.TeamWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range webhooks {
+		secret, err := s.keys.decrypt(w.Secret, teamWebhookAAD(w))
+		if err != nil {
+			return nil, fmt.Errorf("team webhook %s: %v", w.ID, err)
+		}
+		w.Secret = secret
+	}
+	return webhooks, nil
+}
@@ -0,0 +1,158 @@
+// store_encryption_test.go
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(fill byte) string {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = fill
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestEncryptedStore_EncryptsBodiesAtRest(t *testing.T) {
+	ring, err := newKeyring("k1", map[string]string{"k1": testKey(1)})
+	if err != nil {
+		t.Fatalf("newKeyring failed: %v", err)
+	}
+	inner := newMemoryStore()
+	store := newEncryptedStore(inner, ring)
+	ctx := context.Background()
+
+	n := &StoredNotification{
+		Message:   Message{NotificationID: "n-1", Body: "salary review at 3pm"},
+		UserID:    "user-1",
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.SaveNotification(ctx, n); err != nil {
+		t.Fatalf("SaveNotification failed: %v", err)
+	}
+	if n.Message.Body != "salary review at 3pm" {
+		t.Fatal("SaveNotification must not modify the caller's notification")
+	}
+
+	raw, _ := inner.PendingFor(ctx, "user-1")
+	if len(raw) != 1 || !strings.HasPrefix(raw[0].Message.Body, "enc:v1:k1:") {
+		t.Fatalf("expected body to be encrypted at rest, got %+v", raw)
+	}
+
+	pending, err := store.PendingFor(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("PendingFor failed: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Message.Body != "salary review at 3pm" {
+		t.Fatalf("expected decrypted body on read, got %+v", pending)
+	}
+}
+
+func TestEncryptedStore_EncryptsArchivedPayloads(t *testing.T) {
+	ring, err := newKeyring("k1", map[string]string{"k1": testKey(1)})
+	if err != nil {
+		t.Fatalf("newKeyring failed: %v", err)
+	}
+	inner := newMemoryStore()
+	store := newEncryptedStore(inner, ring)
+	ctx := context.Background()
+
+	now := time.Now()
+	payload := `{"body":"salary review at 3pm"}`
+	if err := store.ArchiveMessage(ctx, &ArchivedMessage{NotificationID: "n-1", Payload: payload, ArchivedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("ArchiveMessage failed: %v", err)
+	}
+
+	raw, _ := inner.ArchivedMessages(ctx, "n-1")
+	if len(raw) != 1 || !strings.HasPrefix(raw[0].Payload, "enc:v1:k1:") {
+		t.Fatalf("expected the payload to be encrypted at rest, got %+v", raw)
+	}
+	archived, err := store.ArchivedMessages(ctx, "n-1")
+	if err != nil || len(archived) != 1 || archived[0].Payload != payload {
+		t.Fatalf("expected the decrypted payload on read, got %+v, %v", archived, err)
+	}
+}
+
+func TestEncryptedStore_EncryptsTeamWebhookSecrets(t *testing.T) {
+	ring, err := newKeyring("k1", map[string]string{"k1": testKey(1)})
+	if err != nil {
+		t.Fatalf("newKeyring failed: %v", err)
+	}
+	inner := newMemoryStore()
+	store := newEncryptedStore(inner, ring)
+	ctx := context.Background()
+
+	if err := store.SaveTeamWebhook(ctx, &TeamWebhook{ID: "hook-1", TeamID: "team1", URL: "https://hooks.example.com", Events: []string{"presence"}, Secret: "s3cret"}); err != nil {
+		t.Fatalf("SaveTeamWebhook failed: %v", err)
+	}
+
+	raw, _ := inner.TeamWebhooks(ctx)
+	if len(raw) != 1 || !strings.HasPrefix(raw[0].Secret, "enc:v1:k1:") {
+		t.Fatalf("expected the secret to be encrypted at rest, got %+v", raw)
+	}
+	webhooks, err := store.TeamWebhooks(ctx)
+	if err != nil || len(webhooks) != 1 || webhooks[0].Secret != "s3cret" {
+		t.Fatalf("expected the decrypted secret on read, got %+v, %v", webhooks, err)
+	}
+}
+
+func TestEncryptedStore_KeyRotation(t *testing.T) {
+	inner := newMemoryStore()
+	ctx := context.Background()
+
+	oldRing, _ := newKeyring("k1", map[string]string{"k1": testKey(1)})
+	if err := newEncryptedStore(inner, oldRing).SaveNotification(ctx, &StoredNotification{
+		Message: Message{NotificationID: "n-old", Body: "written with k1"},
+		UserID:  "user-1",
+	}); err != nil {
+		t.Fatalf("SaveNotification failed: %v", err)
+	}
+
+	rotated, _ := newKeyring("k2", map[string]string{"k1": testKey(1), "k2": testKey(2)})
+	store := newEncryptedStore(inner, rotated)
+	if err := store.SaveNotification(ctx, &StoredNotification{
+		Message:   Message{NotificationID: "n-new", Body: "written with k2"},
+		UserID:    "user-1",
+		CreatedAt: time.Now().Add(time.Second),
+	}); err != nil {
+		t.Fatalf("SaveNotification failed: %v", err)
+	}
+
+	pending, err := store.PendingFor(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("PendingFor failed: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Message.Body != "written with k1" || pending[1].Message.Body != "written with k2" {
+		t.Fatalf("expected both generations to decrypt, got %+v", pending)
+	}
+
+	retired, _ := newKeyring("k2", map[string]string{"k2": testKey(2)})
+	if _, err := newEncryptedStore(inner, retired).PendingFor(ctx, "user-1"); err == nil {
+		t.Fatal("expected an error reading data wrapped with a removed key")
+	}
+}
+
+func TestKeyring_RejectsSwappedCiphertext(t *testing.T) {
+	ring, _ := newKeyring("k1", map[string]string{"k1": testKey(1)})
+	sealed, err := ring.encrypt("secret", []byte("user-1\nn-1"))
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	if _, err := ring.decrypt(sealed, []byte("user-2\nn-1")); err == nil {
+		t.Fatal("expected decryption to fail when the ciphertext is moved to another record")
+	}
+}
+
+func TestNewKeyring_ValidatesKeys(t *testing.T) {
+	if _, err := newKeyring("k1", map[string]string{"k1": base64.StdEncoding.EncodeToString([]byte("short"))}); err == nil {
+		t.Fatal("expected an error for a key that is not 32 bytes")
+	}
+	if _, err := newKeyring("missing", map[string]string{"k1": testKey(1)}); err == nil {
+		t.Fatal("expected an error when the active key is not configured")
+	}
+}
@@ -0,0 +1,486 @@
+// store_memory.go
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps notifications in process memory. It is the default driver
+// and loses everything on restart.
+type memoryStore struct {
+	mu        sync.Mutex
+	users     map[string]map[string]*StoredNotification
+	outbox    map[string]*OutboxJob
+	schedules map[string]*ScheduledBroadcast
+	webhooks  map[string]*TeamWebhook
+	rollups   map[string]*PresenceRollup
+	messages  map[string]*MessageRollup
+	archive   map[string]*ArchivedMessage
+	prefs     map[string]*UserPreferences
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:     make(map[string]map[string]*StoredNotification),
+		outbox:    make(map[string]*OutboxJob),
+		schedules: make(map[string]*ScheduledBroadcast),
+		webhooks:  make(map[string]*TeamWebhook),
+		rollups:   make(map[string]*PresenceRollup),
+		messages:  make(map[string]*MessageRollup),
+		archive:   make(map[string]*ArchivedMessage),
+		prefs:     make(map[string]*UserPreferences),
+	}
+}
+
+func (s *memoryStore) SaveNotification(_ context.Context, n *StoredNotification) error {
+	if n == nil || n.ID() == "" || n.UserID == "" {
+		return errors.New("notification id and user id are required")
+	}
+
+	copied := *n
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notifications, ok := s.users[n.UserID]
+	if !ok {
+		notifications = make(map[string]*StoredNotification)
+		s.users[n.UserID] = notifications
+	}
+	notifications[n.ID()] = &copied
+	return nil
+}
+
+func (s *memoryStore) PendingFor(_ context.Context, userID string) ([]*StoredNotification, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	pending := make([]*StoredNotification, 0, len(s.users[userID]))
+	for _, n := range s.users[userID] {
+		if !n.DeliveredAt.IsZero() || (!n.ExpiresAt.IsZero() && n.ExpiresAt.Before(now)) {
+			continue
+		}
+		copied := *n
+		pending = append(pending, &copied)
+	}
+	s.mu.Unlock()
+
+	sortNotifications(pending)
+	return pending, nil
+}
+
+func (s *memoryStore) MarkDelivered(_ context.Context, userID, notificationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n, ok := s.users[userID][notificationID]; ok {
+		n.DeliveredAt = time.Now()
+	}
+	return nil
+}
+
+func (s *memoryStore) PruneExpired(_ context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for userID, notifications := range s.users {
+		for id, n := range notifications {
+			if !n.ExpiresAt.IsZero() && n.ExpiresAt.Before(now) {
+				delete(notifications, id)
+				pruned++
+			}
+		}
+		if len(notifications) == 0 {
+			delete(s.users, userID)
+		}
+	}
+	return pruned, nil
+}
+
+func (s *memoryStore) SaveOutboxJob(_ context.Context, job *OutboxJob) error {
+	if job == nil || job.ID == "" {
+		return errors.New("outbox job id is required")
+	}
+
+	copied := *job
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outbox[job.ID] = &copied
+	return nil
+}
+
+func (s *memoryStore) OutboxJob(_ context.Context, id string) (*OutboxJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.outbox[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (s *memoryStore) OutboxJobs(_ context.Context, status string) ([]*OutboxJob, error) {
+	s.mu.Lock()
+	jobs := make([]*OutboxJob, 0, len(s.outbox))
+	for _, job := range s.outbox {
+		if status != "" && job.Status != status {
+			continue
+		}
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+	s.mu.Unlock()
+
+	sortOutboxJobs(jobs)
+	return jobs, nil
+}
+
+func (s *memoryStore) DeleteOutboxJob(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.outbox, id)
+	return nil
+}
+
+func (s *memoryStore) SaveSchedule(_ context.Context, schedule *ScheduledBroadcast) error {
+	if schedule == nil || schedule.ID == "" {
+		return errors.New("schedule id is required")
+	}
+
+	copied := *schedule
+	copied.History = append([]ScheduleExecution(nil), schedule.History...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules[schedule.ID] = &copied
+	return nil
+}
+
+func (s *memoryStore) Schedules(_ context.Context) ([]*ScheduledBroadcast, error) {
+	s.mu.Lock()
+	schedules := make([]*ScheduledBroadcast, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		copied := *schedule
+		copied.History = append([]ScheduleExecution(nil), schedule.History...)
+		schedules = append(schedules, &copied)
+	}
+	s.mu.Unlock()
+
+	sortSchedules(schedules)
+	return schedules, nil
+}
+
+func (s *memoryStore) DeleteSchedule(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.schedules, id)
+	return nil
+}
+
+func (s *memoryStore) SaveTeamWebhook(_ context.Context, webhook *TeamWebhook) error {
+	if webhook == nil || webhook.ID == "" {
+		return errors.New("webhook id is required")
+	}
+
+	copied := *webhook
+	copied.Events = append([]string(nil), webhook.Events...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks[webhook.ID] = &copied
+	return nil
+}
+
+func (s *memoryStore) TeamWebhooks(_ context.Context) ([]*TeamWebhook, error) {
+	s.mu.Lock()
+	webhooks := make([]*TeamWebhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		copied := *webhook
+		copied.Events = append([]string(nil), webhook.Events...)
+		webhooks = append(webhooks, &copied)
+	}
+	s.mu.Unlock()
+
+	sortTeamWebhooks(webhooks)
+	return webhooks, nil
+}
+
+func (s *memoryStore) DeleteTeamWebhook(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.webhooks, id)
+	return nil
+}
+
+func (s *memoryStore) SavePresenceRollup(_ context.Context, rollup *PresenceRollup) error {
+	if err := validPresenceRollup(rollup); err != nil {
+		return err
+	}
+
+	copied := *rollup
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollups[rollup.key()] = &copied
+	return nil
+}
+
+func (s *memoryStore) PresenceRollups(_ context.Context, period string, from, to time.Time) ([]*PresenceRollup, error) {
+	s.mu.Lock()
+	rollups := make([]*PresenceRollup, 0)
+	for _, rollup := range s.rollups {
+		if !inRollupRange(rollup, period, from, to) {
+			continue
+		}
+		copied := *rollup
+		rollups = append(rollups, &copied)
+	}
+	s.mu.Unlock()
+
+	sortPresenceRollups(rollups)
+	return rollups, nil
+}
+
+func (s *memoryStore) SaveMessageRollup(_ context.Context, rollup *MessageRollup) error {
+	if err := validMessageRollup(rollup); err != nil {
+		return err
+	}
+
+	copied := *rollup
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[rollup.key()] = &copied
+	return nil
+}
+
+func (s *memoryStore) MessageRollups(_ context.Context, from, to time.Time) ([]*MessageRollup, error) {
+	s.mu.Lock()
+	rollups := make([]*MessageRollup, 0)
+	for _, rollup := range s.messages {
+		if rollup.Start.Before(from) || !rollup.Start.Before(to) {
+			continue
+		}
+		copied := *rollup
+		rollups = append(rollups, &copied)
+	}
+	s.mu.Unlock()
+
+	sortMessageRollups(rollups)
+	return rollups, nil
+}
+
+func (s *memoryStore) ArchiveMessage(_ context.Context, message *ArchivedMessage) error {
+	if err := validArchivedMessage(message); err != nil {
+		return err
+	}
+
+	copied := *message
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.archive[message.key()] = &copied
+	return nil
+}
+
+func (s *memoryStore) ArchivedMessages(_ context.Context, notificationID string) ([]*ArchivedMessage, error) {
+	s.mu.Lock()
+	messages := make([]*ArchivedMessage, 0)
+	for _, message := range s.archive {
+		if message.NotificationID != notificationID {
+			continue
+		}
+		copied := *message
+		messages = append(messages, &copied)
+	}
+	s.mu.Unlock()
+
+	sortArchivedMessages(messages)
+	return messages, nil
+}
+
+func (s *memoryStore) PruneArchive(_ context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for key, message := range s.archive {
+		if message.ExpiresAt.Before(now) {
+			delete(s.archive, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+func (s *memoryStore) SaveUserPreferences(_ context.Context, preferences *UserPreferences) error {
+	if preferences == nil || preferences.UserID == "" {
+		return errors.New("preferences user id is required")
+	}
+
+	copied := copyUserPreferences(preferences)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefs[preferencesKey(preferences.TenantID, preferences.UserID)] = copied
+	return nil
+}
+
+func (s *memoryStore) UserPreferences(_ context.Context, tenantID, userID string) (*UserPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	preferences, ok := s.prefs[preferencesKey(tenantID, userID)]
+	if !ok {
+		return nil, nil
+	}
+	return copyUserPreferences(preferences), nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// dump copies every record, in a deterministic order, for a hub snapshot.
+func (s *memoryStore) dump() *memoryStoreSnapshot {
+	s.mu.Lock()
+	snapshot := &memoryStoreSnapshot{
+		Notifications:  make([]*StoredNotification, 0),
+		Outbox:         make([]*OutboxJob, 0, len(s.outbox)),
+		Schedules:      make([]*ScheduledBroadcast, 0, len(s.schedules)),
+		TeamWebhooks:   make([]*TeamWebhook, 0, len(s.webhooks)),
+		Rollups:        make([]*PresenceRollup, 0, len(s.rollups)),
+		MessageRollups: make([]*MessageRollup, 0, len(s.messages)),
+		Archive:        make([]*ArchivedMessage, 0, len(s.archive)),
+		Preferences:    make([]*UserPreferences, 0, len(s.prefs)),
+	}
+	for _, notifications := range s.users {
+		for _, n := range notifications {
+			copied := *n
+			snapshot.Notifications = append(snapshot.Notifications, &copied)
+		}
+	}
+	for _, job := range s.outbox {
+		copied := *job
+		snapshot.Outbox = append(snapshot.Outbox, &copied)
+	}
+	for _, schedule := range s.schedules {
+		copied := *schedule
+		copied.History = append([]ScheduleExecution(nil), schedule.History...)
+		snapshot.Schedules = append(snapshot.Schedules, &copied)
+	}
+	for _, webhook := range s.webhooks {
+		copied := *webhook
+		copied.Events = append([]string(nil), webhook.Events...)
+		snapshot.TeamWebhooks = append(snapshot.TeamWebhooks, &copied)
+	}
+	for _, rollup := range s.rollups {
+		copied := *rollup
+		snapshot.Rollups = append(snapshot.Rollups, &copied)
+	}
+	for _, rollup := range s.messages {
+		copied := *rollup
+		snapshot.MessageRollups = append(snapshot.MessageRollups, &copied)
+	}
+	for _, message := range s.archive {
+		copied := *message
+		snapshot.Archive = append(snapshot.Archive, &copied)
+	}
+	for _, preferences := range s.prefs {
+		snapshot.Preferences = append(snapshot.Preferences, copyUserPreferences(preferences))
+	}
+	s.mu.Unlock()
+
+	sortNotifications(snapshot.Notifications)
+	sort.SliceStable(snapshot.Notifications, func(i, j int) bool {
+		return snapshot.Notifications[i].UserID < snapshot.Notifications[j].UserID
+	})
+	sortOutboxJobs(snapshot.Outbox)
+	sortSchedules(snapshot.Schedules)
+	sortTeamWebhooks(snapshot.TeamWebhooks)
+	sortPresenceRollups(snapshot.Rollups)
+	sortMessageRollups(snapshot.MessageRollups)
+	sortArchivedMessages(snapshot.Archive)
+	sort.Slice(snapshot.Preferences, func(i, j int) bool {
+		a, b := snapshot.Preferences[i], snapshot.Preferences[j]
+		return preferencesKey(a.TenantID, a.UserID) < preferencesKey(b.TenantID, b.UserID)
+	})
+	return snapshot
+}
+
+// load adds the records of a snapshot taken by dump.
+func (s *memoryStore) load(snapshot *memoryStoreSnapshot) {
+	ctx := context.Background()
+	for _, n := range snapshot.Notifications {
+		s.SaveNotification(ctx, n)
+	}
+	for _, job := range snapshot.Outbox {
+		s.SaveOutboxJob(ctx, job)
+	}
+	for _, schedule := range snapshot.Schedules {
+		s.SaveSchedule(ctx, schedule)
+	}
+	for _, webhook := range snapshot.TeamWebhooks {
+		s.SaveTeamWebhook(ctx, webhook)
+	}
+	for _, rollup := range snapshot.Rollups {
+		s.SavePresenceRollup(ctx, rollup)
+	}
+	for _, rollup := range snapshot.MessageRollups {
+		s.SaveMessageRollup(ctx, rollup)
+	}
+	for _, message := range snapshot.Archive {
+		s.ArchiveMessage(ctx, message)
+	}
+	for _, preferences := range snapshot.Preferences {
+		s.SaveUserPreferences(ctx, preferences)
+	}
+}
+
+// sortNotifications orders notifications oldest first, breaking ties by ID so
+// results are deterministic across drivers.
+func sortNotifications(notifications []*StoredNotification) {
+	sort.Slice(notifications, func(i, j int) bool {
+		if !notifications[i].CreatedAt.Equal(notifications[j].CreatedAt) {
+			return notifications[i].CreatedAt.Before(notifications[j].CreatedAt)
+		}
+		return notifications[i].ID() < notifications[j].ID()
+	})
+}
+
+// sortTeamWebhooks orders team webhooks oldest first, breaking ties by ID.
+func sortTeamWebhooks(webhooks []*TeamWebhook) {
+	sort.Slice(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+		}
+		return webhooks[i].ID < webhooks[j].ID
+	})
+}
+
+// sortSchedules orders schedules oldest first, breaking ties by ID.
+func sortSchedules(schedules []*ScheduledBroadcast) {
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].CreatedAt.Equal(schedules[j].CreatedAt) {
+			return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+}
+
+// sortOutboxJobs orders jobs oldest first, breaking ties by ID.
+func sortOutboxJobs(jobs []*OutboxJob) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+}
@@ -0,0 +1,494 @@
+// store_redis.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisStore keeps each user's notifications in a hash
+// (<prefix>:pending:<user>, field = notification ID) and indexes expiry times
+// in a sorted set (<prefix>:expiry) so pruning does not scan every user.
+// Webhook outbox jobs live in a single hash (<prefix>:outbox, field = job ID),
+// scheduled broadcasts in another (<prefix>:schedules, field = schedule ID),
+// presence rollups in one hash per period (<prefix>:presence:<period>) and
+// message rollups in another (<prefix>:messages:hour). Archived messages are
+// kept in a hash per notification ID (<prefix>:archive:<id>), with their
+// expiry times indexed in <prefix>:archive-expiry like pending ones. User
+// preferences live in a single hash (<prefix>:preferences).
+type redisStore struct {
+	client *redisClient
+	prefix string
+}
+
+func newRedisStore(client *redisClient, prefix string) *redisStore {
+	return &redisStore{client: client, prefix: strings.TrimSuffix(prefix, ":")}
+}
+
+func (s *redisStore) pendingKey(userID string) string {
+	return s.prefix + ":pending:" + userID
+}
+
+func (s *redisStore) expiryKey() string {
+	return s.prefix + ":expiry"
+}
+
+func (s *redisStore) outboxKey() string {
+	return s.prefix + ":outbox"
+}
+
+func (s *redisStore) schedulesKey() string {
+	return s.prefix + ":schedules"
+}
+
+func (s *redisStore) teamWebhooksKey() string {
+	return s.prefix + ":team-webhooks"
+}
+
+func (s *redisStore) rollupsKey(period string) string {
+	return s.prefix + ":presence:" + period
+}
+
+func (s *redisStore) messageRollupsKey() string {
+	return s.prefix + ":messages:" + rollupHour
+}
+
+func (s *redisStore) archiveKey(notificationID string) string {
+	return s.prefix + ":archive:" + notificationID
+}
+
+func (s *redisStore) archiveExpiryKey() string {
+	return s.prefix + ":archive-expiry"
+}
+
+func (s *redisStore) preferencesKey() string {
+	return s.prefix + ":preferences"
+}
+
+func expiryMember(userID, notificationID string) string {
+	return userID + "\n" + notificationID
+}
+
+func (s *redisStore) SaveNotification(_ context.Context, n *StoredNotification) error {
+	if n == nil || n.ID() == "" || n.UserID == "" {
+		return errors.New("notification id and user id are required")
+	}
+
+	encoded, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.Do("HSET", s.pendingKey(n.UserID), n.ID(), string(encoded)); err != nil {
+		return err
+	}
+	if !n.ExpiresAt.IsZero() {
+		score := strconv.FormatInt(n.ExpiresAt.UnixMilli(), 10)
+		if _, err := s.client.Do("ZADD", s.expiryKey(), score, expiryMember(n.UserID, n.ID())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) PendingFor(_ context.Context, userID string) ([]*StoredNotification, error) {
+	reply, err := s.client.Do("HVALS", s.pendingKey(userID))
+	if err != nil {
+		return nil, err
+	}
+	values, err := redisStrings(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	pending := make([]*StoredNotification, 0, len(values))
+	for _, value := range values {
+		var n StoredNotification
+		if err := json.Unmarshal([]byte(value), &n); err != nil {
+			return nil, err
+		}
+		if !n.DeliveredAt.IsZero() || (!n.ExpiresAt.IsZero() && n.ExpiresAt.Before(now)) {
+			continue
+		}
+		pending = append(pending, &n)
+	}
+
+	sortNotifications(pending)
+	return pending, nil
+}
+
+func (s *redisStore) MarkDelivered(ctx context.Context, userID, notificationID string) error {
+	reply, err := s.client.Do("HGET", s.pendingKey(userID), notificationID)
+	if err != nil || reply == nil {
+		return err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return errors.New("unexpected redis reply for stored notification")
+	}
+
+	var n StoredNotification
+	if err := json.Unmarshal([]byte(value), &n); err != nil {
+		return err
+	}
+	n.DeliveredAt = time.Now()
+	return s.SaveNotification(ctx, &n)
+}
+
+func (s *redisStore) PruneExpired(_ context.Context, now time.Time) (int, error) {
+	reply, err := s.client.Do("ZRANGEBYSCORE", s.expiryKey(), "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	if err != nil {
+		return 0, err
+	}
+	members, err := redisStrings(reply)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, member := range members {
+		userID, notificationID, ok := strings.Cut(member, "\n")
+		if !ok {
+			continue
+		}
+		removed, err := s.client.Do("HDEL", s.pendingKey(userID), notificationID)
+		if err != nil {
+			return pruned, err
+		}
+		if _, err := s.client.Do("ZREM", s.expiryKey(), member); err != nil {
+			return pruned, err
+		}
+		if count, ok := removed.(int64); ok && count > 0 {
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+func (s *redisStore) SaveOutboxJob(_ context.Context, job *OutboxJob) error {
+	if job == nil || job.ID == "" {
+		return errors.New("outbox job id is required")
+	}
+
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("HSET", s.outboxKey(), job.ID, string(encoded))
+	return err
+}
+
+func (s *redisStore) OutboxJob(_ context.Context, id string) (*OutboxJob, error) {
+	reply, err := s.client.Do("HGET", s.outboxKey(), id)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return nil, errors.New("unexpected redis reply for outbox job")
+	}
+
+	var job OutboxJob
+	if err := json.Unmarshal([]byte(value), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *redisStore) OutboxJobs(_ context.Context, status string) ([]*OutboxJob, error) {
+	reply, err := s.client.Do("HVALS", s.outboxKey())
+	if err != nil {
+		return nil, err
+	}
+	values, err := redisStrings(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*OutboxJob, 0, len(values))
+	for _, value := range values {
+		var job OutboxJob
+		if err := json.Unmarshal([]byte(value), &job); err != nil {
+			return nil, err
+		}
+		if status != "" && job.Status != status {
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+
+	sortOutboxJobs(jobs)
+	return jobs, nil
+}
+
+func (s *redisStore) DeleteOutboxJob(_ context.Context, id string) error {
+	_, err := s.client.Do("HDEL", s.outboxKey(), id)
+	return err
+}
+
+func (s *redisStore) SaveSchedule(_ context.Context, schedule *ScheduledBroadcast) error {
+	if schedule == nil || schedule.ID == "" {
+		return errors.New("schedule id is required")
+	}
+
+	encoded, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("HSET", s.schedulesKey(), schedule.ID, string(encoded))
+	return err
+}
+
+func (s *redisStore) Schedules(_ context.Context) ([]*ScheduledBroadcast, error) {
+	reply, err := s.client.Do("HVALS", s.schedulesKey())
+	if err != nil {
+		return nil, err
+	}
+	values, err := redisStrings(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]*ScheduledBroadcast, 0, len(values))
+	for _, value := range values {
+		var schedule ScheduledBroadcast
+		if err := json.Unmarshal([]byte(value), &schedule); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, &schedule)
+	}
+
+	sortSchedules(schedules)
+	return schedules, nil
+}
+
+func (s *redisStore) DeleteSchedule(_ context.Context, id string) error {
+	_, err := s.client.Do("HDEL", s.schedulesKey(), id)
+	return err
+}
+
+func (s *redisStore) SaveTeamWebhook(_ context.Context, webhook *TeamWebhook) error {
+	if webhook == nil || webhook.ID == "" {
+		return errors.New("webhook id is required")
+	}
+
+	encoded, err := json.Marshal(webhook)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("HSET", s.teamWebhooksKey(), webhook.ID, string(encoded))
+	return err
+}
+
+func (s *redisStore) TeamWebhooks(_ context.Context) ([]*TeamWebhook, error) {
+	reply, err := s.client.Do("HVALS", s.teamWebhooksKey())
+	if err != nil {
+		return nil, err
+	}
+	values, err := redisStrings(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]*TeamWebhook, 0, len(values))
+	for _, value := range values {
+		var webhook TeamWebhook
+		if err := json.Unmarshal([]byte(value), &webhook); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	sortTeamWebhooks(webhooks)
+	return webhooks, nil
+}
+
+func (s *redisStore) DeleteTeamWebhook(_ context.Context, id string) error {
+	_, err := s.client.Do("HDEL", s.teamWebhooksKey(), id)
+	return err
+}
+
+func (s *redisStore) SavePresenceRollup(_ context.Context, rollup *PresenceRollup) error {
+	if err := validPresenceRollup(rollup); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(rollup)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("HSET", s.rollupsKey(rollup.Period), rollup.key(), string(encoded))
+	return err
+}
+
+// PresenceRollups reads every rollup of the period and filters by start;
+// a period's hash holds a few rows per team and day.
+func (s *redisStore) PresenceRollups(_ context.Context, period string, from, to time.Time) ([]*PresenceRollup, error) {
+	reply, err := s.client.Do("HVALS", s.rollupsKey(period))
+	if err != nil {
+		return nil, err
+	}
+	values, err := redisStrings(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	rollups := make([]*PresenceRollup, 0, len(values))
+	for _, value := range values {
+		var rollup PresenceRollup
+		if err := json.Unmarshal([]byte(value), &rollup); err != nil {
+			return nil, err
+		}
+		if inRollupRange(&rollup, period, from, to) {
+			rollups = append(rollups, &rollup)
+		}
+	}
+
+	sortPresenceRollups(rollups)
+	return rollups, nil
+}
+
+func (s *redisStore) SaveMessageRollup(_ context.Context, rollup *MessageRollup) error {
+	if err := validMessageRollup(rollup); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(rollup)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("HSET", s.messageRollupsKey(), rollup.key(), string(encoded))
+	return err
+}
+
+func (s *redisStore) MessageRollups(_ context.Context, from, to time.Time) ([]*MessageRollup, error) {
+	reply, err := s.client.Do("HVALS", s.messageRollupsKey())
+	if err != nil {
+		return nil, err
+	}
+	values, err := redisStrings(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	rollups := make([]*MessageRollup, 0, len(values))
+	for _, value := range values {
+		var rollup MessageRollup
+		if err := json.Unmarshal([]byte(value), &rollup); err != nil {
+			return nil, err
+		}
+		if !rollup.Start.Before(from) && rollup.Start.Before(to) {
+			rollups = append(rollups, &rollup)
+		}
+	}
+
+	sortMessageRollups(rollups)
+	return rollups, nil
+}
+
+func (s *redisStore) ArchiveMessage(_ context.Context, message *ArchivedMessage) error {
+	if err := validArchivedMessage(message); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.Do("HSET", s.archiveKey(message.NotificationID), message.key(), string(encoded)); err != nil {
+		return err
+	}
+	_, err = s.client.Do("ZADD", s.archiveExpiryKey(), strconv.FormatInt(message.ExpiresAt.UnixMilli(), 10), message.key())
+	return err
+}
+
+func (s *redisStore) ArchivedMessages(_ context.Context, notificationID string) ([]*ArchivedMessage, error) {
+	reply, err := s.client.Do("HVALS", s.archiveKey(notificationID))
+	if err != nil {
+		return nil, err
+	}
+	values, err := redisStrings(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*ArchivedMessage, 0, len(values))
+	for _, value := range values {
+		var message ArchivedMessage
+		if err := json.Unmarshal([]byte(value), &message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, &message)
+	}
+
+	sortArchivedMessages(messages)
+	return messages, nil
+}
+
+func (s *redisStore) PruneArchive(_ context.Context, now time.Time) (int, error) {
+	reply, err := s.client.Do("ZRANGEBYSCORE", s.archiveExpiryKey(), "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	if err != nil {
+		return 0, err
+	}
+	members, err := redisStrings(reply)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, member := range members {
+		notificationID, _, ok := strings.Cut(member, "\n")
+		if !ok {
+			continue
+		}
+		removed, err := s.client.Do("HDEL", s.archiveKey(notificationID), member)
+		if err != nil {
+			return pruned, err
+		}
+		if _, err := s.client.Do("ZREM", s.archiveExpiryKey(), member); err != nil {
+			return pruned, err
+		}
+		if count, ok := removed.(int64); ok && count > 0 {
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+func (s *redisStore) SaveUserPreferences(_ context.Context, preferences *UserPreferences) error {
+	if preferences == nil || preferences.UserID == "" {
+		return errors.New("preferences user id is required")
+	}
+
+	encoded, err := json.Marshal(preferences)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("HSET", s.preferencesKey(), preferencesKey(preferences.TenantID, preferences.UserID), string(encoded))
+	return err
+}
+
+func (s *redisStore) UserPreferences(_ context.Context, tenantID, userID string) (*UserPreferences, error) {
+	reply, err := s.client.Do("HGET", s.preferencesKey(), preferencesKey(tenantID, userID))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return nil, errors.New("unexpected redis reply for user preferences")
+	}
+
+	var preferences UserPreferences
+	if err := json.Unmarshal([]byte(value), &preferences); err != nil {
+		return nil, err
+	}
+	return &preferences, nil
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
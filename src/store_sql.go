@@ -0,0 +1,590 @@
+// store_sql.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlStore persists notifications through database/sql. No driver is linked
+// into the default build; the binary must import one (for example a blank
+// import of a Postgres or SQLite driver) under the name in storage.sql.driver.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+func newSQLStore(driver, dsn string, autoMigrate bool) (*sqlStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sql store (is the %q driver linked into this build?): %v", driver, err)
+	}
+
+	store := &sqlStore{db: db, dialect: sqlDialect(driver)}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to sql store: %v", err)
+	}
+	if autoMigrate {
+		if _, err := store.Migrate(context.Background()); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Migrate brings the schema up to date with the embedded migrations.
+func (s *sqlStore) Migrate(ctx context.Context) (int, error) {
+	return newMigrator(s.db, s.rebind, time.Minute).Migrate(ctx)
+}
+
+// sqlDialect reports the placeholder style: "postgres" uses $1, $2, ...;
+// everything else uses ?.
+func sqlDialect(driver string) string {
+	switch driver {
+	case "postgres", "pgx":
+		return "postgres"
+	default:
+		return "generic"
+	}
+}
+
+// rebind rewrites ? placeholders for the store's dialect.
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != "postgres" {
+		return query
+	}
+
+	var out strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			out.WriteByte('$')
+			out.WriteString(strconv.Itoa(n))
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+func unixMilliOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func (s *sqlStore) SaveNotification(ctx context.Context, n *StoredNotification) error {
+	if n == nil || n.ID() == "" || n.UserID == "" {
+		return errors.New("notification id and user id are required")
+	}
+
+	payload, err := json.Marshal(n.Message)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM notifications WHERE user_id = ? AND notification_id = ?`), n.UserID, n.ID()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`INSERT INTO notifications (user_id, notification_id, payload, created_at, expires_at, delivered_at) VALUES (?, ?, ?, ?, ?, ?)`),
+		n.UserID, n.ID(), string(payload), unixMilliOrZero(n.CreatedAt), unixMilliOrZero(n.ExpiresAt), unixMilliOrZero(n.DeliveredAt),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) PendingFor(ctx context.Context, userID string) ([]*StoredNotification, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT payload, created_at, expires_at FROM notifications
+			WHERE user_id = ? AND delivered_at = 0 AND (expires_at = 0 OR expires_at >= ?)
+			ORDER BY created_at, notification_id`),
+		userID, time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []*StoredNotification
+	for rows.Next() {
+		var payload string
+		var createdAt, expiresAt int64
+		if err := rows.Scan(&payload, &createdAt, &expiresAt); err != nil {
+			return nil, err
+		}
+
+		n := &StoredNotification{UserID: userID, CreatedAt: time.UnixMilli(createdAt)}
+		if expiresAt != 0 {
+			n.ExpiresAt = time.UnixMilli(expiresAt)
+		}
+		if err := json.Unmarshal([]byte(payload), &n.Message); err != nil {
+			return nil, err
+		}
+		pending = append(pending, n)
+	}
+	return pending, rows.Err()
+}
+
+func (s *sqlStore) MarkDelivered(ctx context.Context, userID, notificationID string) error {
+	_, err := s.db.ExecContext(ctx,
+		s.rebind(`UPDATE notifications SET delivered_at = ? WHERE user_id = ? AND notification_id = ?`),
+		time.Now().UnixMilli(), userID, notificationID,
+	)
+	return err
+}
+
+func (s *sqlStore) PruneExpired(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx,
+		s.rebind(`DELETE FROM notifications WHERE expires_at <> 0 AND expires_at < ?`),
+		now.UnixMilli(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// SaveOutboxJob stores the job as JSON, with its status and creation time in
+// their own columns for listing.
+func (s *sqlStore) SaveOutboxJob(ctx context.Context, job *OutboxJob) error {
+	if job == nil || job.ID == "" {
+		return errors.New("outbox job id is required")
+	}
+
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM webhook_outbox WHERE job_id = ?`), job.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`INSERT INTO webhook_outbox (job_id, status, job, created_at) VALUES (?, ?, ?, ?)`),
+		job.ID, job.Status, string(encoded), unixMilliOrZero(job.CreatedAt),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) OutboxJob(ctx context.Context, id string) (*OutboxJob, error) {
+	var encoded string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT job FROM webhook_outbox WHERE job_id = ?`), id).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job OutboxJob
+	if err := json.Unmarshal([]byte(encoded), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *sqlStore) OutboxJobs(ctx context.Context, status string) ([]*OutboxJob, error) {
+	query := `SELECT job FROM webhook_outbox ORDER BY created_at, job_id`
+	var args []interface{}
+	if status != "" {
+		query = `SELECT job FROM webhook_outbox WHERE status = ? ORDER BY created_at, job_id`
+		args = append(args, status)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*OutboxJob
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		var job OutboxJob
+		if err := json.Unmarshal([]byte(encoded), &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *sqlStore) DeleteOutboxJob(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM webhook_outbox WHERE job_id = ?`), id)
+	return err
+}
+
+// SaveSchedule stores the schedule as JSON, with its creation time in its
+// own column for listing.
+func (s *sqlStore) SaveSchedule(ctx context.Context, schedule *ScheduledBroadcast) error {
+	if schedule == nil || schedule.ID == "" {
+		return errors.New("schedule id is required")
+	}
+
+	encoded, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM broadcast_schedules WHERE schedule_id = ?`), schedule.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`INSERT INTO broadcast_schedules (schedule_id, schedule, created_at) VALUES (?, ?, ?)`),
+		schedule.ID, string(encoded), unixMilliOrZero(schedule.CreatedAt),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Schedules(ctx context.Context) ([]*ScheduledBroadcast, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT schedule FROM broadcast_schedules ORDER BY created_at, schedule_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*ScheduledBroadcast
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		var schedule ScheduledBroadcast
+		if err := json.Unmarshal([]byte(encoded), &schedule); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, &schedule)
+	}
+	return schedules, rows.Err()
+}
+
+func (s *sqlStore) DeleteSchedule(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM broadcast_schedules WHERE schedule_id = ?`), id)
+	return err
+}
+
+// SaveTeamWebhook stores the webhook as JSON, with its creation time in its
+// own column for listing.
+func (s *sqlStore) SaveTeamWebhook(ctx context.Context, webhook *TeamWebhook) error {
+	if webhook == nil || webhook.ID == "" {
+		return errors.New("webhook id is required")
+	}
+
+	encoded, err := json.Marshal(webhook)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM team_webhooks WHERE webhook_id = ?`), webhook.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`INSERT INTO team_webhooks (webhook_id, webhook, created_at) VALUES (?, ?, ?)`),
+		webhook.ID, string(encoded), unixMilliOrZero(webhook.CreatedAt),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) TeamWebhooks(ctx context.Context) ([]*TeamWebhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT webhook FROM team_webhooks ORDER BY created_at, webhook_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*TeamWebhook
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		var webhook TeamWebhook
+		if err := json.Unmarshal([]byte(encoded), &webhook); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *sqlStore) DeleteTeamWebhook(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM team_webhooks WHERE webhook_id = ?`), id)
+	return err
+}
+
+// SavePresenceRollup stores the rollup as JSON, keyed by its period, start,
+// instance, tenant and team.
+func (s *sqlStore) SavePresenceRollup(ctx context.Context, rollup *PresenceRollup) error {
+	if err := validPresenceRollup(rollup); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(rollup)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`DELETE FROM presence_rollups WHERE period = ? AND period_start = ? AND instance = ? AND tenant_id = ? AND team_id = ?`),
+		rollup.Period, rollup.Start.Unix(), rollup.Instance, rollup.TenantID, rollup.TeamID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`INSERT INTO presence_rollups (period, period_start, instance, tenant_id, team_id, rollup) VALUES (?, ?, ?, ?, ?, ?)`),
+		rollup.Period, rollup.Start.Unix(), rollup.Instance, rollup.TenantID, rollup.TeamID, string(encoded),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) PresenceRollups(ctx context.Context, period string, from, to time.Time) ([]*PresenceRollup, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT rollup FROM presence_rollups WHERE period = ? AND period_start >= ? AND period_start < ?
+			ORDER BY period_start, tenant_id, team_id, instance`),
+		period, from.Unix(), to.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollups []*PresenceRollup
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		var rollup PresenceRollup
+		if err := json.Unmarshal([]byte(encoded), &rollup); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, &rollup)
+	}
+	return rollups, rows.Err()
+}
+
+// SaveMessageRollup stores the rollup as JSON, keyed by its hour, instance,
+// tenant, team and message type.
+func (s *sqlStore) SaveMessageRollup(ctx context.Context, rollup *MessageRollup) error {
+	if err := validMessageRollup(rollup); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(rollup)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`DELETE FROM message_rollups WHERE period_start = ? AND instance = ? AND tenant_id = ? AND team_id = ? AND message_type = ?`),
+		rollup.Start.Unix(), rollup.Instance, rollup.TenantID, rollup.TeamID, rollup.MessageType,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`INSERT INTO message_rollups (period_start, instance, tenant_id, team_id, message_type, rollup) VALUES (?, ?, ?, ?, ?, ?)`),
+		rollup.Start.Unix(), rollup.Instance, rollup.TenantID, rollup.TeamID, rollup.MessageType, string(encoded),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) MessageRollups(ctx context.Context, from, to time.Time) ([]*MessageRollup, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT rollup FROM message_rollups WHERE period_start >= ? AND period_start < ?
+			ORDER BY period_start, tenant_id, team_id, message_type, instance`),
+		from.Unix(), to.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollups []*MessageRollup
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		var rollup MessageRollup
+		if err := json.Unmarshal([]byte(encoded), &rollup); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, &rollup)
+	}
+	return rollups, rows.Err()
+}
+
+// ArchiveMessage stores the entry as JSON, with its notification ID and
+// expiry time in their own columns for lookups and pruning.
+func (s *sqlStore) ArchiveMessage(ctx context.Context, message *ArchivedMessage) error {
+	if err := validArchivedMessage(message); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		s.rebind(`INSERT INTO archived_messages (notification_id, archived_at, expires_at, entry) VALUES (?, ?, ?, ?)`),
+		message.NotificationID, message.ArchivedAt.UnixNano(), message.ExpiresAt.UnixMilli(), string(encoded),
+	)
+	return err
+}
+
+func (s *sqlStore) ArchivedMessages(ctx context.Context, notificationID string) ([]*ArchivedMessage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT entry FROM archived_messages WHERE notification_id = ? ORDER BY archived_at`),
+		notificationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*ArchivedMessage
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		var message ArchivedMessage
+		if err := json.Unmarshal([]byte(encoded), &message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, &message)
+	}
+	return messages, rows.Err()
+}
+
+func (s *sqlStore) PruneArchive(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx,
+		s.rebind(`DELETE FROM archived_messages WHERE expires_at < ?`),
+		now.UnixMilli(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// SaveUserPreferences stores the preferences as JSON, keyed by tenant and
+// user.
+func (s *sqlStore) SaveUserPreferences(ctx context.Context, preferences *UserPreferences) error {
+	if preferences == nil || preferences.UserID == "" {
+		return errors.New("preferences user id is required")
+	}
+
+	encoded, err := json.Marshal(preferences)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`DELETE FROM user_preferences WHERE tenant_id = ? AND user_id = ?`),
+		preferences.TenantID, preferences.UserID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		s.rebind(`INSERT INTO user_preferences (tenant_id, user_id, preferences) VALUES (?, ?, ?)`),
+		preferences.TenantID, preferences.UserID, string(encoded),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) UserPreferences(ctx context.Context, tenantID, userID string) (*UserPreferences, error) {
+	var encoded string
+	err := s.db.QueryRowContext(ctx,
+		s.rebind(`SELECT preferences FROM user_preferences WHERE tenant_id = ? AND user_id = ?`),
+		tenantID, userID,
+	).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var preferences UserPreferences
+	if err := json.Unmarshal([]byte(encoded), &preferences); err != nil {
+		return nil, err
+	}
+	return &preferences, nil
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,25 @@
+// store_sql_sqlite_test.go
+
+//go:build cgo
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The SQLite driver needs cgo, so without it the sql store is left out of
+// the conformance tests.
+func init() {
+	sqlTestStore = func(t *testing.T) Store {
+		t.Helper()
+		store, err := newSQLStore("sqlite3", filepath.Join(t.TempDir(), "store.db"), true)
+		if err != nil {
+			t.Fatalf("failed to open the sqlite store: %v", err)
+		}
+		return store
+	}
+}
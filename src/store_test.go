@@ -0,0 +1,512 @@
+// store_test.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a tiny in-process RESP server implementing just the commands
+// the redis-backed components use.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	hashes   map[string]map[string]string
+	zsets    map[string]map[string]float64
+	values   map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := &fakeRedis{
+		listener: listener,
+		hashes:   make(map[string]map[string]string),
+		zsets:    make(map[string]map[string]float64),
+		values:   make(map[string]string),
+	}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (f *fakeRedis) address() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		reply, err := readRESPReply(reader)
+		if err != nil {
+			return
+		}
+		args, _ := redisStrings(reply)
+		conn.Write([]byte(f.exec(args)))
+	}
+}
+
+func respBulk(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+func respArray(values []string) string {
+	out := "*" + strconv.Itoa(len(values)) + "\r\n"
+	for _, value := range values {
+		out += respBulk(value)
+	}
+	return out
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING", "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		value, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return respBulk(value)
+	case "INCRBY", "DECRBY":
+		count, _ := strconv.Atoi(f.values[args[1]])
+		by, _ := strconv.Atoi(args[2])
+		if strings.ToUpper(args[0]) == "INCRBY" {
+			count += by
+		} else {
+			count -= by
+		}
+		f.values[args[1]] = strconv.Itoa(count)
+		return ":" + strconv.Itoa(count) + "\r\n"
+	case "PEXPIRE":
+		return ":1\r\n"
+	case "HSET":
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = make(map[string]string)
+		}
+		f.hashes[args[1]][args[2]] = args[3]
+		return ":1\r\n"
+	case "HGET":
+		value, ok := f.hashes[args[1]][args[2]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return respBulk(value)
+	case "HVALS":
+		values := make([]string, 0)
+		for _, value := range f.hashes[args[1]] {
+			values = append(values, value)
+		}
+		return respArray(values)
+	case "HDEL":
+		if _, ok := f.hashes[args[1]][args[2]]; !ok {
+			return ":0\r\n"
+		}
+		delete(f.hashes[args[1]], args[2])
+		return ":1\r\n"
+	case "ZADD":
+		if f.zsets[args[1]] == nil {
+			f.zsets[args[1]] = make(map[string]float64)
+		}
+		score, _ := strconv.ParseFloat(args[2], 64)
+		f.zsets[args[1]][args[3]] = score
+		return ":1\r\n"
+	case "ZREM":
+		delete(f.zsets[args[1]], args[2])
+		return ":1\r\n"
+	case "ZRANGEBYSCORE":
+		max, _ := strconv.ParseFloat(args[3], 64)
+		members := make([]string, 0)
+		for member, score := range f.zsets[args[1]] {
+			if score <= max {
+				members = append(members, member)
+			}
+		}
+		sort.Strings(members)
+		return respArray(members)
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+// sqlTestStore opens a migrated sql store for the conformance tests, or is
+// nil when no database/sql driver is linked into the test binary.
+var sqlTestStore func(t *testing.T) Store
+
+func storeDrivers(t *testing.T) map[string]Store {
+	redis := newFakeRedis(t)
+	drivers := map[string]Store{
+		"memory": newMemoryStore(),
+		"redis":  newRedisStore(newRedisClient(redis.address(), "secret", 1, time.Second), "test"),
+	}
+	if sqlTestStore != nil {
+		drivers["sql"] = sqlTestStore(t)
+	}
+	return drivers
+}
+
+func TestStores_PendingLifecycle(t *testing.T) {
+	for name, store := range storeDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			ctx := context.Background()
+			now := time.Now()
+
+			notifications := []*StoredNotification{
+				{Message: Message{NotificationID: "n-2", Body: "second"}, UserID: "user-1", CreatedAt: now.Add(time.Second), ExpiresAt: now.Add(time.Hour)},
+				{Message: Message{NotificationID: "n-1", Body: "first"}, UserID: "user-1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+				{Message: Message{NotificationID: "n-old", Body: "expired"}, UserID: "user-1", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+				{Message: Message{NotificationID: "n-3", Body: "other user"}, UserID: "user-2", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+			}
+			for _, n := range notifications {
+				if err := store.SaveNotification(ctx, n); err != nil {
+					t.Fatalf("SaveNotification(%s) failed: %v", n.ID(), err)
+				}
+			}
+
+			pending, err := store.PendingFor(ctx, "user-1")
+			if err != nil {
+				t.Fatalf("PendingFor failed: %v", err)
+			}
+			if len(pending) != 2 || pending[0].ID() != "n-1" || pending[1].ID() != "n-2" {
+				t.Fatalf("expected pending [n-1 n-2], got %+v", pending)
+			}
+			if pending[0].Message.Body != "first" {
+				t.Fatalf("expected stored body to round-trip, got %q", pending[0].Message.Body)
+			}
+
+			if err := store.MarkDelivered(ctx, "user-1", "n-1"); err != nil {
+				t.Fatalf("MarkDelivered failed: %v", err)
+			}
+			pending, _ = store.PendingFor(ctx, "user-1")
+			if len(pending) != 1 || pending[0].ID() != "n-2" {
+				t.Fatalf("expected only n-2 pending after delivery, got %+v", pending)
+			}
+
+			pruned, err := store.PruneExpired(ctx, now)
+			if err != nil {
+				t.Fatalf("PruneExpired failed: %v", err)
+			}
+			if pruned != 1 {
+				t.Fatalf("expected 1 expired notification pruned, got %d", pruned)
+			}
+		})
+	}
+}
+
+func TestStores_RejectMissingIdentifiers(t *testing.T) {
+	for name, store := range storeDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			err := store.SaveNotification(context.Background(), &StoredNotification{UserID: "user-1"})
+			if err == nil {
+				t.Fatal("expected an error for a notification without an id")
+			}
+		})
+	}
+}
+
+func TestStores_OutboxLifecycle(t *testing.T) {
+	for name, store := range storeDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Millisecond)
+
+			jobs := []*OutboxJob{
+				{ID: "job-2", Kind: "abuse_ban", URL: "http://hooks.example/a", Payload: []byte(`{"n":2}`), Status: outboxFailed, CreatedAt: now.Add(time.Second)},
+				{ID: "job-1", Kind: "abuse_ban", URL: "http://hooks.example/a", Payload: []byte(`{"n":1}`), Headers: map[string]string{"X-Signature": "sig"}, Status: outboxPending, CreatedAt: now},
+				{ID: "job-3", Kind: "abuse_ban", URL: "http://hooks.example/a", Payload: []byte(`{"n":3}`), Status: outboxPending, CreatedAt: now.Add(2 * time.Second)},
+			}
+			for _, job := range jobs {
+				if err := store.SaveOutboxJob(ctx, job); err != nil {
+					t.Fatalf("SaveOutboxJob(%s) failed: %v", job.ID, err)
+				}
+			}
+
+			pending, err := store.OutboxJobs(ctx, outboxPending)
+			if err != nil {
+				t.Fatalf("OutboxJobs failed: %v", err)
+			}
+			if len(pending) != 2 || pending[0].ID != "job-1" || pending[1].ID != "job-3" {
+				t.Fatalf("expected pending [job-1 job-3], got %+v", pending)
+			}
+			if string(pending[0].Payload) != `{"n":1}` || pending[0].Headers["X-Signature"] != "sig" {
+				t.Fatalf("expected the job to round-trip, got %+v", pending[0])
+			}
+
+			all, _ := store.OutboxJobs(ctx, "")
+			if len(all) != 3 || all[0].ID != "job-1" || all[1].ID != "job-2" {
+				t.Fatalf("expected every job oldest first, got %+v", all)
+			}
+
+			if err := store.DeleteOutboxJob(ctx, "job-1"); err != nil {
+				t.Fatalf("DeleteOutboxJob failed: %v", err)
+			}
+			if job, err := store.OutboxJob(ctx, "job-1"); err != nil || job != nil {
+				t.Fatalf("expected job-1 to be gone, got %+v (err %v)", job, err)
+			}
+			if job, err := store.OutboxJob(ctx, "job-2"); err != nil || job == nil || job.Status != outboxFailed {
+				t.Fatalf("expected failed job-2, got %+v (err %v)", job, err)
+			}
+			if err := store.SaveOutboxJob(ctx, &OutboxJob{Kind: "abuse_ban"}); err == nil {
+				t.Fatal("expected an error for a job without an id")
+			}
+		})
+	}
+}
+
+func TestStores_ScheduleLifecycle(t *testing.T) {
+	for name, store := range storeDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Millisecond)
+
+			schedules := []*ScheduledBroadcast{
+				{ID: "sched-2", TeamID: "team1", Cron: "@daily", MessageType: "digest", Template: "b", CreatedAt: now.Add(time.Second)},
+				{ID: "sched-1", TeamID: "team1", Cron: "@hourly", MessageType: "digest", Template: "a", CreatedAt: now, NextRunAt: now.Add(time.Hour),
+					History: []ScheduleExecution{{ScheduledFor: now, FiredAt: now, Status: "delivered", Delivered: 3}}},
+			}
+			for _, schedule := range schedules {
+				if err := store.SaveSchedule(ctx, schedule); err != nil {
+					t.Fatalf("SaveSchedule(%s) failed: %v", schedule.ID, err)
+				}
+			}
+
+			all, err := store.Schedules(ctx)
+			if err != nil {
+				t.Fatalf("Schedules failed: %v", err)
+			}
+			if len(all) != 2 || all[0].ID != "sched-1" || all[1].ID != "sched-2" {
+				t.Fatalf("expected schedules oldest first, got %+v", all)
+			}
+			if !all[0].NextRunAt.Equal(now.Add(time.Hour)) || len(all[0].History) != 1 || all[0].History[0].Delivered != 3 {
+				t.Fatalf("expected the schedule to round-trip, got %+v", all[0])
+			}
+
+			if err := store.DeleteSchedule(ctx, "sched-1"); err != nil {
+				t.Fatalf("DeleteSchedule failed: %v", err)
+			}
+			if all, _ := store.Schedules(ctx); len(all) != 1 || all[0].ID != "sched-2" {
+				t.Fatalf("expected only sched-2 to remain, got %+v", all)
+			}
+			if err := store.SaveSchedule(ctx, &ScheduledBroadcast{TeamID: "team1"}); err == nil {
+				t.Fatal("expected an error for a schedule without an id")
+			}
+		})
+	}
+}
+
+func TestStores_TeamWebhookLifecycle(t *testing.T) {
+	for name, store := range storeDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Millisecond)
+
+			for _, webhook := range []*TeamWebhook{
+				{ID: "hook-2", TeamID: "team1", URL: "https://b.example.com", Events: []string{"delivery"}, Secret: "b", CreatedAt: now.Add(time.Second)},
+				{ID: "hook-1", TenantID: "acme", TeamID: "team1", URL: "https://a.example.com", Events: []string{"message", "presence"}, Secret: "a", CreatedAt: now},
+			} {
+				if err := store.SaveTeamWebhook(ctx, webhook); err != nil {
+					t.Fatalf("SaveTeamWebhook(%s) failed: %v", webhook.ID, err)
+				}
+			}
+
+			all, err := store.TeamWebhooks(ctx)
+			if err != nil {
+				t.Fatalf("TeamWebhooks failed: %v", err)
+			}
+			if len(all) != 2 || all[0].ID != "hook-1" || all[1].ID != "hook-2" {
+				t.Fatalf("expected webhooks oldest first, got %+v", all)
+			}
+			if all[0].TenantID != "acme" || all[0].Secret != "a" || len(all[0].Events) != 2 {
+				t.Fatalf("expected the webhook to round-trip, got %+v", all[0])
+			}
+
+			if err := store.DeleteTeamWebhook(ctx, "hook-1"); err != nil {
+				t.Fatalf("DeleteTeamWebhook failed: %v", err)
+			}
+			if all, _ := store.TeamWebhooks(ctx); len(all) != 1 || all[0].ID != "hook-2" {
+				t.Fatalf("expected only hook-2 to remain, got %+v", all)
+			}
+			if err := store.SaveTeamWebhook(ctx, &TeamWebhook{TeamID: "team1"}); err == nil {
+				t.Fatal("expected an error for a webhook without an id")
+			}
+		})
+	}
+}
+
+func TestStores_PresenceRollups(t *testing.T) {
+	for name, store := range storeDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			ctx := context.Background()
+			hour := time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC)
+
+			for _, rollup := range []*PresenceRollup{
+				{Instance: "node-1", TeamID: "team-b", Period: rollupHour, Start: hour, PeakUsers: 1},
+				{Instance: "node-1", TeamID: "team-a", Period: rollupHour, Start: hour.Add(time.Hour), PeakUsers: 2},
+				{Instance: "node-1", TeamID: "team-a", Period: rollupHour, Start: hour, PeakUsers: 3},
+				{Instance: "node-1", TeamID: "team-a", Period: rollupHour, Start: hour, PeakUsers: 4}, // replaces the previous one
+				{Instance: "node-1", TeamID: "team-a", Period: rollupDay, Start: hour.Truncate(24 * time.Hour), PeakUsers: 5},
+			} {
+				if err := store.SavePresenceRollup(ctx, rollup); err != nil {
+					t.Fatalf("SavePresenceRollup failed: %v", err)
+				}
+			}
+
+			rollups, err := store.PresenceRollups(ctx, rollupHour, hour, hour.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("PresenceRollups failed: %v", err)
+			}
+			if len(rollups) != 2 || rollups[0].TeamID != "team-a" || rollups[0].PeakUsers != 4 || rollups[1].TeamID != "team-b" {
+				t.Fatalf("expected the replaced team-a and team-b rollups of the first hour, got %+v", rollups)
+			}
+			if !rollups[0].Start.Equal(hour) {
+				t.Fatalf("expected the start to round-trip, got %v", rollups[0].Start)
+			}
+			if err := store.SavePresenceRollup(ctx, &PresenceRollup{TeamID: "team-a", Period: "week", Start: hour}); err == nil {
+				t.Fatal("expected an error for an unknown period")
+			}
+		})
+	}
+}
+
+func TestStores_MessageRollups(t *testing.T) {
+	for name, store := range storeDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			ctx := context.Background()
+			hour := time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC)
+
+			for _, rollup := range []*MessageRollup{
+				{Instance: "node-1", TeamID: "team-a", MessageType: "chat", Start: hour.Add(time.Hour), Messages: 1},
+				{Instance: "node-1", TeamID: "team-a", MessageType: "chat", Start: hour, Messages: 2},
+				{Instance: "node-1", TeamID: "team-a", MessageType: "chat", Start: hour, Messages: 3, Bytes: 30}, // replaces the previous one
+				{Instance: "node-1", TeamID: "team-a", MessageType: "alert", Start: hour, Messages: 4},
+				{Instance: "node-1", MessageType: "alert", Start: hour, Messages: 5}, // a global broadcast
+			} {
+				if err := store.SaveMessageRollup(ctx, rollup); err != nil {
+					t.Fatalf("SaveMessageRollup failed: %v", err)
+				}
+			}
+			if err := store.SaveMessageRollup(ctx, &MessageRollup{TeamID: "team-a"}); err == nil {
+				t.Error("expected a rollup without a start to be rejected")
+			}
+
+			rollups, err := store.MessageRollups(ctx, hour, hour.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("MessageRollups failed: %v", err)
+			}
+			if len(rollups) != 3 || rollups[0].TeamID != "" || rollups[1].MessageType != "alert" || rollups[2].Messages != 3 || rollups[2].Bytes != 30 {
+				t.Fatalf("unexpected rollups: %+v", rollups)
+			}
+		})
+	}
+}
+
+func TestStores_ArchivedMessages(t *testing.T) {
+	for name, store := range storeDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			ctx := context.Background()
+			now := time.Now()
+
+			for _, message := range []*ArchivedMessage{
+				{NotificationID: "n-1", Outcome: firehoseUnrouted, Payload: `{"body":"retry"}`, ArchivedAt: now.Add(time.Second), ExpiresAt: now.Add(time.Hour)},
+				{NotificationID: "n-1", Outcome: firehoseRouted, Recipients: 2, Payload: `{"body":"first"}`, ArchivedAt: now, ExpiresAt: now.Add(time.Hour)},
+				{NotificationID: "n-2", Outcome: firehoseRouted, ArchivedAt: now, ExpiresAt: now.Add(-time.Minute)},
+			} {
+				if err := store.ArchiveMessage(ctx, message); err != nil {
+					t.Fatalf("ArchiveMessage failed: %v", err)
+				}
+			}
+			if err := store.ArchiveMessage(ctx, &ArchivedMessage{ArchivedAt: now}); err == nil {
+				t.Error("expected a message without a notification ID to be rejected")
+			}
+
+			archived, err := store.ArchivedMessages(ctx, "n-1")
+			if err != nil {
+				t.Fatalf("ArchivedMessages failed: %v", err)
+			}
+			if len(archived) != 2 || archived[0].Payload != `{"body":"first"}` || archived[0].Recipients != 2 || archived[1].Outcome != firehoseUnrouted {
+				t.Fatalf("expected both sends of n-1 oldest first, got %+v", archived)
+			}
+
+			pruned, err := store.PruneArchive(ctx, now)
+			if err != nil || pruned != 1 {
+				t.Fatalf("expected one expired message pruned, got %d, %v", pruned, err)
+			}
+			if expired, _ := store.ArchivedMessages(ctx, "n-2"); len(expired) != 0 {
+				t.Errorf("expected n-2 to be gone, got %+v", expired)
+			}
+		})
+	}
+}
+
+func TestStores_UserPreferences(t *testing.T) {
+	for name, store := range storeDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			ctx := context.Background()
+
+			if missing, err := store.UserPreferences(ctx, "", "alice"); err != nil || missing != nil {
+				t.Fatalf("expected no preferences yet, got %+v, %v", missing, err)
+			}
+			for _, preferences := range []*UserPreferences{
+				{UserID: "alice", Version: 1, Values: map[string]json.RawMessage{"theme": json.RawMessage(`"dark"`)}},
+				{UserID: "alice", Version: 2, Values: map[string]json.RawMessage{"theme": json.RawMessage(`"light"`)}}, // replaces the previous one
+				{TenantID: "acme", UserID: "alice", Version: 7},
+			} {
+				if err := store.SaveUserPreferences(ctx, preferences); err != nil {
+					t.Fatalf("SaveUserPreferences failed: %v", err)
+				}
+			}
+
+			preferences, err := store.UserPreferences(ctx, "", "alice")
+			if err != nil || preferences.Version != 2 || string(preferences.Values["theme"]) != `"light"` {
+				t.Fatalf("unexpected preferences: %+v, %v", preferences, err)
+			}
+			if tenant, _ := store.UserPreferences(ctx, "acme", "alice"); tenant == nil || tenant.Version != 7 {
+				t.Errorf("expected acme's alice to be kept apart, got %+v", tenant)
+			}
+		})
+	}
+}
+
+func TestSQLDialectRebind(t *testing.T) {
+	store := &sqlStore{dialect: sqlDialect("pgx")}
+	got := store.rebind(`UPDATE notifications SET delivered_at = ? WHERE user_id = ? AND notification_id = ?`)
+	expected := `UPDATE notifications SET delivered_at = $1 WHERE user_id = $2 AND notification_id = $3`
+	if got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
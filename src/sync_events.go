@@ -0,0 +1,64 @@
+// sync_events.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Actions a syncEvent may report.
+const (
+	syncActionRead      = "read"
+	syncActionDismissed = "dismissed"
+)
+
+// maxSyncIDLength bounds the IDs a client names in a syncEvent.
+const maxSyncIDLength = 256
+
+func init() {
+	registerClientFrame("syncEvent", func() clientFrame { return &SyncEventFrame{} }, handleSyncEventFrame)
+}
+
+func (f *SyncEventFrame) validate(c *Client) error {
+	if f.Action != syncActionRead && f.Action != syncActionDismissed {
+		return fmt.Errorf("action must be %s or %s", syncActionRead, syncActionDismissed)
+	}
+	if f.NotificationID == "" && f.ConversationID == "" {
+		return errors.New("notificationId or conversationId is required")
+	}
+	if len(f.NotificationID) > maxSyncIDLength || len(f.ConversationID) > maxSyncIDLength {
+		return fmt.Errorf("notificationId and conversationId must be at most %d bytes", maxSyncIDLength)
+	}
+	return nil
+}
+
+// handleSyncEventFrame relays a client's action to the user's other devices.
+// A read that names a conversation is also applied to its unread count when
+// conversations are tracked.
+func handleSyncEventFrame(c *Client, frame clientFrame) {
+	event := *frame.(*SyncEventFrame)
+	if event.Action == syncActionRead && event.ConversationID != "" && conversationReads != nil {
+		if _, err := conversationReads.markRead(c.teamID, c.userID, event.ConversationID, event.NotificationID); err != nil {
+			c.logger().Warn("Read not applied", "conversation", event.ConversationID, "error", err)
+		}
+	}
+	fanOutSyncEvent(c, event)
+}
+
+// fanOutSyncEvent queues event on the control queue of every other
+// connection of c's user in its tenant, whichever team they are in.
+func fanOutSyncEvent(c *Client, event SyncEventFrame) {
+	event.Type = "syncEvent"
+	event.ConnectionID = c.connID
+	event.Timestamp = time.Now().UnixMilli()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		c.logger().Error("Failed to encode sync event", "error", err)
+		return
+	}
+	relayed := c.hub.SendControlToUser(c.tenantID, c.userID, c, outboundMessage{payload: payload, tenantID: c.tenantID})
+	appMetrics.Count("sync.events", 1, tenantTags(c.tenantID, metricTag("action", event.Action))...)
+	appMetrics.Count("sync.relayed", int64(relayed), tenantTags(c.tenantID)...)
+}
@@ -0,0 +1,70 @@
+// sync_events_test.go
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSyncEvents_RelayedToOtherDevices(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	newClient := func(tenantID, teamID, userID, connID string) *Client {
+		c := &Client{hub: hub, tenantID: tenantID, teamID: teamID, userID: userID, connID: connID, control: make(chan outboundMessage, 4)}
+		hub.Register(c)
+		return c
+	}
+	phone := newClient("", "team-a", "alice", "phone")
+	laptop := newClient("", "team-b", "alice", "laptop")
+	bob := newClient("", "team-a", "bob", "bob")
+	otherTenant := newClient("acme", "acme/team-a", "alice", "acme")
+
+	if err := phone.handleFrame(websocket.TextMessage, []byte(`{"type":"syncEvent","action":"dismissed","notificationId":"n-1"}`)); err != nil {
+		t.Fatalf("expected the sync event to be accepted, got %v", err)
+	}
+	if len(phone.control) != 0 || len(bob.control) != 0 || len(otherTenant.control) != 0 {
+		t.Fatal("expected the event to reach only the user's other devices in the tenant")
+	}
+	var event SyncEventFrame
+	json.Unmarshal((<-laptop.control).payload, &event)
+	if event.Type != "syncEvent" || event.Action != syncActionDismissed || event.NotificationID != "n-1" || event.ConnectionID != "phone" || event.Timestamp == 0 {
+		t.Errorf("unexpected relayed event: %+v", event)
+	}
+
+	for frame, want := range map[string]string{
+		`{"type":"syncEvent","action":"starred","notificationId":"n-1"}`:                           "action must be",
+		`{"type":"syncEvent","action":"read"}`:                                                     "notificationId or conversationId",
+		`{"type":"syncEvent","action":"read","notificationId":"` + strings.Repeat("n", 300) + `"}`: "at most",
+	} {
+		if err := phone.handleFrame(websocket.TextMessage, []byte(frame)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %s to be rejected with %q, got %v", frame, want, err)
+		}
+	}
+}
+
+func TestSyncEvents_ReadReceiptsAreRelayed(t *testing.T) {
+	setupTestAppConfig()
+	conversationReads = newConversationIndex(10, 20)
+	defer func() { conversationReads = nil }()
+	hub := &syncHub{}
+	phone := &Client{hub: hub, teamID: "team-a", userID: "alice", connID: "phone", control: make(chan outboundMessage, 4)}
+	laptop := &Client{hub: hub, teamID: "team-a", userID: "alice", connID: "laptop", control: make(chan outboundMessage, 4)}
+	hub.Register(phone)
+	hub.Register(laptop)
+
+	conversationReads.recordSend("team-a", &Message{NotificationID: "b-1", SenderUserID: "bob", Body: "hi"}, true)
+	if err := phone.handleFrame(websocket.TextMessage, []byte(`{"type":"read","conversationId":"team"}`)); err != nil {
+		t.Fatalf("expected the read receipt to be accepted, got %v", err)
+	}
+	var event SyncEventFrame
+	json.Unmarshal((<-laptop.control).payload, &event)
+	if event.Action != syncActionRead || event.ConversationID != "team" {
+		t.Errorf("expected the read to be relayed, got %+v", event)
+	}
+	if views := conversationReads.list("team-a", "alice"); len(views) != 1 || views[0].Unread != 0 {
+		t.Errorf("expected the team conversation to be read, got %+v", views)
+	}
+}
@@ -0,0 +1,237 @@
+// tcp_listener.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errLineTooLong = errors.New("line exceeds the read limit")
+
+// lineConn carries websocket frames as newline-delimited JSON over a plain TCP
+// or TLS stream, for server-side consumers that do not speak WebSocket. Every
+// frame is one line. Empty lines are heartbeats: the server sends one instead
+// of a ping, and an empty line from the client counts as the pong.
+type lineConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	limit  int64
+	pong   func(appData string) error
+
+	writeMu sync.Mutex
+}
+
+var _ Conn = (*lineConn)(nil)
+
+func newLineConn(conn net.Conn) *lineConn {
+	return &lineConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *lineConn) Close() error { return c.conn.Close() }
+
+// ReadMessage returns the next non-empty line as a text frame, without its
+// line ending.
+func (c *lineConn) ReadMessage() (int, []byte, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(line) > 0 {
+			return websocket.TextMessage, line, nil
+		}
+		if c.pong != nil {
+			if err := c.pong(""); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+}
+
+func (c *lineConn) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := c.reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if c.limit > 0 && int64(len(line)) > c.limit {
+			return nil, errLineTooLong
+		}
+		if !isPrefix {
+			return bytes.TrimSpace(line), nil
+		}
+	}
+}
+
+// WriteMessage writes data and a newline. Pings become empty lines, and close
+// frames are not sent: closing the stream ends it.
+func (c *lineConn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case websocket.CloseMessage, websocket.PongMessage:
+		return nil
+	case websocket.PingMessage:
+		data = nil
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(append(data[:len(data):len(data)], '\n'))
+	return err
+}
+
+func (c *lineConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &lineWriter{conn: c, messageType: messageType}, nil
+}
+
+func (c *lineConn) SetReadLimit(limit int64) { c.limit = limit }
+
+func (c *lineConn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+func (c *lineConn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+func (c *lineConn) SetPongHandler(h func(appData string) error) { c.pong = h }
+
+func (c *lineConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, data)
+}
+
+// lineWriter buffers one frame and writes it as a line on Close.
+type lineWriter struct {
+	conn        *lineConn
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *lineWriter) Close() error {
+	return w.conn.WriteMessage(w.messageType, w.buf.Bytes())
+}
+
+// newTCPListener listens on tcp.address, with TLS unless tcp.allow_plaintext
+// is set and no certificate is configured.
+func newTCPListener() (net.Listener, error) {
+	if AppConfig().TCP.CertFile == "" {
+		return net.Listen("tcp", AppConfig().TCP.Address)
+	}
+	reloader, err := newCertReloader("tcp", AppConfig().TCP.CertFile, AppConfig().TCP.KeyFile, "")
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", AppConfig().TCP.Address, reloader.tlsConfig())
+}
+
+// serveTCP accepts line protocol clients until listener is closed.
+func serveTCP(hub *Hub, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Error("TCP accept failed", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go handleTCPConnection(hub, conn)
+	}
+}
+
+// handleTCPConnection authenticates a line protocol client with the same auth
+// payload as a websocket, sent as its first line, and then streams the same
+// frames to it.
+func handleTCPConnection(hub *Hub, netConn net.Conn) {
+	// The auth checks take the client's address from a request; a stream has
+	// no headers, so there is no Origin to check either.
+	r := &http.Request{RemoteAddr: netConn.RemoteAddr().String(), Header: http.Header{}}
+	clientIP := clientIPFromRequest(r)
+	conn := newLineConn(netConn)
+
+	if drainMode.Load() {
+		writeWebSocketAuthError(conn, protocolJSONv1, "Server is draining")
+		conn.Close()
+		return
+	}
+	if _, banned := abuseGuard.bannedUntil(ipSubject(clientIP)); banned {
+		slog.Warn("Rejecting TCP connection from a banned address", "remote_ip", clientIP)
+		writeWebSocketAuthError(conn, protocolJSONv1, "Temporarily banned")
+		conn.Close()
+		return
+	}
+	if _, locked := authFailures.lockedUntil(ipFailureKey(clientIP)); locked {
+		slog.Warn("Rejecting TCP connection from a locked-out address", "remote_ip", clientIP)
+		writeWebSocketAuthError(conn, protocolJSONv1, "Too many failed authentication attempts")
+		conn.Close()
+		return
+	}
+
+	client := &Client{
+		hub:      hub,
+		conn:     conn,
+		send:     make(chan outboundMessage, AppConfig().Limits.SendChannelBuffer),
+		control:  make(chan outboundMessage, AppConfig().Limits.ControlChannelBuffer),
+		connID:   newConnectionID(),
+		protocol: protocolJSONv1,
+	}
+	registered := false
+	defer func() {
+		if !registered {
+			hub.releaseReservation(client)
+		}
+	}()
+
+	conn.SetReadLimit(AppConfig().WebSocket.AuthMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(AppConfig().WebSocket.ReadDeadline))
+
+	_, line, err := conn.ReadMessage()
+	if err != nil {
+		slog.Warn("Failed to read TCP auth line", "conn", client.connID, "error", err)
+		conn.Close()
+		return
+	}
+	authMsg, err := decodeAuthMessage(line)
+	if err != nil || authMsg.Type != "auth" {
+		slog.Warn("First TCP line is not an auth payload", "conn", client.connID, "remote_ip", clientIP)
+		writeWebSocketAuthError(conn, client.protocol, "First line must be an auth payload")
+		conn.Close()
+		return
+	}
+	if rejection := authenticateConnection(hub, r, client, authMsg, "", nil); rejection != nil {
+		writeWebSocketAuthError(conn, client.protocol, rejection.message)
+		conn.Close()
+		return
+	}
+
+	if err := queueAuthSuccess(context.Background(), client); err != nil {
+		client.logger().Error("Failed to queue authSuccess", "error", err)
+		conn.Close()
+		return
+	}
+	if err := hub.RegisterAndWait(context.Background(), client); err != nil {
+		client.logger().Error("Failed to register client", "error", err)
+		conn.Close()
+		return
+	}
+	registered = true
+	startClient(hub, client, authMsg.ResumeToken)
+
+	client.logger().Info("New TCP connection")
+}
@@ -0,0 +1,106 @@
+// tcp_listener_test.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func dialTCPTestServer(t *testing.T, hub *Hub) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go serveTCP(hub, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func readTCPFrame(t *testing.T, reader *bufio.Reader) map[string]interface{} {
+	t.Helper()
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("failed to read a line: %v", err)
+	}
+	var frame map[string]interface{}
+	if err := json.Unmarshal(line, &frame); err != nil {
+		t.Fatalf("expected a JSON line, got %q", line)
+	}
+	return frame
+}
+
+func TestTCPListener_StreamsNotifications(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Environment.Mode = "development"
+	AppConfig().Environment.EnableFakeAuth = true
+	authFailures = nil
+	hub := newHub()
+	go hub.run()
+
+	conn, reader := dialTCPTestServer(t, hub)
+	if _, err := conn.Write([]byte(`{"type":"auth","teamId":"team-t","userId":"daemon","token":"fake_development_token"}` + "\n")); err != nil {
+		t.Fatalf("failed to send auth: %v", err)
+	}
+	if frame := readTCPFrame(t, reader); frame["type"] != "authSuccess" {
+		t.Fatalf("expected authSuccess, got %v", frame)
+	}
+
+	if hub.getTotalClientCount() != 1 {
+		t.Fatal("expected the client to be registered before authSuccess")
+	}
+	message := NewMessage("n1", "team-t", "daemon", "system", "build", "done", false)
+	payload, _ := message.ToJSON()
+	if hub.sendToUser("team-t", "daemon", outboundMessage{payload: payload, teamID: "team-t", notificationID: "n1"}) != 1 {
+		t.Fatal("expected the notification to be queued")
+	}
+	if frame := readTCPFrame(t, reader); frame["notificationId"] != "n1" || frame["body"] != "done" {
+		t.Fatalf("unexpected notification line: %v", frame)
+	}
+
+	// An empty line is a heartbeat, not a message.
+	if _, err := conn.Write([]byte("\n")); err != nil {
+		t.Fatalf("failed to send a heartbeat: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	clients := hub.snapshotAllClients()
+	if len(clients) != 1 {
+		t.Fatal("expected the heartbeat to keep the connection open")
+	}
+
+	// Closing the stream ends both pumps.
+	conn.Close()
+	for deadline := time.Now().Add(time.Second); clients[0].readPumpAlive.Load() || clients[0].writePumpAlive.Load(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the pumps to exit")
+		}
+	}
+}
+
+func TestTCPListener_RejectsMissingAuth(t *testing.T) {
+	setupTestAppConfig()
+	authFailures = nil
+	hub := newHub()
+	go hub.run()
+
+	conn, reader := dialTCPTestServer(t, hub)
+	if _, err := conn.Write([]byte(`{"type":"subscribe"}` + "\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if frame := readTCPFrame(t, reader); frame["type"] != "auth_error" {
+		t.Fatalf("expected auth_error, got %v", frame)
+	}
+	if _, err := reader.ReadByte(); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,163 @@
+// team_check.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTeamCheckEntries bounds the team existence cache; it is cleared when full.
+const maxTeamCheckEntries = 10000
+
+// maxPathTeamIDLength bounds the team ID accepted in /ws/{teamId}.
+const maxPathTeamIDLength = 128
+
+var errTeamCheckUnavailable = errors.New("team validation unavailable")
+
+// teamDirectory answers whether a team exists for /ws/{teamId} connections.
+// It is nil when backend.team_check_path is not configured.
+var teamDirectory *teamChecker
+
+// teamChecker asks the backend whether a team exists and caches the answer,
+// both positive and negative, so unauthenticated upgrade attempts cannot turn
+// into a backend request each.
+type teamChecker struct {
+	backends *backendPool
+	path     string
+	ttl      time.Duration
+	client   *http.Client
+
+	mu      sync.Mutex
+	entries map[string]teamCheckEntry
+}
+
+type teamCheckEntry struct {
+	exists  bool
+	expires time.Time
+}
+
+func newTeamChecker(backends *backendPool, path string, ttl time.Duration, client *http.Client) *teamChecker {
+	return &teamChecker{
+		backends: backends,
+		path:     path,
+		ttl:      ttl,
+		client:   client,
+		entries:  make(map[string]teamCheckEntry),
+	}
+}
+
+// exists reports whether the backend knows teamID in tenantID. A nil checker
+// accepts every team.
+func (c *teamChecker) exists(tenantID, teamID string) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+
+	key := tenantID + tenantTeamSeparator + teamID
+	now := time.Now()
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expires) {
+		c.mu.Unlock()
+		return entry.exists, nil
+	}
+	c.mu.Unlock()
+
+	var exists bool
+	err := c.backends.call(func(baseURL string) error {
+		var err error
+		exists, err = c.lookup(baseURL, tenantID, teamID)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", errTeamCheckUnavailable, err)
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxTeamCheckEntries {
+		c.entries = make(map[string]teamCheckEntry)
+	}
+	c.entries[key] = teamCheckEntry{exists: exists, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return exists, nil
+}
+
+func (c *teamChecker) lookup(baseURL, tenantID, teamID string) (bool, error) {
+	path := strings.NewReplacer("{teamId}", url.PathEscape(teamID), "{tenantId}", url.PathEscape(tenantID)).Replace(c.path)
+	req, err := http.NewRequest(http.MethodGet, baseURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-API-Key", AppConfig().Security.APIKey)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return false, markCircuitBreakerFailure(err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return true, nil
+	case res.StatusCode == http.StatusNotFound:
+		return false, nil
+	case res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests:
+		return false, markCircuitBreakerFailure(fmt.Errorf("unexpected status %d", res.StatusCode))
+	default:
+		return false, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+}
+
+// pathTeamID extracts teamId from /ws/{teamId}. It returns "" for plain /ws.
+func pathTeamID(path string) (string, error) {
+	teamID, ok := strings.CutPrefix(path, "/ws/")
+	if !ok {
+		return "", nil
+	}
+	if teamID == "" || strings.Contains(teamID, "/") || len(teamID) > maxPathTeamIDLength {
+		return "", errors.New("invalid team ID in path")
+	}
+	return teamID, nil
+}
+
+// precheckPathTeam validates a /ws/{teamId} connection before the upgrade, so
+// a client learns about an unknown or full team from the HTTP status instead
+// of a closed socket. It writes the error response and returns false on
+// rejection.
+func precheckPathTeam(hub *Hub, w http.ResponseWriter, tenant *TenantConfig, teamID string) bool {
+	if teamID == statsTeamID {
+		if tenant != nil {
+			http.Error(w, "Unknown team", http.StatusNotFound)
+			return false
+		}
+		return true
+	}
+
+	scopedTeam, err := scopeTeam(tenantIDOf(tenant), teamID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	exists, err := teamDirectory.exists(tenantIDOf(tenant), teamID)
+	switch {
+	case errors.Is(err, errTeamCheckUnavailable):
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Team validation unavailable", http.StatusServiceUnavailable)
+		return false
+	case !exists:
+		http.Error(w, "Unknown team", http.StatusNotFound)
+		return false
+	}
+
+	if reason := admitClient(hub, tenant, scopedTeam); reason != "" {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+// team_check_test.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPathTeamID(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"/ws", "", false},
+		{"/ws/team-123", "team-123", false},
+		{"/ws/", "", true},
+		{"/ws/team-123/extra", "", true},
+		{"/ws/" + strings.Repeat("t", maxPathTeamIDLength+1), "", true},
+	}
+	for _, tt := range tests {
+		got, err := pathTeamID(tt.path)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Fatalf("pathTeamID(%q) = %q, %v", tt.path, got, err)
+		}
+	}
+}
+
+func TestTeamCheckerExists(t *testing.T) {
+	setupTestAppConfig()
+
+	var requests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("X-API-Key") != "test-api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/teams/acme/team-1/":
+			w.WriteHeader(http.StatusOK)
+		case "/teams/acme/broken/":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer backend.Close()
+
+	checker := newTeamChecker(newBackendPool([]string{backend.URL}, false), "/teams/{tenantId}/{teamId}/", time.Minute, backend.Client())
+
+	tests := []struct {
+		team    string
+		want    bool
+		wantErr bool
+	}{
+		{"team-1", true, false},
+		{"missing", false, false},
+		{"broken", false, true},
+	}
+	for _, tt := range tests {
+		got, err := checker.exists("acme", tt.team)
+		if got != tt.want || errors.Is(err, errTeamCheckUnavailable) != tt.wantErr {
+			t.Fatalf("exists(%q) = %t, %v", tt.team, got, err)
+		}
+	}
+
+	before := requests.Load()
+	for _, team := range []string{"team-1", "missing"} {
+		if _, err := checker.exists("acme", team); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if requests.Load() != before {
+		t.Fatal("expected cached answers for known teams")
+	}
+
+	var nilChecker *teamChecker
+	if ok, err := nilChecker.exists("", "anything"); !ok || err != nil {
+		t.Fatal("a nil checker should accept every team")
+	}
+}
+
+func TestHandleWebSocketPathTeam(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().Server.AllowedOrigins = []string{"https://app.example"}
+	AppConfig().Limits.MaxClientsPerTeam = 1
+	authFailures = nil
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "missing") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	teamDirectory = newTeamChecker(newBackendPool([]string{backend.URL}, false), "/teams/{teamId}/", time.Minute, backend.Client())
+	defer func() { teamDirectory = nil }()
+
+	hub := newHub()
+	full := &Client{teamID: "full", userID: "user-1"}
+	hub.clients["full"] = map[string]map[*Client]struct{}{"user-1": {full: {}}}
+
+	wsURL := newWebSocketTestServer(t, hub)
+
+	tests := []struct {
+		name       string
+		path       string
+		origin     string
+		wantStatus int
+	}{
+		{"known team", "/ws/team-1", "https://app.example", http.StatusSwitchingProtocols},
+		{"unknown team", "/ws/missing", "https://app.example", http.StatusNotFound},
+		{"full team", "/ws/full", "https://app.example", http.StatusServiceUnavailable},
+		{"origin not allowed", "/ws/team-1", "https://evil.example", http.StatusForbidden},
+		{"nested path", "/ws/team-1/x", "https://app.example", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL+tt.path, http.Header{"Origin": {tt.origin}})
+			if resp == nil {
+				t.Fatalf("no handshake response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+
+			// The auth frame must agree with the team in the path.
+			if err := conn.WriteJSON(AuthMessage{Type: "auth", TeamID: "team-2", Token: "tok"}); err != nil {
+				t.Fatalf("failed to send auth: %v", err)
+			}
+			var reply map[string]string
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if err := conn.ReadJSON(&reply); err != nil {
+				t.Fatalf("failed to read auth reply: %v", err)
+			}
+			if reply["type"] != "auth_error" || !strings.Contains(reply["message"], "team in the URL") {
+				t.Fatalf("expected a team mismatch error, got %v", reply)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// team_export.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// teamExportVersion is bumped whenever the export format changes
+// incompatibly; other versions are rejected on import.
+const teamExportVersion = 1
+
+// teamExport is a team's server-side configuration as GET /admin/teams/export
+// returns it and POST /admin/teams/import accepts it.
+type teamExport struct {
+	Version    int                  `json:"version"`
+	ExportedAt time.Time            `json:"exportedAt"`
+	TenantID   string               `json:"tenantId,omitempty"`
+	TeamID     string               `json:"teamId"` // as the tenant knows it
+	Retention  *teamRetentionExport `json:"retention,omitempty"`
+	Blackouts  []teamBlackoutExport `json:"blackouts"`
+	Schedules  []teamScheduleExport `json:"schedules"`
+	Limits     *teamLimitsExport    `json:"limits,omitempty"`
+}
+
+type teamRetentionExport struct {
+	ReplayBuffer           int   `json:"replayBuffer"`
+	OfflineQueueTTLSeconds int64 `json:"offlineQueueTtlSeconds"`
+	HistorySeconds         int64 `json:"historySeconds"`
+}
+
+// teamBlackoutExport is a blackout window that has not ended yet.
+type teamBlackoutExport struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+type teamScheduleExport struct {
+	Cron           string `json:"cron"`
+	Timezone       string `json:"timezone"`
+	MessageType    string `json:"messageType"`
+	Template       string `json:"template"`
+	ActionRequired bool   `json:"actionRequired"`
+	MisfirePolicy  string `json:"misfirePolicy"`
+}
+
+// teamLimitsExport reports the limits that come from the config file. They
+// are informational: importing does not change them.
+type teamLimitsExport struct {
+	MaxClients int `json:"maxClients"`
+}
+
+// exportTeam collects the configuration of tenantID's team teamID.
+func exportTeam(tenantID, teamID string, now time.Time) (teamExport, error) {
+	tenant, err := findTenant(tenantID)
+	if err != nil {
+		return teamExport{}, err
+	}
+	hubTeamID, err := scopeTeam(tenantID, teamID)
+	if err != nil {
+		return teamExport{}, err
+	}
+
+	export := teamExport{
+		Version:    teamExportVersion,
+		ExportedAt: now.UTC(),
+		TenantID:   tenantID,
+		TeamID:     teamID,
+		Blackouts:  []teamBlackoutExport{},
+		Schedules:  []teamScheduleExport{},
+		Limits:     &teamLimitsExport{MaxClients: teamClientLimit(tenant)},
+	}
+	if policy, ok := teamRetention.policy(hubTeamID); ok {
+		export.Retention = &teamRetentionExport{
+			ReplayBuffer:           policy.ReplayBuffer,
+			OfflineQueueTTLSeconds: int64(policy.OfflineQueueTTL / time.Second),
+			HistorySeconds:         int64(policy.History / time.Second),
+		}
+	}
+	if teamBlackouts != nil {
+		for _, window := range teamBlackouts.list(hubTeamID) {
+			if window.End.After(now) {
+				export.Blackouts = append(export.Blackouts, teamBlackoutExport{Start: window.Start, End: window.End, Reason: window.Reason})
+			}
+		}
+	}
+	if broadcastSchedules != nil {
+		for _, schedule := range broadcastSchedules.list(tenantID, teamID) {
+			export.Schedules = append(export.Schedules, teamScheduleExport{
+				Cron:           schedule.Cron,
+				Timezone:       schedule.Timezone,
+				MessageType:    schedule.MessageType,
+				Template:       schedule.Template,
+				ActionRequired: schedule.ActionRequired,
+				MisfirePolicy:  schedule.MisfirePolicy,
+			})
+		}
+	}
+	return export, nil
+}
+
+// teamImportResult is the answer to POST /admin/teams/import.
+type teamImportResult struct {
+	TenantID  string `json:"tenantId,omitempty"`
+	TeamID    string `json:"teamId"`
+	Retention bool   `json:"retention"`
+	Blackouts int    `json:"blackouts"`
+	Schedules int    `json:"schedules"`
+}
+
+// importTeam replaces the team's retention policy, blackout windows and
+// schedules with those in export. Everything is validated before anything
+// changes, so a rejected import leaves the team as it was.
+func importTeam(ctx context.Context, export teamExport, now time.Time) (teamImportResult, error) {
+	if export.Version != teamExportVersion {
+		return teamImportResult{}, fmt.Errorf("unsupported export version %d", export.Version)
+	}
+	export.TeamID = strings.TrimSpace(export.TeamID)
+	if export.TeamID == "" {
+		return teamImportResult{}, errors.New("teamId is required")
+	}
+	if _, err := findTenant(export.TenantID); err != nil {
+		return teamImportResult{}, err
+	}
+	hubTeamID, err := scopeTeam(export.TenantID, export.TeamID)
+	if err != nil {
+		return teamImportResult{}, err
+	}
+
+	if retention := export.Retention; retention != nil {
+		if retention.ReplayBuffer < 0 || retention.OfflineQueueTTLSeconds < 0 || retention.HistorySeconds < 0 {
+			return teamImportResult{}, errors.New("retention values must not be negative")
+		}
+	}
+	for i, window := range export.Blackouts {
+		if !window.End.After(window.Start) {
+			return teamImportResult{}, fmt.Errorf("blackouts[%d]: end must be after start", i)
+		}
+	}
+	records := make([]ScheduledBroadcast, 0, len(export.Schedules))
+	for i, schedule := range export.Schedules {
+		record := ScheduledBroadcast{
+			TenantID:       export.TenantID,
+			TeamID:         export.TeamID,
+			Cron:           strings.TrimSpace(schedule.Cron),
+			Timezone:       firstNonEmpty(strings.TrimSpace(schedule.Timezone), "UTC"),
+			MessageType:    strings.TrimSpace(schedule.MessageType),
+			Template:       schedule.Template,
+			ActionRequired: schedule.ActionRequired,
+			MisfirePolicy:  firstNonEmpty(strings.TrimSpace(schedule.MisfirePolicy), misfireFireOnce),
+		}
+		if _, err := compileSchedule(record); err != nil {
+			return teamImportResult{}, fmt.Errorf("schedules[%d]: %v", i, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) > 0 && broadcastSchedules == nil {
+		return teamImportResult{}, errors.New("the export has schedules, but scheduled broadcasts are not enabled")
+	}
+
+	result := teamImportResult{TenantID: export.TenantID, TeamID: export.TeamID}
+	if retention := export.Retention; retention != nil && teamRetention != nil {
+		teamRetention.set(TeamRetention{
+			TeamID:          hubTeamID,
+			ReplayBuffer:    retention.ReplayBuffer,
+			OfflineQueueTTL: time.Duration(retention.OfflineQueueTTLSeconds) * time.Second,
+			History:         time.Duration(retention.HistorySeconds) * time.Second,
+		})
+		result.Retention = true
+	} else if teamRetention != nil {
+		teamRetention.remove(hubTeamID)
+	}
+
+	if teamBlackouts != nil {
+		for _, window := range teamBlackouts.list(hubTeamID) {
+			teamBlackouts.remove(window.ID)
+		}
+		for _, window := range export.Blackouts {
+			if !window.End.After(now) {
+				continue
+			}
+			if _, err := teamBlackouts.add(blackoutWindow{TeamID: hubTeamID, Start: window.Start, End: window.End, Reason: window.Reason}); err == nil {
+				result.Blackouts++
+			}
+		}
+	}
+
+	if broadcastSchedules != nil {
+		for _, schedule := range broadcastSchedules.list(export.TenantID, export.TeamID) {
+			if err := broadcastSchedules.remove(ctx, schedule.ID); err != nil && !errors.Is(err, errScheduleNotFound) {
+				return result, fmt.Errorf("failed to remove schedule %s: %w", schedule.ID, err)
+			}
+		}
+		for _, record := range records {
+			if _, err := broadcastSchedules.add(ctx, record); err != nil {
+				return result, fmt.Errorf("failed to add a schedule: %w", err)
+			}
+			result.Schedules++
+		}
+	}
+	return result, nil
+}
+
+// handleAdminTeamExport returns a team's configuration for
+// handleAdminTeamImport on another deployment.
+func handleAdminTeamExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	teamID := strings.TrimSpace(query.Get("teamId"))
+	if teamID == "" {
+		http.Error(w, "teamId is required", http.StatusBadRequest)
+		return
+	}
+	export, err := exportTeam(strings.TrimSpace(query.Get("tenantId")), teamID, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, export)
+}
+
+// handleAdminTeamImport applies an export. ?tenantId= and ?teamId= import it
+// into another team than the one it was exported from.
+func handleAdminTeamImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var export teamExport
+	if err := decodeJSONBody(w, r, &export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	if teamID := strings.TrimSpace(query.Get("teamId")); teamID != "" {
+		export.TeamID = teamID
+		export.TenantID = strings.TrimSpace(query.Get("tenantId"))
+	}
+
+	result, err := importTeam(r.Context(), export, time.Now())
+	if err != nil {
+		if result.TeamID != "" {
+			// Validation passed, so the store failed part way through.
+			slog.Error("Failed to import a team", "team", result.TeamID, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("Imported a team", "team", result.TeamID, "retention", result.Retention, "blackouts", result.Blackouts, "schedules", result.Schedules)
+	recordAudit(auditEvent{Action: "teams.import", Subject: result.TeamID, Details: map[string]string{
+		"tenant":    result.TenantID,
+		"blackouts": strconv.Itoa(result.Blackouts),
+		"schedules": strconv.Itoa(result.Schedules),
+	}})
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,99 @@
+// team_export_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTeamExport_RoundTrip(t *testing.T) {
+	setupTestAppConfig()
+	now := time.Now()
+	broadcastSchedules, _ = newTestScheduler(t, newMemoryStore(), &now)
+	teamBlackouts = newBlackoutSchedule(nil, 10)
+	teamRetention = newRetentionTable(nil)
+	defer func() {
+		broadcastSchedules = nil
+		teamBlackouts = nil
+		teamRetention = nil
+	}()
+
+	teamRetention.set(TeamRetention{TeamID: "staging", ReplayBuffer: 5, History: time.Hour})
+	teamBlackouts.add(blackoutWindow{TeamID: "staging", Start: now, End: now.Add(time.Hour), Reason: "release"})
+	teamBlackouts.add(blackoutWindow{TeamID: "staging", Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)})
+	if _, err := broadcastSchedules.add(context.Background(), ScheduledBroadcast{TeamID: "staging", Cron: "0 9 * * *", Timezone: "UTC", MessageType: "standup", Template: "Standup", MisfirePolicy: misfireSkip}); err != nil {
+		t.Fatalf("failed to add a schedule: %v", err)
+	}
+	// The target team already has a schedule, which the import replaces.
+	if _, err := broadcastSchedules.add(context.Background(), ScheduledBroadcast{TeamID: "production", Cron: "0 * * * *", Timezone: "UTC", MessageType: "old", Template: "Old", MisfirePolicy: misfireSkip}); err != nil {
+		t.Fatalf("failed to add a schedule: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleAdminTeamExport(rec, httptest.NewRequest(http.MethodGet, "/admin/teams/export?teamId=staging", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var export teamExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &export); err != nil {
+		t.Fatalf("invalid export: %v", err)
+	}
+	if export.Retention == nil || export.Retention.ReplayBuffer != 5 || len(export.Blackouts) != 1 || len(export.Schedules) != 1 {
+		t.Fatalf("unexpected export: %s", rec.Body.String())
+	}
+
+	body := rec.Body.String()
+	rec = httptest.NewRecorder()
+	handleAdminTeamImport(rec, httptest.NewRequest(http.MethodPost, "/admin/teams/import?teamId=production", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if policy, ok := teamRetention.policy("production"); !ok || policy.ReplayBuffer != 5 || policy.History != time.Hour {
+		t.Fatalf("expected the retention policy to be imported, got %+v", policy)
+	}
+	if windows := teamBlackouts.list("production"); len(windows) != 1 || windows[0].Reason != "release" {
+		t.Fatalf("expected the open blackout to be imported, got %+v", windows)
+	}
+	if schedules := broadcastSchedules.list("", "production"); len(schedules) != 1 || schedules[0].MessageType != "standup" {
+		t.Fatalf("expected the schedules to be replaced, got %+v", schedules)
+	}
+}
+
+func TestTeamImport_RejectsInvalidExports(t *testing.T) {
+	setupTestAppConfig()
+	teamBlackouts = newBlackoutSchedule(nil, 10)
+	teamRetention = newRetentionTable(nil)
+	defer func() {
+		teamBlackouts = nil
+		teamRetention = nil
+	}()
+	teamRetention.set(TeamRetention{TeamID: "team1", ReplayBuffer: 3})
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"wrong version", `{"version":2,"teamId":"team1","blackouts":[],"schedules":[]}`},
+		{"missing team", `{"version":1,"blackouts":[],"schedules":[]}`},
+		{"bad blackout", `{"version":1,"teamId":"team1","blackouts":[{"start":"2025-01-10T16:00:00Z","end":"2025-01-10T15:00:00Z"}],"schedules":[]}`},
+		{"schedules disabled", `{"version":1,"teamId":"team1","blackouts":[],"schedules":[{"cron":"0 9 * * *","messageType":"standup","template":"Standup"}]}`},
+		{"unknown field", `{"version":1,"teamId":"team1","preferences":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleAdminTeamImport(rec, httptest.NewRequest(http.MethodPost, "/admin/teams/import", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if policy, _ := teamRetention.policy("team1"); policy.ReplayBuffer != 3 {
+				t.Fatal("expected a rejected import to change nothing")
+			}
+		})
+	}
+}
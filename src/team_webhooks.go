@@ -0,0 +1,365 @@
+// team_webhooks.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events a team webhook can subscribe to.
+const (
+	teamEventPresence = "presence" // a user's first connection opened or last one closed
+	teamEventDelivery = "delivery" // /send handled a notification for the team
+	teamEventMessage  = "message"  // a team member's client sent a frame
+)
+
+// teamWebhookKind tags team webhook jobs. They go to endpoints the teams
+// chose, so they are signed with the webhook's secret instead of carrying
+// the server's API key.
+const teamWebhookKind = "team_webhook"
+
+var (
+	errTeamWebhookNotFound = errors.New("webhook not found")
+	errTeamWebhookLimit    = errors.New("the team has registered the maximum number of webhooks")
+)
+
+// teamWebhooks is nil unless webhooks.max_per_team is set, and all methods
+// are nil-safe.
+var teamWebhooks *teamWebhookRegistry
+
+// teamWebhookEvent is the body of a team webhook delivery. Type is
+// userJoined or userLeft for presence, the /send outcome for delivery and
+// the frame type for message.
+type teamWebhookEvent struct {
+	Event          string          `json:"event"`
+	Type           string          `json:"type"`
+	TenantID       string          `json:"tenantId,omitempty"`
+	TeamID         string          `json:"teamId"`
+	UserID         string          `json:"userId,omitempty"`
+	NotificationID string          `json:"notificationId,omitempty"`
+	MessageType    string          `json:"messageType,omitempty"`
+	Recipients     *int            `json:"recipients,omitempty"` // delivery only
+	Frame          json.RawMessage `json:"frame,omitempty"`      // message only
+	Time           time.Time       `json:"time"`
+}
+
+// teamWebhookRegistry holds the webhooks teams registered, indexed by scoped
+// team ID, and turns team events into jobs for the shared dispatcher.
+// Webhooks are kept in the store so they survive a restart.
+type teamWebhookRegistry struct {
+	store      Store
+	dispatcher *webhookDispatcher
+	maxPerTeam int
+	now        func() time.Time
+
+	mu     sync.RWMutex
+	byID   map[string]*TeamWebhook
+	byTeam map[string][]*TeamWebhook // oldest first
+}
+
+func newTeamWebhookRegistry(store Store, dispatcher *webhookDispatcher, maxPerTeam int) *teamWebhookRegistry {
+	return &teamWebhookRegistry{
+		store:      store,
+		dispatcher: dispatcher,
+		maxPerTeam: maxPerTeam,
+		now:        time.Now,
+		byID:       make(map[string]*TeamWebhook),
+		byTeam:     make(map[string][]*TeamWebhook),
+	}
+}
+
+// validateTeamWebhook checks a webhook and returns its scoped team ID. Its
+// events are sorted and deduplicated in place.
+func validateTeamWebhook(webhook *TeamWebhook) (string, error) {
+	if webhook.TeamID == "" {
+		return "", errors.New("teamId is required")
+	}
+	if webhook.TeamID == statsTeamID {
+		return "", errors.New("teamId " + statsTeamID + " is reserved")
+	}
+	if _, err := findTenant(webhook.TenantID); err != nil {
+		return "", err
+	}
+	teamID, err := scopeTeam(webhook.TenantID, webhook.TeamID)
+	if err != nil {
+		return "", err
+	}
+
+	parsed, err := url.Parse(webhook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", errors.New("url must be an absolute http or https URL")
+	}
+
+	if len(webhook.Events) == 0 {
+		return "", errors.New("events is required")
+	}
+	seen := make(map[string]struct{}, len(webhook.Events))
+	events := webhook.Events[:0]
+	for _, event := range webhook.Events {
+		switch event {
+		case teamEventPresence, teamEventDelivery, teamEventMessage:
+		default:
+			return "", fmt.Errorf("unknown event %q; events are %s, %s and %s", event, teamEventPresence, teamEventDelivery, teamEventMessage)
+		}
+		if _, ok := seen[event]; !ok {
+			seen[event] = struct{}{}
+			events = append(events, event)
+		}
+	}
+	sort.Strings(events)
+	webhook.Events = events
+	return teamID, nil
+}
+
+func newTeamWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// load restores the webhooks saved in the store.
+func (t *teamWebhookRegistry) load(ctx context.Context) (int, error) {
+	webhooks, err := t.store.TeamWebhooks(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, webhook := range webhooks {
+		teamID, err := validateTeamWebhook(webhook)
+		if err != nil {
+			slog.Error("Skipping a team webhook", "webhook", webhook.ID, "error", err)
+			continue
+		}
+		t.byID[webhook.ID] = webhook
+		t.byTeam[teamID] = append(t.byTeam[teamID], webhook)
+	}
+	return len(t.byID), nil
+}
+
+// add validates and saves a new webhook. A secret is generated when none is
+// given.
+func (t *teamWebhookRegistry) add(ctx context.Context, webhook TeamWebhook) (TeamWebhook, error) {
+	webhook.ID = newNotificationID()
+	webhook.CreatedAt = t.now().UTC()
+	webhook.Events = append([]string(nil), webhook.Events...)
+	teamID, err := validateTeamWebhook(&webhook)
+	if err != nil {
+		return TeamWebhook{}, err
+	}
+	if webhook.Secret == "" {
+		if webhook.Secret, err = newTeamWebhookSecret(); err != nil {
+			return TeamWebhook{}, err
+		}
+	}
+
+	// The lock is held across the save so concurrent registrations cannot
+	// both take the team's last place.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.byTeam[teamID]) >= t.maxPerTeam {
+		return TeamWebhook{}, errTeamWebhookLimit
+	}
+	if err := t.store.SaveTeamWebhook(ctx, &webhook); err != nil {
+		return TeamWebhook{}, err
+	}
+	stored := webhook
+	t.byID[webhook.ID] = &stored
+	t.byTeam[teamID] = append(t.byTeam[teamID], &stored)
+	return webhook, nil
+}
+
+func (t *teamWebhookRegistry) remove(ctx context.Context, id string) error {
+	t.mu.Lock()
+	webhook, ok := t.byID[id]
+	if ok {
+		delete(t.byID, id)
+		teamID, _ := scopeTeam(webhook.TenantID, webhook.TeamID)
+		remaining := make([]*TeamWebhook, 0, len(t.byTeam[teamID]))
+		for _, other := range t.byTeam[teamID] {
+			if other.ID != id {
+				remaining = append(remaining, other)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(t.byTeam, teamID)
+		} else {
+			t.byTeam[teamID] = remaining
+		}
+	}
+	t.mu.Unlock()
+	if !ok {
+		return errTeamWebhookNotFound
+	}
+	return t.store.DeleteTeamWebhook(ctx, id)
+}
+
+// list returns the webhooks, optionally only those for one tenant's team,
+// oldest first and without their secrets.
+func (t *teamWebhookRegistry) list(tenantID, teamID string) []TeamWebhook {
+	t.mu.RLock()
+	webhooks := make([]*TeamWebhook, 0, len(t.byID))
+	for _, webhook := range t.byID {
+		if teamID != "" && (webhook.TenantID != tenantID || webhook.TeamID != teamID) {
+			continue
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	t.mu.RUnlock()
+
+	sortTeamWebhooks(webhooks)
+	list := make([]TeamWebhook, len(webhooks))
+	for i, webhook := range webhooks {
+		list[i] = *webhook
+		list[i].Secret = ""
+	}
+	return list
+}
+
+// notify queues event for every webhook of the scoped team teamID that
+// subscribes to it. Each delivery carries an X-Webhook-Signature header with
+// the HMAC-SHA256 of the body under the webhook's secret.
+func (t *teamWebhookRegistry) notify(tenantID, teamID string, event teamWebhookEvent) {
+	if t == nil {
+		return
+	}
+	t.mu.RLock()
+	var targets []*TeamWebhook
+	for _, webhook := range t.byTeam[teamID] {
+		for _, subscribed := range webhook.Events {
+			if subscribed == event.Event {
+				targets = append(targets, webhook)
+				break
+			}
+		}
+	}
+	t.mu.RUnlock()
+	if len(targets) == 0 {
+		return
+	}
+
+	event.TenantID = tenantID
+	event.TeamID = unscopedTeamID(tenantID, teamID)
+	event.Time = t.now().UTC()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode a team webhook event", "event", event.Event, "team", teamID, "error", err)
+		return
+	}
+	for _, webhook := range targets {
+		t.dispatcher.enqueue(webhookJob{
+			Kind:    teamWebhookKind,
+			URL:     webhook.URL,
+			Payload: payload,
+			Headers: map[string]string{
+				"X-Webhook-Event":     event.Event,
+				"X-Webhook-Signature": "sha256=" + hex.EncodeToString(hmacSHA256([]byte(webhook.Secret), string(payload))),
+			},
+		})
+	}
+	appMetrics.Count("webhooks.team_events", int64(len(targets)), tenantTags(tenantID, metricTag("event", event.Event))...)
+}
+
+// notifyPresence reports a user's first connection to a team opening, or
+// their last one closing.
+func (t *teamWebhookRegistry) notifyPresence(client *Client, eventType string) {
+	if client.teamID == statsTeamID {
+		return
+	}
+	t.notify(client.tenantID, client.teamID, teamWebhookEvent{Event: teamEventPresence, Type: eventType, UserID: client.userID})
+}
+
+type teamWebhookRequest struct {
+	TenantID string   `json:"tenantId"`
+	TeamID   string   `json:"teamId"`
+	URL      string   `json:"url"`
+	Events   []string `json:"events"`
+	Secret   string   `json:"secret"`
+}
+
+// handleAdminTeamWebhooks lists team webhooks (GET, optionally filtered by
+// ?tenantId= and ?teamId=), registers one (POST) or removes one (DELETE
+// ?id=). The secret is returned only when the webhook is registered.
+func handleAdminTeamWebhooks(registry *teamWebhookRegistry, w http.ResponseWriter, r *http.Request) {
+	if registry == nil {
+		http.Error(w, "Team webhooks are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		writeJSONWithETag(w, r, map[string]interface{}{
+			"webhooks": registry.list(strings.TrimSpace(query.Get("tenantId")), strings.TrimSpace(query.Get("teamId"))),
+		})
+
+	case http.MethodPost:
+		var req teamWebhookRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events := make([]string, 0, len(req.Events))
+		for _, event := range req.Events {
+			events = append(events, strings.TrimSpace(event))
+		}
+		webhook, err := registry.add(r.Context(), TeamWebhook{
+			TenantID: strings.TrimSpace(req.TenantID),
+			TeamID:   strings.TrimSpace(req.TeamID),
+			URL:      strings.TrimSpace(req.URL),
+			Events:   events,
+			Secret:   req.Secret,
+		})
+		switch {
+		case errors.Is(err, errTeamWebhookLimit):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("Team webhook registered", "webhook", webhook.ID, "team", webhook.TeamID, "url", redactURL(webhook.URL))
+		recordAudit(auditEvent{Action: "webhooks.team.create", Subject: webhook.ID, Details: map[string]string{
+			"team":   webhook.TeamID,
+			"url":    redactURL(webhook.URL),
+			"events": strings.Join(webhook.Events, ","),
+		}})
+		writeJSON(w, http.StatusCreated, webhook)
+
+	case http.MethodDelete:
+		id := strings.TrimSpace(r.URL.Query().Get("id"))
+		if id == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		err := registry.remove(r.Context(), id)
+		switch {
+		case errors.Is(err, errTeamWebhookNotFound):
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		case err != nil:
+			slog.Error("Failed to delete a team webhook", "webhook", id, "error", err)
+			http.Error(w, "Failed to delete the webhook", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(auditEvent{Action: "webhooks.team.delete", Subject: id})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
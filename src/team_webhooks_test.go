@@ -0,0 +1,140 @@
+// team_webhooks_test.go
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTeamWebhookRegistry_AddValidatesAndLimits(t *testing.T) {
+	setupTestAppConfig()
+	store := newMemoryStore()
+	registry := newTeamWebhookRegistry(store, nil, 1)
+
+	for _, webhook := range []TeamWebhook{
+		{URL: "https://hooks.example.com", Events: []string{teamEventPresence}},
+		{TeamID: "team-1", URL: "ftp://hooks.example.com", Events: []string{teamEventPresence}},
+		{TeamID: "team-1", URL: "https://hooks.example.com"},
+		{TeamID: "team-1", URL: "https://hooks.example.com", Events: []string{"typing"}},
+		{TeamID: statsTeamID, URL: "https://hooks.example.com", Events: []string{teamEventPresence}},
+	} {
+		if _, err := registry.add(context.Background(), webhook); err == nil {
+			t.Errorf("expected %+v to be rejected", webhook)
+		}
+	}
+
+	added, err := registry.add(context.Background(), TeamWebhook{TeamID: "team-1", URL: "https://hooks.example.com", Events: []string{teamEventMessage, teamEventPresence, teamEventMessage}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added.Secret) != 64 || strings.Join(added.Events, ",") != "message,presence" {
+		t.Fatalf("expected a generated secret and sorted events, got %+v", added)
+	}
+	if _, err := registry.add(context.Background(), TeamWebhook{TeamID: "team-1", URL: "https://other.example.com", Events: []string{teamEventDelivery}}); !errors.Is(err, errTeamWebhookLimit) {
+		t.Fatalf("expected the team's limit to apply, got %v", err)
+	}
+
+	// Registered webhooks are loaded again after a restart.
+	restarted := newTeamWebhookRegistry(store, nil, 1)
+	if loaded, err := restarted.load(context.Background()); err != nil || loaded != 1 {
+		t.Fatalf("expected 1 webhook loaded, got %d %v", loaded, err)
+	}
+	if list := restarted.list("", "team-1"); len(list) != 1 || list[0].ID != added.ID || list[0].Secret != "" {
+		t.Fatalf("expected the webhook without its secret, got %+v", list)
+	}
+	if err := restarted.remove(context.Background(), added.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.remove(context.Background(), added.ID); !errors.Is(err, errTeamWebhookNotFound) {
+		t.Fatalf("expected a second remove to find nothing, got %v", err)
+	}
+}
+
+func TestTeamWebhookRegistry_NotifySignsSubscribedEvents(t *testing.T) {
+	setupTestAppConfig()
+	received := make(chan *http.Request, 4)
+	bodies := make(chan []byte, 4)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer backend.Close()
+
+	dispatcher := newWebhookDispatcher(backend.Client(), 8, 1, time.Millisecond, time.Millisecond)
+	startTestDispatcher(t, dispatcher, 1)
+	registry := newTeamWebhookRegistry(newMemoryStore(), dispatcher, 5)
+	if _, err := registry.add(context.Background(), TeamWebhook{TeamID: "team-1", URL: backend.URL, Events: []string{teamEventPresence}, Secret: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{teamID: "team-1", userID: "alice"}
+	registry.notify("", "team-1", teamWebhookEvent{Event: teamEventMessage, Type: "ack", UserID: "alice"})
+	registry.notify("", "team-2", teamWebhookEvent{Event: teamEventPresence, Type: "userJoined", UserID: "bob"})
+	registry.notifyPresence(client, "userJoined")
+
+	var request *http.Request
+	select {
+	case request = <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the presence event to be delivered")
+	}
+	body := <-bodies
+	var event teamWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != teamEventPresence || event.Type != "userJoined" || event.TeamID != "team-1" || event.UserID != "alice" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if want := "sha256=" + hex.EncodeToString(hmacSHA256([]byte("s3cret"), string(body))); request.Header.Get("X-Webhook-Signature") != want {
+		t.Errorf("expected signature %s, got %s", want, request.Header.Get("X-Webhook-Signature"))
+	}
+	if request.Header.Get("X-API-Key") != "" {
+		t.Error("expected the server's API key to stay off team webhooks")
+	}
+	select {
+	case <-received:
+		t.Fatal("expected only the subscribed team's event to be delivered")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHandleAdminTeamWebhooks(t *testing.T) {
+	setupTestAppConfig()
+	registry := newTeamWebhookRegistry(newMemoryStore(), nil, 5)
+
+	rr := httptest.NewRecorder()
+	handleAdminTeamWebhooks(registry, rr, httptest.NewRequest(http.MethodPost, "/admin/webhooks/teams", strings.NewReader(
+		`{"teamId":"team-1","url":"https://hooks.example.com/notify","events":["delivery"],"secret":"s3cret"}`)))
+	if rr.Code != http.StatusCreated || !strings.Contains(rr.Body.String(), `"secret":"s3cret"`) {
+		t.Fatalf("unexpected response: %d %s", rr.Code, rr.Body.String())
+	}
+	var created TeamWebhook
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	rr = httptest.NewRecorder()
+	handleAdminTeamWebhooks(registry, rr, httptest.NewRequest(http.MethodGet, "/admin/webhooks/teams?teamId=team-1", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), created.ID) || strings.Contains(rr.Body.String(), "s3cret") {
+		t.Fatalf("expected the webhook listed without its secret, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleAdminTeamWebhooks(registry, rr, httptest.NewRequest(http.MethodDelete, "/admin/webhooks/teams?id="+created.ID, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handleAdminTeamWebhooks(registry, rr, httptest.NewRequest(http.MethodDelete, "/admin/webhooks/teams?id="+created.ID, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
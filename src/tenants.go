@@ -0,0 +1,234 @@
+// tenants.go
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// TenantConfig is one customer application served by this deployment. A
+// tenant's teams live in their own namespace, so the same team ID in two
+// tenants never shares deliveries.
+type TenantConfig struct {
+	ID                string   `yaml:"id"`
+	APIKey            string   `yaml:"api_key" secret:"true"`
+	AllowedOrigins    []string `yaml:"allowed_origins"`      // Empty uses server.allowed_origins
+	MaxClientsPerTeam int      `yaml:"max_clients_per_team"` // 0 uses limits.max_clients_per_team
+	MaxClients        int      `yaml:"max_clients"`          // Across all of the tenant's teams; 0 is unlimited
+}
+
+// tenantTeamSeparator joins a tenant ID and team ID into the hub key of a
+// tenant's team, e.g. "acme/team-123".
+const tenantTeamSeparator = "/"
+
+var validTenantID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func validateTenants(config *Config) error {
+	seen := make(map[string]bool, len(config.Tenants))
+	keys := map[string]bool{config.Security.APIKey: true}
+	for i := range config.Tenants {
+		tenant := &config.Tenants[i]
+		tenant.ID = strings.TrimSpace(tenant.ID)
+		if !validTenantID.MatchString(tenant.ID) {
+			return fmt.Errorf("tenants[%d].id must be 1-64 letters, digits, '-' or '_'", i)
+		}
+		if seen[tenant.ID] {
+			return fmt.Errorf("tenants has duplicate id %q", tenant.ID)
+		}
+		seen[tenant.ID] = true
+		if tenant.APIKey == "" {
+			return fmt.Errorf("tenants[%d].api_key is required", i)
+		}
+		if keys[tenant.APIKey] {
+			return fmt.Errorf("tenants[%d].api_key must differ from security.api_key and other tenants' keys", i)
+		}
+		keys[tenant.APIKey] = true
+		if tenant.MaxClientsPerTeam < 0 || tenant.MaxClients < 0 {
+			return fmt.Errorf("tenants[%d] client limits must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// findTenant resolves the tenantId a websocket client asked for. An empty ID
+// selects the default namespace and returns nil.
+func findTenant(id string) (*TenantConfig, error) {
+	if id == "" {
+		return nil, nil
+	}
+	if AppConfig() != nil {
+		for i := range AppConfig().Tenants {
+			if AppConfig().Tenants[i].ID == id {
+				return &AppConfig().Tenants[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown tenant %q", id)
+}
+
+// tenantForAPIKey returns the tenant whose API key matches key.
+func tenantForAPIKey(key string) (*TenantConfig, bool) {
+	if AppConfig() == nil || key == "" {
+		return nil, false
+	}
+	for i := range AppConfig().Tenants {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(AppConfig().Tenants[i].APIKey)) == 1 {
+			return &AppConfig().Tenants[i], true
+		}
+	}
+	return nil, false
+}
+
+// scopeTeam returns the hub key for teamID inside tenantID. Team IDs may not
+// contain the separator once tenants are configured, so a default-namespace
+// client cannot name a tenant's team.
+func scopeTeam(tenantID, teamID string) (string, error) {
+	if AppConfig() != nil && len(AppConfig().Tenants) > 0 && strings.Contains(teamID, tenantTeamSeparator) {
+		return "", fmt.Errorf("team IDs must not contain %q", tenantTeamSeparator)
+	}
+	if tenantID == "" || teamID == "" {
+		return teamID, nil
+	}
+	return tenantID + tenantTeamSeparator + teamID, nil
+}
+
+// unscopedTeamID returns the team ID as the tenant's own clients know it.
+func unscopedTeamID(tenantID, teamID string) string {
+	if tenantID == "" {
+		return teamID
+	}
+	return strings.TrimPrefix(teamID, tenantID+tenantTeamSeparator)
+}
+
+func tenantIDOf(tenant *TenantConfig) string {
+	if tenant == nil {
+		return ""
+	}
+	return tenant.ID
+}
+
+// tenantTags labels a metric with the tenant; the default namespace is unlabelled.
+func tenantTags(tenantID string, tags ...string) []string {
+	if tenantID == "" {
+		return tags
+	}
+	return append(tags, metricTag("tenant", tenantID))
+}
+
+// originAllowedByAnyTenant reports whether some tenant lists origin, so the
+// upgrade and CORS checks accept it before the tenant is known.
+func originAllowedByAnyTenant(origin string) bool {
+	if AppConfig() == nil || origin == "" {
+		return false
+	}
+	for _, tenant := range AppConfig().Tenants {
+		if slices.Contains(tenant.AllowedOrigins, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// originAllowedForTenant applies the tenant's own origin list once a client
+// has said which tenant it belongs to.
+func originAllowedForTenant(tenant *TenantConfig, origin string) bool {
+	if origin == "" || ShouldAllowAllOrigins() {
+		return true
+	}
+	if tenant == nil || len(tenant.AllowedOrigins) == 0 {
+		return IsOriginAllowed(origin)
+	}
+	return slices.Contains(tenant.AllowedOrigins, origin)
+}
+
+// admitClient applies the team limit (the tenant's, if it sets one) and the
+// tenant-wide limit. It returns the rejection reason, or "" to admit. It only
+// checks; reserveClient also holds the place.
+func admitClient(hub *Hub, tenant *TenantConfig, teamID string) string {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return admitClientLocked(hub, tenant, teamID)
+}
+
+// reserveClient admits client to its team and holds the place until the hub
+// registers it or releaseReservation gives it back. Holding it closes the
+// window between the limit check and registration in which concurrent
+// handshakes could all pass the check and overshoot the limit.
+func reserveClient(hub *Hub, tenant *TenantConfig, client *Client) string {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if reason := admitClientLocked(hub, tenant, client.teamID); reason != "" {
+		return reason
+	}
+	hub.reserved[client.teamID]++
+	client.reserved = true
+	return ""
+}
+
+func admitClientLocked(hub *Hub, tenant *TenantConfig, teamID string) string {
+	if hub.teamLoadLocked(teamID) >= teamClientLimit(tenant) {
+		return "Team client limit reached"
+	}
+	if tenant != nil && tenant.MaxClients > 0 {
+		total := 0
+		prefix := tenant.ID + tenantTeamSeparator
+		for scopedTeam := range hub.clients {
+			if strings.HasPrefix(scopedTeam, prefix) {
+				total += hub.getTeamClientCountLocked(scopedTeam)
+			}
+		}
+		for scopedTeam, reserved := range hub.reserved {
+			if strings.HasPrefix(scopedTeam, prefix) {
+				total += reserved
+			}
+		}
+		if total >= tenant.MaxClients {
+			return "Tenant client limit reached"
+		}
+	}
+	return ""
+}
+
+// teamClientLimit returns how many clients each of tenant's teams may have.
+func teamClientLimit(tenant *TenantConfig) int {
+	if tenant != nil && tenant.MaxClientsPerTeam > 0 {
+		return tenant.MaxClientsPerTeam
+	}
+	return AppConfig().Limits.MaxClientsPerTeam
+}
+
+type tenantContextKey struct{}
+
+func withTenant(r *http.Request, tenant *TenantConfig) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, tenant))
+}
+
+// requestTenant returns the tenant whose API key authenticated r, or nil for
+// the operator key.
+func requestTenant(r *http.Request) *TenantConfig {
+	tenant, _ := r.Context().Value(tenantContextKey{}).(*TenantConfig)
+	return tenant
+}
+
+// sendTenantID picks the namespace a /send request delivers into. Tenant keys
+// are confined to their own tenant; the operator key may name any tenant.
+func sendTenantID(r *http.Request, req *MessageRequest) (string, error) {
+	if tenant := requestTenant(r); tenant != nil {
+		if req.TenantID != "" && req.TenantID != tenant.ID {
+			return "", errors.New("tenant_id does not match the API key's tenant")
+		}
+		return tenant.ID, nil
+	}
+	tenant, err := findTenant(req.TenantID)
+	if err != nil {
+		return "", err
+	}
+	return tenantIDOf(tenant), nil
+}
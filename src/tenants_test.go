@@ -0,0 +1,351 @@
+// tenants_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func setupTestTenants() {
+	setupTestAppConfig()
+	AppConfig().Tenants = []TenantConfig{
+		{ID: "acme", APIKey: "acme-key", AllowedOrigins: []string{"https://acme.example"}, MaxClientsPerTeam: 1},
+		{ID: "globex", APIKey: "globex-key", MaxClients: 2},
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []TenantConfig
+		wantErr string
+	}{
+		{"valid", []TenantConfig{{ID: "acme", APIKey: "a"}, {ID: "globex", APIKey: "b"}}, ""},
+		{"missing id", []TenantConfig{{APIKey: "a"}}, "tenants[0].id"},
+		{"separator in id", []TenantConfig{{ID: "acme/eu", APIKey: "a"}}, "tenants[0].id"},
+		{"duplicate id", []TenantConfig{{ID: "acme", APIKey: "a"}, {ID: "acme", APIKey: "b"}}, "duplicate id"},
+		{"missing key", []TenantConfig{{ID: "acme"}}, "api_key is required"},
+		{"reuses operator key", []TenantConfig{{ID: "acme", APIKey: "test-api-key"}}, "must differ"},
+		{"shared key", []TenantConfig{{ID: "acme", APIKey: "a"}, {ID: "globex", APIKey: "a"}}, "must differ"},
+		{"negative limit", []TenantConfig{{ID: "acme", APIKey: "a", MaxClients: -1}}, "must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestAppConfig()
+			AppConfig().Tenants = tt.tenants
+			err := validateTenants(AppConfig())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestScopeTeam(t *testing.T) {
+	setupTestTenants()
+
+	tests := []struct {
+		tenantID string
+		teamID   string
+		want     string
+		wantErr  bool
+	}{
+		{"", "team-1", "team-1", false},
+		{"acme", "team-1", "acme/team-1", false},
+		{"acme", "", "", false},
+		{"", "acme/team-1", "", true},
+		{"acme", "globex/team-1", "", true},
+	}
+	for _, tt := range tests {
+		got, err := scopeTeam(tt.tenantID, tt.teamID)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Fatalf("scopeTeam(%q, %q) = %q, %v", tt.tenantID, tt.teamID, got, err)
+		}
+	}
+}
+
+func TestHandleSendMessageIsolatesTenants(t *testing.T) {
+	setupTestTenants()
+	hub := newHub()
+
+	clients := map[string]*Client{}
+	for _, c := range []struct{ tenant, team string }{{"", "team-1"}, {"acme", "team-1"}, {"acme", "team-2"}, {"globex", "team-1"}} {
+		teamKey, _ := scopeTeam(c.tenant, c.team)
+		client := &Client{hub: hub, tenantID: c.tenant, teamID: teamKey, userID: "user-1", send: make(chan outboundMessage, 8)}
+		hub.clients[teamKey] = map[string]map[*Client]struct{}{"user-1": {client: {}}}
+		clients[teamKey] = client
+	}
+
+	tests := []struct {
+		name       string
+		tenant     *TenantConfig
+		body       string
+		wantStatus int
+		wantTeams  []string
+	}{
+		{"tenant team broadcast", &AppConfig().Tenants[0], `{"target_team_id":"team-1","message_type":"m","body":"x","broadcast":true}`, http.StatusOK, []string{"acme/team-1"}},
+		{"tenant global broadcast", &AppConfig().Tenants[0], `{"message_type":"m","body":"x","broadcast":true}`, http.StatusOK, []string{"acme/team-1", "acme/team-2"}},
+		{"tenant cross-team direct", &AppConfig().Tenants[1], `{"target_user_id":"user-1","message_type":"m","body":"x"}`, http.StatusOK, []string{"globex/team-1"}},
+		{"operator default namespace", nil, `{"target_team_id":"team-1","message_type":"m","body":"x","broadcast":true}`, http.StatusOK, []string{"team-1"}},
+		{"operator names tenant", nil, `{"tenant_id":"globex","message_type":"m","body":"x","broadcast":true}`, http.StatusOK, []string{"globex/team-1"}},
+		{"tenant names other tenant", &AppConfig().Tenants[0], `{"tenant_id":"globex","message_type":"m","body":"x","broadcast":true}`, http.StatusForbidden, nil},
+		{"unknown tenant", nil, `{"tenant_id":"initech","message_type":"m","body":"x","broadcast":true}`, http.StatusForbidden, nil},
+		{"scoped team id", &AppConfig().Tenants[0], `{"target_team_id":"globex/team-1","message_type":"m","body":"x","broadcast":true}`, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, client := range clients {
+				drainClientMessages(client)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(tt.body))
+			if tt.tenant != nil {
+				req = withTenant(req, tt.tenant)
+			}
+			rr := httptest.NewRecorder()
+			handleSendMessage(hub, rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			want := make(map[string]bool)
+			for _, team := range tt.wantTeams {
+				want[team] = true
+			}
+			for team, client := range clients {
+				if got := len(client.send) > 0; got != want[team] {
+					t.Fatalf("team %s received=%t, want %t", team, got, want[team])
+				}
+			}
+		})
+	}
+}
+
+func TestTenantAPIKeyMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		middleware func(http.HandlerFunc) http.HandlerFunc
+		apiKey     string
+		wantStatus int
+		wantTenant string
+	}{
+		{"operator key on send", tenantAPIKeyMiddleware, "test-api-key", http.StatusOK, ""},
+		{"tenant key on send", tenantAPIKeyMiddleware, "acme-key", http.StatusOK, "acme"},
+		{"unknown key on send", tenantAPIKeyMiddleware, "nope", http.StatusUnauthorized, ""},
+		{"tenant key on admin", apiKeyMiddleware, "acme-key", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestTenants()
+			authFailures = nil
+
+			var gotTenant string
+			handler := tt.middleware(func(w http.ResponseWriter, r *http.Request) {
+				gotTenant = tenantIDOf(requestTenant(r))
+				w.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/send", nil)
+			req.Header.Set("X-API-Key", tt.apiKey)
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			if rr.Code != tt.wantStatus || gotTenant != tt.wantTenant {
+				t.Fatalf("got status %d tenant %q, want %d %q", rr.Code, gotTenant, tt.wantStatus, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestAdmitClientTenantLimits(t *testing.T) {
+	setupTestTenants()
+	AppConfig().Limits.MaxClientsPerTeam = 5
+	hub := newHub()
+	acme, globex := &AppConfig().Tenants[0], &AppConfig().Tenants[1]
+
+	add := func(teamKey, userID string) {
+		if hub.clients[teamKey] == nil {
+			hub.clients[teamKey] = map[string]map[*Client]struct{}{}
+		}
+		hub.clients[teamKey][userID] = map[*Client]struct{}{{teamID: teamKey, userID: userID}: {}}
+	}
+	add("acme/team-1", "user-1")
+	add("globex/team-1", "user-1")
+	add("globex/team-2", "user-1")
+
+	tests := []struct {
+		name   string
+		tenant *TenantConfig
+		team   string
+		want   string
+	}{
+		{"tenant team limit", acme, "acme/team-1", "Team client limit reached"},
+		{"tenant other team", acme, "acme/team-2", ""},
+		{"tenant total limit", globex, "globex/team-3", "Tenant client limit reached"},
+		{"default namespace", nil, "team-1", ""},
+	}
+	for _, tt := range tests {
+		if got := admitClient(hub, tt.tenant, tt.team); got != tt.want {
+			t.Fatalf("%s: admitClient() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReserveClient_ConcurrentHandshakes(t *testing.T) {
+	setupTestTenants()
+	AppConfig().Limits.MaxClientsPerTeam = 5
+	hub := newHub()
+	go hub.run()
+
+	clients := make([]*Client, 20)
+	admitted := make(chan *Client, len(clients))
+	var wg sync.WaitGroup
+	for i := range clients {
+		clients[i] = &Client{hub: hub, teamID: "team-1", userID: "user", send: make(chan outboundMessage, 1)}
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			if reserveClient(hub, nil, client) == "" {
+				admitted <- client
+			}
+		}(clients[i])
+	}
+	wg.Wait()
+	close(admitted)
+
+	var held []*Client
+	for client := range admitted {
+		held = append(held, client)
+	}
+	if len(held) != 5 {
+		t.Fatalf("expected exactly 5 concurrent handshakes to be admitted, got %d", len(held))
+	}
+
+	// A failed handshake gives its place back; registering confirms one.
+	hub.releaseReservation(held[0])
+	registerAndWait(t, hub, held[1])
+	hub.releaseReservation(held[1])
+	if reason := reserveClient(hub, nil, &Client{teamID: "team-1"}); reason != "" {
+		t.Fatalf("expected the released place to be free, got %q", reason)
+	}
+	if reason := admitClient(hub, nil, "team-1"); reason != "Team client limit reached" {
+		t.Fatalf("expected the registered client to keep its place, got %q", reason)
+	}
+
+	// The tenant-wide limit counts reservations across the tenant's teams.
+	globex := &AppConfig().Tenants[1]
+	for _, team := range []string{"globex/team-1", "globex/team-2"} {
+		if reason := reserveClient(hub, globex, &Client{teamID: team}); reason != "" {
+			t.Fatalf("expected %s to be admitted, got %q", team, reason)
+		}
+	}
+	if reason := reserveClient(hub, globex, &Client{teamID: "globex/team-3"}); reason != "Tenant client limit reached" {
+		t.Fatalf("expected the tenant limit to count reservations, got %q", reason)
+	}
+}
+
+func TestOriginAllowedForTenant(t *testing.T) {
+	setupTestTenants()
+	AppConfig().Server.AllowedOrigins = []string{"https://app.example"}
+	acme, globex := &AppConfig().Tenants[0], &AppConfig().Tenants[1]
+
+	tests := []struct {
+		name   string
+		tenant *TenantConfig
+		origin string
+		want   bool
+	}{
+		{"tenant origin", acme, "https://acme.example", true},
+		{"global origin for tenant with own list", acme, "https://app.example", false},
+		{"tenant without list uses global", globex, "https://app.example", true},
+		{"default namespace rejects tenant origin", nil, "https://acme.example", false},
+		{"no origin", acme, "", true},
+	}
+	for _, tt := range tests {
+		if got := originAllowedForTenant(tt.tenant, tt.origin); got != tt.want {
+			t.Fatalf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+	if !originAllowedByAnyTenant("https://acme.example") || originAllowedByAnyTenant("https://evil.example") {
+		t.Fatal("originAllowedByAnyTenant should accept only listed tenant origins")
+	}
+}
+
+func TestHandleWebSocketTenantOriginHint(t *testing.T) {
+	setupTestTenants()
+	AppConfig().Server.AllowedOrigins = []string{"https://app.example"}
+	hub := newHub()
+	wsURL := newWebSocketTestServer(t, hub)
+
+	tests := []struct {
+		name       string
+		query      string
+		origin     string
+		wantStatus int
+	}{
+		{"tenant origin with hint", "?tenant=acme", "https://acme.example", http.StatusSwitchingProtocols},
+		{"global origin with hint", "?tenant=acme", "https://app.example", http.StatusForbidden},
+		{"tenant without own list", "?tenant=globex", "https://app.example", http.StatusSwitchingProtocols},
+		{"tenant origin without hint", "", "https://acme.example", http.StatusSwitchingProtocols},
+		{"unknown tenant", "?tenant=initech", "https://app.example", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Origin": {tt.origin}}
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL+tt.query, header)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("no handshake response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestTenantAPIKeyMiddlewareChecksOrigin(t *testing.T) {
+	setupTestTenants()
+	authFailures = nil
+
+	handler := tenantAPIKeyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"https://acme.example", http.StatusOK},
+		{"https://evil.example", http.StatusForbidden},
+		{"", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/send", nil)
+		req.Header.Set("X-API-Key", "acme-key")
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if rr.Code != tt.want {
+			t.Fatalf("origin %q: expected status %d, got %d", tt.origin, tt.want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// timer_wheel.go
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// timerWheelResolution is how often the wheel advances. Client timers fire
+// up to this much late.
+const timerWheelResolution = 100 * time.Millisecond
+
+const (
+	wheelSlotBits = 6
+	wheelSlots    = 1 << wheelSlotBits
+	wheelLevels   = 4 // 64^4 ticks, about 19 days at 100ms
+)
+
+// wheelTicker delivers a tick on C every period, dropping ticks the reader
+// is not ready for, like a time.Ticker.
+type wheelTicker struct {
+	C <-chan struct{}
+
+	c        chan struct{}
+	wheel    *timerWheel
+	period   int64 // ticks
+	deadline int64 // tick of the next fire
+	armed    bool
+	// Neighbours in the slot the ticker waits in.
+	prev, next *wheelTicker
+	slot       **wheelTicker
+}
+
+// timerWheel is a hierarchical timing wheel shared by every connection's
+// periodic work, so a server with 50k clients runs one runtime timer instead
+// of one or more per client. Level l holds the timers due within 64^(l+1)
+// ticks; each time a level wraps, the next one's current slot is cascaded
+// down.
+type timerWheel struct {
+	resolution time.Duration
+	start      sync.Once
+
+	mu    sync.Mutex
+	epoch time.Time
+	now   int64 // ticks processed since epoch
+	slots [wheelLevels][wheelSlots]*wheelTicker
+	armed int
+}
+
+// clientTimers schedules pings, digest flushes and ack expiry for every
+// connection. Its goroutine starts with the first ticker.
+var clientTimers = newTimerWheel(timerWheelResolution)
+
+func newTimerWheel(resolution time.Duration) *timerWheel {
+	return &timerWheel{resolution: resolution, epoch: time.Now()}
+}
+
+// every returns a ticker firing every period, rounded up to the wheel's
+// resolution.
+func (w *timerWheel) every(period time.Duration) *wheelTicker {
+	w.start.Do(func() { go w.run() })
+
+	ticks := int64((period + w.resolution - 1) / w.resolution)
+	if ticks < 1 {
+		ticks = 1
+	}
+	c := make(chan struct{}, 1)
+	t := &wheelTicker{C: c, c: c, wheel: w, period: ticks}
+
+	w.mu.Lock()
+	t.deadline = w.now + ticks
+	w.addLocked(t)
+	w.mu.Unlock()
+	return t
+}
+
+// Stop turns the ticker off. No tick is delivered after Stop returns, though
+// one may already be waiting on C.
+func (t *wheelTicker) Stop() {
+	t.wheel.mu.Lock()
+	t.wheel.removeLocked(t)
+	t.wheel.mu.Unlock()
+}
+
+func (w *timerWheel) addLocked(t *wheelTicker) {
+	delta := t.deadline - w.now
+	level := 0
+	for level < wheelLevels-1 && delta >= 1<<(wheelSlotBits*(level+1)) {
+		level++
+	}
+	slot := &w.slots[level][(t.deadline>>(wheelSlotBits*level))&(wheelSlots-1)]
+	t.prev, t.next, t.slot = nil, *slot, slot
+	if *slot != nil {
+		(*slot).prev = t
+	}
+	*slot = t
+	if !t.armed {
+		t.armed = true
+		w.armed++
+	}
+}
+
+func (w *timerWheel) removeLocked(t *wheelTicker) {
+	if !t.armed {
+		return
+	}
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		*t.slot = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev, t.next, t.slot = nil, nil, nil
+	t.armed = false
+	w.armed--
+}
+
+// run advances the wheel every resolution, forever.
+func (w *timerWheel) run() {
+	ticker := time.NewTicker(w.resolution)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		w.advance(now)
+	}
+}
+
+// advance processes every tick up to now, catching up on ticks missed while
+// the process was descheduled.
+func (w *timerWheel) advance(now time.Time) {
+	target := int64(now.Sub(w.epoch) / w.resolution)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.now < target {
+		w.now++
+		// Cascade from the highest level that wrapped, so timers moving down
+		// several levels land before their lower slot is processed.
+		level := 0
+		for level < wheelLevels-1 && w.now&(1<<(wheelSlotBits*(level+1))-1) == 0 {
+			level++
+		}
+		for ; level >= 0; level-- {
+			w.processLocked(level)
+		}
+	}
+}
+
+// processLocked fires the due tickers of level's current slot and moves the
+// rest to the slot they are now due in.
+func (w *timerWheel) processLocked(level int) {
+	slot := &w.slots[level][(w.now>>(wheelSlotBits*level))&(wheelSlots-1)]
+	pending := *slot
+	*slot = nil
+	for pending != nil {
+		t := pending
+		pending = t.next
+		t.prev, t.next, t.slot = nil, nil, nil
+		if t.deadline <= w.now {
+			select {
+			case t.c <- struct{}{}:
+			default:
+			}
+			t.deadline = w.now + t.period
+		}
+		w.addLocked(t)
+	}
+}
+
+// pending returns how many tickers are armed.
+func (w *timerWheel) pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.armed
+}
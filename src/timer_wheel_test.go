@@ -0,0 +1,142 @@
+// timer_wheel_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ticksAfter advances w by n ticks and reports how many times t fired.
+func ticksAfter(w *timerWheel, t *wheelTicker, n int) int {
+	fired := 0
+	for i := 0; i < n; i++ {
+		w.advance(w.epoch.Add(time.Duration(w.now+1) * w.resolution))
+		select {
+		case <-t.C:
+			fired++
+		default:
+		}
+	}
+	return fired
+}
+
+func TestTimerWheel_FiresEveryPeriod(t *testing.T) {
+	// Periods on every level of the wheel.
+	for _, period := range []int64{1, 7, 64, 100, 4096, 5000, 300000} {
+		w := newTimerWheel(time.Millisecond)
+		w.start.Do(func() {}) // advanced by hand
+		ticker := w.every(time.Duration(period) * time.Millisecond)
+
+		w.advance(w.epoch.Add(time.Duration(period-1) * w.resolution))
+		select {
+		case <-ticker.C:
+			t.Fatalf("period %d: fired before its first deadline", period)
+		default:
+		}
+		if fired := ticksAfter(w, ticker, 1); fired != 1 {
+			t.Fatalf("period %d: did not fire at its deadline", period)
+		}
+		if period <= 5000 {
+			if fired := ticksAfter(w, ticker, int(period*3)); fired != 3 {
+				t.Fatalf("period %d: expected 3 more ticks, got %d", period, fired)
+			}
+		}
+		ticker.Stop()
+		if w.pending() != 0 {
+			t.Fatalf("period %d: expected the stopped ticker to be removed", period)
+		}
+	}
+}
+
+func TestTimerWheel_StopAndCatchUp(t *testing.T) {
+	w := newTimerWheel(time.Millisecond)
+	w.start.Do(func() {})
+	tickers := make([]*wheelTicker, 0, 100)
+	for i := 1; i <= 100; i++ {
+		tickers = append(tickers, w.every(time.Duration(i)*time.Millisecond))
+	}
+	for _, ticker := range tickers[:50] {
+		ticker.Stop()
+	}
+	tickers[0].Stop() // stopping twice is harmless
+	if w.pending() != 50 {
+		t.Fatalf("expected 50 armed tickers, got %d", w.pending())
+	}
+
+	// A late advance processes every missed tick, and each ticker holds at
+	// most one undelivered tick.
+	w.advance(w.epoch.Add(time.Second))
+	for i, ticker := range tickers {
+		select {
+		case <-ticker.C:
+			if i < 50 {
+				t.Fatalf("stopped ticker %d fired", i)
+			}
+		default:
+			if i >= 50 {
+				t.Fatalf("ticker %d did not fire", i)
+			}
+		}
+	}
+	if w.now != 1000 {
+		t.Errorf("expected the wheel at tick 1000, got %d", w.now)
+	}
+}
+
+func TestTimerWheel_Run(t *testing.T) {
+	ticker := newTimerWheel(time.Millisecond).every(5 * time.Millisecond)
+	defer ticker.Stop()
+	select {
+	case <-ticker.C:
+	case <-time.After(time.Second):
+		t.Fatal("ticker never fired")
+	}
+}
+
+// The benchmarks arm and stop a ping timer for each of 50k clients, as
+// writePump does, with runtime tickers and with the shared wheel. The wheel
+// leaves the runtime with one timer however many clients are connected.
+const benchmarkClients = 50000
+
+func BenchmarkClientTimers_Ticker(b *testing.B) {
+	tickers := make([]*time.Ticker, benchmarkClients)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := range tickers {
+			tickers[j] = time.NewTicker(54 * time.Second)
+		}
+		for _, ticker := range tickers {
+			ticker.Stop()
+		}
+	}
+	b.ReportMetric(benchmarkClients, "runtime-timers")
+}
+
+func BenchmarkClientTimers_Wheel(b *testing.B) {
+	w := newTimerWheel(timerWheelResolution)
+	tickers := make([]*wheelTicker, benchmarkClients)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := range tickers {
+			tickers[j] = w.every(54 * time.Second)
+		}
+		for _, ticker := range tickers {
+			ticker.Stop()
+		}
+	}
+	b.ReportMetric(1, "runtime-timers")
+}
+
+// BenchmarkTimerWheel_Advance measures one tick of the wheel with 50k
+// clients' timers armed.
+func BenchmarkTimerWheel_Advance(b *testing.B) {
+	w := newTimerWheel(time.Millisecond)
+	w.start.Do(func() {})
+	for j := 0; j < benchmarkClients; j++ {
+		w.every(time.Duration(1000+j%54000) * time.Millisecond)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.advance(w.epoch.Add(time.Duration(w.now+1) * w.resolution))
+	}
+}
@@ -0,0 +1,93 @@
+// tls_policy.go
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tlsVersions are the accepted values of tls.min_version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// weakTLSVersions are refused as tls.min_version in production.
+var weakTLSVersions = map[string]bool{"1.0": true, "1.1": true}
+
+// tlsCipherSuite looks up a suite by its Go name, such as
+// "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256". insecure is true for suites Go
+// only enables on request, such as CBC and RC4 ones.
+func tlsCipherSuite(name string) (id uint16, insecure bool, ok bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, false, true
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true, true
+		}
+	}
+	return 0, false, false
+}
+
+// validateTLSPolicy checks tls.min_version, tls.cipher_suites and
+// tls.ocsp_staple_file. Unknown values are always rejected; weak ones only
+// in production, so a development server can still talk to an old test
+// client.
+func validateTLSPolicy(config *Config) error {
+	if _, ok := tlsVersions[config.TLS.MinVersion]; !ok {
+		return fmt.Errorf("tls.min_version must be one of 1.0, 1.1, 1.2 or 1.3")
+	}
+	if weakTLSVersions[config.TLS.MinVersion] && config.Environment.Mode == "production" {
+		return fmt.Errorf("tls.min_version must be at least 1.2 in production")
+	}
+	if len(config.TLS.CipherSuites) > 0 && config.TLS.MinVersion == "1.3" {
+		return fmt.Errorf("tls.cipher_suites only apply to TLS 1.2 and below; remove them when tls.min_version is 1.3")
+	}
+	for _, name := range config.TLS.CipherSuites {
+		_, insecure, ok := tlsCipherSuite(name)
+		if !ok {
+			return fmt.Errorf("tls.cipher_suites: unknown suite %q; supported suites are %s", name, strings.Join(secureCipherSuiteNames(), ", "))
+		}
+		if insecure && config.Environment.Mode == "production" {
+			return fmt.Errorf("tls.cipher_suites: %s is insecure and is not allowed in production", name)
+		}
+	}
+	if config.TLS.OCSPStapleFile != "" && config.TLS.CertFile == "" {
+		return fmt.Errorf("tls.ocsp_staple_file requires tls.cert_file")
+	}
+	return nil
+}
+
+func secureCipherSuiteNames() []string {
+	var names []string
+	for _, suite := range tls.CipherSuites() {
+		names = append(names, suite.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyTLSPolicy sets the configured protocol version and cipher suites on
+// cfg. The settings were checked by validateTLSPolicy, so unknown values
+// cannot occur; TLS 1.2 is the floor if the config was never loaded.
+func applyTLSPolicy(cfg *tls.Config) {
+	cfg.MinVersion = tls.VersionTLS12
+	if AppConfig() == nil {
+		return
+	}
+	if version, ok := tlsVersions[AppConfig().TLS.MinVersion]; ok {
+		cfg.MinVersion = version
+	}
+	for _, name := range AppConfig().TLS.CipherSuites {
+		if id, _, ok := tlsCipherSuite(name); ok {
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// tls_policy_test.go
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateTLSPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         string
+		minVersion   string
+		cipherSuites []string
+		ocspFile     string
+		wantErr      string
+	}{
+		{name: "defaults", mode: "production", minVersion: "1.2"},
+		{name: "tls 1.3", mode: "production", minVersion: "1.3"},
+		{name: "secure suite", mode: "production", minVersion: "1.2", cipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+		{name: "unknown version", mode: "production", minVersion: "1.4", wantErr: "tls.min_version must be one of"},
+		{name: "tls 1.0 in production", mode: "production", minVersion: "1.0", wantErr: "at least 1.2 in production"},
+		{name: "tls 1.1 in development", mode: "development", minVersion: "1.1"},
+		{name: "unknown suite", mode: "development", minVersion: "1.2", cipherSuites: []string{"TLS_NULL"}, wantErr: `unknown suite "TLS_NULL"`},
+		{name: "insecure suite in production", mode: "production", minVersion: "1.2", cipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}, wantErr: "not allowed in production"},
+		{name: "insecure suite in development", mode: "development", minVersion: "1.2", cipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{name: "suites with tls 1.3", mode: "production", minVersion: "1.3", cipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, wantErr: "only apply to TLS 1.2"},
+		{name: "staple without certificate", mode: "production", minVersion: "1.2", ocspFile: "ocsp.der", wantErr: "requires tls.cert_file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			config.Environment.Mode = tt.mode
+			config.TLS.MinVersion = tt.minVersion
+			config.TLS.CipherSuites = tt.cipherSuites
+			config.TLS.OCSPStapleFile = tt.ocspFile
+			err := validateTLSPolicy(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected a valid policy, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCertReloader_AppliesPolicyAndStaplesOCSP(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().TLS.MinVersion = "1.3"
+	defer setupTestAppConfig()
+
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, "stapled.example")
+	ocspFile := filepath.Join(dir, "ocsp.der")
+	os.WriteFile(ocspFile, []byte("first response"), 0o600)
+	reloader, err := newCertReloader("test", certFile, keyFile, ocspFile)
+	if err != nil {
+		t.Fatalf("failed to load the certificate: %v", err)
+	}
+	defer func() {
+		certReloadersMu.Lock()
+		certReloaders = nil
+		certReloadersMu.Unlock()
+	}()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.tlsConfig())
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	staple := func() []byte {
+		t.Helper()
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		defer conn.Close()
+		if version := conn.ConnectionState().Version; version != tls.VersionTLS13 {
+			t.Fatalf("expected TLS 1.3, got %x", version)
+		}
+		return conn.ConnectionState().OCSPResponse
+	}
+
+	if got := staple(); !bytes.Equal(got, []byte("first response")) {
+		t.Fatalf("expected the stapled response, got %q", got)
+	}
+
+	// Clients that cannot reach the minimum version are refused.
+	if conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}); err == nil {
+		conn.Close()
+		t.Fatal("expected a TLS 1.2 client to be refused")
+	}
+
+	os.WriteFile(ocspFile, []byte("second response"), 0o600)
+	if err := reloadCertificates(false); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if got := staple(); !bytes.Equal(got, []byte("second response")) {
+		t.Fatalf("expected the refreshed response after a reload, got %q", got)
+	}
+}
@@ -0,0 +1,168 @@
+// tls_reload.go
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a certificate and key pair that can be replaced while
+// the listener is running. Only new handshakes pick up a reloaded
+// certificate, so connections that are already established, such as
+// websockets, are not dropped when a certificate is renewed.
+type certReloader struct {
+	setting  string // config setting the files come from, for logs
+	certFile string
+	keyFile  string
+	ocspFile string // optional DER OCSP response stapled to the certificate
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	modTime     time.Time // latest modification time of the files when loaded
+}
+
+// certReloaders are the reloaders of every TLS listener, reloaded together
+// on SIGHUP and by watchCertificates.
+var (
+	certReloadersMu sync.Mutex
+	certReloaders   []*certReloader
+)
+
+func newCertReloader(setting, certFile, keyFile, ocspFile string) (*certReloader, error) {
+	reloader := &certReloader{setting: setting, certFile: certFile, keyFile: keyFile, ocspFile: ocspFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	certReloadersMu.Lock()
+	certReloaders = append(certReloaders, reloader)
+	certReloadersMu.Unlock()
+	return reloader, nil
+}
+
+// reload loads the pair, and the OCSP response to staple if there is one,
+// from disk. On failure the previous certificate stays in use. The response
+// is not checked against the certificate, so refresh it whenever the
+// certificate is renewed.
+func (r *certReloader) reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.ocspFile != "" {
+		staple, err := os.ReadFile(r.ocspFile)
+		if err != nil {
+			return err
+		}
+		if len(staple) == 0 {
+			return fmt.Errorf("%s is empty", r.ocspFile)
+		}
+		certificate.OCSPStaple = staple
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certificate = &certificate
+	r.modTime = modTime
+	return nil
+}
+
+// reloadIfChanged reloads the pair when any of its files changed since it
+// was last loaded.
+func (r *certReloader) reloadIfChanged() (bool, error) {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	return true, r.reload()
+}
+
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile, r.ocspFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, nil
+}
+
+// tlsConfig returns a server config that always presents the current
+// certificate, under the configured TLS policy.
+func (r *certReloader) tlsConfig() *tls.Config {
+	cfg := &tls.Config{GetCertificate: r.getCertificate}
+	applyTLSPolicy(cfg)
+	return cfg
+}
+
+// reloadCertificates reloads every listener's certificate, or with
+// onlyChanged, those whose files changed. It returns the first error; the
+// listeners whose reload failed keep their previous certificate.
+func reloadCertificates(onlyChanged bool) error {
+	certReloadersMu.Lock()
+	reloaders := append([]*certReloader(nil), certReloaders...)
+	certReloadersMu.Unlock()
+
+	var firstErr error
+	for _, reloader := range reloaders {
+		reloaded := true
+		var err error
+		if onlyChanged {
+			reloaded, err = reloader.reloadIfChanged()
+		} else {
+			err = reloader.reload()
+		}
+		switch {
+		case err != nil:
+			slog.Error("Failed to reload a certificate, keeping the previous one", "setting", reloader.setting, "error", err)
+			appMetrics.Count("tls.reloads", 1, metricTag("listener", reloader.setting), metricTag("result", "failed"))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", reloader.setting, err)
+			}
+		case reloaded:
+			slog.Info("Reloaded a certificate", "setting", reloader.setting, "path", reloader.certFile)
+			appMetrics.Count("tls.reloads", 1, metricTag("listener", reloader.setting), metricTag("result", "ok"))
+		}
+	}
+	return firstErr
+}
+
+// watchCertificates reloads certificates whose files changed every
+// interval, for renewals that do not send SIGHUP.
+func watchCertificates(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloadCertificates(true)
+		case <-stop:
+			return
+		}
+	}
+}
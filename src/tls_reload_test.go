@@ -0,0 +1,137 @@
+// tls_reload_test.go
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for commonName and
+// its key to dir, returning their paths.
+func writeTestCertificate(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate a key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create a certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal the key: %v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// servedCommonName completes a handshake with listener and returns the
+// common name of the certificate it presented.
+func servedCommonName(t *testing.T, listener net.Listener) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertReloader_RotatesWithoutDroppingConnections(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, "old.example")
+	reloader, err := newCertReloader("test", certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("failed to load the certificate: %v", err)
+	}
+	defer func() {
+		certReloadersMu.Lock()
+		certReloaders = nil
+		certReloadersMu.Unlock()
+	}()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.tlsConfig())
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				accepted <- conn
+			}()
+		}
+	}()
+
+	established, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer established.Close()
+	serverSide := <-accepted
+
+	if reloaded, err := reloader.reloadIfChanged(); reloaded || err != nil {
+		t.Fatalf("expected no reload for unchanged files, got %v, %v", reloaded, err)
+	}
+
+	// A broken pair is rejected and the old certificate stays in use.
+	os.WriteFile(keyFile, []byte("not a key"), 0o600)
+	if err := reloadCertificates(false); err == nil {
+		t.Fatal("expected reloading a broken key to fail")
+	}
+	if got := servedCommonName(t, listener); got != "old.example" {
+		t.Fatalf("expected the previous certificate after a failed reload, got %s", got)
+	}
+	<-accepted
+
+	writeTestCertificate(t, dir, "new.example")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if err := reloadCertificates(true); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if got := servedCommonName(t, listener); got != "new.example" {
+		t.Fatalf("expected the renewed certificate, got %s", got)
+	}
+	<-accepted
+
+	// The connection made before the rotation still works.
+	if _, err := established.Write([]byte("ping")); err != nil {
+		t.Fatalf("expected the established connection to survive, got %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := serverSide.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("expected the established connection to survive, got %q, %v", buf, err)
+	}
+}
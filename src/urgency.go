@@ -0,0 +1,53 @@
+// urgency.go
+package main
+
+// Urgency levels a /send may give a notification, from least to most urgent.
+const (
+	urgencyLow      = "low"
+	urgencyNormal   = "normal"
+	urgencyHigh     = "high"
+	urgencyCritical = "critical"
+)
+
+// UrgencyPolicy is what notifications of one urgency level are subject to.
+// A notification that is not subject to a check is delivered through it.
+type UrgencyPolicy struct {
+	DND        bool `yaml:"dnd"`         // Withheld from connections whose status is dnd
+	QuietHours bool `yaml:"quiet_hours"` // Deferred by blackout windows
+	Mutes      bool `yaml:"mutes"`       // Dropped by subscription filters
+	Digest     bool `yaml:"digest"`      // Batched into digests
+}
+
+// defaultUrgencyPolicies is the policy of each level that urgency.levels
+// does not list. normal matches how notifications were delivered before
+// urgency levels existed.
+var defaultUrgencyPolicies = map[string]UrgencyPolicy{
+	urgencyLow:      {DND: true, QuietHours: true, Mutes: true, Digest: true},
+	urgencyNormal:   {QuietHours: true, Mutes: true, Digest: true},
+	urgencyHigh:     {QuietHours: true, Mutes: true},
+	urgencyCritical: {},
+}
+
+func validUrgency(level string) bool {
+	_, ok := defaultUrgencyPolicies[level]
+	return ok
+}
+
+// urgencyPolicyOf returns the configured policy for level. Notifications
+// the server generates itself have no level and are treated as normal.
+func urgencyPolicyOf(level string) UrgencyPolicy {
+	if level == "" {
+		level = urgencyNormal
+	}
+	if AppConfig() != nil {
+		if policy, ok := AppConfig().Urgency.Levels[level]; ok {
+			return policy
+		}
+	}
+	return defaultUrgencyPolicies[level]
+}
+
+// inDND reports whether the client has set its status to dnd.
+func (c *Client) inDND() bool {
+	return presenceOf(c.attributes.currentStatus()) == presenceDND
+}
@@ -0,0 +1,110 @@
+// urgency_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnqueueMessage_UrgencyPolicy(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	muted, err := compileFilter(&SubscriptionFilter{MessageTypes: []string{"other"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := compileDigest(&DigestSettings{IntervalSeconds: 60}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newClient := func() *Client {
+		return &Client{hub: hub, teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 8)}
+	}
+	dnd := newClient()
+	dnd.attributes.setStatus("DND")
+	filtered := newClient()
+	filtered.filter = muted
+	digesting := newClient()
+	digesting.digest = digest
+
+	tests := []struct {
+		client  *Client
+		urgency string
+		queued  bool
+	}{
+		{dnd, urgencyLow, false},
+		{dnd, "", true},
+		{dnd, urgencyCritical, true},
+		{filtered, urgencyHigh, false},
+		{filtered, urgencyCritical, true},
+		{digesting, urgencyLow, false},
+		{digesting, urgencyHigh, true},
+	}
+	for _, tt := range tests {
+		before := len(tt.client.send)
+		hub.enqueueMessage(tt.client, outboundMessage{payload: []byte(`{}`), messageType: "alert", urgency: tt.urgency})
+		if queued := len(tt.client.send) > before; queued != tt.queued {
+			t.Errorf("urgency %q: expected queued %v, got %v", tt.urgency, tt.queued, queued)
+		}
+	}
+	digest.mu.Lock()
+	pending := len(digest.pending)
+	digest.mu.Unlock()
+	if pending != 1 {
+		t.Errorf("expected the low urgency message in the digest, got %d", pending)
+	}
+
+	// The matrix is configurable: here low ignores do-not-disturb too.
+	AppConfig().Urgency.Levels[urgencyLow] = UrgencyPolicy{Mutes: true}
+	if !hub.enqueueMessage(dnd, outboundMessage{payload: []byte(`{}`), messageType: "alert", urgency: urgencyLow}) {
+		t.Error("expected the configured policy to apply")
+	}
+}
+
+func TestBlackoutSchedule_CriticalUrgency(t *testing.T) {
+	setupTestAppConfig()
+	schedule := newBlackoutSchedule(nil, 10)
+	now := time.Now()
+	schedule.add(blackoutWindow{TeamID: "team1", Start: now.Add(-time.Minute), End: now.Add(time.Minute)})
+
+	if !schedule.deferBroadcast("team1", outboundMessage{messageType: "alert", urgency: urgencyHigh}, now) {
+		t.Error("expected a high urgency broadcast to be deferred")
+	}
+	critical := outboundMessage{messageType: "alert", urgency: urgencyCritical}
+	if schedule.defers("team1", critical, now) || schedule.deferBroadcast("team1", critical, now) {
+		t.Error("expected a critical broadcast to bypass the blackout")
+	}
+}
+
+func TestHandleSendMessage_Urgency(t *testing.T) {
+	setupTestAppConfig()
+	hub := &syncHub{}
+	client := &Client{teamID: "team-1", userID: "alice", send: make(chan outboundMessage, 4)}
+	hub.Register(client)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleSendMessage(hub, rr, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		return rr
+	}
+	if rr := send(`{"target_team_id":"team-1","target_user_id":"alice","message_type":"alert","body":"x","urgency":" Critical "}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	sent := <-client.send
+	if sent.urgency != urgencyCritical || !strings.Contains(string(sent.payload), `"urgency":"critical"`) {
+		t.Fatalf("expected a critical notification, got %q %s", sent.urgency, sent.payload)
+	}
+
+	AppConfig().Urgency.Default = urgencyLow
+	send(`{"target_team_id":"team-1","target_user_id":"alice","message_type":"alert","body":"x"}`)
+	if sent := <-client.send; sent.urgency != urgencyLow {
+		t.Fatalf("expected urgency.default to apply, got %q", sent.urgency)
+	}
+
+	if rr := send(`{"target_team_id":"team-1","target_user_id":"alice","message_type":"alert","body":"x","urgency":"urgent"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown urgency to be rejected, got %d", rr.Code)
+	}
+}
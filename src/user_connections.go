@@ -0,0 +1,109 @@
+// user_connections.go
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Transports reported by GET /users/{team}/{user}/connections.
+const (
+	transportWebSocket = "websocket"
+	transportTCP       = "tcp"
+)
+
+// transport names how client is connected.
+func (c *Client) transport() string {
+	if _, ok := c.conn.(*lineConn); ok {
+		return transportTCP
+	}
+	return transportWebSocket
+}
+
+// userConnection is one of a user's connections.
+type userConnection struct {
+	ConnID     string       `json:"connectionId"`
+	Transport  string       `json:"transport"`
+	Protocol   wireProtocol `json:"protocol"`
+	QueueDepth int          `json:"queueDepth"`
+	Digest     bool         `json:"digest,omitempty"` // notifications are batched into a digest
+}
+
+type userConnectionsResponse struct {
+	TeamID      string           `json:"teamId"`
+	UserID      string           `json:"userId"`
+	Online      bool             `json:"online"`
+	Devices     int              `json:"devices"`
+	Transports  map[string]int   `json:"transports"`
+	Connections []userConnection `json:"connections"`
+}
+
+// snapshotUserClients returns userID's connections in teamID.
+func (h *Hub) snapshotUserClients(teamID, userID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(h.clients[teamID][userID]))
+	for client := range h.clients[teamID][userID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// handleUserConnections serves GET /users/{team}/{user}/connections, so a
+// backend can tell whether a user is reachable here, and how, before it
+// sends a heavyweight payload instead of falling back to email or push. A
+// tenant key reads its own tenant's teams; the operator key names a tenant
+// with ?tenant_id=.
+func handleUserConnections(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] != "connections" {
+		http.NotFound(w, r)
+		return
+	}
+	team, user := parts[0], parts[1]
+
+	tenantID, err := sendTenantID(r, &MessageRequest{TenantID: strings.TrimSpace(r.URL.Query().Get("tenant_id"))})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	teamID, err := scopeTeam(tenantID, team)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := userConnectionsResponse{
+		TeamID:      team,
+		UserID:      user,
+		Transports:  make(map[string]int),
+		Connections: make([]userConnection, 0),
+	}
+	for _, client := range hub.snapshotUserClients(teamID, user) {
+		connection := userConnection{
+			ConnID:     client.connID,
+			Transport:  client.transport(),
+			Protocol:   client.protocol,
+			QueueDepth: len(client.send),
+			Digest:     client.digest != nil,
+		}
+		if connection.Protocol == "" {
+			connection.Protocol = protocolJSONv1
+		}
+		response.Transports[connection.Transport]++
+		response.Connections = append(response.Connections, connection)
+	}
+	sort.Slice(response.Connections, func(i, j int) bool {
+		return response.Connections[i].ConnID < response.Connections[j].ConnID
+	})
+	response.Devices = len(response.Connections)
+	response.Online = response.Devices > 0
+	writeJSON(w, http.StatusOK, response)
+}
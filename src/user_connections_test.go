@@ -0,0 +1,51 @@
+// user_connections_test.go
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleUserConnections(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	server, peer := net.Pipe()
+	defer server.Close()
+	defer peer.Close()
+	phone := &Client{hub: hub, conn: newMockConn(), connID: "b-phone", teamID: "team-1", userID: "alice", protocol: protocolJSONv2, send: make(chan outboundMessage, 4)}
+	feed := &Client{hub: hub, conn: newLineConn(server), connID: "a-feed", teamID: "team-1", userID: "alice", protocol: protocolJSONv1, send: make(chan outboundMessage, 4)}
+	feed.send <- outboundMessage{}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"alice": {phone: {}, feed: {}}}
+
+	rr := httptest.NewRecorder()
+	handleUserConnections(hub, rr, httptest.NewRequest(http.MethodGet, "/users/team-1/alice/connections", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	var got userConnectionsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Online || got.Devices != 2 || got.Transports[transportWebSocket] != 1 || got.Transports[transportTCP] != 1 {
+		t.Fatalf("expected two devices over both transports, got %+v", got)
+	}
+	want := userConnection{ConnID: "a-feed", Transport: transportTCP, Protocol: protocolJSONv1, QueueDepth: 1}
+	if got.Connections[0] != want {
+		t.Errorf("expected %+v first, got %+v", want, got.Connections[0])
+	}
+
+	rr = httptest.NewRecorder()
+	handleUserConnections(hub, rr, httptest.NewRequest(http.MethodGet, "/users/team-1/bob/connections", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"teamId":"team-1","userId":"bob","online":false,"devices":0,"transports":{},"connections":[]}`+"\n" {
+		t.Fatalf("unexpected response for an offline user: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleUserConnections(hub, rr, httptest.NewRequest(http.MethodPost, "/users/team-1/alice/connections", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rr.Code)
+	}
+}
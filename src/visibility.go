@@ -0,0 +1,215 @@
+// visibility.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxVisibilityRules  = 16
+	maxVisibilityValues = 64
+	// maxClientStatusLength bounds the status a client may declare.
+	maxClientStatusLength = 64
+)
+
+// visibilityAttributes are the client attributes /send visibility rules can
+// test. role and group come from the backend; status is set by the client.
+var visibilityAttributes = map[string]struct{}{"role": {}, "group": {}, "status": {}}
+
+// visibilityRules is a compiled /send visibility list. A client sees the
+// notification only if it matches every rule.
+type visibilityRules []visibilityRule
+
+type visibilityRule struct {
+	attribute string
+	values    map[string]struct{}
+	negate    bool // not_in: the client must have none of the values
+}
+
+// compileVisibility validates a /send visibility list. An empty list shows
+// the notification to everyone and compiles to nil.
+func compileVisibility(rules []VisibilityRule) (visibilityRules, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if len(rules) > maxVisibilityRules {
+		return nil, fmt.Errorf("visibility supports at most %d rules", maxVisibilityRules)
+	}
+
+	compiled := make(visibilityRules, 0, len(rules))
+	for i, rule := range rules {
+		attribute := strings.TrimSpace(rule.Attribute)
+		if _, ok := visibilityAttributes[attribute]; !ok {
+			return nil, fmt.Errorf("visibility[%d].attribute must be role, group or status", i)
+		}
+		if (len(rule.In) == 0) == (len(rule.NotIn) == 0) {
+			return nil, fmt.Errorf("visibility[%d] must set exactly one of in or not_in", i)
+		}
+		values := rule.In
+		if len(rule.NotIn) > 0 {
+			values = rule.NotIn
+		}
+		if len(values) > maxVisibilityValues {
+			return nil, fmt.Errorf("visibility[%d] supports at most %d values", i, maxVisibilityValues)
+		}
+		set := make(map[string]struct{}, len(values))
+		for _, value := range values {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				return nil, fmt.Errorf("visibility[%d] values must not be empty", i)
+			}
+			set[value] = struct{}{}
+		}
+		compiled = append(compiled, visibilityRule{attribute: attribute, values: set, negate: len(rule.NotIn) > 0})
+	}
+	return compiled, nil
+}
+
+// spec converts compiled rules back to the /send form, with values sorted.
+func (rules visibilityRules) spec() []VisibilityRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	specs := make([]VisibilityRule, 0, len(rules))
+	for _, rule := range rules {
+		values := make([]string, 0, len(rule.values))
+		for value := range rule.values {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		spec := VisibilityRule{Attribute: rule.attribute, In: values}
+		if rule.negate {
+			spec.In, spec.NotIn = nil, values
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// allows reports whether client may see a notification with these rules.
+func (rules visibilityRules) allows(client *Client) bool {
+	for _, rule := range rules {
+		if rule.matches(client) == rule.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the client has any of the rule's values.
+func (rule visibilityRule) matches(client *Client) bool {
+	switch rule.attribute {
+	case "role":
+		return intersects(client.attributes.roles, rule.values)
+	case "group":
+		return intersects(client.attributes.groups, rule.values)
+	default:
+		_, ok := rule.values[client.attributes.currentStatus()]
+		return ok
+	}
+}
+
+func intersects(have, want map[string]struct{}) bool {
+	for value := range have {
+		if _, ok := want[value]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAttributes are what visibility rules are evaluated against. roles
+// and groups are fixed at authentication; status may change at any time.
+type clientAttributes struct {
+	roles  map[string]struct{}
+	groups map[string]struct{}
+	status atomic.Pointer[string]
+}
+
+// setIdentity records the roles and groups the backend reported. A team
+// admin always has the admin role.
+func (a *clientAttributes) setIdentity(roles, groups []string, teamAdmin bool) {
+	a.roles = stringSet(roles)
+	if teamAdmin {
+		if a.roles == nil {
+			a.roles = make(map[string]struct{}, 1)
+		}
+		a.roles["admin"] = struct{}{}
+	}
+	a.groups = stringSet(groups)
+}
+
+func stringSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
+
+func (a *clientAttributes) currentStatus() string {
+	if status := a.status.Load(); status != nil {
+		return *status
+	}
+	return ""
+}
+
+// setStatus validates and stores a client-declared status. An empty status
+// clears it.
+func (a *clientAttributes) setStatus(status string) error {
+	status = strings.TrimSpace(status)
+	if len(status) > maxClientStatusLength {
+		return fmt.Errorf("status must be at most %d bytes", maxClientStatusLength)
+	}
+	a.status.Store(&status)
+	return nil
+}
+
+// extractStringList reads a list of strings from the user's settings or,
+// failing that, the top level of the auth response.
+func extractStringList(raw map[string]any, field string) []string {
+	list, ok := raw[field].([]any)
+	if settings, isObject := raw["settings"].(map[string]any); isObject {
+		if nested, isList := settings[field].([]any); isList {
+			list, ok = nested, true
+		}
+	}
+	if !ok {
+		return nil
+	}
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		if value, ok := scalarToString(item); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+type statusSetParams struct {
+	Status string `json:"status"`
+}
+
+func handleStatusSetRequest(c *Client, params json.RawMessage) (interface{}, error) {
+	var p statusSetParams
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, errors.New("invalid params: " + err.Error())
+		}
+	}
+	previous := c.attributes.currentStatus()
+	if err := c.attributes.setStatus(p.Status); err != nil {
+		return nil, err
+	}
+	presenceHistory.statusChanged(c, previous, c.attributes.currentStatus(), time.Now())
+	return statusSetParams{Status: c.attributes.currentStatus()}, nil
+}
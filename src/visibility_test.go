@@ -0,0 +1,130 @@
+// visibility_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompileVisibility_Validation(t *testing.T) {
+	if rules, err := compileVisibility(nil); err != nil || rules != nil {
+		t.Fatalf("expected no rules to compile to nil, got %v, %v", rules, err)
+	}
+
+	testCases := []struct {
+		name  string
+		rules []VisibilityRule
+		want  string
+	}{
+		{"unknown attribute", []VisibilityRule{{Attribute: "team", In: []string{"a"}}}, "must be role, group or status"},
+		{"no condition", []VisibilityRule{{Attribute: "role"}}, "exactly one of in or not_in"},
+		{"both conditions", []VisibilityRule{{Attribute: "role", In: []string{"a"}, NotIn: []string{"b"}}}, "exactly one of in or not_in"},
+		{"empty value", []VisibilityRule{{Attribute: "group", In: []string{" "}}}, "must not be empty"},
+		{"too many rules", make([]VisibilityRule, maxVisibilityRules+1), "at most 16 rules"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := compileVisibility(tc.rules); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestVisibilityRules_Allows(t *testing.T) {
+	rules, err := compileVisibility([]VisibilityRule{
+		{Attribute: "role", In: []string{"admin"}},
+		{Attribute: "group", In: []string{"oncall", "sre"}},
+		{Attribute: "status", NotIn: []string{"dnd"}},
+	})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	newClient := func(roles, groups []string, teamAdmin bool, status string) *Client {
+		client := &Client{}
+		client.attributes.setIdentity(roles, groups, teamAdmin)
+		if err := client.attributes.setStatus(status); err != nil {
+			t.Fatalf("setStatus failed: %v", err)
+		}
+		return client
+	}
+
+	testCases := []struct {
+		name   string
+		client *Client
+		want   bool
+	}{
+		{"admin on call", newClient([]string{"admin"}, []string{"oncall"}, false, ""), true},
+		{"team admin in sre", newClient(nil, []string{"sre"}, true, "away"), true},
+		{"admin in dnd", newClient([]string{"admin"}, []string{"oncall"}, false, "dnd"), false},
+		{"member on call", newClient([]string{"member"}, []string{"oncall"}, false, ""), false},
+		{"admin off call", newClient([]string{"admin"}, nil, false, ""), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rules.allows(tc.client); got != tc.want {
+				t.Fatalf("allows() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if !visibilityRules(nil).allows(&Client{}) {
+		t.Fatal("expected no rules to allow every client")
+	}
+}
+
+func TestParseVerifiedUser_RolesAndGroups(t *testing.T) {
+	user, err := parseVerifiedUser([]byte(`{"id": 7, "roles": ["viewer"], "settings": {"selectedTeam": "t1", "roles": ["admin", 3], "groups": ["oncall"]}}`))
+	if err != nil {
+		t.Fatalf("parseVerifiedUser returned error: %v", err)
+	}
+	if strings.Join(user.Roles, ",") != "admin,3" || strings.Join(user.Groups, ",") != "oncall" {
+		t.Fatalf("expected the settings lists to win, got roles %v and groups %v", user.Roles, user.Groups)
+	}
+}
+
+func TestHandleStatusSetRequest(t *testing.T) {
+	client := &Client{}
+	result, err := handleStatusSetRequest(client, json.RawMessage(`{"status": " dnd "}`))
+	if err != nil || result.(statusSetParams).Status != "dnd" || client.attributes.currentStatus() != "dnd" {
+		t.Fatalf("expected the status to be set, got %v, %v", result, err)
+	}
+	if _, err := handleStatusSetRequest(client, json.RawMessage(`{"status": "`+strings.Repeat("x", maxClientStatusLength+1)+`"}`)); err == nil {
+		t.Fatal("expected an overlong status to be rejected")
+	}
+	if _, err := handleStatusSetRequest(client, nil); err != nil || client.attributes.currentStatus() != "" {
+		t.Fatalf("expected empty params to clear the status, got %v", err)
+	}
+}
+
+func TestHandleSendMessage_Visibility(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	admin := &Client{hub: hub, teamID: "team-1", userID: "admin", send: make(chan outboundMessage, 1)}
+	admin.attributes.setIdentity(nil, nil, true)
+	member := &Client{hub: hub, teamID: "team-1", userID: "member", send: make(chan outboundMessage, 1)}
+	hub.clients["team-1"] = map[string]map[*Client]struct{}{"admin": {admin: {}}, "member": {member: {}}}
+
+	send := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleSendMessage(hub, rec, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := send(`{"target_team_id":"team-1","message_type":"alert","body":"admins only","broadcast":true,"visibility":[{"attribute":"role","in":["admin"]}]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"delivered":1`) {
+		t.Fatalf("expected one delivery, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(admin.send) != 1 || len(member.send) != 0 {
+		t.Fatal("expected only the admin to receive the notification")
+	}
+
+	rec = send(`{"target_team_id":"team-1","message_type":"alert","body":"x","broadcast":true,"visibility":[{"attribute":"mood","in":["happy"]}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "must be role, group or status") {
+		t.Fatalf("expected 400 for an unknown attribute, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,212 @@
+// webhooks.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// webhookJob is one outbound HTTP POST to a backend or customer endpoint.
+type webhookJob struct {
+	Kind    string            // Metric tag naming the feature, e.g. "abuse_ban"
+	URL     string            // Destination; its host selects the circuit breaker
+	Payload []byte            // JSON body
+	Headers map[string]string // Added to the request after the defaults
+
+	id       string // Outbox key, also sent as X-Webhook-ID
+	attempt  int
+	queuedAt time.Time
+}
+
+// outboundWebhooks delivers every webhook the server sends. It is nil until
+// main() creates it, and enqueueing on a nil dispatcher drops the job.
+var outboundWebhooks *webhookDispatcher
+
+// webhookDispatcher sends webhooks from a bounded queue with a fixed pool of
+// workers. A failed attempt is retried with exponential backoff by putting
+// the job back on the queue, so a slow destination never holds a worker while
+// it waits. Each destination host has its own circuit breaker. Jobs are
+// dropped, and counted, when the queue is full, the destination rejects them
+// or they run out of attempts.
+//
+// When outbox is set, every job is written to it before it is queued,
+// deleted once delivered and marked failed when dropped, so jobs survive a
+// restart and failed ones can be inspected and retried.
+type webhookDispatcher struct {
+	client         *http.Client
+	outbox         Store
+	queue          chan webhookJob
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker
+}
+
+func newWebhookDispatcher(client *http.Client, queueSize, maxAttempts int, initialBackoff, maxBackoff time.Duration) *webhookDispatcher {
+	return &webhookDispatcher{
+		client:         client,
+		queue:          make(chan webhookJob, queueSize),
+		maxAttempts:    maxAttempts,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		breakers:       make(map[string]*CircuitBreaker),
+	}
+}
+
+// enqueue queues job without blocking. A new job is recorded in the outbox
+// first. It returns false if the job was dropped.
+func (d *webhookDispatcher) enqueue(job webhookJob) bool {
+	if d == nil {
+		slog.Warn("Dropping a webhook, the dispatcher is not running", "kind", job.Kind)
+		return false
+	}
+	if job.id == "" {
+		job.id = newNotificationID()
+		job.queuedAt = time.Now()
+		d.persist(job, outboxPending, "")
+	}
+	select {
+	case d.queue <- job:
+		return true
+	default:
+		d.drop(job, "queue_full", "queue full")
+		return false
+	}
+}
+
+// run starts workers that deliver queued jobs until stop is closed.
+func (d *webhookDispatcher) run(workers int, stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case job := <-d.queue:
+					d.attempt(job)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *webhookDispatcher) attempt(job webhookJob) {
+	job.attempt++
+	err := d.breaker(job.URL).Call(func() error {
+		return d.post(job)
+	})
+	if err == nil {
+		appMetrics.Count("webhooks.delivered", 1, metricTag("kind", job.Kind))
+		d.complete(job)
+		return
+	}
+
+	var retryable *circuitBreakerFailure
+	if !errors.Is(err, errCircuitOpen) && !errors.As(err, &retryable) {
+		slog.Error("Webhook rejected", "kind", job.Kind, "url", redactURL(job.URL), "error", err)
+		d.drop(job, "rejected", err.Error())
+		return
+	}
+	if job.attempt >= d.maxAttempts {
+		slog.Error("Webhook failed", "kind", job.Kind, "url", redactURL(job.URL), "attempts", job.attempt, "error", err)
+		d.drop(job, "attempts_exhausted", err.Error())
+		return
+	}
+
+	appMetrics.Count("webhooks.retried", 1, metricTag("kind", job.Kind))
+	d.persist(job, outboxPending, err.Error())
+	time.AfterFunc(d.backoff(job.attempt), func() {
+		d.enqueue(job)
+	})
+}
+
+// post sends one attempt. Network errors, 429 and 5xx responses are marked as
+// circuit breaker failures, which also makes them retryable.
+func (d *webhookDispatcher) post(job webhookJob) error {
+	req, err := http.NewRequest(http.MethodPost, job.URL, bytes.NewReader(job.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if job.Kind != teamWebhookKind {
+		req.Header.Set("X-API-Key", AppConfig().Security.APIKey)
+	}
+	req.Header.Set("X-Webhook-ID", job.id)
+	for name, value := range job.Headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return markCircuitBreakerFailure(err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests:
+		return markCircuitBreakerFailure(fmt.Errorf("unexpected status %d", res.StatusCode))
+	default:
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+}
+
+// backoff doubles initialBackoff for every attempt made, capped at
+// maxBackoff, with up to 20% jitter so retries from a burst spread out.
+func (d *webhookDispatcher) backoff(attempt int) time.Duration {
+	delay := d.initialBackoff
+	for i := 1; i < attempt && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+func (d *webhookDispatcher) breaker(destination string) *CircuitBreaker {
+	host := destination
+	if parsed, err := url.Parse(destination); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cb, ok := d.breakers[host]
+	if !ok {
+		cb = &CircuitBreaker{}
+		d.breakers[host] = cb
+	}
+	return cb
+}
+
+func (d *webhookDispatcher) drop(job webhookJob, reason, lastError string) {
+	appMetrics.Count("webhooks.dropped", 1, metricTag("kind", job.Kind), metricTag("reason", reason))
+	d.persist(job, outboxFailed, lastError)
+}
+
+// redactURL drops the query string and credentials, which may carry secrets,
+// from a URL before it is logged.
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid url>"
+	}
+	parsed.User = nil
+	parsed.RawQuery = ""
+	return parsed.String()
+}
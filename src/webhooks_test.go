@@ -0,0 +1,298 @@
+// webhooks_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestDispatcher runs d until the test ends and waits for its workers.
+func startTestDispatcher(t *testing.T, d *webhookDispatcher, workers int) {
+	t.Helper()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		d.run(workers, stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+}
+
+func TestWebhookDispatcherRetries(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().CircuitBreaker.Threshold = 100
+
+	tests := []struct {
+		name         string
+		statuses     []int // response for each attempt; the last one repeats
+		wantAttempts int32
+	}{
+		{"delivered first time", []int{http.StatusOK}, 1},
+		{"retried after server errors", []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusNoContent}, 3},
+		{"rejected without retry", []int{http.StatusBadRequest}, 1},
+		{"gives up after max attempts", []int{http.StatusInternalServerError}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1))
+				if r.Header.Get("X-Signature") != "sig" || r.Header.Get("X-API-Key") != "test-api-key" {
+					t.Errorf("missing webhook headers: %v", r.Header)
+				}
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses))-1])
+			}))
+			defer backend.Close()
+
+			d := newWebhookDispatcher(backend.Client(), 8, 4, time.Millisecond, 4*time.Millisecond)
+			startTestDispatcher(t, d, 2)
+
+			if !d.enqueue(webhookJob{Kind: "test", URL: backend.URL + "/hook", Payload: []byte(`{}`), Headers: map[string]string{"X-Signature": "sig"}}) {
+				t.Fatal("expected the job to be queued")
+			}
+
+			// Wait for the expected attempts, then make sure no more follow.
+			for deadline := time.Now().Add(2 * time.Second); attempts.Load() < tt.wantAttempts; {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected %d attempts, got %d", tt.wantAttempts, attempts.Load())
+				}
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(30 * time.Millisecond)
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Fatalf("expected %d attempts, got %d", tt.wantAttempts, got)
+			}
+		})
+	}
+}
+
+func TestWebhookDispatcherDropsWhenFull(t *testing.T) {
+	setupTestAppConfig()
+	d := newWebhookDispatcher(http.DefaultClient, 1, 1, time.Millisecond, time.Millisecond)
+
+	if !d.enqueue(webhookJob{Kind: "test", URL: "http://127.0.0.1:1/"}) {
+		t.Fatal("expected the first job to be queued")
+	}
+	if d.enqueue(webhookJob{Kind: "test", URL: "http://127.0.0.1:1/"}) {
+		t.Fatal("expected a job beyond the queue size to be dropped")
+	}
+
+	var nilDispatcher *webhookDispatcher
+	if nilDispatcher.enqueue(webhookJob{Kind: "test"}) {
+		t.Fatal("a nil dispatcher should drop jobs")
+	}
+}
+
+func TestWebhookDispatcherBackoff(t *testing.T) {
+	d := newWebhookDispatcher(nil, 1, 10, 100*time.Millisecond, time.Second)
+
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{8, time.Second},
+	}
+	for _, tt := range tests {
+		got := d.backoff(tt.attempt)
+		if got < tt.base || got > tt.base+tt.base/5 {
+			t.Fatalf("backoff(%d) = %s, want %s plus at most 20%% jitter", tt.attempt, got, tt.base)
+		}
+	}
+}
+
+func TestWebhookDispatcherBreakerPerHost(t *testing.T) {
+	d := newWebhookDispatcher(nil, 1, 1, time.Millisecond, time.Millisecond)
+	if d.breaker("http://a.example/x") != d.breaker("http://a.example/y") {
+		t.Fatal("expected one breaker per host")
+	}
+	if d.breaker("http://a.example/x") == d.breaker("http://b.example/x") {
+		t.Fatal("expected separate breakers for separate hosts")
+	}
+}
+
+// waitForOutbox polls store until done accepts its jobs.
+func waitForOutbox(t *testing.T, store Store, done func([]*OutboxJob) bool) []*OutboxJob {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		jobs, err := store.OutboxJobs(context.Background(), "")
+		if err != nil {
+			t.Fatalf("OutboxJobs failed: %v", err)
+		}
+		if done(jobs) {
+			return jobs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox did not reach the expected state, got %+v", jobs)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWebhookDispatcherOutbox(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().CircuitBreaker.Threshold = 100
+
+	tests := []struct {
+		name          string
+		status        int
+		wantStatus    string // "" when the job should be cleared
+		wantAttempts  int
+		wantLastError string
+	}{
+		{"delivered job is cleared", http.StatusOK, "", 0, ""},
+		{"rejected job is marked failed", http.StatusBadRequest, outboxFailed, 1, "unexpected status 400"},
+		{"exhausted job is marked failed", http.StatusBadGateway, outboxFailed, 2, "unexpected status 502"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var webhookID atomic.Value
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				webhookID.Store(r.Header.Get("X-Webhook-ID"))
+				w.WriteHeader(tt.status)
+			}))
+			defer backend.Close()
+
+			store := newMemoryStore()
+			d := newWebhookDispatcher(backend.Client(), 8, 2, time.Millisecond, time.Millisecond)
+			d.outbox = store
+			startTestDispatcher(t, d, 1)
+
+			d.enqueue(webhookJob{Kind: "test", URL: backend.URL, Payload: []byte(`{"event":"test"}`)})
+			jobs := waitForOutbox(t, store, func(jobs []*OutboxJob) bool {
+				if tt.wantStatus == "" {
+					return len(jobs) == 0 && webhookID.Load() != nil
+				}
+				return len(jobs) == 1 && jobs[0].Status == tt.wantStatus
+			})
+
+			if id, _ := webhookID.Load().(string); id == "" {
+				t.Fatal("expected an X-Webhook-ID header")
+			}
+			if tt.wantStatus == "" {
+				return
+			}
+			if jobs[0].ID != webhookID.Load() || jobs[0].Attempts != tt.wantAttempts || jobs[0].LastError != tt.wantLastError {
+				t.Fatalf("unexpected outbox job %+v", jobs[0])
+			}
+			if string(jobs[0].Payload) != `{"event":"test"}` {
+				t.Fatalf("expected the payload to be kept, got %s", jobs[0].Payload)
+			}
+		})
+	}
+}
+
+func TestWebhookDispatcherReplaysPendingJobs(t *testing.T) {
+	setupTestAppConfig()
+	var delivered atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Webhook-ID") == "left-over" {
+			delivered.Add(1)
+		}
+	}))
+	defer backend.Close()
+
+	store := newMemoryStore()
+	ctx := context.Background()
+	_ = store.SaveOutboxJob(ctx, &OutboxJob{ID: "left-over", Kind: "test", URL: backend.URL, Payload: []byte(`{}`), Status: outboxPending, Attempts: 1})
+	_ = store.SaveOutboxJob(ctx, &OutboxJob{ID: "gave-up", Kind: "test", URL: backend.URL, Payload: []byte(`{}`), Status: outboxFailed})
+
+	d := newWebhookDispatcher(backend.Client(), 8, 3, time.Millisecond, time.Millisecond)
+	d.outbox = store
+	startTestDispatcher(t, d, 1)
+
+	replayed, err := d.replayOutbox(ctx)
+	if err != nil || replayed != 1 {
+		t.Fatalf("expected 1 job replayed, got %d (err %v)", replayed, err)
+	}
+	jobs := waitForOutbox(t, store, func(jobs []*OutboxJob) bool { return len(jobs) == 1 })
+	if jobs[0].ID != "gave-up" || delivered.Load() != 1 {
+		t.Fatalf("expected only the pending job to be delivered, got %+v (%d deliveries)", jobs, delivered.Load())
+	}
+}
+
+func TestHandleAdminWebhookOutbox(t *testing.T) {
+	setupTestAppConfig()
+	AppConfig().CircuitBreaker.Threshold = 100
+	var delivered atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered.Add(1)
+	}))
+	defer backend.Close()
+
+	store := newMemoryStore()
+	ctx := context.Background()
+	_ = store.SaveOutboxJob(ctx, &OutboxJob{ID: "failed-1", Kind: "abuse_ban", URL: backend.URL + "/hook?token=secret", Payload: []byte(`{}`), Headers: map[string]string{"X-Signature": "sig"}, Status: outboxFailed, Attempts: 5, LastError: "unexpected status 502"})
+	_ = store.SaveOutboxJob(ctx, &OutboxJob{ID: "pending-1", Kind: "abuse_ban", URL: backend.URL, Payload: []byte(`{}`), Status: outboxPending})
+
+	outboundWebhooks = newWebhookDispatcher(backend.Client(), 8, 3, time.Millisecond, time.Millisecond)
+	outboundWebhooks.outbox = store
+	t.Cleanup(func() { outboundWebhooks = nil })
+	startTestDispatcher(t, outboundWebhooks, 1)
+
+	handler := apiKeyMiddleware(handleAdminWebhookOutbox)
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-API-Key", "test-api-key")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(http.MethodGet, "/admin/webhooks/outbox?status=failed")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listed struct {
+		Jobs []outboxJobView `json:"jobs"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(listed.Jobs) != 1 || listed.Jobs[0].ID != "failed-1" || listed.Jobs[0].LastError != "unexpected status 502" {
+		t.Fatalf("expected only failed-1, got %+v", listed.Jobs)
+	}
+	if body := rr.Body.String(); strings.Contains(body, "secret") || strings.Contains(body, "sig") {
+		t.Fatalf("outbox listing leaked credentials: %s", body)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{"bad status filter", http.MethodGet, "/admin/webhooks/outbox?status=done", http.StatusBadRequest},
+		{"retry without id", http.MethodPost, "/admin/webhooks/outbox", http.StatusBadRequest},
+		{"retry unknown job", http.MethodPost, "/admin/webhooks/outbox?id=missing", http.StatusNotFound},
+		{"retry pending job", http.MethodPost, "/admin/webhooks/outbox?id=pending-1", http.StatusConflict},
+		{"retry failed job", http.MethodPost, "/admin/webhooks/outbox?id=failed-1", http.StatusAccepted},
+		{"discard job", http.MethodDelete, "/admin/webhooks/outbox?id=pending-1", http.StatusNoContent},
+		{"wrong method", http.MethodPut, "/admin/webhooks/outbox?id=pending-1", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := serve(tt.method, tt.target); rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	waitForOutbox(t, store, func(jobs []*OutboxJob) bool { return len(jobs) == 0 })
+	if delivered.Load() != 1 {
+		t.Fatalf("expected the retried job to be delivered once, got %d", delivered.Load())
+	}
+}
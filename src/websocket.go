@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -24,6 +29,8 @@ type CircuitBreaker struct {
 
 var backendCircuitBreaker = &CircuitBreaker{}
 
+var errCircuitOpen = errors.New("circuit breaker open - backend unavailable")
+
 type circuitBreakerFailure struct {
 	err error
 }
@@ -45,10 +52,10 @@ func markCircuitBreakerFailure(err error) error {
 
 func (cb *CircuitBreaker) Call(fn func() error) error {
 	cb.mu.Lock()
-	if cb.failures >= AppConfig.CircuitBreaker.Threshold {
-		if time.Since(cb.lastFailure) < AppConfig.CircuitBreaker.Timeout {
+	if cb.failures >= AppConfig().CircuitBreaker.Threshold {
+		if time.Since(cb.lastFailure) < AppConfig().CircuitBreaker.Timeout {
 			cb.mu.Unlock()
-			return errors.New("circuit breaker open - backend unavailable")
+			return errCircuitOpen
 		}
 		cb.failures = 0
 	}
@@ -74,18 +81,129 @@ func (cb *CircuitBreaker) Call(fn func() error) error {
 	return nil
 }
 
+// trip opens the breaker as if it had just reached its failure threshold.
+func (cb *CircuitBreaker) trip() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = AppConfig().CircuitBreaker.Threshold
+	cb.lastFailure = time.Now()
+}
+
+// state reports the breaker as "open" while it refuses calls, "half-open"
+// once its timeout has passed and the next call will try the backend again,
+// and "closed" otherwise, along with the failures counted towards opening it.
+func (cb *CircuitBreaker) state(now time.Time) (string, int) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch {
+	case cb.failures < AppConfig().CircuitBreaker.Threshold:
+		return "closed", cb.failures
+	case now.Sub(cb.lastFailure) < AppConfig().CircuitBreaker.Timeout:
+		return "open", cb.failures
+	}
+	return "half-open", cb.failures
+}
+
+// reset closes the breaker.
+func (cb *CircuitBreaker) reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+}
+
+// outboundMessage is a frame queued for a client's writePump. receivedAt is
+// the time the originating /send request arrived and is zero for frames that
+// should not be counted towards delivery latency.
+type outboundMessage struct {
+	payload        []byte
+	receivedAt     time.Time
+	tenantID       string
+	teamID         string
+	messageType    string
+	notificationID string
+	fanout         *fanoutCache     // shared across recipients; nil for control frames
+	links          *attachmentLinks // shared across recipients; nil without pre-signed attachments
+	visibility     visibilityRules  // nil shows the message to every recipient
+	deferredAt     time.Time        // when a blackout held the message back
+	handshake      bool             // authSuccess, which leads the send queue
+	priority       bool             // skips subscription filters and digests, as mentions do
+	channel        string           // set for a channel publish, which reaches only the channel's subscribers
+	urgency        string           // level whose UrgencyPolicy applies; "" is normal
+}
+
+// reaches reports whether a delivery that spans teams may go to client: the
+// client must be in the message's tenant and not a __stats__ subscriber.
+func (m outboundMessage) reaches(client *Client) bool {
+	return client.tenantID == m.tenantID && client.teamID != statsTeamID
+}
+
 type Client struct {
-	hub             *Hub
+	hub             NotificationHub
 	conn            Conn
-	send            chan []byte
-	teamID          string
+	send            chan outboundMessage
+	control         chan outboundMessage // small, prioritized channel for control frames
+	tenantID        string
+	teamID          string // hub key; "<tenant>/<team>" for tenant clients
 	userID          string
+	email           string       // from the backend or the JWT; may be empty
+	connID          string       // short random ID assigned at upgrade, for correlating logs
+	protocol        wireProtocol // negotiated subprotocol; "" behaves as json.v1
 	isAuthenticated bool
+	teamAdmin       bool // the backend reported the user as an admin of the team
+	attributes      clientAttributes
+	filter          *clientFilter
+	digest          *clientDigest
+	caps            clientCapabilities
+	acks            *ackTracker         // nil unless the client declared supportsAck
+	reserved        bool                // holds a place in its team until registered; guarded by hub.mu
+	resumeToken     string              // issued by a handover; set before migrating
+	handshakeQueued bool                // authSuccess leads the send queue; see queueAuthSuccess
+	channels        map[string]struct{} // channels subscribed to in its team; guarded by hub.mu
+
+	supervisor *pumpSupervisor // set by startPumps
+
+	// Pump liveness and unregister time (unix nanos) observed by the leak watchdog.
+	readPumpAlive  atomic.Bool
+	writePumpAlive atomic.Bool
+	unregisteredAt atomic.Int64
+
+	// Last pong (unix nanos) and consecutive saturated-buffer sweeps, used by the reaper.
+	lastPong         atomic.Int64
+	fullBufferSweeps atomic.Int32
+
+	backpressureSignaled atomic.Bool
+
+	// migrating is set once the client has been sent a handover migrate frame.
+	migrating atomic.Bool
+}
+
+// logger returns the default logger with the connection's team, user and
+// connection ID, and its tenant if it has one, so the sockets of one user's
+// devices can be told apart.
+func (c *Client) logger() *slog.Logger {
+	logger := slog.With("team", c.teamID, "user", c.userID, "conn", c.connID)
+	if c.tenantID != "" {
+		logger = logger.With("tenant", c.tenantID)
+	}
+	return logger
+}
+
+// newConnectionID returns a short random ID for a new websocket connection.
+func newConnectionID() string {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
 }
 
 type verifiedUser struct {
 	ID             string
+	Email          string
 	SelectedTeamID string
+	TeamAdmin      bool
+	Roles          []string // matched by role visibility rules
+	Groups         []string // matched by group visibility rules
 }
 
 func scalarToString(value any) (string, bool) {
@@ -112,6 +230,18 @@ func extractSelectedTeamID(raw map[string]any) string {
 	return ""
 }
 
+// extractTeamAdmin reads isTeamAdmin from the user's settings or, failing
+// that, the top level of the auth response. A missing flag means false.
+func extractTeamAdmin(raw map[string]any) bool {
+	if settings, ok := raw["settings"].(map[string]any); ok {
+		if admin, ok := settings["isTeamAdmin"].(bool); ok {
+			return admin
+		}
+	}
+	admin, _ := raw["isTeamAdmin"].(bool)
+	return admin
+}
+
 func parseVerifiedUser(body []byte) (*verifiedUser, error) {
 	var raw map[string]any
 	if err := json.Unmarshal(body, &raw); err != nil {
@@ -122,79 +252,223 @@ func parseVerifiedUser(body []byte) (*verifiedUser, error) {
 	if !ok {
 		return nil, errors.New("authentication response missing user id")
 	}
+	email, _ := scalarToString(raw["email"])
 
 	return &verifiedUser{
 		ID:             userID,
+		Email:          email,
 		SelectedTeamID: extractSelectedTeamID(raw),
+		TeamAdmin:      extractTeamAdmin(raw),
+		Roles:          extractStringList(raw, "roles"),
+		Groups:         extractStringList(raw, "groups"),
 	}, nil
 }
 
-func (c *Client) readPump() {
+// readPump reads the client's frames until the connection ends. It returns
+// an error when the connection ended abnormally.
+func (c *Client) readPump(ctx context.Context) error {
+	c.readPumpAlive.Store(true)
 	defer func() {
-		log.Printf("🔌 [%s:%s] ReadPump closing - unregistering client", c.teamID, c.userID)
-		c.hub.unregister <- c
+		c.readPumpAlive.Store(false)
+		c.logger().Debug("ReadPump closing")
 		if c.conn != nil {
 			c.conn.Close()
 		}
 	}()
 
-	log.Printf("🔌 [%s:%s] ReadPump started for client", c.teamID, c.userID)
+	c.logger().Debug("ReadPump started")
 
-	c.conn.SetReadLimit(AppConfig.WebSocket.MaxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(AppConfig.WebSocket.PongWait))
+	c.conn.SetReadLimit(AppConfig().WebSocket.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(AppConfig().WebSocket.PongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(AppConfig.WebSocket.PongWait))
+		c.lastPong.Store(time.Now().UnixNano())
+		c.conn.SetReadDeadline(time.Now().Add(AppConfig().WebSocket.PongWait))
 		return nil
 	})
 
 	for {
-		if _, _, err := c.conn.ReadMessage(); err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("❌ [%s:%s] WebSocket unexpected close error: %v", c.teamID, c.userID, err)
-			} else {
-				log.Printf("🔌 [%s:%s] WebSocket connection closed: %v", c.teamID, c.userID, err)
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil && websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				c.logger().Error("WebSocket unexpected close error", "error", err)
+				return err
 			}
-			return
+			c.logger().Debug("WebSocket connection closed", "error", err)
+			return nil
 		}
 
-		// This server is delivery-only. Clients authenticate and then only receive messages.
-		return
+		// This server is delivery-only. Clients authenticate and then only
+		// send the frames registered in clientFrameTypes.
+		if err := c.handleFrame(messageType, data); err != nil {
+			c.logger().Warn("Closing after a malformed frame", "error", err)
+			abuseGuard.record(userSubject(c.userID), violationMalformedMessage)
+			return err
+		}
 	}
 }
 
-func (c *Client) writePump() {
-	ticker := time.NewTicker(AppConfig.WebSocket.PingPeriod)
+// writePump writes queued frames until the send queue is closed or ctx ends.
+// It returns an error when a write fails.
+func (c *Client) writePump(ctx context.Context) error {
+	c.writePumpAlive.Store(true)
+	// Periodic work runs on the shared clientTimers wheel rather than on
+	// tickers of its own.
+	ticker := clientTimers.every(AppConfig().WebSocket.PingPeriod)
 	defer func() {
-		log.Printf("🔌 [%s:%s] WritePump closing", c.teamID, c.userID)
+		c.writePumpAlive.Store(false)
+		c.logger().Debug("WritePump closing")
 		ticker.Stop()
 		if c.conn != nil {
 			c.conn.Close()
 		}
 	}()
 
+	var digestTick <-chan struct{}
+	var digestFlush <-chan struct{}
+	if c.digest != nil {
+		digestTicker := clientTimers.every(c.digest.interval)
+		defer digestTicker.Stop()
+		digestTick = digestTicker.C
+		digestFlush = c.digest.flush
+	}
+
+	var ackTick <-chan struct{}
+	if c.acks != nil {
+		ackTicker := clientTimers.every(AppConfig().WebSocket.AckTimeout)
+		defer ackTicker.Stop()
+		ackTick = ackTicker.C
+		// Whatever is still unacknowledged when the connection ends is missed.
+		defer c.acks.expire(time.Now().Add(time.Hour))
+	}
+
+	if err := c.writeHandshake(); err != nil {
+		c.logger().Error("Failed to write authSuccess", "error", err)
+		return err
+	}
+
 	for {
+		// Control frames are serviced before queued notifications so the
+		// connection stays healthy even when data is backed up.
+		select {
+		case message := <-c.control:
+			if err := c.writeControl(message); err != nil {
+				c.logger().Error("Failed to write control message", "error", err)
+				return err
+			}
+			continue
+		default:
+		}
+
 		select {
+		case <-ctx.Done():
+			return nil
+
+		case message := <-c.control:
+			if err := c.writeControl(message); err != nil {
+				c.logger().Error("Failed to write control message", "error", err)
+				return err
+			}
+
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(AppConfig.WebSocket.WriteWait))
+			c.conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
 			if !ok {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+				return nil
+			}
+			batch, closed := c.collectBatch(message)
+			if err := c.writeNotifications(c.dropRevoked(batch)); err != nil {
+				c.logger().Error("Failed to write message", "error", err)
+				return err
+			}
+			if closed {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return nil
+			}
+			if err := c.clearBackpressure(); err != nil {
+				c.logger().Error("Failed to clear backpressure", "error", err)
+				return err
+			}
+
+		case <-digestTick:
+			if err := c.writeDigest(); err != nil {
+				c.logger().Error("Failed to write digest", "error", err)
+				return err
 			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				log.Printf("❌ [%s:%s] Failed to write message: %v", c.teamID, c.userID, err)
-				return
+
+		case <-digestFlush:
+			if err := c.writeDigest(); err != nil {
+				c.logger().Error("Failed to write digest", "error", err)
+				return err
+			}
+
+		case <-ackTick:
+			if missed := c.acks.expire(time.Now().Add(-AppConfig().WebSocket.AckTimeout)); missed > 0 {
+				c.logger().Warn("Notifications not acknowledged in time", "missed", missed, "ack_timeout", AppConfig().WebSocket.AckTimeout)
 			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(AppConfig.WebSocket.WriteWait))
+			c.conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("❌ [%s:%s] Failed to send ping: %v", c.teamID, c.userID, err)
-				return
+				c.logger().Error("Failed to send ping", "error", err)
+				return err
 			}
 		}
 	}
 }
 
+func (c *Client) writeDigest() error {
+	frame, err := c.digest.nextFrame()
+	if err != nil || frame == nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+	return c.writeFrame(frame, false)
+}
+
+// writeHandshake writes the authSuccess frame at the head of the send queue,
+// followed by the preferences snapshot, before anything else the writePump
+// writes. Control frames and notifications queued since the client registered
+// wait behind it, so authSuccess is always the client's first frame.
+func (c *Client) writeHandshake() error {
+	if !c.handshakeQueued {
+		return nil
+	}
+	message, ok := <-c.send
+	if !ok {
+		return nil // the loop writes the close frame
+	}
+	if !message.handshake {
+		return fmt.Errorf("expected authSuccess at the head of the send queue")
+	}
+	messageType, data, err := c.protocol.encodeFrame(message.payload, false)
+	if err != nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	sendPreferencesSnapshot(c)
+	return nil
+}
+
+func (c *Client) writeControl(message outboundMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(AppConfig().WebSocket.WriteWait))
+	return c.writeFrame(message.payload, false)
+}
+
+// teamMismatchError is returned when a verified user asks to join a team other
+// than their selected one, which counts as a spoofing attempt.
+type teamMismatchError struct {
+	userID    string
+	requested string
+	selected  string
+}
+
+func (e *teamMismatchError) Error() string {
+	return fmt.Sprintf("requested team %q does not match selectedTeam %q", e.requested, e.selected)
+}
+
 func (c *Client) authenticate(authMsg AuthMessage) error {
 	teamID := strings.TrimSpace(authMsg.TeamID)
 	token := strings.TrimSpace(authMsg.Token)
@@ -205,6 +479,9 @@ func (c *Client) authenticate(authMsg AuthMessage) error {
 	if token == "" {
 		return errors.New("token is required")
 	}
+	if teamID == statsTeamID {
+		return c.authenticateStatsSubscriber(authMsg.UserID, token)
+	}
 
 	if IsFakeAuthEnabled() && token == "fake_development_token" {
 		userID := strings.TrimSpace(authMsg.UserID)
@@ -216,21 +493,25 @@ func (c *Client) authenticate(authMsg AuthMessage) error {
 		c.teamID = teamID
 		c.isAuthenticated = true
 
-		log.Printf("✅ FAKE Client authenticated: user=%s, team=%s", userID, teamID)
+		slog.Debug("Fake client authenticated", "user", userID, "team", teamID)
 		return nil
 	}
 
 	if token == "fake_development_token" {
-		log.Printf("❌ SECURITY: Fake token rejected in production mode")
+		slog.Warn("SECURITY: Fake token rejected in production mode")
 		return errors.New("invalid authentication token")
 	}
 
+	if tokenVerifier != nil {
+		return c.authenticateJWT(tokenVerifier, token, teamID)
+	}
+
 	if httpClient == nil {
-		httpClient = &http.Client{Timeout: AppConfig.Backend.Timeout}
+		httpClient = &http.Client{Timeout: AppConfig().Backend.Timeout}
 	}
 
-	return backendCircuitBreaker.Call(func() error {
-		req, err := http.NewRequest(http.MethodGet, strings.TrimRight(AppConfig.Backend.URL, "/")+"/rest-auth/user/", nil)
+	err := currentBackends().call(func(baseURL string) error {
+		req, err := http.NewRequest(http.MethodGet, baseURL+"/rest-auth/user/", nil)
 		if err != nil {
 			return err
 		}
@@ -244,7 +525,7 @@ func (c *Client) authenticate(authMsg AuthMessage) error {
 
 		switch res.StatusCode {
 		case http.StatusUnauthorized:
-			return errors.New("invalid JWT token provided")
+			return errInvalidJWT
 		case http.StatusOK:
 			bodyBytes, err := io.ReadAll(res.Body)
 			if err != nil {
@@ -259,14 +540,18 @@ func (c *Client) authenticate(authMsg AuthMessage) error {
 				return errors.New("authentication response missing selectedTeam")
 			}
 			if userData.SelectedTeamID != teamID {
-				return fmt.Errorf("requested team %q does not match selectedTeam %q", teamID, userData.SelectedTeamID)
+				return &teamMismatchError{userID: userData.ID, requested: teamID, selected: userData.SelectedTeamID}
 			}
 
 			c.userID = userData.ID
+			c.email = userData.Email
 			c.teamID = teamID
+			c.teamAdmin = userData.TeamAdmin
+			c.attributes.setIdentity(userData.Roles, userData.Groups, userData.TeamAdmin)
 			c.isAuthenticated = true
+			backendAuthCache.remember(token, teamID, userData)
 
-			log.Printf("✅ Client authenticated: user=%s, team=%s", userData.ID, teamID)
+			slog.Debug("Client authenticated", "user", userData.ID, "team", teamID)
 			return nil
 		default:
 			err := errors.New("authentication failed with status: " + res.Status)
@@ -276,6 +561,26 @@ func (c *Client) authenticate(authMsg AuthMessage) error {
 			return err
 		}
 	})
+	if err == nil || isCredentialFailure(err) {
+		return err
+	}
+
+	// The backend could not be asked; fall back to a recent successful
+	// authentication of the same token for the same team.
+	cached, ok := backendAuthCache.lookup(token, teamID)
+	if !ok {
+		return err
+	}
+	c.userID = cached.userID
+	c.email = cached.email
+	c.teamID = teamID
+	c.teamAdmin = cached.teamAdmin
+	c.attributes.setIdentity(cached.roles, cached.groups, cached.teamAdmin)
+	c.isAuthenticated = true
+	appMetrics.Count("auth.cached", 1)
+
+	slog.Warn("Client authenticated from cache, backend unavailable", "user", cached.userID, "team", teamID, "error", err)
+	return nil
 }
 
 type HubHealth struct {
@@ -283,19 +588,54 @@ type HubHealth struct {
 	TotalClients int
 }
 
+// hubRequest asks the run loop to add or remove client. done, when set, is
+// closed once the request has been applied.
+type hubRequest struct {
+	client *Client
+	done   chan struct{}
+}
+
 // Hub maintains the set of active clients and broadcasts messages to them.
 type Hub struct {
 	clients    map[string]map[string]map[*Client]struct{}
-	register   chan *Client
-	unregister chan *Client
+	register   chan hubRequest
+	unregister chan hubRequest
 	mu         sync.RWMutex
+
+	// channels holds each team's channel subscribers, by channel.
+	channels map[string]map[string]map[*Client]struct{}
+
+	// reserved counts, per team, the places held by handshakes that were
+	// admitted but have not registered yet. Client limits count them as taken.
+	reserved map[string]int
+
+	// returning holds the users of a restored snapshot's roster, by team,
+	// until they reconnect or returningUntil passes.
+	returning      map[string]map[string]struct{}
+	returningUntil time.Time
+
+	// handover is the latest blue/green handover started on this instance.
+	handover atomic.Pointer[handover]
+
+	// Lifetime counts of messages queued for and dropped at clients, sampled
+	// by the __stats__ feed.
+	enqueued atomic.Int64
+	dropped  atomic.Int64
+
+	// batchWindow and batchSize are websocket.register_batch_window and
+	// register_batch_size, set before run starts. A zero batchSize does not
+	// limit batches.
+	batchWindow time.Duration
+	batchSize   int
 }
 
 func newHub() *Hub {
 	return &Hub{
 		clients:    make(map[string]map[string]map[*Client]struct{}),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		register:   make(chan hubRequest),
+		unregister: make(chan hubRequest),
+		channels:   make(map[string]map[string]map[*Client]struct{}),
+		reserved:   make(map[string]int),
 	}
 }
 
@@ -329,27 +669,136 @@ func (h *Hub) snapshotAllClients() []*Client {
 	return clients
 }
 
-// run processes client registrations and unregistrations.
+// run processes client registrations and unregistrations, in batches when
+// many arrive at once.
 func (h *Hub) run() {
+	batch := make([]hubOperation, 0, 64)
 	for {
 		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			if _, ok := h.clients[client.teamID]; !ok {
-				h.clients[client.teamID] = make(map[string]map[*Client]struct{})
-			}
-			if _, ok := h.clients[client.teamID][client.userID]; !ok {
-				h.clients[client.teamID][client.userID] = make(map[*Client]struct{})
-			}
-			h.clients[client.teamID][client.userID][client] = struct{}{}
-			h.mu.Unlock()
+		case request := <-h.register:
+			batch = append(batch, hubOperation{hubRequest: request, register: true})
+		case request := <-h.unregister:
+			batch = append(batch, hubOperation{hubRequest: request})
+		}
+		batch = h.collectBatch(batch)
+		h.applyBatch(batch)
+		batch = batch[:0]
+	}
+}
 
-			log.Printf("✅ Client registered: team=%s, user=%s", client.teamID, client.userID)
+// hubOperation is a register or unregister request taken by the run loop.
+type hubOperation struct {
+	hubRequest
+	register bool
+}
 
-		case client := <-h.unregister:
-			h.removeClient(client)
+// collectBatch adds the requests queued behind the first one to batch. When
+// there are some, a burst such as a mass reconnect is under way, and
+// requests arriving within websocket.register_batch_window are added too, up
+// to websocket.register_batch_size in all.
+func (h *Hub) collectBatch(batch []hubOperation) []hubOperation {
+	var deadline <-chan time.Time
+	for h.batchSize == 0 || len(batch) < h.batchSize {
+		select {
+		case request := <-h.register:
+			batch = append(batch, hubOperation{hubRequest: request, register: true})
+			continue
+		case request := <-h.unregister:
+			batch = append(batch, hubOperation{hubRequest: request})
+			continue
+		default:
+		}
+		if len(batch) == 1 || h.batchWindow <= 0 {
+			return batch
+		}
+		if deadline == nil {
+			timer := time.NewTimer(h.batchWindow)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case request := <-h.register:
+			batch = append(batch, hubOperation{hubRequest: request, register: true})
+		case request := <-h.unregister:
+			batch = append(batch, hubOperation{hubRequest: request})
+		case <-deadline:
+			return batch
 		}
 	}
+	return batch
+}
+
+// applyBatch applies the requests in the order they arrived under one hold
+// of the lock, then updates each team touched by a registration once, with
+// its final client count, rather than once per client.
+func (h *Hub) applyBatch(batch []hubOperation) {
+	registered := make(map[string]string) // team -> tenant
+	applied := make([]bool, len(batch))
+	onlyConnection := make([]bool, len(batch)) // the user's first or last in the team
+
+	h.mu.Lock()
+	for i, op := range batch {
+		if op.register {
+			onlyConnection[i] = h.addClientLocked(op.client)
+			registered[op.client.teamID] = op.client.tenantID
+			applied[i] = true
+		} else {
+			applied[i], onlyConnection[i] = h.removeClientLocked(op.client)
+		}
+	}
+	teamClients := make(map[string]int, len(registered))
+	for teamID := range registered {
+		teamClients[teamID] = h.getTeamClientCountLocked(teamID)
+	}
+	h.mu.Unlock()
+
+	now := time.Now()
+	for i, op := range batch {
+		switch {
+		case op.register:
+			h.registered(op.client, now, onlyConnection[i])
+		case applied[i]:
+			h.removed(op.client, now, onlyConnection[i])
+		}
+	}
+	for teamID, tenantID := range registered {
+		teamQuotas.observeClients(h, tenantID, teamID, teamClients[teamID])
+	}
+	if len(batch) > 1 {
+		appMetrics.Count("hub.batched_requests", int64(len(batch)))
+	}
+	for _, op := range batch {
+		op.ack()
+	}
+}
+
+// addClientLocked adds client alongside the user's other connections in its
+// team and reports whether it is the user's first there.
+func (h *Hub) addClientLocked(client *Client) bool {
+	if _, ok := h.clients[client.teamID]; !ok {
+		h.clients[client.teamID] = make(map[string]map[*Client]struct{})
+	}
+	first := false
+	if _, ok := h.clients[client.teamID][client.userID]; !ok {
+		h.clients[client.teamID][client.userID] = make(map[*Client]struct{})
+		first = true
+	}
+	h.clients[client.teamID][client.userID][client] = struct{}{}
+	h.releaseReservationLocked(client)
+	h.resumeLocked(client)
+	return first
+}
+
+// registered records a client added to the roster. first is set for the
+// user's first connection in the team, which also publishes userJoined.
+func (h *Hub) registered(client *Client, now time.Time, first bool) {
+	client.logger().Debug("Client registered")
+	liveEvents.publish(controlEvent{Type: "connect", TeamID: client.teamID, UserID: client.userID, ConnID: client.connID})
+	if first {
+		liveEvents.publish(controlEvent{Type: "userJoined", TeamID: client.teamID, UserID: client.userID})
+		teamWebhooks.notifyPresence(client, "userJoined")
+	}
+	presenceHistory.connected(client, now)
 }
 
 // canAddClient checks if we can add another client to a team.
@@ -357,10 +806,30 @@ func (h *Hub) canAddClient(teamID string) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	if _, ok := h.clients[teamID]; ok {
-		return h.getTeamClientCountLocked(teamID) < AppConfig.Limits.MaxClientsPerTeam
+	return h.teamLoadLocked(teamID) < AppConfig().Limits.MaxClientsPerTeam
+}
+
+// teamLoadLocked counts a team's registered clients and reserved places.
+func (h *Hub) teamLoadLocked(teamID string) int {
+	return h.getTeamClientCountLocked(teamID) + h.reserved[teamID]
+}
+
+// releaseReservation gives back the place reserveClient held for client if
+// its handshake ends without registering it.
+func (h *Hub) releaseReservation(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.releaseReservationLocked(client)
+}
+
+func (h *Hub) releaseReservationLocked(client *Client) {
+	if !client.reserved {
+		return
+	}
+	client.reserved = false
+	if h.reserved[client.teamID]--; h.reserved[client.teamID] <= 0 {
+		delete(h.reserved, client.teamID)
 	}
-	return true
 }
 
 func (h *Hub) getTeamClientCountLocked(teamID string) int {
@@ -400,14 +869,72 @@ func (h *Hub) healthCheck() HubHealth {
 	}
 }
 
-func (h *Hub) enqueueMessage(client *Client, message []byte) (sent bool) {
+// TeamStats describes one connected team for the admin dashboard.
+type TeamStats struct {
+	TeamID     string `json:"team_id"`
+	Users      int    `json:"users"`
+	Clients    int    `json:"clients"`
+	QueueDepth int    `json:"queue_depth"`
+}
+
+// teamStats returns per-team connection counts and the number of messages
+// waiting in the clients' send buffers, sorted by team ID.
+func (h *Hub) teamStats() []TeamStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := make([]TeamStats, 0, len(h.clients))
+	for teamID, teamClients := range h.clients {
+		team := TeamStats{TeamID: teamID, Users: len(teamClients)}
+		for _, userClients := range teamClients {
+			for client := range userClients {
+				team.Clients++
+				team.QueueDepth += len(client.send)
+			}
+		}
+		stats = append(stats, team)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].TeamID < stats[j].TeamID })
+	return stats
+}
+
+func (h *Hub) enqueueMessage(client *Client, message outboundMessage) (sent bool) {
 	if client == nil {
 		return false
 	}
+	if h.handingOver(client) {
+		messageFirehose.record(message, client, firehoseHeld)
+		return false // held for the instance it is migrating to
+	}
+	policy := urgencyPolicyOf(message.urgency)
+	if !message.priority && policy.Mutes && !client.filter.accepts(message) {
+		appMetrics.Count("messages.filtered", 1)
+		messageFirehose.record(message, client, firehoseFiltered)
+		return false
+	}
+	if !message.visibility.allows(client) {
+		appMetrics.Count("messages.hidden", 1)
+		messageFirehose.record(message, client, firehoseHidden)
+		return false
+	}
+	if notificationRecalls.isRevoked(message.tenantID, message.notificationID) {
+		messageFirehose.record(message, client, firehoseRecalled)
+		return false
+	}
+	if policy.DND && client.inDND() {
+		appMetrics.Count("messages.withheld", 1, metricTag("reason", "dnd"))
+		messageFirehose.record(message, client, firehoseWithheld)
+		return false
+	}
+	if !message.priority && policy.Digest && client.digest.wants(message) {
+		client.digest.add(message.notificationID, message.deliveryPayload())
+		messageFirehose.record(message, client, firehoseDigested)
+		return true
+	}
 
 	defer func() {
 		if recovered := recover(); recovered != nil {
-			log.Printf("🧹 Recovered while enqueueing message for %s/%s", client.teamID, client.userID)
+			client.logger().Warn("Recovered while enqueueing message")
 			sent = false
 			h.disconnectClient(client, "send channel closed")
 		}
@@ -415,16 +942,39 @@ func (h *Hub) enqueueMessage(client *Client, message []byte) (sent bool) {
 
 	select {
 	case client.send <- message:
+		h.enqueued.Add(1)
+		messageFirehose.record(message, client, firehoseQueued)
+		h.signalBackpressure(client)
 		return true
 	default:
+		h.dropped.Add(1)
+		appMetrics.Count("messages.dropped", 1, metricTag("reason", "send_buffer_full"))
+		messageFirehose.record(message, client, firehoseDropped)
+		serviceLevels.dropped(time.Now())
 		h.disconnectClient(client, "send buffer full")
 		return false
 	}
 }
 
+// userConnected reports whether userID has a connection in teamID, or in any
+// team of tenantID when teamID is empty.
+func (h *Hub) userConnected(tenantID, teamID, userID string) bool {
+	if teamID != "" {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		return len(h.clients[teamID][userID]) > 0
+	}
+	for _, client := range h.snapshotAllClients() {
+		if client.userID == userID && client.tenantID == tenantID && client.teamID != statsTeamID {
+			return true
+		}
+	}
+	return false
+}
+
 // sendToUser sends a message to a specific user.
 // If teamID is empty, the message is delivered to every connected session for that user across all teams.
-func (h *Hub) sendToUser(teamID, userID string, message []byte) int {
+func (h *Hub) sendToUser(teamID, userID string, message outboundMessage) int {
 	teamID = strings.TrimSpace(teamID)
 	userID = strings.TrimSpace(userID)
 	if userID == "" {
@@ -440,7 +990,9 @@ func (h *Hub) sendToUser(teamID, userID string, message []byte) int {
 		}
 		h.mu.RUnlock()
 
-		count := 0
+		count := h.holdForHandover(message, func(session *handoverSession) bool {
+			return session.teamID == teamID && session.userID == userID
+		})
 		for _, client := range clients {
 			if h.enqueueMessage(client, message) {
 				count++
@@ -449,8 +1001,13 @@ func (h *Hub) sendToUser(teamID, userID string, message []byte) int {
 		return count
 	}
 
-	count := 0
+	count := h.holdForHandover(message, func(session *handoverSession) bool {
+		return session.tenantID == message.tenantID && session.userID == userID
+	})
 	for _, client := range h.snapshotAllClients() {
+		if !message.reaches(client) {
+			continue
+		}
 		if client.userID == userID && h.enqueueMessage(client, message) {
 			count++
 		}
@@ -458,13 +1015,15 @@ func (h *Hub) sendToUser(teamID, userID string, message []byte) int {
 	return count
 }
 
-func (h *Hub) broadcastToTeam(teamID string, message []byte) int {
+func (h *Hub) broadcastToTeam(teamID string, message outboundMessage) int {
 	teamID = strings.TrimSpace(teamID)
 	if teamID == "" {
 		return 0
 	}
 
-	count := 0
+	count := h.holdForHandover(message, func(session *handoverSession) bool {
+		return session.teamID == teamID
+	})
 	for _, client := range h.snapshotTeamClients(teamID) {
 		if h.enqueueMessage(client, message) {
 			count++
@@ -474,9 +1033,14 @@ func (h *Hub) broadcastToTeam(teamID string, message []byte) int {
 }
 
 // broadcastToAllTeams sends a message to all users across all teams.
-func (h *Hub) broadcastToAllTeams(message []byte) int {
-	count := 0
+func (h *Hub) broadcastToAllTeams(message outboundMessage) int {
+	count := h.holdForHandover(message, func(session *handoverSession) bool {
+		return session.tenantID == message.tenantID
+	})
 	for _, client := range h.snapshotAllClients() {
+		if !message.reaches(client) {
+			continue
+		}
 		if h.enqueueMessage(client, message) {
 			count++
 		}
@@ -484,53 +1048,131 @@ func (h *Hub) broadcastToAllTeams(message []byte) int {
 	return count
 }
 
+// enqueueControl queues a control frame (backpressure, presence, flags) on the
+// client's dedicated control channel. Control frames never displace data and
+// are dropped rather than blocking when the control channel is full.
+func (h *Hub) enqueueControl(client *Client, message outboundMessage) bool {
+	if client == nil {
+		return false
+	}
+
+	select {
+	case client.control <- message:
+		return true
+	default:
+		appMetrics.Count("control.dropped", 1)
+		client.logger().Warn("Dropping control frame: control channel full")
+		return false
+	}
+}
+
+// sendControlToUser queues a control frame on every connection of userID in
+// the tenant but except.
+func (h *Hub) sendControlToUser(tenantID, userID string, except *Client, message outboundMessage) int {
+	message.tenantID = tenantID
+	count := 0
+	for _, client := range h.snapshotAllClients() {
+		if client != except && client.userID == userID && message.reaches(client) && h.enqueueControl(client, message) {
+			count++
+		}
+	}
+	return count
+}
+
 func (h *Hub) disconnectClient(client *Client, reason string) {
 	if client == nil {
 		return
 	}
 
-	log.Printf("🧹 Disconnecting client %s/%s: %s", client.teamID, client.userID, reason)
+	client.logger().Info("Disconnecting client", "reason", reason)
 	if client.conn != nil {
 		client.conn.Close()
 	}
 
-	go func() {
-		h.unregister <- client
-	}()
+	go h.Unregister(client)
 }
 
-// removeClient safely removes a client if it is still the active connection for that user.
-func (h *Hub) removeClient(client *Client) bool {
-	if client == nil {
-		return false
+// disconnectUser disconnects every session of a user across all teams.
+func (h *Hub) disconnectUser(userID, reason string) int {
+	count := 0
+	for _, client := range h.snapshotAllClients() {
+		if client.userID == userID {
+			h.disconnectClient(client, reason)
+			count++
+		}
 	}
+	return count
+}
 
+// removeClient takes client off the roster if it is registered. The user's
+// other connections are left alone.
+func (h *Hub) removeClient(client *Client) bool {
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	removed, last := h.removeClientLocked(client)
+	h.mu.Unlock()
+	if removed {
+		h.removed(client, time.Now(), last)
+	}
+	return removed
+}
+
+// removeClientLocked takes client off the roster. It reports whether the
+// client was registered, and whether it was the user's last connection in
+// its team; the user's other devices stay connected.
+func (h *Hub) removeClientLocked(client *Client) (removed, last bool) {
+	if client == nil {
+		return false, false
+	}
 
 	teamClients, ok := h.clients[client.teamID]
 	if !ok {
-		return false
+		return false, false
 	}
 
 	userClients, ok := teamClients[client.userID]
 	if !ok {
-		return false
+		return false, false
 	}
 
 	if _, ok := userClients[client]; !ok {
-		return false
+		return false, false
 	}
 
 	delete(userClients, client)
+	h.unsubscribeAllLocked(client)
 	close(client.send)
+	if client.migrating.Load() {
+		// Whatever it had not written yet goes to the new instance.
+		var queued []outboundMessage
+		for message := range client.send {
+			if !message.handshake {
+				queued = append(queued, message)
+			}
+		}
+		h.handover.Load().requeue(client.resumeToken, queued)
+	}
+	client.unregisteredAt.Store(time.Now().UnixNano())
 
 	if len(userClients) == 0 {
 		delete(teamClients, client.userID)
+		last = true
 	}
 	if len(teamClients) == 0 {
 		delete(h.clients, client.teamID)
 	}
 
-	return true
+	return true, last
+}
+
+// removed records a client taken off the roster. last is set for the user's
+// last connection in the team, which also publishes userLeft.
+func (h *Hub) removed(client *Client, now time.Time, last bool) {
+	appMetrics.Count("connections.closed", 1)
+	liveEvents.publish(controlEvent{Type: "disconnect", TeamID: client.teamID, UserID: client.userID, ConnID: client.connID})
+	if last {
+		liveEvents.publish(controlEvent{Type: "userLeft", TeamID: client.teamID, UserID: client.userID})
+		teamWebhooks.notifyPresence(client, "userLeft")
+	}
+	presenceHistory.disconnected(client, now)
+	keywordAlerts.forget(client)
 }
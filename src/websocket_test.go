@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -82,15 +83,46 @@ func (c *mockConn) WriteJSON(v interface{}) error {
 
 // setupTestAppConfig initializes a minimal AppConfig for testing purposes.
 func setupTestAppConfig() {
-	AppConfig = &Config{}
-	setDefaults(AppConfig) // Apply defaults
-	AppConfig.Security.APIKey = "test-api-key"
-	AppConfig.Backend.URL = "http://test.backend"
-	AppConfig.Server.AllowedOrigins = []string{"*"}
-	AppConfig.Environment.Mode = "production"
+	setAppConfig(&Config{})
+	setDefaults(AppConfig()) // Apply defaults
+	AppConfig().Security.APIKey = "test-api-key"
+	AppConfig().Backend.URL = "http://test.backend"
+	AppConfig().Server.AllowedOrigins = []string{"*"}
+	AppConfig().Environment.Mode = "production"
 	backendCircuitBreaker = &CircuitBreaker{}
 	httpClient = nil
-	requestRateLimiter = nil
+	setRateLimits(nil, nil)
+}
+
+// stopWritePump closes the client's send queue and waits for its writePump to
+// exit, so the pump cannot outlive the test that started it.
+func stopWritePump(t *testing.T, client *Client) {
+	t.Helper()
+	close(client.send)
+	for deadline := time.Now().Add(time.Second); client.writePumpAlive.Load(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for writePump to exit")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// registerAndWait registers clients with a running hub and returns once they
+// are all in its roster.
+func registerAndWait(t *testing.T, hub *Hub, clients ...*Client) {
+	t.Helper()
+	for _, client := range clients {
+		if err := hub.RegisterAndWait(context.Background(), client); err != nil {
+			t.Fatalf("failed to register %s: %v", client.userID, err)
+		}
+	}
+}
+
+func unregisterAndWait(t *testing.T, hub *Hub, client *Client) {
+	t.Helper()
+	if err := hub.UnregisterAndWait(context.Background(), client); err != nil {
+		t.Fatalf("failed to unregister %s: %v", client.userID, err)
+	}
 }
 
 func drainClientMessages(client *Client) {
@@ -109,17 +141,12 @@ func TestHub(t *testing.T) {
 	hub := newHub()
 	go hub.run()
 
-	client1 := &Client{hub: hub, teamID: "team-a", userID: "user-1", send: make(chan []byte, 8)}
-	client2 := &Client{hub: hub, teamID: "team-a", userID: "user-2", send: make(chan []byte, 8)}
-	client3 := &Client{hub: hub, teamID: "team-b", userID: "user-3", send: make(chan []byte, 8)}
+	client1 := &Client{hub: hub, teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 8)}
+	client2 := &Client{hub: hub, teamID: "team-a", userID: "user-2", send: make(chan outboundMessage, 8)}
+	client3 := &Client{hub: hub, teamID: "team-b", userID: "user-3", send: make(chan outboundMessage, 8)}
 
 	// Test Registration
-	hub.register <- client1
-	hub.register <- client2
-	hub.register <- client3
-
-	// Allow time for hub to process registrations
-	time.Sleep(100 * time.Millisecond)
+	registerAndWait(t, hub, client1, client2, client3)
 
 	hub.mu.RLock()
 	if len(hub.clients) != 2 {
@@ -134,10 +161,7 @@ func TestHub(t *testing.T) {
 	hub.mu.RUnlock()
 
 	// Test Unregistration
-	hub.unregister <- client2
-
-	// Allow time for hub to process unregistration
-	time.Sleep(100 * time.Millisecond)
+	unregisterAndWait(t, hub, client2)
 
 	hub.mu.RLock()
 	if len(hub.clients["team-a"]) != 1 {
@@ -149,9 +173,7 @@ func TestHub(t *testing.T) {
 	hub.mu.RUnlock()
 
 	// Test team cleanup after last client leaves
-	hub.unregister <- client1
-
-	time.Sleep(100 * time.Millisecond)
+	unregisterAndWait(t, hub, client1)
 
 	hub.mu.RLock()
 	if _, ok := hub.clients["team-a"]; ok {
@@ -163,17 +185,15 @@ func TestHub(t *testing.T) {
 // TestHub_ClientLimits tests the client limit enforcement.
 func TestHub_ClientLimits(t *testing.T) {
 	setupTestAppConfig()
-	AppConfig.Limits.MaxClientsPerTeam = 2
+	AppConfig().Limits.MaxClientsPerTeam = 2
 	hub := newHub()
 	go hub.run()
 
 	// Add 2 clients, which is the limit
 	for i := 0; i < 2; i++ {
-		hub.register <- &Client{hub: hub, teamID: "team-limited", userID: fmt.Sprintf("user-%d", i), send: make(chan []byte, 8)}
+		registerAndWait(t, hub, &Client{hub: hub, teamID: "team-limited", userID: fmt.Sprintf("user-%d", i), send: make(chan outboundMessage, 8)})
 	}
 
-	time.Sleep(100 * time.Millisecond)
-
 	if hub.canAddClient("team-limited") {
 		t.Error("canAddClient should return false when team is at capacity")
 	}
@@ -197,21 +217,18 @@ func TestHub_Messaging(t *testing.T) {
 	conn1 := newMockConn()
 	conn2 := newMockConn()
 	conn3 := newMockConn()
-	client1 := &Client{hub: hub, conn: conn1, teamID: "team-a", userID: "user-1", send: make(chan []byte, 8)}
-	client2 := &Client{hub: hub, conn: conn2, teamID: "team-a", userID: "user-2", send: make(chan []byte, 8)}
-	client3 := &Client{hub: hub, conn: conn3, teamID: "team-b", userID: "user-1", send: make(chan []byte, 8)}
+	client1 := &Client{hub: hub, conn: conn1, teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 8)}
+	client2 := &Client{hub: hub, conn: conn2, teamID: "team-a", userID: "user-2", send: make(chan outboundMessage, 8)}
+	client3 := &Client{hub: hub, conn: conn3, teamID: "team-b", userID: "user-1", send: make(chan outboundMessage, 8)}
 
-	hub.register <- client1
-	hub.register <- client2
-	hub.register <- client3
-	time.Sleep(100 * time.Millisecond)
+	registerAndWait(t, hub, client1, client2, client3)
 
 	drainClientMessages(client1)
 	drainClientMessages(client2)
 	drainClientMessages(client3)
 
 	t.Run("SendToUser", func(t *testing.T) {
-		message := []byte("private message")
+		message := outboundMessage{payload: []byte("private message")}
 		delivered := hub.sendToUser("team-a", "user-1", message)
 		if delivered != 1 {
 			t.Fatalf("sendToUser should have delivered to 1 connected client, got %d", delivered)
@@ -220,8 +237,8 @@ func TestHub_Messaging(t *testing.T) {
 		// Check if message was received by the correct client
 		select {
 		case received := <-client1.send:
-			if string(received) != string(message) {
-				t.Errorf("Expected client1 to receive '%s', got '%s'", message, received)
+			if string(received.payload) != string(message.payload) {
+				t.Errorf("Expected client1 to receive '%s', got '%s'", message.payload, received.payload)
 			}
 		case <-time.After(1 * time.Second):
 			t.Fatal("Timed out waiting for message")
@@ -234,7 +251,7 @@ func TestHub_Messaging(t *testing.T) {
 	})
 
 	t.Run("SendToUserAcrossTeams", func(t *testing.T) {
-		message := []byte("cross-team direct")
+		message := outboundMessage{payload: []byte("cross-team direct")}
 		delivered := hub.sendToUser("", "user-1", message)
 		if delivered != 2 {
 			t.Fatalf("expected cross-team direct send to deliver to 2 sessions, got %d", delivered)
@@ -243,8 +260,8 @@ func TestHub_Messaging(t *testing.T) {
 		for i, c := range []*Client{client1, client3} {
 			select {
 			case received := <-c.send:
-				if string(received) != string(message) {
-					t.Errorf("Expected client %d to receive '%s', got '%s'", i+1, message, received)
+				if string(received.payload) != string(message.payload) {
+					t.Errorf("Expected client %d to receive '%s', got '%s'", i+1, message.payload, received.payload)
 				}
 			case <-time.After(time.Second):
 				t.Fatalf("Timed out waiting for cross-team direct message for client %d", i+1)
@@ -257,7 +274,7 @@ func TestHub_Messaging(t *testing.T) {
 	})
 
 	t.Run("BroadcastToTeam", func(t *testing.T) {
-		message := []byte("team broadcast")
+		message := outboundMessage{payload: []byte("team broadcast")}
 		count := hub.broadcastToTeam("team-a", message)
 		if count != 2 {
 			t.Errorf("Expected broadcast to deliver to 2 clients, got %d", count)
@@ -267,8 +284,8 @@ func TestHub_Messaging(t *testing.T) {
 		for i, c := range []*Client{client1, client2} {
 			select {
 			case received := <-c.send:
-				if string(received) != string(message) {
-					t.Errorf("Expected client %d to receive '%s', got '%s'", i+1, message, received)
+				if string(received.payload) != string(message.payload) {
+					t.Errorf("Expected client %d to receive '%s', got '%s'", i+1, message.payload, received.payload)
 				}
 			case <-time.After(1 * time.Second):
 				t.Fatalf("Timed out waiting for broadcast message for client %d", i+1)
@@ -282,7 +299,7 @@ func TestHub_Messaging(t *testing.T) {
 	})
 
 	t.Run("BroadcastToAllTeams", func(t *testing.T) {
-		message := []byte("global broadcast")
+		message := outboundMessage{payload: []byte("global broadcast")}
 		count := hub.broadcastToAllTeams(message)
 		if count != 3 {
 			t.Errorf("Expected global broadcast to deliver to 3 clients, got %d", count)
@@ -292,8 +309,8 @@ func TestHub_Messaging(t *testing.T) {
 		for i, c := range []*Client{client1, client2, client3} {
 			select {
 			case received := <-c.send:
-				if string(received) != string(message) {
-					t.Errorf("Expected client %d to receive '%s', got '%s'", i+1, message, received)
+				if string(received.payload) != string(message.payload) {
+					t.Errorf("Expected client %d to receive '%s', got '%s'", i+1, message.payload, received.payload)
 				}
 			case <-time.After(1 * time.Second):
 				t.Fatalf("Timed out waiting for global broadcast message for client %d", i+1)
@@ -307,12 +324,10 @@ func TestHub_AllowsMultipleSessionsPerUser(t *testing.T) {
 	hub := newHub()
 	go hub.run()
 
-	client1 := &Client{hub: hub, teamID: "team-a", userID: "user-1", send: make(chan []byte, 8)}
-	client2 := &Client{hub: hub, teamID: "team-a", userID: "user-1", send: make(chan []byte, 8)}
+	client1 := &Client{hub: hub, teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 8)}
+	client2 := &Client{hub: hub, teamID: "team-a", userID: "user-1", send: make(chan outboundMessage, 8)}
 
-	hub.register <- client1
-	hub.register <- client2
-	time.Sleep(100 * time.Millisecond)
+	registerAndWait(t, hub, client1, client2)
 
 	if totalClients := hub.getTotalClientCount(); totalClients != 2 {
 		t.Fatalf("expected 2 total client sessions, got %d", totalClients)
@@ -329,7 +344,7 @@ func TestHub_AllowsMultipleSessionsPerUser(t *testing.T) {
 	}
 	hub.mu.RUnlock()
 
-	message := []byte("fanout to all sessions")
+	message := outboundMessage{payload: []byte("fanout to all sessions")}
 	delivered := hub.sendToUser("team-a", "user-1", message)
 	if delivered != 2 {
 		t.Fatalf("expected direct send to reach 2 sessions, got %d", delivered)
@@ -338,8 +353,8 @@ func TestHub_AllowsMultipleSessionsPerUser(t *testing.T) {
 	for i, client := range []*Client{client1, client2} {
 		select {
 		case received := <-client.send:
-			if string(received) != string(message) {
-				t.Fatalf("expected client %d to receive %q, got %q", i+1, message, received)
+			if string(received.payload) != string(message.payload) {
+				t.Fatalf("expected client %d to receive %q, got %q", i+1, message.payload, received.payload)
 			}
 		case <-time.After(time.Second):
 			t.Fatalf("timed out waiting for message on client %d", i+1)
@@ -358,13 +373,13 @@ func TestClientReadPump_ClosesOnClientMessages(t *testing.T) {
 		conn:   senderConn,
 		teamID: "team-a",
 		userID: "sender",
-		send:   make(chan []byte, 1),
+		send:   make(chan outboundMessage, 1),
 	}
 
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		sender.readPump()
+		sender.readPump(context.Background())
 	}()
 
 	senderConn.read <- []byte(`{"type":"userMessage","content":"not supported"}`)
@@ -404,7 +419,7 @@ func TestClient_Authentication(t *testing.T) {
 
 	// 2. Setup AppConfig to use the mock server
 	setupTestAppConfig()
-	AppConfig.Backend.URL = mockServer.URL
+	AppConfig().Backend.URL = mockServer.URL
 	httpClient = mockServer.Client() // Use the test server's client
 
 	// 3. Define test cases
@@ -487,8 +502,8 @@ func TestClient_Authentication(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			AppConfig.Environment.Mode = tc.mode
-			AppConfig.Environment.EnableFakeAuth = tc.fakeAuth
+			AppConfig().Environment.Mode = tc.mode
+			AppConfig().Environment.EnableFakeAuth = tc.fakeAuth
 
 			client := &Client{} // A minimal client is enough
 			err := client.authenticate(tc.authMsg)
@@ -553,11 +568,36 @@ func TestParseVerifiedUser_ExtractsSelectedTeam(t *testing.T) {
 	}
 }
 
+func TestParseVerifiedUser_TeamAdmin(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		expected bool
+	}{
+		{name: "nested settings", body: `{"id":1,"settings":{"selectedTeam":"team-a","isTeamAdmin":true}}`, expected: true},
+		{name: "top level", body: `{"id":2,"selectedTeam":"team-a","isTeamAdmin":true}`, expected: true},
+		{name: "absent", body: `{"id":3,"selectedTeam":"team-a"}`, expected: false},
+		{name: "not a bool", body: `{"id":4,"selectedTeam":"team-a","isTeamAdmin":"yes"}`, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user, err := parseVerifiedUser([]byte(tc.body))
+			if err != nil {
+				t.Fatalf("parseVerifiedUser returned error: %v", err)
+			}
+			if user.TeamAdmin != tc.expected {
+				t.Fatalf("expected TeamAdmin %v, got %v", tc.expected, user.TeamAdmin)
+			}
+		})
+	}
+}
+
 // TestCircuitBreaker verifies the circuit breaker logic.
 func TestCircuitBreaker(t *testing.T) {
 	setupTestAppConfig()
-	AppConfig.CircuitBreaker.Threshold = 2
-	AppConfig.CircuitBreaker.Timeout = 100 * time.Millisecond
+	AppConfig().CircuitBreaker.Threshold = 2
+	AppConfig().CircuitBreaker.Timeout = 100 * time.Millisecond
 
 	cb := &CircuitBreaker{}
 	failingCall := func() error { return markCircuitBreakerFailure(errors.New("backend failure")) }
@@ -624,9 +664,9 @@ func TestClientAuthentication_InvalidTokensDoNotOpenCircuitBreaker(t *testing.T)
 	defer mockServer.Close()
 
 	setupTestAppConfig()
-	AppConfig.Backend.URL = mockServer.URL
-	AppConfig.CircuitBreaker.Threshold = 2
-	AppConfig.CircuitBreaker.Timeout = time.Minute
+	AppConfig().Backend.URL = mockServer.URL
+	AppConfig().CircuitBreaker.Threshold = 2
+	AppConfig().CircuitBreaker.Timeout = time.Minute
 	httpClient = mockServer.Client()
 
 	for i := 0; i < 3; i++ {
@@ -642,3 +682,85 @@ func TestClientAuthentication_InvalidTokensDoNotOpenCircuitBreaker(t *testing.T)
 		t.Fatalf("expected valid token to succeed after invalid attempts, got %v", err)
 	}
 }
+
+func TestHub_BatchesRegistrations(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	hub.batchWindow = time.Second
+	hub.batchSize = 4
+
+	client := func(team, user string) *Client {
+		return &Client{hub: hub, teamID: team, userID: user, send: make(chan outboundMessage, 1)}
+	}
+	alice, bob, carol := client("team-a", "alice"), client("team-a", "bob"), client("team-b", "carol")
+
+	// A burst: two requests are already taken, and more arrive within the
+	// window until the batch is full.
+	batch := []hubOperation{
+		{hubRequest: hubRequest{client: alice, done: make(chan struct{})}, register: true},
+		{hubRequest: hubRequest{client: bob, done: make(chan struct{})}, register: true},
+	}
+	go func() {
+		hub.register <- hubRequest{client: carol}
+		hub.unregister <- hubRequest{client: alice}
+	}()
+	batch = hub.collectBatch(batch)
+	if len(batch) != 4 || !batch[2].register || batch[3].register {
+		t.Fatalf("expected the register and unregister that followed, got %+v", batch)
+	}
+
+	hub.applyBatch(batch)
+	for _, op := range batch[:2] {
+		select {
+		case <-op.done:
+		default:
+			t.Fatal("expected every request to be acknowledged")
+		}
+	}
+	if users := hub.clients["team-a"]; len(users) != 1 || users["bob"] == nil {
+		t.Errorf("expected only bob left in team-a, got %v", users)
+	}
+	if _, open := <-alice.send; open {
+		t.Error("expected alice's send queue to be closed")
+	}
+	if hub.getTotalClientCount() != 2 {
+		t.Errorf("expected bob and carol registered, got %d", hub.getTotalClientCount())
+	}
+
+	// A lone request does not wait for the window.
+	started := time.Now()
+	if single := hub.collectBatch([]hubOperation{{hubRequest: hubRequest{client: bob}}}); len(single) != 1 || time.Since(started) > 100*time.Millisecond {
+		t.Errorf("expected a lone request to be applied at once, got %d after %s", len(single), time.Since(started))
+	}
+}
+
+func TestHub_MultipleDevicesPerUser(t *testing.T) {
+	setupTestAppConfig()
+	hub := newHub()
+	go hub.run()
+	events := liveEvents.subscribe()
+	defer liveEvents.unsubscribe(events)
+
+	phone := &Client{hub: hub, teamID: "team-1", userID: "alice", connID: "phone", send: make(chan outboundMessage, 4)}
+	laptop := &Client{hub: hub, teamID: "team-1", userID: "alice", connID: "laptop", send: make(chan outboundMessage, 4)}
+	registerAndWait(t, hub, phone, laptop)
+
+	if sent := hub.sendToUser("team-1", "alice", queuedNotification("n1", "hi")); sent != 2 {
+		t.Fatalf("expected both devices to get the notification, got %d", sent)
+	}
+	hub.removeClient(phone)
+	if sent := hub.sendToUser("team-1", "alice", queuedNotification("n2", "hi")); sent != 1 || len(laptop.send) != 2 {
+		t.Fatalf("expected the laptop to stay connected, got %d recipients", sent)
+	}
+	hub.removeClient(laptop)
+
+	var got []string
+	for len(events) > 0 {
+		event := <-events
+		got = append(got, event.Type+":"+event.ConnID)
+	}
+	want := []string{"connect:phone", "userJoined:", "connect:laptop", "disconnect:phone", "disconnect:laptop", "userLeft:"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected events %v, got %v", want, got)
+	}
+}